@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/joho/godotenv"
+
+	"github.com/romanitalian/carch-go/config"
+)
+
+const configUsage = `Usage: cli config <command>
+
+Commands:
+  init      Write a configuration holding every setting, see cli config init -h
+  check     Validate the configuration and print the effective settings with their source
+`
+
+func configCmd(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "init":
+		return configInit(args[1:])
+	case "check":
+		return configCheck(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown config command %q\n\n%s", args[0], configUsage)
+		return exitUsage
+	}
+}
+
+func configInit(args []string) int {
+	fs := flag.NewFlagSet("config init", flag.ContinueOnError)
+	profile := fs.String("profile", config.SampleDev, "profile of the values: dev, docker or prod")
+	format := fs.String("format", "env", "file format: env or yaml")
+	out := fs.String("out", "", "file to write, refused if it exists; standard output when omitted")
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	if *out == "" {
+		return writeSample(os.Stdout, *profile, *format)
+	}
+
+	// Never overwrite a configuration that may hold real secrets
+	f, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	code := writeSample(f, *profile, *format)
+	if err := f.Close(); err != nil && code == exitOK {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	return code
+}
+
+func writeSample(w io.Writer, profile, format string) int {
+	if err := config.WriteSample(w, profile, format); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	return exitOK
+}
+
+func configCheck(args []string) int {
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	// Taken before Load, which adds the .env file to the environment
+	environ := os.Environ()
+	dotenv, err := godotenv.Read()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		fmt.Fprintf(os.Stderr, "failed to read .env: %v\n", err)
+		return exitFailed
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config: %v\n", err)
+		return exitFailed
+	}
+	return writeEffectiveConfig(os.Stdout, cfg, environ, dotenv)
+}
+
+// writeEffectiveConfig writes every setting of the loaded config, redacted,
+// with the layer its value came from
+func writeEffectiveConfig(w io.Writer, cfg *config.Config, environ []string, dotenv map[string]string) int {
+	sources, err := cfg.Sources(environ, dotenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	values := cfg.Redacted()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, s := range config.Settings() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.EnvVar, values[s.EnvVar], sources[s.EnvVar])
+	}
+	if err := tw.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/config"
+)
+
+func TestWriteSample(t *testing.T) {
+	// Arrange
+	var out bytes.Buffer
+
+	// Act
+	code := writeSample(&out, config.SampleDocker, "env")
+
+	// Assert
+	require.Equal(t, exitOK, code)
+	assert.Contains(t, out.String(), "\nDB_HOST=postgres\n")
+	assert.Equal(t, exitUsage, writeSample(&bytes.Buffer{}, "staging", "env"))
+}
+
+func TestWriteEffectiveConfig(t *testing.T) {
+	// Arrange
+	t.Setenv("HTTP_PORT", "9000")
+	t.Setenv("DB_PASSWORD", "s3cret-password")
+	environ := []string{"HTTP_PORT=9000", "DB_PASSWORD=s3cret-password"}
+	cfg, err := config.Load()
+	require.NoError(t, err)
+
+	// Act
+	var out bytes.Buffer
+	code := writeEffectiveConfig(&out, cfg, environ, nil)
+
+	// Assert
+	require.Equal(t, exitOK, code)
+	lines := strings.Split(out.String(), "\n")
+	assert.Equal(t, []string{"SETTING", "VALUE", "SOURCE"}, strings.Fields(lines[0]))
+	assert.Equal(t, []string{"HTTP_PORT", "9000", "env"}, strings.Fields(findLine(lines, "HTTP_PORT")))
+	assert.Equal(t, []string{"DB_PASSWORD", "s3******", "env"}, strings.Fields(findLine(lines, "DB_PASSWORD")))
+	assert.Equal(t, []string{"GRPC_PORT", "9090", "default"}, strings.Fields(findLine(lines, "GRPC_PORT")))
+	assert.NotContains(t, out.String(), "s3cret-password")
+}
+
+// findLine returns the line of a setting
+func findLine(lines []string, name string) string {
+	for _, line := range lines {
+		if strings.HasPrefix(line, name+" ") {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/app"
+	"github.com/romanitalian/carch-go/internal/pkg/health"
+	"github.com/romanitalian/carch-go/migrations"
+)
+
+// Exit codes of the cli commands
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitUsage  = 2
+)
+
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+)
+
+func doctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	format := fs.String("format", "text", "report format: text or json")
+	noColor := fs.Bool("no-color", false, "disable colored output")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of each check")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return exitFailed
+	}
+
+	// Open without connecting so that an unreachable database is reported as a check
+	db, err := sql.Open("postgres", app.PostgresConfig(cfg, nil).DSN())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %v\n", err)
+		return exitFailed
+	}
+	defer db.Close()
+
+	expected, err := migrations.LatestVersion()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read embedded migrations: %v\n", err)
+		return exitFailed
+	}
+
+	checkers := []health.Checker{
+		health.NewDBChecker(db),
+		health.NewMigrationChecker(db, expected),
+		health.NewRabbitMQChecker(cfg.RabbitMQ.BuildURL(), cfg.Worker.QueueName),
+	}
+	checkers = append(checkers, tlsCheckers(cfg)...)
+
+	return runDoctor(context.Background(), os.Stdout, *format, !*noColor, *timeout, checkers...)
+}
+
+// tlsCheckers checks the configured certificates: the CA bundle and client
+// certificate of the database, and the certificates of the listeners
+func tlsCheckers(cfg *config.Config) []health.Checker {
+	var checkers []health.Checker
+	if cfg.DB.SSLRootCert != "" || cfg.DB.SSLCert != "" || cfg.DB.SSLKey != "" {
+		checkers = append(checkers, health.NewTLSChecker("db_tls", cfg.DB.SSLCert, cfg.DB.SSLKey, cfg.DB.SSLRootCert))
+	}
+	if cfg.HTTP.TLSCertFile != "" || cfg.HTTP.TLSKeyFile != "" {
+		checkers = append(checkers, health.NewTLSChecker("http_tls", cfg.HTTP.TLSCertFile, cfg.HTTP.TLSKeyFile, ""))
+	}
+	if cfg.GRPC.TLSCertFile != "" || cfg.GRPC.TLSKeyFile != "" {
+		checkers = append(checkers, health.NewTLSChecker("grpc_tls", cfg.GRPC.TLSCertFile, cfg.GRPC.TLSKeyFile, ""))
+	}
+	return checkers
+}
+
+// runDoctor runs the checks, writes the report and returns the process exit code
+func runDoctor(ctx context.Context, out io.Writer, format string, color bool, timeout time.Duration, checkers ...health.Checker) int {
+	report := health.Run(ctx, timeout, checkers...)
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(struct {
+			OK bool `json:"ok"`
+			health.Report
+		}{!report.Failed(), report}); err != nil {
+			return exitFailed
+		}
+	case "text":
+		writeTextReport(out, report, color)
+	default:
+		fmt.Fprintf(out, "unknown format %q\n", format)
+		return exitUsage
+	}
+
+	if report.Failed() {
+		return exitFailed
+	}
+	return exitOK
+}
+
+func writeTextReport(out io.Writer, report health.Report, color bool) {
+	for _, c := range report.Checks {
+		label := fmt.Sprintf("[%s]", statusLabel(c.Status))
+		if color {
+			label = statusColor(c.Status) + label + colorReset
+		}
+		fmt.Fprintf(out, "%s %-12s %s (%s)\n", label, c.Name, c.Message, c.Duration.Round(time.Millisecond))
+	}
+
+	if report.Failed() {
+		fmt.Fprintln(out, "\nSome checks failed")
+	} else {
+		fmt.Fprintln(out, "\nAll checks passed")
+	}
+}
+
+func statusLabel(s health.Status) string {
+	switch s {
+	case health.StatusPass:
+		return "PASS"
+	case health.StatusWarn:
+		return "WARN"
+	default:
+		return "FAIL"
+	}
+}
+
+func statusColor(s health.Status) string {
+	switch s {
+	case health.StatusPass:
+		return colorGreen
+	case health.StatusWarn:
+		return colorYellow
+	default:
+		return colorRed
+	}
+}
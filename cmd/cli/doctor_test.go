@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/pkg/health"
+)
+
+type fakeChecker struct {
+	name   string
+	result health.Result
+}
+
+func (f fakeChecker) Name() string                            { return f.name }
+func (f fakeChecker) Check(ctx context.Context) health.Result { return f.result }
+
+func TestRunDoctor_AllPass(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectPing()
+	mock.ExpectQuery("SELECT 1").WillReturnRows(sqlmock.NewRows([]string{"?column?"}).AddRow(1))
+	mock.ExpectQuery("SELECT version, dirty FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(4, false))
+
+	var out bytes.Buffer
+
+	// Act
+	code := runDoctor(context.Background(), &out, "text", false, time.Second,
+		health.NewDBChecker(db),
+		health.NewMigrationChecker(db, 4),
+		fakeChecker{"rabbitmq", health.Pass("connected")},
+	)
+
+	// Assert
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out.String(), "[PASS] postgres")
+	assert.Contains(t, out.String(), "[PASS] migrations")
+	assert.Contains(t, out.String(), "All checks passed")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunDoctor_FailureJSON(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT version, dirty FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, false))
+
+	var out bytes.Buffer
+
+	// Act
+	code := runDoctor(context.Background(), &out, "json", false, time.Second,
+		health.NewMigrationChecker(db, 4),
+		fakeChecker{"tls", health.Warn("certificate expires soon")},
+	)
+
+	// Assert
+	assert.Equal(t, exitFailed, code)
+
+	var report struct {
+		OK     bool                 `json:"ok"`
+		Checks []health.CheckReport `json:"checks"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.False(t, report.OK)
+	require.Len(t, report.Checks, 2)
+	assert.Equal(t, health.StatusFail, report.Checks[0].Status)
+	assert.Contains(t, report.Checks[0].Message, "behind expected 4")
+	assert.Equal(t, health.StatusWarn, report.Checks[1].Status)
+}
+
+func TestRunDoctor_WarningsDoNotFail(t *testing.T) {
+	// Arrange
+	var out bytes.Buffer
+
+	// Act
+	code := runDoctor(context.Background(), &out, "text", true, time.Second,
+		fakeChecker{"tls", health.Warn("certificate expires soon")},
+	)
+
+	// Assert
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out.String(), colorYellow+"[WARN]"+colorReset)
+}
+
+func TestRunDoctor_UnknownFormat(t *testing.T) {
+	// Act
+	code := runDoctor(context.Background(), &bytes.Buffer{}, "yaml", false, time.Second)
+
+	// Assert
+	assert.Equal(t, exitUsage, code)
+}
+
+func TestTLSCheckers(t *testing.T) {
+	// Arrange
+	var cfg config.Config
+	cfg.DB.SSLRootCert = "missing-ca.pem"
+	cfg.GRPC.TLSCertFile, cfg.GRPC.TLSKeyFile = "missing-cert.pem", "missing-key.pem"
+
+	// Act
+	checkers := tlsCheckers(&cfg)
+
+	// Assert: only the configured certificates are checked, and read
+	names := make([]string, len(checkers))
+	for i, c := range checkers {
+		names[i] = c.Name()
+		assert.Equal(t, health.StatusFail, c.Check(context.Background()).Status, c.Name())
+	}
+	assert.Equal(t, []string{"db_tls", "grpc_tls"}, names)
+}
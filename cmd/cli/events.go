@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/app"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/repository"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+const eventsUsage = `Usage: cli events <command>
+
+Commands:
+  replay    Republish archived events to a queue in sequence order
+`
+
+func events(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, eventsUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "replay":
+		return eventsReplay(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown events command %q\n\n%s", args[0], eventsUsage)
+		return exitUsage
+	}
+}
+
+// parseReplayFlags parses the flags of events replay
+func parseReplayFlags(args []string, errOut io.Writer) (service.ReplayRequest, error) {
+	var req service.ReplayRequest
+	fs := flag.NewFlagSet("events replay", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.StringVar(&req.Type, "type", "", "replay only events of the type, all types when empty")
+	fs.Int64Var(&req.FromSeq, "from-seq", 1, "first sequence number to replay")
+	fs.Int64Var(&req.ToSeq, "to-seq", 0, "last sequence number to replay, 0 replays up to the latest event")
+	fs.StringVar(&req.Queue, "to-queue", "", "queue the events are published to")
+	fs.Float64Var(&req.Rate, "rate", 100, "events published per second, 0 is unlimited")
+	if err := fs.Parse(args); err != nil {
+		return req, err
+	}
+
+	switch {
+	case req.Queue == "":
+		return req, errors.New("--to-queue is required")
+	case req.Rate < 0:
+		return req, errors.New("--rate must not be negative")
+	}
+	return req, nil
+}
+
+func eventsReplay(args []string) int {
+	req, err := parseReplayFlags(args, os.Stderr)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return exitUsage
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return exitFailed
+	}
+	log := logger.New(logger.WithOutput(os.Stderr))
+
+	db, err := app.ConnectPostgres(cfg, log)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to connect to database: %v\n", err)
+		return exitFailed
+	}
+	defer db.Close()
+
+	mq, err := app.ConnectRabbitMQ(cfg, log)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to connect to broker: %v\n", err)
+		return exitFailed
+	}
+	defer mq.Close()
+
+	// The broker silently drops messages routed to a missing queue
+	if _, err := mq.InspectQueue(req.Queue); err != nil {
+		fmt.Fprintf(os.Stderr, "queue %q is not available: %v\n", req.Queue, err)
+		return exitFailed
+	}
+
+	// An interrupted replay still reports where to resume from
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	svc := service.NewEventService(repository.NewEventArchiveRepository(db.DB), log, cfg.Events.ArchiveRetention)
+	return runReplay(ctx, os.Stdout, os.Stderr, svc, mq, req)
+}
+
+// runReplay replays the events, writes the JSON result and returns the
+// process exit code
+func runReplay(ctx context.Context, out, errOut io.Writer, svc *service.EventService, publisher service.QueuePublisher, req service.ReplayRequest) int {
+	result, err := svc.Replay(ctx, publisher, req)
+	if err != nil {
+		fmt.Fprintf(errOut, "replay failed: %v\n", err)
+	}
+	if result != nil {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		if encErr := enc.Encode(result); encErr != nil {
+			return exitFailed
+		}
+	}
+
+	if err != nil {
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+func TestParseReplayFlags(t *testing.T) {
+	// Act
+	req, err := parseReplayFlags([]string{"--type", "user.created", "--from-seq", "42", "--to-queue", "rebuild.queue", "--rate", "20"}, io.Discard)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, service.ReplayRequest{Type: "user.created", FromSeq: 42, Queue: "rebuild.queue", Rate: 20}, req)
+}
+
+func TestParseReplayFlags_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "no queue", args: []string{"--type", "user.created"}, wantErr: "--to-queue is required"},
+		{name: "negative rate", args: []string{"--to-queue", "q", "--rate", "-1"}, wantErr: "--rate must not be negative"},
+		{name: "unknown flag", args: []string{"--queue", "q"}, wantErr: "flag provided but not defined"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseReplayFlags(tt.args, io.Discard)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: cli <command> [flags]
+
+Commands:
+  doctor    Validate configuration and dependencies before switching traffic
+  verify    Check the data layer for inconsistencies, --repair applies safe fixes
+  mq        Inspect the RabbitMQ topology, see cli mq
+  events    Replay archived events, see cli events
+  scaffold  Generate the code of a new entity, see cli scaffold
+  openapi   Print the OpenAPI document of the HTTP API
+  migrate   Roll the schema back for an older binary, see cli migrate
+  queries   Report the usage of the registered database queries, see cli queries
+  config    Write sample configurations and check the effective one, see cli config
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "doctor":
+		os.Exit(doctor(os.Args[2:]))
+	case "verify":
+		os.Exit(verify(os.Args[2:]))
+	case "mq":
+		os.Exit(mq(os.Args[2:]))
+	case "events":
+		os.Exit(events(os.Args[2:]))
+	case "scaffold":
+		os.Exit(scaffold(os.Args[2:]))
+	case "openapi":
+		os.Exit(openAPI(os.Args[2:]))
+	case "migrate":
+		os.Exit(migrateCmd(os.Args[2:]))
+	case "queries":
+		os.Exit(queries(os.Args[2:]))
+	case "config":
+		os.Exit(configCmd(os.Args[2:]))
+	case "-h", "--help", "help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+}
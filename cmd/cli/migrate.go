@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/app"
+	"github.com/romanitalian/carch-go/internal/pkg/database"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+const migrateUsage = `Usage: cli migrate <command>
+
+Commands:
+  down      Migrate the schema down to the latest version an older binary
+            works with, e.g. --to-compatible 16 before rolling back to the
+            release shipping migrations up to 16
+`
+
+func migrateCmd(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, migrateUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "down":
+		return migrateDown(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown migrate command %q\n\n%s", args[0], migrateUsage)
+		return exitUsage
+	}
+}
+
+// migrateDownFlags are the flags of migrate down
+type migrateDownFlags struct {
+	// binary is the latest migration shipped by the binary rolled back to
+	binary uint
+	dryRun bool
+}
+
+// parseMigrateDownFlags parses the flags of migrate down
+func parseMigrateDownFlags(args []string, errOut io.Writer) (migrateDownFlags, error) {
+	var f migrateDownFlags
+	fs := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+	fs.SetOutput(errOut)
+	fs.UintVar(&f.binary, "to-compatible", 0, "latest migration shipped by the binary to roll back to")
+	fs.BoolVar(&f.dryRun, "dry-run", false, "print the target version without migrating")
+	if err := fs.Parse(args); err != nil {
+		return f, err
+	}
+
+	set := false
+	fs.Visit(func(fl *flag.Flag) { set = set || fl.Name == "to-compatible" })
+	if !set {
+		return f, errors.New("--to-compatible is required")
+	}
+	return f, nil
+}
+
+func migrateDown(args []string) int {
+	f, err := parseMigrateDownFlags(args, os.Stderr)
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return exitUsage
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return exitFailed
+	}
+	log := logger.New(logger.WithOutput(os.Stderr))
+	db, err := app.ConnectPostgres(cfg, log)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to connect to database: %v\n", err)
+		return exitFailed
+	}
+	defer db.Close()
+
+	manager := database.NewMigrationManager(db.SQLDb, log, database.WithMigrationTimeout(cfg.DB.MigrationTimeout))
+	target, err := manager.RollBackTo(cfg.DB.MigrationsPath, f.binary, f.dryRun)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "migrate down failed: %v\n", err)
+		return exitFailed
+	}
+
+	fmt.Printf("schema version %d works with binary %d\n", target, f.binary)
+	if f.dryRun {
+		fmt.Println("dry run, the schema was not migrated")
+	}
+	return exitOK
+}
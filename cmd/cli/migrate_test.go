@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMigrateDownFlags(t *testing.T) {
+	// Act
+	f, err := parseMigrateDownFlags([]string{"--to-compatible", "16", "--dry-run"}, io.Discard)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, migrateDownFlags{binary: 16, dryRun: true}, f)
+}
+
+func TestParseMigrateDownFlags_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		wantErr string
+	}{
+		{name: "no binary", args: []string{"--dry-run"}, wantErr: "--to-compatible is required"},
+		{name: "not a version", args: []string{"--to-compatible", "v16"}, wantErr: "invalid value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseMigrateDownFlags(tt.args, io.Discard)
+
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
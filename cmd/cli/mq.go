@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/app"
+	"github.com/romanitalian/carch-go/internal/repository"
+)
+
+const mqUsage = `Usage: cli mq <command>
+
+Commands:
+  reconcile    Report drift between the queue registry and the broker
+`
+
+func mq(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, mqUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "reconcile":
+		return mqReconcile(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown mq command %q\n\n%s", args[0], mqUsage)
+		return exitUsage
+	}
+}
+
+func mqReconcile(args []string) int {
+	fs := flag.NewFlagSet("mq reconcile", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return exitFailed
+	}
+
+	report, err := repository.InspectTopology(cfg.RabbitMQ.Driver, cfg.RabbitMQ.BuildURL(), cfg.Worker.QueueName, app.TenantRouting(cfg))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "reconcile failed: %v\n", err)
+		return exitFailed
+	}
+
+	return writeTopologyReport(os.Stdout, report)
+}
+
+// writeTopologyReport writes the JSON report and returns the process exit
+// code, which is non-zero while a queue differs from the registry
+func writeTopologyReport(out io.Writer, report *repository.TopologyReport) int {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(struct {
+		OK bool `json:"ok"`
+		*repository.TopologyReport
+	}{report.Clean(), report}); err != nil {
+		return exitFailed
+	}
+
+	if !report.Clean() {
+		return exitFailed
+	}
+	return exitOK
+}
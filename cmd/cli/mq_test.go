@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/repository"
+)
+
+func TestWriteTopologyReport(t *testing.T) {
+	// Arrange
+	report := &repository.TopologyReport{Queues: []repository.QueueDrift{{
+		Queue:    "tasks",
+		Status:   repository.QueueDrifted,
+		Detail:   "precondition failed: inequivalent arg 'x-dead-letter-exchange'",
+		Messages: 12,
+	}}}
+	var out bytes.Buffer
+
+	// Act
+	code := writeTopologyReport(&out, report)
+
+	// Assert
+	assert.Equal(t, exitFailed, code)
+
+	var got struct {
+		OK     bool                    `json:"ok"`
+		Queues []repository.QueueDrift `json:"queues"`
+	}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.False(t, got.OK)
+	assert.Equal(t, report.Queues, got.Queues)
+}
+
+func TestWriteTopologyReport_Clean(t *testing.T) {
+	// Arrange
+	report := &repository.TopologyReport{Queues: []repository.QueueDrift{{Queue: "tasks", Status: repository.QueueInSync}}}
+	var out bytes.Buffer
+
+	// Act & Assert
+	assert.Equal(t, exitOK, writeTopologyReport(&out, report))
+	assert.Contains(t, out.String(), `"ok": true`)
+}
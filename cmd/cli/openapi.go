@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+	httpTransport "github.com/romanitalian/carch-go/internal/transport/http"
+)
+
+const openapiUsage = `Usage: cli openapi > spec.json
+
+Prints the OpenAPI document of the public HTTP API, generated from the
+registered routes. It needs neither configuration nor dependencies.
+`
+
+func openAPI(args []string) int {
+	fs := flag.NewFlagSet("openapi", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprint(os.Stderr, openapiUsage) }
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprint(os.Stderr, openapiUsage)
+		return exitUsage
+	}
+
+	return writeOpenAPI(os.Stdout)
+}
+
+// writeOpenAPI writes the document of handlers built without services,
+// which registering routes does not use
+func writeOpenAPI(w io.Writer) int {
+	h := httpTransport.NewHandler(&service.Services{}, logger.New(logger.WithOutput(io.Discard)))
+	if _, err := w.Write(append(h.OpenAPIJSON(), '\n')); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitFailed
+	}
+	return exitOK
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteOpenAPI(t *testing.T) {
+	// Arrange
+	var out bytes.Buffer
+
+	// Act
+	code := writeOpenAPI(&out)
+
+	// Assert
+	require.Equal(t, exitOK, code)
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &doc))
+	assert.Equal(t, "3.0.3", doc["openapi"])
+	assert.Contains(t, doc["paths"], "/api/v1/users/{id}")
+}
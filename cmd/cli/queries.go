@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/pkg/queryreg"
+)
+
+const queriesUsage = `Usage: cli queries <command>
+
+Commands:
+  report    Report the calls of every registered database query across instances
+`
+
+// Metric families the instances count registered queries with
+const (
+	queryCallsMetric      = "carch_db_queries_total"
+	queryLastCalledMetric = "carch_db_query_last_called_timestamp_seconds"
+)
+
+// metricsTimeout bounds the scrape of one instance
+const metricsTimeout = 10 * time.Second
+
+func queries(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, queriesUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "report":
+		return queriesReport(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown queries command %q\n\n%s", args[0], queriesUsage)
+		return exitUsage
+	}
+}
+
+// urlsFlag collects the values of a repeated flag
+type urlsFlag []string
+
+func (f *urlsFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *urlsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+func queriesReport(args []string) int {
+	var urls urlsFlag
+	fs := flag.NewFlagSet("queries report", flag.ContinueOnError)
+	fs.Var(&urls, "metrics-url", "metrics endpoint of an instance, repeated for each instance; the internal listener of the configuration when omitted")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	if len(urls) == 0 {
+		cfg, err := config.Load()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+			return exitFailed
+		}
+		if cfg.HTTP.InternalPort == "" {
+			fmt.Fprintln(os.Stderr, "HTTP_INTERNAL_PORT is not set, pass --metrics-url")
+			return exitUsage
+		}
+		urls = urlsFlag{"http://" + net.JoinHostPort(cfg.HTTP.InternalAddress, cfg.HTTP.InternalPort) + "/metrics"}
+	}
+
+	var scrapes []queryMetrics
+	for _, url := range urls {
+		ctx, cancel := context.WithTimeout(context.Background(), metricsTimeout)
+		scrape, err := scrapeQueryMetrics(ctx, http.DefaultClient, url)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read %s: %v\n", url, err)
+			return exitFailed
+		}
+		scrapes = append(scrapes, scrape)
+	}
+
+	return writeQueryReport(os.Stdout, aggregateQueryUsage(queryreg.Default.Queries(), scrapes...))
+}
+
+// queryMetrics are the calls and last call of each query name of one instance
+type queryMetrics map[string]queryreg.Usage
+
+// scrapeQueryMetrics reads the query metrics exposed at url
+func scrapeQueryMetrics(ctx context.Context, client *http.Client, url string) (queryMetrics, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return parseQueryMetrics(resp.Body)
+}
+
+// parseQueryMetrics reads the query metrics of the Prometheus text format
+func parseQueryMetrics(in io.Reader) (queryMetrics, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(in)
+	if err != nil {
+		return nil, err
+	}
+
+	scrape := queryMetrics{}
+	for _, m := range families[queryCallsMetric].GetMetric() {
+		name := queryLabel(m.GetLabel())
+		u := scrape[name]
+		u.Calls += uint64(m.GetCounter().GetValue())
+		scrape[name] = u
+	}
+	for _, m := range families[queryLastCalledMetric].GetMetric() {
+		name := queryLabel(m.GetLabel())
+		sec, frac := math.Modf(m.GetGauge().GetValue())
+		last := time.Unix(int64(sec), int64(frac*1e9)).UTC()
+		u := scrape[name]
+		u.LastCalledAt = &last
+		scrape[name] = u
+	}
+	return scrape, nil
+}
+
+// queryLabel returns the value of the query label
+func queryLabel(labels []*dto.LabelPair) string {
+	for _, l := range labels {
+		if l.GetName() == "query" {
+			return l.GetValue()
+		}
+	}
+	return ""
+}
+
+// queryReport is the usage of the registered queries across instances
+type queryReport struct {
+	Queries []queryreg.Usage `json:"queries"`
+	// Unused are the registered queries no instance ran since it started
+	Unused []string `json:"unused"`
+	// Unregistered counts the statements run without a registered name
+	Unregistered uint64 `json:"unregistered"`
+}
+
+// aggregateQueryUsage sums the calls of each registered query over the
+// scrapes and keeps its latest call
+func aggregateQueryUsage(registered []queryreg.Query, scrapes ...queryMetrics) *queryReport {
+	report := &queryReport{Queries: make([]queryreg.Usage, 0, len(registered)), Unused: []string{}}
+	for _, q := range registered {
+		u := queryreg.Usage{Name: q.Name, Origin: q.Origin}
+		for _, scrape := range scrapes {
+			s := scrape[q.Name]
+			u.Calls += s.Calls
+			if s.LastCalledAt != nil && (u.LastCalledAt == nil || s.LastCalledAt.After(*u.LastCalledAt)) {
+				u.LastCalledAt = s.LastCalledAt
+			}
+		}
+		if u.Calls == 0 {
+			report.Unused = append(report.Unused, q.Name)
+		}
+		report.Queries = append(report.Queries, u)
+	}
+	for _, scrape := range scrapes {
+		report.Unregistered += scrape[queryreg.Unregistered].Calls
+	}
+	return report
+}
+
+// writeQueryReport writes the JSON report and returns the process exit code
+func writeQueryReport(out io.Writer, report *queryReport) int {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return exitFailed
+	}
+	return exitOK
+}
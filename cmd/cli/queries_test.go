@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/queryreg"
+)
+
+func queryMetricsText(calls map[string]int, last map[string]time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# TYPE %s counter\n", queryCallsMetric)
+	for name, n := range calls {
+		fmt.Fprintf(&b, "%s{query=%q} %d\n", queryCallsMetric, name, n)
+	}
+	fmt.Fprintf(&b, "# TYPE %s gauge\n", queryLastCalledMetric)
+	for name, t := range last {
+		fmt.Fprintf(&b, "%s{query=%q} %g\n", queryLastCalledMetric, name, float64(t.Unix()))
+	}
+	return b.String()
+}
+
+func TestAggregateQueryUsage(t *testing.T) {
+	// Arrange
+	earlier := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	later := earlier.Add(time.Hour)
+	a, err := parseQueryMetrics(strings.NewReader(queryMetricsText(
+		map[string]int{"users.get_by_id": 3, queryreg.Unregistered: 1},
+		map[string]time.Time{"users.get_by_id": earlier},
+	)))
+	require.NoError(t, err)
+	b, err := parseQueryMetrics(strings.NewReader(queryMetricsText(
+		map[string]int{"users.get_by_id": 2, "users.purge": 0},
+		map[string]time.Time{"users.get_by_id": later},
+	)))
+	require.NoError(t, err)
+	registered := []queryreg.Query{
+		{Name: "users.get_by_id", Origin: "repository.(*UserRepository).GetByID"},
+		{Name: "users.purge", Origin: "repository.(*UserRepository).Purge"},
+	}
+
+	// Act
+	report := aggregateQueryUsage(registered, a, b)
+
+	// Assert
+	assert.Equal(t, []queryreg.Usage{
+		{Name: "users.get_by_id", Origin: "repository.(*UserRepository).GetByID", Calls: 5, LastCalledAt: &later},
+		{Name: "users.purge", Origin: "repository.(*UserRepository).Purge"},
+	}, report.Queries)
+	assert.Equal(t, []string{"users.purge"}, report.Unused)
+	assert.Equal(t, uint64(1), report.Unregistered)
+}
+
+func TestScrapeQueryMetrics(t *testing.T) {
+	// Arrange
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, queryMetricsText(map[string]int{"users.get_by_id": 4}, nil))
+	}))
+	defer srv.Close()
+
+	// Act
+	scrape, err := scrapeQueryMetrics(context.Background(), srv.Client(), srv.URL)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, uint64(4), scrape["users.get_by_id"].Calls)
+	assert.Nil(t, scrape["users.get_by_id"].LastCalledAt)
+}
+
+func TestScrapeQueryMetrics_Status(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	_, err := scrapeQueryMetrics(context.Background(), srv.Client(), srv.URL)
+
+	assert.ErrorContains(t, err, "404")
+}
+
+func TestWriteQueryReport(t *testing.T) {
+	var out bytes.Buffer
+
+	code := writeQueryReport(&out, aggregateQueryUsage(queryreg.Default.Queries()))
+
+	assert.Equal(t, exitOK, code)
+	var got queryReport
+	require.NoError(t, json.Unmarshal(out.Bytes(), &got))
+	assert.NotEmpty(t, got.Queries, "the repositories register their queries")
+	assert.Len(t, got.Unused, len(got.Queries))
+}
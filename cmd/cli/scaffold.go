@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"embed"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+const scaffoldUsage = `Usage: cli scaffold <command> [flags]
+
+Commands:
+  entity    Generate the domain, repository, service, HTTP routes, proto,
+            migration and tests of a new entity, e.g. --name Project
+`
+
+//go:embed templates/entity/*.tmpl
+var entityTemplates embed.FS
+
+// entityFile is a file rendered by cli scaffold entity
+type entityFile struct {
+	template string
+	// path is a template of the path relative to the module root
+	path string
+}
+
+var entityFiles = []entityFile{
+	{"domain.go.tmpl", "internal/domain/{{.File}}.go"},
+	{"repository.go.tmpl", "internal/repository/{{.File}}.go"},
+	{"repository_test.go.tmpl", "internal/repository/postgres_{{.File}}_test.go"},
+	{"service.go.tmpl", "internal/service/{{.File}}.go"},
+	{"service_test.go.tmpl", "internal/service/{{.File}}_test.go"},
+	{"handler.go.tmpl", "internal/transport/http/{{.File}}.go"},
+	{"handler_test.go.tmpl", "internal/transport/http/{{.File}}_test.go"},
+	{"proto.tmpl", "api/proto/{{.ProtoDir}}/v1/{{.File}}.proto"},
+	{"migration.up.sql.tmpl", "migrations/{{.Migration}}_create_{{.Table}}_table.up.sql"},
+	{"migration.down.sql.tmpl", "migrations/{{.Migration}}_create_{{.Table}}_table.down.sql"},
+}
+
+// entityNamePattern accepts exported Go identifiers without underscores
+var entityNamePattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// errFilesExist is returned when a generated file would replace an existing one
+var errFilesExist = errors.New("refusing to overwrite existing files")
+
+// entityNames are the spellings of an entity name used by the templates
+type entityNames struct {
+	Module string
+	// Name is the Go type, e.g. ProjectTask
+	Name string
+	// Var and PluralVar are local variable names, e.g. projectTask and projectTasks
+	Var       string
+	PluralVar string
+	// Receiver is the receiver name of the domain methods, e.g. p
+	Receiver string
+	// Plural is the exported plural, e.g. ProjectTasks
+	Plural string
+	// Human and HumanPlural are used in messages, e.g. project task
+	Human       string
+	HumanPlural string
+	HumanTitle  string
+	// File is the snake_case file name, e.g. project_task
+	File string
+	// Table is the snake_case table name, e.g. project_tasks
+	Table string
+	// Route is the kebab-case route segment, e.g. project-tasks
+	Route string
+	// ProtoDir and ProtoPackage name the proto package, e.g. projecttask and carch.projecttask.v1
+	ProtoDir     string
+	ProtoPackage string
+	// Migration is the zero-padded version of the migration, e.g. 000012
+	Migration string
+}
+
+func scaffold(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprint(os.Stderr, scaffoldUsage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "entity":
+		return scaffoldEntity(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown scaffold command %q\n\n%s", args[0], scaffoldUsage)
+		return exitUsage
+	}
+}
+
+func scaffoldEntity(args []string) int {
+	fs := flag.NewFlagSet("scaffold entity", flag.ContinueOnError)
+	name := fs.String("name", "", "entity name as a Go type, e.g. Project")
+	dir := fs.String("dir", ".", "root of the module to generate into")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if *name == "" {
+		fmt.Fprintln(os.Stderr, "--name is required")
+		return exitUsage
+	}
+
+	files, err := generateEntity(*dir, *name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "scaffold failed: %v\n", err)
+		return exitFailed
+	}
+
+	writeScaffoldReport(os.Stdout, *name, files)
+	return exitOK
+}
+
+// generateEntity renders the entity files into the module at root and
+// returns their paths. Nothing is written if any of them already exists.
+func generateEntity(root, name string) ([]string, error) {
+	if !entityNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid entity name %q: use an exported Go identifier such as Project", name)
+	}
+
+	module, err := modulePath(root)
+	if err != nil {
+		return nil, err
+	}
+	migration, err := nextMigration(filepath.Join(root, "migrations"))
+	if err != nil {
+		return nil, err
+	}
+	names := newEntityNames(module, name, migration)
+
+	rendered := make(map[string][]byte, len(entityFiles))
+	paths := make([]string, 0, len(entityFiles))
+	var existing []string
+	for _, f := range entityFiles {
+		path, err := render(f.path, names)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(filepath.Join(root, string(path))); err == nil {
+			existing = append(existing, string(path))
+		}
+
+		content, err := renderFile(f.template, names)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(string(path), ".go") {
+			if content, err = format.Source(content); err != nil {
+				return nil, fmt.Errorf("format %s: %w", path, err)
+			}
+		}
+
+		paths = append(paths, string(path))
+		rendered[string(path)] = content
+	}
+	if len(existing) > 0 {
+		return nil, fmt.Errorf("%w: %s", errFilesExist, strings.Join(existing, ", "))
+	}
+
+	for _, path := range paths {
+		if err := writeNewFile(filepath.Join(root, path), rendered[path]); err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+func newEntityNames(module, name string, migration uint) entityNames {
+	words := splitWords(name)
+	lower := strings.ToLower(strings.Join(words, ""))
+	human := humanize(words)
+	plural := pluralize(name)
+	pluralWords := splitWords(plural)
+
+	return entityNames{
+		Module:       module,
+		Name:         name,
+		Var:          lowerFirst(words),
+		PluralVar:    lowerFirst(pluralWords),
+		Receiver:     strings.ToLower(name[:1]),
+		Plural:       plural,
+		Human:        human,
+		HumanPlural:  humanize(pluralWords),
+		HumanTitle:   strings.ToUpper(human[:1]) + human[1:],
+		File:         strings.ToLower(strings.Join(words, "_")),
+		Table:        strings.ToLower(strings.Join(pluralWords, "_")),
+		Route:        strings.ToLower(strings.Join(pluralWords, "-")),
+		ProtoDir:     lower,
+		ProtoPackage: "carch." + lower + ".v1",
+		Migration:    fmt.Sprintf("%06d", migration),
+	}
+}
+
+// splitWords splits a Go identifier into its words, keeping acronyms
+// together: APIKey is API and Key
+func splitWords(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		if !unicode.IsUpper(runes[i]) {
+			continue
+		}
+		prevUpper := unicode.IsUpper(runes[i-1])
+		nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if !prevUpper || nextLower {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+// humanize joins words into a phrase, lower case except for acronyms
+func humanize(words []string) string {
+	phrase := make([]string, len(words))
+	for i, w := range words {
+		if len(w) > 1 && strings.ToUpper(w) == w {
+			phrase[i] = w
+		} else {
+			phrase[i] = strings.ToLower(w)
+		}
+	}
+	return strings.Join(phrase, " ")
+}
+
+// lowerFirst joins words into a camelCase identifier
+func lowerFirst(words []string) string {
+	return strings.ToLower(words[0]) + strings.Join(words[1:], "")
+}
+
+// pluralize returns the English plural of name
+func pluralize(name string) string {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, "y") && len(lower) > 1 && !strings.ContainsRune("aeiou", rune(lower[len(lower)-2])):
+		return name[:len(name)-1] + "ies"
+	case strings.HasSuffix(lower, "s"), strings.HasSuffix(lower, "x"), strings.HasSuffix(lower, "z"),
+		strings.HasSuffix(lower, "ch"), strings.HasSuffix(lower, "sh"):
+		return name + "es"
+	default:
+		return name + "s"
+	}
+}
+
+// modulePath reads the module path from the go.mod at root
+func modulePath(root string) (string, error) {
+	f, err := os.Open(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return "", fmt.Errorf("read go.mod: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if module, ok := strings.CutPrefix(strings.TrimSpace(scanner.Text()), "module "); ok {
+			return strings.Trim(strings.TrimSpace(module), `"`), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("read go.mod: %w", err)
+	}
+	return "", errors.New("go.mod has no module directive")
+}
+
+// nextMigration returns the version following the highest migration in dir
+func nextMigration(dir string) (uint, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, fmt.Errorf("read migrations: %w", err)
+	}
+
+	var latest uint64
+	for _, e := range entries {
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		if !ok || !strings.HasSuffix(e.Name(), ".sql") {
+			continue
+		}
+		if v, err := strconv.ParseUint(prefix, 10, 64); err == nil && v > latest {
+			latest = v
+		}
+	}
+	return uint(latest) + 1, nil
+}
+
+func render(text string, names entityNames) ([]byte, error) {
+	tmpl, err := template.New("path").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, names); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func renderFile(name string, names entityNames) ([]byte, error) {
+	text, err := entityTemplates.ReadFile("templates/entity/" + name)
+	if err != nil {
+		return nil, err
+	}
+	content, err := render(string(text), names)
+	if err != nil {
+		return nil, fmt.Errorf("render %s: %w", name, err)
+	}
+	return content, nil
+}
+
+// writeNewFile creates path with content and fails if it already exists
+func writeNewFile(path string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeScaffoldReport lists the generated files and the wiring left to do by hand
+func writeScaffoldReport(out io.Writer, name string, files []string) {
+	names := newEntityNames("", name, 0)
+
+	fmt.Fprintf(out, "Generated %s:\n", names.Human)
+	for _, f := range files {
+		fmt.Fprintf(out, "  %s\n", f)
+	}
+
+	fmt.Fprintf(out, `
+Wire it up:
+  1. internal/repository/repository.go: add %[1]s domain.%[1]sRepository to Repositories
+     and set it to New%[1]sRepository(querier) in NewRepositories.
+  2. internal/service/service.go: add %[1]s to Repositories and %[1]s %[1]sServiceInterface
+     to Services, set to New%[1]sService(deps.Repos.%[1]s, deps.Logger).
+  3. internal/transport/http/handler.go: call h.register%[1]sRoutes(h.services.%[1]s)
+     in setupRoutes, then run make openapi.
+  4. internal/transport/errmap/errmap.go: map domain.Err%[1]sNotFound to 404.
+  5. Makefile: add %[2]s/v1/%[3]s.proto to the proto target, run make proto and
+     implement %[1]sService in internal/transport/grpc.
+`, name, names.ProtoDir, names.File)
+}
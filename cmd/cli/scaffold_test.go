@@ -0,0 +1,182 @@
+package main
+
+import (
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewEntityNames(t *testing.T) {
+	tests := []struct {
+		name string
+		want entityNames
+	}{
+		{
+			name: "Project",
+			want: entityNames{
+				Name: "Project", Var: "project", PluralVar: "projects", Receiver: "p", Plural: "Projects",
+				Human: "project", HumanPlural: "projects", HumanTitle: "Project",
+				File: "project", Table: "projects", Route: "projects",
+				ProtoDir: "project", ProtoPackage: "carch.project.v1", Migration: "000012",
+			},
+		},
+		{
+			name: "APIKey",
+			want: entityNames{
+				Name: "APIKey", Var: "apiKey", PluralVar: "apiKeys", Receiver: "a", Plural: "APIKeys",
+				Human: "API key", HumanPlural: "API keys", HumanTitle: "API key",
+				File: "api_key", Table: "api_keys", Route: "api-keys",
+				ProtoDir: "apikey", ProtoPackage: "carch.apikey.v1", Migration: "000012",
+			},
+		},
+		{
+			name: "Category",
+			want: entityNames{
+				Name: "Category", Var: "category", PluralVar: "categories", Receiver: "c", Plural: "Categories",
+				Human: "category", HumanPlural: "categories", HumanTitle: "Category",
+				File: "category", Table: "categories", Route: "categories",
+				ProtoDir: "category", ProtoPackage: "carch.category.v1", Migration: "000012",
+			},
+		},
+		{
+			name: "TaxClass",
+			want: entityNames{
+				Name: "TaxClass", Var: "taxClass", PluralVar: "taxClasses", Receiver: "t", Plural: "TaxClasses",
+				Human: "tax class", HumanPlural: "tax classes", HumanTitle: "Tax class",
+				File: "tax_class", Table: "tax_classes", Route: "tax-classes",
+				ProtoDir: "taxclass", ProtoPackage: "carch.taxclass.v1", Migration: "000012",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act & Assert
+			assert.Equal(t, tt.want, newEntityNames("", tt.name, 12))
+		})
+	}
+}
+
+// emptyModule creates a module with no code but the migrations directory
+func emptyModule(t *testing.T) string {
+	t.Helper()
+
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/app\n\ngo 1.24\n"), 0o644))
+	require.NoError(t, os.Mkdir(filepath.Join(root, "migrations"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "migrations", "000004_create_users_table.up.sql"), nil, 0o644))
+	return root
+}
+
+func TestGenerateEntity_InvalidName(t *testing.T) {
+	for _, name := range []string{"", "project", "Project_Task", "Pro-ject", "1Project"} {
+		// Act
+		_, err := generateEntity(emptyModule(t), name)
+
+		// Assert
+		assert.ErrorContains(t, err, "invalid entity name", name)
+	}
+}
+
+func TestGenerateEntity_RefusesToOverwrite(t *testing.T) {
+	// Arrange
+	root := emptyModule(t)
+	existing := filepath.Join(root, "internal", "service", "project.go")
+	require.NoError(t, os.MkdirAll(filepath.Dir(existing), 0o755))
+	require.NoError(t, os.WriteFile(existing, []byte("package service\n"), 0o644))
+
+	// Act
+	_, err := generateEntity(root, "Project")
+
+	// Assert
+	require.ErrorIs(t, err, errFilesExist)
+	assert.ErrorContains(t, err, "internal/service/project.go")
+
+	content, err := os.ReadFile(existing)
+	require.NoError(t, err)
+	assert.Equal(t, "package service\n", string(content))
+	assert.NoFileExists(t, filepath.Join(root, "internal", "domain", "project.go"), "nothing is written")
+}
+
+func TestGenerateEntity_Paths(t *testing.T) {
+	// Act
+	files, err := generateEntity(emptyModule(t), "Project")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Contains(t, files, "migrations/000005_create_projects_table.up.sql")
+	assert.Contains(t, files, "api/proto/project/v1/project.proto")
+}
+
+// TestGenerateEntity_Builds generates an entity into a copy of this module
+// and checks that the result builds, vets and passes its tests
+func TestGenerateEntity_Builds(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a copy of the module")
+	}
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found")
+	}
+
+	// Arrange
+	root := t.TempDir()
+	copyModule(t, filepath.Join("..", ".."), root)
+
+	// Act
+	files, err := generateEntity(root, "ProjectTask")
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, files, len(entityFiles))
+	repo, err := os.ReadFile(filepath.Join(root, "internal", "repository", "project_task.go"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(repo), "query := `", "generated queries are registered")
+	assert.Contains(t, string(repo), `registerQuery("project_tasks.create", "(*ProjectTaskRepository).Create"`)
+
+	for _, args := range [][]string{
+		{"build", "./..."},
+		{"vet", "./..."},
+		{"test", "-count=1", "./internal/repository", "./internal/service", "./internal/transport/http"},
+	} {
+		cmd := exec.Command(goBin, args...)
+		cmd.Dir = root
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, "go %v:\n%s", args, out)
+	}
+}
+
+// copyModule copies the source tree at src to dst, without version control
+func copyModule(t *testing.T, src, dst string) {
+	t.Helper()
+
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(filepath.Join(dst, rel), 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dst, rel), content, 0o644)
+	})
+	require.NoError(t, err)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/app"
+	"github.com/romanitalian/carch-go/internal/pkg/integrity"
+)
+
+func verify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	repair := fs.Bool("repair", false, "apply safe fixes and record them in the audit log")
+	batchSize := fs.Int("batch-size", 500, "rows repaired per transaction")
+	timeout := fs.Duration("timeout", 5*time.Minute, "timeout of the whole run")
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load config: %v\n", err)
+		return exitFailed
+	}
+
+	db, err := sql.Open("postgres", app.PostgresConfig(cfg, nil).DSN())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to open database: %v\n", err)
+		return exitFailed
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	verifier := integrity.NewVerifier(db, cfg.Users.PurgeAfter, integrity.WithBatchSize(*batchSize))
+	return runVerify(ctx, os.Stdout, os.Stderr, verifier, *repair)
+}
+
+// runVerify runs the checks, writes the JSON report and returns the process
+// exit code, which is non-zero while inconsistencies remain
+func runVerify(ctx context.Context, out, errOut io.Writer, verifier *integrity.Verifier, repair bool) int {
+	report, err := verifier.Run(ctx, repair)
+	if err != nil {
+		fmt.Fprintf(errOut, "verify failed: %v\n", err)
+		return exitFailed
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(struct {
+		OK bool `json:"ok"`
+		*integrity.Report
+	}{report.Clean(), report}); err != nil {
+		return exitFailed
+	}
+
+	if !report.Clean() {
+		return exitFailed
+	}
+	return exitOK
+}
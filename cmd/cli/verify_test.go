@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/integrity"
+)
+
+type verifyReport struct {
+	OK       bool                `json:"ok"`
+	Findings []integrity.Finding `json:"findings"`
+}
+
+func keyRows(keys ...string) *sqlmock.Rows {
+	rows := sqlmock.NewRows([]string{"key"})
+	for _, k := range keys {
+		rows.AddRow(k)
+	}
+	return rows
+}
+
+// expectDetect sets up the detect queries of the default checks in order
+func expectDetect(mock sqlmock.Sqlmock, orphans, tombstones, blank, duplicates, expired []string) {
+	mock.ExpectQuery(`FROM email_change_requests r\s+JOIN users`).WillReturnRows(keyRows(orphans...))
+	mock.ExpectQuery(`FROM user_tombstones t\s+JOIN users`).WillReturnRows(keyRows(tombstones...))
+	mock.ExpectQuery(`btrim\(name\) = ''`).WillReturnRows(keyRows(blank...))
+	mock.ExpectQuery(`HAVING COUNT\(\*\) > 1`).WillReturnRows(keyRows(duplicates...))
+	mock.ExpectQuery(`deleted_at < \$1`).WithArgs(sqlmock.AnyArg()).WillReturnRows(keyRows(expired...))
+}
+
+func TestRunVerify_DetectsEveryClass(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectDetect(mock, nil, []string{"t-1"}, []string{"u-1"}, []string{"a@example.com"}, []string{"u-2", "u-3"})
+
+	var out, errOut bytes.Buffer
+
+	// Act
+	code := runVerify(context.Background(), &out, &errOut, integrity.NewVerifier(db, time.Hour), false)
+
+	// Assert
+	assert.Equal(t, exitFailed, code)
+
+	var report verifyReport
+	require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.False(t, report.OK)
+
+	counts := map[string]int{}
+	for _, f := range report.Findings {
+		counts[f.Check] = f.Count
+		assert.Zero(t, f.Repaired, f.Check)
+	}
+	assert.Equal(t, map[string]int{
+		"orphaned_email_change_requests": 0,
+		"tombstones_of_live_users":       1,
+		"users_missing_metadata":         1,
+		"duplicate_emails":               1,
+		"expired_soft_deleted_users":     2,
+	}, counts)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunVerify_RepairsInBatchesWithAudit(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Three orphaned requests repaired two per transaction
+	mock.ExpectQuery(`FROM email_change_requests r\s+JOIN users`).WillReturnRows(keyRows("o-1", "o-2", "o-3"))
+	for _, batch := range [][]string{{"o-1", "o-2"}, {"o-3"}} {
+		mock.ExpectBegin()
+		mock.ExpectQuery(`DELETE FROM email_change_requests`).WillReturnRows(keyRows(batch...))
+		mock.ExpectExec(`INSERT INTO audit_log`).
+			WithArgs(integrity.AuditAction, "email_change_requests", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, int64(len(batch))))
+		mock.ExpectCommit()
+	}
+
+	// A tombstone that is no longer inconsistent when repaired is left alone
+	mock.ExpectQuery(`FROM user_tombstones t\s+JOIN users`).WillReturnRows(keyRows("t-1"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM user_tombstones`).WillReturnRows(keyRows())
+	mock.ExpectCommit()
+
+	mock.ExpectQuery(`btrim\(name\) = ''`).WillReturnRows(keyRows())
+	mock.ExpectQuery(`HAVING COUNT\(\*\) > 1`).WillReturnRows(keyRows())
+	mock.ExpectQuery(`deleted_at < \$1`).WillReturnRows(keyRows())
+
+	var out, errOut bytes.Buffer
+	verifier := integrity.NewVerifier(db, time.Hour, integrity.WithBatchSize(2))
+
+	// Act
+	code := runVerify(context.Background(), &out, &errOut, verifier, true)
+
+	// Assert
+	var report verifyReport
+	require.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, 3, report.Findings[0].Repaired)
+	assert.Equal(t, 0, report.Findings[1].Repaired)
+	assert.False(t, report.OK)
+	assert.Equal(t, exitFailed, code)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunVerify_RepairFailureRollsBack(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectQuery(`FROM email_change_requests r\s+JOIN users`).WillReturnRows(keyRows("o-1"))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`DELETE FROM email_change_requests`).WillReturnRows(keyRows("o-1"))
+	mock.ExpectExec(`INSERT INTO audit_log`).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+
+	var out, errOut bytes.Buffer
+
+	// Act
+	code := runVerify(context.Background(), &out, &errOut, integrity.NewVerifier(db, time.Hour), true)
+
+	// Assert
+	assert.Equal(t, exitFailed, code)
+	assert.Contains(t, errOut.String(), "repair orphaned_email_change_requests")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRunVerify_Clean(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	expectDetect(mock, nil, nil, nil, nil, nil)
+
+	var out, errOut bytes.Buffer
+
+	// Act
+	code := runVerify(context.Background(), &out, &errOut, integrity.NewVerifier(db, time.Hour), true)
+
+	// Assert
+	assert.Equal(t, exitOK, code)
+	assert.Contains(t, out.String(), `"ok": true`)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,40 +2,84 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/app"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
 	"github.com/romanitalian/carch-go/internal/scheduler"
 )
 
 func main() {
-	// Loading configuration
-	cfg, err := config.Load()
+	// Initialize logger, JSON until the config tells the format
+	log := logger.New()
+
+	configPath := flag.String("config", "", "path of a YAML config file, CONFIG_PATH by default")
+	flag.Parse()
+
+	// Loading configuration, environment variables override the file
+	cfg, err := config.LoadFile(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config", err, map[string]interface{}{"error": err.Error()})
+	}
+	configured, err := app.NewLogger(cfg)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		log.Fatal("Invalid log level", err, map[string]interface{}{"level": cfg.Log.Level})
+	}
+	log = configured
+	defer log.Close()
+	logger.SetDefault(log)
+	if err := scheduler.CheckSchedules(cfg.Scheduler.Tasks); err != nil {
+		log.Fatal("Invalid task schedules", err, map[string]interface{}{"error": err.Error()})
 	}
 
 	// Initializing context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Setting up database and RabbitMQ connections
+	repos, cleanup, err := app.BuildRepositories(cfg, log)
+	if err != nil {
+		log.Fatal("Failed to initialize repositories", err, map[string]interface{}{"error": err.Error()})
+	}
+	defer cleanup()
+
 	// Initializing scheduler
-	scheduler := scheduler.NewScheduler(cfg)
+	scheduler := scheduler.NewScheduler(cfg,
+		scheduler.WithServices(app.BuildServices(cfg, repos, log)),
+		scheduler.WithOutboxRelay(app.BuildOutboxRelay(cfg, repos, log)),
+		scheduler.WithStopTimeout(cfg.Scheduler.StopTimeout),
+		scheduler.WithLogger(log),
+	)
 
 	// Registering tasks
-	scheduler.RegisterTasks()
+	if err := scheduler.RegisterTasks(); err != nil {
+		log.Error("Failed to register tasks", err, nil)
+		cleanup()
+		os.Exit(1)
+	}
 
 	// Starting scheduler
-	go scheduler.Run(ctx)
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- scheduler.Run(ctx)
+	}()
 
 	// Waiting for signal for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down scheduler...")
+	log.Info("Shutting down scheduler...")
 	cancel()
+
+	// Exit non-zero when tasks had to be interrupted
+	if err := <-stopped; err != nil {
+		log.Error("Scheduler stopped with error", err, nil)
+		cleanup()
+		os.Exit(1)
+	}
 }
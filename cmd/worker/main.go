@@ -2,45 +2,118 @@ package main
 
 import (
 	"context"
-	"log"
+	"flag"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/romanitalian/carch-go/config"
-	"github.com/romanitalian/carch-go/internal/repository"
-	"github.com/romanitalian/carch-go/internal/worker"
+	"github.com/romanitalian/carch-go/internal/app"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
 )
 
 func main() {
-	// Loading configuration
-	cfg, err := config.Load()
+	// Initialize logger, JSON until the config tells the format
+	log := logger.New()
+
+	configPath := flag.String("config", "", "path of a YAML config file, CONFIG_PATH by default")
+	flag.Parse()
+
+	// Loading configuration, environment variables override the file
+	cfg, err := config.LoadFile(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config", err, map[string]interface{}{"error": err.Error()})
+	}
+	configured, err := app.NewLogger(cfg)
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		log.Fatal("Invalid log level", err, map[string]interface{}{"level": cfg.Log.Level})
 	}
+	log = configured
+	defer log.Close()
+	logger.SetDefault(log)
 
 	// Initializing context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Setting up RabbitMQ connection
-	messageQueue, err := repository.NewRabbitMQ(repository.RabbitMQConfig{
-		URL: cfg.RabbitMQ.URL,
-	})
+	// Setting up database and RabbitMQ connections
+	repos, cleanup, err := app.BuildRepositories(cfg, log)
 	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+		log.Fatal("Failed to initialize repositories", err, map[string]interface{}{"error": err.Error()})
 	}
-	defer messageQueue.Close()
+	defer cleanup()
 
 	// Initializing and starting worker
-	worker := worker.NewWorker(messageQueue)
-	go worker.Run(ctx)
+	worker, err := app.BuildWorker(cfg, repos, log)
+	if err != nil {
+		cleanup()
+		log.Fatal("Failed to initialize worker", err, map[string]interface{}{"error": err.Error()})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := worker.Run(ctx); err != nil {
+			log.Error("Worker stopped", err, nil)
+		}
+	}()
+
+	// The health report tells the phase of the worker, e.g. draining
+	healthServer := app.BuildWorkerHealthServer(cfg, repos, worker)
+	if healthServer != nil {
+		go func() {
+			if err := healthServer.Run(); err != nil {
+				log.Error("Health server failed", err, map[string]interface{}{"port": cfg.Worker.HealthPort})
+			}
+		}()
+		defer healthServer.Shutdown(context.Background())
+	}
 
 	// Waiting for signal for graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down worker...")
+	if cfg.Worker.ShutdownGrace > 0 {
+		// Stop consuming at once and let the handlers in flight finish,
+		// their messages are requeued before the grace expires
+		sigterm := time.Now()
+		deadline := sigterm.Add(cfg.Worker.ShutdownGrace - cfg.Worker.ShutdownNackMargin)
+		log.Info("Draining worker...", map[string]interface{}{
+			"grace":       cfg.Worker.ShutdownGrace.String(),
+			"nack_margin": cfg.Worker.ShutdownNackMargin.String(),
+		})
+		if err := worker.Drain(deadline); err != nil {
+			log.Error("Failed to stop consuming", err, nil)
+		}
+		select {
+		case <-done:
+			log.Info("Worker drained", map[string]interface{}{"duration": time.Since(sigterm).String()})
+		case <-time.After(time.Until(sigterm.Add(cfg.Worker.ShutdownGrace))):
+			log.Warn("Worker did not drain in time, abandoning in-flight handlers", map[string]interface{}{
+				"grace": cfg.Worker.ShutdownGrace.String(),
+			})
+			cleanup()
+			os.Exit(1)
+		}
+		return
+	}
+
+	log.Info("Shutting down worker...")
 	cancel()
+	// Waiting for in-flight handlers, their messages are requeued if cancelled
+	if cfg.Worker.ShutdownTimeout <= 0 {
+		<-done
+		return
+	}
+	select {
+	case <-done:
+	case <-time.After(cfg.Worker.ShutdownTimeout):
+		// Exit non-zero, the broker redelivers the unacknowledged messages
+		log.Warn("Worker did not stop in time, abandoning in-flight handlers", map[string]interface{}{
+			"timeout": cfg.Worker.ShutdownTimeout.String(),
+		})
+		cleanup()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+const redacted = "REDACTED"
+
+// withoutSecrets returns a copy of the configuration with the fields tagged
+// secret:"true" replaced, as Redacted masks them. URLs keep everything but
+// their password, so that a change of host still shows.
+func (c Config) withoutSecrets() Config {
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			if f.Type.Kind() == reflect.Struct {
+				walk(v.Field(i))
+				continue
+			}
+			if f.Tag.Get("secret") == "true" {
+				v.Field(i).Set(redactValue(v.Field(i)))
+			}
+		}
+	}
+	walk(reflect.ValueOf(&c).Elem())
+
+	return c
+}
+
+// redactValue returns v with its secrets replaced. Maps and slices are
+// copied, the configuration they were taken from shares them.
+func redactValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.String:
+		return reflect.ValueOf(redactSecret(v.String())).Convert(v.Type())
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			out.Index(i).Set(redactValue(v.Index(i)))
+		}
+		return out
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		out := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for _, k := range v.MapKeys() {
+			out.SetMapIndex(k, redactValue(v.MapIndex(k)))
+		}
+		return out
+	default:
+		return v
+	}
+}
+
+// redactSecret replaces a secret. A URL, or a list of them, keeps
+// everything but its passwords, other secrets are replaced as a whole.
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	urls := splitURLs(s)
+	if slices.ContainsFunc(urls, func(u string) bool { return !isURL(u) }) {
+		return redacted
+	}
+	for i, raw := range urls {
+		if u, err := url.Parse(raw); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), redacted)
+				urls[i] = u.String()
+			}
+		}
+	}
+	return strings.Join(urls, ",")
+}
+
+// Hash returns a fingerprint of the redacted effective configuration, used to
+// detect replicas started with different settings. Secrets do not contribute.
+func (c Config) Hash() string {
+	data, err := json.Marshal(c.withoutSecrets())
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
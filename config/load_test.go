@@ -0,0 +1,143 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigFile = `
+http:
+  port: "9000"
+  slow_request_threshold: 2s
+db:
+  sslmode: require
+users:
+  bulk_delete_ceiling: 50
+`
+
+// writeConfigFile writes content to a YAML file in a temporary directory
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadFile(t *testing.T) {
+	// Arrange
+	path := writeConfigFile(t, testConfigFile)
+
+	// Act
+	cfg, err := LoadFile(path)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, path, cfg.Path)
+	assert.Equal(t, "9000", cfg.HTTP.Port)
+	assert.Equal(t, 2*time.Second, cfg.HTTP.SlowRequestThreshold)
+	assert.Equal(t, "require", cfg.DB.SSLMode)
+	assert.Equal(t, int64(50), cfg.Users.BulkDeleteCeiling)
+	assert.Equal(t, "9090", cfg.GRPC.Port, "fields missing from the file keep their defaults")
+}
+
+func TestLoadFile_EnvironmentOverridesFile(t *testing.T) {
+	// Arrange
+	path := writeConfigFile(t, testConfigFile)
+	t.Setenv("HTTP_PORT", "9100")
+
+	// Act
+	cfg, err := LoadFile(path)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "9100", cfg.HTTP.Port)
+	assert.Equal(t, "require", cfg.DB.SSLMode)
+}
+
+func TestLoadFile_PathFromEnvironment(t *testing.T) {
+	// Arrange
+	t.Setenv("CONFIG_PATH", writeConfigFile(t, testConfigFile))
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "9000", cfg.HTTP.Port)
+}
+
+func TestLoadFile_MissingFile(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+
+	t.Run("given path", func(t *testing.T) {
+		// Act
+		cfg, err := LoadFile(missing)
+
+		// Assert
+		require.ErrorIs(t, err, os.ErrNotExist)
+		assert.Nil(t, cfg)
+	})
+
+	t.Run("path from the environment", func(t *testing.T) {
+		// Arrange
+		t.Setenv("CONFIG_PATH", missing)
+
+		// Act
+		cfg, err := Load()
+
+		// Assert
+		require.ErrorIs(t, err, os.ErrNotExist)
+		assert.Nil(t, cfg)
+	})
+
+	t.Run("no path", func(t *testing.T) {
+		// Act: there is no DefaultPath file in the package directory
+		cfg, err := Load()
+
+		// Assert
+		require.NoError(t, err)
+		assert.Empty(t, cfg.Path)
+	})
+}
+
+func TestLoadFile_InvalidFileValue(t *testing.T) {
+	// Arrange
+	path := writeConfigFile(t, "db:\n  sslmode: disabled\n")
+
+	// Act
+	_, err := LoadFile(path)
+
+	// Assert
+	var validationErr *ValidationError
+	require.ErrorAs(t, err, &validationErr)
+	assert.Equal(t, "DB_SSLMODE", validationErr.Fields[0].EnvVar)
+}
+
+func TestLoadFile_TaskSchedules(t *testing.T) {
+	// Arrange
+	path := writeConfigFile(t, `
+scheduler:
+  tasks:
+    example: "0 */5 * * * *"
+    hourly: "0 0 * * * *"
+`)
+	t.Setenv("SCHEDULER_TASK_HOURLY", "disabled")
+	t.Setenv("SCHEDULER_TASK_OUTBOX_RELAY", "*/30 * * * * *")
+
+	// Act
+	cfg, err := LoadFile(path)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"example":      "0 */5 * * * *",
+		"hourly":       "disabled",
+		"outbox_relay": "*/30 * * * * *",
+	}, cfg.Scheduler.Tasks)
+}
@@ -0,0 +1,52 @@
+package config
+
+import "os"
+
+// Environments selected by APP_ENV
+const (
+	EnvDev     = "dev"
+	EnvStaging = "staging"
+	EnvProd    = "prod"
+)
+
+// envs are the APP_ENV values
+var envs = []string{EnvDev, EnvStaging, EnvProd}
+
+// defaultDBPassword is the DB_PASSWORD default, which prod refuses
+const defaultDBPassword = "postgres"
+
+// IsDev reports whether the service runs on a developer machine
+func (c *Config) IsDev() bool {
+	return c.Env == EnvDev
+}
+
+// IsProd reports whether the service runs in production
+func (c *Config) IsProd() bool {
+	return c.Env == EnvProd
+}
+
+// applyProfile replaces the built-in defaults that differ in the selected
+// environment. A variable set in the environment is always kept.
+func (c *Config) applyProfile() {
+	unset := func(envVar string) bool {
+		_, ok := os.LookupEnv(envVar)
+		return !ok
+	}
+
+	switch c.Env {
+	case EnvDev:
+		// Front ends served from any local port may call the API
+		if unset("HTTP_CORS_ORIGINS") && len(c.HTTP.CORSOrigins) == 0 {
+			c.HTTP.CORSOrigins = []string{"*"}
+		}
+		// Developers read the logs in their terminal
+		if unset("LOG_FORMAT") && c.Log.Format == LogFormatJSON {
+			c.Log.Format = LogFormatConsole
+		}
+	case EnvProd:
+		// Connections to the database must be encrypted
+		if unset("DB_SSLMODE") && c.DB.SSLMode == "disable" {
+			c.DB.SSLMode = "require"
+		}
+	}
+}
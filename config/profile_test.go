@@ -0,0 +1,107 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// prodTLS are the listener certificates prod requires
+var prodTLS = map[string]string{
+	"HTTP_TLS_CERT_FILE": "/etc/tls/tls.crt", "HTTP_TLS_KEY_FILE": "/etc/tls/tls.key",
+	"GRPC_TLS_CERT_FILE": "/etc/tls/tls.crt", "GRPC_TLS_KEY_FILE": "/etc/tls/tls.key",
+}
+
+func TestLoad_Profiles(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		prod        bool
+		wantSSLMode string
+		wantCORS    []string
+		wantFormat  string
+	}{
+		{
+			name:        "dev by default",
+			wantSSLMode: "disable",
+			wantCORS:    []string{"*"},
+			wantFormat:  "console",
+		},
+		{
+			name:        "staging keeps the built-in defaults",
+			env:         map[string]string{"APP_ENV": "staging"},
+			wantSSLMode: "disable",
+			wantFormat:  "json",
+		},
+		{
+			name:        "prod requires TLS",
+			env:         map[string]string{"APP_ENV": "prod", "DB_PASSWORD": "s3cret"},
+			prod:        true,
+			wantSSLMode: "require",
+			wantFormat:  "json",
+		},
+		{
+			name:        "variables override the profile",
+			env:         map[string]string{"APP_ENV": "prod", "DB_PASSWORD": "s3cret", "DB_SSLMODE": "verify-full", "LOG_FORMAT": "console"},
+			prod:        true,
+			wantSSLMode: "verify-full",
+			wantFormat:  "console",
+		},
+		{
+			name:        "empty origins disable CORS in dev",
+			env:         map[string]string{"HTTP_CORS_ORIGINS": "", "LOG_FORMAT": "json"},
+			wantSSLMode: "disable",
+			wantFormat:  "json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			if tt.prod {
+				for k, v := range prodTLS {
+					t.Setenv(k, v)
+				}
+			}
+
+			cfg, err := Load()
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantSSLMode, cfg.DB.SSLMode)
+			assert.ElementsMatch(t, tt.wantCORS, cfg.HTTP.CORSOrigins)
+			assert.Equal(t, tt.wantFormat, cfg.Log.Format)
+		})
+	}
+}
+
+func TestLoad_ProdRejectsDevDefaults(t *testing.T) {
+	// Arrange
+	t.Setenv("APP_ENV", "prod")
+	t.Setenv("DB_SSLMODE", "disable")
+
+	// Act
+	_, err := Load()
+
+	// Assert
+	var verr *ValidationError
+	require.True(t, errors.As(err, &verr))
+	assert.ElementsMatch(t, []*FieldError{
+		{EnvVar: "DB_SSLMODE", Reason: "disable is not allowed in prod"},
+		{EnvVar: "DB_PASSWORD", Reason: `is the default "postgres", which is not allowed in prod`},
+		{EnvVar: "HTTP_TLS_CERT_FILE", Reason: "is required in prod"},
+		{EnvVar: "GRPC_TLS_CERT_FILE", Reason: "is required in prod"},
+	}, verr.Fields)
+}
+
+func TestConfig_IsProd(t *testing.T) {
+	for _, env := range envs {
+		cfg := &Config{Env: env}
+
+		assert.Equal(t, env == EnvProd, cfg.IsProd(), env)
+		assert.Equal(t, env == EnvDev, cfg.IsDev(), env)
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// defaultVHost is the vhost of an AMQP URI without a path
+const defaultVHost = "/"
+
+// BuildURL returns the AMQP URI of the broker, the first node of URL when
+// it lists several, see BuildURLs
+func (c RabbitMQConfig) BuildURL() string {
+	return c.BuildURLs()[0]
+}
+
+// BuildURLs returns the AMQP URIs of the broker nodes: those listed in URL,
+// separated by commas, when it is set, else one built from Host, Port, User,
+// Password and VHost. Credentials and the vhost are escaped, so that they
+// may hold characters like @, : or /.
+func (c RabbitMQConfig) BuildURLs() []string {
+	if urls := splitURLs(c.URL); len(urls) > 0 {
+		return urls
+	}
+
+	u := url.URL{
+		Scheme: "amqp",
+		User:   url.UserPassword(c.User, c.Password),
+		Host:   net.JoinHostPort(c.Host, c.Port),
+		Path:   "/",
+	}
+	// The default vhost is the bare "/", any other one is a single path
+	// segment with its own slashes escaped
+	if c.VHost != "" && c.VHost != defaultVHost {
+		u.Path += c.VHost
+		u.RawPath = "/" + url.PathEscape(c.VHost)
+	}
+	return []string{u.String()}
+}
+
+// splitURLs returns the non-empty URLs of a comma-separated list
+func splitURLs(list string) []string {
+	var urls []string
+	for _, u := range strings.Split(list, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
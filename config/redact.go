@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// Masking of secrets by Redacted: secrets of at least maskMinLength
+// characters keep their first maskKeep characters, enough to tell two
+// secrets apart without revealing them
+const (
+	maskKeep      = 2
+	maskMinLength = 8
+	maskFill      = "******"
+)
+
+// Redacted returns every setting keyed by its environment variable, as
+// resolved from the defaults, the config file and the environment. Secrets
+// are masked, "postgres" becoming "po******", and so are the passwords of
+// URLs, which keep their other parts. Lists are joined with commas and maps
+// written as sorted key:value pairs, as they are set.
+func (c Config) Redacted() map[string]string {
+	settings := map[string]string{}
+
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			tag, ok := f.Tag.Lookup("env")
+			if !ok {
+				if f.Type.Kind() == reflect.Struct {
+					walk(v.Field(i))
+				}
+				continue
+			}
+			// cleanenv accepts several comma-separated names, the first one is documented
+			name, _, _ := strings.Cut(tag, ",")
+			settings[strings.TrimSpace(name)] = formatSetting(v.Field(i), f.Tag.Get("secret") == "true")
+		}
+	}
+	walk(reflect.ValueOf(c))
+
+	return settings
+}
+
+// formatSetting writes the value of a field the way it is set, masked
+func formatSetting(v reflect.Value, secret bool) string {
+	redact := maskURLPassword
+	if secret {
+		redact = maskSecret
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return redact(v.String())
+	case reflect.Slice:
+		items := make([]string, v.Len())
+		for i := range items {
+			items[i] = formatSetting(v.Index(i), secret)
+		}
+		return strings.Join(items, ",")
+	case reflect.Map:
+		items := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			items = append(items, fmt.Sprint(k.Interface())+":"+formatSetting(v.MapIndex(k), secret))
+		}
+		slices.Sort(items)
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// maskSecret masks a secret. A URL keeps everything but its password, other
+// secrets, such as key=value DSNs, are masked as a whole.
+func maskSecret(s string) string {
+	// A list of URLs, such as the cluster nodes of RABBITMQ_URL, is masked
+	// URL by URL
+	if urls := splitURLs(s); len(urls) > 1 && !slices.ContainsFunc(urls, func(u string) bool { return !isURL(u) }) {
+		for i, u := range urls {
+			urls[i] = maskURLPassword(u)
+		}
+		return strings.Join(urls, ",")
+	}
+	if isURL(s) {
+		return maskURLPassword(s)
+	}
+	return mask(s)
+}
+
+// isURL reports whether s is an absolute URL with a host
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+// maskURLPassword masks the password of a URL, any other value is returned as is
+func maskURLPassword(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		return s
+	}
+	// The mask is written as is, url would escape its asterisks
+	u.User = url.UserPassword(u.User.Username(), redacted)
+	return strings.Replace(u.String(), ":"+redacted+"@", ":"+mask(password)+"@", 1)
+}
+
+// mask hides a secret, keeping the first characters of long ones
+func mask(s string) string {
+	if s == "" {
+		return ""
+	}
+	runes := []rune(s)
+	if len(runes) < maskMinLength {
+		return maskFill
+	}
+	return string(runes[:maskKeep]) + maskFill
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// fileSuffix names the variable holding the path of a secret's file, as
+// DB_PASSWORD_FILE for DB_PASSWORD
+const fileSuffix = "_FILE"
+
+// secretEnvVars collects the env names of the fields tagged secret:"true"
+func secretEnvVars(t reflect.Type) []string {
+	var names []string
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if tag, ok := f.Tag.Lookup("env"); ok && f.Tag.Get("secret") == "true" {
+				for _, name := range strings.Split(tag, ",") {
+					names = append(names, strings.TrimSpace(name))
+				}
+			}
+			if f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+			}
+		}
+	}
+	walk(t)
+
+	return names
+}
+
+// readSecretFiles sets each secret variable from the file named by its
+// _FILE variant, so that secrets mounted as files stay out of the
+// environment given to the process. A variable set both ways is an error,
+// rather than one silently winning. Trailing newlines, which editors and
+// kubectl leave in files, are trimmed.
+func readSecretFiles() error {
+	var errs []error
+	for _, name := range secretEnvVars(reflect.TypeOf(Config{})) {
+		path := os.Getenv(name + fileSuffix)
+		if path == "" {
+			continue
+		}
+		if os.Getenv(name) != "" {
+			errs = append(errs, fmt.Errorf("%s and %s%s are both set, unset one of them", name, name, fileSuffix))
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s%s: %w", name, fileSuffix, err))
+			continue
+		}
+		if err := os.Setenv(name, strings.TrimRight(string(data), "\r\n")); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		// The variable now holds the secret, so that loading the config
+		// again does not find it set both ways
+		_ = os.Unsetenv(name + fileSuffix)
+	}
+	return errors.Join(errs...)
+}
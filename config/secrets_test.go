@@ -0,0 +1,124 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSecretFile writes content to a file in a temporary directory
+func writeSecretFile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+// setSecretFile points the _FILE variant of name at a file holding content.
+// name is cleared too, so that the value read from the file does not leak
+// into other tests.
+func setSecretFile(t *testing.T, name, content string) {
+	t.Helper()
+
+	t.Setenv(name, "")
+	t.Setenv(name+fileSuffix, writeSecretFile(t, content))
+}
+
+func TestLoad_SecretFiles(t *testing.T) {
+	// Arrange
+	setSecretFile(t, "DB_PASSWORD", "db-secret\n")
+	setSecretFile(t, "RABBITMQ_PASSWORD", "mq-secret\r\n")
+	setSecretFile(t, "WEBHOOK_SECRETS", "stripe:whsec_x,acme:s3cret\n\n")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "db-secret", cfg.DB.Password)
+	assert.Equal(t, "mq-secret", cfg.RabbitMQ.Password)
+	assert.Equal(t, map[string]string{"stripe": "whsec_x", "acme": "s3cret"}, cfg.Webhooks.Secrets)
+}
+
+func TestLoad_SecretFileOverridesConfigFile(t *testing.T) {
+	// Arrange
+	path := writeConfigFile(t, "db:\n  password: from-yaml\n")
+	setSecretFile(t, "DB_PASSWORD", "from-file")
+
+	// Act
+	cfg, err := LoadFile(path)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "from-file", cfg.DB.Password)
+}
+
+func TestLoad_SecretSetTwice(t *testing.T) {
+	// Arrange
+	t.Setenv("DB_PASSWORD", "plain")
+	t.Setenv("DB_PASSWORD_FILE", writeSecretFile(t, "from-file"))
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	require.Error(t, err)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "DB_PASSWORD and DB_PASSWORD_FILE are both set")
+}
+
+func TestLoad_SecretFileMissing(t *testing.T) {
+	// Arrange
+	t.Setenv("RABBITMQ_PASSWORD", "")
+	t.Setenv("RABBITMQ_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	require.ErrorIs(t, err, os.ErrNotExist)
+	assert.Nil(t, cfg)
+	assert.Contains(t, err.Error(), "RABBITMQ_PASSWORD_FILE")
+}
+
+func TestLoad_StrictAcceptsSecretFiles(t *testing.T) {
+	// Arrange
+	t.Setenv("CONFIG_STRICT_IGNORE", strictIgnoreForEnviron())
+	t.Setenv("CONFIG_STRICT", "true")
+	setSecretFile(t, "DB_PASSWORD", "db-secret")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "db-secret", cfg.DB.Password)
+}
+
+func TestSecretEnvVars(t *testing.T) {
+	names := secretEnvVars(reflect.TypeOf(Config{}))
+
+	assert.ElementsMatch(t, []string{
+		"DB_DSN", "DB_PASSWORD", "RABBITMQ_URL", "RABBITMQ_PASSWORD", "REDIS_PASSWORD",
+		"USER_MUTATION_OVERRIDE_TOKEN", "USER_METADATA_KEYS", "WEBHOOK_SECRETS", "AUTH_JWT_SECRET",
+	}, names)
+}
+
+func TestLoad_SecretFileLoadedTwice(t *testing.T) {
+	// Arrange: SIGHUP loads the config again
+	setSecretFile(t, "DB_PASSWORD", "db-secret")
+	_, err := Load()
+	require.NoError(t, err)
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "db-secret", cfg.DB.Password)
+}
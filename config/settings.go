@@ -0,0 +1,400 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Setting is a variable a config field is read from, as its tags declare it
+type Setting struct {
+	EnvVar string
+	// Key is the path of the field in the config file, as http.port
+	Key string
+	// Section is the config group the setting belongs to, as HTTP, empty
+	// for top-level settings
+	Section string
+	// Type is how the value is written: string, int, float, bool, duration,
+	// list (comma-separated) or map (comma-separated key:value pairs)
+	Type       string
+	Default    string
+	HasDefault bool
+	Secret     bool
+}
+
+// Settings lists the setting of every config field in declaration order.
+// Config files and the environment cannot set anything else, except
+// SCHEDULER_TASK_<NAME>.
+func Settings() []Setting {
+	var settings []Setting
+
+	var walk func(t reflect.Type, section string, path []string)
+	walk = func(t reflect.Type, section string, path []string) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			key, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			tag, ok := f.Tag.Lookup("env")
+			if !ok {
+				if f.Type.Kind() == reflect.Struct && f.Type != reflect.TypeOf(time.Time{}) {
+					walk(f.Type, f.Name, append(slices.Clone(path), key))
+				}
+				continue
+			}
+
+			// cleanenv accepts several comma-separated names, the first one is documented
+			name, _, _ := strings.Cut(tag, ",")
+			def, hasDefault := f.Tag.Lookup("env-default")
+			settings = append(settings, Setting{
+				EnvVar:     strings.TrimSpace(name),
+				Key:        strings.Join(append(slices.Clone(path), key), "."),
+				Section:    section,
+				Type:       settingType(f.Type),
+				Default:    def,
+				HasDefault: hasDefault,
+				Secret:     f.Tag.Get("secret") == "true",
+			})
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "", nil)
+
+	return settings
+}
+
+func settingType(t reflect.Type) string {
+	switch {
+	case t == reflect.TypeOf(time.Duration(0)):
+		return "duration"
+	case t.Kind() == reflect.Slice:
+		return "list"
+	case t.Kind() == reflect.Map:
+		return "map"
+	case t.Kind() == reflect.Bool:
+		return "bool"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return "float"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return "int"
+	default:
+		return "string"
+	}
+}
+
+// Profiles of sample configurations, see WriteSample
+const (
+	SampleDev    = "dev"
+	SampleDocker = "docker"
+	SampleProd   = "prod"
+)
+
+// SampleProfiles are the profiles WriteSample accepts
+var SampleProfiles = []string{SampleDev, SampleDocker, SampleProd}
+
+// sampleOverrides are the values of each sample profile that differ from
+// the defaults. Prod samples also leave every secret empty, to be set.
+var sampleOverrides = map[string]map[string]string{
+	SampleDev: {
+		"APP_ENV":       EnvDev,
+		"LOG_LEVEL":     "debug",
+		"LOG_FORMAT":    "console",
+		"CONFIG_STRICT": "true",
+	},
+	// Dependencies are the services of a compose file, and probes are
+	// reachable from the other containers
+	SampleDocker: {
+		"APP_ENV":               EnvDev,
+		"LOG_FORMAT":            "console",
+		"HTTP_INTERNAL_ADDRESS": "0.0.0.0",
+		"HTTP_INTERNAL_PORT":    "8081",
+		"DB_HOST":               "postgres",
+		"RABBITMQ_HOST":         "rabbitmq",
+		"REDIS_ADDR":            "redis:6379",
+	},
+	SampleProd: {
+		"APP_ENV":            EnvProd,
+		"DB_SSLMODE":         "require",
+		"HTTP_INTERNAL_PORT": "8081",
+		"CONFIG_STRICT":      "true",
+	},
+}
+
+// sampleValue returns the value of a setting in a sample profile
+func sampleValue(s Setting, profile string) string {
+	if v, ok := sampleOverrides[profile][s.EnvVar]; ok {
+		return v
+	}
+	if profile == SampleProd && s.Secret {
+		return ""
+	}
+	return s.Default
+}
+
+// WriteSample writes a configuration holding every setting with the
+// values of the profile, commented with its type, its default and whether
+// it is a secret. format is env, for a .env file, or yaml.
+func WriteSample(w io.Writer, profile, format string) error {
+	if !slices.Contains(SampleProfiles, profile) {
+		return fmt.Errorf("unknown profile %q, valid profiles are %s", profile, strings.Join(SampleProfiles, ", "))
+	}
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# Configuration of the %s profile, generated by cli config init\n", profile)
+	switch format {
+	case "env":
+		writeEnvSample(bw, profile)
+	case "yaml":
+		writeYAMLSample(bw, profile)
+	default:
+		return fmt.Errorf("unknown format %q, valid formats are env, yaml", format)
+	}
+	return bw.Flush()
+}
+
+// describe returns the comment of a setting
+func describe(s Setting, profile string) string {
+	var b strings.Builder
+	b.WriteString(s.Type)
+	switch {
+	case s.HasDefault && s.Default != "":
+		fmt.Fprintf(&b, ", default %s", s.Default)
+	default:
+		b.WriteString(", empty by default")
+	}
+	if s.Secret {
+		b.WriteString(", secret: may be read from the file named by " + s.EnvVar + fileSuffix)
+		if profile == SampleProd {
+			b.WriteString(", set it")
+		}
+	}
+	return b.String()
+}
+
+func writeEnvSample(w io.Writer, profile string) {
+	section := "-"
+	for _, s := range Settings() {
+		if s.Section != section {
+			section = s.Section
+			fmt.Fprintf(w, "\n# %s\n", sectionTitle(section))
+		}
+		fmt.Fprintf(w, "# %s\n", describe(s, profile))
+		v := sampleValue(s, profile)
+		if v == "" {
+			// Left unset, an empty value is not the zero value of every type
+			fmt.Fprintf(w, "# %s=\n", s.EnvVar)
+			continue
+		}
+		fmt.Fprintf(w, "%s=%s\n", s.EnvVar, envQuote(v))
+	}
+}
+
+// envQuote quotes values godotenv would not read back as they are
+func envQuote(v string) string {
+	if strings.ContainsAny(v, " #'\"\\") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+func writeYAMLSample(w io.Writer, profile string) {
+	section := "-"
+	indent := ""
+	for _, s := range Settings() {
+		if s.Section != section {
+			section = s.Section
+			fmt.Fprintln(w)
+			if prefix, _, ok := strings.Cut(s.Key, "."); ok {
+				fmt.Fprintf(w, "%s:\n", prefix)
+				indent = "  "
+			} else {
+				fmt.Fprintf(w, "# %s\n", sectionTitle(section))
+				indent = ""
+			}
+		}
+		if s.EnvVar == "CONFIG_PATH" {
+			// Only read from the environment, it names the file
+			continue
+		}
+		_, key, ok := strings.Cut(s.Key, ".")
+		if !ok {
+			key = s.Key
+		}
+		fmt.Fprintf(w, "%s# %s (%s)\n", indent, describe(s, profile), s.EnvVar)
+		v := sampleValue(s, profile)
+		if v == "" {
+			fmt.Fprintf(w, "%s# %s:\n", indent, key)
+			continue
+		}
+		fmt.Fprintf(w, "%s%s: %s\n", indent, key, yamlValue(s, v))
+	}
+}
+
+// yamlValue writes a value the way the field is decoded from YAML
+func yamlValue(s Setting, v string) string {
+	switch s.Type {
+	case "int", "float", "bool":
+		return v
+	case "list":
+		var items []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, strconv.Quote(item))
+			}
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case "map":
+		var items []string
+		for _, pair := range strings.Split(v, ",") {
+			if k, val, ok := strings.Cut(strings.TrimSpace(pair), ":"); ok {
+				items = append(items, strconv.Quote(k)+": "+strconv.Quote(val))
+			}
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	default:
+		return strconv.Quote(v)
+	}
+}
+
+func sectionTitle(section string) string {
+	if section == "" {
+		return "General"
+	}
+	return section
+}
+
+// Sources of a setting, see Sources
+const (
+	SourceDefault    = "default"
+	SourceProfile    = "profile"
+	SourceFile       = "file"
+	SourceDotEnv     = ".env"
+	SourceSecretFile = "secret file"
+	SourceEnv        = "env"
+)
+
+// Sources returns the layer the value of every setting of a loaded config
+// came from, keyed by variable: the environment of the process (environ in
+// os.Environ form, taken before Load read the .env file), a secret file
+// named by a _FILE variable, the .env file (dotenv), the config file, the
+// APP_ENV profile or the default
+func (c Config) Sources(environ []string, dotenv map[string]string) (map[string]string, error) {
+	env := make(map[string]bool, len(environ))
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		env[name] = true
+	}
+
+	var file map[string]interface{}
+	if c.Path != "" {
+		raw, err := os.ReadFile(c.Path)
+		if err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+		if err := yaml.Unmarshal(raw, &file); err != nil {
+			return nil, fmt.Errorf("config file: %w", err)
+		}
+	}
+
+	values := c.Redacted()
+	defaults := defaultConfig().Redacted()
+	sources := make(map[string]string, len(values))
+	for _, s := range Settings() {
+		_, inDotEnv := dotenv[s.EnvVar]
+		_, fileInDotEnv := dotenv[s.EnvVar+fileSuffix]
+		switch {
+		// Setting both is refused by Load, unless the variable is empty
+		case s.Secret && (env[s.EnvVar+fileSuffix] || fileInDotEnv):
+			sources[s.EnvVar] = SourceSecretFile
+		case env[s.EnvVar]:
+			sources[s.EnvVar] = SourceEnv
+		case inDotEnv:
+			sources[s.EnvVar] = SourceDotEnv
+		case inYAML(file, s.Key):
+			sources[s.EnvVar] = SourceFile
+		case values[s.EnvVar] != defaults[s.EnvVar]:
+			// Nothing set it, applyProfile did
+			sources[s.EnvVar] = SourceProfile
+		default:
+			sources[s.EnvVar] = SourceDefault
+		}
+	}
+	return sources, nil
+}
+
+// defaultConfig returns the config holding the env-default of every field
+func defaultConfig() Config {
+	var cfg Config
+
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if _, ok := f.Tag.Lookup("env"); !ok {
+				if f.Type.Kind() == reflect.Struct {
+					walk(v.Field(i))
+				}
+				continue
+			}
+			if def, ok := f.Tag.Lookup("env-default"); ok {
+				setDefault(v.Field(i), def)
+			}
+		}
+	}
+	walk(reflect.ValueOf(&cfg).Elem())
+
+	return cfg
+}
+
+// setDefault parses an env-default into a field, as cleanenv does. Defaults
+// are checked by the tests, a malformed one is left unset.
+func setDefault(v reflect.Value, def string) {
+	switch {
+	case v.Type() == reflect.TypeOf(time.Duration(0)):
+		if d, err := time.ParseDuration(def); err == nil {
+			v.SetInt(int64(d))
+		}
+	case v.Kind() == reflect.String:
+		v.SetString(def)
+	case v.Kind() == reflect.Bool:
+		if b, err := strconv.ParseBool(def); err == nil {
+			v.SetBool(b)
+		}
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		if n, err := strconv.ParseInt(def, 10, 64); err == nil {
+			v.SetInt(n)
+		}
+	case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64:
+		if n, err := strconv.ParseUint(def, 10, 64); err == nil {
+			v.SetUint(n)
+		}
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		if f, err := strconv.ParseFloat(def, 64); err == nil {
+			v.SetFloat(f)
+		}
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String && def != "":
+		items := strings.Split(def, ",")
+		v.Set(reflect.ValueOf(items).Convert(v.Type()))
+	}
+}
+
+// inYAML reports whether the dotted key is set in a decoded config file
+func inYAML(file map[string]interface{}, key string) bool {
+	node := interface{}(file)
+	for _, part := range strings.Split(key, ".") {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if node, ok = m[part]; !ok {
+			return false
+		}
+	}
+	return true
+}
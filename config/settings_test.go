@@ -0,0 +1,185 @@
+package config
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withoutDefault are the settings that are empty unless set, a field added
+// without env-default has to be listed here on purpose
+var withoutDefault = []string{
+	// Optional features, disabled when empty
+	"HTTP_INTERNAL_PORT", "HTTP_SHED_SOFT_LIMIT", "HTTP_SHED_HARD_LIMIT", "HTTP_SHED_P99",
+	"LOG_FILE", "WIDE_EVENTS_SINK", "WIDE_EVENTS_FIELDS",
+	"DB_REPLICA_HOST", "REDIS_ADDR", "USER_MUTATION_OVERRIDE_TOKEN", "WORKER_PARTITIONS", "WORKER_HEALTH_PORT",
+	"RABBITMQ_TENANTS", "USER_SENSITIVE_METADATA",
+	// Timeouts falling back to the server defaults
+	"HTTP_READ_TIMEOUT", "HTTP_WRITE_TIMEOUT", "HTTP_IDLE_TIMEOUT", "HTTP_READ_HEADER_TIMEOUT",
+	// CORS is closed until origins are allowed, the others follow from them
+	"HTTP_CORS_ORIGINS", "HTTP_CORS_METHODS", "HTTP_CORS_HEADERS",
+	// Overrides of settings built from the others
+	"DB_DSN", "RABBITMQ_URL", "USER_IMPORT_DIR",
+	// TLS files, only with the sslmodes needing them
+	"DB_SSLROOTCERT", "DB_SSLCERT", "DB_SSLKEY",
+	// Listener certificates, plaintext without them outside prod
+	"HTTP_TLS_CERT_FILE", "HTTP_TLS_KEY_FILE", "GRPC_TLS_CERT_FILE", "GRPC_TLS_KEY_FILE",
+	// Secrets, never defaulted
+	"REDIS_PASSWORD", "USER_METADATA_KEYS", "USER_METADATA_KEY_ID", "WEBHOOK_SECRETS", "AUTH_JWT_SECRET",
+	// Collation of the database when empty
+	"USER_COLLATION",
+	// Maps defaulted per key
+	"LIMITS_EXPENSIVE_CONCURRENCY_BY_ROLE", "LIMITS_GROUP_CONCURRENCY", "WEBHOOK_SIGNATURE_HEADERS", "WEBHOOK_TIMESTAMP_HEADERS", "WEBHOOK_ALGORITHMS",
+	// Read by the loader itself
+	"CONFIG_STRICT_IGNORE", "CONFIG_PATH",
+}
+
+func TestSettings_FollowStructTags(t *testing.T) {
+	// Arrange
+	settings := Settings()
+	names := make([]string, 0, len(settings))
+	for _, s := range settings {
+		names = append(names, s.EnvVar)
+	}
+
+	// Assert: the settings are the variables Redacted lists
+	assert.ElementsMatch(t, names, slices.Collect(maps.Keys(validConfig().Redacted())))
+	assert.Subset(t, names, secretEnvVars(reflect.TypeOf(Config{})))
+	for _, s := range settings {
+		if s.Secret {
+			assert.NotContains(t, s.Default, "@", "%s has a default credential", s.EnvVar)
+		}
+	}
+}
+
+func TestSettings_Defaults(t *testing.T) {
+	for _, s := range Settings() {
+		if slices.Contains(withoutDefault, s.EnvVar) {
+			assert.False(t, s.HasDefault, "%s has a default, remove it from withoutDefault", s.EnvVar)
+			continue
+		}
+		assert.True(t, s.HasDefault, "%s has no env-default, add one or list it in withoutDefault", s.EnvVar)
+	}
+}
+
+func TestSettings_Keys(t *testing.T) {
+	settings := map[string]Setting{}
+	for _, s := range Settings() {
+		settings[s.EnvVar] = s
+	}
+
+	assert.Equal(t, Setting{EnvVar: "HTTP_PORT", Key: "http.port", Section: "HTTP", Type: "string", Default: "8080", HasDefault: true}, settings["HTTP_PORT"])
+	assert.Equal(t, "duration", settings["AUTH_ACCESS_TOKEN_TTL"].Type)
+	assert.Equal(t, "map", settings["WEBHOOK_SECRETS"].Type)
+	assert.True(t, settings["DB_PASSWORD"].Secret)
+	assert.Equal(t, "env", settings["APP_ENV"].Key)
+}
+
+func TestWriteSample_Env(t *testing.T) {
+	for _, profile := range SampleProfiles {
+		t.Run(profile, func(t *testing.T) {
+			// Act
+			var out bytes.Buffer
+			require.NoError(t, WriteSample(&out, profile, "env"))
+			values, err := godotenv.Unmarshal(out.String())
+
+			// Assert: every setting is written, set or commented out
+			require.NoError(t, err)
+			for _, s := range Settings() {
+				if v := sampleValue(s, profile); v != "" {
+					assert.Equal(t, v, values[s.EnvVar], s.EnvVar)
+				} else {
+					assert.Contains(t, out.String(), "# "+s.EnvVar+"=\n")
+				}
+			}
+		})
+	}
+}
+
+func TestWriteSample_ProdLeavesSecretsUnset(t *testing.T) {
+	// Act
+	var out bytes.Buffer
+	require.NoError(t, WriteSample(&out, SampleProd, "env"))
+
+	// Assert
+	assert.Contains(t, out.String(), "# DB_PASSWORD=\n")
+	assert.Contains(t, out.String(), "# string, default postgres, secret: may be read from the file named by DB_PASSWORD_FILE, set it\n")
+	assert.Contains(t, out.String(), "\nDB_SSLMODE=require\n")
+}
+
+func TestWriteSample_YAMLLoads(t *testing.T) {
+	t.Setenv("CONFIG_STRICT_IGNORE", strictIgnoreForEnviron())
+
+	for _, profile := range []string{SampleDev, SampleDocker} {
+		t.Run(profile, func(t *testing.T) {
+			// Arrange
+			var out bytes.Buffer
+			require.NoError(t, WriteSample(&out, profile, "yaml"))
+			path := writeConfigFile(t, out.String())
+
+			// Act
+			cfg, err := LoadFile(path)
+
+			// Assert
+			require.NoError(t, err, out.String())
+			assert.Equal(t, EnvDev, cfg.Env)
+			assert.Equal(t, []string{"/metrics"}, cfg.HTTP.AccessLogExclude)
+			if profile == SampleDocker {
+				assert.Equal(t, "postgres", cfg.DB.Host)
+				assert.Equal(t, "redis:6379", cfg.Redis.Addr)
+			}
+		})
+	}
+}
+
+func TestWriteSample_Unknown(t *testing.T) {
+	assert.EqualError(t, WriteSample(&bytes.Buffer{}, "staging", "env"), `unknown profile "staging", valid profiles are dev, docker, prod`)
+	assert.EqualError(t, WriteSample(&bytes.Buffer{}, SampleDev, "toml"), `unknown format "toml", valid formats are env, yaml`)
+}
+
+func TestConfig_Sources(t *testing.T) {
+	// Arrange
+	path := writeConfigFile(t, "http:\n  port: \"9000\"\ndb:\n  sslmode: disable\n")
+	t.Setenv("APP_ENV", EnvDev)
+	t.Setenv("HTTP_ADDRESS", "127.0.0.1")
+	t.Setenv("LOG_LEVEL", "debug")
+	setSecretFile(t, "DB_PASSWORD", "from-file")
+	environ := os.Environ()
+	dotenv := map[string]string{"LOG_LEVEL": "info", "GRPC_PORT": "9091"}
+
+	cfg, err := LoadFile(path)
+	require.NoError(t, err)
+
+	// Act
+	sources, err := cfg.Sources(environ, dotenv)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, SourceEnv, sources["HTTP_ADDRESS"])
+	assert.Equal(t, SourceEnv, sources["LOG_LEVEL"], "the environment overrides .env")
+	assert.Equal(t, SourceDotEnv, sources["GRPC_PORT"])
+	assert.Equal(t, SourceSecretFile, sources["DB_PASSWORD"])
+	assert.Equal(t, SourceFile, sources["HTTP_PORT"])
+	assert.Equal(t, SourceFile, sources["DB_SSLMODE"], "set to its default, but by the file")
+	assert.Equal(t, SourceProfile, sources["HTTP_CORS_ORIGINS"])
+	assert.Equal(t, SourceDefault, sources["AUTH_ACCESS_TOKEN_TTL"])
+	assert.Equal(t, SourceDefault, sources["HTTP_ACCESS_LOG_EXCLUDE"])
+	assert.Len(t, sources, len(Settings()))
+}
+
+func TestDefaultConfig(t *testing.T) {
+	cfg := defaultConfig()
+
+	assert.Equal(t, EnvDev, cfg.Env)
+	assert.Equal(t, "8080", cfg.HTTP.Port)
+	assert.Equal(t, validConfig().Auth.AccessTokenTTL, cfg.Auth.AccessTokenTTL)
+	assert.Equal(t, "disable", cfg.DB.SSLMode)
+	assert.Empty(t, cfg.HTTP.CORSOrigins)
+}
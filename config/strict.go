@@ -0,0 +1,192 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// maxSuggestionDistance is the largest edit distance for which a known
+// variable is suggested in place of an unknown one
+const maxSuggestionDistance = 3
+
+// foreignEnvVars are read by libraries and share a prefix with ours
+var foreignEnvVars = []string{
+	"GRPC_GO_*",
+	"GRPC_XDS_*",
+	"GRPC_DEFAULT_SSL_ROOTS_FILE_PATH",
+	"GRPC_TRACE",
+	"GRPC_VERBOSITY",
+}
+
+// UnknownEnvVar is an environment variable that no config field reads
+type UnknownEnvVar struct {
+	Name string
+	// Suggestion is the closest known variable, empty when none is close
+	Suggestion string
+}
+
+// UnknownEnvError is returned by Load in strict mode when the environment
+// sets variables with one of our prefixes that no config field reads
+type UnknownEnvError struct {
+	Vars []UnknownEnvVar
+}
+
+func (e *UnknownEnvError) Error() string {
+	names := make([]string, 0, len(e.Vars))
+	for _, v := range e.Vars {
+		if v.Suggestion != "" {
+			names = append(names, fmt.Sprintf("%s (did you mean %s?)", v.Name, v.Suggestion))
+			continue
+		}
+		names = append(names, v.Name)
+	}
+	return "unknown environment variables: " + strings.Join(names, ", ")
+}
+
+// checkUnknownEnv reports variables of environ, in os.Environ form, whose
+// prefix belongs to the config but whose name matches no field and is not
+// ignored
+func checkUnknownEnv(environ, ignore []string) error {
+	known := knownEnvVars(reflect.TypeOf(Config{}))
+	prefixes := envPrefixes(known)
+
+	var unknown []UnknownEnvVar
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if _, ok := known[name]; ok || !hasAnyPrefix(name, prefixes) || ignored(name, ignore) {
+			continue
+		}
+		unknown = append(unknown, UnknownEnvVar{Name: name, Suggestion: closestEnvVar(name, known)})
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Name < unknown[j].Name })
+	return &UnknownEnvError{Vars: unknown}
+}
+
+// ignored reports whether name matches one of the patterns, a trailing *
+// matching any suffix
+func ignored(name string, patterns []string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+			continue
+		}
+		if name == p {
+			return true
+		}
+	}
+	return false
+}
+
+// knownEnvVars collects the env tags of t and its nested structs, with the
+// _FILE variants of secrets
+func knownEnvVars(t reflect.Type) map[string]struct{} {
+	known := map[string]struct{}{}
+
+	var walk func(t reflect.Type)
+	walk = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if tag, ok := f.Tag.Lookup("env"); ok {
+				// cleanenv accepts several comma-separated names
+				for _, name := range strings.Split(tag, ",") {
+					known[strings.TrimSpace(name)] = struct{}{}
+				}
+			}
+			if f.Type.Kind() == reflect.Struct {
+				walk(f.Type)
+			}
+		}
+	}
+	walk(t)
+	for _, name := range secretEnvVars(t) {
+		known[name+fileSuffix] = struct{}{}
+	}
+
+	return known
+}
+
+// envPrefixes returns the first segments of the known names, such as "DB_"
+func envPrefixes(known map[string]struct{}) []string {
+	seen := map[string]struct{}{}
+	var prefixes []string
+	for name := range known {
+		head, _, ok := strings.Cut(name, "_")
+		if !ok {
+			continue
+		}
+		if _, dup := seen[head]; dup {
+			continue
+		}
+		seen[head] = struct{}{}
+		prefixes = append(prefixes, head+"_")
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestEnvVar returns the known name nearest to name, ties broken
+// alphabetically, or "" when none is within maxSuggestionDistance
+func closestEnvVar(name string, known map[string]struct{}) string {
+	best, bestDistance := "", maxSuggestionDistance+1
+	for candidate := range known {
+		d := levenshtein(name, candidate)
+		if d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	if bestDistance > maxSuggestionDistance {
+		return ""
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// checkStrict fails on unknown variables when strict mode is enabled
+func checkStrict(cfg *Config) error {
+	if !cfg.Strict {
+		return nil
+	}
+	// Task names are checked by the scheduler
+	return checkUnknownEnv(os.Environ(), slices.Concat(foreignEnvVars, []string{taskEnvPrefix + "*"}, cfg.StrictIgnore))
+}
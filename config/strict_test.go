@@ -0,0 +1,122 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad_StrictRejectsMisspelledVariable(t *testing.T) {
+	// Arrange
+	t.Setenv("CONFIG_STRICT", "true")
+	t.Setenv("DB_PASWORD", "secret")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	require.Error(t, err)
+	assert.Nil(t, cfg)
+
+	var unknownErr *UnknownEnvError
+	require.ErrorAs(t, err, &unknownErr)
+	assert.Contains(t, unknownErr.Vars, UnknownEnvVar{Name: "DB_PASWORD", Suggestion: "DB_PASSWORD"})
+	assert.Contains(t, err.Error(), "DB_PASWORD (did you mean DB_PASSWORD?)")
+}
+
+func TestLoad_NotStrictIgnoresUnknownVariable(t *testing.T) {
+	// Arrange
+	t.Setenv("DB_PASWORD", "secret")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	require.NoError(t, err)
+	assert.False(t, cfg.Strict)
+}
+
+func TestLoad_StrictIgnoresListedVariables(t *testing.T) {
+	// Arrange
+	t.Setenv("CONFIG_STRICT_IGNORE", strictIgnoreForEnviron()+",DB_PASW*")
+	t.Setenv("CONFIG_STRICT", "true")
+	t.Setenv("DB_PASWORD", "secret")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	require.NoError(t, err)
+	assert.True(t, cfg.Strict)
+}
+
+func TestLoad_StrictAcceptsTaskSchedules(t *testing.T) {
+	// Arrange
+	t.Setenv("CONFIG_STRICT_IGNORE", strictIgnoreForEnviron())
+	t.Setenv("CONFIG_STRICT", "true")
+	t.Setenv("SCHEDULER_TASK_HOURLY", "disabled")
+
+	// Act
+	cfg, err := Load()
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "disabled", cfg.Scheduler.Tasks["hourly"])
+}
+
+func TestCheckUnknownEnv(t *testing.T) {
+	// Arrange
+	environ := []string{
+		"PATH=/usr/bin",
+		"HOME=/root",
+		"DB_HOST=localhost",
+		"RABBITMQ_RECONECT_DELAY=2s",
+		"HTTP_SOMETHING_ELSE_ENTIRELY=1",
+		"WORKER_PREFETCH=20",
+		"GRPC_GO_LOG_SEVERITY_LEVEL=info",
+		"USER_TYPE=admin",
+	}
+
+	// Act
+	err := checkUnknownEnv(environ, append(foreignEnvVars, "USER_TYPE"))
+
+	// Assert
+	var unknownErr *UnknownEnvError
+	require.ErrorAs(t, err, &unknownErr)
+	assert.Equal(t, []UnknownEnvVar{
+		{Name: "HTTP_SOMETHING_ELSE_ENTIRELY"},
+		{Name: "RABBITMQ_RECONECT_DELAY", Suggestion: "RABBITMQ_RECONNECT_DELAY"},
+	}, unknownErr.Vars)
+	assert.NoError(t, checkUnknownEnv([]string{"DB_HOST=localhost", "GOPATH=/go"}, nil))
+	assert.EqualError(t, err, "unknown environment variables: HTTP_SOMETHING_ELSE_ENTIRELY, "+
+		"RABBITMQ_RECONECT_DELAY (did you mean RABBITMQ_RECONNECT_DELAY?)")
+}
+
+func TestEnvPrefixesFollowStructTags(t *testing.T) {
+	// Act
+	prefixes := envPrefixes(knownEnvVars(reflect.TypeOf(Config{})))
+
+	// Assert
+	assert.Subset(t, prefixes, []string{"CONFIG_", "DB_", "GRPC_", "HTTP_", "RABBITMQ_", "WORKER_"})
+	assert.NotContains(t, prefixes, "PATH_")
+}
+
+// strictIgnoreForEnviron lists the variables the test machine already sets
+// with one of our prefixes, so that Load tests do not depend on it
+func strictIgnoreForEnviron() string {
+	var unknownErr *UnknownEnvError
+	if !errors.As(checkUnknownEnv(os.Environ(), foreignEnvVars), &unknownErr) {
+		return ""
+	}
+
+	names := make([]string, 0, len(unknownErr.Vars))
+	for _, v := range unknownErr.Vars {
+		names = append(names, v.Name)
+	}
+	return strings.Join(names, ",")
+}
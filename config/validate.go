@@ -0,0 +1,397 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sslModes are the DB_SSLMODE values lib/pq accepts
+var sslModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// logLevels are the LOG_LEVEL values
+var logLevels = []string{"trace", "debug", "info", "warn", "error"}
+
+// Log formats selected by LOG_FORMAT
+const (
+	LogFormatJSON    = "json"
+	LogFormatConsole = "console"
+)
+
+// logFormats are the LOG_FORMAT values
+var logFormats = []string{LogFormatJSON, LogFormatConsole}
+
+// routeGroups are the route groups of LIMITS_GROUP_CONCURRENCY
+var routeGroups = []string{"bulk", "export"}
+
+// idVersions are the DB_ID_VERSION values
+var idVersions = []int{4, 7}
+
+// trailingSlashPolicies are the HTTP_TRAILING_SLASH values
+var trailingSlashPolicies = []string{"redirect", "rewrite"}
+
+// minJWTSecretSize is the shortest AUTH_JWT_SECRET, auth.MinSecretSize
+const minJWTSecretSize = 32
+
+// FieldError is a config field holding an invalid value
+type FieldError struct {
+	// EnvVar is the environment variable the field is read from
+	EnvVar string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return e.EnvVar + ": " + e.Reason
+}
+
+// ValidationError is returned by Validate and Load with every invalid
+// field, so that all of them can be fixed in one pass
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	reasons := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		reasons = append(reasons, f.Error())
+	}
+	return "invalid configuration: " + strings.Join(reasons, "; ")
+}
+
+// Unwrap returns the field errors, so errors.As finds each of them
+func (e *ValidationError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		errs = append(errs, f)
+	}
+	return errs
+}
+
+// Validate checks the values that would otherwise only fail later as
+// obscure connection errors, and reports all invalid fields at once
+func (c *Config) Validate() error {
+	var fields []*FieldError
+	invalid := func(envVar, format string, args ...interface{}) {
+		fields = append(fields, &FieldError{EnvVar: envVar, Reason: fmt.Sprintf(format, args...)})
+	}
+	// Listeners may ask for any free port with 0, connections may not
+	port := func(envVar, value string, lowest int) {
+		if reason := checkPort(value, lowest); reason != "" {
+			invalid(envVar, "%s", reason)
+		}
+	}
+
+	if !slices.Contains(envs, c.Env) {
+		invalid("APP_ENV", "%q is not one of %s", c.Env, strings.Join(envs, ", "))
+	}
+
+	if !slices.Contains(logLevels, c.Log.Level) {
+		invalid("LOG_LEVEL", "%q is not one of %s", c.Log.Level, strings.Join(logLevels, ", "))
+	}
+	if !slices.Contains(logFormats, c.Log.Format) {
+		invalid("LOG_FORMAT", "%q is not one of %s", c.Log.Format, strings.Join(logFormats, ", "))
+	}
+	if c.Log.FileMaxSizeMB < 0 {
+		invalid("LOG_FILE_MAX_SIZE_MB", "%d is negative", c.Log.FileMaxSizeMB)
+	}
+	if c.Log.FileMaxBackups < 0 {
+		invalid("LOG_FILE_MAX_BACKUPS", "%d is negative", c.Log.FileMaxBackups)
+	}
+
+	if c.WideEvents.Sink != "" && (c.WideEvents.SampleRate <= 0 || c.WideEvents.SampleRate > 1) {
+		invalid("WIDE_EVENTS_SAMPLE_RATE", "%g is not in (0, 1]", c.WideEvents.SampleRate)
+	}
+
+	if !c.HTTP.Enabled && !c.GRPC.Enabled {
+		invalid("GRPC_ENABLED", "cannot be false with HTTP_ENABLED=false, at least one transport is required")
+	}
+	// A certificate goes with its key, and listeners reachable from outside
+	// the pod only serve TLS in prod
+	tls := func(prefix, certFile, keyFile string) {
+		switch {
+		case certFile != "" && keyFile == "":
+			invalid(prefix+"_TLS_KEY_FILE", "is required with %s_TLS_CERT_FILE", prefix)
+		case keyFile != "" && certFile == "":
+			invalid(prefix+"_TLS_CERT_FILE", "is required with %s_TLS_KEY_FILE", prefix)
+		case certFile == "" && c.IsProd():
+			invalid(prefix+"_TLS_CERT_FILE", "is required in prod")
+		}
+	}
+	if c.HTTP.Enabled {
+		port("HTTP_PORT", c.HTTP.Port, 0)
+		tls("HTTP", c.HTTP.TLSCertFile, c.HTTP.TLSKeyFile)
+	}
+	if c.HTTP.InternalPort != "" {
+		port("HTTP_INTERNAL_PORT", c.HTTP.InternalPort, 0)
+	}
+	if c.GRPC.Enabled {
+		port("GRPC_PORT", c.GRPC.Port, 0)
+		tls("GRPC", c.GRPC.TLSCertFile, c.GRPC.TLSKeyFile)
+		if c.GRPC.MaxMetadataSize < 1 {
+			invalid("GRPC_MAX_METADATA_SIZE", "%d is not positive", c.GRPC.MaxMetadataSize)
+		}
+	}
+
+	durations := []struct {
+		envVar string
+		value  time.Duration
+	}{
+		{"HTTP_READ_TIMEOUT", c.HTTP.ReadTimeout},
+		{"HTTP_WRITE_TIMEOUT", c.HTTP.WriteTimeout},
+		{"HTTP_IDLE_TIMEOUT", c.HTTP.IdleTimeout},
+		{"HTTP_READ_HEADER_TIMEOUT", c.HTTP.ReadHeaderTimeout},
+		{"HTTP_CORS_MAX_AGE", c.HTTP.CORSMaxAge},
+		{"REDIS_DIAL_TIMEOUT", c.Redis.DialTimeout},
+		{"WORKER_SHUTDOWN_TIMEOUT", c.Worker.ShutdownTimeout},
+		{"WORKER_SHUTDOWN_GRACE", c.Worker.ShutdownGrace},
+		{"WORKER_SHUTDOWN_NACK_MARGIN", c.Worker.ShutdownNackMargin},
+		{"DB_EXPLAIN_MIN_DURATION", c.DB.ExplainMinDuration},
+		{"LIMITS_GROUP_WAIT", c.Limits.GroupWait},
+		{"CACHE_USER_STALE_GRACE", c.Cache.UserStaleGrace},
+		{"USER_SEEN_INTERVAL", c.Users.SeenInterval},
+	}
+	for _, d := range durations {
+		if d.value < 0 {
+			invalid(d.envVar, "%s is negative", d.value)
+		}
+	}
+
+	if c.HTTP.CORSAllowCredentials && slices.Contains(c.HTTP.CORSOrigins, "*") {
+		invalid("HTTP_CORS_ALLOW_CREDENTIALS", "cannot allow any origin, list the origins in HTTP_CORS_ORIGINS instead of *")
+	}
+
+	if !slices.Contains(trailingSlashPolicies, c.HTTP.TrailingSlash) {
+		invalid("HTTP_TRAILING_SLASH", "%q is not one of %s", c.HTTP.TrailingSlash, strings.Join(trailingSlashPolicies, ", "))
+	}
+
+	// Port 0 asks for any free port, so it cannot collide. Disabled
+	// transports do not listen.
+	listeners := []struct {
+		envVar, port string
+		enabled      bool
+	}{
+		{"HTTP_PORT", c.HTTP.Port, c.HTTP.Enabled},
+		{"HTTP_INTERNAL_PORT", c.HTTP.InternalPort, true},
+		{"GRPC_PORT", c.GRPC.Port, c.GRPC.Enabled},
+	}
+	for i, a := range listeners {
+		for _, b := range listeners[i+1:] {
+			if a.enabled && b.enabled && a.port != "" && a.port != "0" && a.port == b.port {
+				invalid(b.envVar, "port %s is already used by %s", b.port, a.envVar)
+			}
+		}
+	}
+
+	// The fields are ignored when a DSN is given
+	if c.DB.DSN == "" {
+		port("DB_PORT", c.DB.Port, 1)
+		if !slices.Contains(sslModes, c.DB.SSLMode) {
+			invalid("DB_SSLMODE", "%q is not one of %s", c.DB.SSLMode, strings.Join(sslModes, ", "))
+		}
+		if c.DB.SSLCert != "" && c.DB.SSLKey == "" {
+			invalid("DB_SSLKEY", "is required with DB_SSLCERT")
+		}
+		if c.DB.SSLKey != "" && c.DB.SSLCert == "" {
+			invalid("DB_SSLCERT", "is required with DB_SSLKEY")
+		}
+		if c.IsProd() {
+			if c.DB.SSLMode == "disable" {
+				invalid("DB_SSLMODE", "disable is not allowed in prod")
+			}
+			if c.DB.Password == defaultDBPassword {
+				invalid("DB_PASSWORD", "is the default %q, which is not allowed in prod", defaultDBPassword)
+			}
+		}
+	}
+	if c.DB.ReplicaHost != "" {
+		port("DB_REPLICA_PORT", c.DB.ReplicaPort, 1)
+	}
+	if c.DB.ExplainSampleRate < 0 || c.DB.ExplainSampleRate > 1 {
+		invalid("DB_EXPLAIN_SAMPLE_RATE", "%g is not in [0, 1]", c.DB.ExplainSampleRate)
+	}
+	if c.DB.ExplainMinCost < 0 {
+		invalid("DB_EXPLAIN_MIN_COST", "%g is negative", c.DB.ExplainMinCost)
+	}
+	if !slices.Contains(idVersions, c.DB.IDVersion) {
+		invalid("DB_ID_VERSION", "%d is not one of 4, 7", c.DB.IDVersion)
+	}
+
+	// The fields are ignored when a URL is given
+	if urls := splitURLs(c.RabbitMQ.URL); len(urls) == 1 {
+		if reason := checkAMQPURL(urls[0]); reason != "" {
+			invalid("RABBITMQ_URL", "%s", reason)
+		}
+	} else if len(urls) > 1 {
+		for i, u := range urls {
+			if reason := checkAMQPURL(u); reason != "" {
+				invalid("RABBITMQ_URL", "node %d %s", i+1, reason)
+			}
+		}
+	} else {
+		if c.RabbitMQ.Host == "" {
+			invalid("RABBITMQ_HOST", "is required")
+		}
+		port("RABBITMQ_PORT", c.RabbitMQ.Port, 1)
+	}
+
+	if c.Worker.QueueName == "" {
+		invalid("WORKER_QUEUE", "is required")
+	}
+	if c.Worker.Concurrency < 1 {
+		invalid("WORKER_CONCURRENCY", "%d is not positive", c.Worker.Concurrency)
+	}
+	if c.Worker.Prefetch < 0 {
+		invalid("WORKER_PREFETCH", "%d is negative", c.Worker.Prefetch)
+	}
+	if c.Worker.MaxAttempts < 1 {
+		invalid("WORKER_MAX_ATTEMPTS", "%d is not positive", c.Worker.MaxAttempts)
+	}
+	if c.Worker.ShutdownGrace > 0 && c.Worker.ShutdownNackMargin >= c.Worker.ShutdownGrace {
+		invalid("WORKER_SHUTDOWN_NACK_MARGIN", "%s leaves no time to drain within WORKER_SHUTDOWN_GRACE %s",
+			c.Worker.ShutdownNackMargin, c.Worker.ShutdownGrace)
+	}
+	if c.Worker.HealthPort != "" {
+		port("WORKER_HEALTH_PORT", c.Worker.HealthPort, 0)
+	}
+
+	if c.Redis.DB < 0 {
+		invalid("REDIS_DB", "%d is negative", c.Redis.DB)
+	}
+
+	if c.Events.RelayLanes < 0 {
+		invalid("EVENTS_RELAY_LANES", "%d is negative", c.Events.RelayLanes)
+	}
+
+	if c.Limits.CreateUserRate < 0 {
+		invalid("LIMITS_CREATE_USER_RATE", "%g is negative", c.Limits.CreateUserRate)
+	}
+	if c.Limits.CreateUserRate > 0 && c.Limits.CreateUserBurst < 1 {
+		invalid("LIMITS_CREATE_USER_BURST", "%d is not positive", c.Limits.CreateUserBurst)
+	}
+	for _, group := range slices.Sorted(maps.Keys(c.Limits.GroupConcurrency)) {
+		if !slices.Contains(routeGroups, group) {
+			invalid("LIMITS_GROUP_CONCURRENCY", "%q is not one of %s", group, strings.Join(routeGroups, ", "))
+		} else if limit := c.Limits.GroupConcurrency[group]; limit < 0 {
+			invalid("LIMITS_GROUP_CONCURRENCY", "%s limit %d is negative", group, limit)
+		}
+	}
+
+	if c.Auth.JWTSecret != "" && len(c.Auth.JWTSecret) < minJWTSecretSize {
+		invalid("AUTH_JWT_SECRET", "is %d bytes, at least %d are required", len(c.Auth.JWTSecret), minJWTSecretSize)
+	}
+	if c.Auth.AccessTokenTTL <= 0 {
+		invalid("AUTH_ACCESS_TOKEN_TTL", "%s is not positive", c.Auth.AccessTokenTTL)
+	}
+	if c.Auth.Issuer == "" {
+		invalid("AUTH_ISSUER", "is required")
+	}
+
+	if c.Users.ShadowEnabled {
+		if c.Users.ShadowRate <= 0 || c.Users.ShadowRate > 1 {
+			invalid("USER_SHADOW_RATE", "%g is not in (0, 1]", c.Users.ShadowRate)
+		}
+		if c.Users.ShadowBudget <= 0 {
+			invalid("USER_SHADOW_BUDGET", "%s is not positive", c.Users.ShadowBudget)
+		}
+	}
+
+	if c.Users.ImportMaxSize < 0 {
+		invalid("USER_IMPORT_MAX_SIZE", "%d is negative", c.Users.ImportMaxSize)
+	}
+	if c.Users.ImportMaxDisk < 0 {
+		invalid("USER_IMPORT_MAX_DISK", "%d is negative", c.Users.ImportMaxDisk)
+	}
+	if c.Users.ImportMaxDisk > 0 && c.Users.ImportMaxSize > c.Users.ImportMaxDisk {
+		invalid("USER_IMPORT_MAX_SIZE", "%d is above USER_IMPORT_MAX_DISK", c.Users.ImportMaxSize)
+	}
+	if c.Users.ListMaxLimit < 0 {
+		invalid("USER_LIST_MAX_LIMIT", "%d is negative", c.Users.ListMaxLimit)
+	}
+	if c.Users.ImportChunkSize < 1 {
+		invalid("USER_IMPORT_CHUNK_SIZE", "%d is not positive", c.Users.ImportChunkSize)
+	}
+	if c.Users.ImportRetention <= 0 {
+		invalid("USER_IMPORT_RETENTION", "%s is not positive", c.Users.ImportRetention)
+	}
+
+	if c.Users.OrderByID && c.DB.IDVersion != 7 {
+		invalid("USER_ORDER_BY_ID", "needs time-ordered IDs, set DB_ID_VERSION=7")
+	}
+
+	if len(c.Users.SensitiveMetadata) > 0 {
+		if _, ok := c.Users.MetadataKeys[c.Users.MetadataKeyID]; !ok {
+			invalid("USER_METADATA_KEY_ID", "%q is not a key of USER_METADATA_KEYS", c.Users.MetadataKeyID)
+		}
+	}
+	for _, id := range slices.Sorted(maps.Keys(c.Users.MetadataKeys)) {
+		key, err := base64.StdEncoding.DecodeString(c.Users.MetadataKeys[id])
+		if err != nil || len(key) != 32 {
+			invalid("USER_METADATA_KEYS", "key %q is not 32 bytes in base64", id)
+		}
+	}
+
+	if c.Cache.UserStaleGrace > 0 && c.Cache.UserTTL <= 0 {
+		invalid("CACHE_USER_STALE_GRACE", "needs the user cache, set CACHE_USER_TTL")
+	}
+	if c.Cache.WarmEnabled {
+		if c.Cache.UserTTL <= 0 {
+			invalid("CACHE_WARM_ENABLED", "needs the user cache, set CACHE_USER_TTL")
+		}
+		if c.Cache.WarmUsers <= 0 {
+			invalid("CACHE_WARM_USERS", "%d is not positive", c.Cache.WarmUsers)
+		}
+		if c.Cache.WarmRate <= 0 {
+			invalid("CACHE_WARM_RATE", "%d is not positive", c.Cache.WarmRate)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: fields}
+}
+
+// checkPort returns why the value is not a TCP port from lowest to 65535,
+// "" when it is one
+func checkPort(value string, lowest int) string {
+	if value == "" {
+		return "is required"
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Sprintf("%q is not a number", value)
+	}
+	if n < lowest || n > 65535 {
+		return fmt.Sprintf("%d is out of the port range %d-65535", n, lowest)
+	}
+	return ""
+}
+
+// checkAMQPURL returns why the value is not an AMQP URI, "" when it is one.
+// The value is not quoted since it may hold a password.
+func checkAMQPURL(value string) string {
+	if value == "" {
+		return "is required"
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return "is not a valid URI"
+	}
+	if u.Scheme != "amqp" && u.Scheme != "amqps" {
+		return "must use the amqp or amqps scheme"
+	}
+	if u.Host == "" {
+		return "has no host"
+	}
+	if p := u.Port(); p != "" {
+		if reason := checkPort(p, 1); reason != "" {
+			return "port " + reason
+		}
+	}
+	return ""
+}
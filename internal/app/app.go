@@ -0,0 +1,743 @@
+// Package app is the composition root: it builds the dependency graph shared
+// by every binary so that adding a dependency is a change in one place
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/buildinfo"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/crypto"
+	"github.com/romanitalian/carch-go/internal/pkg/explain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/health"
+	"github.com/romanitalian/carch-go/internal/pkg/idgen"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/pkg/pubsub"
+	"github.com/romanitalian/carch-go/internal/pkg/sanitize"
+	"github.com/romanitalian/carch-go/internal/pkg/staging"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
+	"github.com/romanitalian/carch-go/internal/repository"
+	"github.com/romanitalian/carch-go/internal/service"
+	"github.com/romanitalian/carch-go/internal/transport/grpc"
+	httpTransport "github.com/romanitalian/carch-go/internal/transport/http"
+	"github.com/romanitalian/carch-go/internal/worker"
+)
+
+// MessageQueue is the broker used for publishing events and consuming tasks
+type MessageQueue interface {
+	service.Publisher
+	worker.MessageQueue
+	worker.Retrier
+}
+
+// Repositories holds the storage and messaging dependencies
+type Repositories struct {
+	User     domain.UserRepository
+	UserBulk domain.UserBulkRepository
+	// UserRecent lists users by activity and UserCache, nil when caching is
+	// disabled, holds users looked up by ID
+	UserRecent domain.RecentUserRepository
+	UserCache  domain.UserCache
+	Outbox     domain.OutboxRepository
+	// Tx runs functions within a transaction of the primary database
+	Tx       domain.Transactor
+	Instance domain.InstanceRepository
+	Job      domain.JobRepository
+	Audit    domain.AuditRepository
+	Events   domain.EventArchiveRepository
+	// Slots holds the slots of expensive requests, shared through Redis
+	// when it is configured
+	Slots cache.Semaphore
+	// Processed holds the business keys of handled messages, see
+	// worker.WithDedupStore
+	Processed cache.Adder
+	// Nonces holds the signatures of accepted webhooks
+	Nonces cache.Adder
+	// Counters holds the user mutation counts, see USER_MUTATION_LIMIT
+	Counters cache.Counter
+	// Seen holds the users recently marked seen, see USER_SEEN_INTERVAL
+	Seen  cache.Adder
+	Queue MessageQueue
+	// JobEvents receives the IDs of changed jobs
+	JobEvents *pubsub.Broker
+	// SQL is the raw connection used for migrations and health checks
+	SQL *sql.DB
+	// Broker reports the state of the broker connection for health checks
+	Broker health.ConnectionStatus
+	// Explain samples the plans of reads, see DB_EXPLAIN_SAMPLE_RATE
+	Explain *explain.Sampler
+}
+
+// JobNotifierMemory selects the in-process job progress broker, used when the
+// worker runs in the same process as the API
+const JobNotifierMemory = "memory"
+
+// Server is a transport server with a blocking Run and graceful Shutdown
+type Server interface {
+	Run() error
+	Shutdown(ctx context.Context) error
+}
+
+// NewLogger builds the logger of a binary at LOG_LEVEL, writing to stdout in
+// LOG_FORMAT, or JSON to the rotating LOG_FILE when it is set. The binary closes it on exit.
+func NewLogger(cfg *config.Config) (*logger.Logger, error) {
+	level, err := logger.ParseLevel(cfg.Log.Level)
+	if err != nil {
+		return nil, err
+	}
+	opts := []logger.Option{logger.WithLevel(level), logger.WithStackTraces(cfg.Log.StackTraces)}
+	switch {
+	case cfg.Log.File != "":
+		opts = append(opts, logger.WithFile(cfg.Log.File, cfg.Log.FileMaxSizeMB, cfg.Log.FileMaxBackups))
+	case cfg.Log.Format == config.LogFormatConsole:
+		opts = append(opts, logger.WithPretty())
+	}
+	return logger.New(opts...), nil
+}
+
+// RegisterLogMetrics reports the entries log wrote by level, see
+// logger.Stats, to registerer
+func RegisterLogMetrics(log *logger.Logger, registerer prometheus.Registerer) error {
+	return registerer.Register(metrics.NewLogEntries(logger.Levels, func(name string) uint64 {
+		level, err := logger.ParseLevel(name)
+		if err != nil {
+			return 0
+		}
+		return log.Stats().Count(level)
+	}))
+}
+
+// PostgresConfig returns the repository configuration for the database
+func PostgresConfig(cfg *config.Config, log *logger.Logger) repository.PostgresConfig {
+	return repository.PostgresConfig{
+		ConnString: cfg.DB.DSN,
+		Host:       cfg.DB.Host,
+		Port:       cfg.DB.Port,
+		User:       cfg.DB.User,
+		Password:   cfg.DB.Password,
+		DBName:     cfg.DB.DBName,
+		SSLMode:    cfg.DB.SSLMode,
+		Logger:     log,
+
+		SSLRootCert: cfg.DB.SSLRootCert,
+		SSLCert:     cfg.DB.SSLCert,
+		SSLKey:      cfg.DB.SSLKey,
+
+		MaxOpenConns:    cfg.DB.MaxOpenConns,
+		MaxIdleConns:    cfg.DB.MaxIdleConns,
+		ConnMaxLifetime: cfg.DB.ConnMaxLifetime,
+		ConnMaxIdleTime: cfg.DB.ConnMaxIdleTime,
+	}
+}
+
+// RedisConfig returns the repository configuration for Redis
+func RedisConfig(cfg *config.Config, log *logger.Logger) repository.RedisConfig {
+	return repository.RedisConfig{
+		Addr:        cfg.Redis.Addr,
+		Password:    cfg.Redis.Password,
+		DB:          cfg.Redis.DB,
+		DialTimeout: cfg.Redis.DialTimeout,
+		Logger:      log,
+	}
+}
+
+// BuildRepositories connects to Postgres, RabbitMQ and, when configured,
+// Redis, and builds the
+// repositories. The returned cleanup closes connections in reverse order.
+// Error strings are capped at the configured length from here on.
+func BuildRepositories(cfg *config.Config, log *logger.Logger) (*Repositories, func() error, error) {
+	sanitize.SetMaxLength(cfg.Errors.MaxLength)
+	lc := &Lifecycle{}
+
+	db, err := ConnectPostgres(cfg, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	lc.Append("postgres", db.Close)
+
+	mq, err := ConnectRabbitMQ(cfg, log)
+	if err != nil {
+		lc.Close()
+		return nil, nil, err
+	}
+	lc.Append("rabbitmq", mq.Close)
+
+	rdb, err := repository.NewRedisClient(RedisConfig(cfg, log))
+	if err != nil {
+		lc.Close()
+		return nil, nil, err
+	}
+
+	sampler := ExplainSampler(cfg, log)
+	repoOpts := []repository.Option{
+		repository.WithLogger(log),
+		repository.WithExplainSampling(sampler),
+		repository.WithGenerators(generate.WithIDGenerator(IDGenerator(cfg))),
+	}
+	if cfg.Users.OrderByID {
+		repoOpts = append(repoOpts, repository.WithUserIDOrder())
+	}
+	if rdb != nil {
+		lc.Append("redis", rdb.Close)
+		repoOpts = append(repoOpts, repository.WithRedis(rdb))
+	}
+	if cfg.DB.ReplicaHost != "" {
+		replicaCfg, err := PostgresConfig(cfg, log).WithServer(cfg.DB.ReplicaHost, cfg.DB.ReplicaPort)
+		if err != nil {
+			lc.Close()
+			return nil, nil, err
+		}
+
+		replica, err := repository.NewPostgresDB(replicaCfg)
+		if err != nil {
+			lc.Close()
+			return nil, nil, err
+		}
+		lc.Append("postgres replica", replica.Close)
+		repoOpts = append(repoOpts, repository.WithReplica(replica))
+	}
+	if cfg.Cache.UserTTL > 0 {
+		repoOpts = append(repoOpts,
+			repository.WithUserCache(cache.NewMemory(), cfg.Cache.UserTTL, log),
+			repository.WithUserCacheStaleIfError(cfg.Cache.UserStaleGrace))
+	}
+
+	repos := repository.NewRepositories(db, mq, repoOpts...)
+
+	jobEvents := pubsub.New()
+	if cfg.Jobs.Notifier != JobNotifierMemory {
+		listener, err := repository.NewJobListener(PostgresConfig(cfg, log).DSN(), jobEvents, log)
+		if err != nil {
+			lc.Close()
+			return nil, nil, err
+		}
+		lc.Append("job listener", listener.Close)
+	}
+
+	return &Repositories{
+		User:       repos.User,
+		UserBulk:   repos.UserBulk,
+		UserRecent: repos.UserRecent,
+		UserCache:  repos.UserCache,
+		Outbox:     repos.Outbox,
+		Tx:         repos.Tx,
+		Instance:   repos.Instance,
+		Job:        repos.Job,
+		Audit:      repos.Audit,
+		Events:     repos.Events,
+		Slots:      repos.Slots,
+		Processed:  repos.Processed,
+		Nonces:     repos.Nonces,
+		Counters:   repos.Counters,
+		Seen:       repos.Seen,
+		Queue:      mq,
+		JobEvents:  jobEvents,
+		SQL:        db.SQLDb,
+		Broker:     mq,
+		Explain:    sampler,
+	}, lc.Close, nil
+}
+
+// ExplainSampler returns the sampler of the plans of reads. It is built
+// even with DB_EXPLAIN_SAMPLE_RATE=0, so that an administrator can enable
+// sampling at runtime.
+func ExplainSampler(cfg *config.Config, log *logger.Logger) *explain.Sampler {
+	return explain.NewSampler(cfg.DB.ExplainSampleRate, log,
+		explain.WithMinCost(cfg.DB.ExplainMinCost),
+		explain.WithMinDuration(cfg.DB.ExplainMinDuration))
+}
+
+// IDGenerator returns the generator of the IDs of new rows, see DB_ID_VERSION
+func IDGenerator(cfg *config.Config) idgen.Generator {
+	ids, err := idgen.ForVersion(cfg.DB.IDVersion)
+	if err != nil {
+		// Loaded configs are validated, others get the historical random IDs
+		return idgen.UUID
+	}
+	return ids
+}
+
+// BuildCacheWarmer builds the warmer of the user cache, nil when warming or
+// the cache is disabled
+func BuildCacheWarmer(cfg *config.Config, repos *Repositories, log *logger.Logger) *service.CacheWarmer {
+	if !cfg.Cache.WarmEnabled || repos.UserCache == nil {
+		return nil
+	}
+	return service.NewCacheWarmer(repos.UserRecent, repos.UserCache, log,
+		service.WithCacheWarmUsers(cfg.Cache.WarmUsers),
+		service.WithCacheWarmRate(cfg.Cache.WarmRate),
+	)
+}
+
+// ShadowUsers wraps users so that the configured share of its reads also
+// runs against candidate, see service.ShadowUserService. It returns users
+// as is when shadowing is disabled or there is no candidate.
+func ShadowUsers(cfg *config.Config, users, candidate service.UserServiceInterface, log *logger.Logger) service.UserServiceInterface {
+	if !cfg.Users.ShadowEnabled {
+		return users
+	}
+	if candidate == nil {
+		log.Warn("Not shadowing user reads, there is no candidate")
+		return users
+	}
+	log.Info("Shadowing user reads", map[string]interface{}{
+		"rate":      cfg.Users.ShadowRate,
+		"budget_ms": cfg.Users.ShadowBudget.Milliseconds(),
+	})
+	return service.NewShadowUserService(users, candidate, log,
+		service.WithShadowRate(cfg.Users.ShadowRate),
+		service.WithShadowBudget(cfg.Users.ShadowBudget),
+	)
+}
+
+// BuildServices builds the application services on top of the repositories
+func BuildServices(cfg *config.Config, repos *Repositories, log *logger.Logger) *service.Services {
+	userOptions := []service.UserOption{service.WithMaxPageLimit(cfg.Users.ListMaxLimit)}
+	if cfg.Users.EmailChangeConfirmation {
+		userOptions = append(userOptions, service.WithEmailChangeConfirmation(cfg.Users.EmailChangeTTL))
+	}
+	if cfg.Users.Collation != "" {
+		opts, err := domain.ParseUserSort(domain.UserSortName + ":" + cfg.Users.Collation)
+		if err != nil {
+			log.Warn("Ignoring invalid default collation", map[string]interface{}{"collation": cfg.Users.Collation})
+		} else {
+			userOptions = append(userOptions, service.WithDefaultCollation(opts.Collation))
+		}
+	}
+
+	if cfg.Users.MutationLimit > 0 {
+		userOptions = append(userOptions,
+			service.WithMutationThrottle(repos.Counters, repos.Audit, cfg.Users.MutationLimit, cfg.Users.MutationWindow))
+	}
+
+	if cfg.Users.SeenInterval > 0 {
+		userOptions = append(userOptions, service.WithSeenTracking(repos.UserRecent, repos.Seen, cfg.Users.SeenInterval))
+	}
+
+	if len(cfg.Users.SensitiveMetadata) > 0 {
+		// The keys were checked when the config was loaded; storing sensitive
+		// values in clear is not an option if they are wrong anyway
+		keyring, err := crypto.ParseKeyring(cfg.Users.MetadataKeyID, cfg.Users.MetadataKeys)
+		if err != nil {
+			log.Fatal("Invalid metadata master keys", err, map[string]interface{}{"key_id": cfg.Users.MetadataKeyID})
+		}
+		userOptions = append(userOptions,
+			service.WithMetadataEncryption(crypto.NewEnvelopes(keyring), cfg.Users.SensitiveMetadata...))
+	}
+
+	var jobOptions []service.JobOption
+	if cfg.Jobs.Notifier == JobNotifierMemory {
+		jobOptions = append(jobOptions, service.WithLocalJobEvents())
+	}
+
+	services := service.NewServices(service.Deps{
+		Repos: &service.Repositories{
+			User:     repos.User,
+			UserBulk: repos.UserBulk,
+			Outbox:   repos.Outbox,
+			Tx:       repos.Tx,
+			Instance: repos.Instance,
+			Job:      repos.Job,
+			Audit:    repos.Audit,
+			Events:   repos.Events,
+		},
+		MessageQueue:       repos.Queue,
+		Logger:             log,
+		UserOptions:        userOptions,
+		InstanceStaleAfter: cfg.Instances.StaleAfter,
+		JobEvents:          repos.JobEvents,
+		JobOptions:         jobOptions,
+		BulkDeleteOptions: []service.BulkDeleteOption{
+			service.WithBulkDeleteCeiling(cfg.Users.BulkDeleteCeiling),
+			service.WithBulkDeleteBatchSize(cfg.Users.BulkDeleteBatchSize),
+		},
+		ImportStore: ImportStore(cfg),
+		ImportOptions: []service.UserImportOption{
+			service.WithImportChunkSize(cfg.Users.ImportChunkSize),
+			service.WithImportRetention(cfg.Users.ImportRetention),
+		},
+		AuditPartitionsAhead:  cfg.Audit.PartitionsAhead,
+		AuditRetention:        cfg.Audit.Retention,
+		EventArchiveRetention: cfg.Events.ArchiveRetention,
+		Publisher:             repos.Queue,
+		WebhookProviders:      WebhookProviders(cfg),
+		WebhookReplayWindow:   cfg.Webhooks.ReplayWindow,
+		WebhookNonces:         repos.Nonces,
+		Generators:            []generate.Option{generate.WithIDGenerator(IDGenerator(cfg))},
+	})
+	RegisterUserDeletionHooks(services.UserDeletion, repos)
+	// The keyset and full-text search implementation of the user reads is
+	// the candidate once it lands
+	services.User = ShadowUsers(cfg, services.User, nil, log)
+	return services
+}
+
+// RegisterUserDeletionHooks registers the cleanups of deleted users of the
+// repositories. Pending email changes are dropped with the user. The cached
+// user is dropped again once the deletion is committed, since a lookup
+// during the transaction may have cached it back. The cache is held in the
+// memory of this process, so its hook is local: a retry from the outbox
+// would clear the cache of the worker instead.
+func RegisterUserDeletionHooks(deletion *service.UserDeletion, repos *Repositories) {
+	deletion.OnUserDeleted("email_change_requests", service.InDeletionTx, func(ctx context.Context, userID string) error {
+		if err := repos.User.CancelEmailChange(ctx, userID); err != nil && !errors.Is(err, domain.ErrEmailChangeNotFound) {
+			return err
+		}
+		return nil
+	})
+	if repos.UserCache != nil {
+		deletion.OnUserDeleted("user_cache", service.AfterDeletionCommitLocal, repos.UserCache.Invalidate)
+	}
+}
+
+// ImportStore returns the staging directory of user imports with its limits
+func ImportStore(cfg *config.Config) *staging.Store {
+	dir := cfg.Users.ImportDir
+	if dir == "" {
+		dir = service.DefaultImportDir()
+	}
+	return staging.New(dir, cfg.Users.ImportMaxSize, cfg.Users.ImportMaxDisk)
+}
+
+// WebhookProviders returns the webhook providers with a configured secret
+func WebhookProviders(cfg *config.Config) []domain.WebhookProvider {
+	providers := make([]domain.WebhookProvider, 0, len(cfg.Webhooks.Secrets))
+	for name, secret := range cfg.Webhooks.Secrets {
+		providers = append(providers, domain.WebhookProvider{
+			Name:            name,
+			Secret:          secret,
+			SignatureHeader: cfg.Webhooks.SignatureHeaders[name],
+			TimestampHeader: cfg.Webhooks.TimestampHeaders[name],
+			Algorithm:       cfg.Webhooks.Algorithms[name],
+		})
+	}
+	return providers
+}
+
+// NewServiceInstance describes the running process for the instance registry
+func NewServiceInstance(cfg *config.Config) *domain.ServiceInstance {
+	hostname, _ := os.Hostname()
+
+	return &domain.ServiceInstance{
+		ID:         uuid.New().String(),
+		Hostname:   hostname,
+		StartedAt:  time.Now(),
+		ConfigHash: cfg.Hash(),
+		Version:    buildinfo.Version(),
+	}
+}
+
+// BuildOutboxRelay builds the relay forwarding outbox events to the broker
+func BuildOutboxRelay(cfg *config.Config, repos *Repositories, log *logger.Logger) *service.OutboxRelay {
+	return service.NewOutboxRelay(repos.Outbox, repos.Queue, log, service.WithRelayLanes(cfg.Events.RelayLanes))
+}
+
+// BuildWideEvents builds the emitter of wide events, nil when no sink is
+// configured. The returned function closes a file sink.
+func BuildWideEvents(cfg *config.Config) (*wideevent.Emitter, func() error, error) {
+	noop := func() error { return nil }
+	if err := wideevent.CheckFields(cfg.WideEvents.Fields); err != nil {
+		return nil, noop, err
+	}
+
+	var sink io.Writer
+	closeSink := noop
+	switch cfg.WideEvents.Sink {
+	case "":
+		return nil, noop, nil
+	case "stdout":
+		sink = os.Stdout
+	case "stderr":
+		sink = os.Stderr
+	default:
+		f, err := os.OpenFile(cfg.WideEvents.Sink, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, noop, fmt.Errorf("failed to open wide event sink: %w", err)
+		}
+		sink, closeSink = f, f.Close
+	}
+
+	return wideevent.NewEmitter(sink,
+		wideevent.WithSampleRate(cfg.WideEvents.SampleRate),
+		wideevent.WithFields(cfg.WideEvents.Fields...),
+	), closeSink, nil
+}
+
+// BuildHTTPServer builds the REST API server and, when an internal port is
+// configured, the server of probes, metrics and admin routes. The internal
+// server is nil otherwise. Readiness runs the given checkers after the
+// dependency checks.
+func BuildHTTPServer(cfg *config.Config, repos *Repositories, services *service.Services, events *wideevent.Emitter, log *logger.Logger, checkers ...health.Checker) (Server, Server) {
+	var readiness []health.Checker
+	if repos.SQL != nil {
+		readiness = append(readiness, health.NewDBChecker(repos.SQL))
+	}
+	if repos.Broker != nil {
+		readiness = append(readiness, health.NewConnectionChecker("rabbitmq", repos.Broker))
+	}
+	readiness = append(readiness, checkers...)
+
+	public := httpTransport.NewServer(&httpTransport.Config{
+		Address:         cfg.HTTP.Address,
+		Port:            cfg.HTTP.Port,
+		TLSCertFile:     cfg.HTTP.TLSCertFile,
+		TLSKeyFile:      cfg.HTTP.TLSKeyFile,
+		InternalAddress: cfg.HTTP.InternalAddress,
+		InternalPort:    cfg.HTTP.InternalPort,
+		Timeouts: httpTransport.Timeouts{
+			Read:       cfg.HTTP.ReadTimeout,
+			Write:      cfg.HTTP.WriteTimeout,
+			Idle:       cfg.HTTP.IdleTimeout,
+			ReadHeader: cfg.HTTP.ReadHeaderTimeout,
+		},
+		StatementBudget:       cfg.DB.StatementBudget,
+		StatementBudgetStrict: cfg.DB.StatementBudgetStrict,
+		AccessLogExclude:      cfg.HTTP.AccessLogExclude,
+		AccessLogDebug:        cfg.HTTP.AccessLogDebug,
+		ReadinessCheckers:     readiness,
+		ConsistencyWindow:     cfg.DB.ReplicaMaxLag,
+		SlowRequestThreshold:  cfg.HTTP.SlowRequestThreshold,
+		ShedSoftLimit:         cfg.HTTP.ShedSoftLimit,
+		ShedHardLimit:         cfg.HTTP.ShedHardLimit,
+		ShedLatencyThreshold:  cfg.HTTP.ShedP99,
+		ConcurrencySlots:      repos.Slots,
+		ConcurrencyLimit:      cfg.Limits.ExpensiveConcurrency,
+		ConcurrencyByRole:     cfg.Limits.ExpensiveConcurrencyByRole,
+		ConcurrencySlotTTL:    cfg.Limits.SlotTTL,
+		GroupConcurrency:      cfg.Limits.GroupConcurrency,
+		GroupWait:             cfg.Limits.GroupWait,
+		CreateUserRate:        cfg.Limits.CreateUserRate,
+		CreateUserBurst:       cfg.Limits.CreateUserBurst,
+		TrustProxy:            cfg.HTTP.TrustProxy,
+		ThrottleOverrideToken: cfg.Users.MutationOverrideToken,
+		TrailingSlash:         cfg.HTTP.TrailingSlash,
+		CORS: httpTransport.CORS{
+			Origins:          cfg.HTTP.CORSOrigins,
+			Methods:          cfg.HTTP.CORSMethods,
+			Headers:          cfg.HTTP.CORSHeaders,
+			MaxAge:           cfg.HTTP.CORSMaxAge,
+			AllowCredentials: cfg.HTTP.CORSAllowCredentials,
+		},
+		WideEvents:     events,
+		ExplainSampler: repos.Explain,
+	}, services, log)
+
+	if internal := public.Internal(); internal != nil {
+		return public, internal
+	}
+	return public, nil
+}
+
+// ProbeServer restricts the public HTTP server built by BuildHTTPServer to
+// the probes, served on its address
+func ProbeServer(public Server) Server {
+	return public.(*httpTransport.Server).Probes()
+}
+
+// BuildGRPCServer builds the gRPC server
+func BuildGRPCServer(cfg *config.Config, services *service.Services, events *wideevent.Emitter, log *logger.Logger) Server {
+	opts := []grpc.Option{
+		grpc.WithStatementBudget(cfg.DB.StatementBudget, cfg.DB.StatementBudgetStrict),
+		grpc.WithWideEvents(events),
+		grpc.WithMaxMetadataSize(cfg.GRPC.MaxMetadataSize),
+	}
+	if cfg.GRPC.TLSCertFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(cfg.GRPC.TLSCertFile, cfg.GRPC.TLSKeyFile)
+		if err != nil {
+			log.Fatal("Failed to load the gRPC certificate", err, map[string]interface{}{"cert_file": cfg.GRPC.TLSCertFile})
+		}
+		opts = append(opts, grpc.WithTLS(creds))
+	}
+	return grpcServer{grpc.NewServer(cfg.GRPC.Address+":"+cfg.GRPC.Port, services, log, opts...)}
+}
+
+// grpcServer adapts the gRPC server to the Server interface
+type grpcServer struct {
+	*grpc.Server
+}
+
+func (s grpcServer) Run() error {
+	return s.Server.Run()
+}
+
+// ConnectPostgres connects with the configured user and falls back to the
+// postgres superuser for local setups where the user was not created yet.
+// There is no fallback when DB_DSN is set or in prod.
+func ConnectPostgres(cfg *config.Config, log *logger.Logger) (*repository.DB, error) {
+	pgCfg := PostgresConfig(cfg, log)
+
+	db, err := repository.NewPostgresDB(pgCfg)
+	if err != nil && pgCfg.ConnString == "" && !cfg.IsProd() && strings.Contains(err.Error(), "password authentication failed") {
+		log.Warn("Failed to connect with configured user, trying with postgres user",
+			map[string]interface{}{"error": err.Error()})
+
+		pgCfg.User = "postgres"
+		pgCfg.Password = "postgres"
+		db, err = repository.NewPostgresDB(pgCfg)
+	}
+
+	return db, err
+}
+
+// ConnectRabbitMQ connects with the configured URLs, see
+// config.RabbitMQConfig.BuildURLs, and falls back to the default guest
+// credentials on the same broker for local setups, but not in prod
+func ConnectRabbitMQ(cfg *config.Config, log *logger.Logger) (*repository.RabbitMQ, error) {
+	mqCfg := repository.RabbitMQConfig{
+		URLs:              cfg.RabbitMQ.BuildURLs(),
+		Driver:            cfg.RabbitMQ.Driver,
+		Prefetch:          cfg.Worker.Prefetch,
+		TaskQueue:         cfg.Worker.QueueName,
+		DriftPolicy:       cfg.RabbitMQ.TopologyDrift,
+		ReconnectDelay:    cfg.RabbitMQ.ReconnectDelay,
+		ReconnectMaxDelay: cfg.RabbitMQ.ReconnectMaxDelay,
+		TenantRouting:     TenantRouting(cfg),
+		Logger:            log,
+	}
+
+	mq, err := repository.NewRabbitMQ(mqCfg)
+	if err != nil && !cfg.IsProd() && strings.Contains(err.Error(), "authentication failure") {
+		log.Warn("Failed to connect to RabbitMQ with configured credentials, trying with guest user",
+			map[string]interface{}{"error": err.Error()})
+
+		guest := cfg.RabbitMQ
+		guest.URL, guest.User, guest.Password, guest.VHost = "", "guest", "guest", "/"
+		mqCfg.URLs = guest.BuildURLs()
+		mq, err = repository.NewRabbitMQ(mqCfg)
+	}
+
+	return mq, err
+}
+
+// TenantRouting returns the configured tenant routing, nil when disabled
+func TenantRouting(cfg *config.Config) *repository.TenantRouting {
+	if !cfg.RabbitMQ.TenantRouting {
+		return nil
+	}
+	return &repository.TenantRouting{
+		Partitions: cfg.RabbitMQ.TenantPartitions,
+		Tenants:    cfg.RabbitMQ.Tenants,
+	}
+}
+
+// BuildWorker builds the worker consuming the task queues of the configured
+// tenant partitions
+func BuildWorker(cfg *config.Config, repos *Repositories, log *logger.Logger) (*worker.Worker, error) {
+	queues, err := repository.TaskQueues(cfg.Worker.QueueName, TenantRouting(cfg), cfg.Worker.Partitions)
+	if err != nil {
+		return nil, err
+	}
+	log.Info("Worker settings", map[string]interface{}{
+		"queues":           queues,
+		"prefetch":         cfg.Worker.Prefetch,
+		"concurrency":      cfg.Worker.Concurrency,
+		"handler_timeout":  cfg.Worker.HandlerTimeout.String(),
+		"max_attempts":     cfg.Worker.MaxAttempts,
+		"shutdown_timeout": cfg.Worker.ShutdownTimeout.String(),
+	})
+	services := BuildServices(cfg, repos, log)
+	return worker.NewWorker(repos.Queue, BuildDispatcher(cfg, services, repos, log), worker.WithQueues(queues...), worker.WithLogger(log)), nil
+}
+
+// workerHealthTimeout bounds each check of the worker health report
+const workerHealthTimeout = 2 * time.Second
+
+// BuildWorkerHealthServer builds the server answering GET /healthz with the
+// phase of the worker and the state of its broker connection, 503 once the
+// worker drains or a check fails. It is nil when WORKER_HEALTH_PORT is empty.
+func BuildWorkerHealthServer(cfg *config.Config, repos *Repositories, w *worker.Worker) Server {
+	if cfg.Worker.HealthPort == "" {
+		return nil
+	}
+	checkers := []health.Checker{w}
+	if repos.Broker != nil {
+		checkers = append(checkers, health.NewConnectionChecker("rabbitmq", repos.Broker))
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(rw http.ResponseWriter, r *http.Request) {
+		report := health.Run(r.Context(), workerHealthTimeout, checkers...)
+		status := http.StatusOK
+		if report.Failed() || report.Starting() {
+			status = http.StatusServiceUnavailable
+		}
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		_ = json.NewEncoder(rw).Encode(report)
+	})
+	return &healthServer{server: &http.Server{
+		Addr:              ":" + cfg.Worker.HealthPort,
+		Handler:           mux,
+		ReadHeaderTimeout: workerHealthTimeout,
+	}}
+}
+
+// healthServer is the HTTP server of the worker health report
+type healthServer struct {
+	server *http.Server
+}
+
+func (s *healthServer) Run() error {
+	if err := s.server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *healthServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// userCleanupDedupWindow is how long a handled user.cleanup message
+// suppresses others for the same user and hook
+const userCleanupDedupWindow = time.Hour
+
+// BuildDispatcher builds the dispatcher routing task messages to handlers.
+// Handlers are registered per message type with their own timeout and
+// concurrency, see worker.Dispatcher.Handle. Failed messages are published
+// again through the queue until WORKER_MAX_ATTEMPTS.
+func BuildDispatcher(cfg *config.Config, services *service.Services, repos *Repositories, log *logger.Logger) *worker.Dispatcher {
+	d := worker.NewDispatcher(log,
+		worker.WithWorkers(cfg.Worker.Concurrency),
+		worker.WithPrefetch(cfg.Worker.Prefetch),
+		worker.WithDefaultTimeout(cfg.Worker.HandlerTimeout),
+		worker.WithRetries(repos.Queue, cfg.Worker.MaxAttempts),
+		worker.WithDedupStore(repos.Processed),
+	)
+
+	// Bulk deletes are long and write heavily, one runs at a time
+	d.Handle(domain.EventJobPrefix+domain.JobUsersBulkDelete, worker.JobHandler(services.BulkDelete.Run),
+		worker.WithTimeout(cfg.Users.BulkDeleteTimeout),
+		worker.WithConcurrency(1),
+	)
+
+	d.Handle(domain.EventJobPrefix+domain.JobUsersImport, worker.JobHandler(services.Import.Run),
+		worker.WithTimeout(cfg.Users.ImportTimeout),
+		worker.WithConcurrency(1),
+	)
+
+	// The outbox publishes at least once, a relay retrying a publish must
+	// not run the hook of a deleted user twice
+	d.Handle(domain.EventUserCleanup, worker.UserCleanupHandler(services.UserDeletion.RunHook),
+		worker.WithDedup(userCleanupDedupWindow, "user_id", "hook"),
+	)
+
+	return d
+}
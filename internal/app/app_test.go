@@ -0,0 +1,225 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/repository"
+	"github.com/romanitalian/carch-go/internal/service"
+	"github.com/romanitalian/carch-go/internal/worker"
+)
+
+// fakeUserRepository serves List from memory; other methods are not used
+type fakeUserRepository struct {
+	domain.UserRepository
+	users []*domain.User
+}
+
+func (f *fakeUserRepository) List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	return &domain.UserPage{Users: f.users, Total: int64(len(f.users))}, nil
+}
+
+type fakeOutboxRepository struct {
+	domain.OutboxRepository
+}
+
+type fakeQueue struct{}
+
+func (fakeQueue) Publish(ctx context.Context, event *domain.OutboxEvent) error { return nil }
+func (fakeQueue) Consume(queueName string) (<-chan messaging.Delivery, error)  { return nil, nil }
+func (fakeQueue) Close() error                                                 { return nil }
+func (fakeQueue) Retry(ctx context.Context, msg messaging.Delivery) error      { return nil }
+
+func TestBuild_FullGraphWithFakes(t *testing.T) {
+	// Arrange
+	cfg := &config.Config{}
+	cfg.HTTP.Address, cfg.HTTP.Port = "127.0.0.1", "0"
+	cfg.GRPC.Address, cfg.GRPC.Port = "127.0.0.1", "0"
+	log := logger.New()
+
+	repos := &Repositories{
+		User:   &fakeUserRepository{users: []*domain.User{{ID: "user-1", Email: "user1@example.com"}}},
+		Outbox: &fakeOutboxRepository{},
+		Queue:  fakeQueue{},
+	}
+
+	// Act
+	services := BuildServices(cfg, repos, log)
+	httpServer, internalServer := BuildHTTPServer(cfg, repos, services, nil, log)
+	grpcServer := BuildGRPCServer(cfg, services, nil, log)
+	relay := BuildOutboxRelay(cfg, repos, log)
+
+	// Assert
+	require.NotNil(t, services.User)
+	require.NotNil(t, grpcServer)
+	assert.Nil(t, internalServer, "internal routes are not served without an internal port")
+	require.NotNil(t, relay)
+
+	handler := httpServer.(interface{ Handler() http.Handler }).Handler()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "user1@example.com")
+
+	assert.NoError(t, grpcServer.Shutdown(context.Background()))
+}
+
+func TestLifecycle_ClosesInReverseOrder(t *testing.T) {
+	// Arrange
+	var closed []string
+	lc := &Lifecycle{}
+	for _, name := range []string{"postgres", "rabbitmq", "cache"} {
+		name := name
+		lc.Append(name, func() error {
+			closed = append(closed, name)
+			if name == "rabbitmq" {
+				return errors.New("channel already closed")
+			}
+			return nil
+		})
+	}
+
+	// Act
+	err := lc.Close()
+
+	// Assert
+	assert.Equal(t, []string{"cache", "rabbitmq", "postgres"}, closed)
+	assert.ErrorContains(t, err, "close rabbitmq: channel already closed")
+}
+
+func TestNewLogger(t *testing.T) {
+	// Arrange
+	cfg := &config.Config{}
+	cfg.Log.Level = "debug"
+
+	// Act
+	log, err := NewLogger(cfg)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, zerolog.DebugLevel, log.Level())
+}
+
+func TestNewLogger_File(t *testing.T) {
+	// Arrange
+	cfg := &config.Config{Env: "dev"}
+	cfg.Log.Level = "info"
+	cfg.Log.Format = config.LogFormatConsole
+	cfg.Log.File = filepath.Join(t.TempDir(), "worker.log")
+	cfg.Log.FileMaxSizeMB = 100
+
+	// Act
+	log, err := NewLogger(cfg)
+	require.NoError(t, err)
+	log.Info("Worker started")
+	require.NoError(t, log.Close())
+
+	// Assert
+	content, err := os.ReadFile(cfg.Log.File)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), `"message":"Worker started"`, "JSON even with the console format")
+}
+
+func TestRegisterLogMetrics(t *testing.T) {
+	// Arrange
+	log := logger.New(logger.WithOutput(io.Discard))
+	registry := prometheus.NewRegistry()
+	require.NoError(t, RegisterLogMetrics(log, registry))
+
+	// Act
+	log.Info("Worker started")
+	log.Error("Handler failed", errors.New("boom"))
+	log.Error("Handler failed", errors.New("boom"))
+
+	// Assert
+	err := testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP carch_log_entries_total Number of log entries written by level.
+# TYPE carch_log_entries_total counter
+carch_log_entries_total{level="debug"} 0
+carch_log_entries_total{level="error"} 2
+carch_log_entries_total{level="info"} 1
+carch_log_entries_total{level="trace"} 0
+carch_log_entries_total{level="warn"} 0
+`), "carch_log_entries_total")
+	assert.NoError(t, err)
+}
+
+func TestNewLogger_InvalidLevel(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Log.Level = "verbose"
+
+	_, err := NewLogger(cfg)
+
+	assert.EqualError(t, err, `invalid log level "verbose", valid levels are trace, debug, info, warn, error`)
+}
+
+func TestBuildWorkerHealthServer(t *testing.T) {
+	// Arrange
+	cfg := &config.Config{}
+	w := worker.NewWorker(fakeQueue{}, worker.NewDispatcher(logger.New(logger.WithOutput(io.Discard))))
+	assert.Nil(t, BuildWorkerHealthServer(cfg, &Repositories{}, w), "not served without a port")
+	cfg.Worker.HealthPort = "0"
+	server := BuildWorkerHealthServer(cfg, &Repositories{}, w).(*healthServer)
+
+	// Act
+	require.NoError(t, w.Drain(time.Now()))
+	rr := httptest.NewRecorder()
+	server.server.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"name":"worker","duration_ns":`)
+	assert.Contains(t, rr.Body.String(), `"status":"fail","message":"draining"`)
+}
+
+func TestShadowUsers(t *testing.T) {
+	log := logger.New()
+	users := service.NewUserService(repository.NewMemoryUserRepository(), log)
+	candidate := service.NewUserService(repository.NewMemoryUserRepository(), log)
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		candidate  service.UserServiceInterface
+		wantShadow bool
+	}{
+		{name: "disabled", candidate: candidate},
+		{name: "no candidate", enabled: true},
+		{name: "enabled", enabled: true, candidate: candidate, wantShadow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			cfg := &config.Config{}
+			cfg.Users.ShadowEnabled, cfg.Users.ShadowRate, cfg.Users.ShadowBudget = tt.enabled, 0.5, time.Second
+
+			// Act
+			got := ShadowUsers(cfg, users, tt.candidate, log)
+
+			// Assert
+			if tt.wantShadow {
+				assert.IsType(t, &service.ShadowUserService{}, got)
+			} else {
+				assert.Same(t, users, got)
+			}
+		})
+	}
+}
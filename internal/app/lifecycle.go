@@ -0,0 +1,32 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Lifecycle collects cleanup functions of constructed components and runs
+// them in reverse order of construction
+type Lifecycle struct {
+	names   []string
+	closers []func() error
+}
+
+// Append registers the cleanup function of a component
+func (l *Lifecycle) Append(name string, fn func() error) {
+	l.names = append(l.names, name)
+	l.closers = append(l.closers, fn)
+}
+
+// Close runs every registered cleanup function, last registered first,
+// and returns all errors that occurred
+func (l *Lifecycle) Close() error {
+	var errs []error
+	for i := len(l.closers) - 1; i >= 0; i-- {
+		if err := l.closers[i](); err != nil {
+			errs = append(errs, fmt.Errorf("close %s: %w", l.names[i], err))
+		}
+	}
+	l.names, l.closers = nil, nil
+	return errors.Join(errs...)
+}
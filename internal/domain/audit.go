@@ -0,0 +1,112 @@
+package domain
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AuditEntry is a recorded administrative or maintenance action. ActorID is
+// who performed it, empty for actions of the system itself.
+type AuditEntry struct {
+	ID        int64           `json:"id" db:"id"`
+	Action    string          `json:"action" db:"action"`
+	Entity    string          `json:"entity" db:"entity"`
+	EntityID  string          `json:"entity_id" db:"entity_id"`
+	ActorID   string          `json:"actor_id,omitempty" db:"actor_id"`
+	Details   json.RawMessage `json:"details,omitempty" db:"details"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+}
+
+// AuditFilter selects audit entries. Empty fields match every entry.
+type AuditFilter struct {
+	ActorID  string
+	EntityID string
+	Action   string
+	// From and To bound created_at to [From, To), a zero time leaves the side open
+	From time.Time
+	To   time.Time
+	// After continues a listing past the entry the cursor points at
+	After *AuditCursor
+	Limit int
+	// IncludeDetails loads the details of the entries, which can be large
+	IncludeDetails bool
+}
+
+// AuditCursor is the position of an entry in the listing order,
+// created_at then id, both descending
+type AuditCursor struct {
+	CreatedAt time.Time
+	ID        int64
+}
+
+// CursorOf returns the cursor pointing at entry
+func CursorOf(entry *AuditEntry) AuditCursor {
+	return AuditCursor{CreatedAt: entry.CreatedAt, ID: entry.ID}
+}
+
+// String encodes the cursor as an opaque token
+func (c AuditCursor) String() string {
+	raw := strconv.FormatInt(c.CreatedAt.UnixMicro(), 10) + ":" + strconv.FormatInt(c.ID, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseAuditCursor decodes a token returned by AuditCursor.String
+func ParseAuditCursor(token string) (AuditCursor, error) {
+	malformed := fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return AuditCursor{}, malformed
+	}
+	micros, id, ok := strings.Cut(string(raw), ":")
+	at, atErr := strconv.ParseInt(micros, 10, 64)
+	n, idErr := strconv.ParseInt(id, 10, 64)
+	if !ok || atErr != nil || idErr != nil {
+		return AuditCursor{}, malformed
+	}
+
+	return AuditCursor{CreatedAt: time.UnixMicro(at).UTC(), ID: n}, nil
+}
+
+// AuditPage is a page of audit entries. NextCursor is empty on the last page.
+type AuditPage struct {
+	Entries    []*AuditEntry `json:"entries"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+// AuditPartition is the audit log partition holding one calendar month (UTC)
+type AuditPartition struct {
+	Name string
+	// Month is the first instant of the month covered by the partition
+	Month time.Time
+}
+
+// End returns the exclusive upper bound of the partition
+func (p AuditPartition) End() time.Time {
+	return p.Month.AddDate(0, 1, 0)
+}
+
+// AuditMonth truncates t to the first instant of its calendar month in UTC
+func AuditMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+type AuditRepository interface {
+	// Create records the entry, setting its ID
+	Create(ctx context.Context, entry *AuditEntry) error
+	// List returns up to filter.Limit entries matching the filter, newest first
+	List(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error)
+	// ListPartitions returns the monthly partitions ordered by month
+	ListPartitions(ctx context.Context) ([]AuditPartition, error)
+	// CreatePartition creates the partition for the month starting at month
+	// unless it already exists
+	CreatePartition(ctx context.Context, month time.Time) (AuditPartition, error)
+	// DropPartition detaches the partition and drops its rows
+	DropPartition(ctx context.Context, partition AuditPartition) error
+}
@@ -0,0 +1,50 @@
+package domain
+
+import (
+	"context"
+	"errors"
+)
+
+// JobUsersBulkDelete is the type of the jobs deleting the users of a filter
+const JobUsersBulkDelete = "users.bulk_delete"
+
+// ErrBulkDeleteTooLarge rejects a bulk delete matching more users than the
+// safety ceiling allows without being forced
+var ErrBulkDeleteTooLarge = errors.New("bulk delete matches more users than allowed")
+
+// BulkDeleteRequest asks to delete the live users matching a filter
+type BulkDeleteRequest struct {
+	Filter UserFilter
+	// DryRun only counts the users that would be deleted
+	DryRun bool
+	// Force lifts the safety ceiling
+	Force bool
+}
+
+// BulkDeleteResult is the outcome of a bulk delete request
+type BulkDeleteResult struct {
+	DryRun bool `json:"dry_run"`
+	// Matched is how many users matched when the request was made
+	Matched int64 `json:"matched"`
+	// Job runs the deletion, nil for a dry run
+	Job *Job `json:"job,omitempty"`
+}
+
+// BulkDeleteParams are the params of a JobUsersBulkDelete job
+type BulkDeleteParams struct {
+	Filter UserFilter `json:"filter"`
+	Force  bool       `json:"force"`
+	// Total is how many users matched when the job was enqueued
+	Total int64 `json:"total"`
+	// Tenant is the tenant the job acts for, its deletion events belong to it
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// UserBulkRepository selects users by filter for bulk operations, always
+// from the primary so that a batch never misses its own earlier deletions
+type UserBulkRepository interface {
+	// CountUsers returns how many live users match the filter
+	CountUsers(ctx context.Context, filter UserFilter) (int64, error)
+	// ListUserIDs returns up to limit IDs of live users matching the filter
+	ListUserIDs(ctx context.Context, filter UserFilter, limit int) ([]string, error)
+}
@@ -0,0 +1,26 @@
+package domain
+
+import "time"
+
+// Event types of the email change flow
+const (
+	// EventEmailChangeRequested asks for a confirmation mail to the new address
+	EventEmailChangeRequested = "user.email_change_requested"
+	// EventEmailChangeNotice notifies the current address about the request
+	EventEmailChangeNotice = "user.email_change_notice"
+)
+
+// EmailChange is a pending email change awaiting confirmation.
+// Only the hash of the confirmation token is stored.
+type EmailChange struct {
+	UserID    string    `json:"user_id" db:"user_id"`
+	NewEmail  string    `json:"new_email" db:"new_email"`
+	TokenHash string    `json:"-" db:"token_hash"`
+	ExpiresAt time.Time `json:"expires_at" db:"expires_at"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Expired reports whether the request can no longer be confirmed
+func (c *EmailChange) Expired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
+}
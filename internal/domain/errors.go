@@ -6,4 +6,24 @@ import "errors"
 var (
 	ErrUserNotFound = errors.New("user not found")
 	ErrInvalidInput = errors.New("invalid input")
+	ErrEmailTaken   = errors.New("email already in use")
+	ErrInvalidPatch = errors.New("invalid patch")
+	ErrTooManyIDs   = errors.New("too many ids requested")
+	ErrJobNotFound  = errors.New("job not found")
+
+	ErrTooManyChanges = errors.New("too many changes to the user")
+
+	// ErrConflict is a write conflicting with an existing record
+	ErrConflict = errors.New("conflicts with an existing record")
+	// ErrInvalidReference is a write referencing a record that does not exist
+	ErrInvalidReference = errors.New("references a record that does not exist")
+
+	ErrEmailChangeNotFound        = errors.New("email change request not found")
+	ErrEmailChangeExpired         = errors.New("email change request expired")
+	ErrEmailChangeRequiresConfirm = errors.New("email must be changed through the confirmation flow")
+
+	ErrWebhookProviderNotFound = errors.New("webhook provider not found")
+	ErrWebhookSignatureInvalid = errors.New("invalid webhook signature")
+	ErrWebhookStale            = errors.New("webhook timestamp outside the replay window")
+	ErrWebhookReplayed         = errors.New("webhook already received")
 )
@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// ArchivedEvent is a relayed outbox event kept for replays. Seq numbers
+// events in the order they were relayed.
+type ArchivedEvent struct {
+	Seq    int64  `json:"seq" db:"seq"`
+	ID     string `json:"id" db:"event_id"`
+	Type   string `json:"type" db:"event_type"`
+	Tenant string `json:"tenant,omitempty" db:"tenant"`
+	// AggregateID and AggregateSeq are those of the relayed event
+	AggregateID  string          `json:"aggregate_id,omitempty" db:"aggregate_id"`
+	AggregateSeq int64           `json:"aggregate_seq,omitempty" db:"aggregate_seq"`
+	Payload      json.RawMessage `json:"payload" db:"payload"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+	ArchivedAt   time.Time       `json:"archived_at" db:"archived_at"`
+}
+
+// Event returns the event as it was relayed
+func (e *ArchivedEvent) Event() *OutboxEvent {
+	return &OutboxEvent{
+		ID:           e.ID,
+		Type:         e.Type,
+		Tenant:       e.Tenant,
+		AggregateID:  e.AggregateID,
+		AggregateSeq: e.AggregateSeq,
+		Payload:      e.Payload,
+		CreatedAt:    e.CreatedAt,
+	}
+}
+
+// EventArchiveFilter selects archived events in sequence order
+type EventArchiveFilter struct {
+	// Type keeps only events of the type, all types when empty
+	Type string
+	// FromSeq is the first sequence number, inclusive
+	FromSeq int64
+	// ToSeq is the last sequence number, inclusive, unbounded when 0
+	ToSeq int64
+	Limit int
+}
+
+type EventArchiveRepository interface {
+	List(ctx context.Context, filter EventArchiveFilter) ([]*ArchivedEvent, error)
+	// Purge deletes the events archived before the cutoff and returns how many were deleted
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
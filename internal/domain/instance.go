@@ -0,0 +1,32 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// ServiceInstance is a running replica of the service together with the
+// fingerprint of the configuration and build it was started with
+type ServiceInstance struct {
+	ID          string    `json:"id" db:"id"`
+	Hostname    string    `json:"hostname" db:"hostname"`
+	StartedAt   time.Time `json:"started_at" db:"started_at"`
+	HeartbeatAt time.Time `json:"heartbeat_at" db:"heartbeat_at"`
+	ConfigHash  string    `json:"config_hash" db:"config_hash"`
+	Version     string    `json:"version" db:"version"`
+}
+
+// InstanceReport lists live instances and whether they disagree on
+// configuration or version
+type InstanceReport struct {
+	Instances       []*ServiceInstance `json:"instances"`
+	ConfigMismatch  bool               `json:"config_mismatch"`
+	VersionMismatch bool               `json:"version_mismatch"`
+}
+
+type InstanceRepository interface {
+	// Register inserts the instance or refreshes its heartbeat if it already exists
+	Register(ctx context.Context, instance *ServiceInstance) error
+	ListLive(ctx context.Context, heartbeatAfter time.Time) ([]*ServiceInstance, error)
+	PruneStale(ctx context.Context, heartbeatBefore time.Time) (int64, error)
+}
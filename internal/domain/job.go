@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// EventJobPrefix prefixes the event type that hands a job to the worker,
+// followed by the job type, e.g. job.users.bulk_delete
+const EventJobPrefix = "job."
+
+// JobEnqueued is the payload of the event handing a job to the worker
+type JobEnqueued struct {
+	JobID string `json:"job_id"`
+}
+
+// JobStatus is the lifecycle state of an asynchronous job
+type JobStatus string
+
+const (
+	JobPending   JobStatus = "pending"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job is a long-running task executed by the worker
+type Job struct {
+	ID     string    `json:"id" db:"id"`
+	Type   string    `json:"type" db:"job_type"`
+	Status JobStatus `json:"status" db:"status"`
+	// Progress is the completion percentage, 0 to 100
+	Progress int    `json:"progress" db:"progress"`
+	Message  string `json:"message,omitempty" db:"message"`
+	// Params is what the job type needs to run, kept private to the worker
+	Params    json.RawMessage `json:"-" db:"params"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at" db:"updated_at"`
+}
+
+// Terminal reports whether the job will not change anymore
+func (j *Job) Terminal() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+type JobRepository interface {
+	Create(ctx context.Context, job *Job) error
+	GetByID(ctx context.Context, id string) (*Job, error)
+	// Enqueue stores the job along with the event handing it to the worker,
+	// so that a job is only ever run if it was stored
+	Enqueue(ctx context.Context, job *Job, event *OutboxEvent) error
+	// Update stores the job state and notifies watchers of the change
+	Update(ctx context.Context, job *Job) error
+}
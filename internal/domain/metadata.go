@@ -0,0 +1,52 @@
+package domain
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Metadata holds free-form string attributes of a user
+type Metadata map[string]string
+
+// SealedMetadata holds the encrypted metadata values of a user by key. The
+// values are opaque to everything but the service that sealed them.
+type SealedMetadata map[string]json.RawMessage
+
+// Value stores the metadata as a JSON object. Nil is stored as NULL, which
+// updates read as leaving the metadata unchanged.
+func (m Metadata) Value() (driver.Value, error) {
+	return jsonValue(m == nil, m)
+}
+
+func (m *Metadata) Scan(src interface{}) error {
+	return scanJSON(src, m)
+}
+
+func (m SealedMetadata) Value() (driver.Value, error) {
+	return jsonValue(m == nil, m)
+}
+
+func (m *SealedMetadata) Scan(src interface{}) error {
+	return scanJSON(src, m)
+}
+
+func jsonValue(null bool, v interface{}) (driver.Value, error) {
+	if null {
+		return nil, nil
+	}
+	return json.Marshal(v)
+}
+
+func scanJSON(src interface{}, dst interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, dst)
+	case string:
+		return json.Unmarshal([]byte(v), dst)
+	default:
+		return fmt.Errorf("cannot scan %T into %T", src, dst)
+	}
+}
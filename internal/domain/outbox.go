@@ -0,0 +1,91 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event types published through the outbox
+const (
+	EventUserDeleted = "user.deleted"
+	// EventUserCleanup retries a user deletion hook that failed after the
+	// deletion was committed
+	EventUserCleanup = "user.cleanup"
+)
+
+// UserCleanup is the payload of a user.cleanup event
+type UserCleanup struct {
+	UserID string `json:"user_id"`
+	// Hook is the name the deletion hook to retry was registered under
+	Hook string `json:"hook"`
+	// Tenant is the tenant of the deleted user, empty without one
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// OutboxEvent is an event stored alongside the data change that produced it
+// and relayed to the message broker afterwards. Events about the same
+// aggregate are published in the order of their AggregateSeq, the order of
+// events about different aggregates is unspecified.
+type OutboxEvent struct {
+	ID   string `json:"id" db:"id"`
+	Type string `json:"type" db:"event_type"`
+	// Tenant is the tenant the event belongs to, empty for events without one
+	Tenant string `json:"tenant,omitempty" db:"tenant"`
+	// AggregateID is what the event is about, e.g. the ID of a user, empty
+	// for events about nothing in particular
+	AggregateID string `json:"aggregate_id,omitempty" db:"aggregate_id"`
+	// AggregateSeq numbers the events of the aggregate from 1, in the order
+	// they were recorded. It is assigned when the event is recorded, 0 for
+	// events without an aggregate.
+	AggregateSeq int64           `json:"aggregate_seq,omitempty" db:"aggregate_seq"`
+	Payload      json.RawMessage `json:"payload" db:"payload"`
+	// Secret holds payload fields too sensitive to keep, such as a
+	// confirmation token, as a JSON object. It is merged into the payload
+	// only when the event is published, cleared once it is, and never
+	// archived, so replays go without it.
+	Secret      json.RawMessage `json:"-" db:"secret"`
+	CreatedAt   time.Time       `json:"created_at" db:"created_at"`
+	PublishedAt *time.Time      `json:"published_at,omitempty" db:"published_at"`
+}
+
+// Revealed returns the event to publish, with the fields of Secret merged
+// into its payload. An event without a secret is returned as is.
+func (e *OutboxEvent) Revealed() (*OutboxEvent, error) {
+	if len(e.Secret) == 0 {
+		return e, nil
+	}
+
+	var payload, secret map[string]json.RawMessage
+	if err := json.Unmarshal(e.Payload, &payload); err != nil {
+		return nil, fmt.Errorf("event %s payload: %w", e.ID, err)
+	}
+	if err := json.Unmarshal(e.Secret, &secret); err != nil {
+		return nil, fmt.Errorf("event %s secret: %w", e.ID, err)
+	}
+	if payload == nil {
+		payload = make(map[string]json.RawMessage, len(secret))
+	}
+	for key, value := range secret {
+		payload[key] = value
+	}
+
+	merged, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	revealed := *e
+	revealed.Payload, revealed.Secret = merged, nil
+	return &revealed, nil
+}
+
+type OutboxRepository interface {
+	// Append records events to relay, within the transaction of ctx if any,
+	// and numbers those with an aggregate
+	Append(ctx context.Context, events ...*OutboxEvent) error
+	// ListPending returns events not published yet in the order to publish
+	// them, which keeps the events of each aggregate in sequence
+	ListPending(ctx context.Context, limit int) ([]*OutboxEvent, error)
+	MarkPublished(ctx context.Context, id string) error
+}
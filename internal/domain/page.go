@@ -0,0 +1,68 @@
+package domain
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Directions a list can be sorted in
+const (
+	SortAsc  = "asc"
+	SortDesc = "desc"
+)
+
+// DefaultMaxPageLimit is the most items of a page unless configured otherwise
+const DefaultMaxPageLimit = 100
+
+// PageRequest selects a page of a list and how the list is ordered
+type PageRequest struct {
+	// Limit is the most items of the page, 0 for all of them. Validate
+	// bounds it.
+	Limit int
+	// Offset is the number of items skipped before the page
+	Offset int
+	// SortBy is the field the list is ordered by, empty for its default
+	SortBy string
+	// SortDir is SortAsc or SortDesc, empty for the default of SortBy
+	SortDir string
+}
+
+// Validate returns the request with Limit clamped to maxLimit, a zero Limit
+// taking maxLimit. It fails with ErrInvalidInput for a negative limit or
+// offset, a sort field other than sortable ones or an unknown direction.
+func (p PageRequest) Validate(maxLimit int, sortable ...string) (PageRequest, error) {
+	switch {
+	case p.Limit < 0:
+		return p, fmt.Errorf("%w: negative limit %d", ErrInvalidInput, p.Limit)
+	case p.Offset < 0:
+		return p, fmt.Errorf("%w: negative offset %d", ErrInvalidInput, p.Offset)
+	case p.SortBy != "" && !slices.Contains(sortable, p.SortBy):
+		return p, fmt.Errorf("%w: unknown sort field %q", ErrInvalidInput, p.SortBy)
+	case p.SortDir != "" && p.SortDir != SortAsc && p.SortDir != SortDesc:
+		return p, fmt.Errorf("%w: unknown sort direction %q, valid directions are asc, desc", ErrInvalidInput, p.SortDir)
+	}
+
+	if p.Limit == 0 || p.Limit > maxLimit {
+		p.Limit = maxLimit
+	}
+	return p, nil
+}
+
+// Bounds returns the indexes of the first item of the page and of the one
+// after it within a list of n items, e.g. to page a list held in memory
+func (p PageRequest) Bounds(n int) (start, end int) {
+	start = min(p.Offset, n)
+	end = n
+	if p.Limit > 0 {
+		end = min(start+p.Limit, n)
+	}
+	return start, end
+}
+
+// UserPage is a page of users. Total counts the users of the whole list.
+type UserPage struct {
+	Users  []*User `json:"users"`
+	Total  int64   `json:"total"`
+	Limit  int     `json:"limit"`
+	Offset int     `json:"offset"`
+}
@@ -0,0 +1,11 @@
+package domain
+
+import "context"
+
+// Transactor runs functions within a database transaction. Repositories
+// called with the context passed to fn take part in the transaction, which
+// is committed when fn returns nil and rolled back otherwise. A call within
+// fn joins the transaction already running.
+type Transactor interface {
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
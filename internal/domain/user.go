@@ -2,22 +2,282 @@ package domain
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"time"
+
+	"golang.org/x/text/language"
 )
 
 type User struct {
+	ID        string     `json:"id" db:"id"`
+	Email     string     `json:"email" db:"email"`
+	Password  string     `json:"-" db:"password_hash"`
+	Name      string     `json:"name" db:"name"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+	DeletedAt *time.Time `json:"-" db:"deleted_at"`
+
+	// Metadata holds the attributes of the user that are stored in clear
+	Metadata Metadata `json:"metadata,omitempty" db:"metadata"`
+	// SealedMetadata holds the attributes of sensitive keys, encrypted. It is
+	// never serialized, see service.WithMetadataEncryption.
+	SealedMetadata SealedMetadata `json:"-" db:"metadata_encrypted"`
+}
+
+// UserChanges is a set of field changes to apply to a user.
+// A nil field is left unchanged.
+type UserChanges struct {
+	Email *string
+	Name  *string
+}
+
+// Apply sets the changed fields on the user
+func (c UserChanges) Apply(u *User) {
+	if c.Email != nil {
+		u.Email = *c.Email
+	}
+	if c.Name != nil {
+		u.Name = *c.Name
+	}
+}
+
+// Tombstone is the minimal record of a deleted user kept for consumers that
+// mirror users and need to reconcile deletions they may have missed
+type Tombstone struct {
 	ID        string    `json:"id" db:"id"`
-	Email     string    `json:"email" db:"email"`
-	Password  string    `json:"-" db:"password_hash"`
-	Name      string    `json:"name" db:"name"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	DeletedAt time.Time `json:"deleted_at" db:"deleted_at"`
+	// Tenant is reserved for multi-tenant deployments and is empty otherwise
+	Tenant string `json:"tenant,omitempty" db:"-"`
+}
+
+// Audit actions recorded for throttled user mutations
+const (
+	AuditUserMutationsThrottled = "user.mutations_throttled"
+	AuditEntityUser             = "user"
+)
+
+// Fields a user list can be sorted by
+const (
+	UserSortCreatedAt = "created_at"
+	UserSortName      = "name"
+)
+
+// UserSortFields are the fields a user list can be sorted by
+var UserSortFields = []string{UserSortCreatedAt, UserSortName}
+
+// UserListOptions controls which users a list holds and how it is ordered
+type UserListOptions struct {
+	// PageRequest selects the page. SortBy is one of the UserSort fields,
+	// empty means UserSortCreatedAt, newest first by default. Names are
+	// ordered A to Z by default.
+	PageRequest
+	// Collation is the BCP 47 locale used to order names, empty for the default
+	Collation string
+	// Filter selects the users listed, all live users when zero
+	Filter UserFilter
+}
+
+// ParseUserSort parses a sort parameter of the form field[:locale],
+// e.g. "name:de-DE". The locale is only allowed when sorting by name.
+func ParseUserSort(raw string) (UserListOptions, error) {
+	var opts UserListOptions
+	if raw == "" {
+		return opts, nil
+	}
+
+	field, locale, hasLocale := strings.Cut(raw, ":")
+	switch field {
+	case UserSortCreatedAt, UserSortName:
+		opts.SortBy = field
+	default:
+		return opts, fmt.Errorf("%w: unknown sort field %q", ErrInvalidInput, field)
+	}
+
+	if !hasLocale {
+		return opts, nil
+	}
+	if field != UserSortName {
+		return opts, fmt.Errorf("%w: collation is only supported when sorting by name", ErrInvalidInput)
+	}
+
+	tag, err := language.Parse(locale)
+	if err != nil {
+		return opts, fmt.Errorf("%w: invalid collation %q", ErrInvalidInput, locale)
+	}
+	opts.Collation = tag.String()
+
+	return opts, nil
+}
+
+// Fields a user filter can select on
+const (
+	UserFilterEmailDomain   = "email_domain"
+	UserFilterNamePrefix    = "name_prefix"
+	UserFilterCreatedAfter  = "created_after"
+	UserFilterCreatedBefore = "created_before"
+)
+
+// UserFilter selects live users. Zero fields do not restrict the selection.
+type UserFilter struct {
+	// EmailDomain matches the part of the email after the @, case-insensitively
+	EmailDomain string `json:"email_domain,omitempty"`
+	// NamePrefix matches the start of the name, case-sensitively
+	NamePrefix string `json:"name_prefix,omitempty"`
+	// CreatedAfter and CreatedBefore bound the creation time, exclusively
+	CreatedAfter  time.Time `json:"created_after"`
+	CreatedBefore time.Time `json:"created_before"`
+	// Where is an expression the users must also satisfy, nil for none
+	Where UserCondition `json:"-"`
+}
+
+// IsZero reports whether the filter selects every live user
+func (f UserFilter) IsZero() bool {
+	return f == UserFilter{}
+}
+
+// Matches reports whether the filter selects the user, deleted users aside
+func (f UserFilter) Matches(u *User) bool {
+	if f.EmailDomain != "" {
+		_, domain, _ := strings.Cut(u.Email, "@")
+		if !strings.EqualFold(domain, f.EmailDomain) {
+			return false
+		}
+	}
+	if !strings.HasPrefix(u.Name, f.NamePrefix) {
+		return false
+	}
+	if !f.CreatedAfter.IsZero() && !u.CreatedAt.After(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !u.CreatedAt.Before(f.CreatedBefore) {
+		return false
+	}
+	if f.Where != nil && !f.Where.Matches(u) {
+		return false
+	}
+	return true
+}
+
+// String returns the filter in the form ParseUserFilter accepts
+func (f UserFilter) String() string {
+	var terms []string
+	if f.EmailDomain != "" {
+		terms = append(terms, UserFilterEmailDomain+":"+f.EmailDomain)
+	}
+	if f.NamePrefix != "" {
+		terms = append(terms, UserFilterNamePrefix+":"+f.NamePrefix)
+	}
+	if !f.CreatedAfter.IsZero() {
+		terms = append(terms, UserFilterCreatedAfter+":"+f.CreatedAfter.Format(time.RFC3339Nano))
+	}
+	if !f.CreatedBefore.IsZero() {
+		terms = append(terms, UserFilterCreatedBefore+":"+f.CreatedBefore.Format(time.RFC3339Nano))
+	}
+	return strings.Join(terms, ",")
+}
+
+// ParseUserFilter parses a filter parameter of comma-separated field:value
+// terms, e.g. "email_domain:example.com,created_before:2024-01-01T00:00:00Z".
+// Times are RFC 3339 and values cannot contain commas.
+func ParseUserFilter(raw string) (UserFilter, error) {
+	var f UserFilter
+	if raw == "" {
+		return f, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, term := range strings.Split(raw, ",") {
+		field, value, ok := strings.Cut(term, ":")
+		field = strings.TrimSpace(field)
+		if !ok || value == "" {
+			return f, fmt.Errorf("%w: filter term %q must be of the form field:value", ErrInvalidInput, term)
+		}
+		if seen[field] {
+			return f, fmt.Errorf("%w: filter field %q is given twice", ErrInvalidInput, field)
+		}
+		seen[field] = true
+
+		switch field {
+		case UserFilterEmailDomain:
+			f.EmailDomain = strings.ToLower(strings.TrimPrefix(value, "@"))
+		case UserFilterNamePrefix:
+			f.NamePrefix = value
+		case UserFilterCreatedAfter, UserFilterCreatedBefore:
+			t, err := time.Parse(time.RFC3339Nano, value)
+			if err != nil {
+				return f, fmt.Errorf("%w: %s must be an RFC 3339 time", ErrInvalidInput, field)
+			}
+			if field == UserFilterCreatedAfter {
+				f.CreatedAfter = t
+			} else {
+				f.CreatedBefore = t
+			}
+		default:
+			return f, fmt.Errorf("%w: unknown filter field %q", ErrInvalidInput, field)
+		}
+	}
+
+	if !f.CreatedAfter.IsZero() && !f.CreatedBefore.IsZero() && !f.CreatedAfter.Before(f.CreatedBefore) {
+		return f, fmt.Errorf("%w: created_after must be before created_before", ErrInvalidInput)
+	}
+
+	return f, nil
+}
+
+// UserLookup is the result of resolving a batch of user IDs.
+// Every requested ID is either a key of Found or listed in Missing.
+type UserLookup struct {
+	Found   map[string]*User
+	Missing []string
 }
 
 type UserRepository interface {
 	Create(ctx context.Context, user *User) error
 	GetByID(ctx context.Context, id string) (*User, error)
+	GetByIDs(ctx context.Context, ids []string) ([]*User, error)
 	Update(ctx context.Context, user *User) error
 	Delete(ctx context.Context, id string) error
-	List(ctx context.Context) ([]*User, error)
+	// List returns the page of the users listed by opts, every user when
+	// opts.Limit is 0
+	List(ctx context.Context, opts UserListOptions) (*UserPage, error)
+	// CollationExists reports whether the database can order by the collation
+	CollationExists(ctx context.Context, collation string) (bool, error)
+	ListTombstones(ctx context.Context, since time.Time) ([]*Tombstone, error)
+	Purge(ctx context.Context, deletedBefore time.Time) (int64, error)
+	ExpireTombstones(ctx context.Context, deletedBefore time.Time) (int64, error)
+
+	EmailExists(ctx context.Context, email string) (bool, error)
+	CreateEmailChange(ctx context.Context, change *EmailChange, events []*OutboxEvent) error
+	GetEmailChangeByTokenHash(ctx context.Context, tokenHash string) (*EmailChange, error)
+	ConfirmEmailChange(ctx context.Context, change *EmailChange) error
+	CancelEmailChange(ctx context.Context, userID string) error
+}
+
+// RecentUserRepository lists users by activity. A user is seen when it is
+// created or changed, and when MarkSeen records it.
+type RecentUserRepository interface {
+	// ListRecentlySeen returns up to limit live users, most recently seen first
+	ListRecentlySeen(ctx context.Context, limit int) ([]*User, error)
+	// MarkSeen records that the live user id was seen at, unless it was
+	// seen later already
+	MarkSeen(ctx context.Context, id string, at time.Time) error
+}
+
+// SealedMetadataRepository rewrites the sealed metadata of a user and nothing
+// else, as when its values are sealed again under a new master key
+type SealedMetadataRepository interface {
+	// ReplaceSealedMetadata replaces the sealed metadata of a live user if it
+	// is still old, and reports whether it did
+	ReplaceSealedMetadata(ctx context.Context, id string, old, sealed SealedMetadata) (bool, error)
+}
+
+// UserCache holds users looked up by ID
+type UserCache interface {
+	// Ping reports whether the cache backend is available
+	Ping(ctx context.Context) error
+	// Prime caches the user ahead of its lookups
+	Prime(ctx context.Context, user *User) error
+	// Invalidate drops the cached user
+	Invalidate(ctx context.Context, id string) error
 }
@@ -0,0 +1,148 @@
+package domain
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// UserField is a field of a user a UserCondition compares
+type UserField string
+
+const (
+	UserFieldID    UserField = "id"
+	UserFieldEmail UserField = "email"
+	// UserFieldEmailDomain is the part of the email after the @, lowercased
+	UserFieldEmailDomain UserField = "email_domain"
+	UserFieldName        UserField = "name"
+	UserFieldCreatedAt   UserField = "created_at"
+	UserFieldUpdatedAt   UserField = "updated_at"
+)
+
+// Comparison is how a UserTerm compares a field with its value
+type Comparison string
+
+const (
+	CompareEq     Comparison = "eq"
+	CompareNe     Comparison = "ne"
+	CompareLt     Comparison = "lt"
+	CompareLte    Comparison = "lte"
+	CompareGt     Comparison = "gt"
+	CompareGte    Comparison = "gte"
+	ComparePrefix Comparison = "prefix"
+)
+
+// UserCondition is a boolean expression over user fields, such as one parsed
+// from the where parameter of a user listing: a *UserTerm, *UserNot, *UserAnd
+// or *UserOr. Repositories render it in their own query language.
+type UserCondition interface {
+	// Matches reports whether the user satisfies the condition
+	Matches(u *User) bool
+	// String returns the condition with its grouping made explicit
+	String() string
+	userCondition()
+}
+
+// UserTerm compares a field of the user with a value, a string for the
+// string fields and a time.Time for the times
+type UserTerm struct {
+	Field UserField
+	Op    Comparison
+	Value interface{}
+}
+
+// UserNot negates a condition
+type UserNot struct {
+	X UserCondition
+}
+
+// UserAnd holds when both conditions hold
+type UserAnd struct {
+	Left, Right UserCondition
+}
+
+// UserOr holds when either condition holds
+type UserOr struct {
+	Left, Right UserCondition
+}
+
+func (*UserTerm) userCondition() {}
+func (*UserNot) userCondition()  {}
+func (*UserAnd) userCondition()  {}
+func (*UserOr) userCondition()   {}
+
+func (t *UserTerm) Matches(u *User) bool {
+	var cmp int
+	switch have := t.field(u).(type) {
+	case string:
+		want, _ := t.Value.(string)
+		if t.Op == ComparePrefix {
+			return strings.HasPrefix(have, want)
+		}
+		cmp = strings.Compare(have, want)
+	case time.Time:
+		want, _ := t.Value.(time.Time)
+		cmp = have.Compare(want)
+	default:
+		return false
+	}
+
+	switch t.Op {
+	case CompareEq:
+		return cmp == 0
+	case CompareNe:
+		return cmp != 0
+	case CompareLt:
+		return cmp < 0
+	case CompareLte:
+		return cmp <= 0
+	case CompareGt:
+		return cmp > 0
+	case CompareGte:
+		return cmp >= 0
+	}
+	return false
+}
+
+// field returns the value of the field of the term for u
+func (t *UserTerm) field(u *User) interface{} {
+	switch t.Field {
+	case UserFieldID:
+		return u.ID
+	case UserFieldEmail:
+		return u.Email
+	case UserFieldEmailDomain:
+		_, domain, _ := strings.Cut(u.Email, "@")
+		return strings.ToLower(domain)
+	case UserFieldName:
+		return u.Name
+	case UserFieldCreatedAt:
+		return u.CreatedAt
+	case UserFieldUpdatedAt:
+		return u.UpdatedAt
+	}
+	return nil
+}
+
+func (n *UserNot) Matches(u *User) bool { return !n.X.Matches(u) }
+func (a *UserAnd) Matches(u *User) bool { return a.Left.Matches(u) && a.Right.Matches(u) }
+func (o *UserOr) Matches(u *User) bool  { return o.Left.Matches(u) || o.Right.Matches(u) }
+
+// String returns the term in the form of the where parameter, times in RFC 3339
+func (t *UserTerm) String() string {
+	var value string
+	switch v := t.Value.(type) {
+	case string:
+		value = v
+	case time.Time:
+		value = v.Format(time.RFC3339Nano)
+	}
+	if value == "" || strings.ContainsAny(value, " \t()\"\\") {
+		value = strconv.Quote(value)
+	}
+	return string(t.Field) + ":" + string(t.Op) + ":" + value
+}
+
+func (n *UserNot) String() string { return "NOT " + n.X.String() }
+func (a *UserAnd) String() string { return "(" + a.Left.String() + " AND " + a.Right.String() + ")" }
+func (o *UserOr) String() string  { return "(" + o.Left.String() + " OR " + o.Right.String() + ")" }
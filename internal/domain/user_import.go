@@ -0,0 +1,60 @@
+package domain
+
+import "errors"
+
+// JobUsersImport is the type of the jobs creating the users of an uploaded file
+const JobUsersImport = "users.import"
+
+// Formats of user import files
+const (
+	// ImportCSV files have a header row naming the email, password and name
+	// columns, in any order
+	ImportCSV = "csv"
+	// ImportNDJSON files hold one JSON object per line, with the email,
+	// password, name and metadata fields of a new user
+	ImportNDJSON = "ndjson"
+)
+
+// MaxImportRowErrors is how many row errors an import job keeps
+const MaxImportRowErrors = 20
+
+var (
+	// ErrImportTooLarge rejects import files larger than allowed
+	ErrImportTooLarge = errors.New("import file too large")
+	// ErrImportStagingFull rejects imports while staged files use all the
+	// room allowed to them
+	ErrImportStagingFull = errors.New("import staging is full")
+)
+
+// UserImportParams are the params of a JobUsersImport job. The file is read
+// from Offset on, which the job moves forward after each chunk of rows, so
+// that a job interrupted by a crash resumes where it stopped.
+type UserImportParams struct {
+	Format string `json:"format"`
+	// File is the name of the staged file, with its size and checksum
+	File     string `json:"file"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+	// Columns are the columns of a CSV file, read from its header row
+	Columns []string `json:"columns,omitempty"`
+	// Offset is the byte offset of the first row not processed yet
+	Offset int64 `json:"offset"`
+	// Rows counts the rows processed, to number the rows of errors
+	Rows    int64 `json:"rows"`
+	Created int64 `json:"created"`
+	// Skipped counts the rows whose email is already in use, which includes
+	// the rows created again after a crash
+	Skipped int64 `json:"skipped"`
+	Failed  int64 `json:"failed"`
+	// Errors are the first MaxImportRowErrors invalid rows
+	Errors []UserImportRowError `json:"errors,omitempty"`
+	// Tenant is the tenant the job acts for
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// UserImportRowError is a row that could not be imported
+type UserImportRowError struct {
+	// Row is the number of the row, 1 for the first row after a CSV header
+	Row    int64  `json:"row"`
+	Reason string `json:"reason"`
+}
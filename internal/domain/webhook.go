@@ -0,0 +1,56 @@
+package domain
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"time"
+)
+
+// EventWebhookPrefix prefixes the event type of verified webhooks, followed by
+// the provider name, e.g. webhook.stripe
+const EventWebhookPrefix = "webhook."
+
+// Audit actions recorded for rejected webhooks
+const (
+	AuditWebhookRejected = "webhook.rejected"
+	AuditEntityWebhook   = "webhook"
+)
+
+// Defaults of WebhookProvider
+const (
+	DefaultWebhookSignatureHeader = "X-Webhook-Signature"
+	DefaultWebhookTimestampHeader = "X-Webhook-Timestamp"
+	DefaultWebhookAlgorithm       = "sha256"
+)
+
+// WebhookAlgorithms are the HMAC hash functions a provider may sign with
+var WebhookAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// WebhookProvider is a sender of inbound webhooks. It signs
+// "<timestamp>.<body>" with HMAC using the shared secret and sends the hex
+// digest, optionally prefixed with "<algorithm>=", in SignatureHeader and the
+// Unix time in seconds in TimestampHeader.
+type WebhookProvider struct {
+	Name            string
+	Secret          string
+	SignatureHeader string
+	TimestampHeader string
+	Algorithm       string
+}
+
+// WebhookRequest is an inbound webhook before verification
+type WebhookRequest struct {
+	Provider string
+	// Header returns the value of a request header
+	Header func(name string) string
+	Body   []byte
+	// RemoteAddr is recorded when the webhook is rejected
+	RemoteAddr string
+	ReceivedAt time.Time
+}
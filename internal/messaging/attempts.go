@@ -0,0 +1,33 @@
+package messaging
+
+// AttemptsHeader carries how many times handlers failed a message. The
+// broker's redelivered flag cannot tell, as a message is also requeued when
+// its handler times out or the worker shuts down without handling it.
+const AttemptsHeader = "x-attempts"
+
+// Attempts returns how many times handlers failed the delivery, 0 for a
+// message never failed
+func (d Delivery) Attempts() int {
+	n, _ := headerInt(d.Headers[AttemptsHeader])
+	return int(max(n, 0))
+}
+
+// Retry returns the delivery to publish again after a failed attempt, with
+// the attempts counted in AttemptsHeader
+func (d Delivery) Retry() Publishing {
+	headers := make(Headers, len(d.Headers)+1)
+	for key, value := range d.Headers {
+		headers[key] = value
+	}
+	headers[AttemptsHeader] = int64(d.Attempts() + 1)
+
+	return Publishing{
+		MessageID:   d.MessageID,
+		Type:        d.Type,
+		ContentType: d.ContentType,
+		Timestamp:   d.Timestamp,
+		Headers:     headers,
+		Body:        d.Body,
+		Persistent:  true,
+	}
+}
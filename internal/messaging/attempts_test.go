@@ -0,0 +1,51 @@
+package messaging
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelivery_Attempts(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers Headers
+		want    int
+	}{
+		{name: "never failed"},
+		{name: "int64", headers: Headers{AttemptsHeader: int64(2)}, want: 2},
+		{name: "int32", headers: Headers{AttemptsHeader: int32(1)}, want: 1},
+		{name: "string", headers: Headers{AttemptsHeader: "3"}, want: 3},
+		{name: "negative", headers: Headers{AttemptsHeader: int64(-1)}},
+		{name: "not a number", headers: Headers{AttemptsHeader: "many"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Delivery{Headers: tt.headers}.Attempts())
+		})
+	}
+}
+
+func TestDelivery_Retry(t *testing.T) {
+	// Arrange
+	d := Delivery{
+		MessageID:   "m-1",
+		Type:        "user.cleanup",
+		ContentType: "application/json",
+		Headers:     Headers{TenantHeader: "acme", AttemptsHeader: int32(1)},
+		Body:        []byte(`{}`),
+		Redelivered: true,
+	}
+
+	// Act
+	retry := d.Retry()
+
+	// Assert
+	assert.Equal(t, Headers{TenantHeader: "acme", AttemptsHeader: int64(2)}, retry.Headers)
+	assert.Equal(t, int32(1), d.Headers[AttemptsHeader], "the delivery is left as is")
+	assert.Equal(t, "m-1", retry.MessageID)
+	assert.Equal(t, "user.cleanup", retry.Type)
+	assert.Equal(t, []byte(`{}`), retry.Body)
+	assert.True(t, retry.Persistent)
+}
@@ -0,0 +1,80 @@
+// Package messaging defines the broker message types the worker and its
+// handlers depend on, independent of the AMQP driver in use
+package messaging
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrDeliveryNotInitialized is returned when settling a delivery that did
+// not come from a broker
+var ErrDeliveryNotInitialized = errors.New("delivery not initialized")
+
+// Acknowledger settles deliveries on the channel they were received on
+type Acknowledger interface {
+	Ack(tag uint64, multiple bool) error
+	Nack(tag uint64, multiple, requeue bool) error
+	Reject(tag uint64, requeue bool) error
+}
+
+// Headers are the application headers of a message. Nested tables are
+// plain maps.
+type Headers map[string]interface{}
+
+// Delivery is a message received from the broker
+type Delivery struct {
+	Acknowledger Acknowledger
+
+	DeliveryTag uint64
+	Redelivered bool
+	Exchange    string
+	RoutingKey  string
+	// Queue is the queue the delivery was consumed from, where a retry of
+	// the message is published
+	Queue string
+
+	MessageID   string
+	Type        string
+	ContentType string
+	Timestamp   time.Time
+	Headers     Headers
+	Body        []byte
+}
+
+// Ack acknowledges the delivery, and all earlier ones when multiple is set
+func (d Delivery) Ack(multiple bool) error {
+	if d.Acknowledger == nil {
+		return ErrDeliveryNotInitialized
+	}
+	return d.Acknowledger.Ack(d.DeliveryTag, multiple)
+}
+
+// Nack negatively acknowledges the delivery, returning it to the queue when
+// requeue is set
+func (d Delivery) Nack(multiple, requeue bool) error {
+	if d.Acknowledger == nil {
+		return ErrDeliveryNotInitialized
+	}
+	return d.Acknowledger.Nack(d.DeliveryTag, multiple, requeue)
+}
+
+// Reject rejects the delivery, returning it to the queue when requeue is set
+func (d Delivery) Reject(requeue bool) error {
+	if d.Acknowledger == nil {
+		return ErrDeliveryNotInitialized
+	}
+	return d.Acknowledger.Reject(d.DeliveryTag, requeue)
+}
+
+// Publishing is a message to publish
+type Publishing struct {
+	MessageID   string
+	Type        string
+	ContentType string
+	Timestamp   time.Time
+	Headers     Headers
+	Body        []byte
+	// Persistent messages survive a broker restart when their queue is durable
+	Persistent bool
+}
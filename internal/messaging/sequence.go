@@ -0,0 +1,61 @@
+package messaging
+
+import (
+	"hash/fnv"
+	"strconv"
+)
+
+// AggregateHeader carries what an event is about, e.g. the ID of a user,
+// absent for events about nothing in particular
+const AggregateHeader = "x-aggregate-id"
+
+// SequenceHeader carries the number of an event among the events of its
+// aggregate, counted from 1 in the order they were recorded
+const SequenceHeader = "x-aggregate-seq"
+
+// Sequence returns the aggregate of the delivery and its number among the
+// events of the aggregate. Events of one aggregate are published in
+// sequence, but concurrent handlers and redeliveries may still receive them
+// out of order: a handler that depends on their order compares seq with the
+// last one it applied, and skips or buffers the event when it is not the
+// next. ok is false for events without an aggregate.
+func (d Delivery) Sequence() (aggregateID string, seq int64, ok bool) {
+	aggregateID, _ = d.Headers[AggregateHeader].(string)
+	if aggregateID == "" {
+		return "", 0, false
+	}
+
+	if seq, _ = headerInt(d.Headers[SequenceHeader]); seq <= 0 {
+		return "", 0, false
+	}
+	return aggregateID, seq, true
+}
+
+// headerInt returns the integer a header carries. AMQP tables decode
+// integers to the width they were sent with.
+func headerInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case int32:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// Lane returns which of n ordered publishing lanes the events of the
+// aggregate go through, by consistent hashing like Partitioner. Every
+// event of an aggregate goes through the same lane.
+func Lane(aggregateID string, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(aggregateID))
+	return jumpHash(h.Sum64(), n)
+}
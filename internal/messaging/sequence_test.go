@@ -0,0 +1,53 @@
+package messaging
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelivery_Sequence(t *testing.T) {
+	tests := []struct {
+		name          string
+		headers       Headers
+		wantAggregate string
+		wantSeq       int64
+		wantOK        bool
+	}{
+		{name: "sequenced", headers: Headers{AggregateHeader: "user-1", SequenceHeader: int64(3)}, wantAggregate: "user-1", wantSeq: 3, wantOK: true},
+		{name: "narrow integer", headers: Headers{AggregateHeader: "user-1", SequenceHeader: int32(3)}, wantAggregate: "user-1", wantSeq: 3, wantOK: true},
+		{name: "string number", headers: Headers{AggregateHeader: "user-1", SequenceHeader: "3"}, wantAggregate: "user-1", wantSeq: 3, wantOK: true},
+		{name: "without aggregate", headers: Headers{TenantHeader: "acme"}},
+		{name: "without number", headers: Headers{AggregateHeader: "user-1"}},
+		{name: "no headers"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			aggregate, seq, ok := Delivery{Headers: tt.headers}.Sequence()
+
+			// Assert
+			assert.Equal(t, tt.wantAggregate, aggregate)
+			assert.Equal(t, tt.wantSeq, seq)
+			assert.Equal(t, tt.wantOK, ok)
+		})
+	}
+}
+
+func TestLane(t *testing.T) {
+	// Assert: an aggregate always goes through the same lane, within bounds
+	used := map[int]bool{}
+	for i := 0; i < 100; i++ {
+		aggregate := fmt.Sprintf("user-%d", i)
+		lane := Lane(aggregate, 4)
+		assert.Equal(t, lane, Lane(aggregate, 4))
+		assert.GreaterOrEqual(t, lane, 0)
+		assert.Less(t, lane, 4)
+		used[lane] = true
+	}
+	assert.Len(t, used, 4, "aggregates are spread over every lane")
+	assert.Zero(t, Lane("user-1", 1))
+	assert.Zero(t, Lane("user-1", 0))
+}
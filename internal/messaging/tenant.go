@@ -0,0 +1,92 @@
+package messaging
+
+import (
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strings"
+)
+
+// TenantHeader carries the tenant of an event, absent for events without one
+const TenantHeader = "x-tenant"
+
+// DefaultPartition receives the events without a tenant
+const DefaultPartition = "default"
+
+// routingKeyReplacer keeps a tenant a single word of a topic routing key
+var routingKeyReplacer = strings.NewReplacer(".", "_", "*", "_", "#", "_")
+
+// Partitioner assigns tenants to partitions of the event stream. Without a
+// bound every known tenant is its own partition and other tenants share the
+// default one, so no event is routed to a partition nobody consumes. With a
+// bound, tenants are spread over the partitions p0 to p<n-1> by consistent
+// hashing, so a tenant keeps its partition across releases and raising the
+// bound moves as few tenants as possible.
+type Partitioner struct {
+	bound   int
+	tenants []string
+}
+
+// NewPartitioner returns a partitioner over at most bound partitions, or
+// over the partitions of the given tenants when bound is 0
+func NewPartitioner(bound int, tenants ...string) Partitioner {
+	return Partitioner{bound: max(bound, 0), tenants: tenants}
+}
+
+// Bounded reports whether tenants share a fixed set of partitions
+func (p Partitioner) Bounded() bool {
+	return p.bound > 0
+}
+
+// Partition returns the partition of the tenant
+func (p Partitioner) Partition(tenant string) string {
+	if tenant == "" {
+		return DefaultPartition
+	}
+	if !p.Bounded() {
+		if !slices.Contains(p.tenants, tenant) {
+			return DefaultPartition
+		}
+		return routingKeyReplacer.Replace(tenant)
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(tenant))
+	return fmt.Sprintf("p%d", jumpHash(h.Sum64(), p.bound))
+}
+
+// Partitions returns every partition, the default one first
+func (p Partitioner) Partitions() []string {
+	partitions := []string{DefaultPartition}
+	if p.Bounded() {
+		for i := 0; i < p.bound; i++ {
+			partitions = append(partitions, fmt.Sprintf("p%d", i))
+		}
+		return partitions
+	}
+
+	for _, tenant := range p.tenants {
+		if partition := p.Partition(tenant); !slices.Contains(partitions, partition) {
+			partitions = append(partitions, partition)
+		}
+	}
+	return partitions
+}
+
+// RoutingKey returns the routing key of an event type in a partition, e.g.
+// user.created.acme
+func RoutingKey(eventType, partition string) string {
+	return eventType + "." + partition
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach, mapping key to
+// one of n buckets
+func jumpHash(key uint64, n int) int {
+	var b, j int64 = -1, 0
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
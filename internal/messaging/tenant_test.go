@@ -0,0 +1,77 @@
+package messaging
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoutingKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		bound   int
+		tenants []string
+		tenant  string
+		want    string
+	}{
+		{name: "tenant", tenants: []string{"acme"}, tenant: "acme", want: "user.created.acme"},
+		{name: "no tenant", tenants: []string{"acme"}, tenant: "", want: "user.created.default"},
+		{name: "unknown tenant", tenants: []string{"acme"}, tenant: "globex", want: "user.created.default"},
+		{name: "tenant with topic separators", tenants: []string{"acme.eu#1*"}, tenant: "acme.eu#1*", want: "user.created.acme_eu_1_"},
+		// Pinned so a change of the hash, which would move tenants, fails
+		{name: "bounded", bound: 8, tenant: "acme", want: "user.created.p7"},
+		{name: "bounded without tenant", bound: 8, tenant: "", want: "user.created.default"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			partition := NewPartitioner(tt.bound, tt.tenants...).Partition(tt.tenant)
+
+			assert.Equal(t, tt.want, RoutingKey("user.created", partition))
+		})
+	}
+}
+
+func TestPartitioner_Stable(t *testing.T) {
+	// Assert: the same tenant always lands on the same partition
+	p := NewPartitioner(16)
+	for i := 0; i < 100; i++ {
+		tenant := fmt.Sprintf("tenant-%d", i)
+		assert.Equal(t, p.Partition(tenant), NewPartitioner(16).Partition(tenant))
+	}
+
+	// Assert: raising the bound only moves tenants to the new partitions
+	grown := NewPartitioner(17)
+	moved := 0
+	for i := 0; i < 1000; i++ {
+		tenant := fmt.Sprintf("tenant-%d", i)
+		if before, after := p.Partition(tenant), grown.Partition(tenant); before != after {
+			assert.Equal(t, "p16", after)
+			moved++
+		}
+	}
+	assert.InDelta(t, 1000/17, moved, 30)
+}
+
+func TestPartitioner_Spread(t *testing.T) {
+	// Arrange
+	p := NewPartitioner(4)
+	counts := map[string]int{}
+
+	// Act
+	for i := 0; i < 4000; i++ {
+		counts[p.Partition(fmt.Sprintf("tenant-%d", i))]++
+	}
+
+	// Assert
+	assert.Len(t, counts, 4)
+	for partition, n := range counts {
+		assert.InDelta(t, 1000, n, 150, partition)
+	}
+}
+
+func TestPartitioner_Partitions(t *testing.T) {
+	assert.Equal(t, []string{"default", "p0", "p1", "p2"}, NewPartitioner(3, "acme").Partitions())
+	assert.Equal(t, []string{"default", "acme", "globex"}, NewPartitioner(0, "acme", "globex", "acme").Partitions())
+}
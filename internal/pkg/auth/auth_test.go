@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+var issuedAt = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestTokens(t *testing.T, secret, issuer string) (*Tokens, *clock.Fixed) {
+	t.Helper()
+	c := clock.NewFixed(issuedAt)
+	tokens, err := NewTokens(secret, 15*time.Minute, issuer, WithClock(c))
+	require.NoError(t, err)
+	return tokens, c
+}
+
+// forge signs claims JSON as the tokens would, to build tokens IssueToken refuses to
+func forge(tokens *Tokens, claims string) string {
+	signed := encodedHeader + "." + base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(tokens.sign(signed))
+}
+
+func TestTokens_IssueParse(t *testing.T) {
+	// Arrange
+	tokens, c := newTestTokens(t, testSecret, "carch-go")
+
+	// Act
+	token, err := tokens.IssueToken("user-1")
+	require.NoError(t, err)
+	c.Advance(15*time.Minute - time.Second)
+	claims, err := tokens.ParseToken(token)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, &Claims{
+		Subject:   "user-1",
+		Issuer:    "carch-go",
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: issuedAt.Add(15 * time.Minute).Unix(),
+	}, claims)
+	assert.Len(t, strings.Split(token, "."), 3)
+}
+
+func TestTokens_ParseToken_Expired(t *testing.T) {
+	// Arrange
+	tokens, c := newTestTokens(t, testSecret, "carch-go")
+	token, err := tokens.IssueToken("user-1")
+	require.NoError(t, err)
+
+	// Act
+	c.Advance(15 * time.Minute)
+	_, err = tokens.ParseToken(token)
+
+	// Assert
+	require.ErrorIs(t, err, ErrTokenExpired)
+	assert.NotErrorIs(t, err, ErrInvalidToken)
+	assert.EqualError(t, err, "token expired at 2024-03-01T12:15:00Z")
+}
+
+func TestTokens_ParseToken_Invalid(t *testing.T) {
+	tokens, _ := newTestTokens(t, testSecret, "carch-go")
+	otherSecret, _ := newTestTokens(t, strings.Repeat("x", MinSecretSize), "carch-go")
+	otherIssuer, _ := newTestTokens(t, testSecret, "elsewhere")
+
+	valid, err := tokens.IssueToken("user-1")
+	require.NoError(t, err)
+	parts := strings.Split(valid, ".")
+	wrongSignature, err := otherSecret.IssueToken("user-1")
+	require.NoError(t, err)
+	foreign, err := otherIssuer.IssueToken("user-1")
+	require.NoError(t, err)
+	exp := issuedAt.Add(time.Hour).Unix()
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{name: "empty", token: "", wantErr: "invalid token: not a JWT"},
+		{name: "two parts", token: parts[0] + "." + parts[1], wantErr: "invalid token: not a JWT"},
+		{name: "wrong signature", token: wrongSignature, wantErr: "invalid token: signature mismatch"},
+		{name: "altered claims", token: parts[0] + "." + strings.Split(foreign, ".")[1] + "." + parts[2], wantErr: "invalid token: signature mismatch"},
+		{name: "signature not base64", token: parts[0] + "." + parts[1] + ".!!", wantErr: "invalid token: signature mismatch"},
+		{
+			name:    "alg none",
+			token:   base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + ".",
+			wantErr: "invalid token: unsupported header",
+		},
+		{name: "other issuer", token: foreign, wantErr: `invalid token: issued by "elsewhere"`},
+		{name: "claims not JSON", token: forge(tokens, "user-1"), wantErr: "invalid token: malformed claims"},
+		{name: "missing sub", token: forge(tokens, `{"iss":"carch-go","exp":`+strconv.FormatInt(exp, 10)+`}`), wantErr: "invalid token: missing sub claim"},
+		{name: "missing iss", token: forge(tokens, `{"sub":"user-1","exp":`+strconv.FormatInt(exp, 10)+`}`), wantErr: "invalid token: missing iss claim"},
+		{name: "missing exp", token: forge(tokens, `{"sub":"user-1","iss":"carch-go"}`), wantErr: "invalid token: missing exp claim"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			claims, err := tokens.ParseToken(tt.token)
+
+			// Assert
+			assert.Nil(t, claims)
+			require.ErrorIs(t, err, ErrInvalidToken)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestNewTokens_Invalid(t *testing.T) {
+	_, err := NewTokens("short", time.Minute, "carch-go")
+	assert.EqualError(t, err, "auth: secret must be at least 32 bytes")
+
+	_, err = NewTokens(testSecret, 0, "carch-go")
+	assert.EqualError(t, err, "auth: token TTL 0s is not positive")
+
+	_, err = NewTokens(testSecret, time.Minute, "")
+	assert.EqualError(t, err, "auth: issuer is required")
+
+	tokens, _ := newTestTokens(t, testSecret, "carch-go")
+	_, err = tokens.IssueToken("")
+	assert.EqualError(t, err, "auth: user ID is required")
+}
@@ -0,0 +1,283 @@
+// Package backoff spaces the attempts of an operation that may fail for a
+// while, e.g. connecting to a broker that restarts. Delays grow
+// exponentially from an initial delay up to a maximum, optionally jittered,
+// and a policy gives up after a number of attempts or a total time.
+package backoff
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+)
+
+// Jitter spreads the delays of clients retrying together, so that they do
+// not all come back at the same instant
+type Jitter int
+
+const (
+	// NoJitter waits the exponential delay exactly
+	NoJitter Jitter = iota
+	// FullJitter waits a random delay between 0 and the exponential delay
+	FullJitter
+	// EqualJitter waits half the exponential delay plus a random delay up to
+	// the other half
+	EqualJitter
+	// DecorrelatedJitter waits a random delay between the initial delay and
+	// three times the previous one, capped at the maximum
+	DecorrelatedJitter
+)
+
+// DefaultMultiplier is how much the delay grows after every attempt
+const DefaultMultiplier = 2
+
+// Retry describes a failed attempt about to be retried, for OnRetry hooks
+type Retry struct {
+	// Attempt is the number of the failed attempt, from 1
+	Attempt int
+	// Delay is the wait before the next attempt
+	Delay time.Duration
+	Err   error
+}
+
+// Option configures a Backoff
+type Option func(*Backoff)
+
+// WithMultiplier sets how much the delay grows after every attempt, 1 keeps
+// it constant
+func WithMultiplier(m float64) Option {
+	return func(b *Backoff) {
+		if m >= 1 {
+			b.multiplier = m
+		}
+	}
+}
+
+// WithJitter sets how delays are randomized, NoJitter by default
+func WithJitter(j Jitter) Option {
+	return func(b *Backoff) {
+		b.jitter = j
+	}
+}
+
+// WithMaxAttempts gives up after n attempts, 0 never does
+func WithMaxAttempts(n int) Option {
+	return func(b *Backoff) {
+		b.maxAttempts = max(n, 0)
+	}
+}
+
+// WithMaxElapsed gives up when waiting for the next attempt would end more
+// than d after the start, 0 never does
+func WithMaxElapsed(d time.Duration) Option {
+	return func(b *Backoff) {
+		b.maxElapsed = max(d, 0)
+	}
+}
+
+// WithOnRetry calls fn before every wait, to log or count retries
+func WithOnRetry(fn func(Retry)) Option {
+	return func(b *Backoff) {
+		b.onRetry = fn
+	}
+}
+
+// WithRand draws the jitter from r instead of the global source, which
+// makes the delays deterministic for a seeded r
+func WithRand(r *rand.Rand) Option {
+	return func(b *Backoff) {
+		if r != nil {
+			b.float = r.Float64
+		}
+	}
+}
+
+// WithClock sets the clock MaxElapsed is measured with
+func WithClock(c clock.Clock) Option {
+	return func(b *Backoff) {
+		b.clock = clock.OrReal(c)
+	}
+}
+
+// Backoff hands out the delays between the attempts of one operation. It is
+// not safe for concurrent use, every retry loop creates its own.
+type Backoff struct {
+	initial     time.Duration
+	max         time.Duration
+	multiplier  float64
+	jitter      Jitter
+	maxAttempts int
+	maxElapsed  time.Duration
+	onRetry     func(Retry)
+	float       func() float64
+	clock       clock.Clock
+
+	attempt int
+	// next is the exponential delay before the next attempt, prev the
+	// previous delay of decorrelated jitter
+	next    time.Duration
+	prev    time.Duration
+	started time.Time
+	// waited sums the delays handed out, so that MaxElapsed also holds on
+	// a clock the caller does not advance
+	waited time.Duration
+}
+
+// New creates a backoff waiting initial before the second attempt, growing
+// up to max. A max below initial is raised to it.
+func New(initial, max time.Duration, opts ...Option) *Backoff {
+	b := &Backoff{
+		initial:    initial,
+		max:        max,
+		multiplier: DefaultMultiplier,
+		float:      rand.Float64,
+		clock:      clock.Real,
+	}
+	if b.initial < 0 {
+		b.initial = 0
+	}
+	if b.max < b.initial {
+		b.max = b.initial
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	b.Reset()
+	return b
+}
+
+// Reset starts over, after the operation succeeded
+func (b *Backoff) Reset() {
+	b.attempt = 0
+	b.next = b.initial
+	b.prev = b.initial
+	b.started = b.clock.Now()
+	b.waited = 0
+}
+
+// Attempts returns how many attempts failed since the last Reset
+func (b *Backoff) Attempts() int {
+	return b.attempt
+}
+
+// Next records an attempt failed with err and returns the delay before the
+// next one, or false when the policy gives up. A delay is never above the
+// maximum, and the delays handed out since Reset never add up past
+// MaxElapsed.
+func (b *Backoff) Next(err error) (time.Duration, bool) {
+	b.attempt++
+	if b.maxAttempts > 0 && b.attempt >= b.maxAttempts {
+		return 0, false
+	}
+
+	delay := b.jittered()
+	if b.maxElapsed > 0 {
+		elapsed := max(b.clock.Now().Sub(b.started), b.waited)
+		if elapsed+delay > b.maxElapsed {
+			return 0, false
+		}
+	}
+
+	// Grown in floating point, so that a large delay cannot overflow
+	if grown := float64(b.next) * b.multiplier; grown < float64(b.max) {
+		b.next = time.Duration(grown)
+	} else {
+		b.next = b.max
+	}
+	b.waited += delay
+	if b.onRetry != nil {
+		b.onRetry(Retry{Attempt: b.attempt, Delay: delay, Err: err})
+	}
+	return delay, true
+}
+
+// jittered applies the jitter strategy to the current exponential delay
+func (b *Backoff) jittered() time.Duration {
+	switch b.jitter {
+	case FullJitter:
+		return b.random(0, b.next)
+	case EqualJitter:
+		half := b.next / 2
+		return half + b.random(0, b.next-half)
+	case DecorrelatedJitter:
+		upper := b.max
+		if b.prev <= b.max/3 {
+			upper = b.prev * 3
+		}
+		b.prev = b.random(b.initial, upper)
+		return b.prev
+	default:
+		return b.next
+	}
+}
+
+// random returns a delay in [lo, hi]
+func (b *Backoff) random(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + time.Duration(b.float()*float64(hi-lo+1))
+}
+
+// permanentError stops Do from retrying
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks err as not worth retrying, Do returns it right away
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// Do runs fn until it succeeds, returns a Permanent error, the policy of b
+// gives up or ctx is done, waiting the delays of b in between. b is Reset
+// first. Once the policy gives up it returns the last error of fn, and the
+// error of ctx when ctx is done.
+func Do(ctx context.Context, b *Backoff, fn func(ctx context.Context) error) error {
+	b.Reset()
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+		var permanent *permanentError
+		if errors.As(err, &permanent) {
+			return permanent.err
+		}
+
+		delay, ok := b.Next(err)
+		if !ok {
+			return fmt.Errorf("gave up after %d attempts: %w", b.Attempts(), err)
+		}
+		if err := Wait(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Wait waits d or until ctx is done, returning the error of ctx then
+func Wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
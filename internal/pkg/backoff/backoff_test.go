@@ -0,0 +1,233 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+)
+
+var errFailed = errors.New("failed")
+
+var jitters = []Jitter{NoJitter, FullJitter, EqualJitter, DecorrelatedJitter}
+
+// delays returns the delays b hands out until it gives up or n were drawn
+func delays(b *Backoff, n int) []time.Duration {
+	var out []time.Duration
+	for range n {
+		d, ok := b.Next(errFailed)
+		if !ok {
+			break
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+// policy is a random policy generated by testing/quick
+type policy struct {
+	Initial    uint16
+	Max        uint32
+	Multiplier uint8
+	Jitter     uint8
+	Seed       uint64
+}
+
+func (p policy) backoff(opts ...Option) *Backoff {
+	opts = append([]Option{
+		WithMultiplier(1 + float64(p.Multiplier%8)),
+		WithJitter(jitters[int(p.Jitter)%len(jitters)]),
+		WithRand(rand.New(rand.NewPCG(p.Seed, p.Seed))),
+	}, opts...)
+	return New(time.Duration(p.Initial)*time.Millisecond, time.Duration(p.Max)*time.Microsecond, opts...)
+}
+
+func TestBackoff_Exponential(t *testing.T) {
+	b := New(100*time.Millisecond, time.Second)
+
+	got := delays(b, 6)
+
+	assert.Equal(t, []time.Duration{
+		100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second, time.Second,
+	}, got)
+}
+
+func TestBackoff_Reset(t *testing.T) {
+	b := New(time.Millisecond, time.Second)
+	delays(b, 3)
+
+	b.Reset()
+	d, ok := b.Next(errFailed)
+
+	assert.True(t, ok)
+	assert.Equal(t, time.Millisecond, d)
+	assert.Equal(t, 1, b.Attempts())
+}
+
+func TestBackoff_Bounds(t *testing.T) {
+	// Every delay stays within the maximum, and within the exponential
+	// delay it jitters
+	property := func(p policy) bool {
+		b := p.backoff()
+		exact := p.backoff(WithJitter(NoJitter))
+		for _, d := range delays(b, 50) {
+			ceiling, _ := exact.Next(errFailed)
+			if d < 0 || d > b.max {
+				return false
+			}
+			switch b.jitter {
+			case FullJitter:
+				if d > ceiling {
+					return false
+				}
+			case EqualJitter:
+				if d < ceiling/2 || d > ceiling {
+					return false
+				}
+			case DecorrelatedJitter:
+				if d < b.initial {
+					return false
+				}
+			default:
+				if d != ceiling {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	require.NoError(t, quick.Check(property, nil))
+}
+
+func TestBackoff_MaxAttempts(t *testing.T) {
+	property := func(p policy, n uint8) bool {
+		attempts := int(n%20) + 1
+		b := p.backoff(WithMaxAttempts(attempts))
+		// One delay between two attempts
+		return len(delays(b, 100)) == attempts-1
+	}
+
+	require.NoError(t, quick.Check(property, nil))
+}
+
+func TestBackoff_MaxElapsed(t *testing.T) {
+	property := func(p policy, limit uint32) bool {
+		maxElapsed := time.Duration(limit%1_000_000) * time.Microsecond
+		b := p.backoff(WithMaxElapsed(maxElapsed), WithClock(clock.NewFixed(time.Unix(0, 0))))
+		var total time.Duration
+		for _, d := range delays(b, 1000) {
+			total += d
+		}
+		return total <= maxElapsed || maxElapsed == 0
+	}
+
+	require.NoError(t, quick.Check(property, nil))
+}
+
+func TestBackoff_MaxElapsed_Clock(t *testing.T) {
+	// Arrange
+	now := clock.NewFixed(time.Unix(0, 0))
+	b := New(time.Second, time.Second, WithMaxElapsed(10*time.Second), WithClock(now))
+
+	// Act: the attempts themselves took 9.5s
+	now.Advance(9500 * time.Millisecond)
+	_, ok := b.Next(errFailed)
+
+	// Assert
+	assert.False(t, ok, "a delay of 1s would end after 10s")
+}
+
+func TestBackoff_Deterministic(t *testing.T) {
+	property := func(p policy) bool {
+		first := delays(p.backoff(), 30)
+		second := delays(p.backoff(), 30)
+		return assert.ObjectsAreEqual(first, second)
+	}
+
+	require.NoError(t, quick.Check(property, nil))
+}
+
+func TestBackoff_OnRetry(t *testing.T) {
+	// Arrange
+	var retries []Retry
+	b := New(time.Millisecond, time.Second, WithMaxAttempts(3), WithOnRetry(func(r Retry) {
+		retries = append(retries, r)
+	}))
+
+	// Act
+	delays(b, 10)
+
+	// Assert
+	assert.Equal(t, []Retry{
+		{Attempt: 1, Delay: time.Millisecond, Err: errFailed},
+		{Attempt: 2, Delay: 2 * time.Millisecond, Err: errFailed},
+	}, retries, "no hook when the policy gives up")
+}
+
+func TestDo(t *testing.T) {
+	tests := []struct {
+		name      string
+		failures  int
+		err       error
+		wantCalls int
+		wantErr   string
+	}{
+		{name: "first try", wantCalls: 1},
+		{name: "after retries", failures: 2, err: errFailed, wantCalls: 3},
+		{name: "gives up", failures: 10, err: errFailed, wantCalls: 4, wantErr: "gave up after 4 attempts: failed"},
+		{name: "permanent error", failures: 10, err: Permanent(errFailed), wantCalls: 1, wantErr: "failed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			calls := 0
+			b := New(time.Microsecond, time.Millisecond, WithMaxAttempts(4))
+
+			// Act
+			err := Do(context.Background(), b, func(ctx context.Context) error {
+				calls++
+				if calls <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+
+			// Assert
+			assert.Equal(t, tt.wantCalls, calls)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+			assert.ErrorIs(t, err, errFailed)
+		})
+	}
+}
+
+func TestDo_ContextDone(t *testing.T) {
+	// Arrange
+	ctx, cancel := context.WithCancel(context.Background())
+	b := New(time.Hour, time.Hour, WithOnRetry(func(Retry) { cancel() }))
+
+	// Act
+	err := Do(ctx, b, func(ctx context.Context) error { return errFailed })
+
+	// Assert
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestNew_MaxBelowInitial(t *testing.T) {
+	b := New(time.Second, time.Millisecond)
+
+	assert.Equal(t, []time.Duration{time.Second, time.Second}, delays(b, 2))
+}
@@ -0,0 +1,29 @@
+// Package buildinfo reports the version of the running binary
+package buildinfo
+
+import "runtime/debug"
+
+// version is set at build time with
+// -ldflags "-X github.com/romanitalian/carch-go/internal/pkg/buildinfo.version=v1.2.3"
+var version string
+
+// Version returns the version set at build time, falling back to the VCS
+// revision recorded by the Go toolchain and "dev" when neither is available
+func Version() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+
+	for _, s := range info.Settings {
+		if s.Key == "vcs.revision" {
+			return s.Value
+		}
+	}
+
+	return "dev"
+}
@@ -0,0 +1,224 @@
+package cache
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Cache stores values by key for a limited time
+type Cache interface {
+	// Get returns the value and whether it was found
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+}
+
+// Adder is a Cache that can store a value only for a key without one. The
+// check and the write are atomic, so of concurrent Adds of the same key
+// exactly one succeeds.
+type Adder interface {
+	Cache
+	// Add sets key to value for ttl unless key holds a value, and reports
+	// whether it did
+	Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error)
+}
+
+// Pinger is a Cache whose backend may be unavailable
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Ping reports whether the backend of c is available. Caches that are not
+// a Pinger, like Memory, always are.
+func Ping(ctx context.Context, c Cache) error {
+	if p, ok := c.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// sweepEvery is how many writes pass between removals of expired entries
+const sweepEvery = 1024
+
+type entry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// Memory is an in-process Cache. Every process has its own copy, so
+// invalidations are not seen by other replicas before the entries expire.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]entry
+	writes  int
+	now     func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		entries: map[string]entry{},
+		now:     time.Now,
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !m.now().Before(e.expiresAt) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+
+	return e.value, true, nil
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.put(key, value, ttl)
+	return nil
+}
+
+func (m *Memory) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok && m.now().Before(e.expiresAt) {
+		return false, nil
+	}
+	m.put(key, value, ttl)
+	return true, nil
+}
+
+// put stores the entry of key and every sweepEvery writes removes the
+// expired ones, m.mu must be held
+func (m *Memory) put(key string, value []byte, ttl time.Duration) {
+	now := m.now()
+	m.entries[key] = entry{value: value, expiresAt: now.Add(ttl)}
+
+	m.writes++
+	if m.writes%sweepEvery == 0 {
+		for k, e := range m.entries {
+			if !now.Before(e.expiresAt) {
+				delete(m.entries, k)
+			}
+		}
+	}
+}
+
+func (m *Memory) Delete(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
+
+// Counter counts events per key in fixed windows
+type Counter interface {
+	// Incr adds one to the count of key and returns it. The count starts
+	// over at the first Incr after the window of key ended.
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+}
+
+// Incr counts in the entry of key, which expires when its window ends
+func (m *Memory) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	e, ok := m.entries[key]
+	if !ok || !now.Before(e.expiresAt) {
+		e = entry{expiresAt: now.Add(window)}
+	}
+	count, _ := strconv.ParseInt(string(e.value), 10, 64)
+	count++
+	e.value = []byte(strconv.FormatInt(count, 10))
+	m.entries[key] = e
+
+	return count, nil
+}
+
+// Semaphore hands out a limited number of slots per key. A slot expires
+// after its ttl, so holders that never release it cannot leak it.
+type Semaphore interface {
+	// Acquire takes a slot of key if fewer than limit are held and returns
+	// its token, ok is false when every slot is taken
+	Acquire(ctx context.Context, key string, limit int, ttl time.Duration) (token string, ok bool, err error)
+	// Release frees the slot of key held with token
+	Release(ctx context.Context, key, token string) error
+	// Refresh extends the slot of key held with token to expire after ttl,
+	// for holders running longer than the ttl they acquired it with
+	Refresh(ctx context.Context, key, token string, ttl time.Duration) error
+}
+
+// Slots is an in-process Semaphore. Every process has its own slots, so
+// limits apply per replica, see repository.RedisSlots for shared ones.
+type Slots struct {
+	mu    sync.Mutex
+	slots map[string]map[string]time.Time
+	next  uint64
+	now   func() time.Time
+}
+
+func NewSlots() *Slots {
+	return &Slots{
+		slots: map[string]map[string]time.Time{},
+		now:   time.Now,
+	}
+}
+
+func (s *Slots) Acquire(ctx context.Context, key string, limit int, ttl time.Duration) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	held := s.slots[key]
+	for token, expiresAt := range held {
+		if !now.Before(expiresAt) {
+			delete(held, token)
+		}
+	}
+	if len(held) >= limit {
+		return "", false, nil
+	}
+
+	if held == nil {
+		held = map[string]time.Time{}
+		s.slots[key] = held
+	}
+	s.next++
+	token := strconv.FormatUint(s.next, 10)
+	held[token] = now.Add(ttl)
+
+	return token, true, nil
+}
+
+func (s *Slots) Release(ctx context.Context, key, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.slots[key], token)
+	if len(s.slots[key]) == 0 {
+		delete(s.slots, key)
+	}
+	return nil
+}
+
+func (s *Slots) Refresh(ctx context.Context, key, token string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.slots[key][token]; ok {
+		s.slots[key][token] = s.now().Add(ttl)
+	}
+	return nil
+}
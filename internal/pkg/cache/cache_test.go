@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSlots_Acquire(t *testing.T) {
+	// Arrange
+	slots := NewSlots()
+	ctx := context.Background()
+
+	// Act
+	first, ok1, err1 := slots.Acquire(ctx, "ann", 2, time.Minute)
+	_, ok2, err2 := slots.Acquire(ctx, "ann", 2, time.Minute)
+	_, ok3, err3 := slots.Acquire(ctx, "ann", 2, time.Minute)
+	_, okOther, _ := slots.Acquire(ctx, "bob", 2, time.Minute)
+
+	// Assert
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	require.NoError(t, err3)
+	assert.True(t, ok1)
+	assert.True(t, ok2)
+	assert.False(t, ok3, "every slot of the key is taken")
+	assert.True(t, okOther, "keys have slots of their own")
+
+	// Act: releasing a slot lets the next holder in
+	require.NoError(t, slots.Release(ctx, "ann", first))
+	_, ok4, _ := slots.Acquire(ctx, "ann", 2, time.Minute)
+
+	// Assert
+	assert.True(t, ok4)
+}
+
+func TestSlots_Acquire_ExpiredSlotsAreReclaimed(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	slots := NewSlots()
+	slots.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, _, err := slots.Acquire(ctx, "ann", 1, time.Minute)
+	require.NoError(t, err)
+
+	// Act: the holder never releases its slot
+	_, held, _ := slots.Acquire(ctx, "ann", 1, time.Minute)
+	now = now.Add(time.Minute)
+	_, reclaimed, _ := slots.Acquire(ctx, "ann", 1, time.Minute)
+
+	// Assert
+	assert.False(t, held)
+	assert.True(t, reclaimed)
+}
+
+func TestSlots_Refresh(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	slots := NewSlots()
+	slots.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	token, _, err := slots.Acquire(ctx, "ann", 1, time.Minute)
+	require.NoError(t, err)
+
+	// Act: the holder is still running when its slot would expire
+	now = now.Add(50 * time.Second)
+	require.NoError(t, slots.Refresh(ctx, "ann", token, time.Minute))
+	now = now.Add(50 * time.Second)
+	_, ok, _ := slots.Acquire(ctx, "ann", 1, time.Minute)
+
+	// Assert
+	assert.False(t, ok, "the refreshed slot is still held")
+}
+
+func TestMemory_Incr(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Act
+	var counts []int64
+	for range 3 {
+		n, err := m.Incr(ctx, "ann", time.Minute)
+		require.NoError(t, err)
+		counts = append(counts, n)
+	}
+	other, _ := m.Incr(ctx, "bob", time.Minute)
+	now = now.Add(time.Minute)
+	reset, _ := m.Incr(ctx, "ann", time.Minute)
+
+	// Assert
+	assert.Equal(t, []int64{1, 2, 3}, counts)
+	assert.Equal(t, int64(1), other, "keys count on their own")
+	assert.Equal(t, int64(1), reset, "the count starts over once the window ended")
+}
+
+func TestMemory_Add(t *testing.T) {
+	// Arrange
+	now := time.Now()
+	m := NewMemory()
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Act
+	first, err1 := m.Add(ctx, "ann", []byte("first"), time.Minute)
+	second, err2 := m.Add(ctx, "ann", []byte("second"), time.Minute)
+	value, _, _ := m.Get(ctx, "ann")
+	now = now.Add(time.Minute)
+	expired, _ := m.Add(ctx, "ann", []byte("third"), time.Minute)
+
+	// Assert
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	assert.True(t, first)
+	assert.False(t, second, "the key holds a value")
+	assert.Equal(t, []byte("first"), value)
+	assert.True(t, expired, "expired values do not count")
+}
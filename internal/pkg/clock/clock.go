@@ -0,0 +1,81 @@
+// Package clock abstracts the current time so that code stamping records
+// can be tested with exact values
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the current time
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+// OrReal returns c, or Real when c is nil
+func OrReal(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+// Fixed is a Clock that always tells the same time until it is set
+type Fixed struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func NewFixed(now time.Time) *Fixed {
+	return &Fixed{now: now}
+}
+
+func (c *Fixed) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set makes the clock tell now
+func (c *Fixed) Set(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = now
+}
+
+// Advance moves the clock forward by d
+func (c *Fixed) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Stepping is a Clock that moves forward by step every time it is read, so
+// that successive timestamps are distinct and predictable
+type Stepping struct {
+	mu   sync.Mutex
+	next time.Time
+	step time.Duration
+}
+
+// NewStepping returns a clock telling start first, then start+step and so on
+func NewStepping(start time.Time, step time.Duration) *Stepping {
+	return &Stepping{next: start, step: step}
+}
+
+func (c *Stepping) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.next
+	c.next = c.next.Add(c.step)
+	return now
+}
@@ -0,0 +1,46 @@
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var start = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func TestFixed(t *testing.T) {
+	// Arrange
+	c := NewFixed(start)
+
+	// Act & Assert
+	assert.Equal(t, start, c.Now())
+	assert.Equal(t, start, c.Now(), "reading does not move the clock")
+
+	c.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Minute), c.Now())
+
+	c.Set(start)
+	assert.Equal(t, start, c.Now())
+}
+
+func TestStepping(t *testing.T) {
+	// Arrange
+	c := NewStepping(start, time.Second)
+
+	// Act
+	got := []time.Time{c.Now(), c.Now(), c.Now()}
+
+	// Assert
+	assert.Equal(t, []time.Time{start, start.Add(time.Second), start.Add(2 * time.Second)}, got)
+}
+
+func TestOrReal(t *testing.T) {
+	// Arrange
+	fixed := NewFixed(start)
+
+	// Act & Assert
+	assert.Equal(t, Real, OrReal(nil))
+	assert.Same(t, fixed, OrReal(fixed))
+	assert.WithinDuration(t, time.Now(), Real.Now(), time.Second)
+}
@@ -0,0 +1,54 @@
+package consistency
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Header carries the token returned by mutations. Presenting it on the
+// following reads makes them see the mutation.
+const Header = "X-Consistency-Token"
+
+// ErrInvalidToken is returned for tokens not issued by NewToken
+var ErrInvalidToken = errors.New("invalid consistency token")
+
+type contextKey struct{}
+
+// NewToken returns the token for a mutation committed at committedAt
+func NewToken(committedAt time.Time) string {
+	return strconv.FormatInt(committedAt.UnixMicro(), 36)
+}
+
+// ParseToken returns the commit time encoded in the token
+func ParseToken(token string) (time.Time, error) {
+	micros, err := strconv.ParseInt(token, 36, 64)
+	if err != nil || micros <= 0 {
+		return time.Time{}, ErrInvalidToken
+	}
+	return time.UnixMicro(micros), nil
+}
+
+// Fresh reports whether a read made at now may miss the mutation behind the
+// token, i.e. it was committed less than maxLag ago. Older mutations have
+// reached replicas and caches have been invalidated or expired.
+func Fresh(token string, now time.Time, maxLag time.Duration) bool {
+	committedAt, err := ParseToken(token)
+	if err != nil {
+		return false
+	}
+	return now.Sub(committedAt) < maxLag
+}
+
+// WithPrimary marks reads made with the context as requiring the primary
+// database, bypassing caches and replicas
+func WithPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, true)
+}
+
+// PrimaryRequired reports whether reads made with ctx must see the latest writes
+func PrimaryRequired(ctx context.Context) bool {
+	required, _ := ctx.Value(contextKey{}).(bool)
+	return required
+}
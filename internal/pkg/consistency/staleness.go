@@ -0,0 +1,57 @@
+package consistency
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// StalenessHeader tells the client that a response was served from stale
+// data, with the age of the oldest data in seconds. gRPC sends it as the
+// x-data-staleness trailer.
+const StalenessHeader = "X-Data-Staleness"
+
+type stalenessKey struct{}
+
+// Staleness collects the stale data the reads of a request were served
+// from, e.g. cached users kept past their TTL while the database is down
+type Staleness struct {
+	// age is the age of the oldest stale data in nanoseconds, 0 while the
+	// reads were fresh
+	age atomic.Int64
+}
+
+// TrackStaleness returns a context whose reads report the stale data they
+// are served from to the returned Staleness
+func TrackStaleness(ctx context.Context) (context.Context, *Staleness) {
+	s := &Staleness{}
+	return context.WithValue(ctx, stalenessKey{}, s), s
+}
+
+// ReportStale records that a read of ctx was served from data of the given
+// age. It does nothing unless the context tracks staleness.
+func ReportStale(ctx context.Context, age time.Duration) {
+	s, ok := ctx.Value(stalenessKey{}).(*Staleness)
+	if !ok {
+		return
+	}
+	age = max(age, time.Nanosecond)
+	for {
+		current := s.age.Load()
+		if int64(age) <= current || s.age.CompareAndSwap(current, int64(age)) {
+			return
+		}
+	}
+}
+
+// Age returns the age of the oldest stale data reported, and whether any was
+func (s *Staleness) Age() (time.Duration, bool) {
+	age := s.age.Load()
+	return time.Duration(age), age > 0
+}
+
+// FormatAge formats an age as the value of StalenessHeader, whole seconds
+func FormatAge(age time.Duration) string {
+	return strconv.FormatInt(int64(age/time.Second), 10)
+}
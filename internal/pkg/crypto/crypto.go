@@ -0,0 +1,128 @@
+// Package crypto seals small values with envelope encryption. Every value is
+// encrypted with its own AES-256-GCM data key, and the data key is stored
+// next to it, wrapped by a master key that only the KMS holds.
+package crypto
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// KeySize is the size of master and data keys, AES-256
+const KeySize = 32
+
+var (
+	// ErrUnknownKey is returned for a master key the KMS does not hold
+	ErrUnknownKey = errors.New("unknown master key")
+	// ErrDecrypt is returned when a value or data key fails authentication,
+	// because it was altered or is opened with the wrong key or context
+	ErrDecrypt = errors.New("decryption failed")
+)
+
+// KMS wraps data keys with master keys it never hands out
+type KMS interface {
+	// KeyID names the master key new data keys are wrapped with
+	KeyID() string
+	Wrap(ctx context.Context, keyID string, dataKey []byte) ([]byte, error)
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Envelope is a sealed value as it is stored
+type Envelope struct {
+	// KeyID is the master key that wrapped Key
+	KeyID string `json:"kid"`
+	Key   []byte `json:"key"`
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+// Envelopes seals and opens values with data keys wrapped by a KMS
+type Envelopes struct {
+	kms  KMS
+	rand io.Reader
+}
+
+// NewEnvelopes seals values with data keys wrapped by the current key of kms
+func NewEnvelopes(kms KMS) *Envelopes {
+	return &Envelopes{kms: kms, rand: rand.Reader}
+}
+
+// Seal encrypts plaintext under a new data key. aad is authenticated but not
+// stored; the envelope only opens with the same aad, which binds it to where
+// it is stored.
+func (e *Envelopes) Seal(ctx context.Context, plaintext, aad []byte) (*Envelope, error) {
+	key := make([]byte, KeySize)
+	if _, err := io.ReadFull(e.rand, key); err != nil {
+		return nil, err
+	}
+
+	nonce, data, err := seal(e.rand, key, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID := e.kms.KeyID()
+	wrapped, err := e.kms.Wrap(ctx, keyID, key)
+	if err != nil {
+		return nil, fmt.Errorf("wrap data key: %w", err)
+	}
+
+	return &Envelope{KeyID: keyID, Key: wrapped, Nonce: nonce, Data: data}, nil
+}
+
+// Open decrypts an envelope sealed with the same aad
+func (e *Envelopes) Open(ctx context.Context, env *Envelope, aad []byte) ([]byte, error) {
+	key, err := e.kms.Unwrap(ctx, env.KeyID, env.Key)
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key: %w", err)
+	}
+	return open(key, env.Nonce, env.Data, aad)
+}
+
+// Stale reports whether env was sealed before the master key was rotated and
+// should be sealed again
+func (e *Envelopes) Stale(env *Envelope) bool {
+	return env.KeyID != e.kms.KeyID()
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(random io.Reader, key, plaintext, aad []byte) (nonce, data []byte, err error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce = make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(random, nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, aad), nil
+}
+
+func open(key, nonce, data, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, data, aad)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,154 @@
+package crypto
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, KeySize)
+}
+
+func testKeyring(t *testing.T, current string) *Keyring {
+	t.Helper()
+	k, err := NewKeyring(current, map[string][]byte{"k1": testKey(1), "k2": testKey(2)})
+	require.NoError(t, err)
+	return k
+}
+
+func TestEnvelopes_SealOpen(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	e := NewEnvelopes(testKeyring(t, "k1"))
+
+	// Act
+	env, err := e.Seal(ctx, []byte("4111 1111 1111 1111"), []byte("user-1/card"))
+	require.NoError(t, err)
+	got, err := e.Open(ctx, env, []byte("user-1/card"))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "4111 1111 1111 1111", string(got))
+	assert.Equal(t, "k1", env.KeyID)
+	assert.NotContains(t, string(env.Data), "4111")
+}
+
+func TestEnvelopes_Seal_UsesNewDataKeys(t *testing.T) {
+	ctx := context.Background()
+	e := NewEnvelopes(testKeyring(t, "k1"))
+
+	a, err := e.Seal(ctx, []byte("same"), nil)
+	require.NoError(t, err)
+	b, err := e.Seal(ctx, []byte("same"), nil)
+	require.NoError(t, err)
+
+	assert.NotEqual(t, a.Key, b.Key)
+	assert.NotEqual(t, a.Data, b.Data)
+}
+
+func TestEnvelopes_Open_Rejects(t *testing.T) {
+	ctx := context.Background()
+	e := NewEnvelopes(testKeyring(t, "k1"))
+	sealed, err := e.Seal(ctx, []byte("secret"), []byte("user-1/ssn"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		change func(env *Envelope) []byte
+	}{
+		{
+			name:   "other aad",
+			change: func(env *Envelope) []byte { return []byte("user-2/ssn") },
+		},
+		{
+			name: "altered data",
+			change: func(env *Envelope) []byte {
+				env.Data[0] ^= 1
+				return []byte("user-1/ssn")
+			},
+		},
+		{
+			name: "wrapped key moved to another master key",
+			change: func(env *Envelope) []byte {
+				env.KeyID = "k2"
+				return []byte("user-1/ssn")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := *sealed
+			env.Data = append([]byte(nil), sealed.Data...)
+			aad := tt.change(&env)
+
+			_, err := e.Open(ctx, &env, aad)
+
+			assert.ErrorIs(t, err, ErrDecrypt)
+		})
+	}
+}
+
+func TestEnvelopes_Rotation(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	old := NewEnvelopes(testKeyring(t, "k1"))
+	env, err := old.Seal(ctx, []byte("secret"), nil)
+	require.NoError(t, err)
+
+	// Act
+	rotated := NewEnvelopes(testKeyring(t, "k2"))
+	got, err := rotated.Open(ctx, env, nil)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "secret", string(got))
+	assert.True(t, rotated.Stale(env))
+	assert.False(t, old.Stale(env))
+}
+
+func TestEnvelopes_Open_UnknownKey(t *testing.T) {
+	ctx := context.Background()
+	env, err := NewEnvelopes(testKeyring(t, "k1")).Seal(ctx, []byte("secret"), nil)
+	require.NoError(t, err)
+
+	k, err := NewKeyring("k3", map[string][]byte{"k3": testKey(3)})
+	require.NoError(t, err)
+	_, err = NewEnvelopes(k).Open(ctx, env, nil)
+
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestParseKeyring(t *testing.T) {
+	valid := base64.StdEncoding.EncodeToString(testKey(1))
+
+	tests := []struct {
+		name    string
+		current string
+		keys    map[string]string
+		wantErr bool
+	}{
+		{name: "valid", current: "k1", keys: map[string]string{"k1": valid}},
+		{name: "current missing", current: "k2", keys: map[string]string{"k1": valid}, wantErr: true},
+		{name: "not base64", current: "k1", keys: map[string]string{"k1": "not base64!"}, wantErr: true},
+		{name: "short key", current: "k1", keys: map[string]string{"k1": base64.StdEncoding.EncodeToString([]byte("short"))}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := ParseKeyring(tt.current, tt.keys)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.current, k.KeyID())
+		})
+	}
+}
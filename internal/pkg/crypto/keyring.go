@@ -0,0 +1,81 @@
+package crypto
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+)
+
+// Keyring is a KMS holding AES-256 master keys in memory, as loaded from
+// configuration. Keys retired by a rotation stay in the keyring until no
+// value is wrapped with them anymore.
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewKeyring wraps new data keys with the key named current. Every key must
+// be KeySize bytes long.
+func NewKeyring(current string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, current)
+	}
+
+	k := &Keyring{current: current, keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("master key %q is %d bytes, want %d", id, len(key), KeySize)
+		}
+		k.keys[id] = append([]byte(nil), key...)
+	}
+	return k, nil
+}
+
+// ParseKeyring builds a keyring from keys encoded in standard base64
+func ParseKeyring(current string, keys map[string]string) (*Keyring, error) {
+	decoded := make(map[string][]byte, len(keys))
+	for id, key := range keys {
+		b, err := base64.StdEncoding.DecodeString(key)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", id, err)
+		}
+		decoded[id] = b
+	}
+	return NewKeyring(current, decoded)
+}
+
+func (k *Keyring) KeyID() string {
+	return k.current
+}
+
+// Wrap seals the data key with the master key. The key ID is authenticated,
+// so a wrapped key does not unwrap under another ID.
+func (k *Keyring) Wrap(ctx context.Context, keyID string, dataKey []byte) ([]byte, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	nonce, data, err := seal(rand.Reader, master, dataKey, []byte(keyID))
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, data...), nil
+}
+
+func (k *Keyring) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	master, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, keyID)
+	}
+
+	gcm, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, ErrDecrypt
+	}
+	return open(master, wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():], []byte(keyID))
+}
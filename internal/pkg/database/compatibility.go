@@ -0,0 +1,188 @@
+package database
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4/source"
+)
+
+// compatibleAnnotation starts the header comment of an up migration naming
+// the oldest binary the migrated schema still works with, e.g.
+// "-- min-compatible-binary: 16" on an additive migration 17. A binary is
+// named by the latest migration it ships. Without the annotation, only the
+// binaries shipping the migration work with it.
+const compatibleAnnotation = "-- min-compatible-binary:"
+
+// ErrSchemaAhead is returned by RunMigrations when a newer binary migrated
+// the schema past what this binary works with
+var ErrSchemaAhead = errors.New("database schema is ahead of this binary")
+
+// Compatibility tells which binaries work with the schema at each version,
+// as annotated by the up migrations
+type Compatibility struct {
+	// versions are the versions of the up migrations, ascending
+	versions []uint
+	// minBinary is the annotation of each migration, or its version
+	minBinary map[uint]uint
+}
+
+// ReadCompatibility reads the annotations of the up migrations of fsys
+func ReadCompatibility(fsys fs.FS) (*Compatibility, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Compatibility{minBinary: make(map[uint]uint)}
+	for _, e := range entries {
+		parsed, err := source.DefaultParse(e.Name())
+		if err != nil || parsed.Direction != source.Up {
+			continue
+		}
+		minBinary, err := readAnnotation(fsys, e.Name(), parsed.Version)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: %w", e.Name(), err)
+		}
+		c.versions = append(c.versions, parsed.Version)
+		c.minBinary[parsed.Version] = minBinary
+	}
+	sort.Slice(c.versions, func(i, j int) bool { return c.versions[i] < c.versions[j] })
+
+	return c, nil
+}
+
+// readAnnotation returns the oldest binary the migration at version works
+// with, read from the comments heading the file
+func readAnnotation(fsys fs.FS, name string, version uint) (uint, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		value, ok := strings.CutPrefix(line, compatibleAnnotation)
+		if !ok {
+			continue
+		}
+		minBinary, err := strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid annotation %q: %w", line, err)
+		}
+		if uint(minBinary) > version {
+			return 0, fmt.Errorf("binary %d cannot be required before migration %d ships", minBinary, version)
+		}
+		return uint(minBinary), nil
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Latest returns the latest migration version, which names the binary
+// shipping the migrations
+func (c *Compatibility) Latest() uint {
+	if len(c.versions) == 0 {
+		return 0
+	}
+	return c.versions[len(c.versions)-1]
+}
+
+// MinBinary returns the oldest binary working with the schema at version,
+// the highest annotation of the migrations up to it
+func (c *Compatibility) MinBinary(version uint) uint {
+	var minBinary uint
+	for _, v := range c.versions {
+		if v > version {
+			break
+		}
+		minBinary = max(minBinary, c.minBinary[v])
+	}
+	return minBinary
+}
+
+// RollbackTarget returns the highest version, at most current, whose schema
+// works with binary: the version to migrate down to before rolling back to
+// that binary
+func (c *Compatibility) RollbackTarget(current, binary uint) (uint, error) {
+	target, found := uint(0), false
+	for _, v := range c.versions {
+		if v > current || c.MinBinary(v) > binary {
+			break
+		}
+		target, found = v, true
+	}
+	if !found {
+		return 0, fmt.Errorf("no schema version up to %d works with binary %d", current, binary)
+	}
+	return target, nil
+}
+
+// compatibilityTable holds, for each schema version migrated by a binary
+// annotating its migrations, the oldest binary working with it. Older
+// binaries read it to tell whether they may run against a newer schema.
+const compatibilityTable = `CREATE TABLE IF NOT EXISTS schema_compatibility (
+	version    BIGINT PRIMARY KEY,
+	min_binary BIGINT NOT NULL
+)`
+
+// checkSchema fails with ErrSchemaAhead when the schema at current is newer
+// than binary and was not recorded as working with it
+func (m *MigrationManager) checkSchema(ctx context.Context, current, binary uint) error {
+	if current <= binary {
+		return nil
+	}
+
+	if _, err := m.db.ExecContext(ctx, compatibilityTable); err != nil {
+		return fmt.Errorf("failed to create schema compatibility table: %w", err)
+	}
+	var minBinary uint
+	err := m.db.QueryRowContext(ctx, "SELECT min_binary FROM schema_compatibility WHERE version = $1", current).Scan(&minBinary)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return fmt.Errorf("%w: schema version %d is past migration %d of this binary, and was not recorded as working with older binaries",
+			ErrSchemaAhead, current, binary)
+	case err != nil:
+		return fmt.Errorf("failed to read schema compatibility: %w", err)
+	case minBinary > binary:
+		return fmt.Errorf("%w: schema version %d works with binaries from migration %d, this binary ships migrations up to %d, run cli migrate down --to-compatible %d",
+			ErrSchemaAhead, current, minBinary, binary, binary)
+	}
+
+	m.logger.Warn("Database schema is ahead of this binary but works with it", map[string]interface{}{
+		"version":    current,
+		"binary":     binary,
+		"min_binary": minBinary,
+	})
+	return nil
+}
+
+// recordCompatibility records the oldest binary working with the schema at
+// each version of c, for older binaries to read
+func (m *MigrationManager) recordCompatibility(ctx context.Context, c *Compatibility) error {
+	if _, err := m.db.ExecContext(ctx, compatibilityTable); err != nil {
+		return fmt.Errorf("failed to create schema compatibility table: %w", err)
+	}
+	for _, v := range c.versions {
+		_, err := m.db.ExecContext(ctx, `INSERT INTO schema_compatibility (version, min_binary) VALUES ($1, $2)
+			ON CONFLICT (version) DO UPDATE SET min_binary = EXCLUDED.min_binary`, v, c.MinBinary(v))
+		if err != nil {
+			return fmt.Errorf("failed to record schema compatibility of version %d: %w", v, err)
+		}
+	}
+	return nil
+}
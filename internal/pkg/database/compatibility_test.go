@@ -0,0 +1,184 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/health"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// annotatedMigrations holds migrations 1 to 4: 2 and 4 are additive and
+// keep working with the binary before them, 3 is not
+var annotatedMigrations = fstest.MapFS{
+	"000001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id UUID);\n")},
+	"000001_create_users.down.sql": {Data: []byte("DROP TABLE users;\n")},
+	"000002_add_name.up.sql":       {Data: []byte("-- min-compatible-binary: 1\nALTER TABLE users ADD COLUMN name TEXT;\n")},
+	"000003_rename_name.up.sql":    {Data: []byte("ALTER TABLE users RENAME COLUMN name TO full_name;\n")},
+	"000004_add_email.up.sql": {Data: []byte("-- Emails are optional until users confirm one\n" +
+		"-- min-compatible-binary: 3\nALTER TABLE users ADD COLUMN email TEXT;\n")},
+}
+
+func TestReadCompatibility(t *testing.T) {
+	// Act
+	compat, err := ReadCompatibility(annotatedMigrations)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, uint(4), compat.Latest())
+	assert.Equal(t, uint(1), compat.MinBinary(1))
+	assert.Equal(t, uint(1), compat.MinBinary(2), "additive")
+	assert.Equal(t, uint(3), compat.MinBinary(3), "not annotated")
+	assert.Equal(t, uint(3), compat.MinBinary(4), "not older than the migrations before it")
+}
+
+func TestReadCompatibility_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr string
+	}{
+		{name: "not a version", data: "-- min-compatible-binary: latest\n", wantErr: "invalid annotation"},
+		{name: "newer binary", data: "-- min-compatible-binary: 3\n", wantErr: "binary 3 cannot be required before migration 2 ships"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ReadCompatibility(fstest.MapFS{"000002_add_name.up.sql": {Data: []byte(tt.data)}})
+
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestCompatibility_RollbackTarget(t *testing.T) {
+	compat, err := ReadCompatibility(annotatedMigrations)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		current uint
+		binary  uint
+		want    uint
+		wantErr bool
+	}{
+		{name: "additive migration kept", current: 2, binary: 1, want: 2},
+		{name: "breaking migration rolled back", current: 4, binary: 2, want: 2},
+		{name: "later additive migration kept", current: 4, binary: 3, want: 4},
+		{name: "binary not behind", current: 3, binary: 4, want: 3},
+		{name: "no schema works", current: 4, binary: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := compat.RollbackTarget(tt.current, tt.binary)
+
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMigrationManager_checkSchema(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   uint
+		minBinary *uint
+		wantAhead bool
+	}{
+		{name: "schema behind the binary", current: 3},
+		{name: "schema ahead but compatible", current: 5, minBinary: ptr(uint(4))},
+		{name: "schema ahead of the binary", current: 5, minBinary: ptr(uint(5)), wantAhead: true},
+		{name: "schema ahead without record", current: 5, wantAhead: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			if tt.current > 4 {
+				mock.ExpectExec(regexp.QuoteMeta(compatibilityTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+				query := mock.ExpectQuery("SELECT min_binary FROM schema_compatibility").WithArgs(tt.current)
+				if tt.minBinary != nil {
+					query.WillReturnRows(sqlmock.NewRows([]string{"min_binary"}).AddRow(*tt.minBinary))
+				} else {
+					query.WillReturnError(sql.ErrNoRows)
+				}
+			}
+			var logs bytes.Buffer
+			manager := NewMigrationManager(db, logger.New(logger.WithOutput(&logs)))
+
+			// Act
+			err = manager.checkSchema(context.Background(), tt.current, 4)
+
+			// Assert
+			if tt.wantAhead {
+				assert.ErrorIs(t, err, ErrSchemaAhead)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMigrationManager_Check_Refused(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	mock.ExpectExec(regexp.QuoteMeta(compatibilityTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT min_binary FROM schema_compatibility").
+		WillReturnRows(sqlmock.NewRows([]string{"min_binary"}).AddRow(5))
+	manager := NewMigrationManager(db, logger.New(logger.WithOutput(&bytes.Buffer{})))
+
+	// Act
+	err = manager.checkSchema(context.Background(), 5, 4)
+	manager.refuse(err)
+
+	// Assert
+	result := manager.Check(context.Background())
+	assert.Equal(t, health.StatusFail, result.Status, "readiness fails rather than the process")
+	assert.Contains(t, result.Message, "migrate down --to-compatible 4")
+}
+
+func TestMigrationManager_recordCompatibility(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	compat, err := ReadCompatibility(annotatedMigrations)
+	require.NoError(t, err)
+
+	mock.ExpectExec(regexp.QuoteMeta(compatibilityTable)).WillReturnResult(sqlmock.NewResult(0, 0))
+	for _, row := range [][2]uint{{1, 1}, {2, 1}, {3, 3}, {4, 3}} {
+		mock.ExpectExec("INSERT INTO schema_compatibility").WithArgs(row[0], row[1]).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	manager := NewMigrationManager(db, logger.New(logger.WithOutput(&bytes.Buffer{})))
+
+	// Act
+	err = manager.recordCompatibility(context.Background(), compat)
+
+	// Assert
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
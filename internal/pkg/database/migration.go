@@ -3,27 +3,62 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/romanitalian/carch-go/internal/pkg/health"
 	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
 )
 
 // MigrationManager handles database migrations
 type MigrationManager struct {
-	db     *sql.DB
-	logger *logger.Logger
+	db      *sql.DB
+	logger  *logger.Logger
+	timeout time.Duration
+
+	// progress of RunMigrations, reported by Check
+	mu    sync.Mutex
+	n     int
+	total int
+	file  string
+	done  bool
+	// refused tells why RunMigrations refused the schema, see ErrSchemaAhead
+	refused string
+}
+
+// MigrationOption is a function that configures a MigrationManager
+type MigrationOption func(*MigrationManager)
+
+// WithMigrationTimeout aborts a migration that runs longer than timeout,
+// 0 lets migrations run as long as they take
+func WithMigrationTimeout(timeout time.Duration) MigrationOption {
+	return func(m *MigrationManager) {
+		m.timeout = timeout
+	}
 }
 
 // NewMigrationManager creates a new migration manager
-func NewMigrationManager(db *sql.DB, logger *logger.Logger) *MigrationManager {
-	return &MigrationManager{
+func NewMigrationManager(db *sql.DB, logger *logger.Logger, opts ...MigrationOption) *MigrationManager {
+	m := &MigrationManager{
 		db:     db,
 		logger: logger,
 	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
 }
 
 // EnsureDatabaseExists checks if the database exists and creates it if it doesn't
@@ -53,20 +88,24 @@ func (m *MigrationManager) EnsureDatabaseExists(dbName string) error {
 	return nil
 }
 
-// RunMigrations runs all migrations from the migrations directory
+// RunMigrations applies the pending migrations from the migrations
+// directory one at a time, logging each file with its duration. It refuses
+// a schema migrated by a newer binary past what this one works with,
+// returning ErrSchemaAhead and failing Check, then records which binaries
+// work with the schema at each version of the directory.
 func (m *MigrationManager) RunMigrations(ctx context.Context, migrationsPath string) error {
 	m.logger.Info("Running database migrations", map[string]interface{}{
 		"path": migrationsPath,
 	})
 
-	// Ensure migrations path is absolute
-	absPath, err := filepath.Abs(migrationsPath)
+	absPath, err := ResolveMigrationsPath(migrationsPath)
 	if err != nil {
-		return fmt.Errorf("failed to get absolute path for migrations: %w", err)
+		return err
 	}
 
-	// Create postgres driver for migrations
-	driver, err := postgres.WithInstance(m.db, &postgres.Config{})
+	// Create postgres driver for migrations. The statement timeout cancels a
+	// migration on the server once it runs longer than allowed.
+	driver, err := postgres.WithInstance(m.db, &postgres.Config{StatementTimeout: m.timeout})
 	if err != nil {
 		return fmt.Errorf("failed to create postgres driver for migrations: %w", err)
 	}
@@ -82,11 +121,256 @@ func (m *MigrationManager) RunMigrations(ctx context.Context, migrationsPath str
 		return fmt.Errorf("failed to create migrator: %w", err)
 	}
 
-	// Run migrations
-	if err := migrator.Up(); err != nil && err != migrate.ErrNoChange {
-		return fmt.Errorf("failed to run migrations: %w", err)
+	compat, err := ReadCompatibility(os.DirFS(absPath))
+	if err != nil {
+		return err
+	}
+	current, err := schemaVersion(migrator)
+	if err != nil {
+		return err
+	}
+	if err := m.checkSchema(ctx, current, compat.Latest()); err != nil {
+		if errors.Is(err, ErrSchemaAhead) {
+			m.refuse(err)
+		}
+		return err
+	}
+
+	if err := m.run(ctx, &migrateStepper{migrator: migrator, path: absPath}); err != nil {
+		return err
+	}
+	if err := m.recordCompatibility(ctx, compat); err != nil {
+		// Older binaries then refuse the schema, which is safe
+		m.logger.Error("Failed to record schema compatibility", err, nil)
+	}
+	return nil
+}
+
+// RollBackTo migrates the schema down to the highest version working with
+// binary, named by the latest migration it ships, and returns that version.
+// With dryRun, it only returns the version.
+func (m *MigrationManager) RollBackTo(migrationsPath string, binary uint, dryRun bool) (uint, error) {
+	absPath, err := ResolveMigrationsPath(migrationsPath)
+	if err != nil {
+		return 0, err
+	}
+	compat, err := ReadCompatibility(os.DirFS(absPath))
+	if err != nil {
+		return 0, err
+	}
+
+	driver, err := postgres.WithInstance(m.db, &postgres.Config{StatementTimeout: m.timeout})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create postgres driver for migrations: %w", err)
+	}
+	migrator, err := migrate.NewWithDatabaseInstance(fmt.Sprintf("file://%s", absPath), "postgres", driver)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create migrator: %w", err)
+	}
+	current, err := schemaVersion(migrator)
+	if err != nil {
+		return 0, err
+	}
+
+	target, err := compat.RollbackTarget(current, binary)
+	if err != nil || dryRun || target == current {
+		return target, err
+	}
+	m.logger.Info("Rolling back database migrations", map[string]interface{}{
+		"from":   current,
+		"to":     target,
+		"binary": binary,
+	})
+	if err := migrator.Migrate(target); err != nil {
+		return 0, fmt.Errorf("failed to migrate down to version %d: %w", target, err)
+	}
+	return target, nil
+}
+
+// schemaVersion returns the version of the schema, 0 before any migration
+func schemaVersion(migrator *migrate.Migrate) (uint, error) {
+	current, dirty, err := migrator.Version()
+	switch {
+	case errors.Is(err, migrate.ErrNilVersion):
+		return 0, nil
+	case err != nil:
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	case dirty:
+		return 0, fmt.Errorf("schema version %d is dirty", current)
+	}
+	return current, nil
+}
+
+// ResolveMigrationsPath returns the absolute path of a migrations
+// directory, a relative path being resolved against the working directory.
+// It fails when the path is not a directory.
+func ResolveMigrationsPath(path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path for migrations: %w", err)
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return "", fmt.Errorf("migrations directory: %w", err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("migrations directory: %s is not a directory", absPath)
+	}
+	return absPath, nil
+}
+
+func (m *MigrationManager) run(ctx context.Context, stepper migrationStepper) error {
+	pending, err := stepper.Pending()
+	if err != nil {
+		return fmt.Errorf("failed to list pending migrations: %w", err)
+	}
+	if len(pending) == 0 {
+		m.finish()
+		m.logger.Info("Database schema is up to date", nil)
+		return nil
+	}
+
+	metrics.MigrationInProgress.Set(1)
+	defer metrics.MigrationInProgress.Set(0)
+
+	started := time.Now()
+	for i, migration := range pending {
+		m.progress(i+1, len(pending), migration.File)
+
+		fields := map[string]interface{}{
+			"file":    migration.File,
+			"version": migration.Version,
+			"n":       i + 1,
+			"total":   len(pending),
+		}
+		m.logger.Info(fmt.Sprintf("Applying migration %d of %d", i+1, len(pending)), fields)
+
+		stepStarted := time.Now()
+		if err := m.step(ctx, stepper); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				return fmt.Errorf("migration %s exceeded the timeout of %s: %w", migration.File, m.timeout, err)
+			}
+			return fmt.Errorf("migration %s failed: %w", migration.File, err)
+		}
+
+		fields["duration_ms"] = time.Since(stepStarted).Milliseconds()
+		m.logger.Info(fmt.Sprintf("Applied migration %d of %d", i+1, len(pending)), fields)
 	}
 
-	m.logger.Info("Database migrations completed successfully", nil)
+	m.finish()
+	m.logger.Info("Database migrations completed successfully", map[string]interface{}{
+		"applied":     len(pending),
+		"duration_ms": time.Since(started).Milliseconds(),
+	})
 	return nil
 }
+
+// step applies the next migration within the per-migration timeout. The
+// migration keeps running in the background after the timeout, the caller
+// is expected to abort startup.
+func (m *MigrationManager) step(ctx context.Context, stepper migrationStepper) error {
+	if m.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.timeout)
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- stepper.Step() }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *MigrationManager) progress(n, total int, file string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.n, m.total, m.file = n, total, file
+}
+
+func (m *MigrationManager) refuse(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.refused = err.Error()
+}
+
+func (m *MigrationManager) finish() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.done = true
+}
+
+// Name implements health.Checker
+func (m *MigrationManager) Name() string {
+	return "migration_progress"
+}
+
+// Check reports the service as starting until the migrations are applied,
+// so that readiness tells a long migration apart from a failure. It fails
+// for good once RunMigrations refused a schema ahead of the binary.
+func (m *MigrationManager) Check(ctx context.Context) health.Result {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case m.refused != "":
+		return health.Fail(m.refused)
+	case m.done:
+		return health.Pass("migrations applied")
+	case m.total == 0:
+		return health.Starting("waiting for migrations")
+	}
+	return health.Starting(fmt.Sprintf("applying migration %d of %d: %s", m.n, m.total, m.file))
+}
+
+// Migration is an up migration that has not been applied yet
+type Migration struct {
+	Version uint
+	File    string
+}
+
+// migrationStepper applies migrations one at a time
+type migrationStepper interface {
+	// Pending returns the migrations to apply in order
+	Pending() ([]Migration, error)
+	// Step applies the next pending migration
+	Step() error
+}
+
+// migrateStepper applies the migration files of a directory with golang-migrate
+type migrateStepper struct {
+	migrator *migrate.Migrate
+	path     string
+}
+
+func (s *migrateStepper) Pending() ([]Migration, error) {
+	current, _, err := s.migrator.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return nil, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, e := range entries {
+		parsed, err := source.DefaultParse(e.Name())
+		if err != nil || parsed.Direction != source.Up || parsed.Version <= current {
+			continue
+		}
+		pending = append(pending, Migration{Version: parsed.Version, File: e.Name()})
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Version < pending[j].Version })
+
+	return pending, nil
+}
+
+func (s *migrateStepper) Step() error {
+	return s.migrator.Steps(1)
+}
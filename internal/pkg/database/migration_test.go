@@ -0,0 +1,185 @@
+package database
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/health"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// stubMigration takes duration to apply
+type stubMigration struct {
+	Migration
+	duration time.Duration
+	err      error
+}
+
+// stubStepper applies stub migrations and records what the manager reports
+// while each of them runs
+type stubStepper struct {
+	manager    *MigrationManager
+	migrations []stubMigration
+	applied    int
+
+	gauges []float64
+	checks []health.Result
+}
+
+func (s *stubStepper) Pending() ([]Migration, error) {
+	pending := make([]Migration, 0, len(s.migrations))
+	for _, m := range s.migrations {
+		pending = append(pending, m.Migration)
+	}
+	return pending, nil
+}
+
+func (s *stubStepper) Step() error {
+	m := s.migrations[s.applied]
+	s.gauges = append(s.gauges, testutil.ToFloat64(metrics.MigrationInProgress))
+	s.checks = append(s.checks, s.manager.Check(context.Background()))
+	time.Sleep(m.duration)
+	if m.err != nil {
+		return m.err
+	}
+	s.applied++
+	return nil
+}
+
+func newStubManager(logs *bytes.Buffer, migrations []stubMigration, opts ...MigrationOption) (*MigrationManager, *stubStepper) {
+	manager := NewMigrationManager(nil, logger.New(logger.WithOutput(logs)), opts...)
+	return manager, &stubStepper{manager: manager, migrations: migrations}
+}
+
+// logEntries decodes the JSON log lines written to logs
+func logEntries(t *testing.T, logs *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var entries []map[string]interface{}
+	dec := json.NewDecoder(logs)
+	for dec.More() {
+		var entry map[string]interface{}
+		require.NoError(t, dec.Decode(&entry))
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestMigrationManager_run(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	manager, stepper := newStubManager(&logs, []stubMigration{
+		{Migration: Migration{Version: 1, File: "000001_fast.up.sql"}},
+		{Migration: Migration{Version: 2, File: "000002_slow.up.sql"}, duration: 30 * time.Millisecond},
+	}, WithMigrationTimeout(time.Second))
+
+	// Assert: readiness reports starting before migrations run
+	assert.Equal(t, health.StatusStarting, manager.Check(context.Background()).Status)
+
+	// Act
+	err := manager.run(context.Background(), stepper)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 2, stepper.applied)
+	assert.Equal(t, []float64{1, 1}, stepper.gauges)
+	assert.Zero(t, testutil.ToFloat64(metrics.MigrationInProgress))
+	assert.Equal(t, []health.Result{
+		health.Starting("applying migration 1 of 2: 000001_fast.up.sql"),
+		health.Starting("applying migration 2 of 2: 000002_slow.up.sql"),
+	}, stepper.checks)
+	assert.Equal(t, health.StatusPass, manager.Check(context.Background()).Status)
+
+	var finished []map[string]interface{}
+	for _, entry := range logEntries(t, &logs) {
+		if entry["message"] == "Applied migration 2 of 2" {
+			finished = append(finished, entry)
+		}
+	}
+	require.Len(t, finished, 1)
+	assert.Equal(t, "000002_slow.up.sql", finished[0]["file"])
+	assert.GreaterOrEqual(t, finished[0]["duration_ms"], float64(30))
+}
+
+func TestMigrationManager_run_Timeout(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	manager, stepper := newStubManager(&logs, []stubMigration{
+		{Migration: Migration{Version: 1, File: "000001_fast.up.sql"}},
+		{Migration: Migration{Version: 2, File: "000002_backfill.up.sql"}, duration: time.Second},
+		{Migration: Migration{Version: 3, File: "000003_after.up.sql"}},
+	}, WithMigrationTimeout(20*time.Millisecond))
+
+	// Act
+	err := manager.run(context.Background(), stepper)
+
+	// Assert
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.EqualError(t, err, "migration 000002_backfill.up.sql exceeded the timeout of 20ms: context deadline exceeded")
+	assert.Len(t, stepper.checks, 2, "no migration is applied after the timeout")
+	assert.Zero(t, testutil.ToFloat64(metrics.MigrationInProgress))
+	assert.Equal(t, health.StatusStarting, manager.Check(context.Background()).Status)
+}
+
+func TestMigrationManager_run_Failure(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	manager, stepper := newStubManager(&logs, []stubMigration{
+		{Migration: Migration{Version: 1, File: "000001_broken.up.sql"}, err: errors.New("syntax error")},
+	})
+
+	// Act
+	err := manager.run(context.Background(), stepper)
+
+	// Assert
+	assert.EqualError(t, err, "migration 000001_broken.up.sql failed: syntax error")
+}
+
+func TestMigrationManager_run_UpToDate(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	manager, stepper := newStubManager(&logs, nil)
+
+	// Act
+	err := manager.run(context.Background(), stepper)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, health.Pass("migrations applied"), manager.Check(context.Background()))
+	assert.Contains(t, logs.String(), "Database schema is up to date")
+}
+
+func TestResolveMigrationsPath(t *testing.T) {
+	// Arrange
+	root := t.TempDir()
+	require.NoError(t, os.Mkdir(filepath.Join(root, "migrations"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "schema.sql"), nil, 0o600))
+	t.Chdir(root)
+
+	// Act
+	relative, relErr := ResolveMigrationsPath("./migrations")
+	_, missingErr := ResolveMigrationsPath("./missing")
+	_, fileErr := ResolveMigrationsPath("schema.sql")
+
+	// Assert
+	require.NoError(t, relErr)
+	want, err := filepath.EvalSymlinks(filepath.Join(root, "migrations"))
+	require.NoError(t, err)
+	got, err := filepath.EvalSymlinks(relative)
+	require.NoError(t, err)
+	assert.Equal(t, want, got, "resolved against the working directory")
+	assert.True(t, filepath.IsAbs(relative))
+	assert.ErrorIs(t, missingErr, os.ErrNotExist)
+	assert.ErrorContains(t, fileErr, "is not a directory")
+}
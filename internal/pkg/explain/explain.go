@@ -0,0 +1,224 @@
+// Package explain samples the plans of read statements in production.
+// Some statements are only slow with the data distribution of production,
+// so a small share of the reads of the repositories is run a second time
+// as EXPLAIN (ANALYZE, FORMAT JSON), and the plans above a cost or duration
+// threshold are logged with the name of the statement.
+//
+// EXPLAIN ANALYZE executes the statement, which is why only statements that
+// cannot change or lock rows are sampled, and never inside a transaction.
+package explain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/pkg/timing"
+)
+
+// Prefix turns a statement into the statement explaining it
+const Prefix = "EXPLAIN (ANALYZE, FORMAT JSON) "
+
+// Results of a sample, the result label of the sample metric
+const (
+	// ResultLogged is a plan above a threshold, logged
+	ResultLogged = "logged"
+	// ResultBelowThreshold is a plan below the thresholds, not logged
+	ResultBelowThreshold = "below_threshold"
+	// ResultError is an explain that failed or returned an invalid plan
+	ResultError = "error"
+)
+
+// Defaults of the thresholds above which plans are logged
+const (
+	DefaultMinCost     = 1000
+	DefaultMinDuration = 100 * time.Millisecond
+)
+
+// ErrInvalidRate is returned for a sample rate outside [0, 1]
+var ErrInvalidRate = errors.New("sample rate must be between 0 and 1")
+
+// Plan is the part of an explained plan the thresholds apply to
+type Plan struct {
+	// Cost is the estimated total cost of the root node
+	Cost float64
+	// Planning and Execution are the measured planning and execution times
+	Planning  time.Duration
+	Execution time.Duration
+}
+
+// explained is the JSON document of EXPLAIN (ANALYZE, FORMAT JSON)
+type explained []struct {
+	Plan struct {
+		TotalCost float64 `json:"Total Cost"`
+	} `json:"Plan"`
+	PlanningTime  float64 `json:"Planning Time"`
+	ExecutionTime float64 `json:"Execution Time"`
+}
+
+// Parse reads the thresholds' part of the JSON output of EXPLAIN (ANALYZE,
+// FORMAT JSON), in which times are in milliseconds
+func Parse(raw []byte) (Plan, error) {
+	var doc explained
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return Plan{}, fmt.Errorf("failed to parse plan: %w", err)
+	}
+	if len(doc) != 1 {
+		return Plan{}, fmt.Errorf("failed to parse plan: %d statements explained", len(doc))
+	}
+	return Plan{
+		Cost:      doc[0].Plan.TotalCost,
+		Planning:  timing.FromMilliseconds(doc[0].PlanningTime),
+		Execution: timing.FromMilliseconds(doc[0].ExecutionTime),
+	}, nil
+}
+
+var (
+	// leadingComments are the comments naming registered statements
+	leadingComments = regexp.MustCompile(`^(\s*/\*.*?\*/)*\s*`)
+	// writes are the keywords of statements, or CTEs, changing or locking rows
+	writes = regexp.MustCompile(`(?i)\b(INSERT|UPDATE|DELETE|MERGE|TRUNCATE|FOR\s+(NO\s+KEY\s+)?UPDATE|FOR\s+(KEY\s+)?SHARE)\b`)
+)
+
+// ReadOnly reports whether query is a read that can be explained, a SELECT
+// or a WITH query not changing or locking rows. It errs on the side of
+// excluding statements, e.g. reads mentioning "update" in a literal.
+func ReadOnly(query string) bool {
+	query = leadingComments.ReplaceAllString(query, "")
+	words := strings.Fields(strings.TrimLeft(query, "( \t\n"))
+	if len(words) == 0 {
+		return false
+	}
+	switch strings.ToUpper(words[0]) {
+	case "SELECT", "WITH":
+		return !writes.MatchString(query)
+	default:
+		return false
+	}
+}
+
+// Option configures a Sampler
+type Option func(*Sampler)
+
+// WithMinCost logs plans whose estimated cost reaches cost
+func WithMinCost(cost float64) Option {
+	return func(s *Sampler) {
+		s.minCost = max(cost, 0)
+	}
+}
+
+// WithMinDuration logs plans whose execution takes d or longer
+func WithMinDuration(d time.Duration) Option {
+	return func(s *Sampler) {
+		s.minDuration = max(d, 0)
+	}
+}
+
+// WithRand draws the samples from r instead of the global source
+func WithRand(r *rand.Rand) Option {
+	return func(s *Sampler) {
+		if r != nil {
+			s.float = r.Float64
+		}
+	}
+}
+
+// Sampler decides which reads are explained and reports their plans. Its
+// rate may be changed while it is in use, e.g. by an administrator.
+type Sampler struct {
+	// rate holds the bits of the float64 sample rate
+	rate        atomic.Uint64
+	minCost     float64
+	minDuration time.Duration
+	float       func() float64
+	log         *logger.Logger
+}
+
+// NewSampler creates a sampler explaining the given share of reads, 0
+// disabling sampling until SetRate changes it. A rate outside [0, 1] is
+// clamped.
+func NewSampler(rate float64, log *logger.Logger, opts ...Option) *Sampler {
+	s := &Sampler{
+		minCost:     DefaultMinCost,
+		minDuration: DefaultMinDuration,
+		float:       rand.Float64,
+		log:         log,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.rate.Store(math.Float64bits(min(max(rate, 0), 1)))
+	return s
+}
+
+// Rate returns the share of reads explained
+func (s *Sampler) Rate() float64 {
+	return math.Float64frombits(s.rate.Load())
+}
+
+// SetRate changes the share of reads explained, 0 disables sampling
+func (s *Sampler) SetRate(rate float64) error {
+	if math.IsNaN(rate) || rate < 0 || rate > 1 {
+		return ErrInvalidRate
+	}
+	s.rate.Store(math.Float64bits(rate))
+	return nil
+}
+
+// MinCost returns the estimated cost from which plans are logged
+func (s *Sampler) MinCost() float64 {
+	return s.minCost
+}
+
+// MinDuration returns the execution time from which plans are logged
+func (s *Sampler) MinDuration() time.Duration {
+	return s.minDuration
+}
+
+// Sample reports whether the next read is explained. A nil sampler never
+// samples.
+func (s *Sampler) Sample() bool {
+	if s == nil {
+		return false
+	}
+	rate := s.Rate()
+	return rate > 0 && s.float() < rate
+}
+
+// Report logs the plan explaining the statement registered as name when
+// it is above a threshold, and counts the sample. err is the error of the
+// explain, if any. It logs with the logger of ctx, see logger.FromContext.
+func (s *Sampler) Report(ctx context.Context, name string, raw []byte, err error) {
+	log := logger.FromContextOr(ctx, s.log)
+	var plan Plan
+	if err == nil {
+		plan, err = Parse(raw)
+	}
+	if err != nil {
+		metrics.DBExplainSamples.WithLabelValues(name, ResultError).Inc()
+		log.Warn("Failed to explain sampled query", map[string]interface{}{"query": name, "error": err.Error()})
+		return
+	}
+
+	if plan.Cost < s.minCost && plan.Execution < s.minDuration {
+		metrics.DBExplainSamples.WithLabelValues(name, ResultBelowThreshold).Inc()
+		return
+	}
+	metrics.DBExplainSamples.WithLabelValues(name, ResultLogged).Inc()
+	log.Warn("Sampled query plan", map[string]interface{}{
+		"query":        name,
+		"cost":         plan.Cost,
+		"planning_ms":  float64(plan.Planning) / float64(time.Millisecond),
+		"execution_ms": float64(plan.Execution) / float64(time.Millisecond),
+		"plan":         json.RawMessage(raw),
+	})
+}
@@ -0,0 +1,200 @@
+package explain
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// planJSON is the output of EXPLAIN (ANALYZE, FORMAT JSON) for a plan of
+// the given cost and execution time in milliseconds
+func planJSON(cost, executionMS float64) []byte {
+	raw, _ := json.Marshal([]map[string]interface{}{{
+		"Plan":           map[string]interface{}{"Node Type": "Seq Scan", "Relation Name": "users", "Total Cost": cost},
+		"Planning Time":  0.25,
+		"Execution Time": executionMS,
+	}})
+	return raw
+}
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		query string
+		want  bool
+	}{
+		{query: "/* users.get_by_id */ SELECT * FROM users WHERE id = $1", want: true},
+		{query: "\n\t\tselect count(*) from users", want: true},
+		{query: "WITH recent AS (SELECT id FROM users) SELECT * FROM recent", want: true},
+		{query: "(SELECT 1) UNION (SELECT 2)", want: true},
+		{query: "/* users.create */ INSERT INTO users (id) VALUES ($1) RETURNING id"},
+		{query: "UPDATE users SET name = $1"},
+		{query: "DELETE FROM users WHERE id = $1"},
+		{query: "WITH gone AS (DELETE FROM users RETURNING id) SELECT count(*) FROM gone"},
+		{query: "SELECT * FROM jobs WHERE state = 'queued' FOR UPDATE SKIP LOCKED"},
+		{query: "SELECT * FROM jobs FOR NO KEY UPDATE"},
+		{query: "SELECT * FROM jobs FOR KEY SHARE"},
+		{query: "SELECT pg_advisory_lock(1); DELETE FROM users"},
+		{query: "/* only a comment */"},
+		{query: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.want, ReadOnly(tt.query))
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	plan, err := Parse(planJSON(1234.5, 150.5))
+
+	require.NoError(t, err)
+	assert.Equal(t, Plan{Cost: 1234.5, Planning: 250 * time.Microsecond, Execution: 150500 * time.Microsecond}, plan)
+}
+
+func TestParse_Invalid(t *testing.T) {
+	for _, raw := range []string{"", "{}", "[]", `[{"Plan": {}}, {"Plan": {}}]`} {
+		_, err := Parse([]byte(raw))
+
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestSampler_Sample(t *testing.T) {
+	tests := []struct {
+		name string
+		rate float64
+	}{
+		{name: "disabled", rate: 0},
+		{name: "one percent", rate: 0.01},
+		{name: "a quarter", rate: 0.25},
+		{name: "every read", rate: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			s := NewSampler(tt.rate, logger.New(), WithRand(rand.New(rand.NewPCG(1, 2))))
+			const draws = 100_000
+
+			// Act
+			sampled := 0
+			for range draws {
+				if s.Sample() {
+					sampled++
+				}
+			}
+
+			// Assert
+			assert.InDelta(t, tt.rate, float64(sampled)/draws, 0.005)
+		})
+	}
+}
+
+func TestSampler_Sample_Nil(t *testing.T) {
+	var s *Sampler
+
+	assert.False(t, s.Sample())
+}
+
+func TestSampler_SetRate(t *testing.T) {
+	// Arrange
+	s := NewSampler(0, logger.New())
+
+	// Act
+	require.NoError(t, s.SetRate(1))
+	errTooHigh := s.SetRate(1.5)
+	errNegative := s.SetRate(-0.1)
+
+	// Assert
+	assert.True(t, s.Sample())
+	assert.Equal(t, 1.0, s.Rate(), "invalid rates leave the rate as is")
+	assert.ErrorIs(t, errTooHigh, ErrInvalidRate)
+	assert.ErrorIs(t, errNegative, ErrInvalidRate)
+}
+
+func TestNewSampler_ClampsRate(t *testing.T) {
+	assert.Equal(t, 1.0, NewSampler(2, logger.New()).Rate())
+	assert.Equal(t, 0.0, NewSampler(-1, logger.New()).Rate())
+}
+
+func TestSampler_Report(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		raw        []byte
+		err        error
+		wantResult string
+		wantLog    string
+	}{
+		{name: "costly", query: "test.costly", raw: planJSON(5000, 1), wantResult: ResultLogged, wantLog: "Sampled query plan"},
+		{name: "slow", query: "test.slow", raw: planJSON(10, 250), wantResult: ResultLogged, wantLog: "Sampled query plan"},
+		{name: "below thresholds", query: "test.fast", raw: planJSON(10, 1), wantResult: ResultBelowThreshold},
+		{name: "explain failed", query: "test.failed", err: errors.New("canceling statement"), wantResult: ResultError,
+			wantLog: "Failed to explain sampled query"},
+		{name: "invalid plan", query: "test.invalid", raw: []byte("{"), wantResult: ResultError, wantLog: "Failed to explain sampled query"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			var logs bytes.Buffer
+			s := NewSampler(1, logger.New(logger.WithOutput(&logs)), WithMinCost(1000), WithMinDuration(100*time.Millisecond))
+
+			// Act
+			s.Report(context.Background(), tt.query, tt.raw, tt.err)
+
+			// Assert
+			assert.Equal(t, 1.0, testutil.ToFloat64(metrics.DBExplainSamples.WithLabelValues(tt.query, tt.wantResult)))
+			if tt.wantLog == "" {
+				assert.Empty(t, logs.String())
+				return
+			}
+			assert.Contains(t, logs.String(), tt.wantLog)
+			assert.Contains(t, logs.String(), `"query":"`+tt.query+`"`)
+		})
+	}
+}
+
+func TestSampler_Report_Payload(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	s := NewSampler(1, logger.New(logger.WithOutput(&logs)))
+	scoped := logger.New(logger.WithOutput(&logs)).WithFields(map[string]interface{}{"request_id": "req-1"})
+	ctx := logger.WithContext(context.Background(), scoped)
+
+	// Act
+	s.Report(ctx, "users.list", planJSON(1500, 120.5), nil)
+
+	// Assert
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "Sampled query plan", entry["message"])
+	assert.Equal(t, "users.list", entry["query"])
+	assert.Equal(t, "req-1", entry["request_id"])
+	assert.Equal(t, 1500.0, entry["cost"])
+	assert.Equal(t, 0.25, entry["planning_ms"])
+	assert.Equal(t, 120.5, entry["execution_ms"])
+	var want, got interface{}
+	require.NoError(t, json.Unmarshal(planJSON(1500, 120.5), &want))
+	require.NoError(t, json.Unmarshal(mustMarshal(t, entry["plan"]), &got))
+	assert.Equal(t, want, got, "the plan is logged as JSON, not as a string")
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	raw, err := json.Marshal(v)
+	require.NoError(t, err)
+	return raw
+}
@@ -0,0 +1,41 @@
+// Package generate stamps and identifies the records created by the
+// repositories and services, so that both layers are configured alike
+package generate
+
+import (
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/idgen"
+)
+
+// Generators stamp and identify new records
+type Generators struct {
+	Clock clock.Clock
+	IDs   idgen.Generator
+}
+
+// Option sets how records are stamped and identified. Without options
+// they get the time of the system clock and random UUIDs.
+type Option func(*Generators)
+
+// WithClock stamps records with the time told by c
+func WithClock(c clock.Clock) Option {
+	return func(g *Generators) {
+		g.Clock = clock.OrReal(c)
+	}
+}
+
+// WithIDGenerator identifies new records with IDs from ids
+func WithIDGenerator(ids idgen.Generator) Option {
+	return func(g *Generators) {
+		g.IDs = idgen.OrUUID(ids)
+	}
+}
+
+// New returns the generators set by opts
+func New(opts ...Option) Generators {
+	g := Generators{Clock: clock.Real, IDs: idgen.UUID}
+	for _, opt := range opts {
+		opt(&g)
+	}
+	return g
+}
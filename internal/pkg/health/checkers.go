@@ -0,0 +1,221 @@
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DBChecker verifies that the database accepts connections and queries
+type DBChecker struct {
+	db *sql.DB
+}
+
+func NewDBChecker(db *sql.DB) *DBChecker {
+	return &DBChecker{db: db}
+}
+
+func (c *DBChecker) Name() string {
+	return "postgres"
+}
+
+func (c *DBChecker) Check(ctx context.Context) Result {
+	if err := c.db.PingContext(ctx); err != nil {
+		return Fail(fmt.Sprintf("ping failed: %v", err))
+	}
+
+	var one int
+	if err := c.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return Fail(fmt.Sprintf("query failed: %v", err))
+	}
+
+	return Pass("connected")
+}
+
+// MigrationChecker compares the applied schema version with the version
+// the binary was built with
+type MigrationChecker struct {
+	db       *sql.DB
+	expected uint
+}
+
+func NewMigrationChecker(db *sql.DB, expected uint) *MigrationChecker {
+	return &MigrationChecker{db: db, expected: expected}
+}
+
+func (c *MigrationChecker) Name() string {
+	return "migrations"
+}
+
+func (c *MigrationChecker) Check(ctx context.Context) Result {
+	var (
+		version uint
+		dirty   bool
+	)
+	err := c.db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Fail(fmt.Sprintf("no migrations applied, expected version %d", c.expected))
+	}
+	if err != nil {
+		return Fail(fmt.Sprintf("failed to read schema version: %v", err))
+	}
+
+	switch {
+	case dirty:
+		return Fail(fmt.Sprintf("schema version %d is dirty", version))
+	case version < c.expected:
+		return Fail(fmt.Sprintf("schema version %d is behind expected %d", version, c.expected))
+	case version > c.expected:
+		return Warn(fmt.Sprintf("schema version %d is ahead of expected %d", version, c.expected))
+	}
+
+	return Pass(fmt.Sprintf("schema version %d", version))
+}
+
+// RabbitMQChecker dials the broker and verifies the expected queues exist
+type RabbitMQChecker struct {
+	url    string
+	queues []string
+}
+
+func NewRabbitMQChecker(url string, queues ...string) *RabbitMQChecker {
+	return &RabbitMQChecker{url: url, queues: queues}
+}
+
+func (c *RabbitMQChecker) Name() string {
+	return "rabbitmq"
+}
+
+func (c *RabbitMQChecker) Check(ctx context.Context) Result {
+	cfg := amqp.Config{}
+	if deadline, ok := ctx.Deadline(); ok {
+		cfg.Dial = amqp.DefaultDial(time.Until(deadline))
+	}
+
+	conn, err := amqp.DialConfig(c.url, cfg)
+	if err != nil {
+		return Fail(fmt.Sprintf("dial failed: %v", err))
+	}
+	defer conn.Close()
+
+	for _, queue := range c.queues {
+		// A failed passive declare closes the channel, so use one per queue
+		ch, err := conn.Channel()
+		if err != nil {
+			return Fail(fmt.Sprintf("failed to open channel: %v", err))
+		}
+		_, err = ch.QueueDeclarePassive(queue, true, false, false, false, nil)
+		ch.Close()
+		if err != nil {
+			return Fail(fmt.Sprintf("queue %q is missing: %v", queue, err))
+		}
+	}
+
+	return Pass(fmt.Sprintf("connected, %d queue(s) present", len(c.queues)))
+}
+
+// ConnectionStatus is a long-lived connection that reconnects on its own
+type ConnectionStatus interface {
+	Connected() bool
+	LastError() string
+}
+
+// NodeStatus is a connection to one node of a cluster, which the
+// ConnectionChecker names when it is connected
+type NodeStatus interface {
+	Node() string
+}
+
+// ConnectionChecker reports the state of a long-lived connection without
+// dialing, failing with the last error while it is down
+type ConnectionChecker struct {
+	name string
+	conn ConnectionStatus
+}
+
+func NewConnectionChecker(name string, conn ConnectionStatus) *ConnectionChecker {
+	return &ConnectionChecker{name: name, conn: conn}
+}
+
+func (c *ConnectionChecker) Name() string {
+	return c.name
+}
+
+func (c *ConnectionChecker) Check(ctx context.Context) Result {
+	lastErr := c.conn.LastError()
+	if !c.conn.Connected() {
+		if lastErr == "" {
+			return Fail("disconnected")
+		}
+		return Fail(fmt.Sprintf("disconnected: %s", lastErr))
+	}
+	status := "connected"
+	if n, ok := c.conn.(NodeStatus); ok && n.Node() != "" {
+		status += " to " + n.Node()
+	}
+	if lastErr != "" {
+		return Pass(fmt.Sprintf("%s, last error: %s", status, lastErr))
+	}
+	return Pass(status)
+}
+
+// TLSChecker validates certificate material on disk
+type TLSChecker struct {
+	name     string
+	certFile string
+	keyFile  string
+	caFile   string
+}
+
+// NewTLSChecker validates a certificate/key pair and an optional CA bundle.
+// Empty paths are skipped.
+func NewTLSChecker(name, certFile, keyFile, caFile string) *TLSChecker {
+	return &TLSChecker{name: name, certFile: certFile, keyFile: keyFile, caFile: caFile}
+}
+
+func (c *TLSChecker) Name() string {
+	return c.name
+}
+
+func (c *TLSChecker) Check(ctx context.Context) Result {
+	if c.caFile != "" {
+		pem, err := os.ReadFile(c.caFile)
+		if err != nil {
+			return Fail(fmt.Sprintf("failed to read CA file: %v", err))
+		}
+		if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+			return Fail(fmt.Sprintf("no certificates found in %s", c.caFile))
+		}
+	}
+
+	if c.certFile == "" && c.keyFile == "" {
+		return Pass("CA bundle valid")
+	}
+
+	pair, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return Fail(fmt.Sprintf("invalid certificate/key pair: %v", err))
+	}
+
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return Fail(fmt.Sprintf("failed to parse certificate: %v", err))
+	}
+
+	remaining := time.Until(leaf.NotAfter)
+	switch {
+	case remaining <= 0:
+		return Fail(fmt.Sprintf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339)))
+	case remaining < 14*24*time.Hour:
+		return Warn(fmt.Sprintf("certificate expires at %s", leaf.NotAfter.Format(time.RFC3339)))
+	}
+
+	return Pass(fmt.Sprintf("certificate valid until %s", leaf.NotAfter.Format(time.RFC3339)))
+}
@@ -0,0 +1,102 @@
+package health
+
+import (
+	"context"
+	"time"
+)
+
+// Status is the outcome of a single check
+type Status string
+
+const (
+	StatusPass Status = "pass"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+	// StatusStarting is reported while the service works towards being
+	// ready, e.g. applying migrations, and is not a failure
+	StatusStarting Status = "starting"
+)
+
+// Result is the outcome of a check with a human-readable explanation
+type Result struct {
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Checker verifies a single dependency of the service
+type Checker interface {
+	Name() string
+	Check(ctx context.Context) Result
+}
+
+// Pass returns a passing result
+func Pass(msg string) Result {
+	return Result{Status: StatusPass, Message: msg}
+}
+
+// Warn returns a result that does not block traffic but needs attention
+func Warn(msg string) Result {
+	return Result{Status: StatusWarn, Message: msg}
+}
+
+// Fail returns a failing result
+func Fail(msg string) Result {
+	return Result{Status: StatusFail, Message: msg}
+}
+
+// Starting returns a result of a service that is not ready yet
+func Starting(msg string) Result {
+	return Result{Status: StatusStarting, Message: msg}
+}
+
+// CheckReport is the result of a named check
+type CheckReport struct {
+	Name     string        `json:"name"`
+	Duration time.Duration `json:"duration_ns"`
+	Result
+}
+
+// Report aggregates the results of all checks
+type Report struct {
+	Checks []CheckReport `json:"checks"`
+}
+
+// Failed reports whether at least one check failed
+func (r Report) Failed() bool {
+	for _, c := range r.Checks {
+		if c.Status == StatusFail {
+			return true
+		}
+	}
+	return false
+}
+
+// Starting reports whether at least one check is still starting
+func (r Report) Starting() bool {
+	for _, c := range r.Checks {
+		if c.Status == StatusStarting {
+			return true
+		}
+	}
+	return false
+}
+
+// Run executes the checkers sequentially, each bounded by timeout
+func Run(ctx context.Context, timeout time.Duration, checkers ...Checker) Report {
+	report := Report{Checks: make([]CheckReport, 0, len(checkers))}
+
+	for _, c := range checkers {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		result := c.Check(checkCtx)
+		cancel()
+
+		report.Checks = append(report.Checks, CheckReport{
+			Name:     c.Name(),
+			Duration: time.Since(start),
+			Result:   result,
+		})
+	}
+
+	return report
+}
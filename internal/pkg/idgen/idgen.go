@@ -0,0 +1,86 @@
+// Package idgen abstracts the generation of record IDs so that code creating
+// records can be tested with exact values
+package idgen
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// Generator generates unique IDs
+type Generator interface {
+	NewID() string
+}
+
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewID() string {
+	return uuid.New().String()
+}
+
+// UUID generates random (version 4) UUIDs
+var UUID Generator = uuidGenerator{}
+
+type uuidV7Generator struct{}
+
+func (uuidV7Generator) NewID() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
+
+// UUIDv7 generates time-ordered (version 7) UUIDs. IDs generated by a process
+// increase, even within a millisecond, so new rows are appended to the right
+// of a primary key index instead of splitting pages all over it.
+var UUIDv7 Generator = uuidV7Generator{}
+
+// ForVersion returns the generator of UUIDs of the given version, 4 or 7
+func ForVersion(version int) (Generator, error) {
+	switch version {
+	case 4:
+		return UUID, nil
+	case 7:
+		return UUIDv7, nil
+	default:
+		return nil, fmt.Errorf("unsupported UUID version %d", version)
+	}
+}
+
+// OrUUID returns g, or UUID when g is nil
+func OrUUID(g Generator) Generator {
+	if g == nil {
+		return UUID
+	}
+	return g
+}
+
+// Fixed is a Generator that always returns the same ID
+type Fixed string
+
+func (f Fixed) NewID() string {
+	return string(f)
+}
+
+// Sequence is a Generator of UUIDs counting up from 1:
+// 00000000-0000-0000-0000-000000000001, 00000000-0000-0000-0000-000000000002...
+// They are valid UUIDs, so they pass validation and database UUID columns.
+type Sequence struct {
+	mu   sync.Mutex
+	last uint64
+}
+
+func NewSequence() *Sequence {
+	return &Sequence{}
+}
+
+func (s *Sequence) NewID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last++
+	return SequenceID(s.last)
+}
+
+// SequenceID returns the n-th ID of a Sequence
+func SequenceID(n uint64) string {
+	return fmt.Sprintf("00000000-0000-0000-0000-%012d", n)
+}
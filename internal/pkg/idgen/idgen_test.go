@@ -0,0 +1,91 @@
+package idgen
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSequence(t *testing.T) {
+	// Arrange
+	seq := NewSequence()
+
+	// Act
+	got := []string{seq.NewID(), seq.NewID(), seq.NewID()}
+
+	// Assert
+	assert.Equal(t, []string{
+		"00000000-0000-0000-0000-000000000001",
+		"00000000-0000-0000-0000-000000000002",
+		"00000000-0000-0000-0000-000000000003",
+	}, got)
+	for _, id := range got {
+		_, err := uuid.Parse(id)
+		require.NoError(t, err, "sequence IDs are valid UUIDs")
+	}
+	assert.Equal(t, got[1], SequenceID(2))
+}
+
+func TestFixed(t *testing.T) {
+	// Arrange
+	f := Fixed("user-1")
+
+	// Act & Assert
+	assert.Equal(t, "user-1", f.NewID())
+	assert.Equal(t, "user-1", f.NewID())
+}
+
+func TestUUID(t *testing.T) {
+	// Act
+	first, second := UUID.NewID(), UUID.NewID()
+
+	// Assert
+	_, err := uuid.Parse(first)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	assert.Equal(t, UUID, OrUUID(nil))
+	assert.Equal(t, Fixed("x"), OrUUID(Fixed("x")))
+}
+
+func TestUUIDv7(t *testing.T) {
+	// Act
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = UUIDv7.NewID()
+	}
+
+	// Assert
+	for _, id := range ids {
+		parsed, err := uuid.Parse(id)
+		require.NoError(t, err)
+		assert.Equal(t, uuid.Version(7), parsed.Version())
+	}
+	assert.True(t, slices.IsSorted(ids), "IDs of a process increase")
+	assert.Len(t, slices.Compact(slices.Clone(ids)), len(ids))
+}
+
+func TestForVersion(t *testing.T) {
+	tests := []struct {
+		version int
+		want    Generator
+		wantErr bool
+	}{
+		{version: 4, want: UUID},
+		{version: 7, want: UUIDv7},
+		{version: 6, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ForVersion(tt.version)
+
+		if tt.wantErr {
+			assert.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got)
+	}
+}
@@ -0,0 +1,71 @@
+package integrity
+
+import "time"
+
+// DefaultChecks returns the consistency checks of the user data.
+// Soft-deleted users are expected to be purged after retention.
+func DefaultChecks(retention time.Duration) []Check {
+	return []Check{
+		{
+			Name:        "orphaned_email_change_requests",
+			Description: "pending email change requests of deleted users",
+			Entity:      "email_change_requests",
+			Detect: `
+				SELECT r.user_id::text
+				FROM email_change_requests r
+				JOIN users u ON u.id = r.user_id
+				WHERE u.deleted_at IS NOT NULL
+				ORDER BY r.user_id`,
+			Repair: `
+				DELETE FROM email_change_requests r
+				USING users u
+				WHERE u.id = r.user_id AND u.deleted_at IS NOT NULL AND r.user_id::text = ANY($1)
+				RETURNING r.user_id::text`,
+		},
+		{
+			Name:        "tombstones_of_live_users",
+			Description: "tombstones left for users that are not deleted",
+			Entity:      "user_tombstones",
+			Detect: `
+				SELECT t.id::text
+				FROM user_tombstones t
+				JOIN users u ON u.id = t.id
+				WHERE u.deleted_at IS NULL
+				ORDER BY t.id`,
+			Repair: `
+				DELETE FROM user_tombstones t
+				USING users u
+				WHERE u.id = t.id AND u.deleted_at IS NULL AND t.id::text = ANY($1)
+				RETURNING t.id::text`,
+		},
+		{
+			Name:        "users_missing_metadata",
+			Description: "users with a blank name or password hash",
+			Entity:      "users",
+			Detect: `
+				SELECT id::text FROM users
+				WHERE btrim(name) = '' OR btrim(password_hash) = ''
+				ORDER BY id`,
+		},
+		{
+			Name:        "duplicate_emails",
+			Description: "emails used by several users when compared case-insensitively",
+			Entity:      "users",
+			Detect: `
+				SELECT lower(email) FROM users
+				GROUP BY lower(email)
+				HAVING COUNT(*) > 1
+				ORDER BY lower(email)`,
+		},
+		{
+			Name:        "expired_soft_deleted_users",
+			Description: "soft-deleted users kept past the retention period, the purge task is not running",
+			Entity:      "users",
+			Detect: `
+				SELECT id::text FROM users
+				WHERE deleted_at IS NOT NULL AND deleted_at < $1
+				ORDER BY id`,
+			Args: []interface{}{time.Now().Add(-retention)},
+		},
+	}
+}
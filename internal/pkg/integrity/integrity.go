@@ -0,0 +1,225 @@
+// Package integrity runs consistency checks over the data layer and applies
+// safe repairs for the inconsistencies that have one
+package integrity
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// AuditAction is recorded in the audit log for every repaired row
+const AuditAction = "integrity.repair"
+
+const (
+	defaultBatchSize  = 500
+	defaultSampleSize = 20
+)
+
+// Check is a read-only query detecting inconsistent rows, optionally paired
+// with a repair statement
+type Check struct {
+	Name        string
+	Description string
+	// Entity is the table the reported keys belong to
+	Entity string
+	// Detect selects the key of every inconsistent row as text
+	Detect string
+	Args   []interface{}
+	// Repair deletes the rows whose keys are in $1 and still match the
+	// inconsistency, returning their keys. Empty if there is no safe fix.
+	Repair string
+}
+
+// Finding is the outcome of a single check
+type Finding struct {
+	Check       string   `json:"check"`
+	Description string   `json:"description"`
+	Count       int      `json:"count"`
+	Sample      []string `json:"sample,omitempty"`
+	Repairable  bool     `json:"repairable"`
+	Repaired    int      `json:"repaired"`
+}
+
+// Report is the outcome of a verification run
+type Report struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Repair    bool      `json:"repair"`
+	Findings  []Finding `json:"findings"`
+}
+
+// Clean reports whether no inconsistencies are left
+func (r *Report) Clean() bool {
+	for _, f := range r.Findings {
+		if f.Count > f.Repaired {
+			return false
+		}
+	}
+	return true
+}
+
+// Verifier runs checks against the database
+type Verifier struct {
+	db         *sql.DB
+	checks     []Check
+	batchSize  int
+	sampleSize int
+}
+
+// Option is a function that configures a Verifier
+type Option func(*Verifier)
+
+// WithBatchSize sets how many rows are repaired per transaction
+func WithBatchSize(n int) Option {
+	return func(v *Verifier) {
+		if n > 0 {
+			v.batchSize = n
+		}
+	}
+}
+
+// WithChecks replaces the default checks
+func WithChecks(checks ...Check) Option {
+	return func(v *Verifier) {
+		v.checks = checks
+	}
+}
+
+// NewVerifier creates a verifier running DefaultChecks
+func NewVerifier(db *sql.DB, retention time.Duration, opts ...Option) *Verifier {
+	v := &Verifier{
+		db:         db,
+		checks:     DefaultChecks(retention),
+		batchSize:  defaultBatchSize,
+		sampleSize: defaultSampleSize,
+	}
+
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	return v
+}
+
+// Run executes every check and, if repair is set, applies the safe fixes
+func (v *Verifier) Run(ctx context.Context, repair bool) (*Report, error) {
+	report := &Report{
+		CheckedAt: time.Now(),
+		Repair:    repair,
+		Findings:  make([]Finding, 0, len(v.checks)),
+	}
+
+	for _, c := range v.checks {
+		keys, err := v.detect(ctx, c)
+		if err != nil {
+			return nil, fmt.Errorf("check %s: %w", c.Name, err)
+		}
+
+		f := Finding{
+			Check:       c.Name,
+			Description: c.Description,
+			Count:       len(keys),
+			Sample:      keys[:min(len(keys), v.sampleSize)],
+			Repairable:  c.Repair != "",
+		}
+
+		if repair && f.Repairable && len(keys) > 0 {
+			f.Repaired, err = v.repair(ctx, c, keys)
+			if err != nil {
+				return nil, fmt.Errorf("repair %s: %w", c.Name, err)
+			}
+		}
+
+		report.Findings = append(report.Findings, f)
+	}
+
+	return report, nil
+}
+
+func (v *Verifier) detect(ctx context.Context, c Check) ([]string, error) {
+	rows, err := v.db.QueryContext(ctx, c.Detect, c.Args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// repair applies the fix in batches, each in its own transaction together
+// with the audit log entries of the rows it changed
+func (v *Verifier) repair(ctx context.Context, c Check, keys []string) (int, error) {
+	details, err := json.Marshal(map[string]string{"check": c.Name})
+	if err != nil {
+		return 0, err
+	}
+
+	repaired := 0
+	for start := 0; start < len(keys); start += v.batchSize {
+		batch := keys[start:min(start+v.batchSize, len(keys))]
+
+		n, err := v.repairBatch(ctx, c, batch, details)
+		if err != nil {
+			return repaired, err
+		}
+		repaired += n
+	}
+
+	return repaired, nil
+}
+
+func (v *Verifier) repairBatch(ctx context.Context, c Check, batch []string, details []byte) (int, error) {
+	tx, err := v.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, c.Repair, pq.Array(batch))
+	if err != nil {
+		return 0, err
+	}
+
+	var changed []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		changed = append(changed, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	if len(changed) > 0 {
+		query := `
+			INSERT INTO audit_log (action, entity, entity_id, details, created_at)
+			SELECT $1, $2, entity_id, $3, $4 FROM unnest($5::text[]) AS entity_id`
+		_, err = tx.ExecContext(ctx, query, AuditAction, c.Entity, details, time.Now(), pq.Array(changed))
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(changed), nil
+}
@@ -1,16 +1,38 @@
 package logger
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
+
+	"github.com/romanitalian/carch-go/internal/pkg/sanitize"
 )
 
-// Logger is a wrapper around zerolog.Logger
+// Logger is a wrapper around zerolog.Logger. Its level is kept apart from
+// the zerolog.Logger, so that SetLevel may change it while others log. The
+// children made by WithFields share the level of their parent.
 type Logger struct {
 	logger zerolog.Logger
+	level  *atomic.Int32
+	// redacted are the lowercase keys whose values are never logged
+	redacted map[string]bool
+	// output is where the logger writes, file the rotating file among its
+	// outputs, if any
+	output io.Writer
+	file   *RotatingFile
+	// pretty formats entries for the console, see WithPretty
+	pretty bool
+	// counter counts the entries written, see Stats
+	counter *levelCounter
+	// stack logs the stack traces of errors, see WithStackTraces
+	stack bool
 }
 
 // Option is a function that configures a Logger
@@ -18,14 +40,18 @@ type Option func(*Logger)
 
 // New creates a new logger with the given options
 func New(options ...Option) *Logger {
+	useStackMarshaler()
+
 	// Default configuration
 	l := &Logger{
-		logger: zerolog.New(os.Stdout).
-			With().
-			Timestamp().
-			Caller().
-			Logger().
-			Level(zerolog.InfoLevel),
+		level:    new(atomic.Int32),
+		redacted: make(map[string]bool),
+		counter:  new(levelCounter),
+	}
+	l.setOutput(os.Stdout)
+	l.level.Store(int32(zerolog.InfoLevel))
+	for _, key := range DefaultRedactedKeys {
+		l.redacted[key] = true
 	}
 
 	// Apply options
@@ -39,98 +65,308 @@ func New(options ...Option) *Logger {
 // WithLevel sets the logger level
 func WithLevel(level zerolog.Level) Option {
 	return func(l *Logger) {
-		l.logger = l.logger.Level(level)
+		l.level.Store(int32(level))
+	}
+}
+
+// WithLevelString sets the logger level from its name, see ParseLevel. It
+// panics on an invalid name, which a binary must report with ParseLevel
+// before building its logger.
+func WithLevelString(name string) Option {
+	level, err := ParseLevel(name)
+	if err != nil {
+		panic(err)
+	}
+	return WithLevel(level)
+}
+
+// Levels are the names of the levels a logger may be set to, lowest first
+var Levels = []string{"trace", "debug", "info", "warn", "error"}
+
+// ParseLevel returns the level named name, one of Levels in any case. The
+// error of an invalid name lists the valid ones.
+func ParseLevel(name string) (zerolog.Level, error) {
+	normalized := strings.ToLower(strings.TrimSpace(name))
+	if slices.Contains(Levels, normalized) {
+		return zerolog.ParseLevel(normalized)
+	}
+	return zerolog.NoLevel, fmt.Errorf("invalid log level %q, valid levels are %s", name, strings.Join(Levels, ", "))
+}
+
+// RedactedValue replaces the values of redacted keys
+const RedactedValue = "[REDACTED]"
+
+// DefaultRedactedKeys are the keys every logger redacts
+var DefaultRedactedKeys = []string{"password", "password_hash", "authorization", "token"}
+
+// maxRedactDepth bounds how deep nested fields are redacted, deeper values
+// are replaced as a whole
+const maxRedactDepth = 16
+
+// WithRedactedKeys redacts the values of keys, in any case, on top of
+// DefaultRedactedKeys. Keys are matched at any depth of the fields, in
+// nested maps and in the maps of slices.
+func WithRedactedKeys(keys ...string) Option {
+	return func(l *Logger) {
+		for _, key := range keys {
+			l.redacted[strings.ToLower(key)] = true
+		}
 	}
 }
 
 // WithOutput sets the logger output
 func WithOutput(w io.Writer) Option {
 	return func(l *Logger) {
-		l.logger = zerolog.New(w).
-			With().
-			Timestamp().
-			Caller().
-			Logger()
+		l.setOutput(w)
 	}
 }
 
-// WithPretty enables pretty logging
+// WithPretty formats entries for a human reading the console instead of as
+// JSON. It applies to the output whether WithOutput comes before or after
+// it, and keeps the level.
 func WithPretty() Option {
 	return func(l *Logger) {
-		output := zerolog.ConsoleWriter{
-			Out:        os.Stdout,
-			TimeFormat: time.RFC3339,
-		}
-		l.logger = zerolog.New(output).
-			With().
-			Timestamp().
-			Caller().
-			Logger()
+		l.pretty = true
+		l.setOutput(l.output)
 	}
 }
 
+// WithFile writes JSON logs to the file at path instead of the output,
+// rotated when it reaches maxSizeMB and keeping maxBackups rotated files,
+// see RotatingFile. Close closes the file. The file is JSON even after
+// WithPretty.
+func WithFile(path string, maxSizeMB, maxBackups int) Option {
+	return func(l *Logger) {
+		l.pretty = false
+		l.file = NewRotatingFile(path, maxSizeMB, maxBackups)
+		l.setOutput(l.file)
+	}
+}
+
+// WithMultiOutput also writes logs to writers, on top of the output set by
+// the options before it, e.g. WithFile(path, 100, 5), WithMultiOutput(os.Stdout)
+// writes to a rotating file and to stdout
+func WithMultiOutput(writers ...io.Writer) Option {
+	return func(l *Logger) {
+		l.setOutput(zerolog.MultiLevelWriter(append([]io.Writer{l.output}, writers...)...))
+	}
+}
+
+// setOutput makes the logger write to w, formatted for the console if
+// pretty
+func (l *Logger) setOutput(w io.Writer) {
+	l.output = w
+	if l.pretty {
+		w = zerolog.ConsoleWriter{Out: w, TimeFormat: time.RFC3339}
+	}
+	l.logger = zerolog.New(w).
+		Hook(l.counter).
+		With().
+		Timestamp().
+		Caller().
+		Logger()
+}
+
+// Close closes the log file of WithFile, if any. The children of l share
+// its file, so it is closed once the binary is done logging.
+func (l *Logger) Close() error {
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// WithFields returns a child logger adding fields to every message. The
+// child shares the level of l, a later SetLevel on either applies to both.
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	return &Logger{
+		logger:   l.logger.With().Fields(l.redact(fields)).Logger(),
+		level:    l.level,
+		redacted: l.redacted,
+		output:   l.output,
+		file:     l.file,
+		pretty:   l.pretty,
+		counter:  l.counter,
+		stack:    l.stack,
+	}
+}
+
+// Level returns the level below which messages are dropped
+func (l *Logger) Level() zerolog.Level {
+	return zerolog.Level(l.level.Load())
+}
+
+// SetLevel changes the level of a logger in use and returns the previous one.
+// It is safe for concurrent use.
+func (l *Logger) SetLevel(level zerolog.Level) zerolog.Level {
+	return zerolog.Level(l.level.Swap(int32(level)))
+}
+
+// enabled reports whether messages of level are logged
+func (l *Logger) enabled(level zerolog.Level) bool {
+	return level >= l.Level()
+}
+
 // Debug logs a debug message
 func (l *Logger) Debug(msg string, fields ...map[string]interface{}) {
-	event := l.logger.Debug()
-	if len(fields) > 0 {
-		for k, v := range fields[0] {
-			event = event.Interface(k, v)
-		}
+	if !l.enabled(zerolog.DebugLevel) {
+		return
 	}
-	event.Msg(msg)
+	l.withFields(l.logger.Debug(), fields).Msg(msg)
 }
 
 // Info logs an info message
 func (l *Logger) Info(msg string, fields ...map[string]interface{}) {
-	event := l.logger.Info()
-	if len(fields) > 0 {
-		for k, v := range fields[0] {
-			event = event.Interface(k, v)
-		}
+	if !l.enabled(zerolog.InfoLevel) {
+		return
 	}
-	event.Msg(msg)
+	l.withFields(l.logger.Info(), fields).Msg(msg)
 }
 
 // Warn logs a warning message
 func (l *Logger) Warn(msg string, fields ...map[string]interface{}) {
-	event := l.logger.Warn()
-	if len(fields) > 0 {
-		for k, v := range fields[0] {
-			event = event.Interface(k, v)
-		}
+	if !l.enabled(zerolog.WarnLevel) {
+		return
 	}
-	event.Msg(msg)
+	l.withFields(l.logger.Warn(), fields).Msg(msg)
 }
 
 // Error logs an error message
 func (l *Logger) Error(msg string, err error, fields ...map[string]interface{}) {
+	if !l.enabled(zerolog.ErrorLevel) {
+		return
+	}
 	event := l.logger.Error()
 	if err != nil {
-		event = event.Err(err)
+		event = l.withStack(event.Err(sanitize.Error(err)), err)
 	}
-	if len(fields) > 0 {
-		for k, v := range fields[0] {
-			event = event.Interface(k, v)
-		}
-	}
-	event.Msg(msg)
+	l.withFields(event, fields).Msg(msg)
 }
 
 // Fatal logs a fatal message and exits
 func (l *Logger) Fatal(msg string, err error, fields ...map[string]interface{}) {
 	event := l.logger.Fatal()
 	if err != nil {
-		event = event.Err(err)
+		event = l.withStack(event.Err(sanitize.Error(err)), err)
+	}
+	l.withFields(event, fields).Msg(msg)
+}
+
+// withFields adds the first of fields to event, redacted. Errors passed as
+// strings under the "error" key are sanitized like errors passed to Error.
+func (l *Logger) withFields(event *zerolog.Event, fields []map[string]interface{}) *zerolog.Event {
+	if len(fields) == 0 {
+		return event
+	}
+	for k, v := range l.redact(fields[0]) {
+		if s, ok := v.(string); ok && k == zerolog.ErrorFieldName {
+			v = sanitize.String(s)
+		}
+		event = event.Interface(k, v)
+	}
+	return event
+}
+
+// redact returns fields with the values of redacted keys replaced. The
+// maps and slices of the caller are copied rather than changed.
+func (l *Logger) redact(fields map[string]interface{}) map[string]interface{} {
+	if fields == nil {
+		return nil
 	}
-	if len(fields) > 0 {
-		for k, v := range fields[0] {
-			event = event.Interface(k, v)
+	return l.redactValue(fields, 0).(map[string]interface{})
+}
+
+// redactValue redacts the maps within v. Values of other types, such as
+// structs, are logged as they are.
+func (l *Logger) redactValue(v interface{}, depth int) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if depth > maxRedactDepth {
+			return RedactedValue
+		}
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			if l.redacted[strings.ToLower(key)] {
+				out[key] = RedactedValue
+				continue
+			}
+			out[key] = l.redactValue(value, depth+1)
 		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for key, value := range v {
+			if l.redacted[strings.ToLower(key)] {
+				value = RedactedValue
+			}
+			out[key] = value
+		}
+		return out
+	case []interface{}:
+		if depth > maxRedactDepth {
+			return RedactedValue
+		}
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = l.redactValue(value, depth+1)
+		}
+		return out
+	case []map[string]interface{}:
+		values := make([]interface{}, len(v))
+		for i, value := range v {
+			values[i] = value
+		}
+		return l.redactValue(values, depth)
+	default:
+		return v
 	}
-	event.Msg(msg)
 }
 
-// GetZerologLogger returns the underlying zerolog.Logger
+// GetZerologLogger returns the underlying zerolog.Logger at the current level
 func (l *Logger) GetZerologLogger() zerolog.Logger {
-	return l.logger
+	return l.logger.Level(l.Level())
+}
+
+// defaultLogger is returned by FromContext when ctx holds no logger
+var defaultLogger atomic.Pointer[Logger]
+
+func init() {
+	defaultLogger.Store(New())
+}
+
+// Default returns the package-level default logger, see SetDefault
+func Default() *Logger {
+	return defaultLogger.Load()
+}
+
+// SetDefault makes l the logger of contexts holding none, e.g. the
+// configured logger of a binary. It is safe for concurrent use.
+func SetDefault(l *Logger) {
+	if l != nil {
+		defaultLogger.Store(l)
+	}
+}
+
+type contextKey struct{}
+
+// WithContext returns a copy of ctx holding l, so that the layers handling
+// a request log with the fields l adds, such as its request ID
+func WithContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger held by ctx, the default logger when it
+// holds none
+func FromContext(ctx context.Context) *Logger {
+	return FromContextOr(ctx, Default())
+}
+
+// FromContextOr returns the logger held by ctx, fallback when it holds none.
+// Components with a logger of their own pass it as fallback, so that they
+// keep logging to it outside requests.
+func FromContextOr(ctx context.Context, fallback *Logger) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok && l != nil {
+		return l
+	}
+	return fallback
 }
@@ -0,0 +1,303 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_SetLevel(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	log := New(WithOutput(&logs))
+	log.Debug("before")
+
+	// Act
+	old := log.SetLevel(zerolog.DebugLevel)
+	log.Debug("after")
+
+	// Assert
+	assert.Equal(t, zerolog.InfoLevel, old)
+	assert.Equal(t, zerolog.DebugLevel, log.Level())
+	assert.NotContains(t, logs.String(), "before")
+	assert.Contains(t, logs.String(), "after")
+}
+
+func TestLogger_WithLevelKeptByOutput(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	log := New(WithLevel(zerolog.WarnLevel), WithOutput(&logs))
+
+	// Act
+	log.Info("dropped")
+	log.Warn("kept")
+
+	// Assert
+	assert.NotContains(t, logs.String(), "dropped")
+	assert.Contains(t, logs.String(), "kept")
+}
+
+func TestLogger_WithPretty_Ordering(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(logs *bytes.Buffer) []Option
+	}{
+		{
+			name: "level then pretty",
+			opts: func(logs *bytes.Buffer) []Option {
+				return []Option{WithOutput(logs), WithLevel(zerolog.WarnLevel), WithPretty()}
+			},
+		},
+		{
+			name: "pretty then level",
+			opts: func(logs *bytes.Buffer) []Option {
+				return []Option{WithOutput(logs), WithPretty(), WithLevel(zerolog.WarnLevel)}
+			},
+		},
+		{
+			name: "pretty before output",
+			opts: func(logs *bytes.Buffer) []Option {
+				return []Option{WithLevel(zerolog.WarnLevel), WithPretty(), WithOutput(logs)}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			var logs bytes.Buffer
+			log := New(tt.opts(&logs)...)
+
+			// Act
+			log.Info("dropped")
+			log.Warn("kept")
+
+			// Assert
+			assert.Equal(t, zerolog.WarnLevel, log.Level())
+			assert.NotContains(t, logs.String(), "dropped")
+			assert.Contains(t, logs.String(), "kept")
+			assert.False(t, json.Valid(logs.Bytes()), "formatted for the console, not JSON")
+		})
+	}
+}
+
+// TestLogger_SetLevelConcurrent is meant for go test -race
+func TestLogger_SetLevelConcurrent(t *testing.T) {
+	// Arrange
+	var logs syncBuffer
+	log := New(WithOutput(&logs))
+	levels := []zerolog.Level{zerolog.DebugLevel, zerolog.InfoLevel, zerolog.WarnLevel}
+
+	// Act
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				log.SetLevel(levels[(i+j)%len(levels)])
+				log.Debug("debug")
+				log.Info("info")
+				_ = log.GetZerologLogger()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	assert.Contains(t, levels, log.Level())
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func TestLogger_WithFields(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	parent := New(WithOutput(&logs))
+	child := parent.WithFields(map[string]interface{}{"request_id": "req-1"})
+
+	// Act
+	parent.SetLevel(zerolog.WarnLevel)
+	child.Info("dropped")
+	child.Warn("kept")
+	parent.Warn("parent")
+
+	// Assert
+	lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+	require.Len(t, lines, 2, "the child shares the level of its parent")
+	assert.Contains(t, lines[0], `"request_id":"req-1"`)
+	assert.Contains(t, lines[0], `"message":"kept"`)
+	assert.NotContains(t, lines[1], "request_id")
+}
+
+func TestFromContext(t *testing.T) {
+	// Arrange
+	scoped := New(WithOutput(io.Discard))
+	fallback := New(WithOutput(io.Discard))
+	ctx := WithContext(context.Background(), scoped)
+
+	// Act & Assert
+	assert.Same(t, scoped, FromContext(ctx))
+	assert.Same(t, scoped, FromContextOr(ctx, fallback))
+	assert.Same(t, Default(), FromContext(context.Background()))
+	assert.Same(t, fallback, FromContextOr(context.Background(), fallback))
+}
+
+func TestSetDefault(t *testing.T) {
+	// Arrange
+	previous := Default()
+	t.Cleanup(func() { SetDefault(previous) })
+	l := New(WithOutput(io.Discard))
+
+	// Act
+	SetDefault(l)
+	SetDefault(nil)
+
+	// Assert
+	assert.Same(t, l, Default(), "nil keeps the current default")
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    zerolog.Level
+		wantErr string
+	}{
+		{name: "trace", want: zerolog.TraceLevel},
+		{name: "debug", want: zerolog.DebugLevel},
+		{name: "info", want: zerolog.InfoLevel},
+		{name: " WARN ", want: zerolog.WarnLevel},
+		{name: "Error", want: zerolog.ErrorLevel},
+		{name: "verbose", wantErr: `invalid log level "verbose", valid levels are trace, debug, info, warn, error`},
+		{name: "fatal", wantErr: `invalid log level "fatal", valid levels are trace, debug, info, warn, error`},
+		{name: "", wantErr: `invalid log level "", valid levels are trace, debug, info, warn, error`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level, err := ParseLevel(tt.name)
+
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, level)
+		})
+	}
+}
+
+func TestWithLevelString(t *testing.T) {
+	log := New(WithLevelString("warn"))
+
+	assert.Equal(t, zerolog.WarnLevel, log.Level())
+	assert.Panics(t, func() { New(WithLevelString("verbose")) })
+}
+
+// loggedFields decodes the single entry logged to logs
+func loggedFields(t *testing.T, logs *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	return entry
+}
+
+func TestLogger_Redaction(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	log := New(WithOutput(&logs))
+	fields := map[string]interface{}{
+		"user_id":  "user-1",
+		"Password": "hunter2",
+		"request": map[string]interface{}{
+			"email": "ada@example.com",
+			"headers": map[string]string{
+				"Authorization": "Bearer abc",
+				"Accept":        "application/json",
+			},
+			"credentials": []interface{}{
+				map[string]interface{}{"token": "t-1", "kind": "refresh"},
+				"plain",
+			},
+		},
+		"users": []map[string]interface{}{{"id": "user-2", "password_hash": "$2a$10$xyz"}},
+	}
+
+	// Act
+	log.Info("Creating user", fields)
+
+	// Assert
+	entry := loggedFields(t, &logs)
+	assert.Equal(t, "user-1", entry["user_id"])
+	assert.Equal(t, RedactedValue, entry["Password"])
+	assert.Equal(t, map[string]interface{}{
+		"email": "ada@example.com",
+		"headers": map[string]interface{}{
+			"Authorization": RedactedValue,
+			"Accept":        "application/json",
+		},
+		"credentials": []interface{}{
+			map[string]interface{}{"token": RedactedValue, "kind": "refresh"},
+			"plain",
+		},
+	}, entry["request"])
+	assert.Equal(t, []interface{}{map[string]interface{}{"id": "user-2", "password_hash": RedactedValue}}, entry["users"])
+	assert.NotContains(t, logs.String(), "hunter2")
+	assert.Equal(t, "hunter2", fields["Password"], "the fields of the caller are left as they are")
+	assert.Equal(t, "t-1", fields["request"].(map[string]interface{})["credentials"].([]interface{})[0].(map[string]interface{})["token"])
+}
+
+func TestLogger_WithRedactedKeys(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	log := New(WithOutput(&logs), WithRedactedKeys("API_KEY", "secret"))
+
+	// Act
+	log.WithFields(map[string]interface{}{"api_key": "k-1", "tenant": "acme"}).
+		Error("Webhook failed", nil, map[string]interface{}{
+			"provider": map[string]interface{}{"name": "stripe", "Secret": "whsec_1"},
+			"token":    "t-1",
+		})
+
+	// Assert
+	entry := loggedFields(t, &logs)
+	assert.Equal(t, RedactedValue, entry["api_key"], "fields of children are redacted")
+	assert.Equal(t, "acme", entry["tenant"])
+	assert.Equal(t, map[string]interface{}{"name": "stripe", "Secret": RedactedValue}, entry["provider"])
+	assert.Equal(t, RedactedValue, entry["token"], "the default keys are still redacted")
+}
+
+func TestLogger_RedactionDepth(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	log := New(WithOutput(&logs))
+	nested := map[string]interface{}{"password": "deep"}
+	for range maxRedactDepth + 4 {
+		nested = map[string]interface{}{"nested": nested}
+	}
+
+	// Act
+	log.Info("Deep", map[string]interface{}{"value": nested})
+
+	// Assert
+	assert.NotContains(t, logs.String(), "deep")
+	assert.Contains(t, logs.String(), RedactedValue)
+}
@@ -0,0 +1,149 @@
+package logger
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// megabyte is the unit of the size limit of a RotatingFile
+const megabyte = 1024 * 1024
+
+// RotatingFile is a log file rotated when it would grow past its size
+// limit. On rotation path is renamed to path.1, path.1 to path.2 and so on,
+// the oldest backup past maxBackups is removed and a new path is created.
+// It is safe for concurrent use, a write is never split across two files.
+// The file is opened on the first write, so that a path that cannot be
+// opened fails the writes rather than the start of the binary, see
+// zerolog.ErrorHandler.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile creates a file at path rotated when it reaches maxSizeMB,
+// keeping maxBackups rotated files. A zero or negative maxSizeMB never
+// rotates, a zero or negative maxBackups keeps no rotated file.
+func NewRotatingFile(path string, maxSizeMB, maxBackups int) *RotatingFile {
+	return &RotatingFile{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * megabyte,
+		maxBackups: max(maxBackups, 0),
+	}
+}
+
+// Path returns the path of the current file
+func (f *RotatingFile) Path() string {
+	return f.path
+}
+
+// Write writes p to the current file, rotating it first when p would take
+// it past its size limit. A write larger than the limit goes to a file of
+// its own.
+func (f *RotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file. A later write opens it again.
+func (f *RotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// open opens path for appending, creating it and its directory if needed
+func (f *RotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate shifts the backups, moves the current file to the first one and
+// opens a new current file
+func (f *RotatingFile) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	f.file = nil
+
+	if f.maxBackups == 0 {
+		if err := os.Remove(f.path); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+		return f.open()
+	}
+
+	if err := removeIfExists(f.backup(f.maxBackups)); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	for n := f.maxBackups - 1; n >= 1; n-- {
+		if err := renameIfExists(f.backup(n), f.backup(n+1)); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+	if err := os.Rename(f.path, f.backup(1)); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	return f.open()
+}
+
+// backup returns the path of the nth rotated file, 1 being the newest
+func (f *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", f.path, n)
+}
+
+func removeIfExists(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func renameIfExists(from, to string) error {
+	if err := os.Rename(from, to); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// kilobyteLine is a log line of 1KB, newline included
+var kilobyteLine = []byte(strings.Repeat("x", 1023) + "\n")
+
+func TestRotatingFile_Rotate(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "logs", "worker.log")
+	f := NewRotatingFile(path, 1, 2)
+	defer f.Close()
+
+	// Act: 3.5MB fill the current file and both backups, dropping the oldest
+	for range 3584 {
+		_, err := f.Write(kilobyteLine)
+		require.NoError(t, err)
+	}
+
+	// Assert
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		require.NoError(t, err, name)
+		assert.LessOrEqual(t, info.Size(), int64(megabyte), name)
+	}
+	current, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(512*1024), current.Size(), "the half megabyte written last")
+	assert.NoFileExists(t, path+".3", "only maxBackups rotated files are kept")
+}
+
+func TestRotatingFile_NoBackups(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "worker.log")
+	f := NewRotatingFile(path, 1, 0)
+	defer f.Close()
+
+	// Act
+	for range 1025 {
+		_, err := f.Write(kilobyteLine)
+		require.NoError(t, err)
+	}
+
+	// Assert
+	current, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1024), current.Size())
+	assert.NoFileExists(t, path+".1")
+}
+
+func TestRotatingFile_Append(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "worker.log")
+	require.NoError(t, os.WriteFile(path, []byte("before restart\n"), 0o644))
+	f := NewRotatingFile(path, 1, 1)
+
+	// Act
+	_, err := f.Write([]byte("after restart\n"))
+	require.NoError(t, f.Close())
+
+	// Assert
+	require.NoError(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "before restart\nafter restart\n", string(content))
+}
+
+func TestLogger_WithFile_ConcurrentWrites(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "worker.log")
+	log := New(WithFile(path, 1, 10))
+	defer log.Close()
+	const goroutines, messages = 8, 500
+	padding := strings.Repeat("x", 512)
+
+	// Act: about 2.5MB, rotated twice while the goroutines log
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range messages {
+				log.Info("Processed message", map[string]interface{}{"goroutine": g, "message": m, "padding": padding})
+			}
+		}()
+	}
+	wg.Wait()
+	require.NoError(t, log.Close())
+
+	// Assert: every line is whole, in one of the files
+	lines := 0
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		file, err := os.Open(name)
+		require.NoError(t, err, name)
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 4096), 4096)
+		for scanner.Scan() {
+			var entry map[string]interface{}
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry), "a line was split by a rotation")
+			lines++
+		}
+		require.NoError(t, scanner.Err())
+		require.NoError(t, file.Close())
+	}
+	assert.Equal(t, goroutines*messages, lines)
+	assert.NoFileExists(t, path+".3")
+}
+
+func TestLogger_WithFile_MultiOutputAndLevel(t *testing.T) {
+	// Arrange
+	path := filepath.Join(t.TempDir(), "worker.log")
+	var stdout bytes.Buffer
+	log := New(WithLevel(zerolog.WarnLevel), WithFile(path, 100, 5), WithMultiOutput(&stdout))
+
+	// Act
+	log.Info("Dropped by the level")
+	log.Warn("Queue is backing up", map[string]interface{}{"depth": 1200})
+	require.NoError(t, log.Close())
+
+	// Assert
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, stdout.String(), string(content), "the file and the other output get the same lines")
+	assert.Contains(t, string(content), `"message":"Queue is backing up"`)
+	assert.NotContains(t, string(content), "Dropped by the level")
+}
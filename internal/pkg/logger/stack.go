@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/pkgerrors"
+)
+
+// maxStackDepth bounds the frames recorded by WrapStack
+const maxStackDepth = 32
+
+// stackMarshaler installs the pkg/errors stack marshaling of zerolog once,
+// zerolog.ErrorStackMarshaler being global
+var stackMarshaler sync.Once
+
+func useStackMarshaler() {
+	stackMarshaler.Do(func() {
+		zerolog.ErrorStackMarshaler = pkgerrors.MarshalStack
+	})
+}
+
+// WithStackTraces makes Error and Fatal log the stack trace of the errors
+// carrying one under the "stack" field. Errors get one from WrapStack or
+// from github.com/pkg/errors.
+func WithStackTraces(enabled bool) Option {
+	return func(l *Logger) {
+		l.stack = enabled
+	}
+}
+
+// stackError is an error annotated with the stack trace of where it was
+// wrapped. It implements the StackTrace method of pkg/errors, which
+// zerolog marshals.
+type stackError struct {
+	error
+	stack []uintptr
+}
+
+func (e *stackError) Unwrap() error {
+	return e.error
+}
+
+func (e *stackError) StackTrace() errors.StackTrace {
+	frames := make(errors.StackTrace, len(e.stack))
+	for i, pc := range e.stack {
+		frames[i] = errors.Frame(pc)
+	}
+	return frames
+}
+
+// WrapStack annotates err with the stack trace of its caller, unless err
+// already carries one. The error keeps its message and its chain for
+// errors.Is and errors.As. A nil err stays nil.
+func WrapStack(err error) error {
+	if err == nil || pkgerrors.MarshalStack(err) != nil {
+		return err
+	}
+	pcs := make([]uintptr, maxStackDepth)
+	n := runtime.Callers(2, pcs)
+	return &stackError{error: err, stack: pcs[:n]}
+}
+
+// withStack adds the stack trace err carries to event, when stack traces
+// are enabled
+func (l *Logger) withStack(event *zerolog.Event, err error) *zerolog.Event {
+	if !l.stack || err == nil {
+		return event
+	}
+	if stack := zerolog.ErrorStackMarshaler(err); stack != nil {
+		event = event.Interface(zerolog.ErrorStackFieldName, stack)
+	}
+	return event
+}
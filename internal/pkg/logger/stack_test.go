@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// findUser fails like a repository whose query found no row
+func findUser() error {
+	return WrapStack(fmt.Errorf("find user: %w", sql.ErrNoRows))
+}
+
+func TestLogger_Error_StackTraces(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		err     error
+		// wantTop is the function the logged stack starts in, empty when
+		// no stack is logged
+		wantTop string
+	}{
+		{name: "wrapped error", enabled: true, err: findUser(), wantTop: "findUser"},
+		{name: "pkg/errors error", enabled: true, err: pkgerrors.New("broker closed"), wantTop: "TestLogger_Error_StackTraces"},
+		{name: "error rewrapped with fmt", enabled: true, err: fmt.Errorf("get user: %w", findUser()), wantTop: "findUser"},
+		{name: "error without stack", enabled: true, err: errors.New("plain")},
+		{name: "disabled", err: findUser()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			var logs bytes.Buffer
+			log := New(WithOutput(&logs), WithStackTraces(tt.enabled)).WithFields(map[string]interface{}{"request_id": "req-1"})
+
+			// Act
+			log.Error("Failed to get user", tt.err)
+
+			// Assert
+			entry := loggedFields(t, &logs)
+			assert.Equal(t, tt.err.Error(), entry["error"])
+			if tt.wantTop == "" {
+				assert.NotContains(t, entry, "stack")
+				return
+			}
+			require.Contains(t, entry, "stack")
+			frames, ok := entry["stack"].([]interface{})
+			require.True(t, ok)
+			require.NotEmpty(t, frames)
+			top := frames[0].(map[string]interface{})
+			assert.Equal(t, tt.wantTop, top["func"], "the trace starts where the error was wrapped")
+			assert.NotEmpty(t, top["line"])
+		})
+	}
+}
+
+func TestWrapStack(t *testing.T) {
+	// Arrange
+	base := fmt.Errorf("find user: %w", sql.ErrNoRows)
+
+	// Act
+	wrapped := WrapStack(base)
+	again := WrapStack(wrapped)
+
+	// Assert
+	assert.Equal(t, base.Error(), wrapped.Error())
+	assert.ErrorIs(t, wrapped, sql.ErrNoRows)
+	assert.Same(t, wrapped, again, "an error carrying a stack is not wrapped twice")
+	assert.NoError(t, WrapStack(nil))
+}
@@ -0,0 +1,75 @@
+package logger
+
+import (
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// Stats are the numbers of entries a logger wrote by level since it was
+// created, e.g. to alert on a spike of errors without a metrics stack
+type Stats struct {
+	Trace uint64
+	Debug uint64
+	Info  uint64
+	Warn  uint64
+	Error uint64
+	Fatal uint64
+	Panic uint64
+}
+
+// Count returns the number of entries written at level
+func (s Stats) Count(level zerolog.Level) uint64 {
+	switch level {
+	case zerolog.TraceLevel:
+		return s.Trace
+	case zerolog.DebugLevel:
+		return s.Debug
+	case zerolog.InfoLevel:
+		return s.Info
+	case zerolog.WarnLevel:
+		return s.Warn
+	case zerolog.ErrorLevel:
+		return s.Error
+	case zerolog.FatalLevel:
+		return s.Fatal
+	case zerolog.PanicLevel:
+		return s.Panic
+	default:
+		return 0
+	}
+}
+
+// levelCounter is the zerolog hook counting the entries written by level.
+// zerolog runs hooks only for the entries it writes, so entries below the
+// level of the logger are not counted.
+type levelCounter struct {
+	// counts are indexed by level from zerolog.TraceLevel to
+	// zerolog.PanicLevel
+	counts [zerolog.PanicLevel - zerolog.TraceLevel + 1]atomic.Uint64
+}
+
+// Run implements zerolog.Hook without allocating
+func (c *levelCounter) Run(_ *zerolog.Event, level zerolog.Level, _ string) {
+	if level >= zerolog.TraceLevel && level <= zerolog.PanicLevel {
+		c.counts[level-zerolog.TraceLevel].Add(1)
+	}
+}
+
+func (c *levelCounter) count(level zerolog.Level) uint64 {
+	return c.counts[level-zerolog.TraceLevel].Load()
+}
+
+// Stats returns the numbers of entries written by level since the logger
+// was created, counting the entries of its children
+func (l *Logger) Stats() Stats {
+	return Stats{
+		Trace: l.counter.count(zerolog.TraceLevel),
+		Debug: l.counter.count(zerolog.DebugLevel),
+		Info:  l.counter.count(zerolog.InfoLevel),
+		Warn:  l.counter.count(zerolog.WarnLevel),
+		Error: l.counter.count(zerolog.ErrorLevel),
+		Fatal: l.counter.count(zerolog.FatalLevel),
+		Panic: l.counter.count(zerolog.PanicLevel),
+	}
+}
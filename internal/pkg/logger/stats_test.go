@@ -0,0 +1,65 @@
+package logger
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogger_Stats(t *testing.T) {
+	// Arrange
+	log := New(WithOutput(io.Discard), WithLevel(zerolog.DebugLevel))
+	child := log.WithFields(map[string]interface{}{"request_id": "req-1"})
+	const goroutines, rounds = 16, 250
+
+	// Act
+	var wg sync.WaitGroup
+	for g := range goroutines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range rounds {
+				log.Debug("Polling queue")
+				log.Info("Processed message", map[string]interface{}{"goroutine": g})
+				child.Warn("Slow handler")
+				child.Error("Handler failed", errors.New("boom"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	n := uint64(goroutines * rounds)
+	assert.Equal(t, Stats{Debug: n, Info: n, Warn: n, Error: n}, log.Stats(), "children count towards their parent")
+	assert.Equal(t, log.Stats(), child.Stats())
+	assert.Equal(t, n, log.Stats().Count(zerolog.ErrorLevel))
+	assert.Zero(t, log.Stats().Count(zerolog.NoLevel))
+}
+
+func TestLogger_Stats_BelowLevel(t *testing.T) {
+	// Arrange
+	log := New(WithOutput(io.Discard))
+
+	// Act
+	log.Debug("Dropped")
+	log.SetLevel(zerolog.DebugLevel)
+	log.Debug("Written")
+
+	// Assert
+	assert.Equal(t, Stats{Debug: 1}, log.Stats(), "entries below the level are not counted")
+}
+
+func TestLevelCounter_DoesNotAllocate(t *testing.T) {
+	var c levelCounter
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		c.Run(nil, zerolog.ErrorLevel, "Handler failed")
+	})
+
+	assert.Zero(t, allocs)
+	assert.Equal(t, uint64(1001), c.count(zerolog.ErrorLevel), "the warm-up run is counted too")
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// LogEntries reports the entries written by a logger by level, as
+// carch_log_entries_total{level}. The counts are kept by the logger and
+// read when scraped, so that logging does not go through Prometheus.
+type LogEntries struct {
+	desc   *prometheus.Desc
+	levels []string
+	count  func(level string) uint64
+}
+
+// NewLogEntries creates the collector of the entries written at levels,
+// count returning the number written at a level
+func NewLogEntries(levels []string, count func(level string) uint64) *LogEntries {
+	return &LogEntries{
+		desc: prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "log_entries_total"),
+			"Number of log entries written by level.", []string{"level"}, nil),
+		levels: levels,
+		count:  count,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *LogEntries) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector
+func (c *LogEntries) Collect(ch chan<- prometheus.Metric) {
+	for _, level := range c.levels {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.CounterValue, float64(c.count(level)), level)
+	}
+}
@@ -0,0 +1,227 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "carch"
+
+// StatementsPerRequest tracks how many database statements a single request issued
+var StatementsPerRequest = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "db_statements_per_request",
+	Help:      "Number of database statements executed per request.",
+	Buckets:   []float64{0, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89},
+}, []string{"transport", "route"})
+
+// HTTPRequests counts handled HTTP requests by route and response status
+var HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "http_requests_total",
+	Help:      "Number of HTTP requests by route and response status.",
+}, []string{"method", "route", "status"})
+
+// HTTPInFlight tracks public HTTP requests being handled
+var HTTPInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "http_in_flight",
+	Help:      "Number of public HTTP requests being handled.",
+})
+
+// HTTPShed counts HTTP requests rejected by the load shedder by route
+var HTTPShed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "http_shed_total",
+	Help:      "Number of HTTP requests rejected because the service was overloaded by route.",
+}, []string{"route"})
+
+// HTTPRateLimited counts HTTP requests rejected by the client rate limit by route
+var HTTPRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "http_rate_limited_total",
+	Help:      "Number of HTTP requests rejected because the client exceeded its rate limit by route.",
+}, []string{"route"})
+
+// HTTPShedRate is the fraction of sheddable HTTP requests currently rejected
+var HTTPShedRate = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "http_shed_rate",
+	Help:      "Fraction of sheddable HTTP requests currently rejected, from 0 to 1.",
+})
+
+// WorkerInFlight tracks messages being handled by message type
+var WorkerInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "worker_in_flight",
+	Help:      "Number of messages being handled by message type.",
+}, []string{"type"})
+
+// WorkerTimeouts counts handlers cancelled by their timeout
+var WorkerTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "worker_timeouts_total",
+	Help:      "Number of message handlers cancelled by their timeout by message type.",
+}, []string{"type"})
+
+// WorkerSaturated counts messages that waited for room in the backlog of
+// their type, holding back the deliveries of every type
+var WorkerSaturated = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "worker_saturated_total",
+	Help:      "Number of messages that waited for room in the backlog of their type by message type.",
+}, []string{"type"})
+
+// WorkerDuplicates counts messages acknowledged unhandled because their
+// business key was already processed, see worker.WithDedup
+var WorkerDuplicates = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "worker_duplicates_suppressed_total",
+	Help:      "Number of messages suppressed as duplicates of a processed message by message type.",
+}, []string{"type"})
+
+// RabbitMQConnected is 1 while the broker connection is up and 0 otherwise
+var RabbitMQConnected = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "rabbitmq_connected",
+	Help:      "Whether the RabbitMQ connection is up (1) or down (0).",
+})
+
+// RabbitMQNode is 1 for the broker node the connection is up to, with no
+// series while it is down
+var RabbitMQNode = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "rabbitmq_connected_node",
+	Help:      "The RabbitMQ cluster node the connection is up to (1).",
+}, []string{"node"})
+
+// RabbitMQReconnects counts successful reconnects to the broker
+var RabbitMQReconnects = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "rabbitmq_reconnects_total",
+	Help:      "Number of successful reconnects to RabbitMQ.",
+})
+
+// RabbitMQLastDowntime is how long the broker connection was down before the last reconnect
+var RabbitMQLastDowntime = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "rabbitmq_last_disconnect_seconds",
+	Help:      "Duration of the last RabbitMQ disconnect in seconds.",
+})
+
+// MigrationInProgress is 1 while database migrations are applied at startup and 0 otherwise
+var MigrationInProgress = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "migration_in_progress",
+	Help:      "Whether database migrations are being applied (1) or not (0).",
+})
+
+// CacheWarmed counts the users loaded into the user cache ahead of their lookups
+var CacheWarmed = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "cache_warmed_total",
+	Help:      "Number of users loaded into the user cache by cache warming.",
+})
+
+// UserCacheStaleServed counts the users served from the cache past their
+// TTL because the database was unreachable
+var UserCacheStaleServed = promauto.NewCounter(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "user_cache_stale_served_total",
+	Help:      "Number of user lookups served from expired cache entries while the database was unreachable.",
+})
+
+// UserShadowResults counts shadowed user reads by how the candidate result
+// compared with the served one: match, mismatch, error or timeout
+var UserShadowResults = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "user_shadow_results_total",
+	Help:      "Number of user reads run against the candidate implementation by method and result.",
+}, []string{"method", "result"})
+
+// UserShadowLatencyDelta is how much slower the candidate implementation
+// answered than the served one, negative when it was faster
+var UserShadowLatencyDelta = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "user_shadow_latency_delta_seconds",
+	Help:      "Latency of the candidate implementation of user reads minus the served one in seconds.",
+	Buckets:   []float64{-1, -0.25, -0.1, -0.025, -0.005, 0, 0.005, 0.025, 0.1, 0.25, 1},
+}, []string{"method"})
+
+// HTTPGroupInFlight is the number of requests of each route group running
+var HTTPGroupInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "http_group_in_flight",
+	Help:      "Number of requests running by route group.",
+}, []string{"group"})
+
+// HTTPGroupWaiting is the number of requests waiting for a slot of their
+// route group
+var HTTPGroupWaiting = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "http_group_waiting",
+	Help:      "Number of requests waiting for a slot by route group.",
+}, []string{"group"})
+
+// HTTPGroupLimit is the cap of each route group
+var HTTPGroupLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "http_group_limit",
+	Help:      "Maximum number of requests running at once by route group.",
+}, []string{"group"})
+
+// HTTPGroupRejected counts the requests rejected at the cap of their route group
+var HTTPGroupRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "http_group_rejected_total",
+	Help:      "Number of requests rejected by the concurrency cap of their route group.",
+}, []string{"group"})
+
+// HTTPContentBytes counts the bytes of streamed payloads, such as files,
+// written by route
+var HTTPContentBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "http_content_bytes_total",
+	Help:      "Number of bytes of streamed payloads written by route.",
+}, []string{"route"})
+
+// DBQueries counts the database statements run by the repositories by
+// registered name, see package queryreg
+var DBQueries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "db_queries_total",
+	Help:      "Number of database statements run by registered query name.",
+}, []string{"query"})
+
+// DBQueryLastCalled is when each registered statement last ran
+var DBQueryLastCalled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: namespace,
+	Name:      "db_query_last_called_timestamp_seconds",
+	Help:      "Unix time the database statement of each registered query name last ran.",
+}, []string{"query"})
+
+// DBExplainSamples counts the sampled reads explained by registered name
+// and result, see package explain. The explains are not counted in
+// DBQueries.
+var DBExplainSamples = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "db_explain_samples_total",
+	Help:      "Number of sampled reads explained by registered query name and result.",
+}, []string{"query", "result"})
+
+// Handler returns an HTTP handler exposing metrics in Prometheus format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// HTTPPanics counts the panics of HTTP handlers by route and result:
+// recovered when a 500 could still be sent, aborted when the response had
+// started and its connection was aborted
+var HTTPPanics = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "http_panics_total",
+	Help:      "Number of panics of HTTP handlers by route and result.",
+}, []string{"route", "result"})
@@ -0,0 +1,169 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Outcome classifies a request for SLO tracking. Only server errors are
+// faults of the service, the other outcomes do not burn the error budget.
+type Outcome string
+
+const (
+	OutcomeSuccess     Outcome = "success"
+	OutcomeClientError Outcome = "client_error"
+	OutcomeServerError Outcome = "server_error"
+	// OutcomeCancelled is a request abandoned by its client, whose outcome is unknown
+	OutcomeCancelled Outcome = "cancelled"
+)
+
+// Window of the availability ratio
+const (
+	AvailabilityWindow = 5 * time.Minute
+	availabilityBucket = 10 * time.Second
+)
+
+// SLORequests counts requests by outcome. Requests outside the SLO, like
+// admin routes and retries, are counted with slo_eligible="false".
+var SLORequests = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "slo_requests_total",
+	Help:      "Number of requests by transport, route, outcome and whether they count towards the SLO.",
+}, []string{"transport", "route", "outcome", "slo_eligible"})
+
+// Availability is the ratio of eligible requests answered without a server
+// error over the last AvailabilityWindow, by route. Cancelled requests are
+// left out.
+var Availability = NewAvailabilityRatio(AvailabilityWindow, availabilityBucket, time.Now)
+
+func init() {
+	prometheus.MustRegister(Availability)
+}
+
+// ObserveRequest records the outcome of a request in SLORequests and, when
+// it is eligible, in Availability
+func ObserveRequest(transport, route string, outcome Outcome, eligible bool) {
+	SLORequests.WithLabelValues(transport, route, string(outcome), strconv.FormatBool(eligible)).Inc()
+	if eligible && outcome != OutcomeCancelled {
+		Availability.Observe(transport, route, outcome != OutcomeServerError)
+	}
+}
+
+type availabilityKey struct {
+	transport, route string
+}
+
+// availabilityCounts are the requests of a route in the buckets of a window.
+// epochs holds the bucket number each slot counts, stale slots are reset on use.
+type availabilityCounts struct {
+	epochs []int64
+	good   []uint64
+	total  []uint64
+}
+
+// AvailabilityRatio is a per-route ratio of good requests over a sliding
+// window, computed when scraped so that dashboards need no range queries
+type AvailabilityRatio struct {
+	mu     sync.Mutex
+	bucket time.Duration
+	slots  int
+	now    func() time.Time
+	routes map[availabilityKey]*availabilityCounts
+	desc   *prometheus.Desc
+}
+
+// NewAvailabilityRatio creates a ratio over window, advancing by bucket
+func NewAvailabilityRatio(window, bucket time.Duration, now func() time.Time) *AvailabilityRatio {
+	return &AvailabilityRatio{
+		bucket: bucket,
+		slots:  int(window / bucket),
+		now:    now,
+		routes: make(map[availabilityKey]*availabilityCounts),
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "slo_availability_ratio"),
+			"Ratio of SLO eligible requests answered without a server error over the last "+window.String()+" by route.",
+			[]string{"transport", "route"}, nil,
+		),
+	}
+}
+
+// Observe counts a request of the route, good unless it was a server error
+func (a *AvailabilityRatio) Observe(transport, route string, good bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := availabilityKey{transport, route}
+	c, ok := a.routes[key]
+	if !ok {
+		c = &availabilityCounts{
+			epochs: make([]int64, a.slots),
+			good:   make([]uint64, a.slots),
+			total:  make([]uint64, a.slots),
+		}
+		a.routes[key] = c
+	}
+
+	epoch := a.epoch()
+	slot := int(epoch % int64(a.slots))
+	if c.epochs[slot] != epoch {
+		c.epochs[slot], c.good[slot], c.total[slot] = epoch, 0, 0
+	}
+	c.total[slot]++
+	if good {
+		c.good[slot]++
+	}
+}
+
+// Ratio returns the ratio of the route, ok is false without requests in the window
+func (a *AvailabilityRatio) Ratio(transport, route string) (ratio float64, ok bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	c, found := a.routes[availabilityKey{transport, route}]
+	if !found {
+		return 0, false
+	}
+	return a.ratio(c, a.epoch())
+}
+
+// ratio sums the slots of the window ending at epoch, a.mu must be held
+func (a *AvailabilityRatio) ratio(c *availabilityCounts, epoch int64) (float64, bool) {
+	var good, total uint64
+	for i := range c.epochs {
+		if epoch-c.epochs[i] < int64(a.slots) {
+			good += c.good[i]
+			total += c.total[i]
+		}
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(good) / float64(total), true
+}
+
+func (a *AvailabilityRatio) epoch() int64 {
+	return a.now().UnixNano() / int64(a.bucket)
+}
+
+// Describe implements prometheus.Collector
+func (a *AvailabilityRatio) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.desc
+}
+
+// Collect implements prometheus.Collector. Routes without requests in the
+// window are left out rather than reported as unavailable.
+func (a *AvailabilityRatio) Collect(ch chan<- prometheus.Metric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	epoch := a.epoch()
+	for key, c := range a.routes {
+		if ratio, ok := a.ratio(c, epoch); ok {
+			ch <- prometheus.MustNewConstMetric(a.desc, prometheus.GaugeValue, ratio, key.transport, key.route)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRatio returns a one minute ratio over 10s buckets and a function
+// advancing its clock
+func newTestRatio() (*AvailabilityRatio, func(time.Duration)) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ratio := NewAvailabilityRatio(time.Minute, 10*time.Second, func() time.Time { return now })
+	return ratio, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestAvailabilityRatio(t *testing.T) {
+	// Arrange
+	ratio, _ := newTestRatio()
+	for i := 0; i < 3; i++ {
+		ratio.Observe("http", "GET /api/v1/users", true)
+	}
+	ratio.Observe("http", "GET /api/v1/users", false)
+
+	// Act
+	got, ok := ratio.Ratio("http", "GET /api/v1/users")
+
+	// Assert
+	require.True(t, ok)
+	assert.InDelta(t, 0.75, got, 1e-9)
+}
+
+func TestAvailabilityRatio_SlidingWindow(t *testing.T) {
+	// Arrange
+	ratio, advance := newTestRatio()
+	ratio.Observe("grpc", "/carch.user.v1.UserService/GetUser", false)
+	advance(30 * time.Second)
+	ratio.Observe("grpc", "/carch.user.v1.UserService/GetUser", true)
+
+	// Act & Assert
+	got, ok := ratio.Ratio("grpc", "/carch.user.v1.UserService/GetUser")
+	require.True(t, ok)
+	assert.InDelta(t, 0.5, got, 1e-9)
+
+	advance(40 * time.Second)
+	got, ok = ratio.Ratio("grpc", "/carch.user.v1.UserService/GetUser")
+	require.True(t, ok)
+	assert.InDelta(t, 1.0, got, 1e-9, "the failure left the window")
+
+	advance(time.Minute)
+	_, ok = ratio.Ratio("grpc", "/carch.user.v1.UserService/GetUser")
+	assert.False(t, ok, "no requests are left in the window")
+}
+
+func TestAvailabilityRatio_Collect(t *testing.T) {
+	// Arrange
+	ratio, advance := newTestRatio()
+	ratio.Observe("http", "GET /api/v1/users", true)
+	advance(2 * time.Minute)
+	ratio.Observe("http", "POST /api/v1/users", false)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(ratio)
+
+	// Act
+	count, err := testutil.GatherAndCount(registry, "carch_slo_availability_ratio")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "routes without requests in the window are not reported")
+	_, ok := ratio.Ratio("http", "GET /api/v1/users")
+	assert.False(t, ok)
+}
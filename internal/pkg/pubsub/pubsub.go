@@ -0,0 +1,77 @@
+// Package pubsub is a lightweight in-process broker signalling that a topic
+// changed. Subscribers re-read the state themselves, so signals coalesce.
+package pubsub
+
+import "sync"
+
+type subscription struct {
+	ch chan struct{}
+}
+
+// Broker fans change signals out to the subscribers of a topic
+type Broker struct {
+	mu   sync.Mutex
+	subs map[string]map[*subscription]struct{}
+}
+
+func New() *Broker {
+	return &Broker{
+		subs: make(map[string]map[*subscription]struct{}),
+	}
+}
+
+// Subscribe returns a channel signalled on every publish to topic and a
+// function releasing the subscription. Signals published while the previous
+// one is still pending are merged into it.
+func (b *Broker) Subscribe(topic string) (<-chan struct{}, func()) {
+	sub := &subscription{ch: make(chan struct{}, 1)}
+
+	b.mu.Lock()
+	if b.subs[topic] == nil {
+		b.subs[topic] = make(map[*subscription]struct{})
+	}
+	b.subs[topic][sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+
+			delete(b.subs[topic], sub)
+			if len(b.subs[topic]) == 0 {
+				delete(b.subs, topic)
+			}
+		})
+	}
+}
+
+// Publish signals the subscribers of topic without blocking
+func (b *Broker) Publish(topic string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs[topic] {
+		notify(sub)
+	}
+}
+
+// PublishAll signals every subscriber, e.g. after notifications may have been lost
+func (b *Broker) PublishAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, subs := range b.subs {
+		for sub := range subs {
+			notify(sub)
+		}
+	}
+}
+
+func notify(sub *subscription) {
+	select {
+	case sub.ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,65 @@
+package querybudget
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// ErrBudgetExceeded is returned for statements issued past the budget in strict mode
+var ErrBudgetExceeded = errors.New("database statement budget exceeded")
+
+type contextKey struct{}
+
+// Budget counts database statements issued while serving a single request
+type Budget struct {
+	limit  int64
+	strict bool
+	count  atomic.Int64
+}
+
+// WithBudget attaches a new statement counter to the context.
+// A limit of zero or less disables the budget check but still counts statements.
+func WithBudget(ctx context.Context, limit int, strict bool) (context.Context, *Budget) {
+	b := &Budget{
+		limit:  int64(limit),
+		strict: strict,
+	}
+	return context.WithValue(ctx, contextKey{}, b), b
+}
+
+// FromContext returns the budget attached to the context or nil
+func FromContext(ctx context.Context) *Budget {
+	b, _ := ctx.Value(contextKey{}).(*Budget)
+	return b
+}
+
+// Inc records one statement for the request carried by ctx.
+// In strict mode it returns ErrBudgetExceeded once the limit is passed.
+func Inc(ctx context.Context) error {
+	b := FromContext(ctx)
+	if b == nil {
+		return nil
+	}
+
+	n := b.count.Add(1)
+	if b.strict && b.limit > 0 && n > b.limit {
+		return ErrBudgetExceeded
+	}
+	return nil
+}
+
+// Count returns the number of statements recorded so far
+func (b *Budget) Count() int {
+	return int(b.count.Load())
+}
+
+// Limit returns the configured budget
+func (b *Budget) Limit() int {
+	return int(b.limit)
+}
+
+// Exceeded reports whether the request issued more statements than allowed
+func (b *Budget) Exceeded() bool {
+	return b.limit > 0 && b.count.Load() > b.limit
+}
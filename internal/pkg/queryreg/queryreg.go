@@ -0,0 +1,167 @@
+// Package queryreg names the SQL statements of the repositories and counts
+// how often each one runs, so that statements no code path uses anymore,
+// and the schema they alone depend on, can be found.
+//
+// A registered statement starts with a comment holding its name, such as
+// "/* users.get_by_id */ SELECT ...". The name survives the concatenation
+// and formatting of dynamic statements, and shows up in the database's own
+// logs and statistics as well.
+package queryreg
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Unregistered is the name statements without a registered name are counted under
+const Unregistered = "unregistered"
+
+// Query is a registered statement
+type Query struct {
+	Name string
+	// Origin is the function issuing the statement, such as
+	// "repository.(*UserRepository).GetByID"
+	Origin string
+}
+
+// Usage is how often a registered statement ran since the registry was created
+type Usage struct {
+	Name         string     `json:"name"`
+	Origin       string     `json:"origin"`
+	Calls        uint64     `json:"calls"`
+	LastCalledAt *time.Time `json:"last_called_at,omitempty"`
+}
+
+type usage struct {
+	Query
+	calls uint64
+	last  time.Time
+}
+
+// Registry holds the registered statements and their usage
+type Registry struct {
+	startedAt time.Time
+
+	mu      sync.Mutex
+	queries map[string]*usage
+}
+
+// New creates an empty registry, started now
+func New() *Registry {
+	return &Registry{
+		startedAt: time.Now(),
+		queries:   map[string]*usage{},
+	}
+}
+
+// Default is the registry of the process
+var Default = New()
+
+// Register records a statement under name and returns it prefixed with the
+// comment naming it. Statements are registered at package initialization,
+// a name registered twice is a programming error and panics.
+func (r *Registry) Register(name, origin, sql string) string {
+	if name == "" || strings.Contains(name, "*/") {
+		panic(fmt.Sprintf("queryreg: invalid query name %q", name))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if q, ok := r.queries[name]; ok {
+		panic(fmt.Sprintf("queryreg: query %q registered by %s and %s", name, q.Origin, origin))
+	}
+	r.queries[name] = &usage{Query: Query{Name: name, Origin: origin}}
+
+	return "/* " + name + " */" + sql
+}
+
+// Observe counts one run of the statement at now and returns its name, or
+// Unregistered for a statement that does not start with a registered name
+func (r *Registry) Observe(statement string, now time.Time) string {
+	name, ok := Name(statement)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	q := r.queries[name]
+	if !ok || q == nil {
+		return Unregistered
+	}
+	q.calls++
+	q.last = now
+	return name
+}
+
+// StartedAt returns when the registry started counting
+func (r *Registry) StartedAt() time.Time {
+	return r.startedAt
+}
+
+// Queries returns the registered statements sorted by name
+func (r *Registry) Queries() []Query {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queries := make([]Query, 0, len(r.queries))
+	for _, q := range r.queries {
+		queries = append(queries, q.Query)
+	}
+	slices.SortFunc(queries, func(a, b Query) int { return strings.Compare(a.Name, b.Name) })
+	return queries
+}
+
+// Report returns the usage of every registered statement sorted by name
+func (r *Registry) Report() []Usage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report := make([]Usage, 0, len(r.queries))
+	for _, q := range r.queries {
+		u := Usage{Name: q.Name, Origin: q.Origin, Calls: q.calls}
+		if q.calls > 0 {
+			last := q.last
+			u.LastCalledAt = &last
+		}
+		report = append(report, u)
+	}
+	slices.SortFunc(report, func(a, b Usage) int { return strings.Compare(a.Name, b.Name) })
+	return report
+}
+
+// Unused returns the registered statements that never ran, sorted by name
+func (r *Registry) Unused() []Query {
+	var unused []Query
+	for _, u := range r.Report() {
+		if u.Calls == 0 {
+			unused = append(unused, Query{Name: u.Name, Origin: u.Origin})
+		}
+	}
+	return unused
+}
+
+// Name returns the name a registered statement starts with
+func Name(statement string) (string, bool) {
+	rest, ok := strings.CutPrefix(statement, "/* ")
+	if !ok {
+		return "", false
+	}
+	name, _, ok := strings.Cut(rest, " */")
+	if !ok {
+		return "", false
+	}
+	return name, true
+}
+
+// Register records a statement in the Default registry, see Registry.Register
+func Register(name, origin, sql string) string {
+	return Default.Register(name, origin, sql)
+}
+
+// Observe counts a run of the statement in the Default registry, see Registry.Observe
+func Observe(statement string) string {
+	return Default.Observe(statement, time.Now())
+}
@@ -0,0 +1,71 @@
+package queryreg
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistry_Observe(t *testing.T) {
+	// Arrange
+	r := New()
+	get := r.Register("users.get", "repository.(*UserRepository).GetByID", `SELECT * FROM users WHERE id = $1`)
+	list := r.Register("users.list", "repository.(*UserRepository).List", `SELECT * FROM users WHERE `)
+	partition := r.Register("audit.create_partition", "repository.(*AuditRepository).CreatePartition", `CREATE TABLE %s`)
+	r.Register("users.unused", "repository.(*UserRepository).Unused", `SELECT 1`)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// Act
+	names := []string{
+		r.Observe(get, now),
+		r.Observe(get, now.Add(time.Minute)),
+		r.Observe(list+"deleted_at IS NULL ORDER BY id", now),
+		r.Observe(fmt.Sprintf(partition, "audit_log_2024_03"), now),
+		r.Observe(`SELECT 1`, now),
+		r.Observe(`/* users.unknown */ SELECT 1`, now),
+	}
+
+	// Assert
+	assert.Equal(t, []string{"users.get", "users.get", "users.list", "audit.create_partition", Unregistered, Unregistered}, names)
+
+	report := r.Report()
+	require.Len(t, report, 4)
+	assert.Equal(t, "audit.create_partition", report[0].Name)
+	assert.Equal(t, "users.get", report[1].Name)
+	assert.Equal(t, uint64(2), report[1].Calls)
+	assert.Equal(t, now.Add(time.Minute), *report[1].LastCalledAt)
+	assert.Equal(t, "users.unused", report[3].Name)
+	assert.Nil(t, report[3].LastCalledAt)
+
+	assert.Equal(t, []Query{{Name: "users.unused", Origin: "repository.(*UserRepository).Unused"}}, r.Unused())
+}
+
+func TestRegistry_Register_RejectsDuplicates(t *testing.T) {
+	r := New()
+	r.Register("users.get", "a", `SELECT 1`)
+
+	assert.Panics(t, func() { r.Register("users.get", "b", `SELECT 2`) })
+	assert.Panics(t, func() { r.Register("", "c", `SELECT 3`) })
+}
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		statement string
+		want      string
+		wantOK    bool
+	}{
+		{statement: "/* users.get */\n\t\tSELECT 1", want: "users.get", wantOK: true},
+		{statement: "SELECT 1 /* users.get */"},
+		{statement: "/* users.get"},
+	}
+
+	for _, tt := range tests {
+		got, ok := Name(tt.statement)
+
+		assert.Equal(t, tt.want, got, tt.statement)
+		assert.Equal(t, tt.wantOK, ok, tt.statement)
+	}
+}
@@ -0,0 +1,275 @@
+// Package staging keeps uploads on disk until a job processes them, so that
+// large files are neither held in memory nor tie up the request that
+// brought them. Files are capped in size, the directory in total usage, and
+// every file is checksummed when written and verified when opened.
+package staging
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// partialSuffix marks files still being written, renamed once complete
+const partialSuffix = ".part"
+
+// bufferSize bounds the memory an upload is copied through
+const bufferSize = 64 << 10
+
+var (
+	// ErrTooLarge is returned for uploads larger than the size limit
+	ErrTooLarge = errors.New("staged file too large")
+	// ErrFull is returned when the directory has no room left for an upload
+	ErrFull = errors.New("staging is full")
+	// ErrChecksumMismatch is returned when a staged file changed on disk
+	ErrChecksumMismatch = errors.New("staged file checksum mismatch")
+)
+
+// File is a complete staged file
+type File struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+	// Checksum is the hex SHA-256 of the content
+	Checksum string `json:"checksum"`
+}
+
+// Entry is a file of the directory, complete or still being written
+type Entry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	// Partial is set for uploads that were never completed
+	Partial bool
+}
+
+// Store stages files in a directory
+type Store struct {
+	dir     string
+	maxSize int64
+	maxDisk int64
+
+	mu sync.Mutex
+	// reserved is the room held by the uploads of this process in progress
+	reserved int64
+}
+
+// New stages files of up to maxSize bytes in dir, which is created on the
+// first upload, keeping the directory under maxDisk bytes. A limit of 0
+// lifts it.
+func New(dir string, maxSize, maxDisk int64) *Store {
+	return &Store{dir: dir, maxSize: maxSize, maxDisk: maxDisk}
+}
+
+// Dir returns the directory files are staged in
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Stage writes r to the file called name. The upload holds the room it may
+// use while it is written, so that concurrent uploads cannot fill the
+// directory past its limit together. A failed upload leaves nothing behind.
+func (s *Store) Stage(name string, r io.Reader) (*File, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return nil, fmt.Errorf("staging: %w", err)
+	}
+
+	limit, full, err := s.reserve()
+	if err != nil {
+		return nil, err
+	}
+	defer s.release(limit)
+	tooLarge := ErrTooLarge
+	if full {
+		tooLarge = ErrFull
+	}
+
+	partial := filepath.Join(s.dir, name+partialSuffix)
+	f, err := os.OpenFile(partial, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("staging: %w", err)
+	}
+	staged, err := copyLimited(f, r, limit, tooLarge)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("staging: %w", closeErr)
+	}
+	if err == nil {
+		err = os.Rename(partial, filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		_ = os.Remove(partial)
+		return nil, err
+	}
+
+	staged.Name = name
+	return staged, nil
+}
+
+// copyLimited copies r to w, hashing it, and fails with tooLarge past limit
+// bytes, a limit of 0 being none
+func copyLimited(w io.Writer, r io.Reader, limit int64, tooLarge error) (*File, error) {
+	hash := sha256.New()
+	src := r
+	if limit > 0 {
+		// One byte more tells a file of exactly limit bytes from a larger one
+		src = io.LimitReader(r, limit+1)
+	}
+
+	buffered := bufio.NewWriterSize(io.MultiWriter(w, hash), bufferSize)
+	n, err := io.CopyBuffer(buffered, src, make([]byte, bufferSize))
+	if err != nil {
+		return nil, fmt.Errorf("staging: %w", err)
+	}
+	if limit > 0 && n > limit {
+		return nil, tooLarge
+	}
+	if err := buffered.Flush(); err != nil {
+		return nil, fmt.Errorf("staging: %w", err)
+	}
+	return &File{Size: n, Checksum: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// reserve holds the room of an upload: the size limit, or what is left of
+// the directory when that is less, which is reported by full
+func (s *Store) reserve() (limit int64, full bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.maxDisk <= 0 {
+		return s.maxSize, false, nil
+	}
+	used, err := s.usage(false)
+	if err != nil {
+		return 0, false, err
+	}
+	free := s.maxDisk - used - s.reserved
+	if free <= 0 {
+		return 0, true, ErrFull
+	}
+	if s.maxSize > 0 && s.maxSize <= free {
+		s.reserved += s.maxSize
+		return s.maxSize, false, nil
+	}
+	s.reserved += free
+	return free, true, nil
+}
+
+func (s *Store) release(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reserved -= n
+}
+
+// Open opens a staged file after checking it is the one that was staged
+func (s *Store) Open(f File) (*os.File, error) {
+	if err := checkName(f.Name); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(s.dir, f.Name))
+	if err != nil {
+		return nil, fmt.Errorf("staging: %w", err)
+	}
+
+	hash := sha256.New()
+	n, err := io.CopyBuffer(hash, file, make([]byte, bufferSize))
+	if err == nil && (n != f.Size || hex.EncodeToString(hash.Sum(nil)) != f.Checksum) {
+		err = fmt.Errorf("%w: %s", ErrChecksumMismatch, f.Name)
+	}
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Remove deletes a staged file, which may already be gone
+func (s *Store) Remove(name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("staging: %w", err)
+	}
+	return nil
+}
+
+// RemovePartial deletes an upload that was never completed, which another
+// process may be writing: callers only remove old ones
+func (s *Store) RemovePartial(name string) error {
+	if err := os.Remove(filepath.Join(s.dir, name+partialSuffix)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("staging: %w", err)
+	}
+	return nil
+}
+
+// List returns the files of the directory, none when it does not exist yet
+func (s *Store) List() ([]Entry, error) {
+	dirEntries, err := os.ReadDir(s.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("staging: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(dirEntries))
+	for _, e := range dirEntries {
+		if !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// Renamed or removed since the directory was read
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("staging: %w", err)
+		}
+		name, partial := strings.CutSuffix(e.Name(), partialSuffix)
+		entries = append(entries, Entry{Name: name, Size: info.Size(), ModTime: info.ModTime(), Partial: partial})
+	}
+	return entries, nil
+}
+
+// Usage returns the bytes the files of the directory take
+func (s *Store) Usage() (int64, error) {
+	return s.usage(true)
+}
+
+// usage sums the sizes of the files, uploads in progress only when partial
+// is set: those of this process are reserved instead
+func (s *Store) usage(partial bool) (int64, error) {
+	entries, err := s.List()
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, e := range entries {
+		if partial || !e.Partial {
+			total += e.Size
+		}
+	}
+	return total, nil
+}
+
+// checkName keeps files inside the directory
+func checkName(name string) error {
+	if name == "" || name != filepath.Base(name) || strings.HasPrefix(name, ".") || strings.HasSuffix(name, partialSuffix) {
+		return fmt.Errorf("staging: invalid file name %q", name)
+	}
+	return nil
+}
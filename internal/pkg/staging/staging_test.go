@@ -0,0 +1,136 @@
+package staging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore_StageOpen(t *testing.T) {
+	// Arrange
+	store := New(filepath.Join(t.TempDir(), "imports"), 1024, 0)
+
+	// Act
+	staged, err := store.Stage("job-1", strings.NewReader("email,password\n"))
+	require.NoError(t, err)
+	f, err := store.Open(*staged)
+	require.NoError(t, err)
+	defer f.Close()
+	content, err := io.ReadAll(f)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "email,password\n", string(content))
+	sum := sha256.Sum256([]byte("email,password\n"))
+	assert.Equal(t, &File{Name: "job-1", Size: 15, Checksum: hex.EncodeToString(sum[:])}, staged)
+}
+
+func TestStore_Stage_TooLarge(t *testing.T) {
+	// Arrange
+	store := New(t.TempDir(), 10, 0)
+
+	// Act
+	_, exact := store.Stage("exact", strings.NewReader(strings.Repeat("x", 10)))
+	_, err := store.Stage("larger", strings.NewReader(strings.Repeat("x", 11)))
+
+	// Assert
+	assert.NoError(t, exact)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	entries, listErr := store.List()
+	require.NoError(t, listErr)
+	require.Len(t, entries, 1, "the failed upload left nothing behind")
+	assert.Equal(t, "exact", entries[0].Name)
+}
+
+func TestStore_Stage_Full(t *testing.T) {
+	// Arrange
+	store := New(t.TempDir(), 10, 25)
+	_, err := store.Stage("first", strings.NewReader(strings.Repeat("x", 10)))
+	require.NoError(t, err)
+
+	// Act: an upload in progress holds its room
+	blocked, resume := io.Pipe()
+	staged := make(chan error)
+	go func() {
+		_, err := store.Stage("second", blocked)
+		staged <- err
+	}()
+	_, _ = resume.Write([]byte("x"))
+	_, third := store.Stage("third", strings.NewReader(strings.Repeat("x", 6)))
+	resume.Close()
+
+	// Assert
+	assert.ErrorIs(t, third, ErrFull, "only 5 bytes are left with the second upload running")
+	assert.NoError(t, <-staged)
+	usage, err := store.Usage()
+	require.NoError(t, err)
+	assert.Equal(t, int64(11), usage)
+}
+
+func TestStore_Open_ChecksumMismatch(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	store := New(dir, 0, 0)
+	staged, err := store.Stage("job-1", strings.NewReader("email\nada@example.com\n"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "job-1"), []byte("email\neve@example.com\n"), 0o600))
+
+	// Act
+	_, err = store.Open(*staged)
+
+	// Assert
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestStore_ListRemove(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	store := New(dir, 0, 0)
+	_, err := store.Stage("job-1", strings.NewReader("content"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "job-2"+partialSuffix), []byte("cont"), 0o600))
+
+	// Act
+	entries, err := store.List()
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "job-1", entries[0].Name)
+	assert.False(t, entries[0].Partial)
+	assert.Equal(t, "job-2", entries[1].Name)
+	assert.True(t, entries[1].Partial)
+
+	require.NoError(t, store.Remove("job-1"))
+	require.NoError(t, store.Remove("job-1"), "removing twice is fine")
+	require.NoError(t, store.RemovePartial("job-2"))
+	entries, err = store.List()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestStore_InvalidName(t *testing.T) {
+	store := New(t.TempDir(), 0, 0)
+
+	for _, name := range []string{"", "../job", "a/b", ".hidden", "job" + partialSuffix} {
+		_, err := store.Stage(name, strings.NewReader("x"))
+		assert.Error(t, err, name)
+		assert.Error(t, store.Remove(name), name)
+	}
+}
+
+func TestStore_List_MissingDirectory(t *testing.T) {
+	store := New(filepath.Join(t.TempDir(), "missing"), 0, 0)
+
+	entries, err := store.List()
+
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,29 @@
+// Package tenant carries the tenant a request acts for, which events it
+// produces are routed by
+package tenant
+
+import "context"
+
+// Header names the tenant of an API request
+const Header = "X-Tenant-ID"
+
+// maxLength bounds a tenant to what the outbox stores
+const maxLength = 255
+
+type contextKey struct{}
+
+// WithTenant returns a context acting for the tenant
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenant)
+}
+
+// FromContext returns the tenant of the context, empty when there is none
+func FromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(contextKey{}).(string)
+	return tenant
+}
+
+// Valid reports whether the tenant can be stored and routed
+func Valid(tenant string) bool {
+	return tenant != "" && len(tenant) <= maxLength
+}
@@ -0,0 +1,131 @@
+package timing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// maxPhases bounds the phases kept per request, later ones are only counted
+const maxPhases = 256
+
+type contextKey struct{}
+
+// Phase is a timed step of a request, such as a database statement
+type Phase struct {
+	Name string
+	// Detail identifies the step, e.g. the statement, and may be empty
+	Detail string
+	// Offset is when the phase started relative to the request
+	Offset   time.Duration
+	Duration time.Duration
+}
+
+// Collector gathers the phases of a single request
+type Collector struct {
+	start time.Time
+
+	mu      sync.Mutex
+	phases  []Phase
+	dropped int
+}
+
+// WithCollector attaches a new collector to the context. The phase list is
+// only allocated once the first phase is recorded.
+func WithCollector(ctx context.Context) (context.Context, *Collector) {
+	c := &Collector{start: time.Now()}
+	return context.WithValue(ctx, contextKey{}, c), c
+}
+
+// FromContext returns the collector attached to the context or nil
+func FromContext(ctx context.Context) *Collector {
+	c, _ := ctx.Value(contextKey{}).(*Collector)
+	return c
+}
+
+// Span is a phase in progress
+type Span struct {
+	c      *Collector
+	name   string
+	detail string
+	start  time.Time
+}
+
+// Start begins a phase of the request carried by ctx. Without a collector
+// the returned span does nothing and reading the clock is skipped.
+func Start(ctx context.Context, name, detail string) Span {
+	c := FromContext(ctx)
+	if c == nil {
+		return Span{}
+	}
+	return Span{c: c, name: name, detail: detail, start: time.Now()}
+}
+
+// End records the phase
+func (s Span) End() {
+	if s.c == nil {
+		return
+	}
+	s.c.record(Phase{
+		Name:     s.name,
+		Detail:   s.detail,
+		Offset:   s.start.Sub(s.c.start),
+		Duration: time.Since(s.start),
+	})
+}
+
+func (c *Collector) record(p Phase) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.phases) >= maxPhases {
+		c.dropped++
+		return
+	}
+	c.phases = append(c.phases, p)
+}
+
+// Elapsed returns the time since the collector was attached
+func (c *Collector) Elapsed() time.Duration {
+	return time.Since(c.start)
+}
+
+// Phases returns the recorded phases in the order they ended and how many
+// were dropped past the limit
+func (c *Collector) Phases() ([]Phase, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Phase(nil), c.phases...), c.dropped
+}
+
+// Total is the combined time of the phases sharing a name
+type Total struct {
+	Count    int
+	Duration time.Duration
+}
+
+// Totals sums the recorded phases by name
+func (c *Collector) Totals() map[string]Total {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	totals := make(map[string]Total)
+	for _, p := range c.phases {
+		t := totals[p.Name]
+		t.Count++
+		t.Duration += p.Duration
+		totals[p.Name] = t
+	}
+	return totals
+}
+
+// Milliseconds returns d in milliseconds, keeping sub-millisecond precision
+// for fast phases
+func Milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
+
+// FromMilliseconds returns the duration of ms milliseconds, as reported by
+// tools measuring in fractional milliseconds
+func FromMilliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
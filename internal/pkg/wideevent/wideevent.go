@@ -0,0 +1,215 @@
+// Package wideevent assembles one structured record per request, a wide
+// event, out of what the request did: its route and status, the time spent
+// per phase, who made it, how much it read and wrote, and the statements,
+// cache lookups and events it caused. Emitted events are written as NDJSON
+// to a sink of their own, apart from the logs.
+package wideevent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/timing"
+)
+
+// Counters code paths add to, see Add
+const (
+	DBStatements    = "db_statements"
+	CacheHits       = "cache_hits"
+	CacheMisses     = "cache_misses"
+	EventsPublished = "events_published"
+)
+
+// Fields are the names of the fields of an emitted event, in their order
+var Fields = []string{
+	"time", "transport", "method", "route", "status", "outcome", "duration_ms", "phases",
+	"user", "tenant", "bytes_in", "bytes_out",
+	DBStatements, CacheHits, CacheMisses, EventsPublished, "sample_rate",
+}
+
+type contextKey struct{}
+
+// Record gathers the counters of a single request
+type Record struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// WithRecord attaches a new record to the context
+func WithRecord(ctx context.Context) (context.Context, *Record) {
+	r := &Record{counts: map[string]int{}}
+	return context.WithValue(ctx, contextKey{}, r), r
+}
+
+// FromContext returns the record attached to the context or nil
+func FromContext(ctx context.Context) *Record {
+	r, _ := ctx.Value(contextKey{}).(*Record)
+	return r
+}
+
+// Add adds n to the counter of the request carried by ctx. Requests that
+// are not sampled carry no record and are skipped.
+func Add(ctx context.Context, counter string, n int) {
+	r := FromContext(ctx)
+	if r == nil || n == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counts[counter] += n
+}
+
+// Count returns the counter
+func (r *Record) Count(counter string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.counts[counter]
+}
+
+// Phase is the combined time of the same-named phases of a request
+type Phase struct {
+	Count      int     `json:"count"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Event is the wide event of one request
+type Event struct {
+	Time      time.Time
+	Transport string
+	Method    string
+	Route     string
+	// Status is the HTTP status or the gRPC code
+	Status   int
+	Outcome  string
+	Duration time.Duration
+	Phases   map[string]timing.Total
+	// User is the principal the request was made by
+	User     string
+	Tenant   string
+	BytesIn  int64
+	BytesOut int64
+	// Record holds the counters, nil leaves them at 0
+	Record *Record
+}
+
+// Emitter writes sampled wide events to a sink. A nil Emitter emits nothing.
+type Emitter struct {
+	rate   float64
+	fields []string
+	random func() float64
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// Option is a function that configures an Emitter
+type Option func(*Emitter)
+
+// WithSampleRate emits the given fraction of requests, all by default. The
+// rate is written with every event so that counts can be scaled back up.
+func WithSampleRate(rate float64) Option {
+	return func(e *Emitter) {
+		if rate > 0 && rate <= 1 {
+			e.rate = rate
+		}
+	}
+}
+
+// WithFields limits the fields written to the given ones, see CheckFields
+func WithFields(names ...string) Option {
+	return func(e *Emitter) {
+		if len(names) > 0 {
+			e.fields = names
+		}
+	}
+}
+
+// NewEmitter creates an emitter writing one JSON object per line to w
+func NewEmitter(w io.Writer, opts ...Option) *Emitter {
+	e := &Emitter{
+		rate:   1,
+		fields: Fields,
+		random: rand.Float64,
+		enc:    json.NewEncoder(w),
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// CheckFields returns an error naming the first name that is not one of Fields
+func CheckFields(names []string) error {
+	for _, name := range names {
+		if !slices.Contains(Fields, name) {
+			return fmt.Errorf("unknown wide event field %q", name)
+		}
+	}
+	return nil
+}
+
+// Sampled reports whether the request about to start is emitted. It is
+// decided up front so that unsampled requests skip collecting.
+func (e *Emitter) Sampled() bool {
+	if e == nil {
+		return false
+	}
+	return e.rate >= 1 || e.random() < e.rate
+}
+
+// Emit writes the event, keeping the allowed fields only
+func (e *Emitter) Emit(ev Event) error {
+	if e == nil {
+		return nil
+	}
+
+	all := ev.fields(e.rate)
+	out := make(map[string]interface{}, len(e.fields))
+	for _, name := range e.fields {
+		out[name] = all[name]
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(out)
+}
+
+// fields returns the event by field name
+func (ev Event) fields(rate float64) map[string]interface{} {
+	phases := make(map[string]Phase, len(ev.Phases))
+	for name, t := range ev.Phases {
+		phases[name] = Phase{Count: t.Count, DurationMs: timing.Milliseconds(t.Duration)}
+	}
+
+	fields := map[string]interface{}{
+		"time":        ev.Time.UTC().Format(time.RFC3339Nano),
+		"transport":   ev.Transport,
+		"method":      ev.Method,
+		"route":       ev.Route,
+		"status":      ev.Status,
+		"outcome":     ev.Outcome,
+		"duration_ms": timing.Milliseconds(ev.Duration),
+		"phases":      phases,
+		"user":        ev.User,
+		"tenant":      ev.Tenant,
+		"bytes_in":    ev.BytesIn,
+		"bytes_out":   ev.BytesOut,
+		"sample_rate": rate,
+	}
+	for _, counter := range []string{DBStatements, CacheHits, CacheMisses, EventsPublished} {
+		n := 0
+		if ev.Record != nil {
+			n = ev.Record.Count(counter)
+		}
+		fields[counter] = n
+	}
+	return fields
+}
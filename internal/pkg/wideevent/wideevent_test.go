@@ -0,0 +1,87 @@
+package wideevent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/timing"
+)
+
+func TestEmitter_Emit(t *testing.T) {
+	// Arrange
+	var sink bytes.Buffer
+	e := NewEmitter(&sink, WithFields("route", "duration_ms", "phases", DBStatements, EventsPublished))
+	ctx, record := WithRecord(context.Background())
+	Add(ctx, DBStatements, 3)
+	Add(ctx, EventsPublished, 1)
+	Add(ctx, EventsPublished, 1)
+
+	// Act
+	err := e.Emit(Event{
+		Time:     time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC),
+		Route:    "GET /api/v1/users/{id}",
+		Duration: 1500 * time.Microsecond,
+		Phases:   map[string]timing.Total{"db": {Count: 3, Duration: 250 * time.Microsecond}},
+		User:     "client:203.0.113.7",
+		Record:   record,
+	})
+
+	// Assert
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"route": "GET /api/v1/users/{id}",
+		"duration_ms": 1.5,
+		"phases": {"db": {"count": 3, "duration_ms": 0.25}},
+		"db_statements": 3,
+		"events_published": 2
+	}`, sink.String())
+	assert.Equal(t, byte('\n'), sink.Bytes()[sink.Len()-1])
+}
+
+func TestEmitter_AllFields(t *testing.T) {
+	var sink bytes.Buffer
+	require.NoError(t, NewEmitter(&sink).Emit(Event{}))
+
+	var event map[string]interface{}
+	require.NoError(t, json.Unmarshal(sink.Bytes(), &event))
+	assert.Len(t, event, len(Fields))
+	for _, name := range Fields {
+		assert.Contains(t, event, name)
+	}
+}
+
+func TestEmitter_Sampled(t *testing.T) {
+	draws := []float64{0.05, 0.5, 0.09, 0.99}
+	e := NewEmitter(&bytes.Buffer{}, WithSampleRate(0.1))
+	e.random = func() float64 {
+		draw := draws[0]
+		draws = draws[1:]
+		return draw
+	}
+
+	var sampled []bool
+	for range 4 {
+		sampled = append(sampled, e.Sampled())
+	}
+
+	assert.Equal(t, []bool{true, false, true, false}, sampled)
+	assert.True(t, NewEmitter(&bytes.Buffer{}).Sampled())
+	assert.False(t, (*Emitter)(nil).Sampled())
+	assert.NoError(t, (*Emitter)(nil).Emit(Event{}))
+}
+
+func TestAdd_WithoutRecord(t *testing.T) {
+	assert.NotPanics(t, func() { Add(context.Background(), CacheHits, 1) })
+	assert.Nil(t, FromContext(context.Background()))
+}
+
+func TestCheckFields(t *testing.T) {
+	assert.NoError(t, CheckFields([]string{"route", CacheHits}))
+	assert.EqualError(t, CheckFields([]string{"route", "path"}), `unknown wide event field "path"`)
+}
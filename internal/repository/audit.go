@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+)
+
+// auditPartitionLayout names monthly audit_log partitions, e.g. audit_log_y2024m03
+const auditPartitionLayout = "audit_log_y2006m01"
+
+type AuditRepository struct {
+	db  Querier
+	gen generate.Generators
+}
+
+// NewAuditRepository creates a new audit log repository
+func NewAuditRepository(db Querier, opts ...generate.Option) *AuditRepository {
+	return &AuditRepository{
+		db:  db,
+		gen: generate.New(opts...),
+	}
+}
+
+var auditCreateQuery = registerQuery("audit.create", "(*AuditRepository).Create", `
+		INSERT INTO audit_log (action, entity, entity_id, actor_id, details, created_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6)
+		RETURNING id`)
+
+func (r *AuditRepository) Create(ctx context.Context, entry *domain.AuditEntry) error {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = r.gen.Clock.Now()
+	}
+
+	query := auditCreateQuery
+
+	return r.db.GetContext(ctx, &entry.ID, query,
+		entry.Action, entry.Entity, entry.EntityID, entry.ActorID, entry.Details, entry.CreatedAt)
+}
+
+var auditListQuery = registerQuery("audit.list", "(*AuditRepository).List", `
+		SELECT `)
+
+// List returns the entries matching the filter, newest first. Pages are
+// keyed on (created_at, id), which the indexes of the filters end with.
+// Bounding created_at on both sides lets Postgres prune the partitions
+// outside the range.
+func (r *AuditRepository) List(ctx context.Context, filter domain.AuditFilter) ([]*domain.AuditEntry, error) {
+	columns := "id, action, entity, entity_id, COALESCE(actor_id, '') AS actor_id, created_at"
+	if filter.IncludeDetails {
+		columns += ", details"
+	}
+
+	var (
+		where []string
+		args  []interface{}
+	)
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+	if filter.ActorID != "" {
+		where = append(where, "actor_id = "+arg(filter.ActorID))
+	}
+	if filter.EntityID != "" {
+		where = append(where, "entity_id = "+arg(filter.EntityID))
+	}
+	if filter.Action != "" {
+		where = append(where, "action = "+arg(filter.Action))
+	}
+	if !filter.From.IsZero() {
+		where = append(where, "created_at >= "+arg(filter.From))
+	}
+	if !filter.To.IsZero() {
+		where = append(where, "created_at < "+arg(filter.To))
+	}
+	if filter.After != nil {
+		where = append(where, fmt.Sprintf("(created_at, id) < (%s, %s)", arg(filter.After.CreatedAt), arg(filter.After.ID)))
+	}
+
+	query := auditListQuery + columns + `
+		FROM audit_log`
+	if len(where) > 0 {
+		query += `
+		WHERE ` + strings.Join(where, " AND ")
+	}
+	query += `
+		ORDER BY created_at DESC, id DESC
+		LIMIT ` + arg(filter.Limit)
+
+	var entries []*domain.AuditEntry
+	if err := r.db.SelectContext(ctx, &entries, query, args...); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+var auditListPartitionsQuery = registerQuery("audit.list_partitions", "(*AuditRepository).ListPartitions", `
+		SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'audit_log'`)
+
+func (r *AuditRepository) ListPartitions(ctx context.Context) ([]domain.AuditPartition, error) {
+	var names []string
+	query := auditListPartitionsQuery
+
+	if err := r.db.SelectContext(ctx, &names, query); err != nil {
+		return nil, err
+	}
+
+	partitions := make([]domain.AuditPartition, 0, len(names))
+	for _, name := range names {
+		month, err := time.Parse(auditPartitionLayout, name)
+		if err != nil {
+			// The default partition and anything created by hand
+			continue
+		}
+		partitions = append(partitions, domain.AuditPartition{Name: name, Month: month})
+	}
+
+	sort.Slice(partitions, func(i, j int) bool {
+		return partitions[i].Month.Before(partitions[j].Month)
+	})
+
+	return partitions, nil
+}
+
+var auditPartitionExistsQuery = registerQuery("audit.partition_exists", "(*AuditRepository).CreatePartition", `SELECT to_regclass($1) IS NOT NULL`)
+
+var auditCreatePartitionQuery = registerQuery("audit.create_partition", "(*AuditRepository).CreatePartition", `
+		CREATE TABLE %[1]s (LIKE audit_log INCLUDING DEFAULTS INCLUDING CONSTRAINTS);
+		WITH moved AS (
+			DELETE FROM audit_log_default
+			WHERE created_at >= %[2]s AND created_at < %[3]s
+			RETURNING id, action, entity, entity_id, details, created_at
+		)
+		INSERT INTO %[1]s (id, action, entity, entity_id, details, created_at)
+		SELECT id, action, entity, entity_id, details, created_at FROM moved;
+		ALTER TABLE audit_log ATTACH PARTITION %[1]s FOR VALUES FROM (%[2]s) TO (%[3]s)`)
+
+// CreatePartition creates the partition for month. Rows of that month that
+// already landed in the default partition are moved into it, otherwise
+// Postgres would refuse to attach the new partition.
+func (r *AuditRepository) CreatePartition(ctx context.Context, month time.Time) (domain.AuditPartition, error) {
+	month = domain.AuditMonth(month)
+	partition := domain.AuditPartition{
+		Name:  month.Format(auditPartitionLayout),
+		Month: month,
+	}
+
+	var exists bool
+	if err := r.db.GetContext(ctx, &exists, auditPartitionExistsQuery, partition.Name); err != nil {
+		return partition, err
+	}
+	if exists {
+		return partition, nil
+	}
+
+	// DDL takes no bind parameters; name and bounds are derived from month only.
+	// Without arguments the statements run as one implicit transaction.
+	name := pq.QuoteIdentifier(partition.Name)
+	from := pq.QuoteLiteral(partition.Month.Format(time.RFC3339))
+	to := pq.QuoteLiteral(partition.End().Format(time.RFC3339))
+	query := fmt.Sprintf(auditCreatePartitionQuery, name, from, to)
+
+	_, err := r.db.ExecContext(ctx, query)
+	return partition, err
+}
+
+var auditDropPartitionQuery = registerQuery("audit.drop_partition", "(*AuditRepository).DropPartition", `
+		ALTER TABLE audit_log DETACH PARTITION %[1]s;
+		DROP TABLE %[1]s`)
+
+func (r *AuditRepository) DropPartition(ctx context.Context, partition domain.AuditPartition) error {
+	name := pq.QuoteIdentifier(partition.Name)
+	query := fmt.Sprintf(auditDropPartitionQuery, name)
+
+	_, err := r.db.ExecContext(ctx, query)
+	return err
+}
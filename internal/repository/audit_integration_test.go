@@ -0,0 +1,119 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/repository/repotest"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// Months far in the future so the tests never collide with partitions of the
+// current date. Partitions are created and dropped in the test's
+// transaction, so the retention test leaves the older ones in place.
+var (
+	auditTestMarch = time.Date(2091, time.March, 1, 0, 0, 0, 0, time.UTC)
+	auditTestMay   = time.Date(2091, time.May, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func insertAuditEntry(t *testing.T, db Querier, entityID string, createdAt time.Time) {
+	t.Helper()
+
+	_, err := db.ExecContext(context.Background(), `
+		INSERT INTO audit_log (action, entity, entity_id, created_at)
+		VALUES ('test', 'user', $1, $2)`, entityID, createdAt)
+	require.NoError(t, err)
+}
+
+// partitionOf returns the partition holding the entry
+func partitionOf(t *testing.T, db Querier, entityID string) string {
+	t.Helper()
+
+	var name string
+	require.NoError(t, db.GetContext(context.Background(), &name,
+		`SELECT tableoid::regclass::text FROM audit_log WHERE entity_id = $1`, entityID))
+	return name
+}
+
+func TestAuditPartitions_CreateAndRoute(t *testing.T) {
+	// Arrange
+	db := repotest.Begin(t)
+	repo := NewAuditRepository(db)
+	ctx := context.Background()
+
+	// Written before its month had a partition
+	insertAuditEntry(t, db, "early", auditTestMarch.Add(time.Hour))
+	require.Equal(t, "audit_log_default", partitionOf(t, db, "early"))
+
+	// Act
+	partition, err := repo.CreatePartition(ctx, auditTestMarch)
+	require.NoError(t, err)
+	_, err = repo.CreatePartition(ctx, auditTestMarch)
+	require.NoError(t, err, "creating an existing partition is a no-op")
+
+	insertAuditEntry(t, db, "march", auditTestMarch.AddDate(0, 0, 20))
+	insertAuditEntry(t, db, "may", auditTestMay.Add(time.Hour))
+
+	// Assert
+	assert.Equal(t, "audit_log_y2091m03", partition.Name)
+	assert.Equal(t, "audit_log_y2091m03", partitionOf(t, db, "early"))
+	assert.Equal(t, "audit_log_y2091m03", partitionOf(t, db, "march"))
+	assert.Equal(t, "audit_log_default", partitionOf(t, db, "may"))
+
+	entries, err := repo.List(ctx, domain.AuditFilter{From: auditTestMarch, To: auditTestMarch.AddDate(0, 1, 0), Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "march", entries[0].EntityID)
+	assert.Equal(t, "early", entries[1].EntityID)
+
+	// The range scan only touches the partition of the month
+	var plan []string
+	require.NoError(t, db.SelectContext(ctx, &plan, `EXPLAIN SELECT id FROM audit_log WHERE created_at >= $1 AND created_at < $2`,
+		auditTestMarch, auditTestMarch.AddDate(0, 1, 0)))
+	for _, line := range plan {
+		assert.NotContains(t, line, "audit_log_default")
+	}
+}
+
+func TestAuditPartitions_RetentionDrops(t *testing.T) {
+	// Arrange
+	db := repotest.Begin(t)
+	repo := NewAuditRepository(db)
+	ctx := context.Background()
+
+	_, err := repo.CreatePartition(ctx, auditTestMarch)
+	require.NoError(t, err)
+	insertAuditEntry(t, db, "expired", auditTestMarch.Add(time.Hour))
+
+	// March ends before the cutoff of 2091-04-14, April does not
+	audit := service.NewAuditService(repo, logger.New(), 1, 30*24*time.Hour)
+	now := time.Date(2091, time.May, 14, 0, 0, 0, 0, time.UTC)
+
+	// Act
+	err = audit.MaintainPartitions(ctx, now)
+
+	// Assert
+	require.NoError(t, err)
+
+	partitions, err := repo.ListPartitions(ctx)
+	require.NoError(t, err)
+	var names []string
+	for _, p := range partitions {
+		names = append(names, p.Name)
+	}
+	assert.NotContains(t, names, "audit_log_y2091m03")
+	assert.Contains(t, names, "audit_log_y2091m05")
+	assert.Contains(t, names, "audit_log_y2091m06")
+
+	var remaining int
+	require.NoError(t, db.GetContext(ctx, &remaining, `SELECT count(*) FROM audit_log WHERE entity_id = 'expired'`))
+	assert.Zero(t, remaining)
+}
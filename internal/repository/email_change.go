@@ -0,0 +1,157 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
+)
+
+var emailExistsQuery = registerQuery("users.email_exists", "(*UserRepository).EmailExists", `SELECT EXISTS(SELECT 1 FROM users WHERE email = $1 AND deleted_at IS NULL)`)
+
+// EmailExists reports whether a live user already uses the email
+func (r *UserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	var exists bool
+	query := emailExistsQuery
+
+	err := r.primary(ctx).GetContext(ctx, &exists, query, email)
+	return exists, err
+}
+
+var emailChangeCreateQuery = registerQuery("email_changes.create", "(*UserRepository).CreateEmailChange", `
+		WITH req AS (
+			INSERT INTO email_change_requests (user_id, new_email, token_hash, expires_at, created_at)
+			SELECT $1, $2, $3, $4, $5 FROM users WHERE id = $1 AND deleted_at IS NULL
+			ON CONFLICT (user_id) DO UPDATE
+			SET new_email = EXCLUDED.new_email, token_hash = EXCLUDED.token_hash,
+				expires_at = EXCLUDED.expires_at, created_at = EXCLUDED.created_at
+			RETURNING user_id
+		)`)
+
+// CreateEmailChange stores the pending change, replacing a previous one for the
+// same user, and records the given events in the outbox within the same
+// statement, as the next ones in the sequence of the user's events
+func (r *UserRepository) CreateEmailChange(ctx context.Context, change *domain.EmailChange, events []*domain.OutboxEvent) error {
+	args := []interface{}{
+		change.UserID,
+		change.NewEmail,
+		change.TokenHash,
+		change.ExpiresAt,
+		change.CreatedAt,
+	}
+
+	values := make([]string, 0, len(events))
+	for i, e := range events {
+		e.AggregateID = change.UserID
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d::uuid, $%d, $%d, $%d::jsonb, $%d::jsonb, %d)", n+1, n+2, n+3, n+4, n+5, i+1))
+		args = append(args, e.ID, e.Type, e.Tenant, e.Payload, secret(e))
+	}
+
+	query := emailChangeCreateQuery
+	if len(values) > 0 {
+		// The events are numbered in their order, the last one gets the
+		// last number assigned to the user
+		query += `,` + sequenceEvents(fmt.Sprintf(`SELECT user_id::text, %d, $5::timestamptz FROM req`, len(values))) + `,
+		events AS (
+			INSERT INTO outbox (id, event_type, tenant, payload, secret, created_at, aggregate_id, aggregate_seq)
+			SELECT v.id, v.event_type, v.tenant, v.payload, v.secret, s.created_at, s.aggregate_id, s.seq - ` + fmt.Sprint(len(values)) + ` + v.ord FROM seqs s
+			CROSS JOIN (VALUES ` + strings.Join(values, ", ") + `) AS v(id, event_type, tenant, payload, secret, ord)
+		)`
+	}
+	query += `
+		SELECT COUNT(*) FROM req`
+
+	var created int
+	if err := r.primary(ctx).GetContext(ctx, &created, query, args...); err != nil {
+		return userConstraints.translate(err, r.log)
+	}
+
+	if created == 0 {
+		return domain.ErrUserNotFound
+	}
+
+	wideevent.Add(ctx, wideevent.EventsPublished, len(events))
+	return nil
+}
+
+var emailChangeGetByTokenHashQuery = registerQuery("email_changes.get_by_token_hash", "(*UserRepository).GetEmailChangeByTokenHash", `
+		SELECT user_id, new_email, token_hash, expires_at, created_at
+		FROM email_change_requests
+		WHERE token_hash = $1`)
+
+func (r *UserRepository) GetEmailChangeByTokenHash(ctx context.Context, tokenHash string) (*domain.EmailChange, error) {
+	var change domain.EmailChange
+	query := emailChangeGetByTokenHashQuery
+
+	err := r.primary(ctx).GetContext(ctx, &change, query, tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrEmailChangeNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &change, nil
+}
+
+var emailChangeConfirmQuery = registerQuery("email_changes.confirm", "(*UserRepository).ConfirmEmailChange", `
+		WITH req AS (
+			DELETE FROM email_change_requests
+			WHERE user_id = $1 AND token_hash = $2 AND expires_at > $3
+			RETURNING user_id, new_email
+		)
+		UPDATE users u
+		SET email = req.new_email, updated_at = $3
+		FROM req
+		WHERE u.id = req.user_id AND u.deleted_at IS NULL`)
+
+// ConfirmEmailChange consumes the pending request and applies the new email in
+// a single statement. Uniqueness is enforced by the users email constraint, so
+// a conflict leaves the request in place.
+func (r *UserRepository) ConfirmEmailChange(ctx context.Context, change *domain.EmailChange) error {
+	now := r.gen.Clock.Now()
+	query := emailChangeConfirmQuery
+
+	result, err := r.primary(ctx).ExecContext(ctx, query, change.UserID, change.TokenHash, now)
+	if err != nil {
+		return userConstraints.translate(err, r.log)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return domain.ErrEmailChangeNotFound
+	}
+
+	return nil
+}
+
+var emailChangeCancelQuery = registerQuery("email_changes.cancel", "(*UserRepository).CancelEmailChange", `DELETE FROM email_change_requests WHERE user_id = $1`)
+
+func (r *UserRepository) CancelEmailChange(ctx context.Context, userID string) error {
+	query := emailChangeCancelQuery
+
+	result, err := r.primary(ctx).ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return domain.ErrEmailChangeNotFound
+	}
+
+	return nil
+}
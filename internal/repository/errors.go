@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/lib/pq"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// Postgres error codes, see https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	// pgIntegrityConstraintViolation is the class of constraint violations
+	pgIntegrityConstraintViolation = "23"
+	pgForeignKeyViolation          = "23503"
+	pgUniqueViolation              = "23505"
+	// pgConnectionException is the class of failed or lost connections
+	pgConnectionException = "08"
+	// pgOperatorIntervention is the class of server shutdowns and of
+	// servers not accepting connections yet, as during a failover
+	pgOperatorIntervention = "57"
+	pgQueryCanceled        = "57014"
+)
+
+// constraintErrors maps the constraints of a repository's tables to the
+// domain errors their violations mean
+type constraintErrors map[string]error
+
+// translate returns the domain error of a constraint violation and err
+// itself for other errors, annotated with the stack trace of the
+// repository that got it (see logger.WrapStack). Violations of constraints
+// missing from the map are logged, so the map can be completed, and fall
+// back to a generic error of their kind.
+func (c constraintErrors) translate(err error, log *logger.Logger) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code.Class() != pgIntegrityConstraintViolation {
+		return logger.WrapStack(err)
+	}
+	if mapped, ok := c[pqErr.Constraint]; ok {
+		return mapped
+	}
+
+	log.Warn("Unmapped constraint violation", map[string]interface{}{
+		"constraint": pqErr.Constraint,
+		"table":      pqErr.Table,
+		"code":       string(pqErr.Code),
+	})
+	switch pqErr.Code {
+	case pgUniqueViolation:
+		return domain.ErrConflict
+	case pgForeignKeyViolation:
+		return domain.ErrInvalidReference
+	default:
+		return logger.WrapStack(err)
+	}
+}
+
+// unavailable reports whether err means the database could not be reached,
+// rather than a failure of the statement itself: the connection was refused,
+// lost or timed out, or the server is shutting down or starting up.
+// Statements canceled by their context are not counted, their caller is gone:
+// ctx is checked first, since the deadline error of a dial or a read is
+// also a net.Error.
+func unavailable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		class := pqErr.Code.Class()
+		return class == pgConnectionException || (class == pgOperatorIntervention && pqErr.Code != pgQueryCanceled)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF)
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+func TestConstraintErrors_Translate(t *testing.T) {
+	connReset := errors.New("connection reset by peer")
+	notNull := &pq.Error{Code: "23502", Table: "users", Column: "name"}
+	serialization := &pq.Error{Code: "40001"}
+
+	tests := []struct {
+		name       string
+		err        error
+		want       error
+		wantLogged string
+		// wantStack is set for the errors passed through, which carry the
+		// stack trace of the repository
+		wantStack bool
+	}{
+		{
+			name: "unique email",
+			err:  &pq.Error{Code: "23505", Constraint: "users_email_live_key", Table: "users"},
+			want: domain.ErrEmailTaken,
+		},
+		{
+			name: "duplicate id",
+			err:  &pq.Error{Code: "23505", Constraint: "users_pkey", Table: "users"},
+			want: domain.ErrConflict,
+		},
+		{
+			name: "foreign key to a missing user",
+			err:  &pq.Error{Code: "23503", Constraint: "email_change_requests_user_id_fkey", Table: "email_change_requests"},
+			want: domain.ErrUserNotFound,
+		},
+		{
+			name: "wrapped",
+			err:  fmt.Errorf("insert: %w", &pq.Error{Code: "23505", Constraint: "users_email_live_key"}),
+			want: domain.ErrEmailTaken,
+		},
+		{
+			name:       "unmapped unique constraint",
+			err:        &pq.Error{Code: "23505", Constraint: "users_avatar_key_key", Table: "users"},
+			want:       domain.ErrConflict,
+			wantLogged: "users_avatar_key_key",
+		},
+		{
+			name:       "unmapped foreign key",
+			err:        &pq.Error{Code: "23503", Constraint: "users_organization_id_fkey", Table: "users"},
+			want:       domain.ErrInvalidReference,
+			wantLogged: "users_organization_id_fkey",
+		},
+		{
+			name:       "other constraint violation",
+			err:        notNull,
+			want:       notNull,
+			wantLogged: "23502",
+			wantStack:  true,
+		},
+		{
+			name:      "not a constraint violation",
+			err:       serialization,
+			want:      serialization,
+			wantStack: true,
+		},
+		{
+			name:      "not a postgres error",
+			err:       connReset,
+			want:      connReset,
+			wantStack: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			var logs bytes.Buffer
+			log := logger.New(logger.WithOutput(&logs))
+
+			// Act
+			err := userConstraints.translate(tt.err, log)
+
+			// Assert
+			assert.ErrorIs(t, err, tt.want)
+			assert.Equal(t, tt.want.Error(), err.Error())
+			var traced interface{ StackTrace() pkgerrors.StackTrace }
+			assert.Equal(t, tt.wantStack, errors.As(err, &traced))
+			if tt.wantLogged == "" {
+				assert.Empty(t, logs.String())
+				return
+			}
+			assert.Contains(t, logs.String(), "Unmapped constraint violation")
+			assert.Contains(t, logs.String(), tt.wantLogged)
+		})
+	}
+}
+
+func TestUnavailable(t *testing.T) {
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool
+	}{
+		{name: "connection refused", err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, want: true},
+		{name: "connection reset", err: fmt.Errorf("get user: %w", syscall.ECONNRESET), want: true},
+		{name: "bad connection", err: driver.ErrBadConn, want: true},
+		{name: "connection done", err: sql.ErrConnDone, want: true},
+		{name: "connection lost mid-response", err: io.ErrUnexpectedEOF, want: true},
+		{name: "connection failure", err: &pq.Error{Code: "08006"}, want: true},
+		{name: "database shutting down", err: &pq.Error{Code: "57P01"}, want: true},
+		{name: "cannot connect now", err: fmt.Errorf("get user: %w", &pq.Error{Code: "57P03"}), want: true},
+		{name: "statement timeout", err: &pq.Error{Code: "57014"}},
+		{name: "unique violation", err: &pq.Error{Code: "23505"}},
+		{name: "user not found", err: domain.ErrUserNotFound},
+		{name: "no rows", err: sql.ErrNoRows},
+		{name: "context canceled", err: context.Canceled},
+		{name: "context deadline", err: fmt.Errorf("get user: %w", context.DeadlineExceeded)},
+		{name: "read past the deadline of the context", ctx: expired, err: &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			// Act & Assert
+			assert.Equal(t, tt.want, unavailable(ctx, tt.err))
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+type EventArchiveRepository struct {
+	db Querier
+}
+
+// NewEventArchiveRepository creates a new event archive repository. Events
+// are archived by OutboxRepository.MarkPublished.
+func NewEventArchiveRepository(db Querier) *EventArchiveRepository {
+	return &EventArchiveRepository{db: db}
+}
+
+var eventArchiveListQuery = registerQuery("event_archive.list", "(*EventArchiveRepository).List", `
+		SELECT seq, event_id, event_type, tenant, aggregate_id, aggregate_seq, payload, created_at, archived_at
+		FROM event_archive
+		WHERE %s
+		ORDER BY seq
+		LIMIT $%d`)
+
+// List returns the events matching the filter in sequence order
+func (r *EventArchiveRepository) List(ctx context.Context, filter domain.EventArchiveFilter) ([]*domain.ArchivedEvent, error) {
+	where := []string{"seq >= $1"}
+	args := []interface{}{filter.FromSeq}
+	if filter.ToSeq > 0 {
+		args = append(args, filter.ToSeq)
+		where = append(where, fmt.Sprintf("seq <= $%d", len(args)))
+	}
+	if filter.Type != "" {
+		args = append(args, filter.Type)
+		where = append(where, fmt.Sprintf("event_type = $%d", len(args)))
+	}
+	args = append(args, filter.Limit)
+
+	query := fmt.Sprintf(eventArchiveListQuery, strings.Join(where, " AND "), len(args))
+
+	var events []*domain.ArchivedEvent
+	if err := r.db.SelectContext(ctx, &events, query, args...); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+var eventArchivePurgeQuery = registerQuery("event_archive.purge", "(*EventArchiveRepository).Purge", `DELETE FROM event_archive WHERE archived_at < $1`)
+
+func (r *EventArchiveRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	query := eventArchivePurgeQuery
+
+	result, err := r.db.ExecContext(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+type InstanceRepository struct {
+	db Querier
+}
+
+// NewInstanceRepository creates a new service instance repository
+func NewInstanceRepository(db Querier) *InstanceRepository {
+	return &InstanceRepository{
+		db: db,
+	}
+}
+
+var instanceRegisterQuery = registerQuery("instances.register", "(*InstanceRepository).Register", `
+		INSERT INTO service_instances (id, hostname, started_at, heartbeat_at, config_hash, version)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (id) DO UPDATE
+		SET heartbeat_at = EXCLUDED.heartbeat_at,
+			config_hash = EXCLUDED.config_hash,
+			version = EXCLUDED.version`)
+
+// Register inserts the instance or refreshes its heartbeat. Restarting an
+// instance under the same ID keeps its original started_at.
+func (r *InstanceRepository) Register(ctx context.Context, instance *domain.ServiceInstance) error {
+	query := instanceRegisterQuery
+
+	_, err := r.db.ExecContext(ctx, query,
+		instance.ID,
+		instance.Hostname,
+		instance.StartedAt,
+		instance.HeartbeatAt,
+		instance.ConfigHash,
+		instance.Version,
+	)
+	return err
+}
+
+var instanceListLiveQuery = registerQuery("instances.list_live", "(*InstanceRepository).ListLive", `
+		SELECT id, hostname, started_at, heartbeat_at, config_hash, version
+		FROM service_instances
+		WHERE heartbeat_at > $1
+		ORDER BY started_at`)
+
+// ListLive returns instances that sent a heartbeat after heartbeatAfter
+func (r *InstanceRepository) ListLive(ctx context.Context, heartbeatAfter time.Time) ([]*domain.ServiceInstance, error) {
+	var instances []*domain.ServiceInstance
+	query := instanceListLiveQuery
+
+	err := r.db.SelectContext(ctx, &instances, query, heartbeatAfter)
+	if err != nil {
+		return nil, err
+	}
+
+	return instances, nil
+}
+
+var instancePruneStaleQuery = registerQuery("instances.prune_stale", "(*InstanceRepository).PruneStale", `DELETE FROM service_instances WHERE heartbeat_at < $1`)
+
+// PruneStale removes instances whose last heartbeat is older than heartbeatBefore
+func (r *InstanceRepository) PruneStale(ctx context.Context, heartbeatBefore time.Time) (int64, error) {
+	query := instancePruneStaleQuery
+
+	result, err := r.db.ExecContext(ctx, query, heartbeatBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/pubsub"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
+)
+
+// JobProgressChannel is the Postgres notification channel carrying the IDs of
+// jobs whose state changed
+const JobProgressChannel = "job_progress"
+
+type JobRepository struct {
+	db  Querier
+	gen generate.Generators
+}
+
+// NewJobRepository creates a new job repository
+func NewJobRepository(db Querier, opts ...generate.Option) *JobRepository {
+	return &JobRepository{
+		db:  db,
+		gen: generate.New(opts...),
+	}
+}
+
+var jobCreateQuery = registerQuery("jobs.create", "(*JobRepository).Create", `
+		INSERT INTO jobs (id, job_type, status, progress, message, params, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`)
+
+func (r *JobRepository) Create(ctx context.Context, job *domain.Job) error {
+	now := r.gen.Clock.Now()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	query := jobCreateQuery
+
+	_, err := r.db.ExecContext(ctx, query,
+		job.ID,
+		job.Type,
+		job.Status,
+		job.Progress,
+		job.Message,
+		jobParams(job),
+		job.CreatedAt,
+		job.UpdatedAt,
+	)
+	return err
+}
+
+var jobEnqueueQuery = registerQuery("jobs.enqueue", "(*JobRepository).Enqueue", `
+		WITH created AS (
+			INSERT INTO jobs (id, job_type, status, progress, message, params, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+			RETURNING id
+		)
+		INSERT INTO outbox (id, event_type, tenant, payload, created_at)
+		SELECT $8, $9, $10, $11, $7 FROM created`)
+
+// Enqueue stores the job and records the event handing it to the worker in
+// the outbox within the same statement
+func (r *JobRepository) Enqueue(ctx context.Context, job *domain.Job, event *domain.OutboxEvent) error {
+	now := r.gen.Clock.Now()
+	job.CreatedAt = now
+	job.UpdatedAt = now
+
+	query := jobEnqueueQuery
+
+	_, err := r.db.ExecContext(ctx, query,
+		job.ID,
+		job.Type,
+		job.Status,
+		job.Progress,
+		job.Message,
+		jobParams(job),
+		now,
+		event.ID,
+		event.Type,
+		event.Tenant,
+		event.Payload,
+	)
+	if err != nil {
+		return err
+	}
+
+	wideevent.Add(ctx, wideevent.EventsPublished, 1)
+	return nil
+}
+
+// jobParams is the params column of the job, an empty object when it has none
+func jobParams(job *domain.Job) []byte {
+	if len(job.Params) == 0 {
+		return []byte("{}")
+	}
+	return job.Params
+}
+
+// updatedParams is the params column set by Update, NULL to keep the stored one
+func updatedParams(job *domain.Job) interface{} {
+	if len(job.Params) == 0 {
+		return nil
+	}
+	return []byte(job.Params)
+}
+
+var jobGetByIDQuery = registerQuery("jobs.get_by_id", "(*JobRepository).GetByID", `
+		SELECT id, job_type, status, progress, message, params, created_at, updated_at
+		FROM jobs
+		WHERE id = $1`)
+
+func (r *JobRepository) GetByID(ctx context.Context, id string) (*domain.Job, error) {
+	var job domain.Job
+	query := jobGetByIDQuery
+
+	err := r.db.GetContext(ctx, &job, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+var jobUpdateQuery = registerQuery("jobs.update", "(*JobRepository).Update", `
+		WITH updated AS (
+			UPDATE jobs SET status = $2, progress = $3, message = $4, updated_at = $5, params = COALESCE($7, params)
+			WHERE id = $1
+			RETURNING id
+		)
+		SELECT pg_notify($6, id::text) FROM updated`)
+
+// Update stores the job state and sends a notification on JobProgressChannel
+// in the same statement, so watchers are told if and only if the row changed.
+// Params are stored when set, jobs keep their resume point in them.
+func (r *JobRepository) Update(ctx context.Context, job *domain.Job) error {
+	job.UpdatedAt = r.gen.Clock.Now()
+
+	query := jobUpdateQuery
+
+	result, err := r.db.ExecContext(ctx, query,
+		job.ID,
+		job.Status,
+		job.Progress,
+		job.Message,
+		job.UpdatedAt,
+		JobProgressChannel,
+		updatedParams(job),
+	)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return domain.ErrJobNotFound
+	}
+
+	return nil
+}
+
+// JobListener relays job progress notifications from Postgres to an
+// in-process broker, so every replica can serve progress streams
+type JobListener struct {
+	listener *pq.Listener
+	done     chan struct{}
+}
+
+// NewJobListener starts listening on JobProgressChannel and publishes the ID
+// of every changed job to the broker
+func NewJobListener(dsn string, broker *pubsub.Broker, log *logger.Logger) (*JobListener, error) {
+	l := pq.NewListener(dsn, time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Warn("Job progress listener event", map[string]interface{}{"event": int(ev), "error": err.Error()})
+		}
+	})
+
+	if err := l.Listen(JobProgressChannel); err != nil {
+		l.Close()
+		return nil, err
+	}
+
+	jl := &JobListener{
+		listener: l,
+		done:     make(chan struct{}),
+	}
+
+	go func() {
+		defer close(jl.done)
+		for n := range l.Notify {
+			if n == nil {
+				// The connection was re-established and notifications may have been lost
+				broker.PublishAll()
+				continue
+			}
+			broker.Publish(n.Extra)
+		}
+	}()
+
+	return jl, nil
+}
+
+// Close stops listening and waits for the relay to finish
+func (l *JobListener) Close() error {
+	err := l.listener.Close()
+	<-l.done
+	return err
+}
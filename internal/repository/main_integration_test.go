@@ -0,0 +1,14 @@
+//go:build integration
+
+package repository
+
+import (
+	"os"
+	"testing"
+
+	"github.com/romanitalian/carch-go/internal/repository/repotest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(repotest.Main(m))
+}
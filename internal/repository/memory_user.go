@@ -0,0 +1,410 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+)
+
+// MemoryUserRepository keeps users in memory. It follows the semantics of
+// UserRepository, soft deletes and tombstones included, but knows no
+// collations, so name ordering with a locale is left to the service.
+type MemoryUserRepository struct {
+	mu         sync.Mutex
+	users      map[string]*domain.User
+	tombstones map[string]time.Time
+	changes    map[string]*domain.EmailChange
+	// seen holds when users were last marked seen, past their last change
+	seen map[string]time.Time
+	gen  generate.Generators
+}
+
+// NewMemoryUserRepository creates an empty in-memory user repository
+func NewMemoryUserRepository(opts ...generate.Option) *MemoryUserRepository {
+	return &MemoryUserRepository{
+		users:      make(map[string]*domain.User),
+		tombstones: make(map[string]time.Time),
+		changes:    make(map[string]*domain.EmailChange),
+		seen:       make(map[string]time.Time),
+		gen:        generate.New(opts...),
+	}
+}
+
+// live returns the user unless it is missing or soft-deleted. Callers hold mu.
+func (r *MemoryUserRepository) live(id string) (*domain.User, bool) {
+	u, ok := r.users[id]
+	if !ok || u.DeletedAt != nil {
+		return nil, false
+	}
+	return u, true
+}
+
+// emailTaken reports whether another live user uses the email. Callers hold mu.
+func (r *MemoryUserRepository) emailTaken(email, except string) bool {
+	for _, u := range r.users {
+		if u.ID != except && u.DeletedAt == nil && u.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// public copies a stored user without the fields reads never return
+func public(u *domain.User) *domain.User {
+	c := *u
+	c.Password = ""
+	c.DeletedAt = nil
+	c.Metadata = maps.Clone(u.Metadata)
+	c.SealedMetadata = maps.Clone(u.SealedMetadata)
+	return &c
+}
+
+func (r *MemoryUserRepository) Create(ctx context.Context, user *domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if user.ID == "" {
+		user.ID = r.gen.IDs.NewID()
+	}
+	if _, ok := r.users[user.ID]; ok {
+		return domain.ErrConflict
+	}
+	if r.emailTaken(user.Email, "") {
+		return domain.ErrEmailTaken
+	}
+
+	now := r.gen.Clock.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
+
+	stored := *user
+	stored.Metadata = maps.Clone(user.Metadata)
+	stored.SealedMetadata = maps.Clone(user.SealedMetadata)
+	r.users[user.ID] = &stored
+	return nil
+}
+
+func (r *MemoryUserRepository) GetByID(ctx context.Context, id string) (*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.live(id)
+	if !ok {
+		return nil, domain.ErrUserNotFound
+	}
+	return public(u), nil
+}
+
+func (r *MemoryUserRepository) GetByIDs(ctx context.Context, ids []string) ([]*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := make([]*domain.User, 0, len(ids))
+	for _, id := range ids {
+		if u, ok := r.live(id); ok {
+			users = append(users, public(u))
+		}
+	}
+	return users, nil
+}
+
+func (r *MemoryUserRepository) Update(ctx context.Context, user *domain.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.live(user.ID)
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+	if r.emailTaken(user.Email, user.ID) {
+		return domain.ErrEmailTaken
+	}
+
+	user.UpdatedAt = r.gen.Clock.Now()
+	u.Email = user.Email
+	u.Name = user.Name
+	if user.Metadata != nil {
+		u.Metadata = maps.Clone(user.Metadata)
+	}
+	if user.SealedMetadata != nil {
+		u.SealedMetadata = maps.Clone(user.SealedMetadata)
+	}
+	u.UpdatedAt = user.UpdatedAt
+	return nil
+}
+
+func (r *MemoryUserRepository) ReplaceSealedMetadata(ctx context.Context, id string, old, sealed domain.SealedMetadata) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.live(id)
+	same := func(a, b json.RawMessage) bool { return bytes.Equal(a, b) }
+	if !ok || !maps.EqualFunc(u.SealedMetadata, old, same) {
+		return false, nil
+	}
+	u.SealedMetadata = maps.Clone(sealed)
+	return true, nil
+}
+
+func (r *MemoryUserRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	u, ok := r.live(id)
+	if !ok {
+		return domain.ErrUserNotFound
+	}
+
+	deletedAt := r.gen.Clock.Now()
+	u.DeletedAt = &deletedAt
+	return nil
+}
+
+func (r *MemoryUserRepository) List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := make([]*domain.User, 0, len(r.users))
+	for _, u := range r.users {
+		if u.DeletedAt == nil && opts.Filter.Matches(u) {
+			users = append(users, public(u))
+		}
+	}
+
+	// Names are ordered A to Z and creation times newest first by default
+	reversed := opts.SortDir == domain.SortDesc
+	if opts.SortBy != domain.UserSortName {
+		reversed = opts.SortDir == domain.SortAsc
+	}
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		if reversed {
+			a, b = b, a
+		}
+		if opts.SortBy == domain.UserSortName {
+			if a.Name != b.Name {
+				return a.Name < b.Name
+			}
+			return a.ID < b.ID
+		}
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	start, end := opts.Bounds(len(users))
+	return &domain.UserPage{Users: users[start:end], Total: int64(len(users)), Limit: opts.Limit, Offset: opts.Offset}, nil
+}
+
+func (r *MemoryUserRepository) CountUsers(ctx context.Context, filter domain.UserFilter) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var count int64
+	for _, u := range r.users {
+		if u.DeletedAt == nil && filter.Matches(u) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *MemoryUserRepository) ListUserIDs(ctx context.Context, filter domain.UserFilter, limit int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []string
+	for _, u := range r.users {
+		if u.DeletedAt == nil && filter.Matches(u) {
+			ids = append(ids, u.ID)
+		}
+	}
+
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+// lastSeen returns when u was last seen, changes included. Callers hold mu.
+func (r *MemoryUserRepository) lastSeen(u *domain.User) time.Time {
+	if seen, ok := r.seen[u.ID]; ok && seen.After(u.UpdatedAt) {
+		return seen
+	}
+	return u.UpdatedAt
+}
+
+// ListRecentlySeen orders by the last change or mark, whichever is later
+func (r *MemoryUserRepository) ListRecentlySeen(ctx context.Context, limit int) ([]*domain.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := make([]*domain.User, 0, len(r.users))
+	for _, u := range r.users {
+		if u.DeletedAt == nil {
+			users = append(users, public(u))
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		a, b := users[i], users[j]
+		seenA, seenB := r.lastSeen(a), r.lastSeen(b)
+		if !seenA.Equal(seenB) {
+			return seenA.After(seenB)
+		}
+		return a.ID < b.ID
+	})
+	if len(users) > limit {
+		users = users[:limit]
+	}
+	return users, nil
+}
+
+func (r *MemoryUserRepository) MarkSeen(ctx context.Context, id string, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.live(id); !ok {
+		return nil
+	}
+	if at.After(r.seen[id]) {
+		r.seen[id] = at
+	}
+	return nil
+}
+
+// CollationExists always reports false, locale-aware ordering happens in the service
+func (r *MemoryUserRepository) CollationExists(ctx context.Context, collation string) (bool, error) {
+	return false, nil
+}
+
+func (r *MemoryUserRepository) ListTombstones(ctx context.Context, since time.Time) ([]*domain.Tombstone, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var tombstones []*domain.Tombstone
+	for _, u := range r.users {
+		if u.DeletedAt != nil && u.DeletedAt.After(since) {
+			tombstones = append(tombstones, &domain.Tombstone{ID: u.ID, DeletedAt: *u.DeletedAt})
+		}
+	}
+	for id, deletedAt := range r.tombstones {
+		if deletedAt.After(since) {
+			tombstones = append(tombstones, &domain.Tombstone{ID: id, DeletedAt: deletedAt})
+		}
+	}
+
+	sort.Slice(tombstones, func(i, j int) bool {
+		return tombstones[i].DeletedAt.Before(tombstones[j].DeletedAt)
+	})
+	return tombstones, nil
+}
+
+func (r *MemoryUserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var purged int64
+	for id, u := range r.users {
+		if u.DeletedAt != nil && u.DeletedAt.Before(deletedBefore) {
+			delete(r.users, id)
+			delete(r.changes, id)
+			if _, ok := r.tombstones[id]; !ok {
+				r.tombstones[id] = *u.DeletedAt
+				purged++
+			}
+		}
+	}
+	return purged, nil
+}
+
+func (r *MemoryUserRepository) ExpireTombstones(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var expired int64
+	for id, deletedAt := range r.tombstones {
+		if deletedAt.Before(deletedBefore) {
+			delete(r.tombstones, id)
+			expired++
+		}
+	}
+	return expired, nil
+}
+
+func (r *MemoryUserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.emailTaken(email, ""), nil
+}
+
+// CreateEmailChange stores the pending change, replacing a previous one for
+// the same user. There is no outbox in memory, so the events are dropped.
+func (r *MemoryUserRepository) CreateEmailChange(ctx context.Context, change *domain.EmailChange, events []*domain.OutboxEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.live(change.UserID); !ok {
+		return domain.ErrUserNotFound
+	}
+
+	stored := *change
+	r.changes[change.UserID] = &stored
+	return nil
+}
+
+func (r *MemoryUserRepository) GetEmailChangeByTokenHash(ctx context.Context, tokenHash string) (*domain.EmailChange, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, c := range r.changes {
+		if c.TokenHash == tokenHash {
+			found := *c
+			return &found, nil
+		}
+	}
+	return nil, domain.ErrEmailChangeNotFound
+}
+
+func (r *MemoryUserRepository) ConfirmEmailChange(ctx context.Context, change *domain.EmailChange) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.gen.Clock.Now()
+	pending, ok := r.changes[change.UserID]
+	if !ok || pending.TokenHash != change.TokenHash || pending.Expired(now) {
+		return domain.ErrEmailChangeNotFound
+	}
+	u, ok := r.live(change.UserID)
+	if !ok {
+		return domain.ErrEmailChangeNotFound
+	}
+	if r.emailTaken(pending.NewEmail, u.ID) {
+		return domain.ErrEmailTaken
+	}
+
+	delete(r.changes, change.UserID)
+	u.Email = pending.NewEmail
+	u.UpdatedAt = now
+	return nil
+}
+
+func (r *MemoryUserRepository) CancelEmailChange(ctx context.Context, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.changes[userID]; !ok {
+		return domain.ErrEmailChangeNotFound
+	}
+	delete(r.changes, userID)
+	return nil
+}
@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
+)
+
+type OutboxRepository struct {
+	db  Querier
+	gen generate.Generators
+}
+
+// NewOutboxRepository creates a new outbox repository
+func NewOutboxRepository(db Querier, opts ...generate.Option) *OutboxRepository {
+	return &OutboxRepository{
+		db:  db,
+		gen: generate.New(opts...),
+	}
+}
+
+// sequenceEvents is the seqs CTE numbering events of aggregates. source
+// selects the aggregate, how many events to number and their creation time.
+// seqs returns the last number assigned to the aggregate and the creation
+// time to record the events with, which is no earlier than that of its
+// previous events so that relaying in creation order keeps them in
+// sequence. The row of the aggregate stays locked until the transaction
+// ends, so numbers are assigned in commit order.
+func sequenceEvents(source string) string {
+	return `
+		seqs AS (
+			INSERT INTO outbox_sequences (aggregate_id, seq, created_at)
+			` + source + `
+			ON CONFLICT (aggregate_id) DO UPDATE
+			SET seq = outbox_sequences.seq + EXCLUDED.seq,
+				created_at = GREATEST(outbox_sequences.created_at, EXCLUDED.created_at)
+			RETURNING aggregate_id, seq, created_at
+		)`
+}
+
+var outboxAppendQuery = registerQuery("outbox.append", "(*OutboxRepository).Append", `
+		INSERT INTO outbox (id, event_type, tenant, payload, created_at, secret)
+		VALUES ($1, $2, $3, $4, $5, $6)`)
+
+var outboxAppendSequencedQuery = registerQuery("outbox.append_sequenced", "(*OutboxRepository).Append", `
+		WITH`+sequenceEvents(`VALUES ($6, 1, $5::timestamptz)`)+`
+		INSERT INTO outbox (id, event_type, tenant, payload, created_at, aggregate_id, aggregate_seq, secret)
+		SELECT $1, $2, $3, $4, created_at, aggregate_id, seq, $7 FROM seqs
+		RETURNING aggregate_seq, created_at`)
+
+// Append records the events, within the transaction of ctx if any so that
+// they are only relayed once it commits. Events without a creation time are
+// stamped with the current one, events with an aggregate are numbered.
+func (r *OutboxRepository) Append(ctx context.Context, events ...*domain.OutboxEvent) error {
+	db := querier(ctx, r.db)
+
+	for _, event := range events {
+		if event.CreatedAt.IsZero() {
+			event.CreatedAt = r.gen.Clock.Now()
+		}
+		if event.AggregateID == "" {
+			query := outboxAppendQuery
+			if _, err := db.ExecContext(ctx, query, event.ID, event.Type, event.Tenant, event.Payload, event.CreatedAt, secret(event)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		query := outboxAppendSequencedQuery
+		var recorded struct {
+			Seq       int64     `db:"aggregate_seq"`
+			CreatedAt time.Time `db:"created_at"`
+		}
+		err := db.GetContext(ctx, &recorded, query, event.ID, event.Type, event.Tenant, event.Payload, event.CreatedAt, event.AggregateID, secret(event))
+		if err != nil {
+			return err
+		}
+		event.AggregateSeq, event.CreatedAt = recorded.Seq, recorded.CreatedAt
+	}
+
+	wideevent.Add(ctx, wideevent.EventsPublished, len(events))
+	return nil
+}
+
+// secret returns the secret of the event to store, NULL without one
+func secret(event *domain.OutboxEvent) interface{} {
+	if len(event.Secret) == 0 {
+		return nil
+	}
+	return []byte(event.Secret)
+}
+
+var outboxListPendingQuery = registerQuery("outbox.list_pending", "(*OutboxRepository).ListPending", `
+		SELECT id, event_type, tenant, aggregate_id, aggregate_seq, payload, secret, created_at, published_at
+		FROM outbox
+		WHERE published_at IS NULL
+		ORDER BY created_at, aggregate_seq
+		LIMIT $1`)
+
+// ListPending returns events that have not been published yet, oldest first.
+// The events of an aggregate are created no earlier than its previous ones,
+// see sequenceEvents, so they are listed in sequence.
+func (r *OutboxRepository) ListPending(ctx context.Context, limit int) ([]*domain.OutboxEvent, error) {
+	var events []*domain.OutboxEvent
+	query := outboxListPendingQuery
+
+	err := r.db.SelectContext(ctx, &events, query, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+var outboxMarkPublishedQuery = registerQuery("outbox.mark_published", "(*OutboxRepository).MarkPublished", `
+		WITH published AS (
+			UPDATE outbox SET published_at = $1, secret = NULL WHERE id = $2
+			RETURNING id, event_type, tenant, aggregate_id, aggregate_seq, payload, created_at
+		)
+		INSERT INTO event_archive (event_id, event_type, tenant, aggregate_id, aggregate_seq, payload, created_at, archived_at)
+		SELECT id, event_type, tenant, aggregate_id, aggregate_seq, payload, created_at, $1 FROM published
+		ON CONFLICT (event_id) DO NOTHING`)
+
+// MarkPublished records that the event has been handed over to the broker,
+// clears its secret and appends it to the event archive in the same
+// statement, so the archive holds every relayed event in relay order and no
+// secret outlives the publication
+func (r *OutboxRepository) MarkPublished(ctx context.Context, id string) error {
+	query := outboxMarkPublishedQuery
+
+	_, err := r.db.ExecContext(ctx, query, r.gen.Clock.Now(), id)
+	return err
+}
@@ -0,0 +1,91 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/idgen"
+	"github.com/romanitalian/carch-go/internal/repository/repotest"
+)
+
+func TestOutboxRepository_AggregateSequence(t *testing.T) {
+	// Arrange: the clock of the second event of user-1 runs behind
+	ctx := context.Background()
+	tx := repotest.Begin(t)
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	repo := NewOutboxRepository(tx)
+	event := func(aggregate string, at time.Time) *domain.OutboxEvent {
+		return &domain.OutboxEvent{ID: idgen.UUID.NewID(), Type: "user.updated", AggregateID: aggregate, Payload: []byte(`{}`), CreatedAt: at}
+	}
+	events := []*domain.OutboxEvent{
+		event("user-1", now),
+		event("user-2", now.Add(time.Second)),
+		event("user-1", now.Add(-time.Minute)),
+		event("", now.Add(2*time.Second)),
+		event("user-1", now.Add(3*time.Second)),
+	}
+
+	// Act
+	require.NoError(t, repo.Append(ctx, events...))
+	pending, err := repo.ListPending(ctx, 1000)
+	require.NoError(t, err)
+
+	// Assert
+	assert.Equal(t, []int64{1, 1, 2, 0, 3}, []int64{
+		events[0].AggregateSeq, events[1].AggregateSeq, events[2].AggregateSeq, events[3].AggregateSeq, events[4].AggregateSeq,
+	})
+	assert.Equal(t, now, events[2].CreatedAt.UTC(), "an event is not created before the previous one of its aggregate")
+
+	ours := map[string]bool{}
+	for _, e := range events {
+		ours[e.ID] = true
+	}
+	var user1 []int64
+	for _, e := range pending {
+		if ours[e.ID] && e.AggregateID == "user-1" {
+			user1 = append(user1, e.AggregateSeq)
+		}
+	}
+	assert.Equal(t, []int64{1, 2, 3}, user1, "pending events of an aggregate are listed in sequence")
+}
+
+func TestUserRepository_Delete_SequencedAfterEmailChange(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	tx := repotest.Begin(t)
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	repo := NewUserRepository(tx, WithUserGenerators(generate.WithClock(clock.NewFixed(now))))
+	user := &domain.User{Email: "seq@example.com", Password: "hash", Name: "Seq"}
+	require.NoError(t, repo.Create(ctx, user))
+	change := &domain.EmailChange{UserID: user.ID, NewEmail: "new-seq@example.com", TokenHash: "seq-token", ExpiresAt: now.Add(time.Hour), CreatedAt: now}
+	events := []*domain.OutboxEvent{
+		{ID: idgen.UUID.NewID(), Type: domain.EventEmailChangeRequested, Payload: []byte(`{}`)},
+		{ID: idgen.UUID.NewID(), Type: domain.EventEmailChangeNotice, Payload: []byte(`{}`)},
+	}
+
+	// Act
+	require.NoError(t, repo.CreateEmailChange(ctx, change, events))
+	require.NoError(t, repo.Delete(ctx, user.ID))
+
+	// Assert
+	var recorded []struct {
+		Type string `db:"event_type"`
+		Seq  int64  `db:"aggregate_seq"`
+	}
+	require.NoError(t, tx.SelectContext(ctx, &recorded, `
+		SELECT event_type, aggregate_seq FROM outbox WHERE aggregate_id = $1 ORDER BY aggregate_seq`, user.ID))
+	require.Len(t, recorded, 3)
+	assert.Equal(t, domain.EventEmailChangeRequested, recorded[0].Type)
+	assert.Equal(t, domain.EventEmailChangeNotice, recorded[1].Type)
+	assert.Equal(t, domain.EventUserDeleted, recorded[2].Type)
+	assert.Equal(t, []int64{1, 2, 3}, []int64{recorded[0].Seq, recorded[1].Seq, recorded[2].Seq})
+}
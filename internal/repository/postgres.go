@@ -1,23 +1,363 @@
 package repository
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
+	"errors"
 	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/sanitize"
 )
 
 // PostgresConfig holds configuration for PostgreSQL connection
 type PostgresConfig struct {
+	// ConnString, when set, is used as is instead of the discrete fields. It
+	// is a postgres:// URI or a key=value string, and its host may be a unix
+	// socket directory such as /cloudsql/project:region:instance.
+	ConnString string
+
 	Host     string
 	Port     string
 	User     string
 	Password string
 	DBName   string
 	SSLMode  string
-	Logger   *logger.Logger
+	// SSLRootCert is the CA bundle the server certificate is verified
+	// against, SSLCert and SSLKey the client certificate. Empty ones are
+	// left out of the connection string.
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+	Logger      *logger.Logger
+
+	// Pool limits the connections kept to the server. Zero values leave
+	// the database/sql defaults in place.
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// DSN returns the lib/pq connection string for the configuration
+func (cfg PostgresConfig) DSN() string {
+	if cfg.ConnString != "" {
+		return cfg.ConnString
+	}
+	return formatConnSettings(cfg.settings())
+}
+
+// ServerDSN returns the connection string without the database name, to
+// connect to the server for administrative tasks
+func (cfg PostgresConfig) ServerDSN() (string, error) {
+	settings, err := cfg.connSettings()
+	if err != nil {
+		return "", err
+	}
+	delete(settings, "dbname")
+	return formatConnSettings(settings), nil
+}
+
+// WithServer returns the configuration pointed at another host and port,
+// e.g. a read replica, keeping the credentials and every other setting
+func (cfg PostgresConfig) WithServer(host, port string) (PostgresConfig, error) {
+	if cfg.ConnString == "" {
+		cfg.Host, cfg.Port = host, port
+		return cfg, nil
+	}
+
+	settings, err := cfg.connSettings()
+	if err != nil {
+		return cfg, err
+	}
+	settings["host"], settings["port"] = host, port
+	cfg.ConnString = formatConnSettings(settings)
+	return cfg, nil
+}
+
+// WithDatabase returns the configuration pointed at another database of the
+// same server, keeping the credentials and every other setting
+func (cfg PostgresConfig) WithDatabase(name string) (PostgresConfig, error) {
+	if cfg.ConnString == "" {
+		cfg.DBName = name
+		return cfg, nil
+	}
+
+	settings, err := cfg.connSettings()
+	if err != nil {
+		return cfg, err
+	}
+	settings["dbname"] = name
+	cfg.ConnString = formatConnSettings(settings)
+	return cfg, nil
+}
+
+// Resolved returns the configuration with the discrete fields read from
+// ConnString when it is set. It fails if ConnString is not valid.
+func (cfg PostgresConfig) Resolved() (PostgresConfig, error) {
+	if cfg.ConnString == "" {
+		return cfg, nil
+	}
+
+	settings, err := cfg.connSettings()
+	if err != nil {
+		return cfg, err
+	}
+	cfg.Host = settings["host"]
+	cfg.Port = settings["port"]
+	cfg.User = settings["user"]
+	cfg.Password = settings["password"]
+	cfg.DBName = settings["dbname"]
+	cfg.SSLMode = settings["sslmode"]
+	cfg.SSLRootCert = settings["sslrootcert"]
+	cfg.SSLCert = settings["sslcert"]
+	cfg.SSLKey = settings["sslkey"]
+	return cfg, nil
+}
+
+// settings returns the discrete fields as connection settings
+func (cfg PostgresConfig) settings() map[string]string {
+	return map[string]string{
+		"host":     cfg.Host,
+		"port":     cfg.Port,
+		"user":     cfg.User,
+		"password": cfg.Password,
+		"dbname":   cfg.DBName,
+		"sslmode":  cfg.SSLMode,
+
+		"sslrootcert": cfg.SSLRootCert,
+		"sslcert":     cfg.SSLCert,
+		"sslkey":      cfg.SSLKey,
+	}
+}
+
+// tlsFile is a certificate file of the connection, by its setting
+type tlsFile struct {
+	setting, path string
+}
+
+// tlsFiles returns the certificate files of the configuration, leaving out
+// empty ones
+func (cfg PostgresConfig) tlsFiles() []tlsFile {
+	var files []tlsFile
+	for _, f := range []tlsFile{
+		{"sslrootcert", cfg.SSLRootCert},
+		{"sslcert", cfg.SSLCert},
+		{"sslkey", cfg.SSLKey},
+	} {
+		if f.path != "" {
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// checkTLSFiles fails when a certificate file cannot be read, so that a
+// wrong path is not reported as a failed handshake
+func (cfg PostgresConfig) checkTLSFiles() error {
+	for _, f := range cfg.tlsFiles() {
+		if _, err := os.Stat(f.path); err != nil {
+			return fmt.Errorf("%s: %w", f.setting, err)
+		}
+	}
+	return nil
+}
+
+// withTLSFiles adds the certificate files in use to an error of a TLS
+// handshake, which otherwise does not tell which file it came from
+func (cfg PostgresConfig) withTLSFiles(err error) error {
+	files := cfg.tlsFiles()
+	if len(files) == 0 || !isTLSError(err) {
+		return err
+	}
+	used := make([]string, len(files))
+	for i, f := range files {
+		used[i] = f.setting + "=" + f.path
+	}
+	return fmt.Errorf("%w (using %s)", err, strings.Join(used, ", "))
+}
+
+// isTLSError reports whether err comes from setting up TLS, as lib/pq
+// passes on the errors of crypto/tls and its certificate checks
+func isTLSError(err error) bool {
+	var (
+		unknownAuthority x509.UnknownAuthorityError
+		invalidCert      x509.CertificateInvalidError
+		hostname         x509.HostnameError
+		verification     *tls.CertificateVerificationError
+	)
+	if errors.As(err, &unknownAuthority) || errors.As(err, &invalidCert) || errors.As(err, &hostname) || errors.As(err, &verification) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "tls:") || strings.Contains(msg, "x509:") || strings.Contains(msg, "pq: SSL") || strings.Contains(msg, "pq: Private key")
+}
+
+// connSettings returns the settings of ConnString, or of the discrete fields
+// when it is not set
+func (cfg PostgresConfig) connSettings() (map[string]string, error) {
+	if cfg.ConnString == "" {
+		return cfg.settings(), nil
+	}
+	settings, err := parseConnString(cfg.ConnString)
+	if err != nil {
+		// Parse errors may quote the connection string, password included
+		return nil, sanitize.Error(fmt.Errorf("invalid connection string: %w", err))
+	}
+	return settings, nil
+}
+
+// logFields describes the connection for logs, without the password
+func (cfg PostgresConfig) logFields() map[string]interface{} {
+	return map[string]interface{}{
+		"host": cfg.Host,
+		"port": cfg.Port,
+		"user": cfg.User,
+		"db":   cfg.DBName,
+
+		"max_open_conns":     cfg.MaxOpenConns,
+		"max_idle_conns":     cfg.MaxIdleConns,
+		"conn_max_lifetime":  cfg.ConnMaxLifetime.String(),
+		"conn_max_idle_time": cfg.ConnMaxIdleTime.String(),
+	}
+}
+
+// applyPool sets the connection pool limits of the configuration on db
+func (cfg PostgresConfig) applyPool(db *sql.DB) {
+	if cfg.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(cfg.MaxOpenConns)
+	}
+	if cfg.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	if cfg.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	}
+	if cfg.ConnMaxIdleTime > 0 {
+		db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	}
+}
+
+// parseConnString parses a postgres:// URI or a key=value connection string
+// into its settings
+func parseConnString(s string) (map[string]string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, errors.New("connection string is empty")
+	}
+
+	if scheme, _, ok := strings.Cut(s, "://"); ok {
+		if scheme != "postgres" && scheme != "postgresql" {
+			return nil, fmt.Errorf("unsupported scheme %q, use postgres://", scheme)
+		}
+		kv, err := pq.ParseURL(s)
+		if err != nil {
+			return nil, err
+		}
+		s = kv
+	}
+
+	settings, err := parseKeyValues(s)
+	if err != nil {
+		return nil, err
+	}
+	if port, ok := settings["port"]; ok {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return nil, fmt.Errorf("invalid port %q", port)
+		}
+	}
+	return settings, nil
+}
+
+// parseKeyValues parses a key=value connection string the way lib/pq does.
+// Values may be single-quoted and escape characters with a backslash.
+func parseKeyValues(s string) (map[string]string, error) {
+	settings := map[string]string{}
+	r := []rune(s)
+	i := 0
+	skipSpaces := func() {
+		for i < len(r) && unicode.IsSpace(r[i]) {
+			i++
+		}
+	}
+
+	for {
+		skipSpaces()
+		if i == len(r) {
+			return settings, nil
+		}
+
+		start := i
+		for i < len(r) && r[i] != '=' && !unicode.IsSpace(r[i]) {
+			i++
+		}
+		key := string(r[start:i])
+		skipSpaces()
+		if key == "" || i == len(r) || r[i] != '=' {
+			return nil, fmt.Errorf("missing \"=\" after %q", key)
+		}
+		i++
+		skipSpaces()
+
+		var value strings.Builder
+		quoted := i < len(r) && r[i] == '\''
+		if quoted {
+			i++
+		}
+		for ; i < len(r); i++ {
+			c := r[i]
+			if quoted && c == '\'' || !quoted && unicode.IsSpace(c) {
+				break
+			}
+			if c == '\\' {
+				i++
+				if i == len(r) {
+					return nil, fmt.Errorf("value of %q ends with a backslash", key)
+				}
+				c = r[i]
+			}
+			value.WriteRune(c)
+		}
+		if quoted {
+			if i == len(r) {
+				return nil, fmt.Errorf("unterminated quoted value of %q", key)
+			}
+			i++
+		}
+		settings[key] = value.String()
+	}
+}
+
+// formatConnSettings formats settings as a key=value connection string,
+// quoting values where needed and leaving out empty ones
+func formatConnSettings(settings map[string]string) string {
+	keys := make([]string, 0, len(settings))
+	for k, v := range settings {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := settings[k]
+		if strings.ContainsAny(v, " \t\n'\\") {
+			v = "'" + quote.Replace(v) + "'"
+		}
+		parts = append(parts, k+"="+v)
+	}
+	return strings.Join(parts, " ")
 }
 
 // DB is a wrapper around sqlx.DB that exposes the underlying sql.DB
@@ -28,20 +368,27 @@ type DB struct {
 
 // NewPostgresDB creates a new PostgreSQL connection
 func NewPostgresDB(cfg PostgresConfig) (*DB, error) {
-	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.DBName, cfg.SSLMode)
+	dsn := cfg.DSN()
+
+	// The override is validated up front so a typo is not reported as a
+	// connection failure, and so the log shows where it points to
+	cfg, err := cfg.Resolved()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cfg.checkTLSFiles(); err != nil {
+		return nil, err
+	}
 
 	if cfg.Logger != nil {
-		cfg.Logger.Info("Connecting to PostgreSQL", map[string]interface{}{
-			"host": cfg.Host,
-			"port": cfg.Port,
-			"user": cfg.User,
-			"db":   cfg.DBName,
-		})
+		cfg.Logger.Info("Connecting to PostgreSQL", cfg.logFields())
 	}
 
+	// Driver errors may quote the DSN, password included
 	sqlxDB, err := sqlx.Connect("postgres", dsn)
 	if err != nil {
+		err = sanitize.Error(cfg.withTLSFiles(err))
 		if cfg.Logger != nil {
 			cfg.Logger.Error("Failed to connect to PostgreSQL", err, map[string]interface{}{
 				"host": cfg.Host,
@@ -51,8 +398,11 @@ func NewPostgresDB(cfg PostgresConfig) (*DB, error) {
 		return nil, err
 	}
 
+	cfg.applyPool(sqlxDB.DB)
+
 	err = sqlxDB.Ping()
 	if err != nil {
+		err = sanitize.Error(cfg.withTLSFiles(err))
 		if cfg.Logger != nil {
 			cfg.Logger.Error("Failed to ping PostgreSQL", err, nil)
 		}
@@ -72,22 +422,29 @@ func NewPostgresDB(cfg PostgresConfig) (*DB, error) {
 // NewPostgresDBWithoutDB creates a new PostgreSQL connection without specifying a database
 // This is useful for administrative tasks like creating a database
 func NewPostgresDBWithoutDB(cfg PostgresConfig) (*sql.DB, error) {
-	cfg.Logger.Info("Connecting to PostgreSQL server", map[string]interface{}{
-		"host": cfg.Host,
-		"port": cfg.Port,
-		"user": cfg.User,
-	})
+	connStr, err := cfg.ServerDSN()
+	if err != nil {
+		return nil, err
+	}
+	if cfg, err = cfg.Resolved(); err != nil {
+		return nil, err
+	}
+	if err := cfg.checkTLSFiles(); err != nil {
+		return nil, err
+	}
 
-	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s sslmode=%s",
-		cfg.Host, cfg.Port, cfg.User, cfg.Password, cfg.SSLMode)
+	fields := cfg.logFields()
+	delete(fields, "db")
+	cfg.Logger.Info("Connecting to PostgreSQL server", fields)
 
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database connection: %w", err)
+		return nil, sanitize.Error(fmt.Errorf("failed to open database connection: %w", err))
 	}
+	cfg.applyPool(db)
 
 	if err := db.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+		return nil, sanitize.Error(fmt.Errorf("failed to ping database: %w", cfg.withTLSFiles(err)))
 	}
 
 	cfg.Logger.Info("Connected to PostgreSQL server", nil)
@@ -0,0 +1,238 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+func TestPostgresAuditRepository_List(t *testing.T) {
+	from := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 7)
+	after := &domain.AuditCursor{CreatedAt: from.Add(2 * time.Hour), ID: 9}
+
+	tests := []struct {
+		name   string
+		filter domain.AuditFilter
+		where  string
+		args   []driver.Value
+	}{
+		{
+			name:   "no filter",
+			filter: domain.AuditFilter{Limit: 50},
+			where:  `ORDER BY created_at DESC, id DESC LIMIT $1`,
+			args:   []driver.Value{50},
+		},
+		{
+			name:   "actor",
+			filter: domain.AuditFilter{ActorID: "admin-1", Limit: 50},
+			where:  `WHERE actor_id = $1 ORDER BY`,
+			args:   []driver.Value{"admin-1", 50},
+		},
+		{
+			name:   "entity",
+			filter: domain.AuditFilter{EntityID: "u-1", Limit: 50},
+			where:  `WHERE entity_id = $1 ORDER BY`,
+			args:   []driver.Value{"u-1", 50},
+		},
+		{
+			name:   "action",
+			filter: domain.AuditFilter{Action: "webhook.rejected", Limit: 50},
+			where:  `WHERE action = $1 ORDER BY`,
+			args:   []driver.Value{"webhook.rejected", 50},
+		},
+		{
+			// Bounding created_at on both sides prunes the partitions outside the range
+			name:   "time range",
+			filter: domain.AuditFilter{From: from, To: to, Limit: 50},
+			where:  `WHERE created_at >= $1 AND created_at < $2 ORDER BY`,
+			args:   []driver.Value{from, to, 50},
+		},
+		{
+			name:   "after cursor",
+			filter: domain.AuditFilter{Action: "user.purged", After: after, Limit: 10},
+			where:  `WHERE action = $1 AND (created_at, id) < ($2, $3) ORDER BY created_at DESC, id DESC LIMIT $4`,
+			args:   []driver.Value{"user.purged", after.CreatedAt, int64(9), 10},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			repo := NewAuditRepository(sqlx.NewDb(db, "sqlmock"))
+
+			rows := sqlmock.NewRows([]string{"id", "action", "entity", "entity_id", "actor_id", "created_at"}).
+				AddRow(2, "integrity.repair", "user", "u-1", "admin-1", from.Add(time.Hour))
+			mock.ExpectQuery(regexp.QuoteMeta(`AS actor_id, created_at FROM audit_log ` + tt.where)).
+				WithArgs(tt.args...).
+				WillReturnRows(rows)
+
+			// Act
+			entries, err := repo.List(context.Background(), tt.filter)
+
+			// Assert
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "u-1", entries[0].EntityID)
+			assert.Equal(t, "admin-1", entries[0].ActorID)
+			assert.Nil(t, entries[0].Details)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresAuditRepository_List_IncludeDetails(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewAuditRepository(sqlx.NewDb(db, "sqlmock"))
+
+	rows := sqlmock.NewRows([]string{"id", "action", "entity", "entity_id", "actor_id", "created_at", "details"}).
+		AddRow(2, "integrity.repair", "user", "u-1", "", time.Now(), []byte(`{"check":"x"}`))
+	mock.ExpectQuery(regexp.QuoteMeta(`created_at, details FROM audit_log`)).
+		WithArgs(50).
+		WillReturnRows(rows)
+
+	// Act
+	entries, err := repo.List(context.Background(), domain.AuditFilter{Limit: 50, IncludeDetails: true})
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.JSONEq(t, `{"check":"x"}`, string(entries[0].Details))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresAuditRepository_ListPartitions(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewAuditRepository(sqlx.NewDb(db, "sqlmock"))
+
+	rows := sqlmock.NewRows([]string{"relname"}).
+		AddRow("audit_log_y2024m02").
+		AddRow("audit_log_default").
+		AddRow("audit_log_y2023m12")
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM pg_inherits`)).WillReturnRows(rows)
+
+	// Act
+	partitions, err := repo.ListPartitions(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []domain.AuditPartition{
+		{Name: "audit_log_y2023m12", Month: time.Date(2023, time.December, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "audit_log_y2024m02", Month: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)},
+	}, partitions)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresAuditRepository_CreatePartition(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewAuditRepository(sqlx.NewDb(db, "sqlmock"))
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT to_regclass($1) IS NOT NULL`)).
+		WithArgs("audit_log_y2024m12").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	// Rows of the month stranded in the default partition move before attaching
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM audit_log_default
+			WHERE created_at >= '2024-12-01T00:00:00Z' AND created_at < '2025-01-01T00:00:00Z'`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Act
+	partition, err := repo.CreatePartition(context.Background(), time.Date(2024, time.December, 17, 9, 0, 0, 0, time.UTC))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "audit_log_y2024m12", partition.Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresAuditRepository_CreatePartition_Exists(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewAuditRepository(sqlx.NewDb(db, "sqlmock"))
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT to_regclass($1) IS NOT NULL`)).
+		WithArgs("audit_log_y2024m12").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	// Act
+	_, err = repo.CreatePartition(context.Background(), time.Date(2024, time.December, 1, 0, 0, 0, 0, time.UTC))
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresAuditRepository_DropPartition(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewAuditRepository(sqlx.NewDb(db, "sqlmock"))
+
+	mock.ExpectExec(regexp.QuoteMeta(`ALTER TABLE audit_log DETACH PARTITION "audit_log_y2023m01";
+		DROP TABLE "audit_log_y2023m01"`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Act
+	err = repo.DropPartition(context.Background(), domain.AuditPartition{Name: "audit_log_y2023m01"})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresAuditRepository_Create(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewAuditRepository(sqlx.NewDb(db, "sqlmock"))
+
+	entry := &domain.AuditEntry{
+		Action:    domain.AuditWebhookRejected,
+		Entity:    domain.AuditEntityWebhook,
+		EntityID:  "stripe",
+		Details:   []byte(`{"reason":"invalid webhook signature"}`),
+		CreatedAt: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO audit_log`)).
+		WithArgs(entry.Action, entry.Entity, entry.EntityID, entry.ActorID, entry.Details, entry.CreatedAt).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+
+	// Act
+	err = repo.Create(context.Background(), entry)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), entry.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
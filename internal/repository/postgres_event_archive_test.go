@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+)
+
+func TestPostgresOutboxRepository_MarkPublished_Archives(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	repo := NewOutboxRepository(sqlx.NewDb(db, "sqlmock"), generate.WithClock(clock.NewFixed(now)))
+
+	// The secret is cleared and not copied to the archive
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE outbox SET published_at = $1, secret = NULL WHERE id = $2
+			RETURNING id, event_type, tenant, aggregate_id, aggregate_seq, payload, created_at
+		)
+		INSERT INTO event_archive (event_id, event_type, tenant, aggregate_id, aggregate_seq, payload, created_at, archived_at)
+		SELECT id, event_type, tenant, aggregate_id, aggregate_seq, payload, created_at, $1 FROM published
+		ON CONFLICT (event_id) DO NOTHING`)).
+		WithArgs(now, "e-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	err = repo.MarkPublished(context.Background(), "e-1")
+
+	// Assert
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresOutboxRepository_Append_Sequenced(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	defer db.Close()
+
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	previous := now.Add(time.Second)
+	repo := NewOutboxRepository(sqlx.NewDb(db, "sqlmock"), generate.WithClock(clock.NewFixed(now)))
+	cleanup := &domain.OutboxEvent{ID: "e-1", Type: domain.EventUserCleanup, AggregateID: "user-1", Payload: []byte(`{}`)}
+	job := &domain.OutboxEvent{ID: "e-2", Type: "job.bulk_delete", Payload: []byte(`{}`)}
+
+	// The previous event of the user was created later than the clock tells
+	mock.ExpectQuery(outboxAppendSequencedQuery).
+		WithArgs("e-1", domain.EventUserCleanup, "", []byte(`{}`), now, "user-1", nil).
+		WillReturnRows(sqlmock.NewRows([]string{"aggregate_seq", "created_at"}).AddRow(4, previous))
+	mock.ExpectExec(outboxAppendQuery).
+		WithArgs("e-2", "job.bulk_delete", "", []byte(`{}`), now, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	err = repo.Append(context.Background(), cleanup, job)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), cleanup.AggregateSeq)
+	assert.Equal(t, previous, cleanup.CreatedAt, "the event is not created before the previous one of the user")
+	assert.Zero(t, job.AggregateSeq, "events without an aggregate are not numbered")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresEventArchiveRepository_List(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter domain.EventArchiveFilter
+		where  string
+		args   []driver.Value
+	}{
+		{
+			name:   "open range",
+			filter: domain.EventArchiveFilter{FromSeq: 10, Limit: 100},
+			where:  `WHERE seq >= $1`,
+			args:   []driver.Value{int64(10), 100},
+		},
+		{
+			name:   "range of a type",
+			filter: domain.EventArchiveFilter{Type: "user.created", FromSeq: 10, ToSeq: 20, Limit: 100},
+			where:  `WHERE seq >= $1 AND seq <= $2 AND event_type = $3`,
+			args:   []driver.Value{int64(10), int64(20), "user.created", 100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			repo := NewEventArchiveRepository(sqlx.NewDb(db, "sqlmock"))
+
+			at := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+			rows := sqlmock.NewRows([]string{"seq", "event_id", "event_type", "payload", "created_at", "archived_at"}).
+				AddRow(11, "e-11", "user.created", []byte(`{"id":"u-1"}`), at, at)
+			mock.ExpectQuery(regexp.QuoteMeta(tt.where) + `\s+ORDER BY seq\s+LIMIT`).
+				WithArgs(tt.args...).
+				WillReturnRows(rows)
+
+			// Act
+			events, err := repo.List(context.Background(), tt.filter)
+
+			// Assert
+			require.NoError(t, err)
+			require.Len(t, events, 1)
+			assert.Equal(t, int64(11), events[0].Seq)
+			assert.Equal(t, "e-11", events[0].ID)
+			assert.JSONEq(t, `{"id":"u-1"}`, string(events[0].Payload))
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresEventArchiveRepository_Purge(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewEventArchiveRepository(sqlx.NewDb(db, "sqlmock"))
+
+	cutoff := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM event_archive WHERE archived_at < $1`)).
+		WithArgs(cutoff).
+		WillReturnResult(sqlmock.NewResult(0, 42))
+
+	// Act
+	purged, err := repo.Purge(context.Background(), cutoff)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+func TestPostgresInstanceRepository_Register(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewInstanceRepository(sqlx.NewDb(db, "sqlmock"))
+
+	ctx := context.Background()
+	instance := &domain.ServiceInstance{
+		ID:          "instance-1",
+		Hostname:    "api-0",
+		StartedAt:   time.Now(),
+		HeartbeatAt: time.Now(),
+		ConfigHash:  "abc",
+		Version:     "v1",
+	}
+
+	// Re-registering refreshes the heartbeat instead of failing
+	mock.ExpectExec(regexp.QuoteMeta(`ON CONFLICT (id) DO UPDATE`)).
+		WithArgs(instance.ID, instance.Hostname, instance.StartedAt, instance.HeartbeatAt, instance.ConfigHash, instance.Version).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	err = repo.Register(ctx, instance)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresInstanceRepository_PruneStale(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewInstanceRepository(sqlx.NewDb(db, "sqlmock"))
+
+	ctx := context.Background()
+	before := time.Now().Add(-time.Minute)
+
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM service_instances WHERE heartbeat_at < $1`)).
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	// Act
+	pruned, err := repo.PruneStale(ctx, before)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), pruned)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
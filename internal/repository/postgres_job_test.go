@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+)
+
+func TestPostgresJobRepository_Update(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewJobRepository(sqlx.NewDb(db, "sqlmock"), generate.WithClock(clock.NewFixed(testNow)))
+
+	ctx := context.Background()
+	job := &domain.Job{ID: "job-1", Status: domain.JobRunning, Progress: 30}
+
+	// The notification is sent by the same statement as the update
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_notify($6, id::text) FROM updated`)).
+		WithArgs(job.ID, job.Status, job.Progress, job.Message, testNow, JobProgressChannel, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	err = repo.Update(ctx, job)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresJobRepository_Update_Params(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewJobRepository(sqlx.NewDb(db, "sqlmock"), generate.WithClock(clock.NewFixed(testNow)))
+	job := &domain.Job{ID: "job-1", Status: domain.JobRunning, Progress: 30, Params: []byte(`{"offset":1024}`)}
+
+	mock.ExpectExec(regexp.QuoteMeta(`params = COALESCE($7, params)`)).
+		WithArgs(job.ID, job.Status, job.Progress, job.Message, testNow, JobProgressChannel, []byte(`{"offset":1024}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	err = repo.Update(context.Background(), job)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresJobRepository_Update_NotFound(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewJobRepository(sqlx.NewDb(db, "sqlmock"))
+
+	mock.ExpectExec(regexp.QuoteMeta(`UPDATE jobs SET`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Act
+	err = repo.Update(context.Background(), &domain.Job{ID: "missing"})
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrJobNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresJobRepository_Enqueue(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewJobRepository(sqlx.NewDb(db, "sqlmock"), generate.WithClock(clock.NewFixed(testNow)))
+
+	job := &domain.Job{ID: "job-1", Type: domain.JobUsersBulkDelete, Status: domain.JobPending, Params: []byte(`{"total":3}`)}
+	event := &domain.OutboxEvent{ID: "event-1", Type: "job.users.bulk_delete", Tenant: "acme", Payload: []byte(`{"job_id":"job-1"}`)}
+
+	// The job and its event are written by the same statement
+	mock.ExpectExec(regexp.QuoteMeta(`INSERT INTO outbox (id, event_type, tenant, payload, created_at)
+		SELECT $8, $9, $10, $11, $7 FROM created`)).
+		WithArgs(job.ID, job.Type, job.Status, 0, "", job.Params, testNow, event.ID, event.Type, event.Tenant, event.Payload).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	err = repo.Enqueue(context.Background(), job, event)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, testNow, job.CreatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -3,18 +3,29 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"regexp"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/idgen"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
 )
 
+// testNow is the time told by the fixed clocks of the tests
+var testNow = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
 func TestPostgresUserRepository_Create(t *testing.T) {
 	// Arrange
 	db, mock, err := sqlmock.New()
@@ -22,36 +33,44 @@ func TestPostgresUserRepository_Create(t *testing.T) {
 	defer db.Close()
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
-	repo := NewUserRepository(sqlxDB)
+	repo := NewUserRepository(sqlxDB, WithUserGenerators(generate.WithClock(clock.NewFixed(testNow)), generate.WithIDGenerator(idgen.NewSequence())))
 
 	ctx := context.Background()
 	user := &domain.User{
-		ID:        "user-123",
-		Email:     "test@example.com",
-		Password:  "hashed_password",
-		Name:      "Test User",
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+		Email:    "test@example.com",
+		Password: "hashed_password",
+		Name:     "Test User",
 	}
+	userID := idgen.SequenceID(1)
 
 	// Expected query setup
 	mock.ExpectQuery(regexp.QuoteMeta(`
-		INSERT INTO users (id, email, password_hash, name, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		INSERT INTO users (id, email, password_hash, name, metadata, metadata_encrypted, created_at, updated_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5, '{}'), COALESCE($6, '{}'), $7, $8, $8)
 		RETURNING id`)).WithArgs(
-		user.ID,
+		userID,
 		user.Email,
 		user.Password,
 		user.Name,
-		sqlmock.AnyArg(),
-		sqlmock.AnyArg(),
-	).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(user.ID))
+		nil,
+		nil,
+		testNow,
+		testNow,
+	).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(userID))
 
 	// Act
 	err = repo.Create(ctx, user)
 
 	// Assert
 	assert.NoError(t, err)
+	assert.Equal(t, &domain.User{
+		ID:        userID,
+		Email:     "test@example.com",
+		Password:  "hashed_password",
+		Name:      "Test User",
+		CreatedAt: testNow,
+		UpdatedAt: testNow,
+	}, user)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -75,13 +94,13 @@ func TestPostgresUserRepository_GetByID(t *testing.T) {
 	}
 
 	// Expected query setup
-	rows := sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"}).
-		AddRow(expectedUser.ID, expectedUser.Email, expectedUser.Name, expectedUser.CreatedAt, expectedUser.UpdatedAt)
+	rows := sqlmock.NewRows([]string{"id", "email", "name", "metadata", "metadata_encrypted", "created_at", "updated_at"}).
+		AddRow(expectedUser.ID, expectedUser.Email, expectedUser.Name, []byte(`{"plan":"pro"}`), []byte(`{"ssn":{"kid":"k1"}}`), expectedUser.CreatedAt, expectedUser.UpdatedAt)
 
 	mock.ExpectQuery(regexp.QuoteMeta(`
-		SELECT id, email, name, created_at, updated_at
+		SELECT id, email, name, metadata, metadata_encrypted, created_at, updated_at
 		FROM users
-		WHERE id = $1`)).
+		WHERE id = $1 AND deleted_at IS NULL`)).
 		WithArgs(userID).
 		WillReturnRows(rows)
 
@@ -93,9 +112,46 @@ func TestPostgresUserRepository_GetByID(t *testing.T) {
 	assert.Equal(t, expectedUser.ID, user.ID)
 	assert.Equal(t, expectedUser.Email, user.Email)
 	assert.Equal(t, expectedUser.Name, user.Name)
+	assert.Equal(t, domain.Metadata{"plan": "pro"}, user.Metadata)
+	assert.JSONEq(t, `{"kid":"k1"}`, string(user.SealedMetadata["ssn"]))
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestPostgresUserRepository_GetByID_ReadReplica(t *testing.T) {
+	// Arrange
+	primaryDB, primary, err := sqlmock.New()
+	require.NoError(t, err)
+	defer primaryDB.Close()
+	replicaDB, replica, err := sqlmock.New()
+	require.NoError(t, err)
+	defer replicaDB.Close()
+
+	repo := NewUserRepository(sqlx.NewDb(primaryDB, "sqlmock"),
+		WithReadReplica(sqlx.NewDb(replicaDB, "sqlmock")))
+
+	columns := []string{"id", "email", "name", "created_at", "updated_at"}
+	now := time.Now()
+	// The replica has not applied the rename yet
+	replica.ExpectQuery(regexp.QuoteMeta(`WHERE id = $1 AND deleted_at IS NULL`)).
+		WithArgs("user-123").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("user-123", "a@example.com", "Old", now, now))
+	primary.ExpectQuery(regexp.QuoteMeta(`WHERE id = $1 AND deleted_at IS NULL`)).
+		WithArgs("user-123").
+		WillReturnRows(sqlmock.NewRows(columns).AddRow("user-123", "a@example.com", "New", now, now))
+
+	// Act
+	fromReplica, replicaErr := repo.GetByID(context.Background(), "user-123")
+	fromPrimary, primaryErr := repo.GetByID(consistency.WithPrimary(context.Background()), "user-123")
+
+	// Assert
+	require.NoError(t, replicaErr)
+	require.NoError(t, primaryErr)
+	assert.Equal(t, "Old", fromReplica.Name)
+	assert.Equal(t, "New", fromPrimary.Name)
+	assert.NoError(t, primary.ExpectationsWereMet())
+	assert.NoError(t, replica.ExpectationsWereMet())
+}
+
 func TestPostgresUserRepository_GetByID_NotFound(t *testing.T) {
 	// Arrange
 	db, mock, err := sqlmock.New()
@@ -110,9 +166,9 @@ func TestPostgresUserRepository_GetByID_NotFound(t *testing.T) {
 
 	// Expected query setup
 	mock.ExpectQuery(regexp.QuoteMeta(`
-		SELECT id, email, name, created_at, updated_at
+		SELECT id, email, name, metadata, metadata_encrypted, created_at, updated_at
 		FROM users
-		WHERE id = $1`)).
+		WHERE id = $1 AND deleted_at IS NULL`)).
 		WithArgs(userID).
 		WillReturnError(sql.ErrNoRows)
 
@@ -125,7 +181,7 @@ func TestPostgresUserRepository_GetByID_NotFound(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestPostgresUserRepository_Update(t *testing.T) {
+func TestPostgresUserRepository_GetByIDs(t *testing.T) {
 	// Arrange
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
@@ -134,20 +190,52 @@ func TestPostgresUserRepository_Update(t *testing.T) {
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
 	repo := NewUserRepository(sqlxDB)
 
+	ctx := context.Background()
+	ids := []string{"user-1", "user-2"}
+
+	// A single query resolves every ID, unknown ones are simply absent
+	rows := sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"}).
+		AddRow("user-1", "a@example.com", "A", time.Now(), time.Now())
+
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE id = ANY($1) AND deleted_at IS NULL`)).
+		WithArgs(pq.Array(ids)).
+		WillReturnRows(rows)
+
+	// Act
+	users, err := repo.GetByIDs(ctx, ids)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+	assert.Equal(t, "user-1", users[0].ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_Update(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	repo := NewUserRepository(sqlxDB, WithUserGenerators(generate.WithClock(clock.NewFixed(testNow))))
+
 	ctx := context.Background()
 	user := &domain.User{
-		ID:        "user-123",
-		Email:     "updated@example.com",
-		Name:      "Updated User",
-		UpdatedAt: time.Now(),
+		ID:       "user-123",
+		Email:    "updated@example.com",
+		Name:     "Updated User",
+		Metadata: domain.Metadata{"plan": "pro"},
 	}
 
 	// Expected query setup
 	mock.ExpectExec(regexp.QuoteMeta(`
 		UPDATE users
-		SET email = $1, name = $2, updated_at = $3
-		WHERE id = $4`)).
-		WithArgs(user.Email, user.Name, sqlmock.AnyArg(), user.ID).
+		SET email = $1, name = $2, updated_at = $3, last_seen_at = $3,
+			metadata = COALESCE($4, metadata),
+			metadata_encrypted = COALESCE($5, metadata_encrypted)
+		WHERE id = $6 AND deleted_at IS NULL`)).
+		WithArgs(user.Email, user.Name, testNow, []byte(`{"plan":"pro"}`), nil, user.ID).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	// Act
@@ -155,6 +243,40 @@ func TestPostgresUserRepository_Update(t *testing.T) {
 
 	// Assert
 	assert.NoError(t, err)
+	assert.Equal(t, testNow, user.UpdatedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_ReplaceSealedMetadata(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"))
+	old := domain.SealedMetadata{"ssn": []byte(`{"kid":"k1"}`)}
+	sealed := domain.SealedMetadata{"ssn": []byte(`{"kid":"k2"}`)}
+
+	query := regexp.QuoteMeta(`
+		UPDATE users SET metadata_encrypted = $1
+		WHERE id = $2 AND deleted_at IS NULL AND metadata_encrypted = COALESCE($3, '{}')`)
+	mock.ExpectExec(query).
+		WithArgs([]byte(`{"ssn":{"kid":"k2"}}`), "user-123", []byte(`{"ssn":{"kid":"k1"}}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// Changed meanwhile
+	mock.ExpectExec(query).
+		WithArgs(sqlmock.AnyArg(), "user-123", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Act
+	replaced, err := repo.ReplaceSealedMetadata(context.Background(), "user-123", old, sealed)
+	require.NoError(t, err)
+	replacedAgain, err := repo.ReplaceSealedMetadata(context.Background(), "user-123", old, sealed)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.True(t, replaced)
+	assert.False(t, replacedAgain)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -178,9 +300,11 @@ func TestPostgresUserRepository_Update_NotFound(t *testing.T) {
 	// Expected query setup
 	mock.ExpectExec(regexp.QuoteMeta(`
 		UPDATE users
-		SET email = $1, name = $2, updated_at = $3
-		WHERE id = $4`)).
-		WithArgs(user.Email, user.Name, sqlmock.AnyArg(), user.ID).
+		SET email = $1, name = $2, updated_at = $3, last_seen_at = $3,
+			metadata = COALESCE($4, metadata),
+			metadata_encrypted = COALESCE($5, metadata_encrypted)
+		WHERE id = $6 AND deleted_at IS NULL`)).
+		WithArgs(user.Email, user.Name, sqlmock.AnyArg(), nil, nil, user.ID).
 		WillReturnResult(sqlmock.NewResult(0, 0))
 
 	// Act
@@ -199,14 +323,16 @@ func TestPostgresUserRepository_Delete(t *testing.T) {
 	defer db.Close()
 
 	sqlxDB := sqlx.NewDb(db, "sqlmock")
-	repo := NewUserRepository(sqlxDB)
+	repo := NewUserRepository(sqlxDB, WithUserGenerators(generate.WithClock(clock.NewFixed(testNow)), generate.WithIDGenerator(idgen.Fixed("event-1"))))
 
-	ctx := context.Background()
+	ctx := tenant.WithTenant(context.Background(), "acme")
 	userID := "user-123"
 
-	// Expected query setup
-	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM users WHERE id = $1`)).
-		WithArgs(userID).
+	// Expected query setup, the event carries the tenant and is the next
+	// of the user's events
+	mock.ExpectExec(`UPDATE users SET deleted_at = \$2[\s\S]+INSERT INTO outbox_sequences[\s\S]+INSERT INTO outbox \(.*aggregate_id, aggregate_seq\)`).
+		WithArgs(userID, testNow, "event-1", domain.EventUserDeleted, "acme",
+			[]byte(`{"id":"user-123","deleted_at":"2024-03-01T12:00:00Z"}`)).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	// Act
@@ -230,8 +356,8 @@ func TestPostgresUserRepository_Delete_NotFound(t *testing.T) {
 	userID := "non-existent-id"
 
 	// Expected query setup
-	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM users WHERE id = $1`)).
-		WithArgs(userID).
+	mock.ExpectExec(`UPDATE users SET deleted_at = \$2[\s\S]+INSERT INTO outbox`).
+		WithArgs(userID, sqlmock.AnyArg(), sqlmock.AnyArg(), domain.EventUserDeleted, "", sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(0, 0))
 
 	// Act
@@ -271,22 +397,526 @@ func TestPostgresUserRepository_List(t *testing.T) {
 	}
 
 	// Expected query setup
-	rows := sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"})
+	rows := sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at", "total"})
 	for _, user := range expectedUsers {
-		rows.AddRow(user.ID, user.Email, user.Name, user.CreatedAt, user.UpdatedAt)
+		rows.AddRow(user.ID, user.Email, user.Name, user.CreatedAt, user.UpdatedAt, 12)
 	}
 
 	mock.ExpectQuery(regexp.QuoteMeta(`
-		SELECT id, email, name, created_at, updated_at
+		SELECT id, email, name, metadata, metadata_encrypted, created_at, updated_at, COUNT(*) OVER () AS total
 		FROM users
+		WHERE deleted_at IS NULL
+		ORDER BY created_at DESC
+		LIMIT $1 OFFSET $2`)).
+		WithArgs(2, 10).
+		WillReturnRows(rows)
+
+	// Act
+	page, err := repo.List(ctx, domain.UserListOptions{PageRequest: domain.PageRequest{Limit: 2, Offset: 10}})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Len(t, page.Users, len(expectedUsers))
+	assert.Equal(t, expectedUsers[0].ID, page.Users[0].ID)
+	assert.Equal(t, int64(12), page.Total, "counts the users of the whole list")
+	assert.Equal(t, 2, page.Limit)
+	assert.Equal(t, 10, page.Offset)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_List_Paging(t *testing.T) {
+	tests := []struct {
+		name string
+		opts domain.UserListOptions
+		// wantSQL is a regexp matching the end of the query
+		wantSQL  string
+		wantArgs []driver.Value
+	}{
+		{
+			name:     "every user without a limit",
+			wantSQL:  `ORDER BY created_at DESC\s+LIMIT \$1 OFFSET \$2$`,
+			wantArgs: []driver.Value{nil, 0},
+		},
+		{
+			name:     "placeholders after the filter",
+			opts:     domain.UserListOptions{PageRequest: domain.PageRequest{Limit: 20, Offset: 40}, Filter: domain.UserFilter{NamePrefix: "Ad"}},
+			wantSQL:  `name \^@ \$1\s+ORDER BY created_at DESC\s+LIMIT \$2 OFFSET \$3$`,
+			wantArgs: []driver.Value{"Ad", 20, 40},
+		},
+		{
+			name:     "oldest first",
+			opts:     domain.UserListOptions{PageRequest: domain.PageRequest{Limit: 20, SortDir: domain.SortAsc}},
+			wantSQL:  `ORDER BY created_at\s+LIMIT \$1 OFFSET \$2$`,
+			wantArgs: []driver.Value{20, 0},
+		},
+		{
+			name:     "names Z to A",
+			opts:     domain.UserListOptions{PageRequest: domain.PageRequest{Limit: 20, SortBy: domain.UserSortName, SortDir: domain.SortDesc}},
+			wantSQL:  `ORDER BY name DESC, id DESC\s+LIMIT \$1 OFFSET \$2$`,
+			wantArgs: []driver.Value{20, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"))
+			mock.ExpectQuery(tt.wantSQL).
+				WithArgs(tt.wantArgs...).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at", "total"}).
+					AddRow("u-1", "ada@example.com", "Ada", testNow, testNow, 1))
+
+			// Act
+			page, err := repo.List(context.Background(), tt.opts)
+
+			// Assert
+			require.NoError(t, err)
+			assert.Len(t, page.Users, 1)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresUserRepository_List_PastTheEnd(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"))
+	mock.ExpectQuery(regexp.QuoteMeta("LIMIT $1 OFFSET $2")).
+		WithArgs(20, 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at", "total"}))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM users WHERE deleted_at IS NULL")).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(42))
+
+	// Act
+	page, err := repo.List(context.Background(), domain.UserListOptions{PageRequest: domain.PageRequest{Limit: 20, Offset: 100}})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Empty(t, page.Users)
+	assert.Equal(t, int64(42), page.Total, "counted apart when no row tells it")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_List_Collation(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	repo := NewUserRepository(sqlxDB)
+
+	ctx := context.Background()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`ORDER BY name COLLATE "de-DE", id`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"}))
+
+	// Act
+	_, err = repo.List(ctx, domain.UserListOptions{PageRequest: domain.PageRequest{SortBy: domain.UserSortName}, Collation: "de-DE"})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_List_IDOrder(t *testing.T) {
+	// IDs generated before the switch to time-ordered IDs stay valid
+	v4, v7 := idgen.UUID.NewID(), idgen.UUIDv7.NewID()
+
+	tests := []struct {
+		name      string
+		opts      domain.UserListOptions
+		wantOrder string
+	}{
+		{name: "creation order", wantOrder: "ORDER BY id DESC"},
+		{name: "name order", opts: domain.UserListOptions{PageRequest: domain.PageRequest{SortBy: domain.UserSortName}}, wantOrder: "ORDER BY name, id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"), WithIDOrder())
+			mock.ExpectQuery(regexp.QuoteMeta(tt.wantOrder)).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"}).
+					AddRow(v7, "new@example.com", "New", testNow, testNow).
+					AddRow(v4, "old@example.com", "Old", testNow, testNow))
+
+			// Act
+			page, err := repo.List(context.Background(), tt.opts)
+
+			// Assert
+			require.NoError(t, err)
+			require.Len(t, page.Users, 2)
+			assert.Equal(t, []string{v7, v4}, []string{page.Users[0].ID, page.Users[1].ID})
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+// uuidOfVersion matches a UUID argument of the version
+type uuidOfVersion uuid.Version
+
+func (v uuidOfVersion) Match(value driver.Value) bool {
+	s, ok := value.(string)
+	if !ok {
+		return false
+	}
+	id, err := uuid.Parse(s)
+	return err == nil && id.Version() == uuid.Version(v)
+}
+
+func TestPostgresUserRepository_Create_UUIDv7(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"), WithUserGenerators(generate.WithIDGenerator(idgen.UUIDv7)))
+	user := &domain.User{Email: "test@example.com", Name: "Test User"}
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO users`)).
+		WithArgs(uuidOfVersion(7), user.Email, "", user.Name, nil, nil, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(idgen.UUIDv7.NewID()))
+
+	// Act
+	err = repo.Create(context.Background(), user)
+
+	// Assert
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_List_Filter(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"))
+	after := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	filter := domain.UserFilter{EmailDomain: "Example.com", NamePrefix: "Ad", CreatedAfter: after}
+
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE deleted_at IS NULL AND lower(split_part(email, '@', 2)) = $1 AND name ^@ $2 AND created_at > $3
 		ORDER BY created_at DESC`)).
+		WithArgs("example.com", "Ad", after, nil, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"}))
+
+	// Act
+	_, err = repo.List(context.Background(), domain.UserListOptions{Filter: filter})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_List_Where(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"))
+	where := &domain.UserOr{
+		Left:  &domain.UserTerm{Field: domain.UserFieldName, Op: domain.CompareEq, Value: "Ada"},
+		Right: &domain.UserTerm{Field: domain.UserFieldEmailDomain, Op: domain.CompareNe, Value: "example.com"},
+	}
+	filter := domain.UserFilter{NamePrefix: "Ad", Where: where}
+
+	// The condition numbers its placeholders after the filter fields and is
+	// grouped so that its OR cannot escape the live users condition
+	mock.ExpectQuery(regexp.QuoteMeta(`WHERE deleted_at IS NULL AND name ^@ $1 AND ((name = $2 OR lower(split_part(email, '@', 2)) <> $3))
+		ORDER BY created_at DESC`)).
+		WithArgs("Ad", "Ada", "example.com", nil, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"}))
+
+	// Act
+	_, err = repo.List(context.Background(), domain.UserListOptions{Filter: filter})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUserConditionSQL(t *testing.T) {
+	day := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	name := func(op domain.Comparison, v string) *domain.UserTerm {
+		return &domain.UserTerm{Field: domain.UserFieldName, Op: op, Value: v}
+	}
+	created := &domain.UserTerm{Field: domain.UserFieldCreatedAt, Op: domain.CompareGte, Value: day}
+
+	tests := []struct {
+		name     string
+		cond     domain.UserCondition
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "comparison",
+			cond:     name(domain.CompareNe, "Ada"),
+			wantSQL:  "name <> $2",
+			wantArgs: []interface{}{"given", "Ada"},
+		},
+		{
+			name:     "precedence",
+			cond:     &domain.UserOr{Left: name(domain.ComparePrefix, "A"), Right: &domain.UserAnd{Left: &domain.UserNot{X: name(domain.CompareEq, "Bob")}, Right: created}},
+			wantSQL:  "(name ^@ $2 OR (NOT name = $3 AND created_at >= $4))",
+			wantArgs: []interface{}{"given", "A", "Bob", day},
+		},
+		{
+			name:     "unknown field",
+			cond:     &domain.UserTerm{Field: "password", Op: domain.CompareEq, Value: "x"},
+			wantSQL:  "FALSE",
+			wantArgs: []interface{}{"given"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			sql, args := userConditionSQL(tt.cond, []interface{}{"given"})
+
+			// Assert
+			assert.Equal(t, tt.wantSQL, sql)
+			assert.Equal(t, tt.wantArgs, args)
+		})
+	}
+}
+
+func TestPostgresUserRepository_BulkSelection(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// Bulk selections never read from the replica, which may lag behind deletions
+	replica, replicaMock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer replica.Close()
+
+	repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"), WithReadReplica(sqlx.NewDb(replica, "sqlmock")))
+	filter := domain.UserFilter{NamePrefix: "Ad"}
+	ctx := context.Background()
+
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM users WHERE deleted_at IS NULL AND name ^@ $1`)).
+		WithArgs("Ad").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	// The limit comes first, the filter numbers its placeholders after it
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT id FROM users WHERE deleted_at IS NULL AND name ^@ $2 ORDER BY id LIMIT $1`)).
+		WithArgs(2, "Ad").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-1").AddRow("user-2"))
+
+	// Act
+	count, countErr := repo.CountUsers(ctx, filter)
+	ids, idsErr := repo.ListUserIDs(ctx, filter, 2)
+
+	// Assert
+	require.NoError(t, countErr)
+	require.NoError(t, idsErr)
+	assert.Equal(t, int64(3), count)
+	assert.Equal(t, []string{"user-1", "user-2"}, ids)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.NoError(t, replicaMock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_ListRecentlySeen(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"))
+	now := time.Now()
+
+	// Users never seen since the column was added come last
+	mock.ExpectQuery(regexp.QuoteMeta(`
+		SELECT id, email, name, metadata, metadata_encrypted, created_at, updated_at
+		FROM users
+		WHERE deleted_at IS NULL
+		ORDER BY last_seen_at DESC NULLS LAST
+		LIMIT $1`)).
+		WithArgs(2).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "name", "created_at", "updated_at"}).
+			AddRow("user-2", "b@example.com", "B", now, now).
+			AddRow("user-1", "a@example.com", "A", now, now))
+
+	// Act
+	users, err := repo.ListRecentlySeen(context.Background(), 2)
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "user-2", users[0].ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_MarkSeen(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"))
+
+	// Marks never move last_seen_at back
+	mock.ExpectExec(regexp.QuoteMeta(`
+		UPDATE users SET last_seen_at = $1
+		WHERE id = $2 AND deleted_at IS NULL AND (last_seen_at IS NULL OR last_seen_at < $1)`)).
+		WithArgs(testNow, "user-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	err = repo.MarkSeen(context.Background(), "user-1", testNow)
+
+	// Assert
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_ListTombstones(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	repo := NewUserRepository(sqlxDB)
+
+	ctx := context.Background()
+	since := time.Now().Add(-time.Hour)
+
+	// Expected query setup: soft-deleted users and purged tombstones
+	rows := sqlmock.NewRows([]string{"id", "deleted_at"}).
+		AddRow("user-1", since.Add(time.Minute)).
+		AddRow("user-2", since.Add(2*time.Minute))
+
+	mock.ExpectQuery(`FROM users[\s\S]+UNION ALL[\s\S]+FROM user_tombstones`).
+		WithArgs(since).
 		WillReturnRows(rows)
 
 	// Act
-	users, err := repo.List(ctx)
+	tombstones, err := repo.ListTombstones(ctx, since)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Len(t, tombstones, 2)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_Purge(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	repo := NewUserRepository(sqlxDB)
+
+	ctx := context.Background()
+	before := time.Now().Add(-24 * time.Hour)
+
+	// Expected query setup: purged rows are moved to the tombstone table
+	mock.ExpectExec(`DELETE FROM users[\s\S]+INSERT INTO user_tombstones`).
+		WithArgs(before).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	// Act
+	purged, err := repo.Purge(ctx, before)
 
 	// Assert
 	assert.NoError(t, err)
-	assert.Len(t, users, len(expectedUsers))
+	assert.Equal(t, int64(3), purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_ExpireTombstones(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	repo := NewUserRepository(sqlxDB)
+
+	ctx := context.Background()
+	horizon := time.Now().Add(-90 * 24 * time.Hour)
+
+	// Expected query setup
+	mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM user_tombstones WHERE deleted_at < $1`)).
+		WithArgs(horizon).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Act
+	expired, err := repo.ExpireTombstones(ctx, horizon)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), expired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_ConfirmEmailChange_Conflict(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	repo := NewUserRepository(sqlxDB)
+
+	ctx := context.Background()
+	change := &domain.EmailChange{UserID: "user-123", TokenHash: "hash"}
+
+	// The users email constraint rejects the update at confirm time
+	mock.ExpectExec(`DELETE FROM email_change_requests[\s\S]+UPDATE users`).
+		WithArgs(change.UserID, change.TokenHash, sqlmock.AnyArg()).
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "users_email_live_key"})
+
+	// Act
+	err = repo.ConfirmEmailChange(ctx, change)
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrEmailTaken)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresUserRepository_CreateEmailChange_SequencesEvents(t *testing.T) {
+	// Arrange
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	repo := NewUserRepository(sqlx.NewDb(db, "sqlmock"))
+	change := &domain.EmailChange{UserID: "user-123", NewEmail: "new@example.com", TokenHash: "hash", ExpiresAt: testNow.Add(time.Hour), CreatedAt: testNow}
+	events := []*domain.OutboxEvent{
+		{ID: "event-1", Type: domain.EventEmailChangeRequested, Payload: []byte(`{}`), Secret: []byte(`{"token":"t"}`)},
+		{ID: "event-2", Type: domain.EventEmailChangeNotice, Payload: []byte(`{}`)},
+	}
+
+	// Both events are numbered from the last number of the user, in order
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT user_id::text, 2, $5::timestamptz FROM req`)+`[\s\S]+`+
+		regexp.QuoteMeta(`s.seq - 2 + v.ord FROM seqs s`)+`[\s\S]+`+
+		regexp.QuoteMeta(`($6::uuid, $7, $8, $9::jsonb, $10::jsonb, 1), ($11::uuid, $12, $13, $14::jsonb, $15::jsonb, 2)`)).
+		WithArgs(change.UserID, change.NewEmail, change.TokenHash, change.ExpiresAt, change.CreatedAt,
+			"event-1", domain.EventEmailChangeRequested, "", []byte(`{}`), []byte(`{"token":"t"}`),
+			"event-2", domain.EventEmailChangeNotice, "", []byte(`{}`), nil).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	// Act
+	err = repo.CreateEmailChange(context.Background(), change, events)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "user-123", events[0].AggregateID)
+	assert.Equal(t, "user-123", events[1].AggregateID)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/romanitalian/carch-go/internal/pkg/explain"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/pkg/querybudget"
+	"github.com/romanitalian/carch-go/internal/pkg/queryreg"
+	"github.com/romanitalian/carch-go/internal/pkg/timing"
+)
+
+// maxStatementDetail bounds how much of a statement is kept in timing phases
+const maxStatementDetail = 120
+
+// Querier is the subset of sqlx.DB used by repositories
+type Querier interface {
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// registerQuery names a statement of the repositories in queryreg.Default,
+// origin being the method issuing it
+func registerQuery(name, origin, sql string) string {
+	return queryreg.Register(name, "repository."+origin, sql)
+}
+
+// InstrumentedQuerier counts every statement against the request budget
+// attached to the context and under its registered name, and times it as a
+// "db" phase before delegating to the wrapped Querier. With an explain
+// sampler, a share of its reads is explained afterwards, see package explain.
+type InstrumentedQuerier struct {
+	q       Querier
+	sampler *explain.Sampler
+}
+
+// QuerierOption configures an InstrumentedQuerier
+type QuerierOption func(*InstrumentedQuerier)
+
+// WithExplainSampler explains the reads s samples. It is ignored for
+// transactions, as explaining runs the statement a second time.
+func WithExplainSampler(s *explain.Sampler) QuerierOption {
+	return func(i *InstrumentedQuerier) {
+		i.sampler = s
+	}
+}
+
+// NewInstrumentedQuerier wraps a Querier with statement accounting
+func NewInstrumentedQuerier(q Querier, opts ...QuerierOption) *InstrumentedQuerier {
+	i := &InstrumentedQuerier{q: q}
+	for _, opt := range opts {
+		opt(i)
+	}
+	switch q.(type) {
+	case *sqlx.Tx:
+		i.sampler = nil
+	}
+	return i
+}
+
+func (i *InstrumentedQuerier) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if err := querybudget.Inc(ctx); err != nil {
+		return err
+	}
+	name := observeStatement(query)
+	span := startStatement(ctx, query)
+	err := i.q.GetContext(ctx, dest, query, args...)
+	span.End()
+	if err == nil {
+		i.explain(ctx, name, query, args)
+	}
+	return err
+}
+
+func (i *InstrumentedQuerier) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	if err := querybudget.Inc(ctx); err != nil {
+		return err
+	}
+	name := observeStatement(query)
+	span := startStatement(ctx, query)
+	err := i.q.SelectContext(ctx, dest, query, args...)
+	span.End()
+	if err == nil {
+		i.explain(ctx, name, query, args)
+	}
+	return err
+}
+
+// explain runs a read the sampler samples again as EXPLAIN ANALYZE and
+// reports its plan. The explain is timed as a "db" phase of its own but
+// neither counts against the request budget nor under the statement name,
+// see metrics.DBExplainSamples.
+func (i *InstrumentedQuerier) explain(ctx context.Context, name, query string, args []interface{}) {
+	if !i.sampler.Sample() || !explain.ReadOnly(query) {
+		return
+	}
+
+	explained := explain.Prefix + query
+	span := startStatement(ctx, explained)
+	var plan []byte
+	err := i.q.GetContext(ctx, &plan, explained, args...)
+	span.End()
+	i.sampler.Report(ctx, name, plan, err)
+}
+
+func (i *InstrumentedQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if err := querybudget.Inc(ctx); err != nil {
+		return nil, err
+	}
+	observeStatement(query)
+	defer startStatement(ctx, query).End()
+	return i.q.ExecContext(ctx, query, args...)
+}
+
+// observeStatement counts a statement under its registered name, which it
+// returns
+func observeStatement(query string) string {
+	now := time.Now()
+	name := queryreg.Default.Observe(query, now)
+	metrics.DBQueries.WithLabelValues(name).Inc()
+	metrics.DBQueryLastCalled.WithLabelValues(name).Set(float64(now.UnixNano()) / 1e9)
+	return name
+}
+
+// startStatement times a statement when the request is traced. The query is
+// only shortened then, untraced requests skip the work.
+func startStatement(ctx context.Context, query string) timing.Span {
+	if timing.FromContext(ctx) == nil {
+		return timing.Span{}
+	}
+
+	detail := strings.Join(strings.Fields(query), " ")
+	if len(detail) > maxStatementDetail {
+		detail = detail[:maxStatementDetail] + "..."
+	}
+	return timing.Start(ctx, "db", detail)
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/explain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/pkg/querybudget"
+)
+
+// explainedPlan is a plan above the default cost threshold
+const explainedPlan = `[{"Plan": {"Node Type": "Seq Scan", "Total Cost": 4200.5}, "Planning Time": 0.1, "Execution Time": 12.5}]`
+
+// newSampledQuerier wraps a mocked database with a sampler of the given rate
+func newSampledQuerier(t *testing.T, rate float64) (*InstrumentedQuerier, *sqlx.DB, sqlmock.Sqlmock, *bytes.Buffer) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	var logs bytes.Buffer
+	sampler := explain.NewSampler(rate, logger.New(logger.WithOutput(&logs)))
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	return NewInstrumentedQuerier(sqlxDB, WithExplainSampler(sampler)), sqlxDB, mock, &logs
+}
+
+func TestInstrumentedQuerier_ExplainSampled(t *testing.T) {
+	// Arrange
+	q, _, mock, logs := newSampledQuerier(t, 1)
+	query := registerQuery("test.explain_sampled", "test", " SELECT name FROM users WHERE id = $1")
+	mock.ExpectQuery(query).WithArgs("user-1").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ada"))
+	mock.ExpectQuery(explain.Prefix + query).WithArgs("user-1").
+		WillReturnRows(sqlmock.NewRows([]string{"QUERY PLAN"}).AddRow([]byte(explainedPlan)))
+	ctx, budget := querybudget.WithBudget(context.Background(), 0, false)
+
+	// Act
+	var name string
+	err := q.GetContext(ctx, &name, query, "user-1")
+
+	// Assert
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, "Ada", name)
+	assert.Equal(t, 1, budget.Count(), "the explain does not count against the budget")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.DBQueries.WithLabelValues("test.explain_sampled")),
+		"the explain is not counted as a run of the statement")
+	assert.Equal(t, 1.0, testutil.ToFloat64(metrics.DBExplainSamples.WithLabelValues("test.explain_sampled", explain.ResultLogged)))
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "Sampled query plan", entry["message"])
+	assert.Equal(t, "test.explain_sampled", entry["query"])
+	assert.Equal(t, 4200.5, entry["cost"])
+	assert.Equal(t, 12.5, entry["execution_ms"])
+	assert.NotNil(t, entry["plan"])
+}
+
+func TestInstrumentedQuerier_ExplainExclusions(t *testing.T) {
+	tests := []struct {
+		name string
+		rate float64
+		run  func(ctx context.Context, q Querier, mock sqlmock.Sqlmock) error
+	}{
+		{
+			name: "sampling disabled",
+			run: func(ctx context.Context, q Querier, mock sqlmock.Sqlmock) error {
+				mock.ExpectQuery("SELECT name FROM users").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ada"))
+				var names []string
+				return q.SelectContext(ctx, &names, "SELECT name FROM users")
+			},
+		},
+		{
+			name: "exec",
+			rate: 1,
+			run: func(ctx context.Context, q Querier, mock sqlmock.Sqlmock) error {
+				mock.ExpectExec("SELECT pg_notify('jobs', $1)").WithArgs("job-1").WillReturnResult(sqlmock.NewResult(0, 0))
+				_, err := q.ExecContext(ctx, "SELECT pg_notify('jobs', $1)", "job-1")
+				return err
+			},
+		},
+		{
+			name: "mutation returning rows",
+			rate: 1,
+			run: func(ctx context.Context, q Querier, mock sqlmock.Sqlmock) error {
+				mock.ExpectQuery("INSERT INTO users (id) VALUES ($1) RETURNING id").WithArgs("user-1").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow("user-1"))
+				var id string
+				return q.GetContext(ctx, &id, "INSERT INTO users (id) VALUES ($1) RETURNING id", "user-1")
+			},
+		},
+		{
+			name: "locking read",
+			rate: 1,
+			run: func(ctx context.Context, q Querier, mock sqlmock.Sqlmock) error {
+				mock.ExpectQuery("SELECT id FROM jobs FOR UPDATE SKIP LOCKED").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				var ids []string
+				return q.SelectContext(ctx, &ids, "SELECT id FROM jobs FOR UPDATE SKIP LOCKED")
+			},
+		},
+		{
+			name: "failed read",
+			rate: 1,
+			run: func(ctx context.Context, q Querier, mock sqlmock.Sqlmock) error {
+				mock.ExpectQuery("SELECT name FROM users").WillReturnError(context.DeadlineExceeded)
+				var names []string
+				if err := q.SelectContext(ctx, &names, "SELECT name FROM users"); err != context.DeadlineExceeded {
+					return err
+				}
+				return nil
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			q, _, mock, logs := newSampledQuerier(t, tt.rate)
+
+			// Act
+			err := tt.run(context.Background(), q, mock)
+
+			// Assert: an explain would be an unexpected query
+			require.NoError(t, err)
+			require.NoError(t, mock.ExpectationsWereMet())
+			assert.Empty(t, logs.String())
+		})
+	}
+}
+
+func TestInstrumentedQuerier_ExplainNotInTransaction(t *testing.T) {
+	// Arrange
+	_, db, mock, logs := newSampledQuerier(t, 1)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT name FROM users").WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Ada"))
+	tx, err := db.Beginx()
+	require.NoError(t, err)
+	sampler := explain.NewSampler(1, logger.New(logger.WithOutput(logs)))
+	q := NewInstrumentedQuerier(tx, WithExplainSampler(sampler))
+
+	// Act
+	var names []string
+	err = q.SelectContext(context.Background(), &names, "SELECT name FROM users")
+
+	// Assert
+	require.NoError(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, []string{"Ada"}, names)
+	assert.Empty(t, logs.String())
+}
@@ -1,132 +1,717 @@
 package repository
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
 
-	"github.com/streadway/amqp"
+	amqp091 "github.com/rabbitmq/amqp091-go"
 
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/backoff"
 	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/pkg/sanitize"
+	"github.com/romanitalian/carch-go/internal/pkg/timing"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
 )
 
+// TasksQueue is the queue background tasks are consumed from, unless
+// RabbitMQConfig.TaskQueue names another one
+const TasksQueue = "tasks"
+
+// EventsExchange is the topic exchange domain events are published to,
+// routed by event type
+const EventsExchange = "events"
+
+// taskBindings route verified webhooks, enqueued jobs and the retries of
+// user deletion hooks from the events exchange to the task queue, where the
+// worker dispatches them by type
+var taskBindings = []string{"webhook.#", "job.#", domain.EventUserCleanup + ".#"}
+
+// taskQueueBindings binds the task queue to the events exchange, with the
+// suffix of a tenant partition when there is one
+func taskQueueBindings(suffix string) []QueueBinding {
+	bindings := make([]QueueBinding, 0, len(taskBindings))
+	for _, key := range taskBindings {
+		bindings = append(bindings, QueueBinding{Exchange: EventsExchange, Key: key + suffix})
+	}
+	return bindings
+}
+
+// Defaults for RabbitMQConfig
+const (
+	defaultReconnectDelay    = time.Second
+	defaultReconnectMaxDelay = 30 * time.Second
+)
+
+// ErrBrokerUnavailable is returned by Publish while the connection is being re-established
+var ErrBrokerUnavailable = errors.New("rabbitmq is not connected")
+
+// amqpConnection is a broker connection of the AMQP driver in use
+type amqpConnection interface {
+	Channel() (amqpChannel, error)
+	// NotifyClose returns a channel receiving the error the connection was
+	// closed with. It is closed without a value on a graceful Close.
+	NotifyClose() <-chan error
+	Close() error
+}
+
+// amqpChannel is a channel of the AMQP driver in use
+type amqpChannel interface {
+	Qos(prefetchCount int) error
+	QueueDeclare(spec QueueSpec) error
+	// QueueInspect declares the queue passively, failing when it does not exist
+	QueueInspect(name string) (QueueState, error)
+	ExchangeDeclare(name, kind string, durable bool) error
+	QueueBind(name, key, exchange string) error
+	// QueueUnbind removes the binding, succeeding when there is none
+	QueueUnbind(name, key, exchange string) error
+	// Consume delivers messages of the queue to consumer with manual
+	// acknowledgement until the channel closes or the consumer is cancelled
+	Consume(queue, consumer string) (<-chan messaging.Delivery, error)
+	// Cancel stops the deliveries to consumer. Its delivery channel is
+	// closed once the messages already sent are delivered.
+	Cancel(consumer string) error
+	Publish(ctx context.Context, exchange, key string, msg messaging.Publishing) error
+	NotifyClose() <-chan error
+	Close() error
+}
+
+// amqpDialer opens broker connections, replaced by a fake in tests
+type amqpDialer func(url string) (amqpConnection, error)
+
+// Names of the AMQP drivers for RabbitMQConfig.Driver
+const (
+	DriverAMQP091   = "amqp091"
+	DriverStreadway = "streadway"
+)
+
+// amqpDrivers are the available AMQP client libraries. streadway/amqp is
+// unmaintained and kept only until the migration to amqp091-go is complete.
+var amqpDrivers = map[string]amqpDialer{
+	DriverAMQP091:   dialAMQP091,
+	DriverStreadway: dialStreadway,
+}
+
+// RabbitMQ is a broker client that re-establishes its connection when the
+// broker goes away. Lifecycle events are logged with an "event" field:
+// connected, channel_closed, reconnect_attempt and reconnected.
 type RabbitMQ struct {
-	conn    *amqp.Connection
-	channel *amqp.Channel
-	log     *logger.Logger
+	cfg  RabbitMQConfig
+	dial amqpDialer
+	log  *logger.Logger
+
+	mu      sync.RWMutex
+	conn    amqpConnection
+	channel amqpChannel
+	// connected is closed once the current connection is up and replaced
+	// when it is lost, consumers wait on it to resubscribe
+	connected chan struct{}
+	up        bool
+	lastError string
+	// node is the URL of the broker node last connected to, tried first on
+	// reconnect
+	node string
+	// queues maps queue names to their physical queues, see declareTopology
+	queues map[string][]string
+
+	// consumers are the tags of the consumers started by Consume, stopping
+	// is closed by StopConsuming
+	consumers   map[string]bool
+	consumerSeq int
+	stopping    chan struct{}
+	stopOnce    sync.Once
+
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 type RabbitMQConfig struct {
-	URL    string
-	Logger *logger.Logger
+	// URLs are the nodes of the broker, tried in random order until one
+	// accepts the connection, see dialNodes
+	URLs []string
+	// Driver is the AMQP client library, DriverAMQP091 by default
+	Driver string
+	// Prefetch is how many unacknowledged deliveries a consumer may hold, 0 is unlimited
+	Prefetch int
+	// TaskQueue is the name of the task queue, TasksQueue by default
+	TaskQueue string
+	// DriftPolicy handles queues declared with other arguments, DriftFail by default
+	DriftPolicy string
+	// ReconnectDelay is the wait before the first reconnect attempt, doubled
+	// after every failed attempt up to ReconnectMaxDelay
+	ReconnectDelay    time.Duration
+	ReconnectMaxDelay time.Duration
+	// TenantRouting partitions events by tenant, nil routes them by type only
+	TenantRouting *TenantRouting
+	Logger        *logger.Logger
 }
 
 func NewRabbitMQ(cfg RabbitMQConfig) (*RabbitMQ, error) {
-	// Connecting to RabbitMQ
-	if cfg.Logger != nil {
-		cfg.Logger.Info("Connecting to RabbitMQ", map[string]interface{}{"url": cfg.URL})
+	if cfg.Driver == "" {
+		cfg.Driver = DriverAMQP091
+	}
+	dial, ok := amqpDrivers[cfg.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown AMQP driver %q", cfg.Driver)
+	}
+	return newRabbitMQ(cfg, dial)
+}
+
+func newRabbitMQ(cfg RabbitMQConfig, dial amqpDialer) (*RabbitMQ, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = logger.New(logger.WithOutput(io.Discard))
+	}
+	if len(cfg.URLs) == 0 {
+		return nil, errors.New("no RabbitMQ URL")
+	}
+	if cfg.TaskQueue == "" {
+		cfg.TaskQueue = TasksQueue
+	}
+	if cfg.ReconnectDelay <= 0 {
+		cfg.ReconnectDelay = defaultReconnectDelay
+	}
+	if cfg.ReconnectMaxDelay < cfg.ReconnectDelay {
+		cfg.ReconnectMaxDelay = max(defaultReconnectMaxDelay, cfg.ReconnectDelay)
 	}
+	switch cfg.DriftPolicy {
+	case "":
+		cfg.DriftPolicy = DriftFail
+	case DriftFail, DriftPassive, DriftVersioned:
+	default:
+		return nil, fmt.Errorf("unknown topology drift policy %q", cfg.DriftPolicy)
+	}
+
+	r := &RabbitMQ{
+		cfg:       cfg,
+		dial:      dial,
+		log:       cfg.Logger,
+		connected: make(chan struct{}),
+		consumers: make(map[string]bool),
+		stopping:  make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	// Connecting to RabbitMQ
+	r.log.Info("Connecting to RabbitMQ", map[string]interface{}{"urls": redactURLs(cfg.URLs)})
 
-	conn, err := amqp.Dial(cfg.URL)
+	connClosed, chClosed, err := r.connect()
 	if err != nil {
-		if cfg.Logger != nil {
-			cfg.Logger.Error("Failed to connect to RabbitMQ", err, map[string]interface{}{"url": cfg.URL})
-		}
+		err = sanitize.Error(err)
+		r.log.Error("Failed to connect to RabbitMQ", err, map[string]interface{}{"urls": redactURLs(cfg.URLs)})
 		return nil, err
 	}
 
-	// Creating channel
-	if cfg.Logger != nil {
-		cfg.Logger.Info("Creating RabbitMQ channel", nil)
+	r.log.Info("RabbitMQ connected", map[string]interface{}{"event": "connected", "node": r.Node()})
+	metrics.RabbitMQConnected.Set(1)
+
+	go r.supervise(connClosed, chClosed)
+
+	return r, nil
+}
+
+// connect connects to the first node that accepts, see dialNodes. It
+// returns the notifications of the connection and channel closing.
+func (r *RabbitMQ) connect() (<-chan error, <-chan error, error) {
+	var errs []error
+	for _, node := range r.dialOrder() {
+		connClosed, chClosed, err := r.connectTo(node)
+		if err == nil {
+			return connClosed, chClosed, nil
+		}
+		if len(r.cfg.URLs) == 1 {
+			return nil, nil, err
+		}
+		r.log.Warn("Failed to connect to RabbitMQ node", map[string]interface{}{
+			"node":  nodeName(node),
+			"error": sanitize.String(err.Error()),
+		})
+		errs = append(errs, fmt.Errorf("%s: %w", nodeName(node), err))
 	}
+	return nil, nil, errors.Join(errs...)
+}
 
-	ch, err := conn.Channel()
+// connectTo dials the node, opens the channel and declares the topology
+func (r *RabbitMQ) connectTo(node string) (<-chan error, <-chan error, error) {
+	conn, err := r.dial(node)
 	if err != nil {
-		conn.Close()
-		if cfg.Logger != nil {
-			cfg.Logger.Error("Failed to create RabbitMQ channel", err, nil)
-		}
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Declaring queue
-	queueName := "tasks"
-	if cfg.Logger != nil {
-		cfg.Logger.Info("Declaring RabbitMQ queue", map[string]interface{}{"queue": queueName})
+	// Creating channel
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to create channel: %w", err)
 	}
 
-	_, err = ch.QueueDeclare(
-		queueName, // queue name
-		true,      // durable
-		false,     // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
-	)
+	queues, err := declareTopology(conn, ch, QueuesFor(r.cfg.TaskQueue, r.cfg.TenantRouting), r.cfg.Prefetch, r.cfg.DriftPolicy, r.log)
 	if err != nil {
 		ch.Close()
 		conn.Close()
-		if cfg.Logger != nil {
-			cfg.Logger.Error("Failed to declare RabbitMQ queue", err, map[string]interface{}{"queue": queueName})
+		return nil, nil, err
+	}
+
+	connClosed := conn.NotifyClose()
+	chClosed := ch.NotifyClose()
+
+	r.mu.Lock()
+	r.conn = conn
+	r.channel = ch
+	// The consumers of the previous channel are gone with it
+	clear(r.consumers)
+	r.queues = queues
+	r.up = true
+	r.node = node
+	close(r.connected)
+	r.mu.Unlock()
+	setConnectedNode(node)
+
+	return connClosed, chClosed, nil
+}
+
+// supervise waits for the connection or channel to close and reconnects
+// with exponential backoff until Close is called
+func (r *RabbitMQ) supervise(connClosed, chClosed <-chan error) {
+	for {
+		var closeErr error
+		select {
+		case <-r.done:
+			return
+		case closeErr = <-connClosed:
+		case closeErr = <-chClosed:
+		}
+
+		select {
+		case <-r.done:
+			// Closed by Close, not by the broker
+			return
+		default:
+		}
+
+		disconnectedAt := time.Now()
+		reason := "closed"
+		if closeErr != nil {
+			reason = closeErr.Error()
+		}
+		r.disconnect(reason)
+		r.log.Warn("RabbitMQ channel closed", map[string]interface{}{
+			"event": "channel_closed",
+			"error": reason,
+		})
+
+		var ok bool
+		connClosed, chClosed, ok = r.reconnect(disconnectedAt, closeErr)
+		if !ok {
+			return
 		}
-		return nil, err
 	}
+}
+
+// disconnect marks the client as down and releases the broken connection
+func (r *RabbitMQ) disconnect(reason string) {
+	metrics.RabbitMQConnected.Set(0)
+	setConnectedNode("")
+
+	r.mu.Lock()
+	conn, ch := r.conn, r.channel
+	r.up = false
+	r.lastError = sanitize.String(reason)
+	r.connected = make(chan struct{})
+	r.mu.Unlock()
+
+	ch.Close()
+	conn.Close()
+}
 
-	if cfg.Logger != nil {
-		cfg.Logger.Info("Successfully connected to RabbitMQ", nil)
+// reconnect retries connecting until it succeeds or Close is called
+func (r *RabbitMQ) reconnect(disconnectedAt time.Time, closeErr error) (<-chan error, <-chan error, bool) {
+	retry := r.reconnectBackoff(backoff.WithOnRetry(func(rt backoff.Retry) {
+		r.log.Info("Reconnecting to RabbitMQ", map[string]interface{}{
+			"event":    "reconnect_attempt",
+			"attempt":  rt.Attempt,
+			"delay_ms": rt.Delay.Milliseconds(),
+		})
+	}))
+	// The lost connection counts as the first failure, so the first
+	// reconnect attempt already waits
+	err := closeErr
+	for {
+		delay, _ := retry.Next(err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-r.done:
+			timer.Stop()
+			return nil, nil, false
+		case <-timer.C:
+		}
+
+		var connClosed, chClosed <-chan error
+		connClosed, chClosed, err = r.connect()
+		if err == nil {
+			downtime := time.Since(disconnectedAt)
+			metrics.RabbitMQConnected.Set(1)
+			metrics.RabbitMQReconnects.Inc()
+			metrics.RabbitMQLastDowntime.Set(downtime.Seconds())
+			r.log.Info("RabbitMQ reconnected", map[string]interface{}{
+				"event":       "reconnected",
+				"attempt":     retry.Attempts(),
+				"node":        r.Node(),
+				"downtime_ms": downtime.Milliseconds(),
+			})
+			return connClosed, chClosed, true
+		}
+
+		r.mu.Lock()
+		r.lastError = sanitize.String(err.Error())
+		r.mu.Unlock()
+		r.log.Warn("Failed to reconnect to RabbitMQ", map[string]interface{}{
+			"attempt": retry.Attempts(),
+			"error":   err.Error(),
+		})
 	}
+}
 
-	return &RabbitMQ{
-		conn:    conn,
-		channel: ch,
-		log:     cfg.Logger,
-	}, nil
+// reconnectBackoff returns the policy of reconnects and resubscriptions,
+// which retry until Close is called
+func (r *RabbitMQ) reconnectBackoff(opts ...backoff.Option) *backoff.Backoff {
+	return backoff.New(r.cfg.ReconnectDelay, r.cfg.ReconnectMaxDelay, opts...)
+}
+
+// Connected reports whether the client currently has a broker connection
+func (r *RabbitMQ) Connected() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.up
+}
+
+// LastError returns why the connection was last lost or failed to reconnect
+func (r *RabbitMQ) LastError() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.lastError
 }
 
 func (r *RabbitMQ) Close() error {
-	if r.log != nil {
-		r.log.Info("Closing RabbitMQ connection", nil)
-	}
+	r.log.Info("Closing RabbitMQ connection", nil)
 
-	if err := r.channel.Close(); err != nil {
-		if r.log != nil {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.done)
+
+		r.mu.Lock()
+		conn, ch, up := r.conn, r.channel, r.up
+		r.up = false
+		r.mu.Unlock()
+		metrics.RabbitMQConnected.Set(0)
+		setConnectedNode("")
+
+		if !up {
+			return
+		}
+		if err = ch.Close(); err != nil {
 			r.log.Error("Failed to close RabbitMQ channel", err, nil)
+			return
 		}
-		return err
+		if err = conn.Close(); err != nil {
+			r.log.Error("Failed to close RabbitMQ connection", err, nil)
+			return
+		}
+
+		r.log.Info("RabbitMQ connection closed successfully", nil)
+	})
+
+	return err
+}
+
+// Consume delivers messages from the queue until Close is called. After a
+// reconnect the subscription is renewed on the new channel; deliveries
+// received before it can no longer be acknowledged and are redelivered.
+// While a queue moves to a versioned name, both queues are consumed.
+func (r *RabbitMQ) Consume(queueName string) (<-chan messaging.Delivery, error) {
+	r.log.Info("Starting to consume from queue", map[string]interface{}{"queue": queueName})
+
+	physical := r.physicalQueues(queueName)
+	subscriptions := make([]<-chan messaging.Delivery, len(physical))
+	for i, name := range physical {
+		deliveries, err := r.consume(name)
+		if err != nil {
+			r.log.Error("Failed to consume from queue", err, map[string]interface{}{"queue": name})
+			return nil, err
+		}
+		subscriptions[i] = deliveries
 	}
 
-	if err := r.conn.Close(); err != nil {
-		if r.log != nil {
-			r.log.Error("Failed to close RabbitMQ connection", err, nil)
+	out := make(chan messaging.Delivery)
+	var wg sync.WaitGroup
+	for i, name := range physical {
+		wg.Add(1)
+		go func(name string, deliveries <-chan messaging.Delivery) {
+			defer wg.Done()
+			r.forward(queueName, name, deliveries, out)
+		}(name, subscriptions[i])
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	return out, nil
+}
+
+// forward passes deliveries of the physical queue to out, resubscribing
+// after every reconnect until Close is called or the queue is no longer in use
+func (r *RabbitMQ) forward(queueName, physical string, deliveries <-chan messaging.Delivery, out chan<- messaging.Delivery) {
+	for {
+		for d := range deliveries {
+			d.Queue = physical
+			select {
+			case out <- d:
+			case <-r.done:
+				return
+			}
+		}
+
+		if deliveries = r.resubscribe(queueName, physical); deliveries == nil {
+			return
+		}
+	}
+}
+
+// physicalQueues returns the queues declared for queueName, the queue itself
+// when it is not in the registry
+func (r *RabbitMQ) physicalQueues(queueName string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if names, ok := r.queues[queueName]; ok {
+		return names
+	}
+	return []string{queueName}
+}
+
+// resubscribe waits for the connection to come back and consumes the queue
+// again. It returns nil once the client is closed or stopped consuming, or
+// the queue was dropped from the topology, e.g. a drained queue deleted
+// after a transition.
+func (r *RabbitMQ) resubscribe(queueName, physical string) <-chan messaging.Delivery {
+	retry := r.reconnectBackoff()
+	for {
+		r.mu.RLock()
+		connected := r.connected
+		r.mu.RUnlock()
+
+		// A consumer cancelled by StopConsuming ends like a lost one, while
+		// the client may still be connected
+		select {
+		case <-r.stopping:
+			return nil
+		default:
+		}
+		select {
+		case <-r.done:
+			return nil
+		case <-r.stopping:
+			return nil
+		case <-connected:
 		}
+
+		if !slices.Contains(r.physicalQueues(queueName), physical) {
+			r.log.Info("Stopped consuming from queue no longer in use", map[string]interface{}{"queue": physical})
+			return nil
+		}
+
+		deliveries, err := r.consume(physical)
+		if err == nil {
+			r.log.Info("Resumed consuming from queue", map[string]interface{}{"queue": physical})
+			return deliveries
+		}
+
+		r.log.Warn("Failed to resume consuming from queue", map[string]interface{}{
+			"queue": physical,
+			"error": err.Error(),
+		})
+		// The channel broke again, give the supervisor time to replace it
+		delay, _ := retry.Next(err)
+		select {
+		case <-r.done:
+			return nil
+		case <-r.stopping:
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// consume starts a consumer of the queue with a tag of its own, which
+// StopConsuming cancels
+func (r *RabbitMQ) consume(queueName string) (<-chan messaging.Delivery, error) {
+	r.mu.Lock()
+	ch, up := r.channel, r.up
+	r.consumerSeq++
+	tag := fmt.Sprintf("%s.%d", queueName, r.consumerSeq)
+	r.mu.Unlock()
+	if !up {
+		return nil, ErrBrokerUnavailable
+	}
+
+	deliveries, err := ch.Consume(queueName, tag)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.consumers[tag] = true
+	r.mu.Unlock()
+	return deliveries, nil
+}
+
+// StopConsuming cancels the consumers started by Consume, so that the broker
+// stops sending messages while those already sent can still be settled. The
+// delivery channels of Consume are closed once these are passed on, and are
+// not renewed after a reconnect. Consumers of a lost connection are gone
+// with it, their messages are redelivered.
+func (r *RabbitMQ) StopConsuming() error {
+	r.stopOnce.Do(func() {
+		close(r.stopping)
+	})
+
+	r.mu.Lock()
+	ch, up := r.channel, r.up
+	tags := make([]string, 0, len(r.consumers))
+	for tag := range r.consumers {
+		tags = append(tags, tag)
+	}
+	clear(r.consumers)
+	r.mu.Unlock()
+	if !up {
+		return nil
+	}
+
+	var errs []error
+	for _, tag := range tags {
+		if err := ch.Cancel(tag); err != nil {
+			errs = append(errs, fmt.Errorf("failed to cancel consumer %s: %w", tag, err))
+		}
+	}
+	r.log.Info("Stopped consuming", map[string]interface{}{"consumers": len(tags)})
+	return errors.Join(errs...)
+}
+
+// Publish sends a domain event to the events exchange using its type as
+// routing key, suffixed with the tenant's partition with tenant routing
+func (r *RabbitMQ) Publish(ctx context.Context, event *domain.OutboxEvent) error {
+	return r.publish(ctx, EventsExchange, r.cfg.TenantRouting.routingKey(event.Type, event.Tenant), event)
+}
+
+// PublishToQueue sends the event straight to the queue through the default
+// exchange, bypassing the bindings of EventsExchange. The broker drops
+// messages to a queue that does not exist, see InspectQueue.
+func (r *RabbitMQ) PublishToQueue(ctx context.Context, queue string, event *domain.OutboxEvent) error {
+	return r.publish(ctx, "", queue, event)
+}
+
+// Retry publishes a failed delivery again to the queue it was consumed
+// from, with the failed attempts counted, see messaging.Delivery.Retry. The
+// caller acknowledges the delivery once the retry is published.
+func (r *RabbitMQ) Retry(ctx context.Context, msg messaging.Delivery) error {
+	r.mu.RLock()
+	ch, up := r.channel, r.up
+	r.mu.RUnlock()
+	if !up {
+		return ErrBrokerUnavailable
+	}
+
+	if err := ch.Publish(ctx, "", msg.Queue, msg.Retry()); err != nil {
+		r.log.Error("Failed to publish message retry", err, map[string]interface{}{
+			"message_id": msg.MessageID,
+			"type":       msg.Type,
+			"queue":      msg.Queue,
+		})
 		return err
 	}
+	return nil
+}
 
-	if r.log != nil {
-		r.log.Info("RabbitMQ connection closed successfully", nil)
+func (r *RabbitMQ) publish(ctx context.Context, exchange, key string, event *domain.OutboxEvent) error {
+	defer timing.Start(ctx, "amqp.publish", event.Type).End()
+
+	r.mu.RLock()
+	ch, up := r.channel, r.up
+	r.mu.RUnlock()
+	if !up {
+		return ErrBrokerUnavailable
+	}
+
+	msg := messaging.Publishing{
+		ContentType: "application/json",
+		Persistent:  true,
+		MessageID:   event.ID,
+		Type:        event.Type,
+		Timestamp:   event.CreatedAt,
+		Body:        event.Payload,
+	}
+	if event.Tenant != "" {
+		msg.Headers = messaging.Headers{messaging.TenantHeader: event.Tenant}
+	}
+	if event.AggregateID != "" {
+		if msg.Headers == nil {
+			msg.Headers = messaging.Headers{}
+		}
+		msg.Headers[messaging.AggregateHeader] = event.AggregateID
+		msg.Headers[messaging.SequenceHeader] = event.AggregateSeq
 	}
 
+	err := ch.Publish(ctx, exchange, key, msg)
+
+	if err != nil {
+		r.log.Error("Failed to publish event", err, map[string]interface{}{
+			"event_id":    event.ID,
+			"event_type":  event.Type,
+			"tenant":      event.Tenant,
+			"routing_key": key,
+		})
+		return err
+	}
+
+	wideevent.Add(ctx, wideevent.EventsPublished, 1)
 	return nil
 }
 
-func (r *RabbitMQ) Consume(queueName string) (<-chan amqp.Delivery, error) {
-	if r.log != nil {
-		r.log.Info("Starting to consume from queue", map[string]interface{}{"queue": queueName})
+// InspectQueue returns the state of an existing queue and fails when the
+// queue does not exist
+func (r *RabbitMQ) InspectQueue(name string) (QueueState, error) {
+	r.mu.RLock()
+	conn, up := r.conn, r.up
+	r.mu.RUnlock()
+	if !up {
+		return QueueState{}, ErrBrokerUnavailable
 	}
 
-	msgs, err := r.channel.Consume(
-		queueName, // queue
-		"",        // consumer
-		false,     // auto-ack
-		false,     // exclusive
-		false,     // no-local
-		false,     // no-wait
-		nil,       // args
-	)
+	return inspectQueue(conn, name)
+}
 
-	if err != nil && r.log != nil {
-		r.log.Error("Failed to consume from queue", err, map[string]interface{}{"queue": queueName})
+// redactURL hides the password of a broker URL for logging
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
 	}
+	return u.Redacted()
+}
 
-	return msgs, err
+// redactURLs hides the passwords of broker URLs for logging
+func redactURLs(urls []string) []string {
+	redacted := make([]string, len(urls))
+	for i, raw := range urls {
+		redacted[i] = redactURL(raw)
+	}
+	return redacted
 }
 
 // InitializeRabbitMQUser creates a RabbitMQ user and vhost if they don't exist
@@ -137,7 +722,7 @@ func InitializeRabbitMQUser(adminURL, username, password, vhost string, logger *
 	})
 
 	// Connect to RabbitMQ with admin credentials
-	conn, err := amqp.Dial(adminURL)
+	conn, err := amqp091.Dial(adminURL)
 	if err != nil {
 		logger.Error("Failed to connect to RabbitMQ with admin credentials", err, nil)
 		return fmt.Errorf("failed to connect to RabbitMQ with admin credentials: %w", err)
@@ -153,9 +738,10 @@ func InitializeRabbitMQUser(adminURL, username, password, vhost string, logger *
 
 	// Try to use the management API via HTTP
 	logger.Info("Note: RabbitMQ user creation requires the rabbitmqadmin tool or management plugin", nil)
+	// The password is left out of the logs, the operator knows where it is configured
 	logger.Info("If this fails, please create the user manually with:", map[string]interface{}{
-		"command": fmt.Sprintf("rabbitmqctl add_user %s %s && rabbitmqctl set_permissions -p %s %s \".*\" \".*\" \".*\"",
-			username, password, vhost, username),
+		"command": fmt.Sprintf("rabbitmqctl add_user %s '<password>' && rabbitmqctl set_permissions -p %s %s \".*\" \".*\" \".*\"",
+			username, vhost, username),
 	})
 
 	// Try to use the default vhost
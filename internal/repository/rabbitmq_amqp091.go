@@ -0,0 +1,287 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+)
+
+// amqp091Connection adapts an amqp091-go connection to amqpConnection
+type amqp091Connection struct {
+	conn *amqp091.Connection
+}
+
+func dialAMQP091(url string) (amqpConnection, error) {
+	conn, err := amqp091.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return amqp091Connection{conn}, nil
+}
+
+func (c amqp091Connection) Channel() (amqpChannel, error) {
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return amqp091Channel{ch}, nil
+}
+
+func (c amqp091Connection) NotifyClose() <-chan error {
+	return amqp091Closed(c.conn.NotifyClose(make(chan *amqp091.Error, 1)))
+}
+
+func (c amqp091Connection) Close() error {
+	return c.conn.Close()
+}
+
+// amqp091Channel adapts an amqp091-go channel to amqpChannel
+type amqp091Channel struct {
+	ch *amqp091.Channel
+}
+
+func (c amqp091Channel) Qos(prefetchCount int) error {
+	return c.ch.Qos(prefetchCount, 0, false)
+}
+
+func (c amqp091Channel) QueueDeclare(spec QueueSpec) error {
+	_, err := c.ch.QueueDeclare(
+		spec.Name,                   // queue name
+		spec.Durable,                // durable
+		false,                       // delete when unused
+		false,                       // exclusive
+		false,                       // no-wait
+		headersToAMQP091(spec.Args), // arguments
+	)
+	return amqp091TopologyError(err)
+}
+
+func (c amqp091Channel) QueueInspect(name string) (QueueState, error) {
+	q, err := c.ch.QueueDeclarePassive(
+		name,  // queue name
+		false, // durable, ignored when passive
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return QueueState{}, amqp091TopologyError(err)
+	}
+	return QueueState{Messages: q.Messages, Consumers: q.Consumers}, nil
+}
+
+func (c amqp091Channel) ExchangeDeclare(name, kind string, durable bool) error {
+	return c.ch.ExchangeDeclare(
+		name,    // name
+		kind,    // kind
+		durable, // durable
+		false,   // delete when unused
+		false,   // internal
+		false,   // no-wait
+		nil,     // arguments
+	)
+}
+
+func (c amqp091Channel) QueueBind(name, key, exchange string) error {
+	return c.ch.QueueBind(
+		name,     // queue name
+		key,      // routing key
+		exchange, // exchange
+		false,    // no-wait
+		nil,      // arguments
+	)
+}
+
+func (c amqp091Channel) QueueUnbind(name, key, exchange string) error {
+	return c.ch.QueueUnbind(
+		name,     // queue name
+		key,      // routing key
+		exchange, // exchange
+		nil,      // arguments
+	)
+}
+
+func (c amqp091Channel) Consume(queue, consumer string) (<-chan messaging.Delivery, error) {
+	deliveries, err := c.ch.Consume(
+		queue,    // queue
+		consumer, // consumer
+		false,    // auto-ack
+		false,    // exclusive
+		false,    // no-local
+		false,    // no-wait
+		nil,      // args
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	closed := c.ch.NotifyClose(make(chan *amqp091.Error, 1))
+	return forwardDeliveries(deliveries, closed, deliveryFromAMQP091), nil
+}
+
+func (c amqp091Channel) Publish(ctx context.Context, exchange, key string, msg messaging.Publishing) error {
+	return c.ch.PublishWithContext(ctx,
+		exchange,                 // exchange
+		key,                      // routing key
+		false,                    // mandatory
+		false,                    // immediate
+		publishingToAMQP091(msg), // message
+	)
+}
+
+func (c amqp091Channel) Cancel(consumer string) error {
+	return c.ch.Cancel(consumer, false)
+}
+
+func (c amqp091Channel) NotifyClose() <-chan error {
+	return amqp091Closed(c.ch.NotifyClose(make(chan *amqp091.Error, 1)))
+}
+
+func (c amqp091Channel) Close() error {
+	return c.ch.Close()
+}
+
+// forwardDeliveries converts the deliveries of a driver until they end or
+// closed is signaled. A consumer replaced by a new one stops reading, and
+// the channel closing is what frees the goroutine blocked sending to it;
+// the deliveries it did not take are redelivered by the broker.
+func forwardDeliveries[D, E any](deliveries <-chan D, closed <-chan E, convert func(D) messaging.Delivery) <-chan messaging.Delivery {
+	out := make(chan messaging.Delivery)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case d, ok := <-deliveries:
+				if !ok {
+					return
+				}
+				select {
+				case out <- convert(d):
+				case <-closed:
+					return
+				}
+			case <-closed:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// amqp091Closed forwards the close reason, if any, as a plain error
+func amqp091Closed(in chan *amqp091.Error) <-chan error {
+	out := make(chan error, 1)
+	go func() {
+		defer close(out)
+		if err, ok := <-in; ok && err != nil {
+			out <- err
+		}
+	}()
+	return out
+}
+
+// amqp091TopologyError wraps the broker errors topology reconciliation acts on
+func amqp091TopologyError(err error) error {
+	var amqpErr *amqp091.Error
+	if !errors.As(err, &amqpErr) {
+		return err
+	}
+	switch amqpErr.Code {
+	case amqp091.PreconditionFailed:
+		return fmt.Errorf("%w: %s", errPreconditionFailed, amqpErr.Reason)
+	case amqp091.NotFound:
+		return fmt.Errorf("%w: %s", errNotFound, amqpErr.Reason)
+	}
+	return err
+}
+
+func deliveryFromAMQP091(d amqp091.Delivery) messaging.Delivery {
+	return messaging.Delivery{
+		Acknowledger: d.Acknowledger,
+		DeliveryTag:  d.DeliveryTag,
+		Redelivered:  d.Redelivered,
+		Exchange:     d.Exchange,
+		RoutingKey:   d.RoutingKey,
+		MessageID:    d.MessageId,
+		Type:         d.Type,
+		ContentType:  d.ContentType,
+		Timestamp:    d.Timestamp,
+		Headers:      headersFromAMQP091(d.Headers),
+		Body:         d.Body,
+	}
+}
+
+func publishingToAMQP091(msg messaging.Publishing) amqp091.Publishing {
+	p := amqp091.Publishing{
+		MessageId:    msg.MessageID,
+		Type:         msg.Type,
+		ContentType:  msg.ContentType,
+		Timestamp:    msg.Timestamp,
+		Headers:      headersToAMQP091(msg.Headers),
+		Body:         msg.Body,
+		DeliveryMode: amqp091.Transient,
+	}
+	if msg.Persistent {
+		p.DeliveryMode = amqp091.Persistent
+	}
+	return p
+}
+
+// headersFromAMQP091 turns nested tables into plain maps
+func headersFromAMQP091(t amqp091.Table) messaging.Headers {
+	if t == nil {
+		return nil
+	}
+	h := make(messaging.Headers, len(t))
+	for k, v := range t {
+		h[k] = valueFromAMQP091(v)
+	}
+	return h
+}
+
+func valueFromAMQP091(v interface{}) interface{} {
+	switch v := v.(type) {
+	case amqp091.Table:
+		return map[string]interface{}(headersFromAMQP091(v))
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = valueFromAMQP091(item)
+		}
+		return values
+	}
+	return v
+}
+
+// headersToAMQP091 turns nested maps into the tables the driver encodes
+func headersToAMQP091(h messaging.Headers) amqp091.Table {
+	if h == nil {
+		return nil
+	}
+	t := make(amqp091.Table, len(h))
+	for k, v := range h {
+		t[k] = valueToAMQP091(v)
+	}
+	return t
+}
+
+func valueToAMQP091(v interface{}) interface{} {
+	switch v := v.(type) {
+	case messaging.Headers:
+		return headersToAMQP091(v)
+	case map[string]interface{}:
+		return headersToAMQP091(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = valueToAMQP091(item)
+		}
+		return values
+	}
+	return v
+}
@@ -0,0 +1,193 @@
+package repository
+
+import (
+	"testing"
+	"time"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+)
+
+// ackCall is a settlement received by recordingDriverAcknowledger
+type ackCall struct {
+	method   string
+	tag      uint64
+	multiple bool
+	requeue  bool
+}
+
+// recordingDriverAcknowledger stands in for the channel of either driver
+type recordingDriverAcknowledger struct {
+	calls []ackCall
+}
+
+func (a *recordingDriverAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.calls = append(a.calls, ackCall{method: "ack", tag: tag, multiple: multiple})
+	return nil
+}
+
+func (a *recordingDriverAcknowledger) Nack(tag uint64, multiple, requeue bool) error {
+	a.calls = append(a.calls, ackCall{method: "nack", tag: tag, multiple: multiple, requeue: requeue})
+	return nil
+}
+
+func (a *recordingDriverAcknowledger) Reject(tag uint64, requeue bool) error {
+	a.calls = append(a.calls, ackCall{method: "reject", tag: tag, requeue: requeue})
+	return nil
+}
+
+func TestDeliveryFromAMQP091_Settlement(t *testing.T) {
+	// Arrange
+	ack := &recordingDriverAcknowledger{}
+	d := deliveryFromAMQP091(amqp091.Delivery{Acknowledger: ack, DeliveryTag: 7})
+
+	// Act
+	require.NoError(t, d.Ack(false))
+	require.NoError(t, d.Nack(false, true))
+	require.NoError(t, d.Reject(false))
+
+	// Assert
+	assert.Equal(t, []ackCall{
+		{method: "ack", tag: 7},
+		{method: "nack", tag: 7, requeue: true},
+		{method: "reject", tag: 7},
+	}, ack.calls)
+}
+
+func TestDeliveryFromAMQP091_Fields(t *testing.T) {
+	// Arrange
+	ts := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	// Act
+	d := deliveryFromAMQP091(amqp091.Delivery{
+		DeliveryTag: 3,
+		Redelivered: true,
+		Exchange:    "events",
+		RoutingKey:  "user.created",
+		MessageId:   "m-1",
+		Type:        "user.created",
+		ContentType: "application/json",
+		Timestamp:   ts,
+		Headers: amqp091.Table{
+			"attempt": int32(2),
+			"trace":   amqp091.Table{"id": "abc"},
+			"tags":    []interface{}{"a", amqp091.Table{"b": true}},
+		},
+		Body: []byte(`{}`),
+	})
+
+	// Assert
+	assert.Equal(t, messaging.Delivery{
+		DeliveryTag: 3,
+		Redelivered: true,
+		Exchange:    "events",
+		RoutingKey:  "user.created",
+		MessageID:   "m-1",
+		Type:        "user.created",
+		ContentType: "application/json",
+		Timestamp:   ts,
+		Headers: messaging.Headers{
+			"attempt": int32(2),
+			"trace":   map[string]interface{}{"id": "abc"},
+			"tags":    []interface{}{"a", map[string]interface{}{"b": true}},
+		},
+		Body: []byte(`{}`),
+	}, d)
+}
+
+func TestDelivery_WithoutAcknowledger(t *testing.T) {
+	// Arrange
+	d := deliveryFromAMQP091(amqp091.Delivery{DeliveryTag: 1})
+
+	// Act & Assert
+	assert.ErrorIs(t, d.Ack(false), messaging.ErrDeliveryNotInitialized)
+	assert.ErrorIs(t, d.Nack(false, true), messaging.ErrDeliveryNotInitialized)
+	assert.ErrorIs(t, d.Reject(true), messaging.ErrDeliveryNotInitialized)
+}
+
+func TestPublishingToAMQP091(t *testing.T) {
+	// Arrange
+	msg := messaging.Publishing{
+		MessageID:   "m-1",
+		Type:        "user.created",
+		ContentType: "application/json",
+		Headers: messaging.Headers{
+			"trace": map[string]interface{}{"id": "abc"},
+			"tags":  []interface{}{messaging.Headers{"b": true}},
+		},
+		Body:       []byte(`{}`),
+		Persistent: true,
+	}
+
+	// Act
+	p := publishingToAMQP091(msg)
+
+	// Assert
+	assert.Equal(t, amqp091.Persistent, p.DeliveryMode)
+	assert.Equal(t, "m-1", p.MessageId)
+	assert.Equal(t, amqp091.Table{"id": "abc"}, p.Headers["trace"])
+	assert.Equal(t, []interface{}{amqp091.Table{"b": true}}, p.Headers["tags"])
+	assert.NoError(t, p.Headers.Validate(), "nested headers must be tables the driver can encode")
+
+	msg.Persistent = false
+	msg.Headers = nil
+	p = publishingToAMQP091(msg)
+	assert.Equal(t, amqp091.Transient, p.DeliveryMode)
+	assert.Nil(t, p.Headers)
+}
+
+func TestStreadwayAdapter_Mapping(t *testing.T) {
+	// Arrange
+	ack := &recordingDriverAcknowledger{}
+
+	// Act
+	d := deliveryFromStreadway(amqp.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  9,
+		MessageId:    "m-2",
+		Headers:      amqp.Table{"trace": amqp.Table{"id": "abc"}},
+	})
+	require.NoError(t, d.Nack(false, false))
+	p := publishingToStreadway(messaging.Publishing{
+		Headers:    messaging.Headers{"trace": map[string]interface{}{"id": "abc"}},
+		Persistent: true,
+	})
+
+	// Assert
+	assert.Equal(t, "m-2", d.MessageID)
+	assert.Equal(t, map[string]interface{}{"id": "abc"}, d.Headers["trace"])
+	assert.Equal(t, []ackCall{{method: "nack", tag: 9}}, ack.calls)
+	assert.Equal(t, amqp.Persistent, p.DeliveryMode)
+	assert.NoError(t, p.Headers.Validate())
+}
+
+func TestForwardDeliveries_StopsWhenChannelCloses(t *testing.T) {
+	// Arrange: the driver keeps its deliveries open, as when the consumer
+	// was replaced and nobody reads them anymore
+	deliveries := make(chan amqp091.Delivery, 1)
+	deliveries <- amqp091.Delivery{DeliveryTag: 1}
+	closed := make(chan *amqp091.Error)
+	out := forwardDeliveries(deliveries, closed, deliveryFromAMQP091)
+
+	// Act
+	close(closed)
+
+	// Assert: the forwarding goroutine ends and closes its channel, having
+	// forwarded at most the pending delivery
+	timeout := time.After(time.Second)
+	for forwarded := 0; ; forwarded++ {
+		select {
+		case _, ok := <-out:
+			if !ok {
+				assert.LessOrEqual(t, forwarded, 1)
+				return
+			}
+		case <-timeout:
+			t.Fatal("forwarding goroutine still running")
+		}
+	}
+}
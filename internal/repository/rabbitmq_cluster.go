@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"math/rand/v2"
+	"net/url"
+	"slices"
+
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// dialOrder returns the nodes in the order connect tries them: the node
+// last connected to first, as it was healthy, then the others shuffled so
+// that clients spread over the cluster and a dead node is not always
+// dialed first
+func (r *RabbitMQ) dialOrder() []string {
+	r.mu.RLock()
+	last := r.node
+	r.mu.RUnlock()
+
+	nodes := slices.Clone(r.cfg.URLs)
+	rand.Shuffle(len(nodes), func(i, j int) { nodes[i], nodes[j] = nodes[j], nodes[i] })
+	if i := slices.Index(nodes, last); i > 0 {
+		nodes[0], nodes[i] = nodes[i], nodes[0]
+	}
+	return nodes
+}
+
+// Node returns the host and port of the broker node the client is
+// connected to, "" while it is down
+func (r *RabbitMQ) Node() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.up {
+		return ""
+	}
+	return nodeName(r.node)
+}
+
+// nodeName returns the host and port of a broker URL, leaving out the
+// credentials
+func nodeName(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// setConnectedNode labels the node connected to in metrics.RabbitMQNode,
+// "" clears the label once disconnected
+func setConnectedNode(node string) {
+	metrics.RabbitMQNode.Reset()
+	if node != "" {
+		metrics.RabbitMQNode.WithLabelValues(nodeName(node)).Set(1)
+	}
+}
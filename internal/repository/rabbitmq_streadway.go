@@ -0,0 +1,261 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/streadway/amqp"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+)
+
+// streadwayConnection adapts a streadway/amqp connection to amqpConnection
+type streadwayConnection struct {
+	conn *amqp.Connection
+}
+
+func dialStreadway(url string) (amqpConnection, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return streadwayConnection{conn}, nil
+}
+
+func (c streadwayConnection) Channel() (amqpChannel, error) {
+	ch, err := c.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return streadwayChannel{ch}, nil
+}
+
+func (c streadwayConnection) NotifyClose() <-chan error {
+	return streadwayClosed(c.conn.NotifyClose(make(chan *amqp.Error, 1)))
+}
+
+func (c streadwayConnection) Close() error {
+	return c.conn.Close()
+}
+
+// streadwayChannel adapts a streadway/amqp channel to amqpChannel
+type streadwayChannel struct {
+	ch *amqp.Channel
+}
+
+func (c streadwayChannel) Qos(prefetchCount int) error {
+	return c.ch.Qos(prefetchCount, 0, false)
+}
+
+func (c streadwayChannel) QueueDeclare(spec QueueSpec) error {
+	_, err := c.ch.QueueDeclare(
+		spec.Name,                     // queue name
+		spec.Durable,                  // durable
+		false,                         // delete when unused
+		false,                         // exclusive
+		false,                         // no-wait
+		headersToStreadway(spec.Args), // arguments
+	)
+	return streadwayTopologyError(err)
+}
+
+func (c streadwayChannel) QueueInspect(name string) (QueueState, error) {
+	q, err := c.ch.QueueDeclarePassive(
+		name,  // queue name
+		false, // durable, ignored when passive
+		false, // delete when unused
+		false, // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
+	if err != nil {
+		return QueueState{}, streadwayTopologyError(err)
+	}
+	return QueueState{Messages: q.Messages, Consumers: q.Consumers}, nil
+}
+
+func (c streadwayChannel) ExchangeDeclare(name, kind string, durable bool) error {
+	return c.ch.ExchangeDeclare(
+		name,    // name
+		kind,    // kind
+		durable, // durable
+		false,   // delete when unused
+		false,   // internal
+		false,   // no-wait
+		nil,     // arguments
+	)
+}
+
+func (c streadwayChannel) QueueBind(name, key, exchange string) error {
+	return c.ch.QueueBind(
+		name,     // queue name
+		key,      // routing key
+		exchange, // exchange
+		false,    // no-wait
+		nil,      // arguments
+	)
+}
+
+func (c streadwayChannel) QueueUnbind(name, key, exchange string) error {
+	return c.ch.QueueUnbind(
+		name,     // queue name
+		key,      // routing key
+		exchange, // exchange
+		nil,      // arguments
+	)
+}
+
+func (c streadwayChannel) Consume(queue, consumer string) (<-chan messaging.Delivery, error) {
+	deliveries, err := c.ch.Consume(
+		queue,    // queue
+		consumer, // consumer
+		false,    // auto-ack
+		false,    // exclusive
+		false,    // no-local
+		false,    // no-wait
+		nil,      // args
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	closed := c.ch.NotifyClose(make(chan *amqp.Error, 1))
+	return forwardDeliveries(deliveries, closed, deliveryFromStreadway), nil
+}
+
+// Publish ignores ctx, the driver does not support cancellation
+func (c streadwayChannel) Publish(ctx context.Context, exchange, key string, msg messaging.Publishing) error {
+	return c.ch.Publish(
+		exchange,                   // exchange
+		key,                        // routing key
+		false,                      // mandatory
+		false,                      // immediate
+		publishingToStreadway(msg), // message
+	)
+}
+
+func (c streadwayChannel) Cancel(consumer string) error {
+	return c.ch.Cancel(consumer, false)
+}
+
+func (c streadwayChannel) NotifyClose() <-chan error {
+	return streadwayClosed(c.ch.NotifyClose(make(chan *amqp.Error, 1)))
+}
+
+func (c streadwayChannel) Close() error {
+	return c.ch.Close()
+}
+
+// streadwayClosed forwards the close reason, if any, as a plain error
+func streadwayClosed(in chan *amqp.Error) <-chan error {
+	out := make(chan error, 1)
+	go func() {
+		defer close(out)
+		if err, ok := <-in; ok && err != nil {
+			out <- err
+		}
+	}()
+	return out
+}
+
+// streadwayTopologyError wraps the broker errors topology reconciliation acts on
+func streadwayTopologyError(err error) error {
+	var amqpErr *amqp.Error
+	if !errors.As(err, &amqpErr) {
+		return err
+	}
+	switch amqpErr.Code {
+	case amqp.PreconditionFailed:
+		return fmt.Errorf("%w: %s", errPreconditionFailed, amqpErr.Reason)
+	case amqp.NotFound:
+		return fmt.Errorf("%w: %s", errNotFound, amqpErr.Reason)
+	}
+	return err
+}
+
+func deliveryFromStreadway(d amqp.Delivery) messaging.Delivery {
+	return messaging.Delivery{
+		Acknowledger: d.Acknowledger,
+		DeliveryTag:  d.DeliveryTag,
+		Redelivered:  d.Redelivered,
+		Exchange:     d.Exchange,
+		RoutingKey:   d.RoutingKey,
+		MessageID:    d.MessageId,
+		Type:         d.Type,
+		ContentType:  d.ContentType,
+		Timestamp:    d.Timestamp,
+		Headers:      headersFromStreadway(d.Headers),
+		Body:         d.Body,
+	}
+}
+
+func publishingToStreadway(msg messaging.Publishing) amqp.Publishing {
+	p := amqp.Publishing{
+		MessageId:    msg.MessageID,
+		Type:         msg.Type,
+		ContentType:  msg.ContentType,
+		Timestamp:    msg.Timestamp,
+		Headers:      headersToStreadway(msg.Headers),
+		Body:         msg.Body,
+		DeliveryMode: amqp.Transient,
+	}
+	if msg.Persistent {
+		p.DeliveryMode = amqp.Persistent
+	}
+	return p
+}
+
+// headersFromStreadway turns nested tables into plain maps
+func headersFromStreadway(t amqp.Table) messaging.Headers {
+	if t == nil {
+		return nil
+	}
+	h := make(messaging.Headers, len(t))
+	for k, v := range t {
+		h[k] = valueFromStreadway(v)
+	}
+	return h
+}
+
+func valueFromStreadway(v interface{}) interface{} {
+	switch v := v.(type) {
+	case amqp.Table:
+		return map[string]interface{}(headersFromStreadway(v))
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = valueFromStreadway(item)
+		}
+		return values
+	}
+	return v
+}
+
+// headersToStreadway turns nested maps into the tables the driver encodes
+func headersToStreadway(h messaging.Headers) amqp.Table {
+	if h == nil {
+		return nil
+	}
+	t := make(amqp.Table, len(h))
+	for k, v := range h {
+		t[k] = valueToStreadway(v)
+	}
+	return t
+}
+
+func valueToStreadway(v interface{}) interface{} {
+	switch v := v.(type) {
+	case messaging.Headers:
+		return headersToStreadway(v)
+	case map[string]interface{}:
+		return headersToStreadway(v)
+	case []interface{}:
+		values := make([]interface{}, len(v))
+		for i, item := range v {
+			values[i] = valueToStreadway(item)
+		}
+		return values
+	}
+	return v
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+)
+
+// TenantRouting publishes events under tenant-partitioned routing keys,
+// <type>.<partition>, and replaces the task queue by one queue per
+// partition, so a noisy tenant only backs up its own partition
+type TenantRouting struct {
+	// Partitions bounds the number of partitions tenants are hashed onto.
+	// With 0, every tenant of Tenants gets its own partition and the others
+	// share the default one.
+	Partitions int
+	Tenants    []string
+}
+
+func (t *TenantRouting) partitioner() messaging.Partitioner {
+	return messaging.NewPartitioner(t.Partitions, t.Tenants...)
+}
+
+// routingKey returns the routing key of the event, its type without routing
+func (t *TenantRouting) routingKey(eventType, tenant string) string {
+	if t == nil {
+		return eventType
+	}
+	return messaging.RoutingKey(eventType, t.partitioner().Partition(tenant))
+}
+
+// PartitionQueue returns the queue of a partition, e.g. tasks.acme
+func PartitionQueue(queue, partition string) string {
+	return queue + "." + partition
+}
+
+// QueuesFor returns the queue registry declared with the task queue named
+// queue and the routing, Queues without either
+func QueuesFor(queue string, routing *TenantRouting) []QueueSpec {
+	if queue == "" {
+		queue = TasksQueue
+	}
+	if routing == nil {
+		if queue == TasksQueue {
+			return Queues
+		}
+		specs := slices.Clone(Queues)
+		for i := range specs {
+			if specs[i].Name == TasksQueue {
+				specs[i].Name = queue
+			}
+		}
+		return specs
+	}
+
+	partitions := routing.partitioner().Partitions()
+	specs := make([]QueueSpec, 0, len(partitions))
+	for _, partition := range partitions {
+		specs = append(specs, QueueSpec{
+			Name:     PartitionQueue(queue, partition),
+			Durable:  true,
+			Bindings: taskQueueBindings("." + partition),
+		})
+	}
+	return specs
+}
+
+// TaskQueues returns the task queues named queue of the given partitions,
+// of all of them when none are given. Without routing it is the task queue.
+func TaskQueues(queue string, routing *TenantRouting, partitions []string) ([]string, error) {
+	if queue == "" {
+		queue = TasksQueue
+	}
+	if routing == nil {
+		if len(partitions) > 0 {
+			return nil, fmt.Errorf("partitions %v given without tenant routing", partitions)
+		}
+		return []string{queue}, nil
+	}
+
+	all := routing.partitioner().Partitions()
+	if len(partitions) == 0 {
+		partitions = all
+	}
+
+	queues := make([]string, 0, len(partitions))
+	for _, partition := range partitions {
+		if !slices.Contains(all, partition) {
+			return nil, fmt.Errorf("unknown partition %q, partitions are %v", partition, all)
+		}
+		queues = append(queues, PartitionQueue(queue, partition))
+	}
+	return queues, nil
+}
@@ -0,0 +1,135 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+func connectWithRouting(t *testing.T, broker *fakeBroker, routing *TenantRouting) *RabbitMQ {
+	t.Helper()
+
+	go func() { broker.results <- nil }()
+	mq, err := newRabbitMQ(RabbitMQConfig{
+		URLs:              []string{"amqp://localhost:5672/"},
+		ReconnectDelay:    time.Millisecond,
+		ReconnectMaxDelay: 2 * time.Millisecond,
+		TenantRouting:     routing,
+		Logger:            logger.New(logger.WithOutput(&logBuffer{})),
+	}, broker.dial)
+	require.NoError(t, err)
+	t.Cleanup(func() { mq.Close() })
+	return mq
+}
+
+func TestRabbitMQ_Publish_TenantRouting(t *testing.T) {
+	tests := []struct {
+		name    string
+		routing *TenantRouting
+		tenant  string
+		wantKey string
+	}{
+		{name: "without routing", tenant: "acme", wantKey: "user.created"},
+		{name: "tenant", routing: &TenantRouting{Tenants: []string{"acme"}}, tenant: "acme", wantKey: "user.created.acme"},
+		{name: "unknown tenant", routing: &TenantRouting{Tenants: []string{"acme"}}, tenant: "globex", wantKey: "user.created.default"},
+		{name: "no tenant", routing: &TenantRouting{Tenants: []string{"acme"}}, wantKey: "user.created.default"},
+		{name: "bounded", routing: &TenantRouting{Partitions: 8}, tenant: "acme", wantKey: "user.created.p7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			broker := newFakeBroker()
+			mq := connectWithRouting(t, broker, tt.routing)
+
+			// Act
+			err := mq.Publish(context.Background(), &domain.OutboxEvent{ID: "e1", Type: "user.created", Tenant: tt.tenant})
+
+			// Assert: the envelope carries the tenant
+			require.NoError(t, err)
+			ch := broker.current().channel
+			assert.Equal(t, []string{tt.wantKey}, ch.keys)
+			if tt.tenant != "" {
+				assert.Equal(t, tt.tenant, ch.published[0].Headers[messaging.TenantHeader])
+			}
+		})
+	}
+}
+
+func TestRabbitMQ_Publish_Sequence(t *testing.T) {
+	// Arrange
+	broker := newFakeBroker()
+	mq := connectWithRouting(t, broker, nil)
+
+	// Act
+	err := mq.Publish(context.Background(), &domain.OutboxEvent{
+		ID: "e1", Type: domain.EventUserDeleted, Tenant: "acme", AggregateID: "user-1", AggregateSeq: 3,
+	})
+
+	// Assert: the envelope carries the sequence along with the tenant
+	require.NoError(t, err)
+	headers := broker.current().channel.published[0].Headers
+	assert.Equal(t, "acme", headers[messaging.TenantHeader])
+	aggregate, seq, ok := messaging.Delivery{Headers: headers}.Sequence()
+	assert.True(t, ok)
+	assert.Equal(t, "user-1", aggregate)
+	assert.Equal(t, int64(3), seq)
+}
+
+func TestRabbitMQ_TenantRouting_DeclaresPartitionQueues(t *testing.T) {
+	// Arrange
+	broker := newFakeBroker()
+
+	// Act
+	mq := connectWithRouting(t, broker, &TenantRouting{Partitions: 2})
+
+	// Assert: a queue per partition replaces the task queue
+	assert.False(t, broker.topology.has(TasksQueue))
+	assert.Equal(t, map[string][]string{
+		"tasks.default": {"webhook.#.default", "job.#.default", "user.cleanup.#.default"},
+		"tasks.p0":      {"webhook.#.p0", "job.#.p0", "user.cleanup.#.p0"},
+		"tasks.p1":      {"webhook.#.p1", "job.#.p1", "user.cleanup.#.p1"},
+	}, broker.topology.bindings)
+	assert.Equal(t, []string{"tasks.p1"}, mq.physicalQueues("tasks.p1"))
+}
+
+func TestTaskQueues(t *testing.T) {
+	routing := &TenantRouting{Tenants: []string{"acme", "globex"}}
+
+	queues, err := TaskQueues("", routing, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tasks.default", "tasks.acme", "tasks.globex"}, queues, "all partitions")
+
+	queues, err = TaskQueues("", routing, []string{"globex"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tasks.globex"}, queues)
+
+	_, err = TaskQueues("", routing, []string{"initech"})
+	assert.ErrorContains(t, err, `unknown partition "initech"`)
+
+	queues, err = TaskQueues("", nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{TasksQueue}, queues)
+
+	queues, err = TaskQueues("jobs", routing, []string{"acme"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"jobs.acme"}, queues, "named task queue")
+}
+
+func TestQueuesFor_NamedTaskQueue(t *testing.T) {
+	specs := QueuesFor("jobs", nil)
+	require.Len(t, specs, 1)
+	assert.Equal(t, "jobs", specs[0].Name)
+	assert.Equal(t, Queues[0].Bindings, specs[0].Bindings)
+	assert.Equal(t, TasksQueue, Queues[0].Name, "the registry must not change")
+
+	specs = QueuesFor("jobs", &TenantRouting{Tenants: []string{"acme"}})
+	assert.Equal(t, "jobs.acme", specs[1].Name)
+}
@@ -0,0 +1,307 @@
+package repository
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/sanitize"
+)
+
+// Broker errors topology reconciliation acts on, wrapped by the driver
+// adapters. Either closes the channel the declaration ran on.
+var (
+	errPreconditionFailed = errors.New("precondition failed")
+	errNotFound           = errors.New("not found")
+)
+
+// Policies for RabbitMQConfig.DriftPolicy, applied when a queue exists with
+// arguments other than the desired ones
+const (
+	// DriftFail fails the connection, the queue has to be deleted manually
+	DriftFail = "fail"
+	// DriftPassive uses the existing queue as it is and logs a warning
+	DriftPassive = "passive"
+	// DriftVersioned declares the queue under a versioned name with the
+	// desired arguments and keeps draining the existing queue
+	DriftVersioned = "versioned"
+)
+
+// QueueBinding routes messages of an exchange matching Key to a queue
+type QueueBinding struct {
+	Exchange string
+	Key      string
+}
+
+// QueueSpec is the desired declaration of a queue
+type QueueSpec struct {
+	Name     string
+	Durable  bool
+	Args     messaging.Headers
+	Bindings []QueueBinding
+}
+
+// VersionedName is the name the queue is declared under when an existing
+// queue of Name has other arguments. It is derived from the declaration so
+// every replica picks the same one.
+func (s QueueSpec) VersionedName() string {
+	data, _ := json.Marshal(struct {
+		Durable bool              `json:"durable"`
+		Args    messaging.Headers `json:"args"`
+	}{s.Durable, s.Args})
+	sum := sha256.Sum256(data)
+	return s.Name + ".v" + hex.EncodeToString(sum[:4])
+}
+
+// Queues is the desired queue topology, declared on every connect
+var Queues = []QueueSpec{
+	{
+		Name:     TasksQueue,
+		Durable:  true,
+		Bindings: taskQueueBindings(""),
+	},
+}
+
+// QueueState is what the broker reports for an existing queue
+type QueueState struct {
+	Messages  int
+	Consumers int
+}
+
+// declareTopology sets the prefetch, declares the events exchange and
+// reconciles the queues of the registry with the broker. It returns the
+// physical queues of every queue name, the active one first.
+func declareTopology(conn amqpConnection, ch amqpChannel, specs []QueueSpec, prefetch int, policy string, log *logger.Logger) (map[string][]string, error) {
+	if prefetch > 0 {
+		if err := ch.Qos(prefetch); err != nil {
+			return nil, fmt.Errorf("failed to set prefetch %d: %w", prefetch, err)
+		}
+	}
+
+	// Declaring durable events exchange
+	if err := ch.ExchangeDeclare(EventsExchange, "topic", true); err != nil {
+		return nil, fmt.Errorf("failed to declare exchange %s: %w", EventsExchange, err)
+	}
+
+	queues := make(map[string][]string, len(specs))
+	for _, spec := range specs {
+		names, err := reconcileQueue(conn, ch, spec, policy, log)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, b := range spec.Bindings {
+			if err := ch.QueueBind(names[0], b.Key, b.Exchange); err != nil {
+				return nil, fmt.Errorf("failed to bind queue %s to %s: %w", names[0], b.Key, err)
+			}
+		}
+		// Queues left to drain stop receiving messages once the active queue
+		// is bound, which would otherwise get every message a second time
+		for _, draining := range names[1:] {
+			for _, b := range spec.Bindings {
+				if err := ch.QueueUnbind(draining, b.Key, b.Exchange); err != nil {
+					return nil, fmt.Errorf("failed to unbind queue %s from %s: %w", draining, b.Key, err)
+				}
+			}
+		}
+		queues[spec.Name] = names
+	}
+
+	return queues, nil
+}
+
+// reconcileQueue declares the queue on ch and returns its physical queues.
+// Declarations that may fail run on throwaway channels first, as a failed
+// declaration closes its channel.
+func reconcileQueue(conn amqpConnection, ch amqpChannel, spec QueueSpec, policy string, log *logger.Logger) ([]string, error) {
+	versioned := spec.VersionedName()
+
+	// Once a transition started, stay on the versioned queue even after the
+	// original one was deleted, it may still hold messages
+	if policy == DriftVersioned {
+		if _, err := inspectQueue(conn, versioned); err == nil {
+			return declareVersioned(conn, ch, spec, versioned)
+		}
+	}
+
+	err := onThrowawayChannel(conn, func(probe amqpChannel) error {
+		return probe.QueueDeclare(spec)
+	})
+	switch {
+	case err == nil:
+		if err := ch.QueueDeclare(spec); err != nil {
+			return nil, fmt.Errorf("failed to declare queue %s: %w", spec.Name, err)
+		}
+		return []string{spec.Name}, nil
+	case !errors.Is(err, errPreconditionFailed):
+		return nil, fmt.Errorf("failed to declare queue %s: %w", spec.Name, err)
+	}
+
+	fields := map[string]interface{}{
+		"event":  "topology_drift",
+		"queue":  spec.Name,
+		"policy": policy,
+		"error":  err.Error(),
+	}
+	switch policy {
+	case DriftPassive:
+		log.Warn("Queue arguments differ from the desired ones, using the existing queue", fields)
+		return []string{spec.Name}, nil
+	case DriftVersioned:
+		fields["versioned_queue"] = versioned
+		log.Warn("Queue arguments differ from the desired ones, moving to a versioned queue", fields)
+		return declareVersioned(conn, ch, spec, versioned)
+	default:
+		return nil, fmt.Errorf("failed to declare queue %s: %w", spec.Name, err)
+	}
+}
+
+// declareVersioned declares the versioned queue and keeps the original one,
+// if it still exists, as a queue to drain. declareTopology unbinds it.
+func declareVersioned(conn amqpConnection, ch amqpChannel, spec QueueSpec, versioned string) ([]string, error) {
+	original := spec.Name
+	spec.Name = versioned
+	if err := ch.QueueDeclare(spec); err != nil {
+		return nil, fmt.Errorf("failed to declare queue %s: %w", versioned, err)
+	}
+
+	names := []string{versioned}
+	if _, err := inspectQueue(conn, original); err == nil {
+		names = append(names, original)
+	}
+	return names, nil
+}
+
+// inspectQueue declares the queue passively on a throwaway channel
+func inspectQueue(conn amqpConnection, name string) (QueueState, error) {
+	var state QueueState
+	err := onThrowawayChannel(conn, func(probe amqpChannel) error {
+		var err error
+		state, err = probe.QueueInspect(name)
+		return err
+	})
+	return state, err
+}
+
+// onThrowawayChannel runs fn on a channel of its own
+func onThrowawayChannel(conn amqpConnection, fn func(amqpChannel) error) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return fmt.Errorf("failed to create channel: %w", err)
+	}
+	// The broker may have closed it already
+	defer ch.Close()
+
+	return fn(ch)
+}
+
+// Queue statuses of a TopologyReport
+const (
+	QueueInSync  = "in_sync"
+	QueueMissing = "missing"
+	// QueueDrifted has other arguments than the desired ones
+	QueueDrifted = "drifted"
+	// QueueTransition has drifted and a versioned queue exists next to it,
+	// it can be deleted once drained
+	QueueTransition = "transition"
+	// QueueVersioned only exists under its versioned name
+	QueueVersioned = "versioned"
+)
+
+// QueueDrift compares a queue of the registry with the broker
+type QueueDrift struct {
+	Queue  string `json:"queue"`
+	Status string `json:"status"`
+	// Detail is the broker's description of the differing argument
+	Detail    string `json:"detail,omitempty"`
+	Messages  int    `json:"messages"`
+	Consumers int    `json:"consumers"`
+
+	VersionedQueue    string `json:"versioned_queue,omitempty"`
+	VersionedMessages int    `json:"versioned_messages,omitempty"`
+}
+
+// TopologyReport is the drift between the queue registry and the broker
+type TopologyReport struct {
+	Queues []QueueDrift `json:"queues"`
+}
+
+// Clean reports whether every queue is declared as desired
+func (r *TopologyReport) Clean() bool {
+	for _, q := range r.Queues {
+		if q.Status != QueueInSync && q.Status != QueueVersioned {
+			return false
+		}
+	}
+	return true
+}
+
+// InspectTopology compares the queue registry declared with the task queue
+// and the routing, see QueuesFor, with the broker at url without changing it
+func InspectTopology(driver, url, queue string, routing *TenantRouting) (*TopologyReport, error) {
+	if driver == "" {
+		driver = DriverAMQP091
+	}
+	dial, ok := amqpDrivers[driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown AMQP driver %q", driver)
+	}
+
+	conn, err := dial(url)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return inspectTopology(conn, QueuesFor(queue, routing))
+}
+
+func inspectTopology(conn amqpConnection, specs []QueueSpec) (*TopologyReport, error) {
+	report := &TopologyReport{Queues: make([]QueueDrift, 0, len(specs))}
+	for _, spec := range specs {
+		drift := QueueDrift{Queue: spec.Name, Status: QueueInSync}
+
+		state, err := inspectQueue(conn, spec.Name)
+		switch {
+		case errors.Is(err, errNotFound):
+			drift.Status = QueueMissing
+		case err != nil:
+			return nil, fmt.Errorf("failed to inspect queue %s: %w", spec.Name, err)
+		default:
+			drift.Messages, drift.Consumers = state.Messages, state.Consumers
+			// The queue exists, so an equivalent declaration changes nothing
+			err = onThrowawayChannel(conn, func(probe amqpChannel) error {
+				return probe.QueueDeclare(spec)
+			})
+			switch {
+			case errors.Is(err, errPreconditionFailed):
+				drift.Status, drift.Detail = QueueDrifted, sanitize.String(err.Error())
+			case err != nil:
+				return nil, fmt.Errorf("failed to compare queue %s: %w", spec.Name, err)
+			}
+		}
+
+		versioned := spec.VersionedName()
+		state, err = inspectQueue(conn, versioned)
+		switch {
+		case errors.Is(err, errNotFound):
+		case err != nil:
+			return nil, fmt.Errorf("failed to inspect queue %s: %w", versioned, err)
+		default:
+			drift.VersionedQueue, drift.VersionedMessages = versioned, state.Messages
+			if drift.Status == QueueMissing {
+				drift.Status = QueueVersioned
+			} else {
+				drift.Status = QueueTransition
+			}
+		}
+
+		report.Queues = append(report.Queues, drift)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// seedDriftedTasksQueue declares the task queue on the broker with arguments
+// the registry does not have, as an earlier release would have
+func seedDriftedTasksQueue(broker *fakeBroker) {
+	broker.topology.queues[TasksQueue] = QueueSpec{
+		Name:    TasksQueue,
+		Durable: true,
+		Args:    messaging.Headers{"x-max-length": 1000},
+	}
+	for _, b := range Queues[0].Bindings {
+		broker.topology.bindings[TasksQueue] = append(broker.topology.bindings[TasksQueue], b.Key)
+	}
+}
+
+func connectWithPolicy(t *testing.T, broker *fakeBroker, logs *logBuffer, policy string) (*RabbitMQ, error) {
+	t.Helper()
+
+	go func() { broker.results <- nil }()
+	mq, err := newRabbitMQ(RabbitMQConfig{
+		URLs:              []string{"amqp://localhost:5672/"},
+		DriftPolicy:       policy,
+		ReconnectDelay:    time.Millisecond,
+		ReconnectMaxDelay: 2 * time.Millisecond,
+		Logger:            logger.New(logger.WithOutput(logs)),
+	}, broker.dial)
+	if err == nil {
+		t.Cleanup(func() { mq.Close() })
+	}
+	return mq, err
+}
+
+func TestRabbitMQ_TopologyDrift_Fail(t *testing.T) {
+	// Arrange
+	broker := newFakeBroker()
+	seedDriftedTasksQueue(broker)
+
+	// Act
+	_, err := connectWithPolicy(t, broker, &logBuffer{}, DriftFail)
+
+	// Assert
+	assert.ErrorIs(t, err, errPreconditionFailed)
+	assert.ErrorContains(t, err, "failed to declare queue tasks")
+}
+
+func TestRabbitMQ_TopologyDrift_Passive(t *testing.T) {
+	// Arrange
+	broker := newFakeBroker()
+	seedDriftedTasksQueue(broker)
+	logs := &logBuffer{}
+
+	// Act
+	mq, err := connectWithPolicy(t, broker, logs, DriftPassive)
+	require.NoError(t, err)
+
+	// Assert: the existing queue is used as it is
+	assert.Equal(t, []string{TasksQueue}, mq.physicalQueues(TasksQueue))
+	assert.Equal(t, messaging.Headers{"x-max-length": 1000}, broker.topology.queues[TasksQueue].Args)
+	assert.False(t, broker.topology.has(Queues[0].VersionedName()))
+	assert.Contains(t, logs.eventNames(t), "topology_drift")
+
+	deliveries, err := mq.Consume(TasksQueue)
+	require.NoError(t, err)
+	require.True(t, broker.current().channel.deliverTo(TasksQueue, messaging.Delivery{MessageID: "m1"}))
+	assert.Equal(t, "m1", (<-deliveries).MessageID)
+}
+
+func TestRabbitMQ_TopologyDrift_Versioned(t *testing.T) {
+	// Arrange
+	broker := newFakeBroker()
+	seedDriftedTasksQueue(broker)
+	logs := &logBuffer{}
+	versioned := Queues[0].VersionedName()
+
+	// Act
+	mq, err := connectWithPolicy(t, broker, logs, DriftVersioned)
+	require.NoError(t, err)
+
+	// Assert: the versioned queue is declared as desired and both are consumed
+	require.True(t, broker.topology.has(versioned))
+	assert.Equal(t, Queues[0].Args, broker.topology.queues[versioned].Args)
+	assert.Equal(t, []string{versioned, TasksQueue}, mq.physicalQueues(TasksQueue))
+	assert.Contains(t, logs.eventNames(t), "topology_drift")
+	assert.ElementsMatch(t, taskBindings, broker.topology.bindings[versioned])
+	assert.Empty(t, broker.topology.bindings[TasksQueue], "the drained queue must not receive new messages")
+
+	deliveries, err := mq.Consume(TasksQueue)
+	require.NoError(t, err)
+
+	channel := broker.current().channel
+	require.True(t, channel.deliverTo(TasksQueue, messaging.Delivery{MessageID: "old"}))
+	assert.Equal(t, "old", (<-deliveries).MessageID)
+	require.True(t, channel.deliverTo(versioned, messaging.Delivery{MessageID: "new"}))
+	assert.Equal(t, "new", (<-deliveries).MessageID)
+
+	// Act: the drained queue is deleted and the connection is re-established
+	broker.topology.delete(TasksQueue)
+	broker.current().drop(errors.New("connection reset"))
+	broker.results <- nil
+
+	// Assert: the client stays on the versioned queue
+	require.Eventually(t, func() bool {
+		return broker.current().channel.deliverTo(versioned, messaging.Delivery{MessageID: "after"})
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, "after", (<-deliveries).MessageID)
+	assert.Equal(t, []string{versioned}, mq.physicalQueues(TasksQueue))
+	assert.False(t, broker.topology.has(TasksQueue), "the original queue must not be recreated")
+}
+
+func TestInspectTopology(t *testing.T) {
+	versioned := Queues[0].VersionedName()
+
+	tests := []struct {
+		name      string
+		seed      func(b *fakeBroker)
+		wantState string
+		wantClean bool
+	}{
+		{
+			name:      "missing",
+			seed:      func(b *fakeBroker) {},
+			wantState: QueueMissing,
+		},
+		{
+			name:      "in sync",
+			seed:      func(b *fakeBroker) { b.topology.queues[TasksQueue] = Queues[0] },
+			wantState: QueueInSync,
+			wantClean: true,
+		},
+		{
+			name:      "drifted",
+			seed:      seedDriftedTasksQueue,
+			wantState: QueueDrifted,
+		},
+		{
+			name: "transition",
+			seed: func(b *fakeBroker) {
+				seedDriftedTasksQueue(b)
+				b.topology.queues[versioned] = QueueSpec{Name: versioned, Durable: true}
+			},
+			wantState: QueueTransition,
+		},
+		{
+			name:      "versioned",
+			seed:      func(b *fakeBroker) { b.topology.queues[versioned] = QueueSpec{Name: versioned, Durable: true} },
+			wantState: QueueVersioned,
+			wantClean: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			broker := newFakeBroker()
+			tt.seed(broker)
+			go func() { broker.results <- nil }()
+			conn, err := broker.dial("")
+			require.NoError(t, err)
+
+			// Act
+			report, err := inspectTopology(conn, Queues)
+
+			// Assert
+			require.NoError(t, err)
+			require.Len(t, report.Queues, 1)
+			assert.Equal(t, tt.wantState, report.Queues[0].Status)
+			assert.Equal(t, tt.wantClean, report.Clean())
+			if tt.wantState == QueueDrifted {
+				assert.Contains(t, report.Queues[0].Detail, "inequivalent arg")
+			}
+			// Inspecting never changes the broker
+			assert.Equal(t, tt.wantState != QueueMissing && tt.wantState != QueueVersioned, broker.topology.has(TasksQueue))
+		})
+	}
+}
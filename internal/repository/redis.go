@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/sanitize"
+)
+
+// defaultRedisDialTimeout bounds the connection and the startup ping
+const defaultRedisDialTimeout = 5 * time.Second
+
+// RedisConfig configures the Redis client, which is optional
+type RedisConfig struct {
+	// Addr is host:port, empty disables Redis
+	Addr     string
+	Password string
+	DB       int
+	// DialTimeout bounds connecting, defaultRedisDialTimeout when 0
+	DialTimeout time.Duration
+	Logger      *logger.Logger
+}
+
+// NewRedisClient connects to Redis and pings it, so that a wrong address
+// fails startup rather than the first cache access. It returns a nil client
+// when no address is configured.
+func NewRedisClient(cfg RedisConfig) (*redis.Client, error) {
+	if cfg.Logger == nil {
+		cfg.Logger = logger.New(logger.WithOutput(io.Discard))
+	}
+	if cfg.Addr == "" {
+		cfg.Logger.Info("Redis is not configured", nil)
+		return nil, nil
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = defaultRedisDialTimeout
+	}
+
+	fields := map[string]interface{}{"address": cfg.Addr, "db": cfg.DB}
+	cfg.Logger.Info("Connecting to Redis", fields)
+
+	client := redis.NewClient(&redis.Options{
+		Addr:        cfg.Addr,
+		Password:    cfg.Password,
+		DB:          cfg.DB,
+		DialTimeout: cfg.DialTimeout,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.DialTimeout)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		err = sanitize.Error(err)
+		cfg.Logger.Error("Failed to ping Redis", err, fields)
+		return nil, err
+	}
+
+	cfg.Logger.Info("Successfully connected to Redis", fields)
+	return client, nil
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+)
+
+// incrWindow adds one to the count KEYS[1] and starts its window of ARGV[1]
+// milliseconds unless it has one, like PEXPIRE NX on Redis before 7
+var incrWindow = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if redis.call('PTTL', KEYS[1]) < 0 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// RedisCache is a Cache shared by every replica using the same Redis
+type RedisCache struct {
+	client *redis.Client
+}
+
+var (
+	_ cache.Adder   = (*RedisCache)(nil)
+	_ cache.Counter = (*RedisCache)(nil)
+	_ cache.Pinger  = (*RedisCache)(nil)
+)
+
+func NewRedisCache(client *redis.Client) *RedisCache {
+	return &RedisCache{client: client}
+}
+
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(ctx, key, value, ttl).Err()
+}
+
+func (c *RedisCache) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return c.client.SetNX(ctx, key, value, ttl).Result()
+}
+
+func (c *RedisCache) Delete(ctx context.Context, key string) error {
+	return c.client.Del(ctx, key).Err()
+}
+
+func (c *RedisCache) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	return incrWindow.Run(ctx, c.client, []string{key}, window.Milliseconds()).Int64()
+}
+
+func (c *RedisCache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
@@ -0,0 +1,145 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redisAddrEnv names the variable holding the Redis the tests run against
+const redisAddrEnv = "TEST_REDIS_ADDR"
+
+func testRedis(t *testing.T) *redis.Client {
+	t.Helper()
+
+	addr := os.Getenv(redisAddrEnv)
+	if addr == "" {
+		t.Skip(redisAddrEnv + " is not set")
+	}
+	client, err := NewRedisClient(RedisConfig{Addr: addr})
+	require.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestRedisSlots_SharedAcrossReplicas(t *testing.T) {
+	// Arrange: two replicas share the slots of the same Redis
+	client := testRedis(t)
+	ctx := context.Background()
+	key := "test:slots:" + uuid.NewString()
+	t.Cleanup(func() { client.Del(ctx, key) })
+	first, second := NewRedisSlots(client), NewRedisSlots(client)
+
+	// Act
+	token, ok1, err1 := first.Acquire(ctx, key, 1, time.Minute)
+	_, ok2, err2 := second.Acquire(ctx, key, 1, time.Minute)
+
+	// Assert
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	assert.True(t, ok1)
+	assert.False(t, ok2, "the slot is held by the other replica")
+
+	// Act: releasing the slot lets the other replica in
+	require.NoError(t, first.Release(ctx, key, token))
+	_, ok3, err3 := second.Acquire(ctx, key, 1, time.Minute)
+
+	// Assert
+	require.NoError(t, err3)
+	assert.True(t, ok3)
+}
+
+func TestRedisSlots_Refresh(t *testing.T) {
+	// Arrange
+	client := testRedis(t)
+	ctx := context.Background()
+	key := "test:slots:" + uuid.NewString()
+	t.Cleanup(func() { client.Del(ctx, key) })
+	now := time.Now()
+	slots := NewRedisSlots(client)
+	slots.now = func() time.Time { return now }
+
+	token, _, err := slots.Acquire(ctx, key, 1, time.Minute)
+	require.NoError(t, err)
+
+	// Act: the holder is still running when its slot would expire
+	now = now.Add(50 * time.Second)
+	require.NoError(t, slots.Refresh(ctx, key, token, time.Minute))
+	now = now.Add(50 * time.Second)
+	_, held, _ := slots.Acquire(ctx, key, 1, time.Minute)
+	now = now.Add(time.Minute)
+	_, reclaimed, _ := slots.Acquire(ctx, key, 1, time.Minute)
+
+	// Assert
+	assert.False(t, held, "the refreshed slot is still held")
+	assert.True(t, reclaimed, "slots that are not refreshed expire")
+}
+
+func TestRedisCache_AddSharedAcrossReplicas(t *testing.T) {
+	// Arrange
+	client := testRedis(t)
+	ctx := context.Background()
+	key := "test:cache:" + uuid.NewString()
+	t.Cleanup(func() { client.Del(ctx, key) })
+	first, second := NewRedisCache(client), NewRedisCache(client)
+
+	// Act
+	ok1, err1 := first.Add(ctx, key, []byte("first"), time.Minute)
+	ok2, err2 := second.Add(ctx, key, []byte("second"), time.Minute)
+	value, found, err3 := second.Get(ctx, key)
+
+	// Assert
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	require.NoError(t, err3)
+	assert.True(t, ok1)
+	assert.False(t, ok2, "the key was added by the other replica")
+	assert.True(t, found)
+	assert.Equal(t, []byte("first"), value)
+
+	// Act: deleting the key lets the other replica add it
+	require.NoError(t, first.Delete(ctx, key))
+	ok4, err4 := second.Add(ctx, key, []byte("second"), time.Minute)
+
+	// Assert
+	require.NoError(t, err4)
+	assert.True(t, ok4)
+}
+
+func TestRedisCache_IncrSharedAcrossReplicas(t *testing.T) {
+	// Arrange
+	client := testRedis(t)
+	ctx := context.Background()
+	key := "test:counter:" + uuid.NewString()
+	t.Cleanup(func() { client.Del(ctx, key) })
+	first, second := NewRedisCache(client), NewRedisCache(client)
+
+	// Act
+	count1, err1 := first.Incr(ctx, key, 200*time.Millisecond)
+	count2, err2 := second.Incr(ctx, key, time.Hour)
+	ttl, err3 := client.PTTL(ctx, key).Result()
+
+	// Assert
+	require.NoError(t, err1)
+	require.NoError(t, err2)
+	require.NoError(t, err3)
+	assert.Equal(t, int64(1), count1)
+	assert.Equal(t, int64(2), count2, "both replicas count the same key")
+	assert.LessOrEqual(t, ttl, 200*time.Millisecond, "later increments keep the window")
+
+	// Act: the count starts over once the window ended
+	time.Sleep(250 * time.Millisecond)
+	count3, err4 := second.Incr(ctx, key, time.Hour)
+
+	// Assert
+	require.NoError(t, err4)
+	assert.Equal(t, int64(1), count3)
+}
@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+)
+
+// acquireSlot drops the expired slots of KEYS[1], a sorted set of tokens
+// scored by their expiry, and adds ARGV[4] unless ARGV[2] slots are held.
+// ARGV[1] is the current time and ARGV[3] the expiry of the slot, both in
+// milliseconds, ARGV[5] the ttl keeping the set past its last slot.
+var acquireSlot = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// refreshSlot moves the expiry of the slot ARGV[2] of KEYS[1] to ARGV[1]
+// when it is still held, and keeps the set for ARGV[3] milliseconds
+var refreshSlot = redis.NewScript(`
+if redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 1
+`)
+
+// RedisSlots is a Semaphore shared by every replica using the same Redis,
+// so that limits apply across replicas. Each key is a sorted set of the
+// tokens of its slots scored by their expiry.
+type RedisSlots struct {
+	client *redis.Client
+	now    func() time.Time
+}
+
+var _ cache.Semaphore = (*RedisSlots)(nil)
+
+func NewRedisSlots(client *redis.Client) *RedisSlots {
+	return &RedisSlots{client: client, now: time.Now}
+}
+
+func (s *RedisSlots) Acquire(ctx context.Context, key string, limit int, ttl time.Duration) (string, bool, error) {
+	token := uuid.NewString()
+	now := s.now()
+	ok, err := acquireSlot.Run(ctx, s.client, []string{key},
+		now.UnixMilli(), limit, now.Add(ttl).UnixMilli(), token, ttl.Milliseconds()).Int()
+	if err != nil {
+		return "", false, err
+	}
+	if ok == 0 {
+		return "", false, nil
+	}
+	return token, true, nil
+}
+
+func (s *RedisSlots) Release(ctx context.Context, key, token string) error {
+	return s.client.ZRem(ctx, key, token).Err()
+}
+
+func (s *RedisSlots) Refresh(ctx context.Context, key, token string, ttl time.Duration) error {
+	return refreshSlot.Run(ctx, s.client, []string{key},
+		s.now().Add(ttl).UnixMilli(), token, ttl.Milliseconds()).Err()
+}
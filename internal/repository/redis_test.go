@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRedisClient_NotConfigured(t *testing.T) {
+	// Act
+	client, err := NewRedisClient(RedisConfig{})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Nil(t, client)
+}
+
+func TestNewRedisClient_Unreachable(t *testing.T) {
+	// Arrange: an address nothing listens on once the listener is closed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	// Act
+	client, err := NewRedisClient(RedisConfig{Addr: addr, DialTimeout: time.Second})
+
+	// Assert
+	require.Error(t, err)
+	assert.Nil(t, client)
+}
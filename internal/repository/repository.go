@@ -1,16 +1,174 @@
 package repository
 
 import (
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
 	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/explain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
 )
 
 type Repositories struct {
 	User domain.UserRepository
+	// UserBulk selects users for bulk operations, bypassing the cache and the replica
+	UserBulk domain.UserBulkRepository
+	// UserRecent lists users by activity, bypassing the cache
+	UserRecent domain.RecentUserRepository
+	// UserCache is the cache of users looked up by ID, nil without WithUserCache
+	UserCache domain.UserCache
+	Outbox    domain.OutboxRepository
+	// Tx runs functions within a transaction of the primary database
+	Tx       domain.Transactor
+	Instance domain.InstanceRepository
+	Job      domain.JobRepository
+	Audit    domain.AuditRepository
+	Events   domain.EventArchiveRepository
+	// Redis is the client of repositories opting into Redis, nil without WithRedis
+	Redis *redis.Client
+	// Slots limits concurrent work across replicas sharing Redis, or within
+	// this process without it
+	Slots cache.Semaphore
+	// Processed holds the business keys of messages the workers handled,
+	// shared through Redis like Slots
+	Processed cache.Adder
+	// Nonces holds the signatures of accepted webhooks, shared through
+	// Redis like Slots
+	Nonces cache.Adder
+	// Counters counts events per key in windows, shared through Redis like
+	// Slots
+	Counters cache.Counter
+	// Seen holds the users recently marked seen, shared through Redis like
+	// Slots
+	Seen cache.Adder
+}
+
+type options struct {
+	replica   *DB
+	userCache cache.Cache
+	userTTL   time.Duration
+	userStale time.Duration
+	redis     *redis.Client
+	log       *logger.Logger
+	gen       []generate.Option
+	idOrder   bool
+	explain   *explain.Sampler
+}
+
+// Option is a function that configures the repositories
+type Option func(*options)
+
+// WithReplica serves user reads from a read replica
+func WithReplica(replica *DB) Option {
+	return func(o *options) {
+		o.replica = replica
+	}
+}
+
+// WithUserCache caches users looked up by ID for ttl
+func WithUserCache(c cache.Cache, ttl time.Duration, log *logger.Logger) Option {
+	return func(o *options) {
+		o.userCache = c
+		o.userTTL = ttl
+		o.log = log
+	}
+}
+
+// WithUserCacheStaleIfError keeps cached users for grace past their TTL and
+// serves them while the database is unreachable, see WithStaleIfError
+func WithUserCacheStaleIfError(grace time.Duration) Option {
+	return func(o *options) {
+		o.userStale = grace
+	}
+}
+
+// WithRedis makes a Redis client available to the repositories, a nil
+// client leaves them without Redis
+func WithRedis(client *redis.Client) Option {
+	return func(o *options) {
+		o.redis = client
+	}
+}
+
+// WithLogger sets the logger the repositories report to
+func WithLogger(log *logger.Logger) Option {
+	return func(o *options) {
+		o.log = log
+	}
+}
+
+// WithGenerators sets how every repository generates timestamps and IDs
+func WithGenerators(opts ...generate.Option) Option {
+	return func(o *options) {
+		o.gen = append(o.gen, opts...)
+	}
+}
+
+// WithUserIDOrder lists users in creation order by ID, see WithIDOrder
+func WithUserIDOrder() Option {
+	return func(o *options) {
+		o.idOrder = true
+	}
+}
+
+// WithExplainSampling explains the reads s samples, on the primary and
+// the replica alike
+func WithExplainSampling(s *explain.Sampler) Option {
+	return func(o *options) {
+		o.explain = s
+	}
 }
 
 // NewRepositories creates a new Repositories instance
-func NewRepositories(db *DB, mq *RabbitMQ) *Repositories {
-	return &Repositories{
-		User: NewUserRepository(db.DB),
+func NewRepositories(db *DB, mq *RabbitMQ, opts ...Option) *Repositories {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
 	}
+
+	querier := NewInstrumentedQuerier(db.DB, WithExplainSampler(o.explain))
+
+	userOpts := []UserRepositoryOption{WithUserGenerators(o.gen...), WithUserLogger(o.log)}
+	if o.replica != nil {
+		userOpts = append(userOpts, WithReadReplica(NewInstrumentedQuerier(o.replica.DB, WithExplainSampler(o.explain))))
+	}
+	if o.idOrder {
+		userOpts = append(userOpts, WithIDOrder())
+	}
+
+	primary := NewUserRepository(querier, userOpts...)
+	repos := &Repositories{
+		User:       primary,
+		UserBulk:   primary,
+		UserRecent: primary,
+		Outbox:     NewOutboxRepository(querier, o.gen...),
+		Tx:         NewTransactor(db.DB),
+		Instance:   NewInstanceRepository(querier),
+		Job:        NewJobRepository(querier, o.gen...),
+		Audit:      NewAuditRepository(querier, o.gen...),
+		Events:     NewEventArchiveRepository(querier),
+		Redis:      o.redis,
+		Slots:      cache.NewSlots(),
+		Processed:  cache.NewMemory(),
+		Nonces:     cache.NewMemory(),
+		Counters:   cache.NewMemory(),
+		Seen:       cache.NewMemory(),
+	}
+	if o.redis != nil {
+		repos.Slots = NewRedisSlots(o.redis)
+		repos.Processed = NewRedisCache(o.redis)
+		repos.Nonces = NewRedisCache(o.redis)
+		repos.Counters = NewRedisCache(o.redis)
+		repos.Seen = NewRedisCache(o.redis)
+	}
+	if o.userCache != nil {
+		cached := NewCachedUserRepository(primary, o.userCache, o.userTTL, o.log, WithStaleIfError(o.userStale))
+		repos.User = cached
+		repos.UserCache = cached
+	}
+
+	return repos
 }
@@ -0,0 +1,185 @@
+// Package repotest isolates integration tests sharing one Postgres database.
+// Each test runs in a transaction rolled back when it ends, so tests leave
+// no data behind and may run in parallel.
+//
+// Behavior that depends on a commit cannot be tested this way: other
+// connections never see the test's rows, and notifications are only sent on
+// commit. The outbox relay of another process and the job listener's
+// LISTEN/NOTIFY are such cases, they need a test that commits and cleans up
+// after itself.
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq"
+
+	"github.com/romanitalian/carch-go/internal/pkg/database"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/queryreg"
+)
+
+// DSNEnv names the variable holding the database the tests run against
+const DSNEnv = "TEST_DATABASE_DSN"
+
+// shared is the database of every test of the process, migrated once
+var shared struct {
+	once sync.Once
+	db   *sqlx.DB
+	err  error
+}
+
+// DB connects to the database of DSNEnv and migrates it on first use. The
+// test is skipped when DSNEnv is not set.
+func DB(t testing.TB) *sqlx.DB {
+	t.Helper()
+
+	dsn := os.Getenv(DSNEnv)
+	if dsn == "" {
+		t.Skip(DSNEnv + " is not set")
+	}
+
+	shared.once.Do(func() {
+		shared.db, shared.err = sqlx.Connect("postgres", dsn)
+		if shared.err != nil {
+			return
+		}
+		shared.err = database.NewMigrationManager(shared.db.DB, logger.New()).
+			RunMigrations(context.Background(), migrationsDir())
+	})
+	if shared.err != nil {
+		t.Fatalf("test database: %v", shared.err)
+	}
+
+	return shared.db
+}
+
+// migrationsDir returns the migrations of the module, wherever the tests run from
+func migrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "migrations")
+}
+
+// Main runs the tests of m, to be called from TestMain, and then lists the
+// registered queries none of them ran (see package queryreg). The suite
+// fails for them, unless -run or -skip picked some of the tests, since such
+// a run leaves queries out. Nothing is checked unless DSNEnv is set.
+func Main(m *testing.M) int {
+	code := m.Run()
+	if code != 0 || os.Getenv(DSNEnv) == "" {
+		return code
+	}
+
+	unused := queryreg.Default.Unused()
+	if len(unused) == 0 {
+		return code
+	}
+	fmt.Fprintf(os.Stderr, "%d registered queries did not run:\n", len(unused))
+	for _, q := range unused {
+		fmt.Fprintf(os.Stderr, "  %s (%s)\n", q.Name, q.Origin)
+	}
+	if filtered() {
+		return code
+	}
+	return 1
+}
+
+// filtered tells whether the -run or -skip flag picked the tests to run
+func filtered() bool {
+	for _, name := range []string{"test.run", "test.skip"} {
+		if f := flag.Lookup(name); f != nil && f.Value.String() != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Tx is a transaction rolled back when its test ends. It is a
+// repository.Querier, to be given to repositories in place of the database,
+// and counts the statements run under their registered names like the
+// repository.InstrumentedQuerier. Like sql.Tx, it must not be used by
+// several goroutines at once.
+type Tx struct {
+	tx         *sqlx.Tx
+	savepoints int
+}
+
+// Begin starts the transaction of a test, see Tx
+func Begin(t testing.TB) *Tx {
+	t.Helper()
+
+	tx, err := DB(t).BeginTxx(context.Background(), nil)
+	if err != nil {
+		t.Fatalf("begin test transaction: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			t.Errorf("roll back test transaction: %v", err)
+		}
+	})
+
+	return &Tx{tx: tx}
+}
+
+func (tx *Tx) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	queryreg.Observe(query)
+	return tx.tx.GetContext(ctx, dest, query, args...)
+}
+
+func (tx *Tx) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	queryreg.Observe(query)
+	return tx.tx.SelectContext(ctx, dest, query, args...)
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	queryreg.Observe(query)
+	return tx.tx.ExecContext(ctx, query, args...)
+}
+
+// Savepoint is a transaction nested in a Tx, for code under test that
+// starts transactions of its own. Commit releases it, leaving its changes
+// to the enclosing transaction, and Rollback undoes them.
+type Savepoint struct {
+	*Tx
+	name string
+	done bool
+}
+
+// Begin starts a savepoint in the transaction
+func (tx *Tx) Begin(ctx context.Context) (*Savepoint, error) {
+	tx.savepoints++
+	name := fmt.Sprintf("repotest_%d", tx.savepoints)
+	if _, err := tx.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return nil, err
+	}
+	return &Savepoint{Tx: tx, name: name}, nil
+}
+
+// Commit releases the savepoint
+func (s *Savepoint) Commit() error {
+	return s.end("RELEASE SAVEPOINT ")
+}
+
+// Rollback undoes the changes made since the savepoint. Like sql.Tx, it
+// returns sql.ErrTxDone after Commit, so that it may be deferred.
+func (s *Savepoint) Rollback() error {
+	return s.end("ROLLBACK TO SAVEPOINT ")
+}
+
+func (s *Savepoint) end(statement string) error {
+	if s.done {
+		return sql.ErrTxDone
+	}
+	s.done = true
+	_, err := s.tx.ExecContext(context.Background(), statement+s.name)
+	return err
+}
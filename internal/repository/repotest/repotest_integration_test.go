@@ -0,0 +1,74 @@
+//go:build integration
+
+package repotest
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// marker is a value no other test writes
+const marker = "repotest-isolation"
+
+// getter is a Tx or the database
+type getter interface {
+	GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error
+}
+
+func countMarkers(t *testing.T, q getter) int {
+	t.Helper()
+
+	var n int
+	require.NoError(t, q.GetContext(context.Background(), &n, `SELECT count(*) FROM audit_log WHERE entity_id = $1`, marker))
+	return n
+}
+
+func insertMarker(t *testing.T, tx *Tx) {
+	t.Helper()
+
+	_, err := tx.ExecContext(context.Background(),
+		`INSERT INTO audit_log (action, entity, entity_id, created_at) VALUES ('test', 'repotest', $1, now())`, marker)
+	require.NoError(t, err)
+}
+
+func TestBegin_RolledBackAtCleanup(t *testing.T) {
+	t.Run("writes", func(t *testing.T) {
+		// Arrange
+		tx := Begin(t)
+
+		// Act
+		insertMarker(t, tx)
+
+		// Assert
+		assert.Equal(t, 1, countMarkers(t, tx))
+		assert.Zero(t, countMarkers(t, DB(t)), "other connections do not see uncommitted rows")
+	})
+
+	// Assert
+	assert.Zero(t, countMarkers(t, DB(t)))
+}
+
+func TestSavepoint(t *testing.T) {
+	// Arrange
+	tx := Begin(t)
+	ctx := context.Background()
+
+	// Act: a committed nested transaction keeps its rows, a rolled back one does not
+	committed, err := tx.Begin(ctx)
+	require.NoError(t, err)
+	insertMarker(t, committed.Tx)
+	require.NoError(t, committed.Commit())
+
+	rolledBack, err := tx.Begin(ctx)
+	require.NoError(t, err)
+	insertMarker(t, rolledBack.Tx)
+	require.NoError(t, rolledBack.Rollback())
+
+	// Assert
+	assert.Equal(t, 1, countMarkers(t, tx))
+	assert.ErrorIs(t, committed.Rollback(), sql.ErrTxDone)
+}
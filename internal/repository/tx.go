@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// txKey is the context key of the transaction of Transactor.InTx
+type txKey struct{}
+
+// Transactor runs functions within a transaction of the primary database.
+// The repositories built on the same database run their statements in the
+// transaction of the context they are called with, see querier.
+type Transactor struct {
+	db *sqlx.DB
+}
+
+// NewTransactor creates a transactor of db
+func NewTransactor(db *sqlx.DB) *Transactor {
+	return &Transactor{db: db}
+}
+
+// InTx runs fn within a transaction, committed when fn returns nil and
+// rolled back when it fails or panics. Called within fn, it joins the
+// transaction already running.
+func (t *Transactor) InTx(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if inTx(ctx) {
+		return fn(ctx)
+	}
+
+	tx, err := t.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err := fn(context.WithValue(ctx, txKey{}, NewInstrumentedQuerier(tx))); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return errors.Join(err, rbErr)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+// querier returns the transaction ctx runs in, db outside of one
+func querier(ctx context.Context, db Querier) Querier {
+	if tx, ok := ctx.Value(txKey{}).(Querier); ok {
+		return tx
+	}
+	return db
+}
+
+// inTx reports whether ctx runs in a transaction
+func inTx(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(Querier)
+	return ok
+}
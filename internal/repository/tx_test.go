@@ -0,0 +1,94 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/idgen"
+)
+
+// newTxRepositories returns a transactor and the user and outbox
+// repositories of the same mocked database
+func newTxRepositories(t *testing.T) (*Transactor, *UserRepository, *OutboxRepository, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherEqual))
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	sqlxDB := sqlx.NewDb(db, "sqlmock")
+	gen := []generate.Option{generate.WithClock(clock.NewFixed(testNow)), generate.WithIDGenerator(idgen.NewSequence())}
+	return NewTransactor(sqlxDB), NewUserRepository(NewInstrumentedQuerier(sqlxDB), WithUserGenerators(gen...)),
+		NewOutboxRepository(NewInstrumentedQuerier(sqlxDB), gen...), mock
+}
+
+func TestTransactor_InTx_Commit(t *testing.T) {
+	// Arrange
+	tx, users, outbox, mock := newTxRepositories(t)
+	event := &domain.OutboxEvent{ID: "event-1", Type: domain.EventUserCleanup, Payload: []byte(`{}`)}
+	mock.ExpectBegin()
+	mock.ExpectExec(emailChangeCancelQuery).WithArgs("user-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(outboxAppendQuery).WithArgs("event-1", domain.EventUserCleanup, "", []byte(`{}`), testNow, nil).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// Act
+	err := tx.InTx(context.Background(), func(ctx context.Context) error {
+		if err := users.CancelEmailChange(ctx, "user-1"); err != nil {
+			return err
+		}
+		// A nested call joins the running transaction
+		return tx.InTx(ctx, func(ctx context.Context) error {
+			return outbox.Append(ctx, event)
+		})
+	})
+
+	// Assert
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTransactor_InTx_Rollback(t *testing.T) {
+	// Arrange
+	tx, users, _, mock := newTxRepositories(t)
+	failure := errors.New("hook failed")
+	mock.ExpectBegin()
+	mock.ExpectExec(emailChangeCancelQuery).WithArgs("user-1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	// Act
+	err := tx.InTx(context.Background(), func(ctx context.Context) error {
+		if err := users.CancelEmailChange(ctx, "user-1"); err != nil {
+			return err
+		}
+		return failure
+	})
+
+	// Assert
+	assert.ErrorIs(t, err, failure)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTransactor_InTx_RollbackOnPanic(t *testing.T) {
+	// Arrange
+	tx, _, _, mock := newTxRepositories(t)
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+
+	// Act & Assert
+	assert.PanicsWithValue(t, "boom", func() {
+		_ = tx.InTx(context.Background(), func(ctx context.Context) error {
+			panic("boom")
+		})
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -2,58 +2,153 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
-	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 
 	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
 )
 
+// userConstraints maps the constraints of the users table and of the
+// tables written along with it
+var userConstraints = constraintErrors{
+	"users_pkey":                           domain.ErrConflict,
+	"users_email_live_key":                 domain.ErrEmailTaken,
+	"outbox_pkey":                          domain.ErrConflict,
+	"user_tombstones_pkey":                 domain.ErrConflict,
+	"email_change_requests_pkey":           domain.ErrConflict,
+	"email_change_requests_user_id_fkey":   domain.ErrUserNotFound,
+	"email_change_requests_token_hash_key": domain.ErrConflict,
+}
+
 type UserRepository struct {
-	db *sqlx.DB
+	db      Querier
+	replica Querier
+	log     *logger.Logger
+	// orderByID lists users in creation order by ID, see WithIDOrder
+	orderByID bool
+	gen       generate.Generators
+}
+
+// UserRepositoryOption is a function that configures a UserRepository
+type UserRepositoryOption func(*UserRepository)
+
+// WithReadReplica serves user reads from a replica unless the context
+// requires the primary, see consistency.WithPrimary
+func WithReadReplica(replica Querier) UserRepositoryOption {
+	return func(r *UserRepository) {
+		r.replica = replica
+	}
+}
+
+// WithUserLogger sets the logger unmapped constraint violations are reported to
+func WithUserLogger(log *logger.Logger) UserRepositoryOption {
+	return func(r *UserRepository) {
+		if log != nil {
+			r.log = log
+		}
+	}
+}
+
+// WithUserGenerators sets how users and their outbox events are stamped and identified
+func WithUserGenerators(opts ...generate.Option) UserRepositoryOption {
+	return func(r *UserRepository) {
+		r.gen = generate.New(opts...)
+	}
+}
+
+// WithIDOrder lists users in creation order by ID instead of created_at,
+// which the primary key index serves without a sort. It is only correct with
+// time-ordered IDs such as idgen.UUIDv7: users with random IDs are listed in
+// no particular order among them.
+func WithIDOrder() UserRepositoryOption {
+	return func(r *UserRepository) {
+		r.orderByID = true
+	}
 }
 
 // NewUserRepository creates a new user repository
-func NewUserRepository(db *sqlx.DB) *UserRepository {
-	return &UserRepository{
-		db: db,
+func NewUserRepository(db Querier, opts ...UserRepositoryOption) *UserRepository {
+	r := &UserRepository{
+		db:  db,
+		log: logger.New(),
+		gen: generate.New(),
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// primary returns the primary database, or the transaction ctx runs in
+func (r *UserRepository) primary(ctx context.Context) Querier {
+	return querier(ctx, r.db)
+}
+
+// reader returns the database user reads are served from. Reads within a
+// transaction go through it, so that they see its changes.
+func (r *UserRepository) reader(ctx context.Context) Querier {
+	if r.replica == nil || consistency.PrimaryRequired(ctx) || inTx(ctx) {
+		return r.primary(ctx)
 	}
+	return r.replica
 }
 
+var userCreateQuery = registerQuery("users.create", "(*UserRepository).Create", `
+		INSERT INTO users (id, email, password_hash, name, metadata, metadata_encrypted, created_at, updated_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, COALESCE($5, '{}'), COALESCE($6, '{}'), $7, $8, $8)
+		RETURNING id`)
+
 func (r *UserRepository) Create(ctx context.Context, user *domain.User) error {
 	// Only generate a new ID if one is not provided (useful for testing)
 	if user.ID == "" {
-		user.ID = uuid.New().String()
+		user.ID = r.gen.IDs.NewID()
 	}
 
-	user.CreatedAt = time.Now()
-	user.UpdatedAt = time.Now()
+	now := r.gen.Clock.Now()
+	user.CreatedAt = now
+	user.UpdatedAt = now
 
-	query := `
-		INSERT INTO users (id, email, password_hash, name, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id`
+	query := userCreateQuery
 
-	return r.db.QueryRowContext(ctx, query,
+	err := r.primary(ctx).GetContext(ctx, &user.ID, query,
 		user.ID,
 		user.Email,
 		user.Password,
 		user.Name,
+		user.Metadata,
+		user.SealedMetadata,
 		user.CreatedAt,
 		user.UpdatedAt,
-	).Scan(&user.ID)
+	)
+	if err != nil {
+		return userConstraints.translate(err, logger.FromContextOr(ctx, r.log))
+	}
+
+	return nil
 }
 
+var userGetByIDQuery = registerQuery("users.get_by_id", "(*UserRepository).GetByID", `
+		SELECT id, email, name, metadata, metadata_encrypted, created_at, updated_at
+		FROM users
+		WHERE id = $1 AND deleted_at IS NULL`)
+
 func (r *UserRepository) GetByID(ctx context.Context, id string) (*domain.User, error) {
 	var user domain.User
 
-	query := `
-		SELECT id, email, name, created_at, updated_at
-		FROM users
-		WHERE id = $1`
+	query := userGetByIDQuery
 
-	err := r.db.GetContext(ctx, &user, query, id)
+	err := r.reader(ctx).GetContext(ctx, &user, query, id)
 	if err != nil {
 		return nil, err
 	}
@@ -61,22 +156,50 @@ func (r *UserRepository) GetByID(ctx context.Context, id string) (*domain.User,
 	return &user, nil
 }
 
-func (r *UserRepository) Update(ctx context.Context, user *domain.User) error {
-	user.UpdatedAt = time.Now()
+var userGetByIDsQuery = registerQuery("users.get_by_ids", "(*UserRepository).GetByIDs", `
+		SELECT id, email, name, metadata, metadata_encrypted, created_at, updated_at
+		FROM users
+		WHERE id = ANY($1) AND deleted_at IS NULL`)
+
+// GetByIDs returns the users with the given IDs in a single query.
+// IDs that do not match a live user are absent from the result.
+func (r *UserRepository) GetByIDs(ctx context.Context, ids []string) ([]*domain.User, error) {
+	var users []*domain.User
+
+	query := userGetByIDsQuery
 
-	query := `
+	err := r.reader(ctx).SelectContext(ctx, &users, query, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
+
+var userUpdateQuery = registerQuery("users.update", "(*UserRepository).Update", `
 		UPDATE users
-		SET email = $1, name = $2, updated_at = $3
-		WHERE id = $4`
+		SET email = $1, name = $2, updated_at = $3, last_seen_at = $3,
+			metadata = COALESCE($4, metadata),
+			metadata_encrypted = COALESCE($5, metadata_encrypted)
+		WHERE id = $6 AND deleted_at IS NULL`)
+
+// Update stores the email, name and metadata of the user. Nil metadata is
+// left as stored.
+func (r *UserRepository) Update(ctx context.Context, user *domain.User) error {
+	user.UpdatedAt = r.gen.Clock.Now()
+
+	query := userUpdateQuery
 
-	result, err := r.db.ExecContext(ctx, query,
+	result, err := r.primary(ctx).ExecContext(ctx, query,
 		user.Email,
 		user.Name,
 		user.UpdatedAt,
+		user.Metadata,
+		user.SealedMetadata,
 		user.ID,
 	)
 	if err != nil {
-		return err
+		return userConstraints.translate(err, logger.FromContextOr(ctx, r.log))
 	}
 
 	rows, err := result.RowsAffected()
@@ -91,14 +214,61 @@ func (r *UserRepository) Update(ctx context.Context, user *domain.User) error {
 	return nil
 }
 
-func (r *UserRepository) Delete(ctx context.Context, id string) error {
-	query := `DELETE FROM users WHERE id = $1`
+var userReplaceSealedMetadataQuery = registerQuery("users.replace_sealed_metadata", "(*UserRepository).ReplaceSealedMetadata", `
+		UPDATE users SET metadata_encrypted = $1
+		WHERE id = $2 AND deleted_at IS NULL AND metadata_encrypted = COALESCE($3, '{}')`)
+
+// ReplaceSealedMetadata replaces the sealed metadata of a live user unless
+// it changed since old was read. The user is not considered changed, so
+// neither updated_at nor last_seen_at move.
+func (r *UserRepository) ReplaceSealedMetadata(ctx context.Context, id string, old, sealed domain.SealedMetadata) (bool, error) {
+	query := userReplaceSealedMetadataQuery
 
-	result, err := r.db.ExecContext(ctx, query, id)
+	result, err := r.primary(ctx).ExecContext(ctx, query, sealed, id, old)
+	if err != nil {
+		return false, err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rows > 0, nil
+}
+
+var userDeleteQuery = registerQuery("users.delete", "(*UserRepository).Delete", `
+		WITH deleted AS (
+			UPDATE users SET deleted_at = $2
+			WHERE id = $1 AND deleted_at IS NULL
+			RETURNING id
+		),`+sequenceEvents(`SELECT id::text, 1, $2::timestamptz FROM deleted`)+`
+		INSERT INTO outbox (id, event_type, tenant, payload, created_at, aggregate_id, aggregate_seq)
+		SELECT $3, $4, $5, $6, created_at, aggregate_id, seq FROM seqs`)
+
+// Delete soft-deletes the user and records a user.deleted event in the outbox
+// within the same statement, so the event is emitted if and only if the row
+// changed. The event is the next in the sequence of the user's events.
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	deletedAt := r.gen.Clock.Now()
+	payload, err := json.Marshal(domain.Tombstone{ID: id, DeletedAt: deletedAt})
 	if err != nil {
 		return err
 	}
 
+	query := userDeleteQuery
+	result, err := r.primary(ctx).ExecContext(ctx, query,
+		id,
+		deletedAt,
+		r.gen.IDs.NewID(),
+		domain.EventUserDeleted,
+		tenant.FromContext(ctx),
+		payload,
+	)
+	if err != nil {
+		return userConstraints.translate(err, logger.FromContextOr(ctx, r.log))
+	}
+
 	rows, err := result.RowsAffected()
 	if err != nil {
 		return err
@@ -108,21 +278,296 @@ func (r *UserRepository) Delete(ctx context.Context, id string) error {
 		return domain.ErrUserNotFound
 	}
 
+	wideevent.Add(ctx, wideevent.EventsPublished, 1)
 	return nil
 }
 
-func (r *UserRepository) List(ctx context.Context) ([]*domain.User, error) {
-	var users []*domain.User
+var userListQuery = registerQuery("users.list", "(*UserRepository).List", `
+		SELECT id, email, name, metadata, metadata_encrypted, created_at, updated_at, COUNT(*) OVER () AS total
+		FROM users
+		WHERE `)
+
+// userRow is a listed user along with the number of users of the whole list
+type userRow struct {
+	domain.User
+	Total int64 `db:"total"`
+}
+
+// List returns the page of the live users of opts.Filter ordered as
+// requested, every user when opts.Limit is 0. Names are ordered with
+// opts.Collation, which must exist in the database (see CollationExists).
+func (r *UserRepository) List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	var rows []userRow
+
+	// A NULL limit lists every user
+	var limit interface{}
+	if opts.Limit > 0 {
+		limit = opts.Limit
+	}
+	where, args := userFilterWhere(opts.Filter, nil)
+	args = append(args, limit, opts.Offset)
+	query := userListQuery + where + `
+		ORDER BY ` + userOrderBy(opts, r.orderByID) + `
+		LIMIT $` + strconv.Itoa(len(args)-1) + ` OFFSET $` + strconv.Itoa(len(args))
+
+	err := r.reader(ctx).SelectContext(ctx, &rows, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &domain.UserPage{Users: make([]*domain.User, 0, len(rows)), Limit: opts.Limit, Offset: opts.Offset}
+	for i := range rows {
+		page.Users = append(page.Users, &rows[i].User)
+		page.Total = rows[i].Total
+	}
+	// A page past the end holds no row to tell the total
+	if len(rows) == 0 && opts.Offset > 0 {
+		if page.Total, err = r.CountUsers(ctx, opts.Filter); err != nil {
+			return nil, err
+		}
+	}
 
-	query := `
-		SELECT id, email, name, created_at, updated_at
+	return page, nil
+}
+
+var userCountQuery = registerQuery("users.count", "(*UserRepository).CountUsers", `SELECT COUNT(*) FROM users WHERE `)
+
+// CountUsers returns how many live users match the filter, read from the primary
+func (r *UserRepository) CountUsers(ctx context.Context, filter domain.UserFilter) (int64, error) {
+	var count int64
+
+	where, args := userFilterWhere(filter, nil)
+	query := userCountQuery + where
+
+	err := r.primary(ctx).GetContext(ctx, &count, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+var userListIDsQuery = registerQuery("users.list_ids", "(*UserRepository).ListUserIDs", `SELECT id FROM users WHERE `)
+
+// ListUserIDs returns up to limit IDs of live users matching the filter in
+// ID order, read from the primary
+func (r *UserRepository) ListUserIDs(ctx context.Context, filter domain.UserFilter, limit int) ([]string, error) {
+	var ids []string
+
+	where, args := userFilterWhere(filter, []interface{}{limit})
+	query := userListIDsQuery + where + ` ORDER BY id LIMIT $1`
+
+	err := r.primary(ctx).SelectContext(ctx, &ids, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+var userListRecentlySeenQuery = registerQuery("users.list_recently_seen", "(*UserRepository).ListRecentlySeen", `
+		SELECT id, email, name, metadata, metadata_encrypted, created_at, updated_at
 		FROM users
-		ORDER BY created_at DESC`
+		WHERE deleted_at IS NULL
+		ORDER BY last_seen_at DESC NULLS LAST
+		LIMIT $1`)
 
-	err := r.db.SelectContext(ctx, &users, query)
+// ListRecentlySeen returns up to limit live users, most recently seen first
+func (r *UserRepository) ListRecentlySeen(ctx context.Context, limit int) ([]*domain.User, error) {
+	var users []*domain.User
+
+	query := userListRecentlySeenQuery
+
+	err := r.reader(ctx).SelectContext(ctx, &users, query, limit)
 	if err != nil {
 		return nil, err
 	}
 
 	return users, nil
 }
+
+var userMarkSeenQuery = registerQuery("users.mark_seen", "(*UserRepository).MarkSeen", `
+		UPDATE users SET last_seen_at = $1
+		WHERE id = $2 AND deleted_at IS NULL AND (last_seen_at IS NULL OR last_seen_at < $1)`)
+
+// MarkSeen moves last_seen_at of a live user forward to at. The user is not
+// considered changed, so updated_at does not move.
+func (r *UserRepository) MarkSeen(ctx context.Context, id string, at time.Time) error {
+	query := userMarkSeenQuery
+
+	_, err := r.primary(ctx).ExecContext(ctx, query, at, id)
+	return err
+}
+
+// userFilterWhere returns the condition selecting the live users of the
+// filter, numbering its placeholders after the args already given
+func userFilterWhere(f domain.UserFilter, args []interface{}) (string, []interface{}) {
+	conds := []string{"deleted_at IS NULL"}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		conds = append(conds, cond+" $"+strconv.Itoa(len(args)))
+	}
+
+	if f.EmailDomain != "" {
+		add("lower(split_part(email, '@', 2)) =", strings.ToLower(f.EmailDomain))
+	}
+	if f.NamePrefix != "" {
+		add("name ^@", f.NamePrefix)
+	}
+	if !f.CreatedAfter.IsZero() {
+		add("created_at >", f.CreatedAfter)
+	}
+	if !f.CreatedBefore.IsZero() {
+		add("created_at <", f.CreatedBefore)
+	}
+	if f.Where != nil {
+		var cond string
+		cond, args = userConditionSQL(f.Where, args)
+		conds = append(conds, "("+cond+")")
+	}
+
+	return strings.Join(conds, " AND "), args
+}
+
+// userConditionColumns are the SQL expressions the fields of a
+// domain.UserCondition are compared on
+var userConditionColumns = map[domain.UserField]string{
+	domain.UserFieldID:          "id",
+	domain.UserFieldEmail:       "email",
+	domain.UserFieldEmailDomain: "lower(split_part(email, '@', 2))",
+	domain.UserFieldName:        "name",
+	domain.UserFieldCreatedAt:   "created_at",
+	domain.UserFieldUpdatedAt:   "updated_at",
+}
+
+var sqlComparisons = map[domain.Comparison]string{
+	domain.CompareEq: "=", domain.CompareNe: "<>",
+	domain.CompareLt: "<", domain.CompareLte: "<=", domain.CompareGt: ">", domain.CompareGte: ">=",
+	domain.ComparePrefix: "^@",
+}
+
+// userConditionSQL returns c as SQL, numbering its placeholders after the
+// args already given. A term of an unknown field or comparison renders as
+// FALSE rather than as SQL of the caller's making.
+func userConditionSQL(c domain.UserCondition, args []interface{}) (string, []interface{}) {
+	switch c := c.(type) {
+	case *domain.UserNot:
+		cond, args := userConditionSQL(c.X, args)
+		return "NOT " + cond, args
+	case *domain.UserAnd:
+		left, args := userConditionSQL(c.Left, args)
+		right, args := userConditionSQL(c.Right, args)
+		return "(" + left + " AND " + right + ")", args
+	case *domain.UserOr:
+		left, args := userConditionSQL(c.Left, args)
+		right, args := userConditionSQL(c.Right, args)
+		return "(" + left + " OR " + right + ")", args
+	case *domain.UserTerm:
+		column, ok := userConditionColumns[c.Field]
+		op, known := sqlComparisons[c.Op]
+		if !ok || !known {
+			return "FALSE", args
+		}
+		args = append(args, c.Value)
+		return column + " " + op + " $" + strconv.Itoa(len(args)), args
+	}
+	return "FALSE", args
+}
+
+// userOrderBy returns the order of a user list, newest first and names A to
+// Z unless opts.SortDir says otherwise. IDs break ties in the same direction.
+func userOrderBy(opts domain.UserListOptions, byID bool) string {
+	if opts.SortBy != domain.UserSortName {
+		dir := " DESC"
+		if opts.SortDir == domain.SortAsc {
+			dir = ""
+		}
+		if byID {
+			return "id" + dir
+		}
+		return "created_at" + dir
+	}
+
+	dir := ""
+	if opts.SortDir == domain.SortDesc {
+		dir = " DESC"
+	}
+	name := "name"
+	if opts.Collation != "" {
+		name += " COLLATE " + pq.QuoteIdentifier(opts.Collation)
+	}
+	return name + dir + ", id" + dir
+}
+
+var collationExistsQuery = registerQuery("users.collation_exists", "(*UserRepository).CollationExists", `SELECT EXISTS (SELECT 1 FROM pg_collation WHERE collname = $1)`)
+
+// CollationExists reports whether a collation with the given name is defined
+func (r *UserRepository) CollationExists(ctx context.Context, collation string) (bool, error) {
+	var exists bool
+	query := collationExistsQuery
+
+	err := r.primary(ctx).GetContext(ctx, &exists, query, collation)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+var userListTombstonesQuery = registerQuery("users.list_tombstones", "(*UserRepository).ListTombstones", `
+		SELECT id, deleted_at FROM users
+		WHERE deleted_at IS NOT NULL AND deleted_at > $1
+		UNION ALL
+		SELECT id, deleted_at FROM user_tombstones
+		WHERE deleted_at > $1
+		ORDER BY deleted_at`)
+
+// ListTombstones returns users deleted after since, both soft-deleted and purged
+func (r *UserRepository) ListTombstones(ctx context.Context, since time.Time) ([]*domain.Tombstone, error) {
+	var tombstones []*domain.Tombstone
+	query := userListTombstonesQuery
+
+	err := r.reader(ctx).SelectContext(ctx, &tombstones, query, since)
+	if err != nil {
+		return nil, err
+	}
+
+	return tombstones, nil
+}
+
+var userPurgeQuery = registerQuery("users.purge", "(*UserRepository).Purge", `
+		WITH purged AS (
+			DELETE FROM users
+			WHERE deleted_at IS NOT NULL AND deleted_at < $1
+			RETURNING id, deleted_at
+		)
+		INSERT INTO user_tombstones (id, deleted_at)
+		SELECT id, deleted_at FROM purged
+		ON CONFLICT (id) DO NOTHING`)
+
+// Purge hard-deletes users soft-deleted before deletedBefore, keeping a
+// compact tombstone row for each of them
+func (r *UserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	query := userPurgeQuery
+
+	result, err := r.primary(ctx).ExecContext(ctx, query, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+var userExpireTombstonesQuery = registerQuery("users.expire_tombstones", "(*UserRepository).ExpireTombstones", `DELETE FROM user_tombstones WHERE deleted_at < $1`)
+
+// ExpireTombstones removes tombstones older than the retention horizon
+func (r *UserRepository) ExpireTombstones(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	query := userExpireTombstonesQuery
+
+	result, err := r.primary(ctx).ExecContext(ctx, query, deletedBefore)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
@@ -0,0 +1,202 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
+)
+
+// CachedUserRepository caches users looked up by ID. Writes through it
+// invalidate the cached user; reads whose context requires the primary skip
+// the cache and refresh it with what they read. With WithStaleIfError, users
+// are kept past their TTL and served when the database is unreachable.
+type CachedUserRepository struct {
+	domain.UserRepository
+	cache cache.Cache
+	ttl   time.Duration
+	log   *logger.Logger
+	// staleGrace is how long users are kept past ttl, see WithStaleIfError
+	staleGrace time.Duration
+	clock      clock.Clock
+}
+
+// CachedUserOption configures a CachedUserRepository
+type CachedUserOption func(*CachedUserRepository)
+
+// WithStaleIfError keeps cached users for grace past their TTL. A lookup
+// of an expired user still goes to the database, but when the database is
+// unreachable the expired user is served instead of the error, reported to
+// consistency.ReportStale with its age. Errors of the lookup itself, such
+// as domain.ErrUserNotFound, are returned as they are.
+func WithStaleIfError(grace time.Duration) CachedUserOption {
+	return func(r *CachedUserRepository) {
+		r.staleGrace = max(grace, 0)
+	}
+}
+
+// WithCacheClock tells the age of cached users with c
+func WithCacheClock(c clock.Clock) CachedUserOption {
+	return func(r *CachedUserRepository) {
+		r.clock = clock.OrReal(c)
+	}
+}
+
+// NewCachedUserRepository decorates repo with a cache keeping users for ttl
+func NewCachedUserRepository(repo domain.UserRepository, c cache.Cache, ttl time.Duration, log *logger.Logger, opts ...CachedUserOption) *CachedUserRepository {
+	r := &CachedUserRepository{
+		UserRepository: repo,
+		cache:          c,
+		ttl:            ttl,
+		log:            log,
+		clock:          clock.Real,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// cachedUser is the cache entry of a user
+type cachedUser struct {
+	User     domain.User
+	CachedAt time.Time
+}
+
+func userCacheKey(id string) string {
+	return "user:" + id
+}
+
+func (r *CachedUserRepository) GetByID(ctx context.Context, id string) (*domain.User, error) {
+	var stale *cachedUser
+	if !consistency.PrimaryRequired(ctx) {
+		if entry, ok := r.cached(ctx, id); ok {
+			if r.clock.Now().Sub(entry.CachedAt) < r.ttl {
+				wideevent.Add(ctx, wideevent.CacheHits, 1)
+				return &entry.User, nil
+			}
+			stale = entry
+		}
+		wideevent.Add(ctx, wideevent.CacheMisses, 1)
+	}
+
+	user, err := r.UserRepository.GetByID(ctx, id)
+	if err != nil {
+		if stale != nil && unavailable(ctx, err) {
+			metrics.UserCacheStaleServed.Inc()
+			consistency.ReportStale(ctx, r.clock.Now().Sub(stale.CachedAt))
+			return &stale.User, nil
+		}
+		return nil, err
+	}
+
+	r.store(ctx, user)
+	return user, nil
+}
+
+func (r *CachedUserRepository) Update(ctx context.Context, user *domain.User) error {
+	if err := r.UserRepository.Update(ctx, user); err != nil {
+		return err
+	}
+	r.invalidate(ctx, user.ID)
+	return nil
+}
+
+// ReplaceSealedMetadata is passed to the decorated repository, which must
+// implement domain.SealedMetadataRepository
+func (r *CachedUserRepository) ReplaceSealedMetadata(ctx context.Context, id string, old, sealed domain.SealedMetadata) (bool, error) {
+	repo, ok := r.UserRepository.(domain.SealedMetadataRepository)
+	if !ok {
+		return false, errors.ErrUnsupported
+	}
+	replaced, err := repo.ReplaceSealedMetadata(ctx, id, old, sealed)
+	if err != nil {
+		return false, err
+	}
+	r.invalidate(ctx, id)
+	return replaced, nil
+}
+
+func (r *CachedUserRepository) Delete(ctx context.Context, id string) error {
+	if err := r.UserRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	r.invalidate(ctx, id)
+	return nil
+}
+
+func (r *CachedUserRepository) ConfirmEmailChange(ctx context.Context, change *domain.EmailChange) error {
+	if err := r.UserRepository.ConfirmEmailChange(ctx, change); err != nil {
+		return err
+	}
+	r.invalidate(ctx, change.UserID)
+	return nil
+}
+
+// cached returns the cached user, expired or not. Users past the stale
+// grace are left out. Cache failures are logged and treated as misses so
+// that the database keeps serving reads.
+func (r *CachedUserRepository) cached(ctx context.Context, id string) (*cachedUser, bool) {
+	data, ok, err := r.cache.Get(ctx, userCacheKey(id))
+	if err != nil {
+		r.log.Warn("Failed to read user from cache", map[string]interface{}{"user_id": id, "error": err.Error()})
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+
+	var entry cachedUser
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&entry); err != nil {
+		r.log.Warn("Failed to decode cached user", map[string]interface{}{"user_id": id, "error": err.Error()})
+		return nil, false
+	}
+	if r.clock.Now().Sub(entry.CachedAt) >= r.ttl+r.staleGrace {
+		return nil, false
+	}
+
+	return &entry, true
+}
+
+// Ping reports whether the cache backend is available
+func (r *CachedUserRepository) Ping(ctx context.Context) error {
+	return cache.Ping(ctx, r.cache)
+}
+
+// Prime caches the user as a lookup would, so that warming the cache ahead
+// of reads does not go through the database twice
+func (r *CachedUserRepository) Prime(ctx context.Context, user *domain.User) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(cachedUser{User: *user, CachedAt: r.clock.Now()}); err != nil {
+		return err
+	}
+	return r.cache.Set(ctx, userCacheKey(user.ID), buf.Bytes(), r.ttl+r.staleGrace)
+}
+
+// Invalidate drops the cached user, so that its next lookup reads the database
+func (r *CachedUserRepository) Invalidate(ctx context.Context, id string) error {
+	return r.cache.Delete(ctx, userCacheKey(id))
+}
+
+func (r *CachedUserRepository) store(ctx context.Context, user *domain.User) {
+	if err := r.Prime(ctx, user); err != nil {
+		r.log.Warn("Failed to cache user", map[string]interface{}{"user_id": user.ID, "error": err.Error()})
+	}
+}
+
+func (r *CachedUserRepository) invalidate(ctx context.Context, id string) {
+	if err := r.Invalidate(ctx, id); err != nil {
+		r.log.Warn("Failed to invalidate cached user", map[string]interface{}{"user_id": id, "error": err.Error()})
+	}
+}
@@ -0,0 +1,231 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// laggingUserRepository writes to the primary and serves plain reads from a
+// replica that only catches up when told to
+type laggingUserRepository struct {
+	domain.UserRepository
+	primary map[string]domain.User
+	replica map[string]domain.User
+	reads   int
+	// err fails the reads, e.g. as an unreachable database would
+	err error
+}
+
+func newLaggingUserRepository() *laggingUserRepository {
+	return &laggingUserRepository{
+		primary: map[string]domain.User{},
+		replica: map[string]domain.User{},
+	}
+}
+
+func (r *laggingUserRepository) GetByID(ctx context.Context, id string) (*domain.User, error) {
+	r.reads++
+	if r.err != nil {
+		return nil, r.err
+	}
+	source := r.replica
+	if consistency.PrimaryRequired(ctx) {
+		source = r.primary
+	}
+
+	user, ok := source[id]
+	if !ok {
+		return nil, domain.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *laggingUserRepository) Update(ctx context.Context, user *domain.User) error {
+	r.primary[user.ID] = *user
+	return nil
+}
+
+func (r *laggingUserRepository) catchUp() {
+	for id, user := range r.primary {
+		r.replica[id] = user
+	}
+}
+
+func TestCachedUserRepository_PrimaryReadBypassesStaleCache(t *testing.T) {
+	// Arrange
+	inner := newLaggingUserRepository()
+	inner.primary["user-123"] = domain.User{ID: "user-123", Name: "Old", Password: "hash"}
+	inner.catchUp()
+	repo := NewCachedUserRepository(inner, cache.NewMemory(), time.Minute, logger.New())
+	ctx := context.Background()
+
+	_, err := repo.GetByID(ctx, "user-123")
+	require.NoError(t, err)
+	// Renamed behind the cache's back, e.g. through another replica of the service
+	inner.primary["user-123"] = domain.User{ID: "user-123", Name: "New", Password: "hash"}
+
+	// Act
+	cached, cachedErr := repo.GetByID(ctx, "user-123")
+	fresh, freshErr := repo.GetByID(consistency.WithPrimary(ctx), "user-123")
+	refreshed, refreshedErr := repo.GetByID(ctx, "user-123")
+
+	// Assert
+	require.NoError(t, cachedErr)
+	require.NoError(t, freshErr)
+	require.NoError(t, refreshedErr)
+	assert.Equal(t, "Old", cached.Name)
+	assert.Equal(t, "New", fresh.Name)
+	assert.Equal(t, "New", refreshed.Name, "the primary read refreshes the cache")
+	assert.Equal(t, "hash", refreshed.Password)
+	assert.Equal(t, 2, inner.reads)
+}
+
+func TestCachedUserRepository_UpdateInvalidates(t *testing.T) {
+	// Arrange
+	inner := newLaggingUserRepository()
+	inner.primary["user-123"] = domain.User{ID: "user-123", Name: "Old"}
+	inner.catchUp()
+	repo := NewCachedUserRepository(inner, cache.NewMemory(), time.Minute, logger.New())
+	ctx := context.Background()
+
+	_, err := repo.GetByID(ctx, "user-123")
+	require.NoError(t, err)
+
+	// Act
+	require.NoError(t, repo.Update(ctx, &domain.User{ID: "user-123", Name: "New"}))
+	inner.catchUp()
+	user, err := repo.GetByID(ctx, "user-123")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "New", user.Name)
+}
+
+func TestCachedUserRepository_Expires(t *testing.T) {
+	// Arrange
+	inner := newLaggingUserRepository()
+	inner.primary["user-123"] = domain.User{ID: "user-123"}
+	inner.catchUp()
+	repo := NewCachedUserRepository(inner, cache.NewMemory(), time.Millisecond, logger.New())
+	ctx := context.Background()
+
+	// Act
+	_, err := repo.GetByID(ctx, "user-123")
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+	_, err = repo.GetByID(ctx, "user-123")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 2, inner.reads)
+}
+
+func TestCachedUserRepository_StaleIfError(t *testing.T) {
+	// connRefused is the error of a database refusing connections
+	connRefused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+
+	tests := []struct {
+		name string
+		// age is how long ago the user was cached, with a TTL of a minute
+		// and a grace of five
+		age     time.Duration
+		primary bool
+		err     error
+		want    string
+		wantErr error
+		// wantStale is the staleness reported, 0 when none is
+		wantStale time.Duration
+	}{
+		{name: "fresh entry", age: 30 * time.Second, err: connRefused, want: "Cached"},
+		{name: "stale entry, database up", age: 2 * time.Minute, want: "Current"},
+		{name: "stale entry, database unreachable", age: 2 * time.Minute, err: connRefused, want: "Cached", wantStale: 2 * time.Minute},
+		{name: "stale entry, failover in progress", age: 3 * time.Minute, err: fmt.Errorf("get user: %w", &pq.Error{Code: "57P03"}), want: "Cached", wantStale: 3 * time.Minute},
+		{name: "stale entry, user gone", age: 2 * time.Minute, err: domain.ErrUserNotFound, wantErr: domain.ErrUserNotFound},
+		{name: "stale entry, read from the primary", age: 2 * time.Minute, primary: true, err: connRefused, wantErr: connRefused},
+		{name: "entry beyond the grace", age: 7 * time.Minute, err: connRefused, wantErr: connRefused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			inner := newLaggingUserRepository()
+			inner.primary["user-123"] = domain.User{ID: "user-123", Name: "Cached"}
+			inner.catchUp()
+			now := clock.NewFixed(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+			repo := NewCachedUserRepository(inner, cache.NewMemory(), time.Minute, logger.New(),
+				WithStaleIfError(5*time.Minute), WithCacheClock(now))
+			_, err := repo.GetByID(context.Background(), "user-123")
+			require.NoError(t, err)
+
+			now.Advance(tt.age)
+			inner.primary["user-123"] = domain.User{ID: "user-123", Name: "Current"}
+			inner.catchUp()
+			inner.err = tt.err
+			ctx, staleness := consistency.TrackStaleness(context.Background())
+			if tt.primary {
+				ctx = consistency.WithPrimary(ctx)
+			}
+			before := testutil.ToFloat64(metrics.UserCacheStaleServed)
+
+			// Act
+			user, err := repo.GetByID(ctx, "user-123")
+
+			// Assert
+			age, stale := staleness.Age()
+			assert.Equal(t, tt.wantStale, age)
+			assert.Equal(t, tt.wantStale > 0, stale)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Equal(t, before, testutil.ToFloat64(metrics.UserCacheStaleServed))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, user.Name)
+			if stale {
+				assert.Equal(t, before+1, testutil.ToFloat64(metrics.UserCacheStaleServed))
+			}
+		})
+	}
+}
+
+func TestCachedUserRepository_StaleIfError_MutationsUnaffected(t *testing.T) {
+	// Arrange
+	inner := &failingWrites{laggingUserRepository: newLaggingUserRepository(), err: syscall.ECONNREFUSED}
+	inner.primary["user-123"] = domain.User{ID: "user-123", Name: "Cached"}
+	inner.catchUp()
+	repo := NewCachedUserRepository(inner, cache.NewMemory(), time.Minute, logger.New(), WithStaleIfError(5*time.Minute))
+	_, err := repo.GetByID(context.Background(), "user-123")
+	require.NoError(t, err)
+
+	// Act
+	err = repo.Update(context.Background(), &domain.User{ID: "user-123", Name: "New"})
+
+	// Assert
+	assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+}
+
+// failingWrites fails every update
+type failingWrites struct {
+	*laggingUserRepository
+	err error
+}
+
+func (r *failingWrites) Update(ctx context.Context, user *domain.User) error {
+	return r.err
+}
@@ -0,0 +1,125 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/idgen"
+	"github.com/romanitalian/carch-go/internal/repository/repotest"
+)
+
+// The synthetic users the ID benchmarks run against, inserted in batches
+const (
+	benchUsers     = 50_000
+	benchBatchSize = 1_000
+	benchPageSize  = 100
+)
+
+// benchIDVersions compare random IDs listed by created_at with
+// time-ordered IDs listed by ID, see WithIDOrder
+var benchIDVersions = []struct {
+	name string
+	ids  idgen.Generator
+	byID bool
+}{
+	{name: "v4", ids: idgen.UUID},
+	{name: "v7", ids: idgen.UUIDv7, byID: true},
+}
+
+// insertBenchUsers inserts n users identified by ids, created in the order
+// their IDs were generated
+func insertBenchUsers(tb testing.TB, db Querier, ids idgen.Generator, n int) {
+	tb.Helper()
+
+	for done := 0; done < n; done += benchBatchSize {
+		batch := make([]string, min(benchBatchSize, n-done))
+		for i := range batch {
+			batch[i] = ids.NewID()
+		}
+		_, err := db.ExecContext(context.Background(), `
+			INSERT INTO users (id, email, password_hash, name, created_at, updated_at)
+			SELECT id, id || '@bench.example', '', 'Bench', clock_timestamp(), clock_timestamp()
+			FROM unnest($1::uuid[]) WITH ORDINALITY AS t(id, n)
+			ORDER BY n`, pq.Array(batch))
+		require.NoError(tb, err)
+	}
+}
+
+// BenchmarkUserIDs_Insert inserts batches of users into a table already
+// holding benchUsers. Random IDs land all over the primary key index and
+// split its pages, time-ordered ones are appended to its right edge. The
+// pkey-B/user metric is the resulting index size per user.
+func BenchmarkUserIDs_Insert(b *testing.B) {
+	for _, v := range benchIDVersions {
+		b.Run(v.name, func(b *testing.B) {
+			tx := repotest.Begin(b)
+			insertBenchUsers(b, tx, v.ids, benchUsers)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				insertBenchUsers(b, tx, v.ids, benchBatchSize)
+			}
+			b.StopTimer()
+
+			var size, users float64
+			ctx := context.Background()
+			require.NoError(b, tx.GetContext(ctx, &size, `SELECT pg_relation_size('users_pkey')`))
+			require.NoError(b, tx.GetContext(ctx, &users, `SELECT count(*) FROM users`))
+			b.ReportMetric(size/users, "pkey-B/user")
+		})
+	}
+}
+
+// BenchmarkUserIDs_NewestPage reads the newest page of users in the order
+// of the user listing. By created_at the table is scanned and sorted, by
+// time-ordered ID the primary key index is read from its end.
+func BenchmarkUserIDs_NewestPage(b *testing.B) {
+	for _, v := range benchIDVersions {
+		b.Run(v.name, func(b *testing.B) {
+			tx := repotest.Begin(b)
+			insertBenchUsers(b, tx, v.ids, benchUsers)
+			ctx := context.Background()
+			_, err := tx.ExecContext(ctx, `ANALYZE users`)
+			require.NoError(b, err)
+			query := `
+				SELECT id FROM users WHERE deleted_at IS NULL
+				ORDER BY ` + userOrderBy(domain.UserListOptions{}, v.byID) + `
+				LIMIT $1`
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				var ids []string
+				require.NoError(b, tx.SelectContext(ctx, &ids, query, benchPageSize))
+			}
+		})
+	}
+}
+
+func TestUserRepository_MixedIDVersions(t *testing.T) {
+	// Arrange: users created before and after the switch to time-ordered IDs
+	tx := repotest.Begin(t)
+	ctx := context.Background()
+	old := &domain.User{Email: "v4@ids.example", Name: "Old"}
+	created := &domain.User{Email: "v7@ids.example", Name: "New"}
+	require.NoError(t, NewUserRepository(tx).Create(ctx, old))
+	repo := NewUserRepository(tx, WithUserGenerators(generate.WithIDGenerator(idgen.UUIDv7)), WithIDOrder())
+	require.NoError(t, repo.Create(ctx, created))
+
+	// Act
+	users, err := repo.GetByIDs(ctx, []string{old.ID, created.ID})
+	require.NoError(t, err)
+	listed, err := repo.List(ctx, domain.UserListOptions{Filter: domain.UserFilter{EmailDomain: "ids.example"}})
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	require.Len(t, listed.Users, 2)
+	require.ElementsMatch(t, []string{old.ID, created.ID}, []string{listed.Users[0].ID, listed.Users[1].ID})
+}
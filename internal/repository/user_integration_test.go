@@ -0,0 +1,34 @@
+//go:build integration
+
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/repository/repotest"
+)
+
+func TestUserRepository_RecreatesDeletedEmail(t *testing.T) {
+	// Arrange: a user deleted before the purge removed its row
+	tx := repotest.Begin(t)
+	ctx := context.Background()
+	repo := NewUserRepository(tx)
+	deleted := &domain.User{Email: "again@users.example", Name: "Deleted"}
+	require.NoError(t, repo.Create(ctx, deleted))
+	require.NoError(t, repo.Delete(ctx, deleted.ID))
+
+	// Act
+	recreated := &domain.User{Email: "again@users.example", Name: "Recreated"}
+	err := repo.Create(ctx, recreated)
+
+	// Assert: the email is free again, but only for one live user
+	require.NoError(t, err)
+	assert.NotEqual(t, deleted.ID, recreated.ID)
+	err = repo.Create(ctx, &domain.User{Email: "again@users.example", Name: "Duplicate"})
+	assert.ErrorIs(t, err, domain.ErrEmailTaken)
+}
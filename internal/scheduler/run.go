@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultStopTimeout bounds the wait for running tasks on shutdown
+const defaultStopTimeout = 30 * time.Second
+
+// runHistory is how many finished runs are kept
+const runHistory = 100
+
+// ErrTasksInterrupted is returned by Run when tasks were still running once
+// the stop timeout expired
+var ErrTasksInterrupted = errors.New("scheduler tasks interrupted")
+
+// RunStatus is the state of a task run
+type RunStatus string
+
+const (
+	RunRunning     RunStatus = "running"
+	RunSucceeded   RunStatus = "succeeded"
+	RunFailed      RunStatus = "failed"
+	RunInterrupted RunStatus = "interrupted"
+)
+
+// TaskRun records one run of a task
+type TaskRun struct {
+	Task       string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Status     RunStatus
+	Err        error
+}
+
+// task wraps fn into a cron job recording its runs. fn gets a context that
+// is cancelled when shutdown gives up waiting for it.
+func (s *Scheduler) task(name string, fn func(ctx context.Context) error) func() {
+	return func() {
+		run := &TaskRun{Task: name, StartedAt: time.Now(), Status: RunRunning}
+		s.mu.Lock()
+		s.running[run] = struct{}{}
+		s.mu.Unlock()
+
+		err := fn(s.tasksCtx)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.running, run)
+		if run.Status == RunInterrupted {
+			// Shutdown already recorded it
+			return
+		}
+		run.FinishedAt = time.Now()
+		run.Status = RunSucceeded
+		fields := map[string]interface{}{"task": name, "duration": run.FinishedAt.Sub(run.StartedAt).String()}
+		if err != nil {
+			run.Status, run.Err = RunFailed, err
+			s.log.Error("Task failed", err, fields)
+		} else {
+			s.log.Debug("Task finished", fields)
+		}
+		s.record(run)
+	}
+}
+
+// record appends a finished run to the history, s.mu must be held
+func (s *Scheduler) record(run *TaskRun) {
+	s.runs = append(s.runs, *run)
+	if len(s.runs) > runHistory {
+		s.runs = s.runs[len(s.runs)-runHistory:]
+	}
+}
+
+// Runs returns the most recent finished runs, oldest first
+func (s *Scheduler) Runs() []TaskRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]TaskRun(nil), s.runs...)
+}
+
+// interrupt cancels the running tasks and marks their runs interrupted. It
+// returns the names of the tasks.
+func (s *Scheduler) interrupt() []string {
+	s.cancelTasks()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	names := make([]string, 0, len(s.running))
+	for run := range s.running {
+		run.FinishedAt = now
+		run.Status = RunInterrupted
+		run.Err = context.Canceled
+		s.record(run)
+		delete(s.running, run)
+
+		s.log.Warn("Task interrupted", map[string]interface{}{
+			"task":       run.Task,
+			"started_at": run.StartedAt.Format(time.RFC3339),
+		})
+		names = append(names, run.Task)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stop stops scheduling tasks and waits up to the stop timeout for the
+// running ones, which are interrupted after it
+func (s *Scheduler) stop() error {
+	done := s.cron.Stop()
+
+	timer := time.NewTimer(s.stopTimeout)
+	defer timer.Stop()
+	select {
+	case <-done.Done():
+		return nil
+	case <-timer.C:
+	}
+
+	names := s.interrupt()
+	if len(names) == 0 {
+		// The last task finished as the timeout expired
+		return nil
+	}
+	return fmt.Errorf("%w after %v: %s", ErrTasksInterrupted, s.stopTimeout, strings.Join(names, ", "))
+}
@@ -0,0 +1,89 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Disabled is the schedule that skips registering a task
+const Disabled = "disabled"
+
+// defaultSchedules are the cron expressions, with seconds, tasks run on
+// unless SCHEDULER_TASK_<NAME> sets another. Names are the task names with
+// underscores for spaces.
+var defaultSchedules = map[string]string{
+	"example":                   "0 * * * * *",    // Every minute
+	"hourly":                    "0 0 * * * *",    // Every hour
+	"outbox_relay":              "*/5 * * * * *",  // Every 5 seconds
+	"purge_deleted_users":       "0 30 3 * * *",   // Every day at 03:30
+	"prune_stale_instances":     "0 */5 * * * *",  // Every 5 minutes
+	"maintain_audit_partitions": "0 15 2 * * *",   // Every day at 02:15
+	"purge_event_archive":       "0 45 3 * * *",   // Every day at 03:45
+	"warm_user_cache":           "0 */5 * * * *",  // Every 5 minutes
+	"clean_import_staging":      "0 */15 * * * *", // Every 15 minutes
+}
+
+// specParser parses schedules like the cron of a Scheduler, seconds first
+var specParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// scheduleName returns the name the schedule of a task is configured by
+func scheduleName(task string) string {
+	return strings.ReplaceAll(task, " ", "_")
+}
+
+// CheckSchedules returns an error naming the configured tasks that do not
+// exist, with the valid names, and the schedules that do not parse
+func CheckSchedules(schedules map[string]string) error {
+	valid := slices.Sorted(maps.Keys(defaultSchedules))
+
+	var problems []string
+	for _, name := range slices.Sorted(maps.Keys(schedules)) {
+		if _, ok := defaultSchedules[name]; !ok {
+			problems = append(problems, fmt.Sprintf("unknown task %q, valid tasks are %s", name, strings.Join(valid, ", ")))
+			continue
+		}
+		spec := schedules[name]
+		if spec == Disabled {
+			continue
+		}
+		if _, err := specParser.Parse(spec); err != nil {
+			problems = append(problems, fmt.Sprintf("task %q: invalid schedule %q: %v", name, spec, err))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("scheduler tasks: %s", strings.Join(problems, "; "))
+}
+
+// schedule returns the cron expression of the named task, "" when it is
+// disabled
+func (s *Scheduler) schedule(task string) string {
+	name := scheduleName(task)
+	spec, ok := s.cfg.Scheduler.Tasks[name]
+	if !ok {
+		spec = defaultSchedules[name]
+	}
+	if spec == Disabled {
+		return ""
+	}
+	return spec
+}
+
+// register adds the task on its schedule unless it is disabled
+func (s *Scheduler) register(task string, fn func(ctx context.Context) error) error {
+	spec := s.schedule(task)
+	if spec == "" {
+		return nil
+	}
+	if _, err := s.cron.AddFunc(spec, s.task(task, fn)); err != nil {
+		return fmt.Errorf("scheduling %s: %w", task, err)
+	}
+	s.log.Debug("Task scheduled", map[string]interface{}{"task": task, "schedule": spec})
+	return nil
+}
@@ -0,0 +1,77 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/config"
+)
+
+func TestScheduler_RegisterTasks_ConfiguredSchedules(t *testing.T) {
+	// Arrange
+	cfg := &config.Config{}
+	cfg.Scheduler.Tasks = map[string]string{"example": "0 */10 * * * *", "hourly": Disabled}
+	s := NewScheduler(cfg)
+
+	// Act
+	err := s.RegisterTasks()
+
+	// Assert
+	require.NoError(t, err)
+	entries := s.cron.Entries()
+	require.Len(t, entries, 1, "hourly is disabled and the other tasks lack their dependencies")
+	from := time.Date(2024, 3, 1, 12, 3, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2024, 3, 1, 12, 10, 0, 0, time.UTC), entries[0].Schedule.Next(from))
+}
+
+func TestCheckSchedules(t *testing.T) {
+	tests := []struct {
+		name      string
+		schedules map[string]string
+		wantErr   string
+	}{
+		{
+			name:      "defaults",
+			schedules: nil,
+		},
+		{
+			name:      "overrides",
+			schedules: map[string]string{"outbox_relay": "*/30 * * * * *", "warm_user_cache": "@hourly", "hourly": Disabled},
+		},
+		{
+			name:      "unknown task",
+			schedules: map[string]string{"exmaple": "0 * * * * *"},
+			wantErr: `scheduler tasks: unknown task "exmaple", valid tasks are clean_import_staging, example, hourly, ` +
+				`maintain_audit_partitions, outbox_relay, prune_stale_instances, purge_deleted_users, purge_event_archive, warm_user_cache`,
+		},
+		{
+			name:      "invalid schedule",
+			schedules: map[string]string{"hourly": "0 0 * * *"},
+			wantErr:   `scheduler tasks: task "hourly": invalid schedule "0 0 * * *": expected exactly 6 fields, found 5: [0 0 * * *]`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckSchedules(tt.schedules)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestScheduler_RegisterTasks_RejectsUnknownTask(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Scheduler.Tasks = map[string]string{"nightly": "0 0 0 * * *"}
+	s := NewScheduler(cfg)
+
+	assert.ErrorContains(t, s.RegisterTasks(), `unknown task "nightly"`)
+	assert.Empty(t, s.cron.Entries())
+}
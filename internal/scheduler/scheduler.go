@@ -2,54 +2,198 @@ package scheduler
 
 import (
 	"context"
-	"log"
+	"sync"
 	"time"
 
 	"github.com/robfig/cron/v3"
 	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
 )
 
+// cacheWarmingTask is the name runs of the user cache warming are recorded with
+const cacheWarmingTask = "warm user cache"
+
 type Scheduler struct {
-	cron *cron.Cron
-	cfg  *config.Config
+	cron     *cron.Cron
+	cfg      *config.Config
+	services *service.Services
+	relay    *service.OutboxRelay
+	warmer   *service.CacheWarmer
+	log      *logger.Logger
+
+	stopTimeout time.Duration
+	// tasksCtx is passed to tasks and cancelled when they are interrupted
+	tasksCtx    context.Context
+	cancelTasks context.CancelFunc
+
+	mu      sync.Mutex
+	running map[*TaskRun]struct{}
+	runs    []TaskRun
+}
+
+// Option is a function that configures a Scheduler
+type Option func(*Scheduler)
+
+// WithServices enables tasks that operate on application services
+func WithServices(services *service.Services) Option {
+	return func(s *Scheduler) {
+		s.services = services
+	}
 }
 
-func NewScheduler(cfg *config.Config) *Scheduler {
-	return &Scheduler{
-		cron: cron.New(cron.WithSeconds()),
-		cfg:  cfg,
+// WithOutboxRelay enables periodic relaying of outbox events to the broker
+func WithOutboxRelay(relay *service.OutboxRelay) Option {
+	return func(s *Scheduler) {
+		s.relay = relay
 	}
 }
 
-func (s *Scheduler) RegisterTasks() {
-	// Registration of periodic tasks
-	s.cron.AddFunc("0 * * * * *", func() { // Every minute
-		if err := s.exampleTask(); err != nil {
-			log.Printf("Error running example task: %v", err)
+// WithCacheWarmer enables warming the user cache of this process, see
+// RegisterCacheWarming
+func WithCacheWarmer(warmer *service.CacheWarmer) Option {
+	return func(s *Scheduler) {
+		s.warmer = warmer
+	}
+}
+
+// WithLogger sets the logger tasks report to, logger.Default() unless set
+func WithLogger(log *logger.Logger) Option {
+	return func(s *Scheduler) {
+		if log != nil {
+			s.log = log
 		}
-	})
+	}
+}
 
-	s.cron.AddFunc("0 0 * * * *", func() { // Every hour
-		if err := s.hourlyTask(); err != nil {
-			log.Printf("Error running hourly task: %v", err)
+// WithStopTimeout sets how long Run waits for running tasks once its
+// context is done, 30s by default
+func WithStopTimeout(timeout time.Duration) Option {
+	return func(s *Scheduler) {
+		if timeout > 0 {
+			s.stopTimeout = timeout
+		}
+	}
+}
+
+func NewScheduler(cfg *config.Config, opts ...Option) *Scheduler {
+	s := &Scheduler{
+		cron:        cron.New(cron.WithSeconds()),
+		cfg:         cfg,
+		stopTimeout: defaultStopTimeout,
+		log:         logger.Default(),
+		running:     make(map[*TaskRun]struct{}),
+	}
+	s.tasksCtx, s.cancelTasks = context.WithCancel(context.Background())
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// RegisterTasks registers the periodic tasks on their schedules, see
+// CheckSchedules for the errors it returns
+func (s *Scheduler) RegisterTasks() error {
+	if err := CheckSchedules(s.cfg.Scheduler.Tasks); err != nil {
+		return err
+	}
+
+	tasks := []struct {
+		name    string
+		fn      func(ctx context.Context) error
+		enabled bool
+	}{
+		{"example", s.exampleTask, true},
+		{"hourly", s.hourlyTask, true},
+		{"outbox relay", s.relayOutboxTask, s.relay != nil},
+		{"purge deleted users", s.purgeDeletedUsersTask, s.services != nil},
+		{"prune stale instances", s.pruneStaleInstancesTask, s.services != nil},
+		{"maintain audit partitions", s.maintainAuditPartitionsTask, s.services != nil},
+		{"purge event archive", s.purgeEventArchiveTask, s.services != nil},
+		{"clean import staging", s.cleanImportStagingTask, s.services != nil},
+	}
+	for _, t := range tasks {
+		if !t.enabled {
+			continue
 		}
-	})
+		if err := s.register(t.name, t.fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RegisterCacheWarming registers the warming of the user cache, when a
+// warmer is set. The cache lives in the process serving lookups, so the API
+// registers it on a scheduler of its own rather than in RegisterTasks.
+func (s *Scheduler) RegisterCacheWarming() error {
+	if s.warmer == nil {
+		return nil
+	}
+	if err := CheckSchedules(s.cfg.Scheduler.Tasks); err != nil {
+		return err
+	}
+	return s.register(cacheWarmingTask, s.warmCacheTask)
 }
 
-func (s *Scheduler) Run(ctx context.Context) {
+// WarmCache warms the user cache right away, e.g. once the process is ready
+// after a deploy. The run is recorded like a scheduled one.
+func (s *Scheduler) WarmCache() {
+	if s.warmer != nil {
+		s.task(cacheWarmingTask, s.warmCacheTask)()
+	}
+}
+
+// Run runs the tasks until ctx is done, then waits for the running ones.
+// It returns ErrTasksInterrupted if some were still running after the stop
+// timeout.
+func (s *Scheduler) Run(ctx context.Context) error {
 	s.cron.Start()
-	defer s.cron.Stop()
 
 	// Waiting for termination signal
 	<-ctx.Done()
+
+	return s.stop()
 }
 
-func (s *Scheduler) exampleTask() error {
-	log.Printf("Running example task at %v", time.Now())
+func (s *Scheduler) exampleTask(ctx context.Context) error {
+	s.log.Info("Running example task", nil)
 	return nil
 }
 
-func (s *Scheduler) hourlyTask() error {
-	log.Printf("Running hourly task at %v", time.Now())
+func (s *Scheduler) hourlyTask(ctx context.Context) error {
+	s.log.Info("Running hourly task", nil)
 	return nil
 }
+
+func (s *Scheduler) relayOutboxTask(ctx context.Context) error {
+	_, err := s.relay.Relay(ctx)
+	return err
+}
+
+func (s *Scheduler) purgeDeletedUsersTask(ctx context.Context) error {
+	return s.services.User.Purge(ctx, s.cfg.Users.PurgeAfter, s.cfg.Users.TombstoneHorizon)
+}
+
+func (s *Scheduler) pruneStaleInstancesTask(ctx context.Context) error {
+	return s.services.Instance.PruneStale(ctx)
+}
+
+func (s *Scheduler) maintainAuditPartitionsTask(ctx context.Context) error {
+	return s.services.Audit.MaintainPartitions(ctx, time.Now())
+}
+
+func (s *Scheduler) warmCacheTask(ctx context.Context) error {
+	_, err := s.warmer.Warm(ctx)
+	return err
+}
+
+func (s *Scheduler) purgeEventArchiveTask(ctx context.Context) error {
+	return s.services.Events.PurgeArchive(ctx)
+}
+
+func (s *Scheduler) cleanImportStagingTask(ctx context.Context) error {
+	return s.services.Import.CleanStaging(ctx)
+}
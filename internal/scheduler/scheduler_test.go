@@ -0,0 +1,132 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/config"
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/repository"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// startTask registers fn to run every second, starts the scheduler and
+// returns once fn runs. Run's result is sent on the returned channel.
+func startTask(t *testing.T, s *Scheduler, ctx context.Context, fn func(ctx context.Context) error) <-chan error {
+	t.Helper()
+
+	entered := make(chan struct{}, 1)
+	_, err := s.cron.AddFunc("* * * * * *", s.task("slow", func(ctx context.Context) error {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		return fn(ctx)
+	}))
+	require.NoError(t, err)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Run(ctx)
+	}()
+
+	select {
+	case <-entered:
+	case <-time.After(3 * time.Second):
+		t.Fatal("task did not run")
+	}
+	return stopped
+}
+
+func TestScheduler_Run_InterruptsTasksPastStopTimeout(t *testing.T) {
+	// Arrange: the task sleeps well past the stop timeout
+	s := NewScheduler(&config.Config{}, WithStopTimeout(50*time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	taskCtx := make(chan context.Context, 1)
+	stopped := startTask(t, s, ctx, func(ctx context.Context) error {
+		taskCtx <- ctx
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	// Act
+	cancel()
+	err := <-stopped
+
+	// Assert
+	require.ErrorIs(t, err, ErrTasksInterrupted)
+	assert.Contains(t, err.Error(), "slow")
+	assert.Error(t, (<-taskCtx).Err(), "the task's context is cancelled")
+
+	runs := s.Runs()
+	require.Len(t, runs, 1)
+	assert.Equal(t, "slow", runs[0].Task)
+	assert.Equal(t, RunInterrupted, runs[0].Status)
+	assert.False(t, runs[0].FinishedAt.IsZero())
+}
+
+func TestScheduler_Run_WaitsForRunningTasks(t *testing.T) {
+	// Arrange
+	s := NewScheduler(&config.Config{}, WithStopTimeout(time.Second))
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := startTask(t, s, ctx, func(ctx context.Context) error {
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	})
+
+	// Act
+	cancel()
+	err := <-stopped
+
+	// Assert
+	require.NoError(t, err)
+	runs := s.Runs()
+	require.Len(t, runs, 1)
+	assert.Equal(t, RunSucceeded, runs[0].Status)
+}
+
+func TestScheduler_WarmCache(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	log := logger.New()
+	users := repository.NewMemoryUserRepository()
+	require.NoError(t, users.Create(ctx, &domain.User{Email: "a@example.com", Name: "A"}))
+	cached := repository.NewCachedUserRepository(users, cache.NewMemory(), time.Minute, log)
+	s := NewScheduler(&config.Config{}, WithCacheWarmer(service.NewCacheWarmer(users, cached, log)))
+
+	// Act
+	s.WarmCache()
+
+	// Assert
+	runs := s.Runs()
+	require.Len(t, runs, 1)
+	assert.Equal(t, cacheWarmingTask, runs[0].Task)
+	assert.Equal(t, RunSucceeded, runs[0].Status)
+}
+
+func TestScheduler_task_LogsFailures(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	s := NewScheduler(&config.Config{}, WithLogger(logger.New(logger.WithOutput(&logs))))
+	failure := errors.New("database unreachable")
+
+	// Act
+	s.task("purge deleted users", func(ctx context.Context) error { return failure })()
+
+	// Assert
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "Task failed", entry["message"])
+	assert.Equal(t, "purge deleted users", entry["task"])
+	assert.Equal(t, failure.Error(), entry["error"])
+	assert.NotEmpty(t, entry["duration"])
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// defaultAuditPartitionsAhead is used when no number of months to pre-create is configured
+const defaultAuditPartitionsAhead = 3
+
+// Page sizes of audit listings
+const (
+	defaultAuditPageSize = 50
+	maxAuditPageSize     = 500
+)
+
+type actorKey struct{}
+
+// WithActor marks ctx as made on behalf of the principal actorID, which
+// audit entries recorded with ctx name as their ActorID
+func WithActor(ctx context.Context, actorID string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actorID)
+}
+
+// ActorOf returns the principal ctx is made on behalf of, empty for
+// actions of the system itself
+func ActorOf(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// AuditService lists the audit log and manages its monthly partitions
+type AuditService struct {
+	repo      domain.AuditRepository
+	log       *logger.Logger
+	ahead     int
+	retention time.Duration
+}
+
+// NewAuditService creates an audit service that keeps ahead months of
+// partitions created past the current one. A retention of 0 keeps entries
+// forever.
+func NewAuditService(repo domain.AuditRepository, log *logger.Logger, ahead int, retention time.Duration) *AuditService {
+	if ahead <= 0 {
+		ahead = defaultAuditPartitionsAhead
+	}
+
+	return &AuditService{
+		repo:      repo,
+		log:       log,
+		ahead:     ahead,
+		retention: retention,
+	}
+}
+
+// MaintainPartitions creates the partitions for the current month and the
+// configured months ahead, then drops partitions that ended before the
+// retention cutoff
+func (s *AuditService) MaintainPartitions(ctx context.Context, now time.Time) error {
+	current := domain.AuditMonth(now)
+	for i := 0; i <= s.ahead; i++ {
+		partition, err := s.repo.CreatePartition(ctx, current.AddDate(0, i, 0))
+		if err != nil {
+			return err
+		}
+		s.log.Debug("Audit log partition ensured", map[string]interface{}{"partition": partition.Name})
+	}
+
+	if s.retention <= 0 {
+		return nil
+	}
+
+	partitions, err := s.repo.ListPartitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	cutoff := now.Add(-s.retention)
+	for _, partition := range partitions {
+		// A partition goes only once all of its rows are past retention
+		if partition.End().After(cutoff) {
+			continue
+		}
+		if err := s.repo.DropPartition(ctx, partition); err != nil {
+			return err
+		}
+		s.log.Info("Dropped expired audit log partition", map[string]interface{}{
+			"partition": partition.Name,
+			"cutoff":    cutoff,
+		})
+	}
+
+	return nil
+}
+
+// List returns a page of the entries matching the filter, newest first. A
+// limit of 0 or past the maximum page size is replaced by the default or
+// the maximum.
+func (s *AuditService) List(ctx context.Context, filter domain.AuditFilter) (*domain.AuditPage, error) {
+	if !filter.From.IsZero() && !filter.To.IsZero() && !filter.To.After(filter.From) {
+		return nil, fmt.Errorf("%w: the end of the time range must be after its start", domain.ErrInvalidInput)
+	}
+	switch {
+	case filter.Limit < 0:
+		return nil, fmt.Errorf("%w: limit must not be negative", domain.ErrInvalidInput)
+	case filter.Limit == 0:
+		filter.Limit = defaultAuditPageSize
+	case filter.Limit > maxAuditPageSize:
+		filter.Limit = maxAuditPageSize
+	}
+
+	// One entry past the page tells whether there is a next one
+	limit := filter.Limit
+	filter.Limit++
+	entries, err := s.repo.List(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := &domain.AuditPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = domain.CursorOf(page.Entries[limit-1]).String()
+	}
+	if page.Entries == nil {
+		page.Entries = []*domain.AuditEntry{}
+	}
+
+	return page, nil
+}
@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// memoryAuditRepository tracks partitions by month
+type memoryAuditRepository struct {
+	partitions map[time.Time]domain.AuditPartition
+	dropped    []string
+	entries    []*domain.AuditEntry
+}
+
+func newMemoryAuditRepository(months ...time.Time) *memoryAuditRepository {
+	r := &memoryAuditRepository{partitions: map[time.Time]domain.AuditPartition{}}
+	for _, m := range months {
+		_, _ = r.CreatePartition(context.Background(), m)
+	}
+	return r
+}
+
+func (r *memoryAuditRepository) Create(ctx context.Context, entry *domain.AuditEntry) error {
+	entry.ID = int64(len(r.entries) + 1)
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func (r *memoryAuditRepository) List(ctx context.Context, filter domain.AuditFilter) ([]*domain.AuditEntry, error) {
+	var entries []*domain.AuditEntry
+	for _, e := range r.entries {
+		switch {
+		case filter.ActorID != "" && e.ActorID != filter.ActorID,
+			filter.EntityID != "" && e.EntityID != filter.EntityID,
+			filter.Action != "" && e.Action != filter.Action,
+			!filter.From.IsZero() && e.CreatedAt.Before(filter.From),
+			!filter.To.IsZero() && !e.CreatedAt.Before(filter.To),
+			filter.After != nil && !listedAfter(e, filter.After):
+			continue
+		}
+		entry := *e
+		if !filter.IncludeDetails {
+			entry.Details = nil
+		}
+		entries = append(entries, &entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return listedAfter(entries[j], &domain.AuditCursor{CreatedAt: entries[i].CreatedAt, ID: entries[i].ID})
+	})
+	if len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+	return entries, nil
+}
+
+// listedAfter reports whether e is listed after the cursor, newest first
+func listedAfter(e *domain.AuditEntry, c *domain.AuditCursor) bool {
+	if e.CreatedAt.Equal(c.CreatedAt) {
+		return e.ID < c.ID
+	}
+	return e.CreatedAt.Before(c.CreatedAt)
+}
+
+func (r *memoryAuditRepository) ListPartitions(ctx context.Context) ([]domain.AuditPartition, error) {
+	var partitions []domain.AuditPartition
+	for _, p := range r.partitions {
+		partitions = append(partitions, p)
+	}
+	sort.Slice(partitions, func(i, j int) bool { return partitions[i].Month.Before(partitions[j].Month) })
+	return partitions, nil
+}
+
+func (r *memoryAuditRepository) CreatePartition(ctx context.Context, month time.Time) (domain.AuditPartition, error) {
+	month = domain.AuditMonth(month)
+	p := domain.AuditPartition{Name: month.Format("audit_log_y2006m01"), Month: month}
+	r.partitions[month] = p
+	return p, nil
+}
+
+func (r *memoryAuditRepository) DropPartition(ctx context.Context, partition domain.AuditPartition) error {
+	delete(r.partitions, partition.Month)
+	r.dropped = append(r.dropped, partition.Name)
+	return nil
+}
+
+func (r *memoryAuditRepository) names() []string {
+	partitions, _ := r.ListPartitions(context.Background())
+	names := make([]string, 0, len(partitions))
+	for _, p := range partitions {
+		names = append(names, p.Name)
+	}
+	return names
+}
+
+func TestAuditService_MaintainPartitions_CreatesMonthsAhead(t *testing.T) {
+	// Arrange
+	repo := newMemoryAuditRepository()
+	service := NewAuditService(repo, logger.New(), 2, 0)
+	now := time.Date(2024, time.November, 20, 23, 0, 0, 0, time.UTC)
+
+	// Act
+	err := service.MaintainPartitions(context.Background(), now)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"audit_log_y2024m11", "audit_log_y2024m12", "audit_log_y2025m01"}, repo.names())
+	assert.Empty(t, repo.dropped)
+}
+
+func TestAuditService_MaintainPartitions_DropsExpired(t *testing.T) {
+	// Arrange
+	repo := newMemoryAuditRepository(
+		time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+		time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+	)
+	service := NewAuditService(repo, logger.New(), 1, 60*24*time.Hour)
+	// The cutoff falls on 2024-02-15: January is fully expired, February is not
+	now := time.Date(2024, time.April, 15, 0, 0, 0, 0, time.UTC)
+
+	// Act
+	err := service.MaintainPartitions(context.Background(), now)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"audit_log_y2024m01"}, repo.dropped)
+	assert.Equal(t, []string{"audit_log_y2024m02", "audit_log_y2024m03", "audit_log_y2024m04", "audit_log_y2024m05"}, repo.names())
+}
+
+func TestAuditService_MaintainPartitions_KeepsForeverWithoutRetention(t *testing.T) {
+	// Arrange
+	repo := newMemoryAuditRepository(time.Date(2019, time.May, 1, 0, 0, 0, 0, time.UTC))
+	service := NewAuditService(repo, logger.New(), 0, 0)
+
+	// Act
+	err := service.MaintainPartitions(context.Background(), time.Date(2024, time.April, 15, 0, 0, 0, 0, time.UTC))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Empty(t, repo.dropped)
+	assert.Contains(t, repo.names(), "audit_log_y2019m05")
+	assert.Contains(t, repo.names(), "audit_log_y2024m07")
+}
+
+func TestAuditService_List_Pages(t *testing.T) {
+	// Arrange
+	repo := newMemoryAuditRepository()
+	service := NewAuditService(repo, logger.New(), 0, 0)
+	base := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	// Two entries share a timestamp, the ID breaks the tie
+	for _, offset := range []time.Duration{0, time.Hour, time.Hour, 2 * time.Hour, 3 * time.Hour} {
+		require.NoError(t, repo.Create(context.Background(), &domain.AuditEntry{Action: "user.purged", CreatedAt: base.Add(offset)}))
+	}
+
+	// Act
+	var (
+		ids    []int64
+		pages  int
+		cursor *domain.AuditCursor
+	)
+	for {
+		page, err := service.List(context.Background(), domain.AuditFilter{Limit: 2, After: cursor})
+		require.NoError(t, err)
+		pages++
+		for _, e := range page.Entries {
+			ids = append(ids, e.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		next, err := domain.ParseAuditCursor(page.NextCursor)
+		require.NoError(t, err)
+		cursor = &next
+	}
+
+	// Assert
+	assert.Equal(t, []int64{5, 4, 3, 2, 1}, ids)
+	assert.Equal(t, 3, pages)
+}
+
+func TestAuditService_List_Filters(t *testing.T) {
+	base := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	entries := []*domain.AuditEntry{
+		{Action: "user.purged", EntityID: "u-1", ActorID: "admin-1", CreatedAt: base},
+		{Action: "webhook.rejected", EntityID: "stripe", CreatedAt: base.Add(time.Hour), Details: []byte(`{"reason":"stale"}`)},
+		{Action: "user.purged", EntityID: "u-2", ActorID: "admin-2", CreatedAt: base.Add(2 * time.Hour)},
+	}
+
+	tests := []struct {
+		name   string
+		filter domain.AuditFilter
+		want   []string
+	}{
+		{"all", domain.AuditFilter{}, []string{"u-2", "stripe", "u-1"}},
+		{"actor", domain.AuditFilter{ActorID: "admin-1"}, []string{"u-1"}},
+		{"entity", domain.AuditFilter{EntityID: "stripe"}, []string{"stripe"}},
+		{"action", domain.AuditFilter{Action: "user.purged"}, []string{"u-2", "u-1"}},
+		{"from", domain.AuditFilter{From: base.Add(time.Hour)}, []string{"u-2", "stripe"}},
+		{"to", domain.AuditFilter{To: base.Add(time.Hour)}, []string{"u-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			repo := newMemoryAuditRepository()
+			for _, e := range entries {
+				entry := *e
+				require.NoError(t, repo.Create(context.Background(), &entry))
+			}
+			service := NewAuditService(repo, logger.New(), 0, 0)
+
+			// Act
+			page, err := service.List(context.Background(), tt.filter)
+
+			// Assert
+			require.NoError(t, err)
+			var got []string
+			for _, e := range page.Entries {
+				got = append(got, e.EntityID)
+				assert.Nil(t, e.Details, "details are only loaded on request")
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Empty(t, page.NextCursor)
+		})
+	}
+}
+
+func TestAuditService_List_InvalidRange(t *testing.T) {
+	// Arrange
+	service := NewAuditService(newMemoryAuditRepository(), logger.New(), 0, 0)
+	at := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+	// Act
+	_, err := service.List(context.Background(), domain.AuditFilter{From: at, To: at})
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrInvalidInput)
+}
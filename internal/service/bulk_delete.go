@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+)
+
+// Defaults of BulkDeleteService
+const (
+	DefaultBulkDeleteCeiling   = 1000
+	DefaultBulkDeleteBatchSize = 100
+)
+
+// BulkDeleteService deletes the users matching a filter. Requests are counted
+// right away and the deletion runs as a job in the worker, in batches.
+type BulkDeleteService struct {
+	users domain.UserRepository
+	bulk  domain.UserBulkRepository
+	jobs  *JobService
+	log   *logger.Logger
+	// deletion deletes each user, running the deletion hooks
+	deletion *UserDeletion
+
+	ceiling   int64
+	batchSize int
+	gen       generate.Generators
+}
+
+// BulkDeleteOption is a function that configures a BulkDeleteService
+type BulkDeleteOption func(*BulkDeleteService)
+
+// WithBulkDeleteCeiling sets how many users a bulk delete may match without
+// being forced, 0 lifts the ceiling
+func WithBulkDeleteCeiling(n int64) BulkDeleteOption {
+	return func(s *BulkDeleteService) {
+		s.ceiling = n
+	}
+}
+
+// WithBulkDeleteBatchSize sets how many users are deleted between two
+// progress updates
+func WithBulkDeleteBatchSize(n int) BulkDeleteOption {
+	return func(s *BulkDeleteService) {
+		if n > 0 {
+			s.batchSize = n
+		}
+	}
+}
+
+// WithBulkUserDeletion deletes users through d, running its deletion hooks.
+// Without it users are deleted with no hook.
+func WithBulkUserDeletion(d *UserDeletion) BulkDeleteOption {
+	return func(s *BulkDeleteService) {
+		s.deletion = d
+	}
+}
+
+// WithBulkDeleteGenerators sets how jobs and their events are stamped and identified
+func WithBulkDeleteGenerators(opts ...generate.Option) BulkDeleteOption {
+	return func(s *BulkDeleteService) {
+		s.gen = generate.New(opts...)
+	}
+}
+
+// NewBulkDeleteService creates a bulk delete service. Users are deleted one
+// by one through users, so every deletion emits its own user.deleted event
+// and runs its own deletion hooks.
+func NewBulkDeleteService(users domain.UserRepository, bulk domain.UserBulkRepository, jobs *JobService, log *logger.Logger, opts ...BulkDeleteOption) *BulkDeleteService {
+	s := &BulkDeleteService{
+		users:     users,
+		bulk:      bulk,
+		jobs:      jobs,
+		log:       log,
+		ceiling:   DefaultBulkDeleteCeiling,
+		batchSize: DefaultBulkDeleteBatchSize,
+		gen:       generate.New(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.deletion == nil {
+		s.deletion = NewUserDeletion(users, nil, nil, log)
+	}
+
+	return s
+}
+
+// Start counts the users of the filter and, unless it is a dry run, enqueues
+// the job deleting them. More users than the ceiling are only deleted when
+// the request is forced.
+func (s *BulkDeleteService) Start(ctx context.Context, req domain.BulkDeleteRequest) (*domain.BulkDeleteResult, error) {
+	if req.Filter.IsZero() {
+		return nil, fmt.Errorf("%w: a bulk delete needs a filter", domain.ErrInvalidInput)
+	}
+
+	matched, err := s.bulk.CountUsers(ctx, req.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &domain.BulkDeleteResult{DryRun: req.DryRun, Matched: matched}
+	if req.DryRun {
+		return result, nil
+	}
+	if !req.Force && s.exceedsCeiling(matched) {
+		return nil, fmt.Errorf("%w: %d users match, the ceiling is %d", domain.ErrBulkDeleteTooLarge, matched, s.ceiling)
+	}
+
+	params, err := json.Marshal(domain.BulkDeleteParams{
+		Filter: req.Filter,
+		Force:  req.Force,
+		Total:  matched,
+		Tenant: tenant.FromContext(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job := &domain.Job{
+		ID:      s.gen.IDs.NewID(),
+		Type:    domain.JobUsersBulkDelete,
+		Status:  domain.JobPending,
+		Message: fmt.Sprintf("%d users to delete", matched),
+		Params:  params,
+	}
+
+	payload, err := json.Marshal(domain.JobEnqueued{JobID: job.ID})
+	if err != nil {
+		return nil, err
+	}
+	event := &domain.OutboxEvent{
+		ID:        s.gen.IDs.NewID(),
+		Type:      domain.EventJobPrefix + job.Type,
+		Tenant:    tenant.FromContext(ctx),
+		Payload:   payload,
+		CreatedAt: s.gen.Clock.Now(),
+	}
+
+	if err := s.jobs.Enqueue(ctx, job, event); err != nil {
+		return nil, err
+	}
+
+	s.log.Info("Bulk delete enqueued", map[string]interface{}{
+		"job_id":  job.ID,
+		"filter":  req.Filter.String(),
+		"matched": matched,
+		"force":   req.Force,
+	})
+
+	result.Job = job
+	return result, nil
+}
+
+// Run deletes the users of the job in batches, updating its progress after
+// each of them. Every batch selects the users still matching, so a job
+// interrupted by a crash or a timeout resumes where it stopped when its
+// message is redelivered. A job that already finished is left alone.
+func (s *BulkDeleteService) Run(ctx context.Context, jobID string) error {
+	job, err := s.jobs.Get(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Terminal() {
+		return nil
+	}
+
+	var params domain.BulkDeleteParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return s.fail(ctx, job, fmt.Sprintf("invalid params: %v", err))
+	}
+	ctx = tenant.WithTenant(ctx, params.Tenant)
+
+	remaining, err := s.bulk.CountUsers(ctx, params.Filter)
+	if err != nil {
+		return err
+	}
+	// Users created since the request may have pushed the count past the ceiling
+	if !params.Force && s.exceedsCeiling(remaining) {
+		return s.fail(ctx, job, fmt.Sprintf("aborted: %d users match, the ceiling is %d", remaining, s.ceiling))
+	}
+
+	job.Status = domain.JobRunning
+	setBulkDeleteProgress(job, params.Total, remaining)
+	if err := s.jobs.Update(ctx, job); err != nil {
+		return err
+	}
+
+	for {
+		ids, err := s.bulk.ListUserIDs(ctx, params.Filter, s.batchSize)
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		for _, id := range ids {
+			// A user deleted concurrently is as good as deleted by the job
+			if err := s.deletion.Delete(ctx, id); err != nil && !errors.Is(err, domain.ErrUserNotFound) {
+				return fmt.Errorf("delete user %s: %w", id, err)
+			}
+		}
+
+		remaining, err = s.bulk.CountUsers(ctx, params.Filter)
+		if err != nil {
+			return err
+		}
+		setBulkDeleteProgress(job, params.Total, remaining)
+		if err := s.jobs.Update(ctx, job); err != nil {
+			return err
+		}
+	}
+
+	job.Status = domain.JobSucceeded
+	job.Progress = 100
+	job.Message = fmt.Sprintf("deleted the users matching %s", params.Filter)
+	if err := s.jobs.Update(ctx, job); err != nil {
+		return err
+	}
+
+	s.log.Info("Bulk delete finished", map[string]interface{}{"job_id": job.ID, "total": params.Total})
+	return nil
+}
+
+func (s *BulkDeleteService) exceedsCeiling(n int64) bool {
+	return s.ceiling > 0 && n > s.ceiling
+}
+
+func (s *BulkDeleteService) fail(ctx context.Context, job *domain.Job, message string) error {
+	s.log.Warn("Bulk delete failed", map[string]interface{}{"job_id": job.ID, "reason": message})
+
+	job.Status = domain.JobFailed
+	job.Message = message
+	return s.jobs.Update(ctx, job)
+}
+
+// setBulkDeleteProgress reports how many of the users that matched at the
+// request are gone. Progress stays below 100 until the job succeeds.
+func setBulkDeleteProgress(job *domain.Job, total, remaining int64) {
+	done := total - remaining
+	if done < 0 {
+		done = 0
+	}
+
+	job.Progress = 0
+	if total > 0 {
+		job.Progress = int(min(done*100/total, 99))
+	}
+	job.Message = fmt.Sprintf("%d of %d users deleted", done, total)
+}
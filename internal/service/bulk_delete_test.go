@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+)
+
+// bulkUserRepository keeps live users in memory and serves the bulk
+// selections and deletes; other methods are not used
+type bulkUserRepository struct {
+	domain.UserRepository
+
+	mu    sync.Mutex
+	users map[string]*domain.User
+	// deleted counts the deletions of every user, by the tenant they were made for
+	deleted map[string]string
+	limits  []int
+	// failAfter makes deletes fail once that many users are deleted, 0 never
+	failAfter int
+}
+
+func newBulkUserRepository(emails ...string) *bulkUserRepository {
+	r := &bulkUserRepository{users: make(map[string]*domain.User), deleted: make(map[string]string)}
+	for i, email := range emails {
+		r.add(fmt.Sprintf("user-%02d", i), email)
+	}
+	return r
+}
+
+func (r *bulkUserRepository) add(id, email string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[id] = &domain.User{ID: id, Email: email}
+}
+
+func (r *bulkUserRepository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.failAfter > 0 && len(r.deleted) >= r.failAfter {
+		return errors.New("connection reset")
+	}
+	if _, ok := r.users[id]; !ok {
+		return domain.ErrUserNotFound
+	}
+	delete(r.users, id)
+	r.deleted[id] = tenant.FromContext(ctx)
+	return nil
+}
+
+func (r *bulkUserRepository) CountUsers(ctx context.Context, filter domain.UserFilter) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for _, u := range r.users {
+		if filter.Matches(u) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *bulkUserRepository) ListUserIDs(ctx context.Context, filter domain.UserFilter, limit int) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = append(r.limits, limit)
+	var ids []string
+	for id, u := range r.users {
+		if filter.Matches(u) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+	return ids, nil
+}
+
+func newTestBulkDeleteService(users *bulkUserRepository, jobs *memoryJobRepository, opts ...BulkDeleteOption) *BulkDeleteService {
+	return NewBulkDeleteService(users, users, NewJobService(jobs, nil, logger.New()), logger.New(), opts...)
+}
+
+var exampleUsers = domain.UserFilter{EmailDomain: "example.com"}
+
+func TestBulkDeleteService_DryRunCounts(t *testing.T) {
+	// Arrange
+	users := newBulkUserRepository("a@example.com", "b@EXAMPLE.com", "c@example.com", "d@example.org")
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	service := newTestBulkDeleteService(users, jobs, WithBulkDeleteCeiling(1))
+
+	// Act: the ceiling does not apply to counting
+	result, err := service.Start(context.Background(), domain.BulkDeleteRequest{Filter: exampleUsers, DryRun: true})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, &domain.BulkDeleteResult{DryRun: true, Matched: 3}, result)
+	assert.Empty(t, jobs.jobs)
+	assert.Empty(t, users.deleted)
+}
+
+func TestBulkDeleteService_Start(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     domain.BulkDeleteRequest
+		wantErr error
+	}{
+		{name: "within the ceiling", req: domain.BulkDeleteRequest{Filter: domain.UserFilter{EmailDomain: "example.org"}}},
+		{name: "above the ceiling", req: domain.BulkDeleteRequest{Filter: exampleUsers}, wantErr: domain.ErrBulkDeleteTooLarge},
+		{name: "above the ceiling forced", req: domain.BulkDeleteRequest{Filter: exampleUsers, Force: true}},
+		{name: "without a filter", req: domain.BulkDeleteRequest{DryRun: true}, wantErr: domain.ErrInvalidInput},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			users := newBulkUserRepository("a@example.com", "b@example.com", "c@example.com", "d@example.org")
+			jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+			service := newTestBulkDeleteService(users, jobs, WithBulkDeleteCeiling(2))
+			ctx := tenant.WithTenant(context.Background(), "acme")
+
+			// Act
+			result, err := service.Start(ctx, tt.req)
+
+			// Assert
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				assert.Empty(t, jobs.jobs, "nothing is enqueued")
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, result.Job)
+			assert.Equal(t, domain.JobPending, jobs.jobs[result.Job.ID].Status)
+			assert.Empty(t, users.deleted, "users are deleted by the worker")
+
+			require.Len(t, jobs.events, 1)
+			event := jobs.events[0]
+			assert.Equal(t, "job.users.bulk_delete", event.Type)
+			assert.Equal(t, "acme", event.Tenant)
+			assert.JSONEq(t, `{"job_id":"`+result.Job.ID+`"}`, string(event.Payload))
+
+			var params domain.BulkDeleteParams
+			require.NoError(t, json.Unmarshal(jobs.jobs[result.Job.ID].Params, &params))
+			assert.Equal(t, tt.req.Filter, params.Filter)
+			assert.Equal(t, result.Matched, params.Total)
+			assert.Equal(t, "acme", params.Tenant)
+		})
+	}
+}
+
+// startBulkDelete enqueues a forced bulk delete of the example.com users
+func startBulkDelete(t *testing.T, service *BulkDeleteService) string {
+	t.Helper()
+	result, err := service.Start(tenant.WithTenant(context.Background(), "acme"), domain.BulkDeleteRequest{Filter: exampleUsers, Force: true})
+	require.NoError(t, err)
+	return result.Job.ID
+}
+
+func TestBulkDeleteService_RunDeletesInBatches(t *testing.T) {
+	// Arrange
+	users := newBulkUserRepository("a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com", "f@example.org")
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	service := newTestBulkDeleteService(users, jobs, WithBulkDeleteBatchSize(2))
+	jobID := startBulkDelete(t, service)
+
+	// Act
+	err := service.Run(context.Background(), jobID)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Len(t, users.deleted, 5)
+	assert.Contains(t, users.users, "user-05", "users outside the filter are kept")
+	for id, owner := range users.deleted {
+		assert.Equal(t, "acme", owner, "user %s deleted for the tenant of the request", id)
+	}
+	assert.Equal(t, []int{2, 2, 2, 2}, users.limits, "three full or partial batches, then an empty one")
+
+	var progress []int
+	for _, job := range jobs.updates {
+		progress = append(progress, job.Progress)
+	}
+	assert.Equal(t, []int{0, 40, 80, 99, 100}, progress)
+	assert.Equal(t, domain.JobSucceeded, jobs.jobs[jobID].Status)
+}
+
+func TestBulkDeleteService_RunResumesAfterCrash(t *testing.T) {
+	// Arrange
+	users := newBulkUserRepository("a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com")
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	service := newTestBulkDeleteService(users, jobs, WithBulkDeleteBatchSize(2))
+	jobID := startBulkDelete(t, service)
+
+	// The first run dies in the middle of its second batch
+	users.failAfter = 3
+	require.Error(t, service.Run(context.Background(), jobID))
+	interrupted := jobs.jobs[jobID]
+	assert.Equal(t, domain.JobRunning, interrupted.Status)
+	assert.Equal(t, 40, interrupted.Progress)
+
+	// Act: the message is redelivered
+	users.failAfter = 0
+	err := service.Run(context.Background(), jobID)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Len(t, users.deleted, 5, "every user is deleted exactly once")
+	assert.Empty(t, users.users)
+	assert.Equal(t, domain.JobSucceeded, jobs.jobs[jobID].Status)
+
+	// A further redelivery leaves the finished job alone
+	updates := len(jobs.updates)
+	require.NoError(t, service.Run(context.Background(), jobID))
+	assert.Len(t, jobs.updates, updates)
+}
+
+func TestBulkDeleteService_RunAbortsPastTheCeiling(t *testing.T) {
+	// Arrange
+	users := newBulkUserRepository("a@example.com", "b@example.com")
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	service := newTestBulkDeleteService(users, jobs, WithBulkDeleteCeiling(2))
+	result, err := service.Start(context.Background(), domain.BulkDeleteRequest{Filter: exampleUsers})
+	require.NoError(t, err)
+
+	// Users created after the request push the count past the ceiling
+	users.add("user-10", "late@example.com")
+
+	// Act
+	err = service.Run(context.Background(), result.Job.ID)
+
+	// Assert
+	require.NoError(t, err)
+	job := jobs.jobs[result.Job.ID]
+	assert.Equal(t, domain.JobFailed, job.Status)
+	assert.Contains(t, job.Message, "3 users match, the ceiling is 2")
+	assert.Empty(t, users.deleted)
+}
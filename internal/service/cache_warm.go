@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// Defaults of CacheWarmer
+const (
+	DefaultCacheWarmUsers = 1000
+	DefaultCacheWarmRate  = 100
+)
+
+// CacheWarmer loads the users seen most recently into the user cache, so
+// that a replica starting with an empty cache does not send every lookup of
+// its hot users to the database at once
+type CacheWarmer struct {
+	users domain.RecentUserRepository
+	cache domain.UserCache
+	log   *logger.Logger
+
+	limit int
+	rate  int
+	// wait blocks between two cached users, it is replaced in tests
+	wait func(ctx context.Context, d time.Duration) error
+}
+
+// CacheWarmOption is a function that configures a CacheWarmer
+type CacheWarmOption func(*CacheWarmer)
+
+// WithCacheWarmUsers sets how many of the users seen most recently are cached
+func WithCacheWarmUsers(n int) CacheWarmOption {
+	return func(w *CacheWarmer) {
+		if n > 0 {
+			w.limit = n
+		}
+	}
+}
+
+// WithCacheWarmRate sets how many users are cached per second, so that
+// warming does not compete with requests for the database and the cache
+func WithCacheWarmRate(perSecond int) CacheWarmOption {
+	return func(w *CacheWarmer) {
+		if perSecond > 0 {
+			w.rate = perSecond
+		}
+	}
+}
+
+// NewCacheWarmer creates a cache warmer reading users from users into cache
+func NewCacheWarmer(users domain.RecentUserRepository, cache domain.UserCache, log *logger.Logger, opts ...CacheWarmOption) *CacheWarmer {
+	w := &CacheWarmer{
+		users: users,
+		cache: cache,
+		log:   log,
+		limit: DefaultCacheWarmUsers,
+		rate:  DefaultCacheWarmRate,
+		wait:  sleepContext,
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// Warm caches the users seen most recently at the configured rate and
+// returns how many it cached. It does nothing while the cache is
+// unavailable, and stops at the first user that cannot be cached since the
+// backend most likely went away.
+func (w *CacheWarmer) Warm(ctx context.Context) (int, error) {
+	if err := w.cache.Ping(ctx); err != nil {
+		w.log.Warn("Skipping cache warming, the cache is unavailable", map[string]interface{}{"error": err.Error()})
+		return 0, nil
+	}
+
+	users, err := w.users.ListRecentlySeen(ctx, w.limit)
+	if err != nil {
+		return 0, err
+	}
+
+	interval := time.Second / time.Duration(w.rate)
+	warmed := 0
+	for i, user := range users {
+		if i > 0 {
+			if err := w.wait(ctx, interval); err != nil {
+				w.record(warmed, len(users))
+				return warmed, err
+			}
+		}
+		if err := w.cache.Prime(ctx, user); err != nil {
+			w.log.Warn("Stopping cache warming, failed to cache a user", map[string]interface{}{
+				"user_id": user.ID,
+				"error":   err.Error(),
+			})
+			break
+		}
+		warmed++
+	}
+
+	w.record(warmed, len(users))
+	return warmed, nil
+}
+
+func (w *CacheWarmer) record(warmed, candidates int) {
+	metrics.CacheWarmed.Add(float64(warmed))
+	w.log.Info("User cache warmed", map[string]interface{}{"warmed": warmed, "candidates": candidates})
+}
@@ -0,0 +1,173 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/repository"
+)
+
+// recentUsers serves users in the order they were given, most recent first
+type recentUsers struct {
+	users  []*domain.User
+	limits []int
+	// seen are the IDs marked seen, in order
+	seen []string
+}
+
+func newRecentUsers(n int) *recentUsers {
+	r := &recentUsers{}
+	for i := 0; i < n; i++ {
+		r.users = append(r.users, &domain.User{ID: fmt.Sprintf("user-%02d", i), Email: fmt.Sprintf("u%d@example.com", i)})
+	}
+	return r
+}
+
+func (r *recentUsers) ListRecentlySeen(ctx context.Context, limit int) ([]*domain.User, error) {
+	r.limits = append(r.limits, limit)
+	return r.users[:min(limit, len(r.users))], nil
+}
+
+func (r *recentUsers) MarkSeen(ctx context.Context, id string, at time.Time) error {
+	r.seen = append(r.seen, id)
+	return nil
+}
+
+// flakyCache is a cache whose backend is down or fails after some writes
+type flakyCache struct {
+	*cache.Memory
+	down bool
+	// failAfter makes writes fail once that many succeeded, 0 never
+	failAfter int
+	writes    int
+}
+
+func (c *flakyCache) Ping(ctx context.Context) error {
+	if c.down {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func (c *flakyCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if c.failAfter > 0 && c.writes >= c.failAfter {
+		return errors.New("connection reset")
+	}
+	c.writes++
+	return c.Memory.Set(ctx, key, value, ttl)
+}
+
+// newWarmer returns a warmer filling an in-memory user cache over an empty
+// repository, so that every cache miss fails, and the waits it made
+func newWarmer(users *recentUsers, c cache.Cache, opts ...CacheWarmOption) (*CacheWarmer, *repository.CachedUserRepository, *[]time.Duration) {
+	log := logger.New()
+	cached := repository.NewCachedUserRepository(repository.NewMemoryUserRepository(), c, time.Minute, log)
+
+	w := NewCacheWarmer(users, cached, log, opts...)
+	var waits []time.Duration
+	w.wait = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return nil
+	}
+	return w, cached, &waits
+}
+
+func TestCacheWarmer_Warm(t *testing.T) {
+	tests := []struct {
+		name       string
+		users      int
+		limit      int
+		wantWarmed int
+	}{
+		{name: "fewer users than the limit", users: 3, limit: 10, wantWarmed: 3},
+		{name: "limited", users: 10, limit: 4, wantWarmed: 4},
+		{name: "no users", users: 0, limit: 10, wantWarmed: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			users := newRecentUsers(tt.users)
+			w, cached, _ := newWarmer(users, cache.NewMemory(), WithCacheWarmUsers(tt.limit))
+
+			// Act
+			warmed, err := w.Warm(context.Background())
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantWarmed, warmed)
+			assert.Equal(t, []int{tt.limit}, users.limits)
+			for _, u := range users.users[:tt.wantWarmed] {
+				// The repository behind the cache is empty, so only a cached user is found
+				got, err := cached.GetByID(context.Background(), u.ID)
+				require.NoError(t, err)
+				assert.Equal(t, u.Email, got.Email)
+			}
+		})
+	}
+}
+
+func TestCacheWarmer_Warm_RateLimited(t *testing.T) {
+	// Arrange
+	w, _, waits := newWarmer(newRecentUsers(5), cache.NewMemory(), WithCacheWarmRate(50))
+
+	// Act
+	warmed, err := w.Warm(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 5, warmed)
+	assert.Equal(t, []time.Duration{20 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond}, *waits,
+		"users are spread at the rate, with no wait before the first")
+}
+
+func TestCacheWarmer_Warm_StopsWhenCancelled(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	cached := repository.NewCachedUserRepository(repository.NewMemoryUserRepository(), cache.NewMemory(), time.Minute, log)
+	w := NewCacheWarmer(newRecentUsers(3), cached, log, WithCacheWarmRate(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Act
+	warmed, err := w.Warm(ctx)
+
+	// Assert
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, warmed)
+}
+
+func TestCacheWarmer_Warm_CacheUnavailable(t *testing.T) {
+	// Arrange
+	users := newRecentUsers(3)
+	w, _, _ := newWarmer(users, &flakyCache{Memory: cache.NewMemory(), down: true})
+
+	// Act
+	warmed, err := w.Warm(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	assert.Zero(t, warmed)
+	assert.Empty(t, users.limits, "users must not be read for an unavailable cache")
+}
+
+func TestCacheWarmer_Warm_StopsAtCacheFailure(t *testing.T) {
+	// Arrange
+	w, _, _ := newWarmer(newRecentUsers(5), &flakyCache{Memory: cache.NewMemory(), failAfter: 2})
+
+	// Act
+	warmed, err := w.Warm(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 2, warmed)
+}
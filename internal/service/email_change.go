@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+)
+
+// defaultEmailChangeTTL is how long a confirmation token stays valid by default
+const defaultEmailChangeTTL = 24 * time.Hour
+
+// emailChangeRequestedPayload is sent to the new address for confirmation,
+// along with the token in emailChangeSecret
+type emailChangeRequestedPayload struct {
+	UserID    string    `json:"user_id"`
+	Email     string    `json:"email"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// emailChangeSecret carries the confirmation token. It is the event secret
+// rather than part of its payload, so that the token is only kept until the
+// mail is published and never archived.
+type emailChangeSecret struct {
+	Token string `json:"token"`
+}
+
+// emailChangeNoticePayload is sent to the current address as a warning
+type emailChangeNoticePayload struct {
+	UserID   string `json:"user_id"`
+	Email    string `json:"email"`
+	NewEmail string `json:"new_email"`
+}
+
+// RequestEmailChange stores a pending change for the user and emits events for
+// the confirmation mail to the new address and the notice to the current one
+func (s *UserService) RequestEmailChange(ctx context.Context, userID, newEmail string) error {
+	s.log.Info("Requesting email change", map[string]interface{}{"user_id": userID})
+
+	user, err := s.repo.GetByID(consistency.WithPrimary(ctx), userID)
+	if err != nil {
+		return err
+	}
+
+	if user.Email == newEmail {
+		return domain.ErrInvalidInput
+	}
+
+	taken, err := s.repo.EmailExists(ctx, newEmail)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return domain.ErrEmailTaken
+	}
+
+	token, tokenHash, err := newEmailChangeToken()
+	if err != nil {
+		return err
+	}
+
+	now := s.gen.Clock.Now()
+	change := &domain.EmailChange{
+		UserID:    userID,
+		NewEmail:  newEmail,
+		TokenHash: tokenHash,
+		ExpiresAt: now.Add(s.emailChangeTTL),
+		CreatedAt: now,
+	}
+
+	requested, err := s.newOutboxEvent(ctx, domain.EventEmailChangeRequested, now, emailChangeRequestedPayload{
+		UserID:    userID,
+		Email:     newEmail,
+		ExpiresAt: change.ExpiresAt,
+	})
+	if err != nil {
+		return err
+	}
+	if requested.Secret, err = json.Marshal(emailChangeSecret{Token: token}); err != nil {
+		return err
+	}
+
+	notice, err := s.newOutboxEvent(ctx, domain.EventEmailChangeNotice, now, emailChangeNoticePayload{
+		UserID:   userID,
+		Email:    user.Email,
+		NewEmail: newEmail,
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.repo.CreateEmailChange(ctx, change, []*domain.OutboxEvent{requested, notice})
+}
+
+// ConfirmEmailChange applies the pending change identified by the token
+func (s *UserService) ConfirmEmailChange(ctx context.Context, token string) (*domain.User, error) {
+	change, err := s.repo.GetEmailChangeByTokenHash(ctx, hashEmailChangeToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.Info("Confirming email change", map[string]interface{}{"user_id": change.UserID})
+
+	if change.Expired(s.gen.Clock.Now()) {
+		return nil, domain.ErrEmailChangeExpired
+	}
+
+	if err := s.repo.ConfirmEmailChange(ctx, change); err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.GetByID(consistency.WithPrimary(ctx), change.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.notify(ctx, UserEvent{Type: UserUpdated, UserID: user.ID, User: user})
+	return user, nil
+}
+
+// CancelEmailChange discards the pending change of the user
+func (s *UserService) CancelEmailChange(ctx context.Context, userID string) error {
+	s.log.Info("Cancelling email change", map[string]interface{}{"user_id": userID})
+	return s.repo.CancelEmailChange(ctx, userID)
+}
+
+// newEmailChangeToken returns a random URL-safe token and its hash
+func newEmailChangeToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashEmailChangeToken(token), nil
+}
+
+func hashEmailChangeToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newOutboxEvent returns an event of the tenant the context acts for
+func (s *UserService) newOutboxEvent(ctx context.Context, eventType string, createdAt time.Time, payload interface{}) (*domain.OutboxEvent, error) {
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &domain.OutboxEvent{
+		ID:        s.gen.IDs.NewID(),
+		Type:      eventType,
+		Tenant:    tenant.FromContext(ctx),
+		Payload:   raw,
+		CreatedAt: createdAt,
+	}, nil
+}
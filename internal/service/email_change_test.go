@@ -0,0 +1,152 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/idgen"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// primaryContext matches contexts whose reads must see the latest writes,
+// as required before a read-modify-write
+var primaryContext = mock.MatchedBy(func(ctx context.Context) bool {
+	return consistency.PrimaryRequired(ctx)
+})
+
+func TestUserService_RequestEmailChange(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	service := NewUserService(mockRepo, logger.New(), WithEmailChangeConfirmation(time.Hour),
+		WithUserGenerators(generate.WithClock(clock.NewFixed(now)), generate.WithIDGenerator(idgen.NewSequence())))
+	ctx := context.Background()
+
+	mockRepo.On("GetByID", primaryContext, "user-123").Return(&domain.User{ID: "user-123", Email: "old@example.com"}, nil)
+	mockRepo.On("EmailExists", ctx, "new@example.com").Return(false, nil)
+
+	var token string
+	mockRepo.On("CreateEmailChange", ctx, mock.MatchedBy(func(c *domain.EmailChange) bool {
+		return c.UserID == "user-123" && c.NewEmail == "new@example.com" && c.TokenHash != ""
+	}), mock.MatchedBy(func(events []*domain.OutboxEvent) bool {
+		if len(events) != 2 || events[0].Type != domain.EventEmailChangeRequested || events[1].Type != domain.EventEmailChangeNotice {
+			return false
+		}
+		var secret emailChangeSecret
+		if err := json.Unmarshal(events[0].Secret, &secret); err != nil {
+			return false
+		}
+		token = secret.Token
+		return true
+	})).Return(nil)
+
+	// Act
+	err := service.RequestEmailChange(ctx, "user-123", "new@example.com")
+
+	// Assert
+	assert.NoError(t, err)
+	change := mockRepo.Calls[2].Arguments.Get(1).(*domain.EmailChange)
+	assert.Equal(t, hashEmailChangeToken(token), change.TokenHash, "only the token hash must be stored")
+	assert.Equal(t, now, change.CreatedAt)
+	assert.Equal(t, now.Add(time.Hour), change.ExpiresAt)
+
+	events := mockRepo.Calls[2].Arguments.Get(2).([]*domain.OutboxEvent)
+	assert.Equal(t, idgen.SequenceID(1), events[0].ID)
+	assert.Equal(t, idgen.SequenceID(2), events[1].ID)
+	assert.Equal(t, now, events[0].CreatedAt)
+	assert.Equal(t, now, events[1].CreatedAt)
+	assert.NotContains(t, string(events[0].Payload), token, "the token must not be kept in the payload")
+	assert.Empty(t, events[1].Secret)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_ConfirmEmailChange_ConflictAtConfirm(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	service := NewUserService(mockRepo, logger.New())
+	ctx := context.Background()
+
+	change := &domain.EmailChange{
+		UserID:    "user-123",
+		NewEmail:  "new@example.com",
+		TokenHash: hashEmailChangeToken("token"),
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	mockRepo.On("GetEmailChangeByTokenHash", ctx, change.TokenHash).Return(change, nil)
+	mockRepo.On("ConfirmEmailChange", ctx, change).Return(domain.ErrEmailTaken)
+
+	// Act
+	user, err := service.ConfirmEmailChange(ctx, "token")
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrEmailTaken)
+	assert.Nil(t, user)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_ConfirmEmailChange_Expired(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	service := NewUserService(mockRepo, logger.New())
+	ctx := context.Background()
+
+	change := &domain.EmailChange{
+		UserID:    "user-123",
+		NewEmail:  "new@example.com",
+		TokenHash: hashEmailChangeToken("token"),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+	mockRepo.On("GetEmailChangeByTokenHash", ctx, change.TokenHash).Return(change, nil)
+
+	// Act
+	user, err := service.ConfirmEmailChange(ctx, "token")
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrEmailChangeExpired)
+	assert.Nil(t, user)
+	mockRepo.AssertNotCalled(t, "ConfirmEmailChange", mock.Anything, mock.Anything)
+}
+
+func TestUserService_CancelEmailChange(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	service := NewUserService(mockRepo, logger.New())
+	ctx := context.Background()
+
+	mockRepo.On("CancelEmailChange", ctx, "user-123").Return(nil).Once()
+	mockRepo.On("CancelEmailChange", ctx, "user-123").Return(domain.ErrEmailChangeNotFound).Once()
+
+	// Act
+	first := service.CancelEmailChange(ctx, "user-123")
+	second := service.CancelEmailChange(ctx, "user-123")
+
+	// Assert
+	assert.NoError(t, first)
+	assert.ErrorIs(t, second, domain.ErrEmailChangeNotFound)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_Update_RejectsDirectEmailChange(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	service := NewUserService(mockRepo, logger.New(), WithEmailChangeConfirmation(0))
+	ctx := context.Background()
+
+	mockRepo.On("GetByID", primaryContext, "user-123").Return(&domain.User{ID: "user-123", Email: "old@example.com"}, nil)
+
+	// Act
+	err := service.Update(ctx, &domain.User{ID: "user-123", Email: "new@example.com", Name: "New"})
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrEmailChangeRequiresConfirm)
+	mockRepo.AssertNotCalled(t, "Update", mock.Anything, mock.Anything)
+}
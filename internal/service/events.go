@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// replayBatchSize is the number of archived events loaded at a time
+const replayBatchSize = 500
+
+// QueuePublisher delivers an event straight to a queue
+type QueuePublisher interface {
+	PublishToQueue(ctx context.Context, queue string, event *domain.OutboxEvent) error
+}
+
+// ReplayRequest selects the archived events to republish and where to
+type ReplayRequest struct {
+	// Type keeps only events of the type, all types when empty
+	Type string
+	// FromSeq and ToSeq bound the sequence numbers, both inclusive. ToSeq 0
+	// replays up to the latest event.
+	FromSeq int64
+	ToSeq   int64
+	Queue   string
+	// Rate is the maximum number of events published per second, 0 is unlimited
+	Rate float64
+}
+
+// ReplayResult reports a replay. LastSeq is 0 when nothing was replayed,
+// otherwise an interrupted replay resumes from LastSeq+1.
+type ReplayResult struct {
+	Replayed int   `json:"replayed"`
+	LastSeq  int64 `json:"last_seq"`
+}
+
+// EventService replays archived events and enforces the archive retention
+type EventService struct {
+	archive   domain.EventArchiveRepository
+	log       *logger.Logger
+	retention time.Duration
+	gen       generate.Generators
+	// sleep waits between events to keep a replay under its rate
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewEventService creates an event service. A retention of 0 keeps archived
+// events forever.
+func NewEventService(archive domain.EventArchiveRepository, log *logger.Logger, retention time.Duration, opts ...generate.Option) *EventService {
+	return &EventService{
+		archive:   archive,
+		log:       log,
+		retention: retention,
+		gen:       generate.New(opts...),
+		sleep:     sleepContext,
+	}
+}
+
+// Replay republishes the selected events to the queue in sequence order and
+// stops at the first failure, so that later events are not delivered ahead
+// of earlier ones
+func (s *EventService) Replay(ctx context.Context, publisher QueuePublisher, req ReplayRequest) (*ReplayResult, error) {
+	if req.Queue == "" {
+		return nil, errors.New("queue is required")
+	}
+	if req.ToSeq > 0 && req.ToSeq < req.FromSeq {
+		return nil, errors.New("to_seq is before from_seq")
+	}
+
+	var interval time.Duration
+	if req.Rate > 0 {
+		interval = time.Duration(float64(time.Second) / req.Rate)
+	}
+
+	result := &ReplayResult{}
+	filter := domain.EventArchiveFilter{Type: req.Type, FromSeq: req.FromSeq, ToSeq: req.ToSeq, Limit: replayBatchSize}
+	start := s.gen.Clock.Now()
+	for {
+		events, err := s.archive.List(ctx, filter)
+		if err != nil {
+			return result, err
+		}
+
+		for _, event := range events {
+			// Events are paced against the start so that slow publishes do
+			// not add up to a lower rate
+			if result.Replayed > 0 && interval > 0 {
+				due := start.Add(time.Duration(result.Replayed) * interval)
+				if wait := due.Sub(s.gen.Clock.Now()); wait > 0 {
+					if err := s.sleep(ctx, wait); err != nil {
+						return result, err
+					}
+				}
+			}
+
+			if err := publisher.PublishToQueue(ctx, req.Queue, event.Event()); err != nil {
+				s.log.Error("Failed to replay archived event", err, map[string]interface{}{
+					"seq":        event.Seq,
+					"event_id":   event.ID,
+					"event_type": event.Type,
+					"queue":      req.Queue,
+				})
+				return result, err
+			}
+			result.Replayed++
+			result.LastSeq = event.Seq
+		}
+
+		if len(events) < filter.Limit {
+			break
+		}
+		filter.FromSeq = result.LastSeq + 1
+	}
+
+	s.log.Info("Replayed archived events", map[string]interface{}{
+		"event_type": req.Type,
+		"queue":      req.Queue,
+		"replayed":   result.Replayed,
+		"last_seq":   result.LastSeq,
+	})
+	return result, nil
+}
+
+// PurgeArchive deletes the archived events older than the retention
+func (s *EventService) PurgeArchive(ctx context.Context) error {
+	if s.retention <= 0 {
+		return nil
+	}
+
+	cutoff := s.gen.Clock.Now().Add(-s.retention)
+	purged, err := s.archive.Purge(ctx, cutoff)
+	if err != nil {
+		return err
+	}
+
+	if purged > 0 {
+		s.log.Info("Purged archived events", map[string]interface{}{
+			"purged": purged,
+			"cutoff": cutoff,
+		})
+	}
+	return nil
+}
+
+// sleepContext waits for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
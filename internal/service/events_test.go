@@ -0,0 +1,190 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// memoryEventArchive numbers archived events like the seq column
+type memoryEventArchive struct {
+	events []*domain.ArchivedEvent
+	purged time.Time
+}
+
+// archive appends events of the given types, stamped at archived
+func (r *memoryEventArchive) archive(archived time.Time, types ...string) {
+	for _, eventType := range types {
+		seq := int64(len(r.events) + 1)
+		r.events = append(r.events, &domain.ArchivedEvent{
+			Seq:        seq,
+			ID:         fmt.Sprintf("e-%d", seq),
+			Type:       eventType,
+			Payload:    json.RawMessage(fmt.Sprintf(`{"seq":%d}`, seq)),
+			CreatedAt:  archived,
+			ArchivedAt: archived,
+		})
+	}
+}
+
+func (r *memoryEventArchive) List(ctx context.Context, filter domain.EventArchiveFilter) ([]*domain.ArchivedEvent, error) {
+	var events []*domain.ArchivedEvent
+	for _, e := range r.events {
+		switch {
+		case e.Seq < filter.FromSeq,
+			filter.ToSeq > 0 && e.Seq > filter.ToSeq,
+			filter.Type != "" && e.Type != filter.Type:
+			continue
+		}
+		events = append(events, e)
+		if len(events) == filter.Limit {
+			break
+		}
+	}
+	return events, nil
+}
+
+func (r *memoryEventArchive) Purge(ctx context.Context, before time.Time) (int64, error) {
+	r.purged = before
+	var kept []*domain.ArchivedEvent
+	for _, e := range r.events {
+		if !e.ArchivedAt.Before(before) {
+			kept = append(kept, e)
+		}
+	}
+	purged := int64(len(r.events) - len(kept))
+	r.events = kept
+	return purged, nil
+}
+
+// recordingQueuePublisher records published events, each publish taking
+// latency on the clock
+type recordingQueuePublisher struct {
+	clock     *clock.Fixed
+	latency   time.Duration
+	failOn    string
+	queues    []string
+	published []string
+}
+
+func (p *recordingQueuePublisher) PublishToQueue(ctx context.Context, queue string, event *domain.OutboxEvent) error {
+	p.clock.Advance(p.latency)
+	if event.ID == p.failOn {
+		return errors.New("broker unavailable")
+	}
+	p.queues = append(p.queues, queue)
+	p.published = append(p.published, event.ID)
+	return nil
+}
+
+// newTestEventService returns a service whose sleeps advance the clock and
+// are recorded
+func newTestEventService(archive domain.EventArchiveRepository, c *clock.Fixed) (*EventService, *[]time.Duration) {
+	svc := NewEventService(archive, logger.New(), 90*24*time.Hour, generate.WithClock(c))
+	var sleeps []time.Duration
+	svc.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		c.Advance(d)
+		return nil
+	}
+	return svc, &sleeps
+}
+
+func TestEventService_Replay_RangeInOrder(t *testing.T) {
+	// Arrange
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	archive := &memoryEventArchive{}
+	archive.archive(now, "user.created", "user.deleted", "user.created", "user.created", "user.deleted", "user.created", "user.created")
+	c := clock.NewFixed(now)
+	publisher := &recordingQueuePublisher{clock: c}
+	svc, sleeps := newTestEventService(archive, c)
+
+	// Act
+	result, err := svc.Replay(context.Background(), publisher, ReplayRequest{
+		Type: "user.created", FromSeq: 2, ToSeq: 6, Queue: "rebuild.queue",
+	})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, &ReplayResult{Replayed: 3, LastSeq: 6}, result)
+	assert.Equal(t, []string{"e-3", "e-4", "e-6"}, publisher.published)
+	assert.Equal(t, []string{"rebuild.queue", "rebuild.queue", "rebuild.queue"}, publisher.queues)
+	assert.Empty(t, *sleeps, "no rate limit")
+}
+
+func TestEventService_Replay_RateLimit(t *testing.T) {
+	// Arrange: 10 events per second, each publish takes 30ms
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	archive := &memoryEventArchive{}
+	archive.archive(now, "user.created", "user.created", "user.created", "user.created")
+	c := clock.NewFixed(now)
+	publisher := &recordingQueuePublisher{clock: c, latency: 30 * time.Millisecond}
+	svc, sleeps := newTestEventService(archive, c)
+
+	// Act
+	result, err := svc.Replay(context.Background(), publisher, ReplayRequest{Queue: "rebuild.queue", Rate: 10})
+
+	// Assert: the sleeps make up for the publish latency
+	require.NoError(t, err)
+	assert.Equal(t, 4, result.Replayed)
+	assert.Equal(t, []string{"e-1", "e-2", "e-3", "e-4"}, publisher.published)
+	assert.Equal(t, []time.Duration{70 * time.Millisecond, 70 * time.Millisecond, 70 * time.Millisecond}, *sleeps)
+	assert.Equal(t, now.Add(330*time.Millisecond), c.Now(), "the last event went out 300ms after the first")
+}
+
+func TestEventService_Replay_StopsAtFailure(t *testing.T) {
+	// Arrange
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	archive := &memoryEventArchive{}
+	archive.archive(now, "user.created", "user.created", "user.created")
+	c := clock.NewFixed(now)
+	publisher := &recordingQueuePublisher{clock: c, failOn: "e-2"}
+	svc, _ := newTestEventService(archive, c)
+
+	// Act
+	result, err := svc.Replay(context.Background(), publisher, ReplayRequest{Queue: "rebuild.queue"})
+
+	// Assert: the replay resumes from LastSeq+1
+	require.Error(t, err)
+	assert.Equal(t, &ReplayResult{Replayed: 1, LastSeq: 1}, result)
+	assert.Equal(t, []string{"e-1"}, publisher.published)
+}
+
+func TestEventService_Replay_InvalidRequest(t *testing.T) {
+	svc, _ := newTestEventService(&memoryEventArchive{}, clock.NewFixed(time.Now()))
+
+	_, err := svc.Replay(context.Background(), &recordingQueuePublisher{}, ReplayRequest{})
+	assert.EqualError(t, err, "queue is required")
+
+	_, err = svc.Replay(context.Background(), &recordingQueuePublisher{}, ReplayRequest{Queue: "q", FromSeq: 5, ToSeq: 4})
+	assert.EqualError(t, err, "to_seq is before from_seq")
+}
+
+func TestEventService_PurgeArchive(t *testing.T) {
+	// Arrange
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	archive := &memoryEventArchive{}
+	archive.archive(now.AddDate(0, 0, -91), "user.created")
+	archive.archive(now.AddDate(0, 0, -89), "user.created")
+	svc, _ := newTestEventService(archive, clock.NewFixed(now))
+
+	// Act
+	err := svc.PurgeArchive(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(-90*24*time.Hour), archive.purged)
+	require.Len(t, archive.events, 1)
+	assert.Equal(t, int64(2), archive.events[0].Seq)
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// defaultInstanceStaleAfter is used when no stale threshold is configured
+const defaultInstanceStaleAfter = time.Minute
+
+// InstanceService tracks running replicas so that configuration or version
+// drift between them can be detected
+type InstanceService struct {
+	repo       domain.InstanceRepository
+	log        *logger.Logger
+	staleAfter time.Duration
+	gen        generate.Generators
+}
+
+func NewInstanceService(repo domain.InstanceRepository, log *logger.Logger, staleAfter time.Duration, opts ...generate.Option) *InstanceService {
+	if staleAfter <= 0 {
+		staleAfter = defaultInstanceStaleAfter
+	}
+
+	return &InstanceService{
+		repo:       repo,
+		log:        log,
+		staleAfter: staleAfter,
+		gen:        generate.New(opts...),
+	}
+}
+
+// Register records the instance as live
+func (s *InstanceService) Register(ctx context.Context, instance *domain.ServiceInstance) error {
+	instance.HeartbeatAt = s.gen.Clock.Now()
+	return s.repo.Register(ctx, instance)
+}
+
+// KeepAlive registers the instance and refreshes its heartbeat every interval
+// until ctx is done. A failed heartbeat is logged and retried on the next tick.
+func (s *InstanceService) KeepAlive(ctx context.Context, instance *domain.ServiceInstance, interval time.Duration) error {
+	if err := s.Register(ctx, instance); err != nil {
+		return err
+	}
+
+	s.log.Info("Service instance registered", map[string]interface{}{
+		"instance_id": instance.ID,
+		"config_hash": instance.ConfigHash,
+		"version":     instance.Version,
+	})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := s.Register(ctx, instance); err != nil && ctx.Err() == nil {
+				s.log.Error("Failed to send instance heartbeat", err, map[string]interface{}{"instance_id": instance.ID})
+			}
+		}
+	}
+}
+
+// List returns live instances and flags disagreement on config hash or version
+func (s *InstanceService) List(ctx context.Context) (*domain.InstanceReport, error) {
+	instances, err := s.repo.ListLive(ctx, s.gen.Clock.Now().Add(-s.staleAfter))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &domain.InstanceReport{Instances: instances}
+	if report.Instances == nil {
+		report.Instances = []*domain.ServiceInstance{}
+	}
+
+	for i := 1; i < len(instances); i++ {
+		if instances[i].ConfigHash != instances[0].ConfigHash {
+			report.ConfigMismatch = true
+		}
+		if instances[i].Version != instances[0].Version {
+			report.VersionMismatch = true
+		}
+	}
+
+	return report, nil
+}
+
+// PruneStale removes instances that stopped sending heartbeats
+func (s *InstanceService) PruneStale(ctx context.Context) error {
+	pruned, err := s.repo.PruneStale(ctx, s.gen.Clock.Now().Add(-s.staleAfter))
+	if err != nil {
+		return err
+	}
+
+	s.log.Info("Pruned stale service instances", map[string]interface{}{"pruned": pruned})
+	return nil
+}
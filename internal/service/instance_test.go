@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// memoryInstanceRepository keeps registrations in memory, keyed by instance ID
+type memoryInstanceRepository struct {
+	instances map[string]*domain.ServiceInstance
+	order     []string
+}
+
+func newMemoryInstanceRepository() *memoryInstanceRepository {
+	return &memoryInstanceRepository{instances: map[string]*domain.ServiceInstance{}}
+}
+
+func (r *memoryInstanceRepository) Register(ctx context.Context, instance *domain.ServiceInstance) error {
+	if _, ok := r.instances[instance.ID]; !ok {
+		r.order = append(r.order, instance.ID)
+	}
+	copied := *instance
+	r.instances[instance.ID] = &copied
+	return nil
+}
+
+func (r *memoryInstanceRepository) ListLive(ctx context.Context, heartbeatAfter time.Time) ([]*domain.ServiceInstance, error) {
+	var live []*domain.ServiceInstance
+	for _, id := range r.order {
+		if i, ok := r.instances[id]; ok && i.HeartbeatAt.After(heartbeatAfter) {
+			live = append(live, i)
+		}
+	}
+	return live, nil
+}
+
+func (r *memoryInstanceRepository) PruneStale(ctx context.Context, heartbeatBefore time.Time) (int64, error) {
+	var pruned int64
+	for id, i := range r.instances {
+		if i.HeartbeatAt.Before(heartbeatBefore) {
+			delete(r.instances, id)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+func TestInstanceService_List_ConfigMismatch(t *testing.T) {
+	// Arrange
+	repo := newMemoryInstanceRepository()
+	service := NewInstanceService(repo, logger.New(), time.Minute)
+	ctx := context.Background()
+
+	// Two replicas of the same build started with different env configs
+	require.NoError(t, service.Register(ctx, &domain.ServiceInstance{ID: "a", ConfigHash: "hash-1", Version: "v1"}))
+	require.NoError(t, service.Register(ctx, &domain.ServiceInstance{ID: "b", ConfigHash: "hash-2", Version: "v1"}))
+
+	// Act
+	report, err := service.List(ctx)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Len(t, report.Instances, 2)
+	assert.True(t, report.ConfigMismatch)
+	assert.False(t, report.VersionMismatch)
+}
+
+func TestInstanceService_List_NoMismatch(t *testing.T) {
+	// Arrange
+	repo := newMemoryInstanceRepository()
+	service := NewInstanceService(repo, logger.New(), time.Minute)
+	ctx := context.Background()
+
+	require.NoError(t, service.Register(ctx, &domain.ServiceInstance{ID: "a", ConfigHash: "hash-1", Version: "v1"}))
+	require.NoError(t, service.Register(ctx, &domain.ServiceInstance{ID: "b", ConfigHash: "hash-1", Version: "v1"}))
+
+	// Act
+	report, err := service.List(ctx)
+
+	// Assert
+	assert.NoError(t, err)
+	assert.False(t, report.ConfigMismatch)
+	assert.False(t, report.VersionMismatch)
+}
+
+func TestInstanceService_StaleInstancesIgnoredAndPruned(t *testing.T) {
+	// Arrange
+	repo := newMemoryInstanceRepository()
+	service := NewInstanceService(repo, logger.New(), time.Minute)
+	ctx := context.Background()
+
+	require.NoError(t, service.Register(ctx, &domain.ServiceInstance{ID: "a", ConfigHash: "hash-1", Version: "v1"}))
+	require.NoError(t, service.Register(ctx, &domain.ServiceInstance{ID: "b", ConfigHash: "hash-2", Version: "v2"}))
+	// b stopped sending heartbeats
+	repo.instances["b"].HeartbeatAt = time.Now().Add(-time.Hour)
+
+	// Act
+	report, err := service.List(ctx)
+	require.NoError(t, err)
+	pruneErr := service.PruneStale(ctx)
+
+	// Assert
+	assert.Len(t, report.Instances, 1)
+	assert.False(t, report.ConfigMismatch)
+	assert.False(t, report.VersionMismatch)
+	assert.NoError(t, pruneErr)
+	assert.NotContains(t, repo.instances, "b")
+}
@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"io"
+	"time"
 
 	"github.com/romanitalian/carch-go/internal/domain"
 )
@@ -10,7 +12,64 @@ import (
 type UserServiceInterface interface {
 	Create(ctx context.Context, user *domain.User) error
 	GetByID(ctx context.Context, id string) (*domain.User, error)
+	GetByIDs(ctx context.Context, ids []string) (*domain.UserLookup, error)
 	Update(ctx context.Context, user *domain.User) error
+	Patch(ctx context.Context, id string, changes domain.UserChanges) (*domain.User, error)
 	Delete(ctx context.Context, id string) error
-	List(ctx context.Context) ([]*domain.User, error)
+	List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error)
+	ListTombstones(ctx context.Context, since time.Time) ([]*domain.Tombstone, error)
+	Purge(ctx context.Context, retention, horizon time.Duration) error
+
+	RequestEmailChange(ctx context.Context, userID, newEmail string) error
+	ConfirmEmailChange(ctx context.Context, token string) (*domain.User, error)
+	CancelEmailChange(ctx context.Context, userID string) error
+}
+
+// InstanceServiceInterface defines the interface for service instance tracking
+type InstanceServiceInterface interface {
+	Register(ctx context.Context, instance *domain.ServiceInstance) error
+	KeepAlive(ctx context.Context, instance *domain.ServiceInstance, interval time.Duration) error
+	List(ctx context.Context) (*domain.InstanceReport, error)
+	PruneStale(ctx context.Context) error
+}
+
+// AuditServiceInterface defines the interface for the audit log
+type AuditServiceInterface interface {
+	List(ctx context.Context, filter domain.AuditFilter) (*domain.AuditPage, error)
+	MaintainPartitions(ctx context.Context, now time.Time) error
+}
+
+// JobServiceInterface defines the interface for job service
+type JobServiceInterface interface {
+	Get(ctx context.Context, id string) (*domain.Job, error)
+	Update(ctx context.Context, job *domain.Job) error
+	Watch(ctx context.Context, id string) (<-chan *domain.Job, error)
+}
+
+// BulkDeleteServiceInterface defines the interface for bulk user deletion
+type BulkDeleteServiceInterface interface {
+	Start(ctx context.Context, req domain.BulkDeleteRequest) (*domain.BulkDeleteResult, error)
+	// Run deletes the users of an enqueued job, called by the worker
+	Run(ctx context.Context, jobID string) error
+}
+
+// UserImportServiceInterface defines the interface for user imports
+type UserImportServiceInterface interface {
+	// Stage stages an import file and enqueues the job importing it
+	Stage(ctx context.Context, format string, r io.Reader) (*domain.Job, error)
+	// Run imports the staged file of an enqueued job, called by the worker
+	Run(ctx context.Context, jobID string) error
+	// CleanStaging removes the staged files of abandoned imports
+	CleanStaging(ctx context.Context) error
+}
+
+// WebhookServiceInterface defines the interface for inbound webhooks
+type WebhookServiceInterface interface {
+	Receive(ctx context.Context, req *domain.WebhookRequest) error
+}
+
+// EventServiceInterface defines the interface for the event archive
+type EventServiceInterface interface {
+	Replay(ctx context.Context, publisher QueuePublisher, req ReplayRequest) (*ReplayResult, error)
+	PurgeArchive(ctx context.Context) error
 }
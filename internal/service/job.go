@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/pubsub"
+)
+
+// JobService exposes job state and streams its changes
+type JobService struct {
+	repo   domain.JobRepository
+	events *pubsub.Broker
+	log    *logger.Logger
+
+	publishLocal bool
+}
+
+// JobOption is a function that configures a JobService
+type JobOption func(*JobService)
+
+// WithLocalJobEvents publishes job changes to the in-process broker directly.
+// Use it when the worker runs in the same process and Postgres notifications
+// are not relayed to the broker.
+func WithLocalJobEvents() JobOption {
+	return func(s *JobService) {
+		s.publishLocal = true
+	}
+}
+
+// NewJobService creates a job service. Watchers are woken up through events,
+// which must receive the ID of every changed job.
+func NewJobService(repo domain.JobRepository, events *pubsub.Broker, log *logger.Logger, opts ...JobOption) *JobService {
+	if events == nil {
+		events = pubsub.New()
+	}
+
+	s := &JobService{
+		repo:   repo,
+		events: events,
+		log:    log,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *JobService) Get(ctx context.Context, id string) (*domain.Job, error) {
+	return s.repo.GetByID(ctx, id)
+}
+
+// Enqueue stores the job and hands it to the worker through event
+func (s *JobService) Enqueue(ctx context.Context, job *domain.Job, event *domain.OutboxEvent) error {
+	return s.repo.Enqueue(ctx, job, event)
+}
+
+// Update stores the job progress and wakes up its watchers
+func (s *JobService) Update(ctx context.Context, job *domain.Job) error {
+	if err := s.repo.Update(ctx, job); err != nil {
+		return err
+	}
+
+	if s.publishLocal {
+		s.events.Publish(job.ID)
+	}
+	return nil
+}
+
+// Watch returns a channel receiving the current job state and then every
+// change to it. The channel is closed after a terminal state is delivered or
+// when ctx is done.
+func (s *JobService) Watch(ctx context.Context, id string) (<-chan *domain.Job, error) {
+	// Subscribe before reading the state so that no change is missed in between
+	signals, unsubscribe := s.events.Subscribe(id)
+
+	job, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		unsubscribe()
+		return nil, err
+	}
+
+	updates := make(chan *domain.Job)
+	go func() {
+		defer close(updates)
+		defer unsubscribe()
+
+		for {
+			select {
+			case updates <- job:
+			case <-ctx.Done():
+				return
+			}
+
+			if job.Terminal() {
+				return
+			}
+
+			next, err := s.waitForChange(ctx, signals, job)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.log.Error("Failed to read job state", err, map[string]interface{}{"job_id": id})
+				}
+				return
+			}
+			job = next
+		}
+	}()
+
+	return updates, nil
+}
+
+// waitForChange blocks until the job differs from last. Signals may be
+// spurious, e.g. after the notification connection was re-established.
+func (s *JobService) waitForChange(ctx context.Context, signals <-chan struct{}, last *domain.Job) (*domain.Job, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-signals:
+		}
+
+		job, err := s.repo.GetByID(ctx, last.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if !job.UpdatedAt.Equal(last.UpdatedAt) || job.Status != last.Status || job.Progress != last.Progress {
+			return job, nil
+		}
+	}
+}
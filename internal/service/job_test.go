@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// memoryJobRepository keeps jobs in memory, along with every update
+type memoryJobRepository struct {
+	mu      sync.Mutex
+	jobs    map[string]domain.Job
+	events  []*domain.OutboxEvent
+	updates []domain.Job
+}
+
+func (r *memoryJobRepository) Create(ctx context.Context, job *domain.Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[job.ID] = *job
+	return nil
+}
+
+func (r *memoryJobRepository) Enqueue(ctx context.Context, job *domain.Job, event *domain.OutboxEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.jobs[job.ID] = *job
+	r.events = append(r.events, event)
+	return nil
+}
+
+func (r *memoryJobRepository) GetByID(ctx context.Context, id string) (*domain.Job, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, ok := r.jobs[id]
+	if !ok {
+		return nil, domain.ErrJobNotFound
+	}
+	return &job, nil
+}
+
+func (r *memoryJobRepository) Update(ctx context.Context, job *domain.Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.jobs[job.ID]; !ok {
+		return domain.ErrJobNotFound
+	}
+	job.UpdatedAt = time.Now()
+	r.jobs[job.ID] = *job
+	r.updates = append(r.updates, *job)
+	return nil
+}
+
+func receiveJob(t *testing.T, updates <-chan *domain.Job) *domain.Job {
+	t.Helper()
+	select {
+	case job, ok := <-updates:
+		require.True(t, ok, "updates closed early")
+		return job
+	case <-time.After(time.Second):
+		t.Fatal("no job update received")
+		return nil
+	}
+}
+
+func TestJobService_Watch(t *testing.T) {
+	// Arrange
+	repo := &memoryJobRepository{jobs: map[string]domain.Job{
+		"job-1": {ID: "job-1", Status: domain.JobPending},
+	}}
+	service := NewJobService(repo, nil, logger.New(), WithLocalJobEvents())
+	ctx := context.Background()
+
+	// Act
+	updates, err := service.Watch(ctx, "job-1")
+	require.NoError(t, err)
+
+	// Assert: the current state first, then every change until a terminal state
+	assert.Equal(t, domain.JobPending, receiveJob(t, updates).Status)
+
+	require.NoError(t, service.Update(ctx, &domain.Job{ID: "job-1", Status: domain.JobRunning, Progress: 50}))
+	job := receiveJob(t, updates)
+	assert.Equal(t, domain.JobRunning, job.Status)
+	assert.Equal(t, 50, job.Progress)
+
+	require.NoError(t, service.Update(ctx, &domain.Job{ID: "job-1", Status: domain.JobSucceeded, Progress: 100}))
+	assert.Equal(t, domain.JobSucceeded, receiveJob(t, updates).Status)
+
+	select {
+	case _, ok := <-updates:
+		assert.False(t, ok, "updates must be closed after a terminal state")
+	case <-time.After(time.Second):
+		t.Fatal("updates not closed")
+	}
+}
+
+func TestJobService_Watch_NotFound(t *testing.T) {
+	// Arrange
+	repo := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	service := NewJobService(repo, nil, logger.New())
+
+	// Act
+	updates, err := service.Watch(context.Background(), "missing")
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrJobNotFound)
+	assert.Nil(t, updates)
+}
+
+func TestJobService_Watch_StopsOnCancel(t *testing.T) {
+	// Arrange
+	repo := &memoryJobRepository{jobs: map[string]domain.Job{
+		"job-1": {ID: "job-1", Status: domain.JobRunning},
+	}}
+	service := NewJobService(repo, nil, logger.New())
+	ctx, cancel := context.WithCancel(context.Background())
+
+	updates, err := service.Watch(ctx, "job-1")
+	require.NoError(t, err)
+	receiveJob(t, updates)
+
+	// Act
+	cancel()
+
+	// Assert
+	select {
+	case _, ok := <-updates:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("updates not closed after cancel")
+	}
+}
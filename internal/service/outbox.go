@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// outboxBatchSize is the maximum number of events relayed per run
+const outboxBatchSize = 100
+
+// Publisher delivers an event to the message broker
+type Publisher interface {
+	Publish(ctx context.Context, event *domain.OutboxEvent) error
+}
+
+// OutboxRelay forwards pending outbox events to the message broker
+type OutboxRelay struct {
+	repo      domain.OutboxRepository
+	publisher Publisher
+	log       *logger.Logger
+	lanes     int
+}
+
+// OutboxRelayOption is a function that configures an OutboxRelay
+type OutboxRelayOption func(*OutboxRelay)
+
+// WithRelayLanes publishes events through n lanes at once, 1 by default.
+// The events of an aggregate always go through the same lane, see Relay.
+func WithRelayLanes(n int) OutboxRelayOption {
+	return func(r *OutboxRelay) {
+		if n > 0 {
+			r.lanes = n
+		}
+	}
+}
+
+func NewOutboxRelay(repo domain.OutboxRepository, publisher Publisher, log *logger.Logger, opts ...OutboxRelayOption) *OutboxRelay {
+	r := &OutboxRelay{
+		repo:      repo,
+		publisher: publisher,
+		log:       log,
+		lanes:     1,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+// Relay publishes pending events. They are spread over the lanes by
+// aggregate, and the lanes publish at once. Each lane publishes its events
+// in creation order and stops at its first failure, so that later events
+// are not delivered ahead of earlier ones, while the other lanes carry on.
+// The events of an aggregate are thus published in sequence, the order of
+// events of different aggregates is unspecified. Relay returns how many
+// events were published, and the failures of the lanes.
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	events, err := r.repo.ListPending(ctx, outboxBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	lanes := make([][]*domain.OutboxEvent, r.lanes)
+	for _, event := range events {
+		lane := messaging.Lane(event.AggregateID, r.lanes)
+		lanes[lane] = append(lanes[lane], event)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		published atomic.Int64
+		errs      = make([]error, len(lanes))
+	)
+	for i, lane := range lanes {
+		if len(lane) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			n, err := r.relayLane(ctx, lane)
+			published.Add(int64(n))
+			errs[i] = err
+		}()
+	}
+	wg.Wait()
+
+	return int(published.Load()), errors.Join(errs...)
+}
+
+// relayLane publishes the events of a lane in order, up to the first failure
+func (r *OutboxRelay) relayLane(ctx context.Context, events []*domain.OutboxEvent) (int, error) {
+	for i, event := range events {
+		revealed, err := event.Revealed()
+		if err != nil {
+			return i, err
+		}
+		if err := r.publisher.Publish(ctx, revealed); err != nil {
+			r.log.Error("Failed to publish outbox event", err, map[string]interface{}{
+				"event_id":      event.ID,
+				"event_type":    event.Type,
+				"aggregate_id":  event.AggregateID,
+				"aggregate_seq": event.AggregateSeq,
+			})
+			return i, err
+		}
+
+		if err := r.repo.MarkPublished(ctx, event.ID); err != nil {
+			return i, err
+		}
+	}
+
+	return len(events), nil
+}
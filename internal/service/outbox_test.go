@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// pendingOutbox lists its events as pending until they are marked published
+type pendingOutbox struct {
+	domain.OutboxRepository
+	mu        sync.Mutex
+	events    []*domain.OutboxEvent
+	published map[string]bool
+}
+
+func (o *pendingOutbox) ListPending(ctx context.Context, limit int) ([]*domain.OutboxEvent, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var pending []*domain.OutboxEvent
+	for _, event := range o.events {
+		if !o.published[event.ID] && len(pending) < limit {
+			pending = append(pending, event)
+		}
+	}
+	return pending, nil
+}
+
+func (o *pendingOutbox) MarkPublished(ctx context.Context, id string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.published[id] = true
+	return nil
+}
+
+// racingPublisher publishes through a broker that takes a while, as lanes
+// running at once, and fails the events listed in fail
+type racingPublisher struct {
+	mu        sync.Mutex
+	published []*domain.OutboxEvent
+	inFlight  int
+	// overlap is the most publishes seen in flight at once
+	overlap int
+	fail    map[string]error
+}
+
+func (p *racingPublisher) Publish(ctx context.Context, event *domain.OutboxEvent) error {
+	p.mu.Lock()
+	p.inFlight++
+	p.overlap = max(p.overlap, p.inFlight)
+	p.mu.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.inFlight--
+	if err := p.fail[event.ID]; err != nil {
+		return err
+	}
+	p.published = append(p.published, event)
+	return nil
+}
+
+// seqsOf returns the sequence numbers of the published events of each aggregate, in publish order
+func (p *racingPublisher) seqsOf() map[string][]int64 {
+	seqs := map[string][]int64{}
+	for _, event := range p.published {
+		seqs[event.AggregateID] = append(seqs[event.AggregateID], event.AggregateSeq)
+	}
+	return seqs
+}
+
+// interleavedEvents returns seqs events for each of users, in the order a
+// busy outbox would list them: the events of every user interleaved
+func interleavedEvents(users, seqs int) []*domain.OutboxEvent {
+	var events []*domain.OutboxEvent
+	for seq := 1; seq <= seqs; seq++ {
+		for user := 0; user < users; user++ {
+			events = append(events, &domain.OutboxEvent{
+				ID:           fmt.Sprintf("user-%d-%d", user, seq),
+				Type:         "user.updated",
+				AggregateID:  fmt.Sprintf("user-%d", user),
+				AggregateSeq: int64(seq),
+			})
+		}
+	}
+	return events
+}
+
+func TestOutboxRelay_Relay_PerAggregateOrder(t *testing.T) {
+	// Arrange
+	outbox := &pendingOutbox{events: interleavedEvents(8, 5), published: map[string]bool{}}
+	publisher := &racingPublisher{}
+	relay := NewOutboxRelay(outbox, publisher, logger.New(), WithRelayLanes(4))
+
+	// Act
+	n, err := relay.Relay(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, 40, n)
+	assert.Greater(t, publisher.overlap, 1, "lanes publish at once")
+	seqs := publisher.seqsOf()
+	require.Len(t, seqs, 8)
+	for user, got := range seqs {
+		assert.Equal(t, []int64{1, 2, 3, 4, 5}, got, "events of %s are published in sequence", user)
+	}
+}
+
+func TestOutboxRelay_Relay_FailureStopsItsLane(t *testing.T) {
+	// Arrange: the third event of user-0 fails to publish
+	outbox := &pendingOutbox{events: interleavedEvents(8, 5), published: map[string]bool{}}
+	failure := errors.New("broker unavailable")
+	publisher := &racingPublisher{fail: map[string]error{"user-0-3": failure}}
+	relay := NewOutboxRelay(outbox, publisher, logger.New(), WithRelayLanes(4))
+	blocked := messaging.Lane("user-0", 4)
+
+	// Act
+	n, err := relay.Relay(context.Background())
+
+	// Assert
+	assert.ErrorIs(t, err, failure)
+	seqs := publisher.seqsOf()
+	assert.Equal(t, []int64{1, 2}, seqs["user-0"], "later events of the user wait for the failed one")
+	published := 0
+	for user, got := range seqs {
+		published += len(got)
+		if user == "user-0" || messaging.Lane(user, 4) == blocked {
+			continue
+		}
+		assert.Equal(t, []int64{1, 2, 3, 4, 5}, got, "the other lanes carry on")
+	}
+	assert.Equal(t, published, n)
+
+	// Act: the next run resumes from the failed event, in sequence
+	delete(publisher.fail, "user-0-3")
+	_, err = relay.Relay(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	for user, got := range publisher.seqsOf() {
+		assert.True(t, slices.IsSorted(got), "events of %s are published in sequence", user)
+		assert.Len(t, got, 5)
+	}
+}
+
+func TestOutboxRelay_Relay_WithoutAggregate(t *testing.T) {
+	// Arrange: events without an aggregate keep the creation order
+	events := []*domain.OutboxEvent{{ID: "job-1"}, {ID: "job-2"}, {ID: "job-3"}}
+	outbox := &pendingOutbox{events: events, published: map[string]bool{}}
+	publisher := &racingPublisher{fail: map[string]error{"job-2": errors.New("broker unavailable")}}
+	relay := NewOutboxRelay(outbox, publisher, logger.New(), WithRelayLanes(4))
+
+	// Act
+	n, err := relay.Relay(context.Background())
+
+	// Assert
+	assert.Error(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, []*domain.OutboxEvent{events[0]}, publisher.published)
+}
+
+func TestOutboxRelay_Relay_RevealsSecret(t *testing.T) {
+	// Arrange
+	event := &domain.OutboxEvent{
+		ID:      "e-1",
+		Type:    domain.EventEmailChangeRequested,
+		Payload: []byte(`{"user_id":"user-1"}`),
+		Secret:  []byte(`{"token":"t0k3n"}`),
+	}
+	outbox := &pendingOutbox{events: []*domain.OutboxEvent{event}, published: map[string]bool{}}
+	publisher := &racingPublisher{}
+	relay := NewOutboxRelay(outbox, publisher, logger.New())
+
+	// Act
+	_, err := relay.Relay(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	require.Len(t, publisher.published, 1)
+	assert.JSONEq(t, `{"user_id":"user-1","token":"t0k3n"}`, string(publisher.published[0].Payload))
+	assert.Empty(t, publisher.published[0].Secret)
+	assert.JSONEq(t, `{"user_id":"user-1"}`, string(event.Payload), "the stored event keeps the secret apart")
+}
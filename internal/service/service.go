@@ -1,28 +1,94 @@
 package service
 
 import (
+	"time"
+
 	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
 	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/pubsub"
+	"github.com/romanitalian/carch-go/internal/pkg/staging"
 )
 
 type Deps struct {
 	Repos        *Repositories
 	MessageQueue interface{}
 	Logger       *logger.Logger
+	UserOptions  []UserOption
+	// InstanceStaleAfter is how long an instance without a heartbeat is considered live
+	InstanceStaleAfter time.Duration
+	// JobEvents receives the IDs of changed jobs, see NewJobService
+	JobEvents  *pubsub.Broker
+	JobOptions []JobOption
+	// BulkDeleteOptions set the safety ceiling and the batch size of bulk deletes
+	BulkDeleteOptions []BulkDeleteOption
+	// ImportStore stages the files of user imports, see NewUserImportService
+	ImportStore   *staging.Store
+	ImportOptions []UserImportOption
+	// AuditPartitionsAhead is how many months of audit log partitions are created in advance
+	AuditPartitionsAhead int
+	// AuditRetention is how long audit entries are kept, 0 keeps them forever
+	AuditRetention time.Duration
+	// EventArchiveRetention is how long relayed events are kept for replays, 0 keeps them forever
+	EventArchiveRetention time.Duration
+	// Publisher hands verified webhooks to the worker
+	Publisher        Publisher
+	WebhookProviders []domain.WebhookProvider
+	// WebhookReplayWindow is how far a webhook's timestamp may be from now
+	WebhookReplayWindow time.Duration
+	// WebhookNonces holds the signatures of accepted webhooks, in memory when nil
+	WebhookNonces cache.Adder
+	// Generators set how services stamp and identify records, the system
+	// clock and random UUIDs by default
+	Generators []generate.Option
 }
 
 type Repositories struct {
-	User domain.UserRepository
+	User     domain.UserRepository
+	UserBulk domain.UserBulkRepository
+	// Outbox records the retries of failed user deletion hooks
+	Outbox domain.OutboxRepository
+	// Tx runs user deletions and their hooks in one transaction
+	Tx       domain.Transactor
+	Instance domain.InstanceRepository
+	Job      domain.JobRepository
+	Audit    domain.AuditRepository
+	Events   domain.EventArchiveRepository
 }
 
 type Services struct {
-	User UserServiceInterface
-	Log  *logger.Logger
+	User       UserServiceInterface
+	Instance   InstanceServiceInterface
+	Job        JobServiceInterface
+	BulkDelete BulkDeleteServiceInterface
+	Import     UserImportServiceInterface
+	Audit      AuditServiceInterface
+	Webhook    WebhookServiceInterface
+	Events     EventServiceInterface
+	// UserDeletion is where components register their cleanups of deleted
+	// users, run by User.Delete and BulkDelete
+	UserDeletion *UserDeletion
+	Log          *logger.Logger
 }
 
 func NewServices(deps Deps) *Services {
+	deletion := NewUserDeletion(deps.Repos.User, deps.Repos.Tx, deps.Repos.Outbox, deps.Logger, deps.Generators...)
+	jobs := NewJobService(deps.Repos.Job, deps.JobEvents, deps.Logger, deps.JobOptions...)
+	bulkDeleteOptions := append([]BulkDeleteOption{WithBulkDeleteGenerators(deps.Generators...), WithBulkUserDeletion(deletion)}, deps.BulkDeleteOptions...)
+	importOptions := append([]UserImportOption{WithImportGenerators(deps.Generators...)}, deps.ImportOptions...)
+	users := NewUserService(deps.Repos.User, deps.Logger, append([]UserOption{WithUserGenerators(deps.Generators...), WithUserDeletion(deletion)}, deps.UserOptions...)...)
+
 	return &Services{
-		User: NewUserService(deps.Repos.User, deps.Logger),
-		Log:  deps.Logger,
+		User:         users,
+		Instance:     NewInstanceService(deps.Repos.Instance, deps.Logger, deps.InstanceStaleAfter, deps.Generators...),
+		Job:          jobs,
+		BulkDelete:   NewBulkDeleteService(deps.Repos.User, deps.Repos.UserBulk, jobs, deps.Logger, bulkDeleteOptions...),
+		Import:       NewUserImportService(users, jobs, deps.ImportStore, deps.Logger, importOptions...),
+		Audit:        NewAuditService(deps.Repos.Audit, deps.Logger, deps.AuditPartitionsAhead, deps.AuditRetention),
+		Webhook:      NewWebhookService(deps.WebhookProviders, deps.WebhookReplayWindow, deps.Publisher, deps.WebhookNonces, deps.Repos.Audit, deps.Logger, deps.Generators...),
+		Events:       NewEventService(deps.Repos.Events, deps.Logger, deps.EventArchiveRetention, deps.Generators...),
+		UserDeletion: deletion,
+		Log:          deps.Logger,
 	}
 }
@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/pkg/sanitize"
+)
+
+// Defaults of ShadowUserService
+const (
+	DefaultShadowRate   = 0.01
+	DefaultShadowBudget = 2 * time.Second
+)
+
+// Results of a shadowed read, see metrics.UserShadowResults
+const (
+	shadowMatch    = "match"
+	shadowMismatch = "mismatch"
+	shadowError    = "error"
+	shadowTimeout  = "timeout"
+)
+
+// maxShadowIDs bounds the IDs logged for each side of a difference
+const maxShadowIDs = 20
+
+// ShadowUserService runs a sample of the reads of a user service against a
+// candidate implementation as well, to validate it on production traffic
+// before switching to it. The legacy result is always served and the
+// request never waits for the candidate. Results are compared once both are
+// known, differences are logged and counted in metrics.UserShadowResults.
+// Writes only go to the legacy service.
+type ShadowUserService struct {
+	UserServiceInterface
+	candidate UserServiceInterface
+	log       *logger.Logger
+
+	rate   float64
+	budget time.Duration
+	// sample returns a number in [0, 1), it is replaced in tests
+	sample func() float64
+	// pending tracks running comparisons
+	pending sync.WaitGroup
+}
+
+// ShadowOption is a function that configures a ShadowUserService
+type ShadowOption func(*ShadowUserService)
+
+// WithShadowRate sets the share of reads, from 0 to 1, also run against the candidate
+func WithShadowRate(rate float64) ShadowOption {
+	return func(s *ShadowUserService) {
+		if rate >= 0 && rate <= 1 {
+			s.rate = rate
+		}
+	}
+}
+
+// WithShadowBudget bounds how long the candidate may take. It runs apart
+// from the request, so that a slow candidate delays no response, and is
+// abandoned and counted as a timeout past the budget.
+func WithShadowBudget(d time.Duration) ShadowOption {
+	return func(s *ShadowUserService) {
+		if d > 0 {
+			s.budget = d
+		}
+	}
+}
+
+// NewShadowUserService serves reads from legacy and shadows a sample of them to candidate
+func NewShadowUserService(legacy, candidate UserServiceInterface, log *logger.Logger, opts ...ShadowOption) *ShadowUserService {
+	s := &ShadowUserService{
+		UserServiceInterface: legacy,
+		candidate:            candidate,
+		log:                  log,
+		rate:                 DefaultShadowRate,
+		budget:               DefaultShadowBudget,
+		sample:               rand.Float64,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+func (s *ShadowUserService) GetByID(ctx context.Context, id string) (*domain.User, error) {
+	if !s.sampled() {
+		return s.UserServiceInterface.GetByID(ctx, id)
+	}
+
+	shadow := s.start(ctx, func(ctx context.Context) ([]string, error) {
+		return foundIDs(s.candidate.GetByID(ctx, id))
+	})
+	started := time.Now()
+	user, err := s.UserServiceInterface.GetByID(ctx, id)
+	ids, legacyErr := foundIDs(user, err)
+	s.compare("GetByID", shadow, ids, legacyErr, time.Since(started), map[string]interface{}{"id": id})
+
+	return user, err
+}
+
+func (s *ShadowUserService) GetByIDs(ctx context.Context, ids []string) (*domain.UserLookup, error) {
+	if !s.sampled() {
+		return s.UserServiceInterface.GetByIDs(ctx, ids)
+	}
+
+	shadow := s.start(ctx, func(ctx context.Context) ([]string, error) {
+		lookup, err := s.candidate.GetByIDs(ctx, ids)
+		return lookupIDs(lookup), err
+	})
+	started := time.Now()
+	lookup, err := s.UserServiceInterface.GetByIDs(ctx, ids)
+	s.compare("GetByIDs", shadow, lookupIDs(lookup), err, time.Since(started), map[string]interface{}{"ids": len(ids)})
+
+	return lookup, err
+}
+
+func (s *ShadowUserService) List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	if !s.sampled() {
+		return s.UserServiceInterface.List(ctx, opts)
+	}
+
+	shadow := s.start(ctx, func(ctx context.Context) ([]string, error) {
+		page, err := s.candidate.List(ctx, opts)
+		return pageIDs(page), err
+	})
+	started := time.Now()
+	page, err := s.UserServiceInterface.List(ctx, opts)
+	s.compare("List", shadow, pageIDs(page), err, time.Since(started), listQueryFields(opts))
+
+	return page, err
+}
+
+func (s *ShadowUserService) sampled() bool {
+	return s.rate > 0 && s.sample() < s.rate
+}
+
+// shadowCall is the candidate's side of a shadowed read
+type shadowCall struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	result  chan shadowResult
+	started time.Time
+}
+
+type shadowResult struct {
+	ids []string
+	err error
+	// elapsed is how long the candidate took
+	elapsed time.Duration
+}
+
+// start runs the candidate next to the legacy call. It is detached from
+// the request's cancellation, which comes with the response, and bounded by
+// the budget instead.
+func (s *ShadowUserService) start(ctx context.Context, call func(ctx context.Context) ([]string, error)) *shadowCall {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.budget)
+	c := &shadowCall{ctx: ctx, cancel: cancel, result: make(chan shadowResult, 1), started: time.Now()}
+
+	go func() {
+		ids, err := call(ctx)
+		c.result <- shadowResult{ids: ids, err: err, elapsed: time.Since(c.started)}
+	}()
+
+	return c
+}
+
+// compare reports how the candidate's result differs from the legacy one,
+// in the background. Reads the legacy service failed are not compared.
+func (s *ShadowUserService) compare(method string, c *shadowCall, ids []string, err error, elapsed time.Duration, query map[string]interface{}) {
+	if err != nil {
+		c.cancel()
+		return
+	}
+
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		defer c.cancel()
+
+		fields := map[string]interface{}{"method": method, "query": query}
+		report := func(result, msg string) {
+			metrics.UserShadowResults.WithLabelValues(method, result).Inc()
+			s.log.Warn(msg, fields)
+		}
+
+		// A result that came in while the legacy read outlasted the budget counts
+		var r shadowResult
+		select {
+		case r = <-c.result:
+		default:
+			select {
+			case r = <-c.result:
+			case <-c.ctx.Done():
+				// The candidate may not honor its context, it is not waited for
+				r.err = c.ctx.Err()
+			}
+		}
+		switch {
+		case errors.Is(r.err, context.DeadlineExceeded):
+			fields["budget_ms"] = s.budget.Milliseconds()
+			report(shadowTimeout, "Shadow read exceeded its budget")
+			return
+		case r.err != nil:
+			fields["error"] = r.err.Error()
+			report(shadowError, "Shadow read failed")
+			return
+		}
+
+		delta := r.elapsed - elapsed
+		metrics.UserShadowLatencyDelta.WithLabelValues(method).Observe(delta.Seconds())
+
+		diff := diffIDs(ids, r.ids)
+		if diff.equal() {
+			metrics.UserShadowResults.WithLabelValues(method, shadowMatch).Inc()
+			return
+		}
+		fields["legacy_count"] = len(ids)
+		fields["candidate_count"] = len(r.ids)
+		fields["missing_ids"] = capIDs(diff.missing)
+		fields["extra_ids"] = capIDs(diff.extra)
+		fields["same_order"] = diff.sameOrder
+		fields["latency_delta_ms"] = delta.Milliseconds()
+		report(shadowMismatch, "Shadow read differs")
+	}()
+}
+
+// shadowDiff is how the candidate's IDs differ from the legacy ones
+type shadowDiff struct {
+	// missing are legacy IDs the candidate did not return, extra the reverse
+	missing, extra []string
+	sameOrder      bool
+}
+
+func (d shadowDiff) equal() bool {
+	return len(d.missing) == 0 && len(d.extra) == 0 && d.sameOrder
+}
+
+func diffIDs(legacy, candidate []string) shadowDiff {
+	d := shadowDiff{sameOrder: slices.Equal(legacy, candidate)}
+	if d.sameOrder {
+		return d
+	}
+
+	inLegacy := make(map[string]struct{}, len(legacy))
+	for _, id := range legacy {
+		inLegacy[id] = struct{}{}
+	}
+	inCandidate := make(map[string]struct{}, len(candidate))
+	for _, id := range candidate {
+		inCandidate[id] = struct{}{}
+		if _, ok := inLegacy[id]; !ok {
+			d.extra = append(d.extra, id)
+		}
+	}
+	for _, id := range legacy {
+		if _, ok := inCandidate[id]; !ok {
+			d.missing = append(d.missing, id)
+		}
+	}
+	return d
+}
+
+func capIDs(ids []string) []string {
+	if len(ids) > maxShadowIDs {
+		return ids[:maxShadowIDs]
+	}
+	return ids
+}
+
+func userIDs(users []*domain.User) []string {
+	ids := make([]string, 0, len(users))
+	for _, u := range users {
+		ids = append(ids, u.ID)
+	}
+	return ids
+}
+
+// pageIDs returns the IDs of the users of a page, in order
+func pageIDs(page *domain.UserPage) []string {
+	if page == nil {
+		return nil
+	}
+	return userIDs(page.Users)
+}
+
+// foundIDs turns a lookup by ID into the IDs found, a missing user being a
+// result to compare rather than a failure
+func foundIDs(user *domain.User, err error) ([]string, error) {
+	if errors.Is(err, domain.ErrUserNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []string{user.ID}, nil
+}
+
+// lookupIDs returns the IDs found in sorted order, the map having none
+func lookupIDs(lookup *domain.UserLookup) []string {
+	if lookup == nil {
+		return nil
+	}
+	ids := make([]string, 0, len(lookup.Found))
+	for id := range lookup.Found {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// listQueryFields describes a listing for logs, with the values users
+// searched for redacted
+func listQueryFields(opts domain.UserListOptions) map[string]interface{} {
+	fields := map[string]interface{}{
+		"sort":      opts.SortBy,
+		"sort_dir":  opts.SortDir,
+		"collation": opts.Collation,
+		"limit":     opts.Limit,
+		"offset":    opts.Offset,
+	}
+	if opts.Filter.EmailDomain != "" {
+		fields["email_domain"] = sanitize.Redacted
+	}
+	if opts.Filter.NamePrefix != "" {
+		fields["name_prefix"] = sanitize.Redacted
+	}
+	if !opts.Filter.CreatedAfter.IsZero() {
+		fields["created_after"] = opts.Filter.CreatedAfter
+	}
+	if !opts.Filter.CreatedBefore.IsZero() {
+		fields["created_before"] = opts.Filter.CreatedBefore
+	}
+	if opts.Filter.Where != nil {
+		fields["where"] = sanitize.Redacted
+	}
+	return fields
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// listingUsers is a user service whose reads return fixed users
+type listingUsers struct {
+	UserServiceInterface
+	ids []string
+	err error
+	// block, when set, holds reads until it is closed, whatever their context
+	block chan struct{}
+	calls atomic.Int32
+}
+
+func (u *listingUsers) List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	u.calls.Add(1)
+	if u.block != nil {
+		<-u.block
+	}
+	if u.err != nil {
+		return nil, u.err
+	}
+	users := make([]*domain.User, 0, len(u.ids))
+	for _, id := range u.ids {
+		users = append(users, &domain.User{ID: id})
+	}
+	return &domain.UserPage{Users: users, Total: int64(len(users))}, nil
+}
+
+func (u *listingUsers) GetByID(ctx context.Context, id string) (*domain.User, error) {
+	for _, known := range u.ids {
+		if known == id {
+			return &domain.User{ID: id}, nil
+		}
+	}
+	return nil, domain.ErrUserNotFound
+}
+
+// newShadow shadows every read of legacy to candidate and returns the logs
+func newShadow(legacy, candidate UserServiceInterface, opts ...ShadowOption) (*ShadowUserService, *bytes.Buffer) {
+	var logs bytes.Buffer
+	s := NewShadowUserService(legacy, candidate, logger.New(logger.WithOutput(&logs)), append([]ShadowOption{WithShadowRate(1)}, opts...)...)
+	return s, &logs
+}
+
+func shadowResults(method, result string) float64 {
+	return testutil.ToFloat64(metrics.UserShadowResults.WithLabelValues(method, result))
+}
+
+func TestShadowUserService_List(t *testing.T) {
+	tests := []struct {
+		name       string
+		legacy     []string
+		candidate  []string
+		wantResult string
+		wantLogged []string
+	}{
+		{name: "same users", legacy: []string{"a", "b"}, candidate: []string{"a", "b"}, wantResult: shadowMatch},
+		{
+			name: "missing and extra users", legacy: []string{"a", "b", "c"}, candidate: []string{"a", "d"}, wantResult: shadowMismatch,
+			wantLogged: []string{`"legacy_count":3`, `"candidate_count":2`, `"missing_ids":["b","c"]`, `"extra_ids":["d"]`},
+		},
+		{
+			name: "other order", legacy: []string{"a", "b"}, candidate: []string{"b", "a"}, wantResult: shadowMismatch,
+			wantLogged: []string{`"same_order":false`, `"missing_ids":null`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			legacy := &listingUsers{ids: tt.legacy}
+			s, logs := newShadow(legacy, &listingUsers{ids: tt.candidate})
+			before := shadowResults("List", tt.wantResult)
+			opts := domain.UserListOptions{PageRequest: domain.PageRequest{SortBy: domain.UserSortName}, Filter: domain.UserFilter{EmailDomain: "example.com"}}
+
+			// Act
+			page, err := s.List(context.Background(), opts)
+			s.pending.Wait()
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.legacy, pageIDs(page), "the legacy result is served")
+			assert.Equal(t, before+1, shadowResults("List", tt.wantResult))
+			for _, want := range tt.wantLogged {
+				assert.Contains(t, logs.String(), want)
+			}
+			if tt.wantResult == shadowMismatch {
+				assert.Contains(t, logs.String(), `"email_domain":"REDACTED"`)
+				assert.NotContains(t, logs.String(), "example.com")
+			}
+		})
+	}
+}
+
+func TestShadowUserService_GetByID(t *testing.T) {
+	// Arrange: the candidate does not find the user
+	s, logs := newShadow(&listingUsers{ids: []string{"a"}}, &listingUsers{})
+	before := shadowResults("GetByID", shadowMismatch)
+
+	// Act
+	user, err := s.GetByID(context.Background(), "a")
+	s.pending.Wait()
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "a", user.ID)
+	assert.Equal(t, before+1, shadowResults("GetByID", shadowMismatch))
+	assert.Contains(t, logs.String(), `"missing_ids":["a"]`)
+}
+
+func TestShadowUserService_CandidateFails(t *testing.T) {
+	// Arrange
+	s, logs := newShadow(&listingUsers{ids: []string{"a"}}, &listingUsers{err: errors.New("relation users_v2 does not exist")})
+	before := shadowResults("List", shadowError)
+
+	// Act
+	page, err := s.List(context.Background(), domain.UserListOptions{})
+	s.pending.Wait()
+
+	// Assert
+	require.NoError(t, err)
+	assert.Len(t, page.Users, 1)
+	assert.Equal(t, before+1, shadowResults("List", shadowError))
+	assert.Contains(t, logs.String(), "Shadow read failed")
+}
+
+func TestShadowUserService_LegacyFails(t *testing.T) {
+	// Arrange
+	legacyErr := errors.New("connection reset")
+	s, logs := newShadow(&listingUsers{err: legacyErr}, &listingUsers{ids: []string{"a"}})
+
+	// Act
+	_, err := s.List(context.Background(), domain.UserListOptions{})
+	s.pending.Wait()
+
+	// Assert
+	assert.ErrorIs(t, err, legacyErr)
+	assert.NotContains(t, logs.String(), "Shadow read", "failed legacy reads are not compared")
+}
+
+func TestShadowUserService_LatencyBudget(t *testing.T) {
+	// Arrange: the candidate ignores its context and never answers in time
+	candidate := &listingUsers{ids: []string{"a"}, block: make(chan struct{})}
+	defer close(candidate.block)
+	s, logs := newShadow(&listingUsers{ids: []string{"a"}}, candidate, WithShadowBudget(10*time.Millisecond))
+	before := shadowResults("List", shadowTimeout)
+
+	// Act
+	page, err := s.List(context.Background(), domain.UserListOptions{})
+
+	// Assert: the response did not wait for the blocked candidate
+	require.NoError(t, err)
+	assert.Len(t, page.Users, 1)
+
+	s.pending.Wait()
+	assert.Equal(t, before+1, shadowResults("List", shadowTimeout))
+	assert.Contains(t, logs.String(), "Shadow read exceeded its budget")
+}
+
+func TestShadowUserService_Sampling(t *testing.T) {
+	// Arrange
+	candidate := &listingUsers{}
+	s, _ := newShadow(&listingUsers{}, candidate, WithShadowRate(0.25))
+	samples := []float64{0.1, 0.5, 0.9, 0.2}
+	s.sample = func() float64 {
+		v := samples[0]
+		samples = samples[1:]
+		return v
+	}
+
+	// Act
+	for range 4 {
+		_, err := s.List(context.Background(), domain.UserListOptions{})
+		require.NoError(t, err)
+	}
+	s.pending.Wait()
+
+	// Assert
+	assert.Equal(t, int32(2), candidate.calls.Load())
+}
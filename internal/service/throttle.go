@@ -0,0 +1,97 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+)
+
+// mutationThrottle caps how many changes a single user takes per window
+type mutationThrottle struct {
+	counters cache.Counter
+	audit    domain.AuditRepository
+	limit    int64
+	window   time.Duration
+}
+
+type bypassThrottleKey struct{}
+
+// BypassThrottle marks ctx as made by an administrator allowed to change
+// users past the mutation throttle
+func BypassThrottle(ctx context.Context) context.Context {
+	return context.WithValue(ctx, bypassThrottleKey{}, true)
+}
+
+// ThrottleBypassed reports whether ctx lifts the mutation throttle
+func ThrottleBypassed(ctx context.Context) bool {
+	bypass, _ := ctx.Value(bypassThrottleKey{}).(bool)
+	return bypass
+}
+
+// WithMutationThrottle rejects Update and Patch with domain.ErrTooManyChanges
+// once a user was changed more than limit times in the current window.
+// Counters live in counters. Rejections are recorded in audit once per user
+// and window, not per attempt. A limit of 0 disables the throttle.
+func WithMutationThrottle(counters cache.Counter, audit domain.AuditRepository, limit int, window time.Duration) UserOption {
+	return func(s *UserService) {
+		if counters == nil || limit <= 0 || window <= 0 {
+			return
+		}
+		s.throttle = &mutationThrottle{counters: counters, audit: audit, limit: int64(limit), window: window}
+	}
+}
+
+// throttleMutation counts a change to the user and fails when it is past the limit
+func (s *UserService) throttleMutation(ctx context.Context, id string) error {
+	if s.throttle == nil || ThrottleBypassed(ctx) {
+		return nil
+	}
+
+	count, err := s.throttle.counters.Incr(ctx, "mutations:user:"+id, s.throttle.window)
+	if err != nil {
+		// Failing open keeps users editable when the counter store is not
+		s.log.Error("Failed to count user mutation", err, map[string]interface{}{"user_id": id})
+		return nil
+	}
+	if count <= s.throttle.limit {
+		return nil
+	}
+
+	// Only the first rejection of a window is reported, a runaway client
+	// would otherwise bloat the audit log the throttle protects
+	if count == s.throttle.limit+1 {
+		s.log.Warn("Throttling user mutations", map[string]interface{}{
+			"user_id": id,
+			"limit":   s.throttle.limit,
+			"window":  s.throttle.window.String(),
+		})
+		s.recordThrottled(ctx, id)
+	}
+	return domain.ErrTooManyChanges
+}
+
+// recordThrottled records in the audit log that changes to the user are rejected
+func (s *UserService) recordThrottled(ctx context.Context, id string) {
+	if s.throttle.audit == nil {
+		return
+	}
+
+	details, _ := json.Marshal(map[string]interface{}{
+		"limit":  s.throttle.limit,
+		"window": s.throttle.window.String(),
+	})
+	entry := &domain.AuditEntry{
+		Action:    domain.AuditUserMutationsThrottled,
+		Entity:    domain.AuditEntityUser,
+		EntityID:  id,
+		ActorID:   ActorOf(ctx),
+		Details:   details,
+		CreatedAt: s.gen.Clock.Now(),
+	}
+	if err := s.throttle.audit.Create(ctx, entry); err != nil {
+		s.log.Error("Failed to record throttled user mutations", err, map[string]interface{}{"user_id": id})
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// windowCounters counts in fixed windows on a clock advanced by hand
+type windowCounters struct {
+	now     time.Time
+	counts  map[string]int64
+	resetAt map[string]time.Time
+}
+
+func newWindowCounters() *windowCounters {
+	return &windowCounters{now: time.Now(), counts: map[string]int64{}, resetAt: map[string]time.Time{}}
+}
+
+func (c *windowCounters) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	if !c.now.Before(c.resetAt[key]) {
+		c.counts[key] = 0
+		c.resetAt[key] = c.now.Add(window)
+	}
+	c.counts[key]++
+	return c.counts[key], nil
+}
+
+func newThrottledUserService(limit int) (*UserService, *MockUserRepository, *windowCounters, *memoryAuditRepository) {
+	repo := new(MockUserRepository)
+	repo.On("Update", mock.Anything, mock.Anything).Return(nil)
+	repo.On("GetByID", mock.Anything, mock.Anything).Return(&domain.User{ID: "u-1", Name: "Ann"}, nil)
+
+	counters := newWindowCounters()
+	audit := newMemoryAuditRepository()
+	svc := NewUserService(repo, logger.New(), WithMutationThrottle(counters, audit, limit, time.Hour))
+	return svc, repo, counters, audit
+}
+
+// mutate updates or patches the user and returns the error
+func mutate(ctx context.Context, svc *UserService, id string, patch bool) error {
+	if patch {
+		name := "Bob"
+		_, err := svc.Patch(ctx, id, domain.UserChanges{Name: &name})
+		return err
+	}
+	return svc.Update(ctx, &domain.User{ID: id, Name: "Bob"})
+}
+
+func TestUserService_MutationThrottle(t *testing.T) {
+	// Arrange
+	svc, repo, _, audit := newThrottledUserService(3)
+	ctx := WithActor(context.Background(), "client:203.0.113.7")
+
+	// Act: updates and patches count alike
+	var errs []error
+	for i := range 6 {
+		errs = append(errs, mutate(ctx, svc, "u-1", i%2 == 1))
+	}
+	other := mutate(ctx, svc, "u-2", false)
+
+	// Assert
+	assert.Equal(t, []error{nil, nil, nil, domain.ErrTooManyChanges, domain.ErrTooManyChanges, domain.ErrTooManyChanges}, errs)
+	assert.NoError(t, other, "users are throttled on their own")
+	repo.AssertNumberOfCalls(t, "Update", 4)
+
+	require.Len(t, audit.entries, 1, "rejections are recorded once per window")
+	entry := audit.entries[0]
+	assert.Equal(t, domain.AuditUserMutationsThrottled, entry.Action)
+	assert.Equal(t, domain.AuditEntityUser, entry.Entity)
+	assert.Equal(t, "u-1", entry.EntityID)
+	assert.Equal(t, "client:203.0.113.7", entry.ActorID)
+	assert.JSONEq(t, `{"limit": 3, "window": "1h0m0s"}`, string(entry.Details))
+}
+
+func TestUserService_MutationThrottle_WindowReset(t *testing.T) {
+	// Arrange
+	svc, _, counters, audit := newThrottledUserService(1)
+	ctx := context.Background()
+	require.NoError(t, mutate(ctx, svc, "u-1", false))
+	require.ErrorIs(t, mutate(ctx, svc, "u-1", false), domain.ErrTooManyChanges)
+
+	// Act
+	counters.now = counters.now.Add(time.Hour)
+	afterReset := mutate(ctx, svc, "u-1", false)
+	throttledAgain := mutate(ctx, svc, "u-1", false)
+
+	// Assert
+	assert.NoError(t, afterReset)
+	assert.ErrorIs(t, throttledAgain, domain.ErrTooManyChanges)
+	assert.Len(t, audit.entries, 2, "each window records its first rejection")
+}
+
+func TestUserService_MutationThrottle_Override(t *testing.T) {
+	// Arrange
+	svc, repo, _, audit := newThrottledUserService(1)
+	ctx := context.Background()
+	require.NoError(t, mutate(ctx, svc, "u-1", false))
+
+	// Act
+	var errs []error
+	for i := range 3 {
+		errs = append(errs, mutate(BypassThrottle(ctx), svc, "u-1", i%2 == 1))
+	}
+
+	// Assert
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	repo.AssertNumberOfCalls(t, "Update", 4)
+	assert.Empty(t, audit.entries)
+}
@@ -2,45 +2,398 @@ package service
 
 import (
 	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 
 	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
 	"github.com/romanitalian/carch-go/internal/pkg/logger"
 )
 
+// MaxLookupIDs is the largest number of distinct IDs accepted by a single GetByIDs call
+const MaxLookupIDs = 100
+
 type UserService struct {
 	repo domain.UserRepository
 	log  *logger.Logger
+
+	emailChangeConfirmation bool
+	emailChangeTTL          time.Duration
+
+	defaultCollation string
+	collations       sync.Map
+	maxPageLimit     int
+
+	throttle *mutationThrottle
+	seen     *seenTracking
+	hasher   PasswordHasher
+	hooks    []UserHook
+	deletion *UserDeletion
+	metadata *metadataEncryption
+	gen      generate.Generators
+}
+
+// UserOption is a function that configures a UserService
+type UserOption func(*UserService)
+
+// WithEmailChangeConfirmation makes email changes go through the confirmation
+// flow only; Update then rejects attempts to change the email directly
+func WithEmailChangeConfirmation(ttl time.Duration) UserOption {
+	return func(s *UserService) {
+		s.emailChangeConfirmation = true
+		if ttl > 0 {
+			s.emailChangeTTL = ttl
+		}
+	}
+}
+
+// WithDefaultCollation sets the locale used to order names when a request
+// sorts by name without naming one
+func WithDefaultCollation(locale string) UserOption {
+	return func(s *UserService) {
+		s.defaultCollation = locale
+	}
+}
+
+// WithMaxPageLimit bounds the pages of List to limit users, 0 keeps
+// domain.DefaultMaxPageLimit
+func WithMaxPageLimit(limit int) UserOption {
+	return func(s *UserService) {
+		if limit > 0 {
+			s.maxPageLimit = limit
+		}
+	}
+}
+
+// WithUserDeletion deletes users through d, running its deletion hooks.
+// Without it users are deleted with no hook.
+func WithUserDeletion(d *UserDeletion) UserOption {
+	return func(s *UserService) {
+		s.deletion = d
+	}
+}
+
+// WithUserGenerators sets how email change requests, outbox events and
+// audit entries are stamped and identified
+func WithUserGenerators(opts ...generate.Option) UserOption {
+	return func(s *UserService) {
+		s.gen = generate.New(opts...)
+	}
 }
 
-func NewUserService(repo domain.UserRepository, log *logger.Logger) *UserService {
-	return &UserService{
-		repo: repo,
-		log:  log,
+func NewUserService(repo domain.UserRepository, log *logger.Logger, opts ...UserOption) *UserService {
+	s := &UserService{
+		repo:           repo,
+		log:            log,
+		emailChangeTTL: defaultEmailChangeTTL,
+		maxPageLimit:   domain.DefaultMaxPageLimit,
+		gen:            generate.New(),
 	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.deletion == nil {
+		s.deletion = NewUserDeletion(repo, nil, nil, log)
+	}
+
+	return s
+}
+
+// logFor returns the logger of the request ctx belongs to, with its
+// request-scoped fields, or the logger of the service outside requests
+func (s *UserService) logFor(ctx context.Context) *logger.Logger {
+	return logger.FromContextOr(ctx, s.log)
 }
 
 func (s *UserService) Create(ctx context.Context, user *domain.User) error {
 	// Business logic and validation
-	s.log.Info("Creating user", map[string]interface{}{"user_id": user.ID})
-	return s.repo.Create(ctx, user)
+	s.logFor(ctx).Info("Creating user", map[string]interface{}{"user_id": user.ID})
+
+	if s.hasher != nil && user.Password != "" {
+		hash, err := s.hasher.Hash(user.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = hash
+	}
+
+	if err := s.sealMetadata(ctx, user); err != nil {
+		return err
+	}
+
+	if err := s.repo.Create(ctx, user); err != nil {
+		return err
+	}
+
+	s.notify(ctx, UserEvent{Type: UserCreated, UserID: user.ID, User: user})
+	return nil
 }
 
 func (s *UserService) GetByID(ctx context.Context, id string) (*domain.User, error) {
-	s.log.Info("Getting user by ID", map[string]interface{}{"user_id": id})
-	return s.repo.GetByID(ctx, id)
+	s.logFor(ctx).Info("Getting user by ID", map[string]interface{}{"user_id": id})
+
+	user, err := s.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	s.markSeen(ctx, user.ID)
+	s.openMetadata(ctx, user)
+	return user, nil
+}
+
+// GetByIDs resolves a batch of user IDs. Repeated IDs are looked up once and
+// IDs that are not valid UUIDs are reported as missing without hitting the database.
+// Results are keyed by the ID as the caller spelled it, every spelling of the
+// same UUID gets the result. MaxLookupIDs caps the distinct IDs.
+func (s *UserService) GetByIDs(ctx context.Context, ids []string) (*domain.UserLookup, error) {
+	// spellings maps the canonical form of each ID to the spellings the caller used
+	spellings := make(map[string][]string, len(ids))
+	query := make([]string, 0, len(ids))
+	var invalid []string
+	for _, id := range ids {
+		parsed, err := uuid.Parse(id)
+		if err != nil {
+			if _, ok := spellings[id]; !ok {
+				spellings[id] = []string{id}
+				invalid = append(invalid, id)
+			}
+			continue
+		}
+
+		canonical := parsed.String()
+		seen, ok := spellings[canonical]
+		if !ok {
+			query = append(query, canonical)
+		}
+		if !slices.Contains(seen, id) {
+			spellings[canonical] = append(seen, id)
+		}
+	}
+
+	if len(query)+len(invalid) > MaxLookupIDs {
+		return nil, domain.ErrTooManyIDs
+	}
+
+	lookup := &domain.UserLookup{
+		Found:   make(map[string]*domain.User, len(ids)),
+		Missing: append([]string{}, invalid...),
+	}
+
+	s.logFor(ctx).Info("Getting users by IDs", map[string]interface{}{"count": len(query)})
+
+	if len(query) > 0 {
+		users, err := s.repo.GetByIDs(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		s.openMetadata(ctx, users...)
+		for _, u := range users {
+			for _, id := range spellings[u.ID] {
+				lookup.Found[id] = u
+			}
+		}
+	}
+
+	for _, canonical := range query {
+		for _, id := range spellings[canonical] {
+			if _, ok := lookup.Found[id]; !ok {
+				lookup.Missing = append(lookup.Missing, id)
+			}
+		}
+	}
+
+	return lookup, nil
 }
 
 func (s *UserService) Update(ctx context.Context, user *domain.User) error {
-	s.log.Info("Updating user", map[string]interface{}{"user_id": user.ID})
-	return s.repo.Update(ctx, user)
+	s.logFor(ctx).Info("Updating user", map[string]interface{}{"user_id": user.ID})
+
+	if err := s.throttleMutation(ctx, user.ID); err != nil {
+		return err
+	}
+
+	if s.emailChangeConfirmation {
+		current, err := s.repo.GetByID(consistency.WithPrimary(ctx), user.ID)
+		if err != nil {
+			return err
+		}
+
+		if user.Email != "" && user.Email != current.Email {
+			return domain.ErrEmailChangeRequiresConfirm
+		}
+		user.Email = current.Email
+	}
+
+	if err := s.sealMetadata(ctx, user); err != nil {
+		return err
+	}
+
+	if err := s.repo.Update(ctx, user); err != nil {
+		return err
+	}
+
+	s.notify(ctx, UserEvent{Type: UserUpdated, UserID: user.ID, User: user})
+	return nil
+}
+
+// Patch applies a change set to the user and returns the updated user
+func (s *UserService) Patch(ctx context.Context, id string, changes domain.UserChanges) (*domain.User, error) {
+	s.logFor(ctx).Info("Patching user", map[string]interface{}{"user_id": id})
+
+	if err := s.throttleMutation(ctx, id); err != nil {
+		return nil, err
+	}
+
+	// Read-modify-write must start from the latest state, not a cached or replicated copy
+	user, err := s.repo.GetByID(consistency.WithPrimary(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+
+	current := user.Email
+	changes.Apply(user)
+
+	if s.emailChangeConfirmation && user.Email != current {
+		return nil, domain.ErrEmailChangeRequiresConfirm
+	}
+
+	if err := s.repo.Update(ctx, user); err != nil {
+		return nil, err
+	}
+
+	s.notify(ctx, UserEvent{Type: UserUpdated, UserID: user.ID, User: user})
+	return user, nil
 }
 
 func (s *UserService) Delete(ctx context.Context, id string) error {
-	s.log.Info("Deleting user", map[string]interface{}{"user_id": id})
-	return s.repo.Delete(ctx, id)
+	s.logFor(ctx).Info("Deleting user", map[string]interface{}{"user_id": id})
+
+	if err := s.deletion.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	s.notify(ctx, UserEvent{Type: UserDeleted, UserID: id})
+	return nil
+}
+
+// List returns the page of users ordered as requested, of at most the max
+// page limit. Sorting by name uses the default collation when none is given.
+// If the database lacks the collation, every user is fetched in the default
+// database order, re-sorted in memory and paged.
+func (s *UserService) List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	s.logFor(ctx).Info("Listing users", map[string]interface{}{
+		"sort":      opts.SortBy,
+		"sort_dir":  opts.SortDir,
+		"collation": opts.Collation,
+		"filter":    opts.Filter.String(),
+		"limit":     opts.Limit,
+		"offset":    opts.Offset,
+	})
+
+	var err error
+	if opts.PageRequest, err = opts.PageRequest.Validate(s.maxPageLimit, domain.UserSortFields...); err != nil {
+		return nil, err
+	}
+
+	page, err := s.list(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	s.openMetadata(ctx, page.Users...)
+	return page, nil
+}
+
+func (s *UserService) list(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	if opts.SortBy != domain.UserSortName {
+		return s.repo.List(ctx, opts)
+	}
+	if opts.Collation == "" {
+		opts.Collation = s.defaultCollation
+	}
+	if opts.Collation == "" {
+		return s.repo.List(ctx, opts)
+	}
+
+	exists, err := s.collationExists(ctx, opts.Collation)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return s.repo.List(ctx, opts)
+	}
+
+	s.logFor(ctx).Warn("Collation is not available in the database, sorting in memory", map[string]interface{}{"collation": opts.Collation})
+
+	locale, page := opts.Collation, opts.PageRequest
+	opts.Collation = ""
+	opts.Limit, opts.Offset = 0, 0
+	all, err := s.repo.List(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c := collate.New(language.Make(locale))
+	users := all.Users
+	sort.SliceStable(users, func(i, j int) bool {
+		if page.SortDir == domain.SortDesc {
+			return c.CompareString(users[j].Name, users[i].Name) < 0
+		}
+		return c.CompareString(users[i].Name, users[j].Name) < 0
+	})
+
+	start, end := page.Bounds(len(users))
+	return &domain.UserPage{Users: users[start:end], Total: all.Total, Limit: page.Limit, Offset: page.Offset}, nil
 }
 
-func (s *UserService) List(ctx context.Context) ([]*domain.User, error) {
-	s.log.Info("Listing users", nil)
-	return s.repo.List(ctx)
+// collationExists caches positive and negative answers, collations only
+// change with migrations
+func (s *UserService) collationExists(ctx context.Context, collation string) (bool, error) {
+	if v, ok := s.collations.Load(collation); ok {
+		return v.(bool), nil
+	}
+
+	exists, err := s.repo.CollationExists(ctx, collation)
+	if err != nil {
+		return false, err
+	}
+
+	s.collations.Store(collation, exists)
+	return exists, nil
+}
+
+func (s *UserService) ListTombstones(ctx context.Context, since time.Time) ([]*domain.Tombstone, error) {
+	s.logFor(ctx).Info("Listing user tombstones", map[string]interface{}{"since": since})
+	return s.repo.ListTombstones(ctx, since)
+}
+
+// Purge hard-deletes users soft-deleted longer than retention ago and drops
+// tombstones older than horizon
+func (s *UserService) Purge(ctx context.Context, retention, horizon time.Duration) error {
+	now := s.gen.Clock.Now()
+
+	purged, err := s.repo.Purge(ctx, now.Add(-retention))
+	if err != nil {
+		return err
+	}
+
+	expired, err := s.repo.ExpireTombstones(ctx, now.Add(-horizon))
+	if err != nil {
+		return err
+	}
+
+	s.logFor(ctx).Info("Purged deleted users", map[string]interface{}{
+		"purged":             purged,
+		"expired_tombstones": expired,
+	})
+	return nil
 }
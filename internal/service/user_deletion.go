@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+)
+
+// DeletionPhase is when a user deletion hook runs
+type DeletionPhase int
+
+const (
+	// InDeletionTx hooks run within the deletion transaction, before the
+	// user is deleted, for rows of the same database. A failing hook aborts
+	// the deletion and rolls back the hooks run before it.
+	InDeletionTx DeletionPhase = iota
+	// AfterDeletionCommit hooks run once the deletion is committed, for what
+	// a transaction cannot cover and any process can clean up: blobs, shared
+	// caches, other services. A failing hook does not fail the deletion nor
+	// stop the hooks after it, it is retried by the worker from a
+	// user.cleanup outbox event.
+	AfterDeletionCommit
+	// AfterDeletionCommitLocal hooks run once the deletion is committed, for
+	// what only the deleting process holds, such as an in-memory cache. A
+	// failing hook is only logged: its retry would run in the worker, which
+	// holds none of it.
+	AfterDeletionCommitLocal
+)
+
+// UserDeletionHook cleans up what a component keeps about a deleted user.
+// Hooks may run more than once for a user and must be idempotent.
+type UserDeletionHook func(ctx context.Context, userID string) error
+
+type deletionHook struct {
+	name string
+	run  UserDeletionHook
+}
+
+// UserDeletion deletes users along with what other components keep about
+// them. Components register their cleanups with OnUserDeleted, every user
+// deletion then runs them in the order they were registered.
+type UserDeletion struct {
+	repo   domain.UserRepository
+	tx     domain.Transactor
+	outbox domain.OutboxRepository
+	log    *logger.Logger
+
+	mu          sync.RWMutex
+	inTx        []deletionHook
+	afterCommit []deletionHook
+	local       []deletionHook
+	gen         generate.Generators
+}
+
+// NewUserDeletion creates the deletion of the users of repo. Without a
+// transactor, in-transaction hooks run without one: a failing hook still
+// aborts the deletion, but the hooks run before it are not rolled back.
+// Without an outbox, failed after-commit hooks are only logged.
+func NewUserDeletion(repo domain.UserRepository, tx domain.Transactor, outbox domain.OutboxRepository, log *logger.Logger, opts ...generate.Option) *UserDeletion {
+	return &UserDeletion{
+		repo:   repo,
+		tx:     tx,
+		outbox: outbox,
+		log:    log,
+		gen:    generate.New(opts...),
+	}
+}
+
+// OnUserDeleted registers the hook run in phase when a user is deleted.
+// name identifies the hook in logs and retries, registering it twice panics.
+func (d *UserDeletion) OnUserDeleted(name string, phase DeletionPhase, hook UserDeletionHook) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if findHook(d.inTx, name) != nil || findHook(d.afterCommit, name) != nil || findHook(d.local, name) != nil {
+		panic(fmt.Sprintf("user deletion hook %q registered twice", name))
+	}
+
+	h := deletionHook{name: name, run: hook}
+	switch phase {
+	case InDeletionTx:
+		d.inTx = append(d.inTx, h)
+	case AfterDeletionCommitLocal:
+		d.local = append(d.local, h)
+	default:
+		d.afterCommit = append(d.afterCommit, h)
+	}
+}
+
+// Delete runs the in-transaction hooks and deletes the user in one
+// transaction, then runs the after-commit hooks and the local ones
+func (d *UserDeletion) Delete(ctx context.Context, id string) error {
+	d.mu.RLock()
+	inTx, afterCommit, local := d.inTx, d.afterCommit, d.local
+	d.mu.RUnlock()
+
+	err := d.inTransaction(ctx, func(ctx context.Context) error {
+		for _, hook := range inTx {
+			if err := hook.run(ctx, id); err != nil {
+				return fmt.Errorf("user deletion hook %s: %w", hook.name, err)
+			}
+		}
+		return d.repo.Delete(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, hook := range afterCommit {
+		if err := hook.run(ctx, id); err != nil {
+			d.retryLater(ctx, id, hook, err)
+		}
+	}
+	for _, hook := range local {
+		if err := hook.run(ctx, id); err != nil {
+			d.log.Error("User deletion hook failed", err, map[string]interface{}{"user_id": id, "hook": hook.name})
+		}
+	}
+	return nil
+}
+
+// RunHook runs the after-commit hook registered as name for the user, the
+// retry of a failed run. A hook no longer registered after commit, or
+// registered as local, is skipped.
+func (d *UserDeletion) RunHook(ctx context.Context, userID, name string) error {
+	d.mu.RLock()
+	hook := findHook(d.afterCommit, name)
+	d.mu.RUnlock()
+
+	if hook == nil {
+		d.log.Warn("Skipping unknown user deletion hook", map[string]interface{}{"user_id": userID, "hook": name})
+		return nil
+	}
+	return hook.run(ctx, userID)
+}
+
+// findHook returns the hook of hooks registered as name, nil if there is none
+func findHook(hooks []deletionHook, name string) *deletionHook {
+	for i := range hooks {
+		if hooks[i].name == name {
+			return &hooks[i]
+		}
+	}
+	return nil
+}
+
+func (d *UserDeletion) inTransaction(ctx context.Context, fn func(ctx context.Context) error) error {
+	if d.tx == nil {
+		return fn(ctx)
+	}
+	return d.tx.InTx(ctx, fn)
+}
+
+// retryLater records a user.cleanup event for the failed hook, which the
+// worker consumes to run it again
+func (d *UserDeletion) retryLater(ctx context.Context, userID string, hook deletionHook, cause error) {
+	fields := map[string]interface{}{"user_id": userID, "hook": hook.name}
+	if d.outbox == nil {
+		d.log.Error("User deletion hook failed", cause, fields)
+		return
+	}
+	d.log.Warn("User deletion hook failed, retrying from the outbox", map[string]interface{}{
+		"user_id": userID,
+		"hook":    hook.name,
+		"error":   cause.Error(),
+	})
+
+	payload, err := json.Marshal(domain.UserCleanup{UserID: userID, Hook: hook.name, Tenant: tenant.FromContext(ctx)})
+	if err == nil {
+		err = d.outbox.Append(ctx, &domain.OutboxEvent{
+			ID:          d.gen.IDs.NewID(),
+			Type:        domain.EventUserCleanup,
+			Tenant:      tenant.FromContext(ctx),
+			AggregateID: userID,
+			Payload:     payload,
+			CreatedAt:   d.gen.Clock.Now(),
+		})
+	}
+	if err != nil {
+		d.log.Error("Failed to record user deletion hook retry", err, fields)
+	}
+}
@@ -0,0 +1,221 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+	"github.com/romanitalian/carch-go/internal/repository"
+)
+
+// recordingTransactor runs functions as a transaction would, recording
+// their commits and rollbacks in steps
+type recordingTransactor struct {
+	steps *[]string
+}
+
+func (t recordingTransactor) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	*t.steps = append(*t.steps, "begin")
+	if err := fn(ctx); err != nil {
+		*t.steps = append(*t.steps, "rollback")
+		return err
+	}
+	*t.steps = append(*t.steps, "commit")
+	return nil
+}
+
+// appendedOutbox keeps the events appended to it
+type appendedOutbox struct {
+	domain.OutboxRepository
+	events []*domain.OutboxEvent
+}
+
+func (o *appendedOutbox) Append(ctx context.Context, events ...*domain.OutboxEvent) error {
+	o.events = append(o.events, events...)
+	return nil
+}
+
+// newTestDeletion returns a deletion of a repository holding one user,
+// whose hooks record their runs in steps
+func newTestDeletion(t *testing.T, steps *[]string, outbox domain.OutboxRepository) (*UserDeletion, domain.UserRepository, string) {
+	t.Helper()
+
+	repo := repository.NewMemoryUserRepository()
+	user := &domain.User{Email: "ada@example.com", Name: "Ada"}
+	require.NoError(t, repo.Create(context.Background(), user))
+
+	d := NewUserDeletion(repo, recordingTransactor{steps: steps}, outbox, logger.New())
+	return d, repo, user.ID
+}
+
+// recordHook returns a hook recording its run in steps and failing with err
+func recordHook(steps *[]string, name string, err error) UserDeletionHook {
+	return func(ctx context.Context, userID string) error {
+		*steps = append(*steps, name)
+		return err
+	}
+}
+
+func TestUserDeletion_Delete_Phases(t *testing.T) {
+	// Arrange
+	var steps []string
+	d, repo, id := newTestDeletion(t, &steps, &appendedOutbox{})
+	d.OnUserDeleted("tokens", InDeletionTx, recordHook(&steps, "tokens", nil))
+	d.OnUserDeleted("cache", AfterDeletionCommit, recordHook(&steps, "cache", nil))
+	d.OnUserDeleted("memberships", InDeletionTx, func(ctx context.Context, userID string) error {
+		// In-transaction hooks run before the user is deleted
+		_, err := repo.GetByID(ctx, userID)
+		steps = append(steps, "memberships")
+		return err
+	})
+	d.OnUserDeleted("avatars", AfterDeletionCommit, recordHook(&steps, "avatars", nil))
+
+	// Act
+	err := d.Delete(context.Background(), id)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"begin", "tokens", "memberships", "commit", "cache", "avatars"}, steps)
+	_, err = repo.GetByID(context.Background(), id)
+	assert.ErrorIs(t, err, domain.ErrUserNotFound)
+}
+
+func TestUserDeletion_Delete_AbortedByHook(t *testing.T) {
+	// Arrange
+	var steps []string
+	d, repo, id := newTestDeletion(t, &steps, &appendedOutbox{})
+	failure := errors.New("tokens table is locked")
+	d.OnUserDeleted("tokens", InDeletionTx, recordHook(&steps, "tokens", failure))
+	d.OnUserDeleted("memberships", InDeletionTx, recordHook(&steps, "memberships", nil))
+	d.OnUserDeleted("cache", AfterDeletionCommit, recordHook(&steps, "cache", nil))
+
+	// Act
+	err := d.Delete(context.Background(), id)
+
+	// Assert
+	assert.ErrorIs(t, err, failure)
+	assert.ErrorContains(t, err, "user deletion hook tokens")
+	assert.Equal(t, []string{"begin", "tokens", "rollback"}, steps, "neither the later hooks nor the after-commit ones run")
+	_, err = repo.GetByID(context.Background(), id)
+	assert.NoError(t, err, "the user is not deleted")
+}
+
+func TestUserDeletion_Delete_UnknownUser(t *testing.T) {
+	// Arrange
+	var steps []string
+	d, _, _ := newTestDeletion(t, &steps, &appendedOutbox{})
+	d.OnUserDeleted("cache", AfterDeletionCommit, recordHook(&steps, "cache", nil))
+
+	// Act
+	err := d.Delete(context.Background(), "00000000-0000-0000-0000-000000000000")
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrUserNotFound)
+	assert.Equal(t, []string{"begin", "rollback"}, steps)
+}
+
+func TestUserDeletion_Delete_AfterCommitFailure(t *testing.T) {
+	// Arrange
+	var steps []string
+	outbox := &appendedOutbox{}
+	d, repo, id := newTestDeletion(t, &steps, outbox)
+	failing := errors.New("blob store unavailable")
+	d.OnUserDeleted("avatars", AfterDeletionCommit, recordHook(&steps, "avatars", failing))
+	d.OnUserDeleted("cache", AfterDeletionCommit, recordHook(&steps, "cache", nil))
+	ctx := tenant.WithTenant(context.Background(), "acme")
+
+	// Act
+	err := d.Delete(ctx, id)
+
+	// Assert
+	require.NoError(t, err, "the deletion is committed")
+	assert.Equal(t, []string{"begin", "commit", "avatars", "cache"}, steps)
+	_, err = repo.GetByID(context.Background(), id)
+	assert.ErrorIs(t, err, domain.ErrUserNotFound)
+
+	require.Len(t, outbox.events, 1)
+	event := outbox.events[0]
+	assert.Equal(t, domain.EventUserCleanup, event.Type)
+	assert.Equal(t, "acme", event.Tenant)
+	assert.Equal(t, id, event.AggregateID, "the retry is sequenced after the user.deleted event")
+	var cleanup domain.UserCleanup
+	require.NoError(t, json.Unmarshal(event.Payload, &cleanup))
+	assert.Equal(t, domain.UserCleanup{UserID: id, Hook: "avatars", Tenant: "acme"}, cleanup)
+}
+
+func TestUserDeletion_Delete_LocalFailure(t *testing.T) {
+	// Arrange
+	var steps []string
+	outbox := &appendedOutbox{}
+	d, _, id := newTestDeletion(t, &steps, outbox)
+	d.OnUserDeleted("memory", AfterDeletionCommitLocal, recordHook(&steps, "memory", errors.New("evicted")))
+	d.OnUserDeleted("avatars", AfterDeletionCommit, recordHook(&steps, "avatars", nil))
+
+	// Act
+	err := d.Delete(context.Background(), id)
+
+	// Assert
+	require.NoError(t, err, "the deletion is committed")
+	assert.Equal(t, []string{"begin", "commit", "avatars", "memory"}, steps)
+	assert.Empty(t, outbox.events, "local hooks are not retried by the worker")
+}
+
+func TestUserDeletion_RunHook(t *testing.T) {
+	// Arrange
+	var steps []string
+	d, _, id := newTestDeletion(t, &steps, &appendedOutbox{})
+	d.OnUserDeleted("tokens", InDeletionTx, recordHook(&steps, "tokens", nil))
+	d.OnUserDeleted("avatars", AfterDeletionCommit, recordHook(&steps, "avatars", nil))
+	d.OnUserDeleted("memory", AfterDeletionCommitLocal, recordHook(&steps, "memory", nil))
+
+	// Act
+	errAvatars := d.RunHook(context.Background(), id, "avatars")
+	errTokens := d.RunHook(context.Background(), id, "tokens")
+	errLocal := d.RunHook(context.Background(), id, "memory")
+	errUnknown := d.RunHook(context.Background(), id, "retired")
+
+	// Assert
+	assert.NoError(t, errAvatars)
+	assert.NoError(t, errTokens)
+	assert.NoError(t, errLocal)
+	assert.NoError(t, errUnknown)
+	assert.Equal(t, []string{"avatars"}, steps, "only after-commit hooks are retried")
+}
+
+func TestUserDeletion_OnUserDeleted_Duplicate(t *testing.T) {
+	// Arrange
+	var steps []string
+	d, _, _ := newTestDeletion(t, &steps, nil)
+	d.OnUserDeleted("avatars", AfterDeletionCommit, recordHook(&steps, "avatars", nil))
+
+	// Act & Assert
+	assert.Panics(t, func() {
+		d.OnUserDeleted("avatars", InDeletionTx, recordHook(&steps, "avatars", nil))
+	})
+}
+
+func TestUserService_Delete_RunsDeletionHooks(t *testing.T) {
+	// Arrange
+	var steps []string
+	d, repo, id := newTestDeletion(t, &steps, nil)
+	d.OnUserDeleted("tokens", InDeletionTx, recordHook(&steps, "tokens", nil))
+	var events []UserEvent
+	s := NewUserService(repo, logger.New(),
+		WithUserDeletion(d),
+		WithUserHooks(func(ctx context.Context, event UserEvent) { events = append(events, event) }))
+
+	// Act
+	err := s.Delete(context.Background(), id)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"begin", "tokens", "commit"}, steps)
+	assert.Equal(t, []UserEvent{{Type: UserDeleted, UserID: id}}, events)
+}
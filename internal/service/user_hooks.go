@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+// Types of the events passed to user hooks
+const (
+	UserCreated = "user.created"
+	UserUpdated = "user.updated"
+	UserDeleted = domain.EventUserDeleted
+)
+
+// UserEvent describes a change the service made to a user
+type UserEvent struct {
+	Type   string
+	UserID string
+	// User is the user as stored after the change, nil for deletions
+	User *domain.User
+}
+
+// UserHook is called after a change to a user has been stored. Hooks run
+// synchronously on the caller's goroutine and cannot fail the change.
+type UserHook func(ctx context.Context, event UserEvent)
+
+// PasswordHasher turns a plain password into the hash that is stored
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+}
+
+// WithUserHooks calls hooks, in order, after every stored change to a user
+func WithUserHooks(hooks ...UserHook) UserOption {
+	return func(s *UserService) {
+		s.hooks = append(s.hooks, hooks...)
+	}
+}
+
+// WithPasswordHasher hashes passwords of new users before they are stored.
+// Without a hasher the password is stored as given.
+func WithPasswordHasher(h PasswordHasher) UserOption {
+	return func(s *UserService) {
+		s.hasher = h
+	}
+}
+
+func (s *UserService) notify(ctx context.Context, event UserEvent) {
+	for _, hook := range s.hooks {
+		hook(ctx, event)
+	}
+}
@@ -0,0 +1,544 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/staging"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+)
+
+// Defaults of UserImportService
+const (
+	DefaultImportChunkSize = 500
+	DefaultImportRetention = 24 * time.Hour
+)
+
+// maxImportLine bounds the memory an NDJSON line is read into, longer lines
+// are failed rows
+const maxImportLine = 64 << 10
+
+// importColumns are the columns of CSV files, email and password required
+var importColumns = []string{"email", "password", "name"}
+
+// UserImportService creates users from uploaded files. Uploads are staged
+// on disk and the users are created by a job in the worker, chunk by chunk.
+type UserImportService struct {
+	users UserServiceInterface
+	jobs  *JobService
+	store *staging.Store
+	log   *logger.Logger
+
+	chunkSize int
+	retention time.Duration
+	gen       generate.Generators
+}
+
+// UserImportOption is a function that configures a UserImportService
+type UserImportOption func(*UserImportService)
+
+// WithImportChunkSize sets how many rows are imported between two progress
+// updates, which is also how many rows a resumed job may process again
+func WithImportChunkSize(n int) UserImportOption {
+	return func(s *UserImportService) {
+		if n > 0 {
+			s.chunkSize = n
+		}
+	}
+}
+
+// WithImportRetention sets how long the staged file of a job that stopped
+// changing is kept, see CleanStaging
+func WithImportRetention(d time.Duration) UserImportOption {
+	return func(s *UserImportService) {
+		if d > 0 {
+			s.retention = d
+		}
+	}
+}
+
+// WithImportGenerators sets how jobs and their events are stamped and identified
+func WithImportGenerators(opts ...generate.Option) UserImportOption {
+	return func(s *UserImportService) {
+		s.gen = generate.New(opts...)
+	}
+}
+
+// DefaultImportDir is the staging directory of imports when none is configured
+func DefaultImportDir() string {
+	return filepath.Join(os.TempDir(), "carch-go", "imports")
+}
+
+// NewUserImportService creates an import service staging files in store, a
+// DefaultImportDir without limits when nil. The processes enqueueing and
+// running imports must share the directory.
+func NewUserImportService(users UserServiceInterface, jobs *JobService, store *staging.Store, log *logger.Logger, opts ...UserImportOption) *UserImportService {
+	if store == nil {
+		store = staging.New(DefaultImportDir(), 0, 0)
+	}
+
+	s := &UserImportService{
+		users:     users,
+		jobs:      jobs,
+		store:     store,
+		log:       log,
+		chunkSize: DefaultImportChunkSize,
+		retention: DefaultImportRetention,
+		gen:       generate.New(),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Stage writes the file to the staging directory and enqueues the job
+// importing it, which is returned. The file is not parsed until the job runs.
+func (s *UserImportService) Stage(ctx context.Context, format string, r io.Reader) (*domain.Job, error) {
+	if format != domain.ImportCSV && format != domain.ImportNDJSON {
+		return nil, fmt.Errorf("%w: unknown import format %q", domain.ErrInvalidInput, format)
+	}
+
+	id := s.gen.IDs.NewID()
+	staged, err := s.store.Stage(id, r)
+	switch {
+	case errors.Is(err, staging.ErrTooLarge):
+		return nil, domain.ErrImportTooLarge
+	case errors.Is(err, staging.ErrFull):
+		return nil, domain.ErrImportStagingFull
+	case err != nil:
+		return nil, err
+	}
+
+	job, err := s.enqueue(ctx, id, format, staged)
+	if err != nil {
+		s.removeStaged(id)
+		return nil, err
+	}
+
+	s.log.Info("User import staged", map[string]interface{}{
+		"job_id":   job.ID,
+		"format":   format,
+		"size":     staged.Size,
+		"checksum": staged.Checksum,
+	})
+	return job, nil
+}
+
+func (s *UserImportService) enqueue(ctx context.Context, id, format string, staged *staging.File) (*domain.Job, error) {
+	if staged.Size == 0 {
+		return nil, fmt.Errorf("%w: the import file is empty", domain.ErrInvalidInput)
+	}
+
+	params, err := json.Marshal(domain.UserImportParams{
+		Format:   format,
+		File:     staged.Name,
+		Size:     staged.Size,
+		Checksum: staged.Checksum,
+		Tenant:   tenant.FromContext(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	job := &domain.Job{
+		ID:      id,
+		Type:    domain.JobUsersImport,
+		Status:  domain.JobPending,
+		Message: fmt.Sprintf("%d bytes staged", staged.Size),
+		Params:  params,
+	}
+
+	payload, err := json.Marshal(domain.JobEnqueued{JobID: job.ID})
+	if err != nil {
+		return nil, err
+	}
+	event := &domain.OutboxEvent{
+		ID:        s.gen.IDs.NewID(),
+		Type:      domain.EventJobPrefix + job.Type,
+		Tenant:    tenant.FromContext(ctx),
+		Payload:   payload,
+		CreatedAt: s.gen.Clock.Now(),
+	}
+
+	if err := s.jobs.Enqueue(ctx, job, event); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Run creates the users of the staged file of the job, storing the offset
+// reached after each chunk of rows. A job interrupted by a crash or a
+// timeout resumes from there when its message is redelivered; the users of
+// the interrupted chunk it creates again are counted as skipped. A job that
+// already finished is left alone.
+func (s *UserImportService) Run(ctx context.Context, jobID string) error {
+	job, err := s.jobs.Get(ctx, jobID)
+	if err != nil {
+		return err
+	}
+	if job.Terminal() {
+		return nil
+	}
+
+	var params domain.UserImportParams
+	if err := json.Unmarshal(job.Params, &params); err != nil {
+		return s.fail(ctx, job, fmt.Sprintf("invalid params: %v", err))
+	}
+	ctx = tenant.WithTenant(ctx, params.Tenant)
+
+	f, err := s.store.Open(staging.File{Name: params.File, Size: params.Size, Checksum: params.Checksum})
+	if errors.Is(err, staging.ErrChecksumMismatch) || errors.Is(err, os.ErrNotExist) {
+		return s.fail(ctx, job, fmt.Sprintf("staged file unusable: %v", err))
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	next, err := newImportReader(f, &params)
+	if err != nil {
+		return s.fail(ctx, job, err.Error())
+	}
+
+	job.Status = domain.JobRunning
+	if err := s.update(ctx, job, &params); err != nil {
+		return err
+	}
+
+	for {
+		chunk, offset, err := next(s.chunkSize)
+		if err != nil {
+			return err
+		}
+		if len(chunk) == 0 {
+			break
+		}
+
+		for _, row := range chunk {
+			if err := s.importRow(ctx, &params, row); err != nil {
+				return err
+			}
+		}
+		params.Offset = offset
+		if err := s.update(ctx, job, &params); err != nil {
+			return err
+		}
+	}
+
+	job.Status = domain.JobSucceeded
+	job.Progress = 100
+	job.Message = importSummary(&params)
+	if err := s.jobs.Update(ctx, job); err != nil {
+		return err
+	}
+	s.removeStaged(params.File)
+
+	s.log.Info("User import finished", map[string]interface{}{
+		"job_id":  job.ID,
+		"created": params.Created,
+		"skipped": params.Skipped,
+		"failed":  params.Failed,
+	})
+	return nil
+}
+
+// importRow creates the user of a row. Rows that cannot become users are
+// counted, other errors abort the chunk.
+func (s *UserImportService) importRow(ctx context.Context, params *domain.UserImportParams, row importRow) error {
+	params.Rows++
+	reason := row.invalid
+	if reason == "" {
+		reason = checkImportUser(row.user)
+	}
+	if reason == "" {
+		err := s.users.Create(ctx, row.user)
+		switch {
+		case errors.Is(err, domain.ErrEmailTaken):
+			params.Skipped++
+			return nil
+		case errors.Is(err, domain.ErrInvalidInput):
+			reason = err.Error()
+		case err != nil:
+			return fmt.Errorf("import row %d: %w", params.Rows, err)
+		default:
+			params.Created++
+			return nil
+		}
+	}
+
+	params.Failed++
+	if len(params.Errors) < domain.MaxImportRowErrors {
+		params.Errors = append(params.Errors, domain.UserImportRowError{Row: params.Rows, Reason: reason})
+	}
+	return nil
+}
+
+// checkImportUser returns why a user cannot be created, "" if it can
+func checkImportUser(u *domain.User) string {
+	switch {
+	case u.Email == "":
+		return "email is required"
+	case !strings.Contains(u.Email, "@"):
+		return fmt.Sprintf("invalid email %q", u.Email)
+	case u.Password == "":
+		return "password is required"
+	}
+	return ""
+}
+
+// update stores the params and progress of the job. Progress stays below
+// 100 until the job succeeds.
+func (s *UserImportService) update(ctx context.Context, job *domain.Job, params *domain.UserImportParams) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	job.Params = raw
+	job.Progress = 0
+	if params.Size > 0 {
+		job.Progress = int(min(params.Offset*100/params.Size, 99))
+	}
+	job.Message = fmt.Sprintf("%d of %d bytes read: %s", params.Offset, params.Size, importSummary(params))
+	return s.jobs.Update(ctx, job)
+}
+
+// importSummary counts the rows of an import and gives the first error
+func importSummary(params *domain.UserImportParams) string {
+	summary := fmt.Sprintf("%d users created, %d skipped as existing, %d rows failed", params.Created, params.Skipped, params.Failed)
+	if len(params.Errors) > 0 {
+		summary += fmt.Sprintf(", first at row %d: %s", params.Errors[0].Row, params.Errors[0].Reason)
+	}
+	return summary
+}
+
+func (s *UserImportService) fail(ctx context.Context, job *domain.Job, message string) error {
+	s.log.Warn("User import failed", map[string]interface{}{"job_id": job.ID, "reason": message})
+
+	job.Status = domain.JobFailed
+	job.Message = message
+	return s.jobs.Update(ctx, job)
+}
+
+func (s *UserImportService) removeStaged(name string) {
+	if err := s.store.Remove(name); err != nil {
+		s.log.Warn("Failed to remove staged import", map[string]interface{}{"file": name, "error": err.Error()})
+	}
+}
+
+// CleanStaging removes the staged files of imports that stopped changing
+// for the retention: those that failed, were abandoned, or whose job is
+// gone, and uploads that were never completed. Jobs still pending or running
+// without progress are failed, their file being gone.
+func (s *UserImportService) CleanStaging(ctx context.Context) error {
+	entries, err := s.store.List()
+	if err != nil {
+		return err
+	}
+
+	now := s.gen.Clock.Now()
+	var removed int
+	for _, e := range entries {
+		if now.Sub(e.ModTime) < s.retention {
+			continue
+		}
+		if e.Partial {
+			if err := s.store.RemovePartial(e.Name); err != nil {
+				return err
+			}
+			removed++
+			continue
+		}
+
+		job, err := s.jobs.Get(ctx, e.Name)
+		switch {
+		case errors.Is(err, domain.ErrJobNotFound):
+		case err != nil:
+			return err
+		case now.Sub(job.UpdatedAt) < s.retention:
+			continue
+		case !job.Terminal():
+			if err := s.fail(ctx, job, fmt.Sprintf("expired: no progress for %s", s.retention)); err != nil {
+				return err
+			}
+		}
+		if err := s.store.Remove(e.Name); err != nil {
+			return err
+		}
+		removed++
+	}
+
+	if removed > 0 {
+		s.log.Info("Cleaned import staging", map[string]interface{}{"removed": removed, "dir": s.store.Dir()})
+	}
+	return nil
+}
+
+// importRow is a row of an import file, invalid telling why it is not a user
+type importRow struct {
+	user    *domain.User
+	invalid string
+}
+
+// importReader returns the next n rows of a staged file, fewer at its end,
+// and the offset following them
+type importReader func(n int) ([]importRow, int64, error)
+
+// newImportReader reads f, which is positioned at the start, from the
+// offset of params. The header of a CSV file is read on the first run.
+func newImportReader(f io.ReadSeeker, params *domain.UserImportParams) (importReader, error) {
+	switch params.Format {
+	case domain.ImportCSV:
+		return newCSVImportReader(f, params)
+	case domain.ImportNDJSON:
+		return newNDJSONImportReader(f, params.Offset)
+	default:
+		return nil, fmt.Errorf("unknown import format %q", params.Format)
+	}
+}
+
+func newCSVImportReader(f io.ReadSeeker, params *domain.UserImportParams) (importReader, error) {
+	if _, err := f.Seek(params.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	base := params.Offset
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+
+	if params.Columns == nil {
+		header, err := r.Read()
+		if err != nil {
+			return nil, fmt.Errorf("invalid header: %v", err)
+		}
+		columns := make([]string, len(header))
+		for i, name := range header {
+			columns[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+			if !slices.Contains(importColumns, columns[i]) {
+				return nil, fmt.Errorf("invalid header: unknown column %q, valid columns are %s", name, strings.Join(importColumns, ", "))
+			}
+		}
+		if !slices.Contains(columns, "email") || !slices.Contains(columns, "password") {
+			return nil, errors.New("invalid header: the email and password columns are required")
+		}
+		params.Columns = columns
+		params.Offset = base + r.InputOffset()
+	}
+	r.FieldsPerRecord = len(params.Columns)
+
+	return func(n int) ([]importRow, int64, error) {
+		var rows []importRow
+		for len(rows) < n {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				rows = append(rows, importRow{invalid: parseErr.Err.Error()})
+				continue
+			}
+			if err != nil {
+				return nil, 0, err
+			}
+
+			user := &domain.User{}
+			for i, value := range record {
+				switch params.Columns[i] {
+				case "email":
+					user.Email = strings.TrimSpace(value)
+				case "password":
+					user.Password = value
+				case "name":
+					user.Name = strings.TrimSpace(value)
+				}
+			}
+			rows = append(rows, importRow{user: user})
+		}
+		return rows, base + r.InputOffset(), nil
+	}, nil
+}
+
+// importLine is an NDJSON line
+type importLine struct {
+	Email    string          `json:"email"`
+	Password string          `json:"password"`
+	Name     string          `json:"name"`
+	Metadata domain.Metadata `json:"metadata"`
+}
+
+func newNDJSONImportReader(f io.ReadSeeker, offset int64) (importReader, error) {
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReaderSize(f, maxImportLine)
+
+	return func(n int) ([]importRow, int64, error) {
+		var rows []importRow
+		for len(rows) < n {
+			line, read, err := readImportLine(r)
+			offset += read
+			if err == io.EOF && read == 0 {
+				break
+			}
+			if err != nil && err != io.EOF {
+				return nil, 0, err
+			}
+			if line == nil {
+				rows = append(rows, importRow{invalid: fmt.Sprintf("line longer than %d bytes", maxImportLine)})
+				continue
+			}
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+
+			var decoded importLine
+			dec := json.NewDecoder(bytes.NewReader(line))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(&decoded); err != nil {
+				rows = append(rows, importRow{invalid: fmt.Sprintf("invalid JSON: %v", err)})
+				continue
+			}
+			rows = append(rows, importRow{user: &domain.User{
+				Email:    strings.TrimSpace(decoded.Email),
+				Password: decoded.Password,
+				Name:     strings.TrimSpace(decoded.Name),
+				Metadata: decoded.Metadata,
+			}})
+		}
+		return rows, offset, nil
+	}, nil
+}
+
+// readImportLine reads a line and the bytes it took. A line that does not
+// fit the buffer is skipped and returned as nil.
+func readImportLine(r *bufio.Reader) ([]byte, int64, error) {
+	line, err := r.ReadSlice('\n')
+	read := int64(len(line))
+	if !errors.Is(err, bufio.ErrBufferFull) {
+		return line, read, err
+	}
+	for errors.Is(err, bufio.ErrBufferFull) {
+		line, err = r.ReadSlice('\n')
+		read += int64(len(line))
+	}
+	if err != nil && err != io.EOF {
+		return nil, read, err
+	}
+	return nil, read, nil
+}
@@ -0,0 +1,341 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/staging"
+)
+
+// importUsers creates users in memory, keyed by email; other methods are not used
+type importUsers struct {
+	UserServiceInterface
+
+	mu      sync.Mutex
+	users   map[string]*domain.User
+	creates int
+	// failAt makes the create of that number fail, 0 never
+	failAt int
+}
+
+func newImportUsers() *importUsers {
+	return &importUsers{users: make(map[string]*domain.User)}
+}
+
+func (u *importUsers) Create(ctx context.Context, user *domain.User) error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.creates++
+	if u.creates == u.failAt {
+		return errors.New("connection reset")
+	}
+	if _, ok := u.users[user.Email]; ok {
+		return domain.ErrEmailTaken
+	}
+	u.users[user.Email] = user
+	return nil
+}
+
+func (u *importUsers) emails() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	emails := make([]string, 0, len(u.users))
+	for email := range u.users {
+		emails = append(emails, email)
+	}
+	return emails
+}
+
+var importNow = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+func newTestImportService(t *testing.T, users *importUsers, jobs *memoryJobRepository, store *staging.Store, opts ...UserImportOption) *UserImportService {
+	t.Helper()
+	if store == nil {
+		store = staging.New(t.TempDir(), 0, 0)
+	}
+	opts = append([]UserImportOption{WithImportGenerators(generate.WithClock(clock.NewFixed(importNow)))}, opts...)
+	return NewUserImportService(users, NewJobService(jobs, nil, logger.New()), store, logger.New(), opts...)
+}
+
+func importParams(t *testing.T, jobs *memoryJobRepository, id string) domain.UserImportParams {
+	t.Helper()
+	job, err := jobs.GetByID(context.Background(), id)
+	require.NoError(t, err)
+	var params domain.UserImportParams
+	require.NoError(t, json.Unmarshal(job.Params, &params))
+	return params
+}
+
+func TestUserImportService_Stage(t *testing.T) {
+	// Arrange
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	store := staging.New(t.TempDir(), 0, 0)
+	service := newTestImportService(t, newImportUsers(), jobs, store)
+
+	// Act
+	job, err := service.Stage(context.Background(), domain.ImportCSV, strings.NewReader("email,password\nada@example.com,secret\n"))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobUsersImport, job.Type)
+	assert.Equal(t, domain.JobPending, job.Status)
+	assert.Equal(t, "38 bytes staged", job.Message)
+	require.Len(t, jobs.events, 1)
+	assert.Equal(t, "job.users.import", jobs.events[0].Type)
+
+	params := importParams(t, jobs, job.ID)
+	assert.Equal(t, job.ID, params.File)
+	assert.Equal(t, int64(38), params.Size)
+	assert.Len(t, params.Checksum, 64)
+	entries, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, job.ID, entries[0].Name)
+}
+
+func TestUserImportService_Stage_Rejected(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   *staging.Store
+		format  string
+		content string
+		wantErr error
+	}{
+		{name: "unknown format", format: "xml", content: "<users/>", wantErr: domain.ErrInvalidInput},
+		{name: "empty file", format: domain.ImportCSV, wantErr: domain.ErrInvalidInput},
+		{name: "too large", store: staging.New(t.TempDir(), 8, 0), format: domain.ImportCSV, content: "email,password\n", wantErr: domain.ErrImportTooLarge},
+		{name: "staging full", store: staging.New(t.TempDir(), 100, 8), format: domain.ImportCSV, content: "email,password\n", wantErr: domain.ErrImportStagingFull},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+			store := tt.store
+			if store == nil {
+				store = staging.New(t.TempDir(), 0, 0)
+			}
+			service := newTestImportService(t, newImportUsers(), jobs, store)
+
+			// Act
+			job, err := service.Stage(context.Background(), tt.format, strings.NewReader(tt.content))
+
+			// Assert
+			assert.Nil(t, job)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Empty(t, jobs.jobs)
+			entries, listErr := store.List()
+			require.NoError(t, listErr)
+			assert.Empty(t, entries, "nothing stays staged")
+		})
+	}
+}
+
+func TestUserImportService_Run_CSV(t *testing.T) {
+	// Arrange
+	const file = "\ufeffName, Email,password\n" +
+		"Ada,ada@example.com,secret\n" +
+		"Bob,bob@example.com,\n" +
+		"\"Grace, H\",grace@example.com,secret\n" +
+		"Ada again,ada@example.com,secret\n" +
+		"Eve,eve@example.com\n" +
+		"Linus,linus@example.com,secret\n"
+	users := newImportUsers()
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	store := staging.New(t.TempDir(), 0, 0)
+	service := newTestImportService(t, users, jobs, store, WithImportChunkSize(2))
+	job, err := service.Stage(context.Background(), domain.ImportCSV, strings.NewReader(file))
+	require.NoError(t, err)
+
+	// Act
+	err = service.Run(context.Background(), job.ID)
+
+	// Assert
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ada@example.com", "grace@example.com", "linus@example.com"}, users.emails())
+	assert.Equal(t, "Grace, H", users.users["grace@example.com"].Name)
+
+	final, err := jobs.GetByID(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobSucceeded, final.Status)
+	assert.Equal(t, 100, final.Progress)
+	assert.Equal(t, "3 users created, 1 skipped as existing, 2 rows failed, first at row 2: password is required", final.Message)
+
+	params := importParams(t, jobs, job.ID)
+	assert.Equal(t, []string{"name", "email", "password"}, params.Columns)
+	assert.Equal(t, int64(len(file)), params.Offset)
+	assert.Equal(t, []domain.UserImportRowError{
+		{Row: 2, Reason: "password is required"},
+		{Row: 5, Reason: "wrong number of fields"},
+	}, params.Errors)
+
+	// One update to start, one per chunk of 2 rows, one to finish
+	require.Len(t, jobs.updates, 5)
+	assert.Equal(t, "72 of 192 bytes read: 1 users created, 0 skipped as existing, 1 rows failed, first at row 2: password is required", jobs.updates[1].Message)
+	entries, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, entries, "the staged file is removed once imported")
+}
+
+func TestUserImportService_Run_InvalidHeader(t *testing.T) {
+	// Arrange
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	store := staging.New(t.TempDir(), 0, 0)
+	service := newTestImportService(t, newImportUsers(), jobs, store)
+	job, err := service.Stage(context.Background(), domain.ImportCSV, strings.NewReader("email,role\nada@example.com,admin\n"))
+	require.NoError(t, err)
+
+	// Act
+	err = service.Run(context.Background(), job.ID)
+
+	// Assert
+	require.NoError(t, err)
+	final, err := jobs.GetByID(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobFailed, final.Status)
+	assert.Equal(t, `invalid header: unknown column "role", valid columns are email, password, name`, final.Message)
+	entries, err := store.List()
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the file of a failed import is kept until CleanStaging")
+}
+
+func TestUserImportService_Run_NDJSON(t *testing.T) {
+	// Arrange
+	file := `{"email":"ada@example.com","password":"secret","name":"Ada","metadata":{"team":"core"}}` + "\n" +
+		"\n" +
+		`{"email":"bob@example.com","password":"secret","role":"admin"}` + "\n" +
+		`{"email":"` + strings.Repeat("x", maxImportLine) + `"}` + "\n" +
+		`not json` + "\n" +
+		`{"email":"eve@example.com","password":"secret"}`
+	users := newImportUsers()
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	service := newTestImportService(t, users, jobs, nil)
+	job, err := service.Stage(context.Background(), domain.ImportNDJSON, strings.NewReader(file))
+	require.NoError(t, err)
+
+	// Act
+	err = service.Run(context.Background(), job.ID)
+
+	// Assert
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ada@example.com", "eve@example.com"}, users.emails())
+	assert.Equal(t, domain.Metadata{"team": "core"}, users.users["ada@example.com"].Metadata)
+
+	params := importParams(t, jobs, job.ID)
+	assert.Equal(t, int64(len(file)), params.Offset)
+	require.Len(t, params.Errors, 3)
+	assert.Equal(t, domain.UserImportRowError{Row: 2, Reason: `invalid JSON: json: unknown field "role"`}, params.Errors[0])
+	assert.Equal(t, domain.UserImportRowError{Row: 3, Reason: "line longer than 65536 bytes"}, params.Errors[1])
+	assert.Equal(t, int64(4), params.Errors[2].Row)
+}
+
+func TestUserImportService_Run_ResumesAfterCrash(t *testing.T) {
+	// Arrange
+	var file strings.Builder
+	file.WriteString("email,password\n")
+	for _, name := range []string{"ada", "bob", "eve", "grace", "linus"} {
+		file.WriteString(name + "@example.com,secret\n")
+	}
+	users := newImportUsers()
+	users.failAt = 4
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	service := newTestImportService(t, users, jobs, nil, WithImportChunkSize(2))
+	job, err := service.Stage(context.Background(), domain.ImportCSV, strings.NewReader(file.String()))
+	require.NoError(t, err)
+
+	// Act: the worker dies on the second user of the second chunk, after
+	// creating its first user, then the message is redelivered
+	crashErr := service.Run(context.Background(), job.ID)
+	interrupted := importParams(t, jobs, job.ID)
+	err = service.Run(context.Background(), job.ID)
+
+	// Assert
+	require.Error(t, crashErr)
+	assert.Equal(t, int64(2), interrupted.Rows, "the offset of the first chunk was stored")
+	assert.Equal(t, int64(len("email,password\nada@example.com,secret\nbob@example.com,secret\n")), interrupted.Offset)
+
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"ada@example.com", "bob@example.com", "eve@example.com", "grace@example.com", "linus@example.com"}, users.emails())
+	params := importParams(t, jobs, job.ID)
+	assert.Equal(t, int64(5), params.Rows)
+	assert.Equal(t, int64(4), params.Created)
+	assert.Equal(t, int64(1), params.Skipped, "the user created before the crash is not created twice")
+	final, err := jobs.GetByID(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobSucceeded, final.Status)
+}
+
+func TestUserImportService_Run_ChecksumMismatch(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{}}
+	service := newTestImportService(t, newImportUsers(), jobs, staging.New(dir, 0, 0))
+	job, err := service.Stage(context.Background(), domain.ImportCSV, strings.NewReader("email,password\nada@example.com,secret\n"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, job.ID), []byte("email,password\neve@example.com,secret\n"), 0o600))
+
+	// Act
+	err = service.Run(context.Background(), job.ID)
+
+	// Assert
+	require.NoError(t, err)
+	final, err := jobs.GetByID(context.Background(), job.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobFailed, final.Status)
+	assert.Contains(t, final.Message, "staged file unusable: staged file checksum mismatch")
+}
+
+func TestUserImportService_CleanStaging(t *testing.T) {
+	// Arrange
+	dir := t.TempDir()
+	old := importNow.Add(-25 * time.Hour)
+	jobs := &memoryJobRepository{jobs: map[string]domain.Job{
+		"failed":  {ID: "failed", Status: domain.JobFailed, UpdatedAt: old},
+		"stale":   {ID: "stale", Status: domain.JobPending, UpdatedAt: old},
+		"running": {ID: "running", Status: domain.JobRunning, UpdatedAt: importNow.Add(-time.Minute)},
+		"recent":  {ID: "recent", Status: domain.JobFailed, UpdatedAt: importNow.Add(-time.Hour)},
+	}}
+	write := func(name string, modTime time.Time) {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte("email,password\n"), 0o600))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	write("failed", old)
+	write("stale", old)
+	write("running", old)
+	write("recent", importNow.Add(-time.Hour))
+	write("orphan", old)
+	write("upload.part", old)
+	write("current.part", importNow.Add(-time.Minute))
+	service := newTestImportService(t, newImportUsers(), jobs, staging.New(dir, 0, 0))
+
+	// Act
+	err := service.CleanStaging(context.Background())
+
+	// Assert
+	require.NoError(t, err)
+	var left []string
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		left = append(left, e.Name())
+	}
+	assert.ElementsMatch(t, []string{"running", "recent", "current.part"}, left)
+	assert.Equal(t, domain.JobFailed, jobs.jobs["stale"].Status)
+	assert.Equal(t, "expired: no progress for 24h0m0s", jobs.jobs["stale"].Message)
+	assert.Equal(t, domain.JobRunning, jobs.jobs["running"].Status)
+}
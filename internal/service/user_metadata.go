@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/crypto"
+)
+
+// MetadataSealer seals metadata values, see crypto.Envelopes
+type MetadataSealer interface {
+	Seal(ctx context.Context, plaintext, aad []byte) (*crypto.Envelope, error)
+	Open(ctx context.Context, env *crypto.Envelope, aad []byte) ([]byte, error)
+	// Stale reports whether env should be sealed again with the current key
+	Stale(env *crypto.Envelope) bool
+}
+
+// metadataEncryption seals the metadata values of sensitive keys
+type metadataEncryption struct {
+	sealer    MetadataSealer
+	sensitive map[string]bool
+}
+
+// WithMetadataEncryption stores the metadata values of the sensitive keys
+// sealed by sealer rather than in clear. Reads only return them to callers
+// whose context went through WithSensitiveMetadata, and values sealed under a
+// retired master key are sealed again with the current one when read. Hooks
+// and responses to changes never see them.
+func WithMetadataEncryption(sealer MetadataSealer, sensitiveKeys ...string) UserOption {
+	return func(s *UserService) {
+		m := &metadataEncryption{sealer: sealer, sensitive: make(map[string]bool, len(sensitiveKeys))}
+		for _, key := range sensitiveKeys {
+			m.sensitive[key] = true
+		}
+		s.metadata = m
+	}
+}
+
+type sensitiveMetadataKey struct{}
+
+// WithSensitiveMetadata marks ctx as made by a caller allowed to read the
+// values of sensitive metadata keys
+func WithSensitiveMetadata(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sensitiveMetadataKey{}, true)
+}
+
+// SensitiveMetadataAllowed reports whether reads made with ctx return the
+// values of sensitive metadata keys
+func SensitiveMetadataAllowed(ctx context.Context) bool {
+	allowed, _ := ctx.Value(sensitiveMetadataKey{}).(bool)
+	return allowed
+}
+
+// metadataAAD binds a sealed value to the user and key it is stored under,
+// so that it cannot be copied to another one
+func metadataAAD(userID, key string) []byte {
+	return []byte(userID + "\x00" + key)
+}
+
+// sealMetadata moves the values of sensitive keys from Metadata to
+// SealedMetadata, sealed. Nil Metadata leaves the stored metadata as is.
+func (s *UserService) sealMetadata(ctx context.Context, user *domain.User) error {
+	if s.metadata == nil || user.Metadata == nil {
+		return nil
+	}
+	// Sealed values are bound to the ID, so it must be known before the user is stored
+	if user.ID == "" {
+		user.ID = s.gen.IDs.NewID()
+	}
+
+	plain := make(domain.Metadata, len(user.Metadata))
+	sealed := make(domain.SealedMetadata)
+	for key, value := range user.Metadata {
+		if !s.metadata.sensitive[key] {
+			plain[key] = value
+			continue
+		}
+
+		raw, err := s.sealValue(ctx, user.ID, key, []byte(value))
+		if err != nil {
+			return fmt.Errorf("seal metadata %q: %w", key, err)
+		}
+		sealed[key] = raw
+	}
+
+	user.Metadata = plain
+	user.SealedMetadata = sealed
+	return nil
+}
+
+func (s *UserService) sealValue(ctx context.Context, userID, key string, value []byte) (json.RawMessage, error) {
+	env, err := s.metadata.sealer.Seal(ctx, value, metadataAAD(userID, key))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(env)
+}
+
+// openMetadata prepares users read from the repository for the caller. The
+// sensitive values are added to Metadata if ctx allows it, and values sealed
+// under a retired master key are sealed again and stored. Values that fail
+// to open are logged and left out.
+func (s *UserService) openMetadata(ctx context.Context, users ...*domain.User) {
+	if s.metadata == nil {
+		return
+	}
+
+	allowed := SensitiveMetadataAllowed(ctx)
+	for _, u := range users {
+		if len(u.SealedMetadata) == 0 {
+			continue
+		}
+
+		resealed := make(domain.SealedMetadata, len(u.SealedMetadata))
+		rotated := false
+		for key, raw := range u.SealedMetadata {
+			resealed[key] = raw
+
+			var env crypto.Envelope
+			if err := json.Unmarshal(raw, &env); err != nil {
+				s.log.Error("Failed to decode sealed metadata", err, map[string]interface{}{"user_id": u.ID, "key": key})
+				continue
+			}
+			stale := s.metadata.sealer.Stale(&env)
+			if !allowed && !stale {
+				continue
+			}
+
+			value, err := s.metadata.sealer.Open(ctx, &env, metadataAAD(u.ID, key))
+			if err != nil {
+				s.log.Error("Failed to open sealed metadata", err, map[string]interface{}{"user_id": u.ID, "key": key})
+				continue
+			}
+			if allowed {
+				if u.Metadata == nil {
+					u.Metadata = make(domain.Metadata)
+				}
+				u.Metadata[key] = string(value)
+			}
+			if !stale {
+				continue
+			}
+
+			fresh, err := s.sealValue(ctx, u.ID, key, value)
+			if err != nil {
+				s.log.Error("Failed to seal metadata with the current key", err, map[string]interface{}{"user_id": u.ID, "key": key})
+				continue
+			}
+			resealed[key] = fresh
+			rotated = true
+		}
+
+		if rotated {
+			s.rotateMetadata(ctx, u, resealed)
+		}
+	}
+}
+
+// rotateMetadata stores the values of the user sealed again. It is skipped
+// if the sealed metadata changed since the user was read; the next read
+// rotates what is stored then.
+func (s *UserService) rotateMetadata(ctx context.Context, u *domain.User, resealed domain.SealedMetadata) {
+	repo, ok := s.repo.(domain.SealedMetadataRepository)
+	if !ok {
+		return
+	}
+
+	replaced, err := repo.ReplaceSealedMetadata(ctx, u.ID, u.SealedMetadata, resealed)
+	if err != nil {
+		s.log.Error("Failed to store rotated metadata", err, map[string]interface{}{"user_id": u.ID})
+		return
+	}
+	if replaced {
+		u.SealedMetadata = resealed
+		s.log.Info("Rotated sealed metadata", map[string]interface{}{"user_id": u.ID})
+	}
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/crypto"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/repository"
+)
+
+func testEnvelopes(t *testing.T, current string) *crypto.Envelopes {
+	t.Helper()
+	keyring, err := crypto.NewKeyring(current, map[string][]byte{
+		"k1": bytes.Repeat([]byte{1}, crypto.KeySize),
+		"k2": bytes.Repeat([]byte{2}, crypto.KeySize),
+	})
+	require.NoError(t, err)
+	return crypto.NewEnvelopes(keyring)
+}
+
+func newMetadataUserService(t *testing.T, repo domain.UserRepository, keyID string, hooks ...UserHook) *UserService {
+	return NewUserService(repo, logger.New(logger.WithOutput(&bytes.Buffer{})),
+		WithMetadataEncryption(testEnvelopes(t, keyID), "ssn"),
+		WithUserHooks(hooks...),
+	)
+}
+
+func sealedKeyID(t *testing.T, raw json.RawMessage) string {
+	t.Helper()
+	var env crypto.Envelope
+	require.NoError(t, json.Unmarshal(raw, &env))
+	return env.KeyID
+}
+
+func TestUserService_MetadataEncryption_SealsSensitiveKeys(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository()
+	var hooked *domain.User
+	s := newMetadataUserService(t, repo, "k1", func(ctx context.Context, event UserEvent) {
+		hooked = event.User
+	})
+	user := &domain.User{Email: "a@example.com", Metadata: domain.Metadata{"plan": "pro", "ssn": "078-05-1120"}}
+
+	// Act
+	err := s.Create(ctx, user)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, domain.Metadata{"plan": "pro"}, user.Metadata)
+	assert.Equal(t, domain.Metadata{"plan": "pro"}, hooked.Metadata)
+
+	stored, err := repo.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.Metadata{"plan": "pro"}, stored.Metadata)
+	require.Contains(t, stored.SealedMetadata, "ssn")
+	assert.NotContains(t, string(stored.SealedMetadata["ssn"]), "078-05-1120")
+
+	body, err := json.Marshal(stored)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "ssn")
+}
+
+func TestUserService_MetadataEncryption_Reads(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository()
+	s := newMetadataUserService(t, repo, "k1")
+	user := &domain.User{Email: "a@example.com", Metadata: domain.Metadata{"plan": "pro", "ssn": "078-05-1120"}}
+	require.NoError(t, s.Create(ctx, user))
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want domain.Metadata
+	}{
+		{
+			name: "without permission",
+			ctx:  ctx,
+			want: domain.Metadata{"plan": "pro"},
+		},
+		{
+			name: "with permission",
+			ctx:  WithSensitiveMetadata(ctx),
+			want: domain.Metadata{"plan": "pro", "ssn": "078-05-1120"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.GetByID(tt.ctx, user.ID)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got.Metadata)
+
+			lookup, err := s.GetByIDs(tt.ctx, []string{user.ID})
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, lookup.Found[user.ID].Metadata)
+
+			list, err := s.List(tt.ctx, domain.UserListOptions{})
+			require.NoError(t, err)
+			require.Len(t, list.Users, 1)
+			assert.Equal(t, tt.want, list.Users[0].Metadata)
+		})
+	}
+}
+
+func TestUserService_MetadataEncryption_UpdateKeepsMetadataWhenLeftOut(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository()
+	s := newMetadataUserService(t, repo, "k1")
+	user := &domain.User{Email: "a@example.com", Metadata: domain.Metadata{"ssn": "078-05-1120"}}
+	require.NoError(t, s.Create(ctx, user))
+
+	err := s.Update(ctx, &domain.User{ID: user.ID, Email: "a@example.com", Name: "Ann"})
+	require.NoError(t, err)
+
+	got, err := s.GetByID(WithSensitiveMetadata(ctx), user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "078-05-1120", got.Metadata["ssn"])
+}
+
+func TestUserService_MetadataEncryption_RotatesOnRead(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository()
+	user := &domain.User{Email: "a@example.com", Metadata: domain.Metadata{"ssn": "078-05-1120"}}
+	require.NoError(t, newMetadataUserService(t, repo, "k1").Create(ctx, user))
+
+	// Act
+	got, err := newMetadataUserService(t, repo, "k2").GetByID(ctx, user.ID)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Empty(t, got.Metadata)
+	stored, err := repo.GetByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", sealedKeyID(t, stored.SealedMetadata["ssn"]))
+
+	opened, err := newMetadataUserService(t, repo, "k2").GetByID(WithSensitiveMetadata(ctx), user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "078-05-1120", opened.Metadata["ssn"])
+}
+
+func TestUserService_MetadataEncryption_RejectsValuesMovedToAnotherUser(t *testing.T) {
+	// Arrange
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository()
+	s := newMetadataUserService(t, repo, "k1")
+	a := &domain.User{Email: "a@example.com", Metadata: domain.Metadata{"ssn": "078-05-1120"}}
+	b := &domain.User{Email: "b@example.com", Metadata: domain.Metadata{}}
+	require.NoError(t, s.Create(ctx, a))
+	require.NoError(t, s.Create(ctx, b))
+
+	stored, err := repo.GetByID(ctx, a.ID)
+	require.NoError(t, err)
+	_, err = repo.ReplaceSealedMetadata(ctx, b.ID, domain.SealedMetadata{}, stored.SealedMetadata)
+	require.NoError(t, err)
+
+	// Act
+	got, err := s.GetByID(WithSensitiveMetadata(ctx), b.ID)
+
+	// Assert
+	require.NoError(t, err)
+	assert.NotContains(t, got.Metadata, "ssn")
+}
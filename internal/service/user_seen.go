@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+)
+
+// seenTracking records when users are read, at most once per user and interval
+type seenTracking struct {
+	users    domain.RecentUserRepository
+	marks    cache.Adder
+	interval time.Duration
+}
+
+// WithSeenTracking marks users seen in users when GetByID reads them, so
+// that the cache warmer finds them. A user is marked at most once per
+// interval, as claimed in marks. An interval of 0 disables the tracking.
+func WithSeenTracking(users domain.RecentUserRepository, marks cache.Adder, interval time.Duration) UserOption {
+	return func(s *UserService) {
+		if users == nil || marks == nil || interval <= 0 {
+			return
+		}
+		s.seen = &seenTracking{users: users, marks: marks, interval: interval}
+	}
+}
+
+// markSeen records that the user was read, unless it was within the interval
+func (s *UserService) markSeen(ctx context.Context, id string) {
+	if s.seen == nil {
+		return
+	}
+
+	// Reads are not failed for the sake of the warmer, errors are only logged
+	claimed, err := s.seen.marks.Add(ctx, "seen:user:"+id, nil, s.seen.interval)
+	if err != nil {
+		s.logFor(ctx).Error("Failed to claim user seen mark", err, map[string]interface{}{"user_id": id})
+		return
+	}
+	if !claimed {
+		return
+	}
+	if err := s.seen.users.MarkSeen(ctx, id, s.gen.Clock.Now()); err != nil {
+		s.logFor(ctx).Error("Failed to mark user seen", err, map[string]interface{}{"user_id": id})
+	}
+}
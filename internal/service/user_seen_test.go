@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// failingAdder is a mark store whose backend is down
+type failingAdder struct {
+	*cache.Memory
+}
+
+func (failingAdder) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+func TestUserService_GetByID_MarksSeenOncePerInterval(t *testing.T) {
+	// Arrange
+	repo := new(MockUserRepository)
+	repo.On("GetByID", mock.Anything, "u-1").Return(&domain.User{ID: "u-1"}, nil)
+	repo.On("GetByID", mock.Anything, "u-2").Return(&domain.User{ID: "u-2"}, nil)
+	recent := &recentUsers{}
+	svc := NewUserService(repo, logger.New(), WithSeenTracking(recent, cache.NewMemory(), time.Hour))
+	ctx := context.Background()
+
+	// Act
+	for _, id := range []string{"u-1", "u-1", "u-2", "u-1"} {
+		_, err := svc.GetByID(ctx, id)
+		require.NoError(t, err)
+	}
+
+	// Assert
+	assert.Equal(t, []string{"u-1", "u-2"}, recent.seen)
+}
+
+func TestUserService_GetByID_ServedWhenMarksFail(t *testing.T) {
+	// Arrange
+	repo := new(MockUserRepository)
+	repo.On("GetByID", mock.Anything, "u-1").Return(&domain.User{ID: "u-1"}, nil)
+	recent := &recentUsers{}
+	svc := NewUserService(repo, logger.New(), WithSeenTracking(recent, failingAdder{cache.NewMemory()}, time.Hour))
+
+	// Act
+	user, err := svc.GetByID(context.Background(), "u-1")
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "u-1", user.ID)
+	assert.Empty(t, recent.seen)
+}
@@ -6,11 +6,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 
 	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/idgen"
 	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/repository"
 )
 
 // Мок для UserRepository
@@ -31,6 +36,14 @@ func (m *MockUserRepository) GetByID(ctx context.Context, id string) (*domain.Us
 	return args.Get(0).(*domain.User), args.Error(1)
 }
 
+func (m *MockUserRepository) GetByIDs(ctx context.Context, ids []string) ([]*domain.User, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.User), args.Error(1)
+}
+
 func (m *MockUserRepository) Update(ctx context.Context, user *domain.User) error {
 	args := m.Called(ctx, user)
 	return args.Error(0)
@@ -41,12 +54,63 @@ func (m *MockUserRepository) Delete(ctx context.Context, id string) error {
 	return args.Error(0)
 }
 
-func (m *MockUserRepository) List(ctx context.Context) ([]*domain.User, error) {
-	args := m.Called(ctx)
+func (m *MockUserRepository) List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	args := m.Called(ctx, opts)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).([]*domain.User), args.Error(1)
+	return args.Get(0).(*domain.UserPage), args.Error(1)
+}
+
+func (m *MockUserRepository) CollationExists(ctx context.Context, collation string) (bool, error) {
+	args := m.Called(ctx, collation)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) ListTombstones(ctx context.Context, since time.Time) ([]*domain.Tombstone, error) {
+	args := m.Called(ctx, since)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Tombstone), args.Error(1)
+}
+
+func (m *MockUserRepository) Purge(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	args := m.Called(ctx, deletedBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockUserRepository) ExpireTombstones(ctx context.Context, deletedBefore time.Time) (int64, error) {
+	args := m.Called(ctx, deletedBefore)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockUserRepository) EmailExists(ctx context.Context, email string) (bool, error) {
+	args := m.Called(ctx, email)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockUserRepository) CreateEmailChange(ctx context.Context, change *domain.EmailChange, events []*domain.OutboxEvent) error {
+	args := m.Called(ctx, change, events)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) GetEmailChangeByTokenHash(ctx context.Context, tokenHash string) (*domain.EmailChange, error) {
+	args := m.Called(ctx, tokenHash)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.EmailChange), args.Error(1)
+}
+
+func (m *MockUserRepository) ConfirmEmailChange(ctx context.Context, change *domain.EmailChange) error {
+	args := m.Called(ctx, change)
+	return args.Error(0)
+}
+
+func (m *MockUserRepository) CancelEmailChange(ctx context.Context, userID string) error {
+	args := m.Called(ctx, userID)
+	return args.Error(0)
 }
 
 func TestUserService_Create(t *testing.T) {
@@ -123,6 +187,26 @@ func TestUserService_GetByID_NotFound(t *testing.T) {
 	mockRepo.AssertExpectations(t)
 }
 
+func TestUserService_Patch_ReadsPrimary(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	service := NewUserService(mockRepo, logger.New())
+	ctx := context.Background()
+
+	name := "New Name"
+	mockRepo.On("GetByID", primaryContext, "user-123").Return(&domain.User{ID: "user-123", Email: "a@example.com", Name: "Old"}, nil)
+	mockRepo.On("Update", ctx, mock.Anything).Return(nil)
+
+	// Act
+	user, err := service.Patch(ctx, "user-123", domain.UserChanges{Name: &name})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "New Name", user.Name)
+	assert.Equal(t, "a@example.com", user.Email)
+	mockRepo.AssertExpectations(t)
+}
+
 func TestUserService_Update(t *testing.T) {
 	// Arrange
 	mockRepo := new(MockUserRepository)
@@ -238,15 +322,16 @@ func TestUserService_List(t *testing.T) {
 	}
 
 	// Настройка мока
-	mockRepo.On("List", ctx).Return(expectedUsers, nil)
+	firstPage := domain.UserListOptions{PageRequest: domain.PageRequest{Limit: domain.DefaultMaxPageLimit}}
+	mockRepo.On("List", ctx, firstPage).Return(&domain.UserPage{Users: expectedUsers, Total: 2, Limit: domain.DefaultMaxPageLimit}, nil)
 
 	// Act
-	users, err := service.List(ctx)
+	page, err := service.List(ctx, domain.UserListOptions{})
 
 	// Assert
 	assert.NoError(t, err)
-	assert.Equal(t, expectedUsers, users)
-	assert.Len(t, users, 2)
+	assert.Equal(t, expectedUsers, page.Users)
+	assert.Equal(t, int64(2), page.Total)
 	mockRepo.AssertExpectations(t)
 }
 
@@ -260,10 +345,10 @@ func TestUserService_List_Error(t *testing.T) {
 	expectedError := errors.New("database error")
 
 	// Настройка мока
-	mockRepo.On("List", ctx).Return(nil, expectedError)
+	mockRepo.On("List", ctx, domain.UserListOptions{PageRequest: domain.PageRequest{Limit: domain.DefaultMaxPageLimit}}).Return(nil, expectedError)
 
 	// Act
-	users, err := service.List(ctx)
+	users, err := service.List(ctx, domain.UserListOptions{})
 
 	// Assert
 	assert.Error(t, err)
@@ -271,3 +356,302 @@ func TestUserService_List_Error(t *testing.T) {
 	assert.Nil(t, users)
 	mockRepo.AssertExpectations(t)
 }
+
+func TestUserService_Purge(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	log := logger.New()
+	service := NewUserService(mockRepo, log)
+	ctx := context.Background()
+	retention := 24 * time.Hour
+	horizon := 90 * 24 * time.Hour
+	start := time.Now()
+
+	// Purged users older than retention, tombstones older than horizon
+	mockRepo.On("Purge", ctx, mock.MatchedBy(func(before time.Time) bool {
+		return !before.Before(start.Add(-retention)) && before.Before(start.Add(-retention+time.Minute))
+	})).Return(int64(2), nil)
+	mockRepo.On("ExpireTombstones", ctx, mock.MatchedBy(func(before time.Time) bool {
+		return !before.Before(start.Add(-horizon)) && before.Before(start.Add(-horizon+time.Minute))
+	})).Return(int64(1), nil)
+
+	// Act
+	err := service.Purge(ctx, retention, horizon)
+
+	// Assert
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_GetByIDs(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	log := logger.New()
+	service := NewUserService(mockRepo, log)
+	ctx := context.Background()
+
+	found := &domain.User{ID: "6f1c2a4e-0d6b-4c1e-9a51-0c8a3c1f5b11", Email: "a@example.com"}
+	missing := "0b7e4c52-2d1a-4f0e-8e0c-3b9e6f2d7a22"
+
+	// Repeated IDs are queried once, invalid ones never reach the repository
+	mockRepo.On("GetByIDs", ctx, []string{found.ID, missing}).Return([]*domain.User{found}, nil)
+
+	// Act
+	lookup, err := service.GetByIDs(ctx, []string{found.ID, missing, found.ID, "not-a-uuid", missing})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*domain.User{found.ID: found}, lookup.Found)
+	assert.ElementsMatch(t, []string{missing, "not-a-uuid"}, lookup.Missing)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_GetByIDs_KeepsCallerSpelling(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	log := logger.New()
+	service := NewUserService(mockRepo, log)
+	ctx := context.Background()
+
+	found := &domain.User{ID: "6f1c2a4e-0d6b-4c1e-9a51-0c8a3c1f5b11"}
+	upper := "6F1C2A4E-0D6B-4C1E-9A51-0C8A3C1F5B11"
+
+	mockRepo.On("GetByIDs", ctx, []string{found.ID}).Return([]*domain.User{found}, nil)
+
+	// Act
+	lookup, err := service.GetByIDs(ctx, []string{upper, found.ID})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*domain.User{upper: found, found.ID: found}, lookup.Found)
+	assert.Empty(t, lookup.Missing)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_GetByIDs_MixedCaseDuplicates(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	service := NewUserService(mockRepo, logger.New())
+	ctx := context.Background()
+
+	found := &domain.User{ID: "6f1c2a4e-0d6b-4c1e-9a51-0c8a3c1f5b11"}
+	foundUpper := "6F1C2A4E-0D6B-4C1E-9A51-0C8A3C1F5B11"
+	missing := "0b7e4c52-2d1a-4f0e-8e0c-3b9e6f2d7a22"
+	missingUpper := "0B7E4C52-2D1A-4F0E-8E0C-3B9E6F2D7A22"
+
+	mockRepo.On("GetByIDs", ctx, []string{found.ID, missing}).Return([]*domain.User{found}, nil)
+
+	// Act
+	lookup, err := service.GetByIDs(ctx, []string{foundUpper, missing, found.ID, missingUpper, foundUpper})
+
+	// Assert: every spelling is either found or missing
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]*domain.User{foundUpper: found, found.ID: found}, lookup.Found)
+	assert.ElementsMatch(t, []string{missing, missingUpper}, lookup.Missing)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_GetByIDs_MixedUUIDVersions(t *testing.T) {
+	// Arrange: a user created before the switch to time-ordered IDs and one after
+	ctx := context.Background()
+	repo := repository.NewMemoryUserRepository(generate.WithIDGenerator(idgen.UUIDv7))
+	old := &domain.User{ID: idgen.UUID.NewID(), Email: "old@example.com"}
+	created := &domain.User{Email: "new@example.com"}
+	service := NewUserService(repo, logger.New())
+	assert.NoError(t, service.Create(ctx, old))
+	assert.NoError(t, service.Create(ctx, created))
+
+	// Act
+	lookup, err := service.GetByIDs(ctx, []string{old.ID, created.ID})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, uuid.Version(4), uuid.MustParse(old.ID).Version())
+	assert.Equal(t, uuid.Version(7), uuid.MustParse(created.ID).Version())
+	assert.Len(t, lookup.Found, 2)
+	assert.Empty(t, lookup.Missing)
+}
+
+func TestUserService_GetByIDs_TooMany(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	log := logger.New()
+	service := NewUserService(mockRepo, log)
+	ctx := context.Background()
+
+	ids := make([]string, MaxLookupIDs+1)
+	for i := range ids {
+		ids[i] = uuid.NewString()
+	}
+
+	// Act
+	lookup, err := service.GetByIDs(ctx, ids)
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrTooManyIDs)
+	assert.Nil(t, lookup)
+	mockRepo.AssertNotCalled(t, "GetByIDs", mock.Anything, mock.Anything)
+}
+
+func TestUserService_GetByIDs_CapsDistinctIDs(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	service := NewUserService(mockRepo, logger.New())
+	ctx := context.Background()
+
+	id := "6f1c2a4e-0d6b-4c1e-9a51-0c8a3c1f5b11"
+	ids := make([]string, MaxLookupIDs+1)
+	for i := range ids {
+		ids[i] = id
+	}
+	mockRepo.On("GetByIDs", ctx, []string{id}).Return([]*domain.User{}, nil)
+
+	// Act
+	lookup, err := service.GetByIDs(ctx, ids)
+
+	// Assert: repeated IDs count once towards the cap
+	assert.NoError(t, err)
+	assert.Equal(t, []string{id}, lookup.Missing)
+	mockRepo.AssertExpectations(t)
+}
+
+// collationFixture returns users in the default database order
+func collationFixture() []*domain.User {
+	return []*domain.User{
+		{ID: "1", Name: "Zebra"},
+		{ID: "2", Name: "Ärger"},
+		{ID: "3", Name: "Apfel"},
+		{ID: "4", Name: "Öl"},
+	}
+}
+
+func userNames(users []*domain.User) []string {
+	names := make([]string, len(users))
+	for i, u := range users {
+		names[i] = u.Name
+	}
+	return names
+}
+
+func TestUserService_List_InMemoryCollation(t *testing.T) {
+	tests := []struct {
+		locale   string
+		expected []string
+	}{
+		// German sorts umlauts with their base letters
+		{"de-DE", []string{"Apfel", "Ärger", "Öl", "Zebra"}},
+		// Swedish sorts Ä and Ö as separate letters after Z
+		{"sv-SE", []string{"Apfel", "Zebra", "Ärger", "Öl"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.locale, func(t *testing.T) {
+			// Arrange
+			mockRepo := new(MockUserRepository)
+			service := NewUserService(mockRepo, logger.New())
+			ctx := context.Background()
+
+			// The database lacks the collation, so it returns the default order
+			mockRepo.On("CollationExists", ctx, tt.locale).Return(false, nil).Once()
+			// Every user is fetched to be sorted and paged in memory
+			mockRepo.On("List", ctx, domain.UserListOptions{PageRequest: domain.PageRequest{SortBy: domain.UserSortName}}).
+				Return(&domain.UserPage{Users: collationFixture(), Total: 4}, nil)
+
+			// Act
+			page, err := service.List(ctx, domain.UserListOptions{PageRequest: domain.PageRequest{SortBy: domain.UserSortName}, Collation: tt.locale})
+			// The answer is cached
+			_, err2 := service.List(ctx, domain.UserListOptions{PageRequest: domain.PageRequest{SortBy: domain.UserSortName}, Collation: tt.locale})
+
+			// Assert
+			assert.NoError(t, err)
+			assert.NoError(t, err2)
+			assert.Equal(t, tt.expected, userNames(page.Users))
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUserService_List_DatabaseCollation(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	service := NewUserService(mockRepo, logger.New(), WithDefaultCollation("de-DE"))
+	ctx := context.Background()
+
+	ordered := []*domain.User{{ID: "3", Name: "Apfel"}, {ID: "2", Name: "Ärger"}}
+	mockRepo.On("CollationExists", ctx, "de-DE").Return(true, nil)
+	mockRepo.On("List", ctx, domain.UserListOptions{
+		PageRequest: domain.PageRequest{SortBy: domain.UserSortName, Limit: domain.DefaultMaxPageLimit},
+		Collation:   "de-DE",
+	}).Return(&domain.UserPage{Users: ordered, Total: 2}, nil)
+
+	// Act
+	page, err := service.List(ctx, domain.UserListOptions{PageRequest: domain.PageRequest{SortBy: domain.UserSortName}})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, ordered, page.Users)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUserService_List_InMemoryCollationPage(t *testing.T) {
+	// Arrange
+	mockRepo := new(MockUserRepository)
+	service := NewUserService(mockRepo, logger.New())
+	ctx := context.Background()
+	mockRepo.On("CollationExists", ctx, "de-DE").Return(false, nil)
+	mockRepo.On("List", ctx, domain.UserListOptions{PageRequest: domain.PageRequest{SortBy: domain.UserSortName, SortDir: domain.SortDesc}}).
+		Return(&domain.UserPage{Users: collationFixture(), Total: 4}, nil)
+
+	// Act
+	page, err := service.List(ctx, domain.UserListOptions{
+		PageRequest: domain.PageRequest{SortBy: domain.UserSortName, SortDir: domain.SortDesc, Limit: 2, Offset: 1},
+		Collation:   "de-DE",
+	})
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Öl", "Ärger"}, userNames(page.Users), "paged after sorting")
+	assert.Equal(t, int64(4), page.Total)
+	assert.Equal(t, 2, page.Limit)
+	assert.Equal(t, 1, page.Offset)
+}
+
+func TestUserService_List_PageRequest(t *testing.T) {
+	tests := []struct {
+		name      string
+		page      domain.PageRequest
+		wantLimit int
+		wantErr   bool
+	}{
+		{name: "no limit takes the max", wantLimit: 10},
+		{name: "limit clamped to the max", page: domain.PageRequest{Limit: 50}, wantLimit: 10},
+		{name: "limit below the max", page: domain.PageRequest{Limit: 5}, wantLimit: 5},
+		{name: "unknown sort field", page: domain.PageRequest{SortBy: "password"}, wantErr: true},
+		{name: "unknown direction", page: domain.PageRequest{SortDir: "up"}, wantErr: true},
+		{name: "negative offset", page: domain.PageRequest{Offset: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			mockRepo := new(MockUserRepository)
+			service := NewUserService(mockRepo, logger.New(), WithMaxPageLimit(10))
+			ctx := context.Background()
+			want := tt.page
+			want.Limit = tt.wantLimit
+			mockRepo.On("List", ctx, domain.UserListOptions{PageRequest: want}).Return(&domain.UserPage{}, nil).Maybe()
+
+			// Act
+			_, err := service.List(ctx, domain.UserListOptions{PageRequest: tt.page})
+
+			// Assert
+			if tt.wantErr {
+				assert.ErrorIs(t, err, domain.ErrInvalidInput)
+				mockRepo.AssertNotCalled(t, "List", mock.Anything, mock.Anything)
+				return
+			}
+			require.NoError(t, err)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/sanitize"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+)
+
+// defaultWebhookReplayWindow is used when no replay window is configured
+const defaultWebhookReplayWindow = 5 * time.Minute
+
+// webhookRejectionAuditWindow is how often rejections of a provider from
+// one client are recorded in the audit log
+const webhookRejectionAuditWindow = time.Minute
+
+// WebhookService verifies inbound webhooks and hands them to the worker
+// through the message broker
+type WebhookService struct {
+	providers    map[string]domain.WebhookProvider
+	replayWindow time.Duration
+	publisher    Publisher
+	nonces       cache.Adder
+	audit        domain.AuditRepository
+	rejections   cache.Counter
+	log          *logger.Logger
+	gen          generate.Generators
+}
+
+// NewWebhookService creates a webhook service accepting the given providers.
+// Webhooks signed more than replayWindow away from the time they are
+// received are rejected, and so are signatures already accepted within the
+// window, which are kept in nonces. A nil nonces keeps them in memory,
+// shared by no other replica.
+func NewWebhookService(providers []domain.WebhookProvider, replayWindow time.Duration, publisher Publisher, nonces cache.Adder, audit domain.AuditRepository, log *logger.Logger, opts ...generate.Option) *WebhookService {
+	if replayWindow <= 0 {
+		replayWindow = defaultWebhookReplayWindow
+	}
+	if nonces == nil {
+		nonces = cache.NewMemory()
+	}
+
+	s := &WebhookService{
+		providers:    make(map[string]domain.WebhookProvider, len(providers)),
+		replayWindow: replayWindow,
+		publisher:    publisher,
+		nonces:       nonces,
+		audit:        audit,
+		rejections:   cache.NewMemory(),
+		log:          log,
+		gen:          generate.New(opts...),
+	}
+	for _, p := range providers {
+		if p.SignatureHeader == "" {
+			p.SignatureHeader = domain.DefaultWebhookSignatureHeader
+		}
+		if p.TimestampHeader == "" {
+			p.TimestampHeader = domain.DefaultWebhookTimestampHeader
+		}
+		if p.Algorithm == "" {
+			p.Algorithm = domain.DefaultWebhookAlgorithm
+		}
+		s.providers[p.Name] = p
+	}
+
+	return s
+}
+
+// Receive verifies the signature and timestamp of the webhook and publishes
+// its body as a webhook.<provider> event. Rejected webhooks are recorded in
+// the audit log, once per provider, client and minute.
+func (s *WebhookService) Receive(ctx context.Context, req *domain.WebhookRequest) error {
+	provider, ok := s.providers[req.Provider]
+	if !ok {
+		return domain.ErrWebhookProviderNotFound
+	}
+
+	signature, err := s.verify(provider, req)
+	if err == nil {
+		err = s.claimNonce(ctx, provider, signature)
+	}
+	if err != nil {
+		s.reject(ctx, req, err)
+		return err
+	}
+
+	err = s.publisher.Publish(ctx, &domain.OutboxEvent{
+		ID:        s.gen.IDs.NewID(),
+		Type:      domain.EventWebhookPrefix + provider.Name,
+		Tenant:    tenant.FromContext(ctx),
+		Payload:   req.Body,
+		CreatedAt: req.ReceivedAt,
+	})
+	if err != nil {
+		// The provider retries with the same signature, which must not be
+		// taken for a replay
+		if err := s.nonces.Delete(ctx, nonceKey(provider, signature)); err != nil {
+			s.log.Error("Failed to release webhook nonce", err, map[string]interface{}{"provider": provider.Name})
+		}
+		return err
+	}
+	return nil
+}
+
+// nonceKey is the key of an accepted signature of provider
+func nonceKey(provider domain.WebhookProvider, signature []byte) string {
+	return "webhook:nonce:" + provider.Name + ":" + hex.EncodeToString(signature)
+}
+
+// claimNonce records the signature as accepted and fails with
+// domain.ErrWebhookReplayed when it already was. A timestamp is accepted
+// within the replay window on both sides of the time of receipt, so the
+// signature is kept for twice the window.
+func (s *WebhookService) claimNonce(ctx context.Context, provider domain.WebhookProvider, signature []byte) error {
+	added, err := s.nonces.Add(ctx, nonceKey(provider, signature), []byte("1"), 2*s.replayWindow)
+	if err != nil {
+		// Failing open keeps webhooks flowing when the nonce store is not;
+		// the timestamp still bounds replays to the window
+		s.log.Error("Failed to record webhook nonce", err, map[string]interface{}{"provider": provider.Name})
+		return nil
+	}
+	if !added {
+		return domain.ErrWebhookReplayed
+	}
+	return nil
+}
+
+// verify checks the timestamp against the replay window, then the HMAC of
+// "<timestamp>.<body>", and returns the signature
+func (s *WebhookService) verify(provider domain.WebhookProvider, req *domain.WebhookRequest) ([]byte, error) {
+	rawTimestamp := req.Header(provider.TimestampHeader)
+	unix, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: missing or malformed %s", domain.ErrWebhookSignatureInvalid, provider.TimestampHeader)
+	}
+
+	skew := req.ReceivedAt.Sub(time.Unix(unix, 0))
+	if skew > s.replayWindow || skew < -s.replayWindow {
+		return nil, fmt.Errorf("%w: signed %s ago", domain.ErrWebhookStale, skew.Round(time.Second))
+	}
+
+	newHash, ok := domain.WebhookAlgorithms[provider.Algorithm]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", domain.ErrWebhookSignatureInvalid, provider.Algorithm)
+	}
+
+	signature := strings.TrimPrefix(req.Header(provider.SignatureHeader), provider.Algorithm+"=")
+	got, err := hex.DecodeString(signature)
+	if err != nil || len(got) == 0 {
+		return nil, fmt.Errorf("%w: missing or malformed %s", domain.ErrWebhookSignatureInvalid, provider.SignatureHeader)
+	}
+
+	mac := hmac.New(newHash, []byte(provider.Secret))
+	mac.Write([]byte(rawTimestamp))
+	mac.Write([]byte("."))
+	mac.Write(req.Body)
+	if !hmac.Equal(got, mac.Sum(nil)) {
+		return nil, domain.ErrWebhookSignatureInvalid
+	}
+
+	return got, nil
+}
+
+// reject logs the rejected webhook and records it in the audit log. Only
+// the first rejection of a provider from a client within
+// webhookRejectionAuditWindow is reported, a client sending forged
+// webhooks would otherwise flood the audit log.
+func (s *WebhookService) reject(ctx context.Context, req *domain.WebhookRequest, reason error) {
+	fields := map[string]interface{}{
+		"provider":    req.Provider,
+		"remote_addr": req.RemoteAddr,
+		"error":       reason.Error(),
+	}
+	count, err := s.rejections.Incr(ctx, "webhook:rejected:"+req.Provider+":"+remoteHost(req.RemoteAddr), webhookRejectionAuditWindow)
+	if err == nil && count > 1 {
+		s.log.Debug("Rejected webhook", fields)
+		return
+	}
+	s.log.Warn("Rejected webhook", fields)
+
+	details, _ := json.Marshal(map[string]string{
+		"reason":      sanitize.String(reason.Error()),
+		"remote_addr": req.RemoteAddr,
+	})
+	entry := &domain.AuditEntry{
+		Action:    domain.AuditWebhookRejected,
+		Entity:    domain.AuditEntityWebhook,
+		EntityID:  req.Provider,
+		ActorID:   ActorOf(ctx),
+		Details:   details,
+		CreatedAt: req.ReceivedAt,
+	}
+	if err := s.audit.Create(ctx, entry); err != nil {
+		s.log.Error("Failed to record rejected webhook", err, map[string]interface{}{"provider": req.Provider})
+	}
+}
+
+// remoteHost strips the port from addr, which changes with every connection
+func remoteHost(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
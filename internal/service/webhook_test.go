@@ -0,0 +1,199 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/idgen"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+)
+
+// recordingPublisher keeps published events
+type recordingPublisher struct {
+	events []*domain.OutboxEvent
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event *domain.OutboxEvent) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+// signWebhook builds a request signed like a provider with the default headers
+func signWebhook(secret string, signedAt, receivedAt time.Time, body string) *domain.WebhookRequest {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + body))
+
+	header := http.Header{}
+	header.Set(domain.DefaultWebhookTimestampHeader, timestamp)
+	header.Set(domain.DefaultWebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	return &domain.WebhookRequest{
+		Provider:   "stripe",
+		Header:     header.Get,
+		Body:       []byte(body),
+		RemoteAddr: "203.0.113.7:443",
+		ReceivedAt: receivedAt,
+	}
+}
+
+func newTestWebhookService() (*WebhookService, *recordingPublisher, *memoryAuditRepository) {
+	publisher := &recordingPublisher{}
+	audit := newMemoryAuditRepository()
+	providers := []domain.WebhookProvider{{Name: "stripe", Secret: "whsec"}}
+	service := NewWebhookService(providers, 5*time.Minute, publisher, nil, audit, logger.New(), generate.WithIDGenerator(idgen.NewSequence()))
+	return service, publisher, audit
+}
+
+func TestWebhookService_Receive_Publishes(t *testing.T) {
+	// Arrange
+	service, publisher, audit := newTestWebhookService()
+	now := time.Now()
+	req := signWebhook("whsec", now.Add(-time.Minute), now, `{"type":"charge.succeeded"}`)
+
+	// Act
+	err := service.Receive(tenant.WithTenant(context.Background(), "acme"), req)
+
+	// Assert: the event carries the tenant of the request
+	require.NoError(t, err)
+	require.Len(t, publisher.events, 1)
+	assert.Equal(t, &domain.OutboxEvent{
+		ID:        idgen.SequenceID(1),
+		Type:      "webhook.stripe",
+		Tenant:    "acme",
+		Payload:   []byte(`{"type":"charge.succeeded"}`),
+		CreatedAt: now,
+	}, publisher.events[0])
+	assert.Empty(t, audit.entries)
+}
+
+func TestWebhookService_Receive_Rejects(t *testing.T) {
+	now := time.Now()
+	body := `{"type":"charge.succeeded"}`
+
+	tests := []struct {
+		name string
+		req  *domain.WebhookRequest
+		want error
+	}{
+		{
+			name: "wrong secret",
+			req:  signWebhook("other", now, now, body),
+			want: domain.ErrWebhookSignatureInvalid,
+		},
+		{
+			name: "tampered body",
+			req: func() *domain.WebhookRequest {
+				req := signWebhook("whsec", now, now, body)
+				req.Body = []byte(`{"type":"charge.refunded"}`)
+				return req
+			}(),
+			want: domain.ErrWebhookSignatureInvalid,
+		},
+		{
+			name: "missing signature",
+			req: func() *domain.WebhookRequest {
+				req := signWebhook("whsec", now, now, body)
+				header := http.Header{}
+				header.Set(domain.DefaultWebhookTimestampHeader, req.Header(domain.DefaultWebhookTimestampHeader))
+				req.Header = header.Get
+				return req
+			}(),
+			want: domain.ErrWebhookSignatureInvalid,
+		},
+		{
+			name: "replayed",
+			req:  signWebhook("whsec", now.Add(-10*time.Minute), now, body),
+			want: domain.ErrWebhookStale,
+		},
+		{
+			name: "from the future",
+			req:  signWebhook("whsec", now.Add(10*time.Minute), now, body),
+			want: domain.ErrWebhookStale,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			service, publisher, audit := newTestWebhookService()
+
+			// Act
+			err := service.Receive(context.Background(), tt.req)
+
+			// Assert
+			assert.ErrorIs(t, err, tt.want)
+			assert.Empty(t, publisher.events)
+			require.Len(t, audit.entries, 1)
+			assert.Equal(t, domain.AuditWebhookRejected, audit.entries[0].Action)
+			assert.Equal(t, "stripe", audit.entries[0].EntityID)
+			assert.Contains(t, string(audit.entries[0].Details), "203.0.113.7:443")
+		})
+	}
+}
+
+func TestWebhookService_Receive_UnknownProvider(t *testing.T) {
+	// Arrange
+	service, publisher, audit := newTestWebhookService()
+	now := time.Now()
+	req := signWebhook("whsec", now, now, `{}`)
+	req.Provider = "acme"
+
+	// Act
+	err := service.Receive(context.Background(), req)
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrWebhookProviderNotFound)
+	assert.Empty(t, publisher.events)
+	assert.Empty(t, audit.entries)
+}
+
+func TestWebhookService_Receive_RejectsReplayedSignature(t *testing.T) {
+	// Arrange
+	service, publisher, audit := newTestWebhookService()
+	now := time.Now()
+	req := signWebhook("whsec", now, now, `{"type":"charge.succeeded"}`)
+	require.NoError(t, service.Receive(context.Background(), req))
+
+	// Act: the same delivery is sent again within the replay window
+	err := service.Receive(context.Background(), signWebhook("whsec", now, now.Add(time.Minute), `{"type":"charge.succeeded"}`))
+
+	// Assert
+	assert.ErrorIs(t, err, domain.ErrWebhookReplayed)
+	assert.Len(t, publisher.events, 1)
+	require.Len(t, audit.entries, 1)
+	assert.Contains(t, string(audit.entries[0].Details), "already received")
+}
+
+func TestWebhookService_Receive_AuditsRejectionsOncePerClient(t *testing.T) {
+	// Arrange
+	service, _, audit := newTestWebhookService()
+	now := time.Now()
+	other := signWebhook("other", now, now, `{}`)
+	other.RemoteAddr = "198.51.100.1:443"
+
+	// Act: one client keeps sending forged webhooks from new ports
+	for port := 1000; port < 1010; port++ {
+		req := signWebhook("other", now, now, `{}`)
+		req.RemoteAddr = "203.0.113.7:" + strconv.Itoa(port)
+		assert.ErrorIs(t, service.Receive(context.Background(), req), domain.ErrWebhookSignatureInvalid)
+	}
+	assert.ErrorIs(t, service.Receive(context.Background(), other), domain.ErrWebhookSignatureInvalid)
+
+	// Assert: every rejection failed, but each client was audited once
+	require.Len(t, audit.entries, 2)
+	assert.Contains(t, string(audit.entries[0].Details), "203.0.113.7:1000")
+	assert.Contains(t, string(audit.entries[1].Details), "198.51.100.1:443")
+}
@@ -0,0 +1,117 @@
+// Package accesslog defines the access log entry both transports write for
+// every request, so that an HTTP request and a gRPC call doing the same
+// thing are logged with the same fields and can be searched together.
+package accesslog
+
+import (
+	"net"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// Message is the message of access log entries
+const Message = "Request"
+
+// RequestIDHeader carries the ID of a request, taken from the client when it
+// sends a valid one and generated otherwise. It is echoed in the response,
+// as a header over HTTP and as header metadata over gRPC, where metadata
+// keys are lowercase.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest request ID accepted from a client
+const maxRequestIDLength = 128
+
+// Fields are the fields of every access log entry
+var Fields = []string{
+	"transport", "method", "route", "status", "outcome", "duration_ms",
+	"peer", "request_id", "user", "user_agent", "bytes_in", "bytes_out",
+}
+
+// Entry is a request as written to the access log
+type Entry struct {
+	// Transport is http or grpc
+	Transport string
+	// Method is the HTTP method, POST for gRPC calls
+	Method string
+	// Route is the route pattern of HTTP requests, or their path when no
+	// route matched, and the full method of gRPC calls
+	Route string
+	// Status is the HTTP status, or the gRPC code
+	Status int
+	// Outcome classifies the status alike for both transports, see errmap
+	Outcome   string
+	Duration  time.Duration
+	Peer      string
+	RequestID string
+	// User is the principal the request is attributed to
+	User      string
+	UserAgent string
+	BytesIn   int64
+	BytesOut  int64
+}
+
+// Log writes e to log
+func (e Entry) Log(log *logger.Logger) {
+	log.Info(Message, e.fields())
+}
+
+// Debug writes e to log at debug level, for requests too frequent to log
+// at info level such as probes
+func (e Entry) Debug(log *logger.Logger) {
+	log.Debug(Message, e.fields())
+}
+
+func (e Entry) fields() map[string]interface{} {
+	return map[string]interface{}{
+		"transport":   e.Transport,
+		"method":      e.Method,
+		"route":       e.Route,
+		"status":      e.Status,
+		"outcome":     e.Outcome,
+		"duration_ms": e.Duration.Milliseconds(),
+		"peer":        e.Peer,
+		"request_id":  e.RequestID,
+		"user":        e.User,
+		"user_agent":  e.UserAgent,
+		"bytes_in":    e.BytesIn,
+		"bytes_out":   e.BytesOut,
+	}
+}
+
+// RequestID returns the request ID sent by a client when it is valid, a
+// new one otherwise. Valid IDs are up to 128 printable ASCII characters,
+// so that a client cannot inject arbitrary content into the logs.
+func RequestID(sent string) string {
+	if sent == "" || len(sent) > maxRequestIDLength {
+		return uuid.NewString()
+	}
+	for i := 0; i < len(sent); i++ {
+		if sent[i] < '!' || sent[i] > '~' {
+			return uuid.NewString()
+		}
+	}
+	return sent
+}
+
+// ClientHost returns the host of a client address, addr itself when it has
+// no port
+func ClientHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// ClientPrincipal identifies a caller that is not authenticated by the
+// host of its address, alike for both transports. It is empty when the
+// address is unknown.
+func ClientPrincipal(addr string) string {
+	if addr == "" {
+		return ""
+	}
+	return "client:" + ClientHost(addr)
+}
@@ -0,0 +1,98 @@
+package accesslog
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name string
+		sent string
+		keep bool
+	}{
+		{name: "sent by the client", sent: "req-7f3a/01", keep: true},
+		{name: "missing", sent: ""},
+		{name: "too long", sent: strings.Repeat("a", 129)},
+		{name: "spaces", sent: "req 1"},
+		{name: "line break", sent: "req\n{\"level\":\"error\"}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := RequestID(tt.sent)
+
+			if tt.keep {
+				assert.Equal(t, tt.sent, id)
+				return
+			}
+			_, err := uuid.Parse(id)
+			assert.NoError(t, err, "a new ID is generated")
+		})
+	}
+}
+
+func TestClientPrincipal(t *testing.T) {
+	tests := []struct {
+		name string
+		addr string
+		want string
+	}{
+		{name: "host and port", addr: "203.0.113.7:51234", want: "client:203.0.113.7"},
+		{name: "IPv6 host and port", addr: "[2001:db8::1]:51234", want: "client:2001:db8::1"},
+		{name: "bare host", addr: "203.0.113.7", want: "client:203.0.113.7"},
+		{name: "unknown", addr: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ClientPrincipal(tt.addr))
+		})
+	}
+}
+
+func TestEntry_Log(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	log := logger.New(logger.WithOutput(&logs))
+
+	// Act
+	Entry{Transport: "http", Method: "GET", Route: "GET /api/v1/users/{id}", Status: 200, Duration: 3 * time.Millisecond}.Log(log)
+
+	// Assert
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+	assert.Equal(t, Message, line["message"])
+	for _, field := range Fields {
+		assert.Contains(t, line, field, "every field is written, even when empty")
+	}
+	assert.Equal(t, 3.0, line["duration_ms"])
+}
+
+func TestEntry_Debug(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	log := logger.New(logger.WithOutput(&logs), logger.WithLevelString("debug"))
+
+	// Act
+	Entry{Transport: "http", Method: "GET", Route: "GET /readyz", Status: 200, BytesOut: 16}.Debug(log)
+
+	// Assert
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+	assert.Equal(t, "debug", line["level"])
+	assert.Equal(t, Message, line["message"])
+	for _, field := range Fields {
+		assert.Contains(t, line, field)
+	}
+	assert.Equal(t, 16.0, line["bytes_out"])
+}
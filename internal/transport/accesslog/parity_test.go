@@ -0,0 +1,146 @@
+package accesslog_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"maps"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+	"github.com/romanitalian/carch-go/internal/transport/accesslog"
+	grpcTransport "github.com/romanitalian/carch-go/internal/transport/grpc"
+	httpTransport "github.com/romanitalian/carch-go/internal/transport/http"
+	userv1 "github.com/romanitalian/carch-go/pkg/api/user/v1"
+)
+
+// lookupUsers finds user-1 and misses the other IDs
+type lookupUsers struct {
+	service.UserServiceInterface
+}
+
+func (lookupUsers) GetByIDs(ctx context.Context, ids []string) (*domain.UserLookup, error) {
+	return &domain.UserLookup{
+		Found:   map[string]*domain.User{"user-1": {ID: "user-1", Email: "ada@example.com"}},
+		Missing: ids[1:],
+	}, nil
+}
+
+// syncBuffer is written by the server goroutines and read by the test
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// accessEntries returns the access log entries written to logs
+func (b *syncBuffer) accessEntries(t *testing.T) []map[string]interface{} {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(bytes.NewReader(b.buf.Bytes()))
+	for scanner.Scan() {
+		var line map[string]interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &line))
+		if line["message"] == accesslog.Message {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// fieldsOf returns the names of the fields of an entry, without the ones
+// every log line has
+func fieldsOf(entry map[string]interface{}) []string {
+	fields := slices.Collect(maps.Keys(entry))
+	fields = slices.DeleteFunc(fields, func(f string) bool {
+		return f == "level" || f == "message" || f == "time" || f == "caller"
+	})
+	slices.Sort(fields)
+	return fields
+}
+
+func TestAccessLog_SameFieldsOnBothTransports(t *testing.T) {
+	// Arrange
+	var logs syncBuffer
+	log := logger.New(logger.WithOutput(&logs))
+	services := &service.Services{User: lookupUsers{}, Log: log}
+
+	handler := httpTransport.NewHandler(services, log)
+	listener := bufconn.Listen(1 << 20)
+	server := grpcTransport.NewServer("bufnet", services, log)
+	go server.Run(listener)
+	defer server.Shutdown(context.Background())
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return listener.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUserAgent("parity-test"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Act: the same lookup over both transports
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users/lookup", strings.NewReader(`{"ids":["user-1","user-2"]}`))
+	req.Header.Set(accesslog.RequestIDHeader, "req-http")
+	req.Header.Set("User-Agent", "parity-test")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	var header metadata.MD
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "req-grpc")
+	_, err = userv1.NewUserServiceClient(conn).BatchGetUsers(ctx,
+		&userv1.BatchGetUsersRequest{Ids: []string{"user-1", "user-2"}}, grpc.Header(&header))
+
+	// Assert
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	require.NoError(t, err)
+	assert.Equal(t, "req-http", rr.Header().Get(accesslog.RequestIDHeader))
+	assert.Equal(t, []string{"req-grpc"}, header.Get("x-request-id"))
+
+	entries := logs.accessEntries(t)
+	require.Len(t, entries, 2)
+	httpEntry, grpcEntry := entries[0], entries[1]
+	assert.Equal(t, fieldsOf(httpEntry), fieldsOf(grpcEntry))
+	assert.ElementsMatch(t, accesslog.Fields, fieldsOf(httpEntry))
+
+	assert.Equal(t, "http", httpEntry["transport"])
+	assert.Equal(t, "POST /api/v1/users/lookup", httpEntry["route"])
+	assert.Equal(t, 200.0, httpEntry["status"])
+	assert.Equal(t, "req-http", httpEntry["request_id"])
+	assert.Equal(t, "parity-test", httpEntry["user_agent"])
+
+	assert.Equal(t, "grpc", grpcEntry["transport"])
+	assert.Equal(t, "POST", grpcEntry["method"])
+	assert.Equal(t, "/carch.user.v1.UserService/BatchGetUsers", grpcEntry["route"])
+	assert.Equal(t, 0.0, grpcEntry["status"])
+	assert.Equal(t, "req-grpc", grpcEntry["request_id"])
+	assert.Contains(t, grpcEntry["user_agent"], "parity-test")
+
+	assert.Equal(t, httpEntry["outcome"], grpcEntry["outcome"])
+	assert.Greater(t, httpEntry["bytes_in"], 0.0)
+	assert.Greater(t, grpcEntry["bytes_in"], 0.0)
+	assert.Greater(t, httpEntry["bytes_out"], 0.0)
+	assert.Greater(t, grpcEntry["bytes_out"], 0.0)
+}
@@ -0,0 +1,106 @@
+package errmap
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+// Mapping describes how a domain error is exposed by every transport
+type Mapping struct {
+	HTTPStatus int
+	GRPCCode   codes.Code
+	// Code is the stable machine-readable error code returned to clients
+	Code string
+	// Message is the public message returned to clients
+	Message string
+}
+
+// Transport-level errors that do not originate from the domain
+var (
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	ErrOverloaded           = errors.New("service overloaded")
+	ErrConcurrencyLimit     = errors.New("concurrency limit exceeded")
+	ErrRateLimited          = errors.New("rate limit exceeded")
+)
+
+type entry struct {
+	err     error
+	mapping Mapping
+}
+
+// registry lists every domain error with its transport mapping.
+// Entries are matched with errors.Is in order, so wrapped errors are supported.
+var registry = []entry{
+	{domain.ErrUserNotFound, Mapping{http.StatusNotFound, codes.NotFound, "user_not_found", "user not found"}},
+	{domain.ErrInvalidInput, Mapping{http.StatusBadRequest, codes.InvalidArgument, "invalid_input", "invalid input"}},
+	{domain.ErrInvalidPatch, Mapping{http.StatusUnprocessableEntity, codes.InvalidArgument, "invalid_patch", "invalid patch"}},
+	{domain.ErrTooManyIDs, Mapping{http.StatusBadRequest, codes.InvalidArgument, "too_many_ids", "too many ids requested"}},
+	{domain.ErrTooManyChanges, Mapping{http.StatusTooManyRequests, codes.ResourceExhausted, "too_many_changes", "too many changes to the user, retry later"}},
+	{domain.ErrConflict, Mapping{http.StatusConflict, codes.AlreadyExists, "conflict", "conflicts with an existing record"}},
+	{domain.ErrInvalidReference, Mapping{http.StatusUnprocessableEntity, codes.FailedPrecondition, "invalid_reference", "references a record that does not exist"}},
+	{domain.ErrBulkDeleteTooLarge, Mapping{http.StatusUnprocessableEntity, codes.FailedPrecondition, "bulk_delete_too_large", "bulk delete matches more users than allowed, force it to proceed"}},
+	{domain.ErrImportTooLarge, Mapping{http.StatusRequestEntityTooLarge, codes.InvalidArgument, "import_too_large", "import file too large"}},
+	{domain.ErrImportStagingFull, Mapping{http.StatusServiceUnavailable, codes.Unavailable, "import_staging_full", "import staging is full, retry later"}},
+	{domain.ErrJobNotFound, Mapping{http.StatusNotFound, codes.NotFound, "job_not_found", "job not found"}},
+	{domain.ErrEmailTaken, Mapping{http.StatusConflict, codes.AlreadyExists, "email_taken", "email already in use"}},
+	{domain.ErrEmailChangeNotFound, Mapping{http.StatusNotFound, codes.NotFound, "email_change_not_found", "email change request not found"}},
+	{domain.ErrEmailChangeExpired, Mapping{http.StatusGone, codes.FailedPrecondition, "email_change_expired", "email change request expired"}},
+	{domain.ErrEmailChangeRequiresConfirm, Mapping{http.StatusConflict, codes.FailedPrecondition, "email_change_requires_confirmation", "email must be changed through the confirmation flow"}},
+	{domain.ErrWebhookProviderNotFound, Mapping{http.StatusNotFound, codes.NotFound, "webhook_provider_not_found", "webhook provider not found"}},
+	{domain.ErrWebhookSignatureInvalid, Mapping{http.StatusUnauthorized, codes.Unauthenticated, "invalid_signature", "invalid webhook signature"}},
+	{domain.ErrWebhookStale, Mapping{http.StatusUnauthorized, codes.Unauthenticated, "stale_webhook", "webhook timestamp outside the replay window"}},
+	{domain.ErrWebhookReplayed, Mapping{http.StatusUnauthorized, codes.Unauthenticated, "replayed_webhook", "webhook already received"}},
+
+	{ErrUnsupportedMediaType, Mapping{http.StatusUnsupportedMediaType, codes.InvalidArgument, "unsupported_media_type", "unsupported media type"}},
+	{ErrConcurrencyLimit, Mapping{http.StatusTooManyRequests, codes.ResourceExhausted, "concurrency_limit_exceeded", "too many concurrent requests"}},
+	{ErrRateLimited, Mapping{http.StatusTooManyRequests, codes.ResourceExhausted, "rate_limited", "too many requests, retry later"}},
+	{ErrOverloaded, Mapping{http.StatusServiceUnavailable, codes.Unavailable, "overloaded", "service is overloaded, retry later"}},
+}
+
+// Internal is the mapping used for errors without a registered mapping
+var Internal = Mapping{http.StatusInternalServerError, codes.Internal, "internal", "internal server error"}
+
+// StatusClientClosedRequest is the nginx convention for requests abandoned by the client
+const StatusClientClosedRequest = 499
+
+// Mappings of requests that ended because their own context did
+var (
+	Canceled         = Mapping{StatusClientClosedRequest, codes.Canceled, "canceled", "request canceled"}
+	DeadlineExceeded = Mapping{http.StatusGatewayTimeout, codes.DeadlineExceeded, "deadline_exceeded", "request deadline exceeded"}
+)
+
+// Resolve returns the mapping of err for the request whose context is ctx.
+// Cancellation and deadline errors are attributed to the request only if its
+// own context ended; from any other context they are server faults.
+func Resolve(ctx context.Context, err error) (Mapping, bool) {
+	switch {
+	case errors.Is(err, context.Canceled) && errors.Is(ctx.Err(), context.Canceled):
+		return Canceled, true
+	case errors.Is(err, context.DeadlineExceeded) && errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return DeadlineExceeded, true
+	}
+	return Lookup(err)
+}
+
+// Lookup returns the mapping registered for err
+func Lookup(err error) (Mapping, bool) {
+	for _, e := range registry {
+		if errors.Is(err, e.err) {
+			return e.mapping, true
+		}
+	}
+	return Mapping{}, false
+}
+
+// Map returns the mapping registered for err or Internal if there is none
+func Map(err error) Mapping {
+	if m, ok := Lookup(err); ok {
+		return m
+	}
+	return Internal
+}
@@ -0,0 +1,133 @@
+package errmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	iofs "io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+// TestRegistry_CoversDomainErrors fails when an exported error is added to
+// internal/domain without a transport mapping
+func TestRegistry_CoversDomainErrors(t *testing.T) {
+	// Arrange
+	messages := domainErrorMessages(t)
+	require.NotEmpty(t, messages)
+
+	registered := make(map[string]bool)
+	for _, e := range registry {
+		registered[e.err.Error()] = true
+	}
+
+	// Assert
+	for name, msg := range messages {
+		assert.True(t, registered[msg], "domain.%s has no mapping in errmap", name)
+	}
+}
+
+func TestMap(t *testing.T) {
+	// Act & Assert
+	m := Map(domain.ErrUserNotFound)
+	assert.Equal(t, http.StatusNotFound, m.HTTPStatus)
+	assert.Equal(t, codes.NotFound, m.GRPCCode)
+
+	wrapped := Map(fmt.Errorf("decode body: %w", domain.ErrInvalidInput))
+	assert.Equal(t, http.StatusBadRequest, wrapped.HTTPStatus)
+
+	unknown := Map(errors.New("connection reset"))
+	assert.Equal(t, Internal, unknown)
+}
+
+func TestResolve(t *testing.T) {
+	// Arrange
+	live := context.Background()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want Mapping
+		ok   bool
+	}{
+		{"client canceled", canceled, fmt.Errorf("query: %w", context.Canceled), Canceled, true},
+		{"request deadline", expired, context.DeadlineExceeded, DeadlineExceeded, true},
+		{"canceled elsewhere", live, context.Canceled, Mapping{}, false},
+		{"internal deadline", live, context.DeadlineExceeded, Mapping{}, false},
+		{"domain error on canceled request", canceled, domain.ErrUserNotFound, Map(domain.ErrUserNotFound), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			m, ok := Resolve(tt.ctx, tt.err)
+
+			// Assert
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, m)
+		})
+	}
+}
+
+// domainErrorMessages returns the messages of exported Err* variables
+// declared with errors.New in internal/domain, keyed by variable name
+func domainErrorMessages(t *testing.T) map[string]string {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, "../../domain", func(fi iofs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	require.NoError(t, err)
+
+	messages := make(map[string]string)
+	for _, pkg := range pkgs {
+		for _, file := range pkg.Files {
+			for _, decl := range file.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.VAR {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					vs := spec.(*ast.ValueSpec)
+					for i, name := range vs.Names {
+						if !name.IsExported() || !strings.HasPrefix(name.Name, "Err") {
+							continue
+						}
+						require.Less(t, i, len(vs.Values), "domain.%s must be initialized", name.Name)
+						messages[name.Name] = errorsNewMessage(t, name.Name, vs.Values[i])
+					}
+				}
+			}
+		}
+	}
+	return messages
+}
+
+func errorsNewMessage(t *testing.T, name string, expr ast.Expr) string {
+	call, ok := expr.(*ast.CallExpr)
+	require.True(t, ok, "domain.%s must be declared with errors.New", name)
+	require.Len(t, call.Args, 1, "domain.%s must be declared with errors.New", name)
+
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	require.True(t, ok, "domain.%s must use a string literal message", name)
+
+	msg, err := strconv.Unquote(lit.Value)
+	require.NoError(t, err)
+	return msg
+}
@@ -0,0 +1,40 @@
+package errmap
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// HTTPOutcome classifies a response by its status
+func HTTPOutcome(status int) metrics.Outcome {
+	switch {
+	case status == StatusClientClosedRequest:
+		return metrics.OutcomeCancelled
+	case status >= http.StatusInternalServerError:
+		return metrics.OutcomeServerError
+	case status >= http.StatusBadRequest:
+		return metrics.OutcomeClientError
+	default:
+		return metrics.OutcomeSuccess
+	}
+}
+
+// GRPCOutcome classifies a response by its code, with the codes of the
+// registry's client errors as client errors. Deadlines are the service's,
+// since they are set for the request's whole path.
+func GRPCOutcome(code codes.Code) metrics.Outcome {
+	switch code {
+	case codes.OK:
+		return metrics.OutcomeSuccess
+	case codes.Canceled:
+		return metrics.OutcomeCancelled
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.Unauthenticated, codes.FailedPrecondition, codes.OutOfRange, codes.ResourceExhausted:
+		return metrics.OutcomeClientError
+	default:
+		return metrics.OutcomeServerError
+	}
+}
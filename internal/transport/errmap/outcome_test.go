@@ -0,0 +1,72 @@
+package errmap
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+func TestHTTPOutcome(t *testing.T) {
+	tests := []struct {
+		status int
+		want   metrics.Outcome
+	}{
+		{status: http.StatusOK, want: metrics.OutcomeSuccess},
+		{status: http.StatusAccepted, want: metrics.OutcomeSuccess},
+		{status: http.StatusPermanentRedirect, want: metrics.OutcomeSuccess},
+		{status: http.StatusBadRequest, want: metrics.OutcomeClientError},
+		{status: http.StatusNotFound, want: metrics.OutcomeClientError},
+		{status: http.StatusTooManyRequests, want: metrics.OutcomeClientError},
+		{status: StatusClientClosedRequest, want: metrics.OutcomeCancelled},
+		{status: http.StatusInternalServerError, want: metrics.OutcomeServerError},
+		{status: http.StatusServiceUnavailable, want: metrics.OutcomeServerError},
+		{status: http.StatusGatewayTimeout, want: metrics.OutcomeServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.status), func(t *testing.T) {
+			assert.Equal(t, tt.want, HTTPOutcome(tt.status))
+		})
+	}
+}
+
+func TestGRPCOutcome(t *testing.T) {
+	tests := []struct {
+		code codes.Code
+		want metrics.Outcome
+	}{
+		{code: codes.OK, want: metrics.OutcomeSuccess},
+		{code: codes.InvalidArgument, want: metrics.OutcomeClientError},
+		{code: codes.NotFound, want: metrics.OutcomeClientError},
+		{code: codes.ResourceExhausted, want: metrics.OutcomeClientError},
+		{code: codes.Canceled, want: metrics.OutcomeCancelled},
+		{code: codes.Internal, want: metrics.OutcomeServerError},
+		{code: codes.Unavailable, want: metrics.OutcomeServerError},
+		{code: codes.DeadlineExceeded, want: metrics.OutcomeServerError},
+		{code: codes.Unknown, want: metrics.OutcomeServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			assert.Equal(t, tt.want, GRPCOutcome(tt.code))
+		})
+	}
+}
+
+// TestOutcome_SameAcrossTransports fails when a mapping would count against
+// the error budget on one transport only
+func TestOutcome_SameAcrossTransports(t *testing.T) {
+	mappings := []Mapping{Internal, Canceled, DeadlineExceeded}
+	for _, e := range registry {
+		mappings = append(mappings, e.mapping)
+	}
+
+	for _, m := range mappings {
+		assert.Equal(t, HTTPOutcome(m.HTTPStatus), GRPCOutcome(m.GRPCCode), "mapping %s", m.Code)
+	}
+}
@@ -3,30 +3,112 @@ package grpc
 import (
 	"context"
 	"net"
+	"strings"
+	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
 	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/pkg/querybudget"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+	"github.com/romanitalian/carch-go/internal/pkg/timing"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
 	"github.com/romanitalian/carch-go/internal/service"
+	"github.com/romanitalian/carch-go/internal/transport/accesslog"
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+	userv1 "github.com/romanitalian/carch-go/pkg/api/user/v1"
 )
 
 type Server struct {
+	userv1.UnimplementedUserServiceServer
+
 	services *service.Services
 	server   *grpc.Server
 	addr     string
 	log      *logger.Logger
+
+	statementBudget       int
+	statementBudgetStrict bool
+	wideEvents            *wideevent.Emitter
+	maxMetadataSize       int
+	creds                 credentials.TransportCredentials
+}
+
+// DefaultMaxMetadataSize is the metadata size cap of calls, see WithMaxMetadataSize
+const DefaultMaxMetadataSize = 16 << 10
+
+// metadataEntryOverhead is what each metadata entry counts on top of its
+// key and value, as in the header list size of HTTP/2
+const metadataEntryOverhead = 32
+
+// Option is a function that configures a Server
+type Option func(*Server)
+
+// WithStatementBudget sets the per-call database statement budget,
+// see the HTTP transport option of the same name
+func WithStatementBudget(limit int, strict bool) Option {
+	return func(s *Server) {
+		s.statementBudget = limit
+		s.statementBudgetStrict = strict
+	}
+}
+
+// WithMaxMetadataSize rejects calls whose metadata is larger than n bytes
+// with ResourceExhausted, before their handler runs. Metadata four times
+// larger is dropped by the transport before it is read in full.
+func WithMaxMetadataSize(n int) Option {
+	return func(s *Server) {
+		if n > 0 {
+			s.maxMetadataSize = n
+		}
+	}
+}
+
+// WithTLS serves the calls over TLS with creds, such as those of
+// credentials.NewServerTLSFromFile. Without it the server is plaintext.
+func WithTLS(creds credentials.TransportCredentials) Option {
+	return func(s *Server) {
+		s.creds = creds
+	}
 }
 
-func NewServer(addr string, services *service.Services, log *logger.Logger) *Server {
+// WithWideEvents emits a wide event for every sampled call, nil disables them
+func WithWideEvents(emitter *wideevent.Emitter) Option {
+	return func(s *Server) {
+		s.wideEvents = emitter
+	}
+}
+
+func NewServer(addr string, services *service.Services, log *logger.Logger, opts ...Option) *Server {
 	s := &Server{
-		addr:     addr,
-		services: services,
-		server:   grpc.NewServer(),
-		log:      log,
+		addr:            addr,
+		services:        services,
+		log:             log,
+		maxMetadataSize: DefaultMaxMetadataSize,
+	}
+
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	// Registration of gRPC services
-	// pb.RegisterUserServiceServer(s.server, s)
+	serverOpts := []grpc.ServerOption{
+		grpc.MaxHeaderListSize(uint32(4 * s.maxMetadataSize)),
+		grpc.ChainUnaryInterceptor(s.logCall, s.emitWideEvent, s.observeOutcome, s.limitMetadata, s.mapErrors, s.trackStatements, s.reportStaleness),
+	}
+	if s.creds != nil {
+		serverOpts = append(serverOpts, grpc.Creds(s.creds))
+	}
+	s.server = grpc.NewServer(serverOpts...)
+
+	userv1.RegisterUserServiceServer(s.server, s)
 	log.Info("gRPC server initialized", map[string]interface{}{"address": addr})
 
 	return s
@@ -52,6 +134,227 @@ func (s *Server) Run(listener ...net.Listener) error {
 	return s.server.Serve(l)
 }
 
+// requestIDKey is the metadata key of accesslog.RequestIDHeader
+var requestIDKey = strings.ToLower(accesslog.RequestIDHeader)
+
+// logCall writes the access log entry of a call, with the fields of the
+// HTTP one, see accesslog. It runs outside mapErrors to log the status sent
+// to the client.
+func (s *Server) logCall(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	requestID := accesslog.RequestID(firstValue(md, requestIDKey))
+	// Fails only for calls not served over a stream, such as in tests
+	_ = grpc.SetHeader(ctx, metadata.Pairs(requestIDKey, requestID))
+	// Services and repositories log with the fields of the call
+	ctx = logger.WithContext(ctx, s.log.WithFields(map[string]interface{}{
+		"request_id": requestID,
+		"method":     "POST",
+		"route":      info.FullMethod,
+	}))
+	// Audit entries recorded by the call name its principal as their actor
+	ctx = service.WithActor(ctx, clientPrincipal(ctx))
+
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	accesslog.Entry{
+		Transport: "grpc",
+		Method:    "POST",
+		Route:     info.FullMethod,
+		Status:    int(code),
+		Outcome:   string(errmap.GRPCOutcome(code)),
+		Duration:  time.Since(start),
+		Peer:      peerAddr(ctx),
+		RequestID: requestID,
+		User:      clientPrincipal(ctx),
+		UserAgent: firstValue(md, "user-agent"),
+		BytesIn:   messageSize(req),
+		BytesOut:  messageSize(resp),
+	}.Log(s.log)
+
+	return resp, err
+}
+
+// firstValue returns the first value of a metadata key, empty when unset
+func firstValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// limitMetadata rejects calls whose metadata is above the cap with
+// ResourceExhausted, without running their handler
+func (s *Server) limitMetadata(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if size := metadataSize(md); size > s.maxMetadataSize {
+		s.log.Warn("Rejected oversized metadata", map[string]interface{}{
+			"method": info.FullMethod,
+			"size":   size,
+			"limit":  s.maxMetadataSize,
+		})
+		return nil, status.Errorf(codes.ResourceExhausted, "request metadata of %d bytes exceeds the limit of %d bytes", size, s.maxMetadataSize)
+	}
+	return handler(ctx, req)
+}
+
+// metadataSize is the size of metadata counted as HTTP/2 counts header lists
+func metadataSize(md metadata.MD) int {
+	size := 0
+	for key, values := range md {
+		for _, v := range values {
+			size += len(key) + len(v) + metadataEntryOverhead
+		}
+	}
+	return size
+}
+
+// mapErrors converts errors returned by handlers into gRPC statuses using the
+// registry shared with the HTTP transport. Calls abandoned by the client are
+// logged at debug level, errors without a mapping are logged in full and
+// returned as Internal with a generic message.
+func (s *Server) mapErrors(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+	if err == nil {
+		return resp, nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return resp, err
+	}
+
+	m, ok := errmap.Resolve(ctx, err)
+	switch {
+	case m == errmap.Canceled:
+		s.log.Debug("Call canceled by client", map[string]interface{}{"method": info.FullMethod, "error": err.Error()})
+	case !ok:
+		s.log.Error("Unmapped error", err, map[string]interface{}{"method": info.FullMethod})
+		m = errmap.Internal
+	}
+
+	return resp, status.Error(m.GRPCCode, m.Message)
+}
+
+// previousAttemptsKey is the metadata gRPC clients send with retries of a call
+const previousAttemptsKey = "grpc-previous-rpc-attempts"
+
+// observeOutcome classifies the status of a unary call for the SLO metrics.
+// It runs outside mapErrors to see the status sent to the client. Retries
+// are kept out of the SLO so that a failing call counts once.
+func (s *Server) observeOutcome(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	resp, err := handler(ctx, req)
+
+	retried := false
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		retried = len(md.Get(previousAttemptsKey)) > 0
+	}
+	metrics.ObserveRequest("grpc", info.FullMethod, errmap.GRPCOutcome(status.Code(err)), !retried)
+
+	return resp, err
+}
+
+// trackStatements counts database statements issued by a unary call
+func (s *Server) trackStatements(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, budget := querybudget.WithBudget(ctx, s.statementBudget, s.statementBudgetStrict)
+
+	resp, err := handler(ctx, req)
+
+	metrics.StatementsPerRequest.WithLabelValues("grpc", info.FullMethod).Observe(float64(budget.Count()))
+	wideevent.Add(ctx, wideevent.DBStatements, budget.Count())
+	if budget.Exceeded() {
+		s.log.Warn("Database statement budget exceeded", map[string]interface{}{
+			"route":      info.FullMethod,
+			"statements": budget.Count(),
+			"budget":     budget.Limit(),
+		})
+	}
+
+	return resp, err
+}
+
+// stalenessTrailer is the trailer of calls served from stale data, see
+// consistency.StalenessHeader
+const stalenessTrailer = "x-data-staleness"
+
+// reportStaleness sends the age of the stale data a unary call was served
+// from in the x-data-staleness trailer
+func (s *Server) reportStaleness(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, staleness := consistency.TrackStaleness(ctx)
+
+	resp, err := handler(ctx, req)
+
+	if age, ok := staleness.Age(); ok && err == nil {
+		if trailerErr := grpc.SetTrailer(ctx, metadata.Pairs(stalenessTrailer, consistency.FormatAge(age))); trailerErr != nil {
+			s.log.Warn("Failed to set staleness trailer", map[string]interface{}{"method": info.FullMethod, "error": trailerErr.Error()})
+		}
+	}
+
+	return resp, err
+}
+
+// emitWideEvent emits the wide event of sampled calls. It runs outside
+// mapErrors to see the status sent to the client.
+func (s *Server) emitWideEvent(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !s.wideEvents.Sampled() {
+		return handler(ctx, req)
+	}
+
+	start := time.Now()
+	ctx, record := wideevent.WithRecord(ctx)
+	ctx, collector := timing.WithCollector(ctx)
+
+	span := timing.Start(ctx, "handler", "")
+	resp, err := handler(ctx, req)
+	span.End()
+
+	code := status.Code(err)
+	event := wideevent.Event{
+		Time:      start,
+		Transport: "grpc",
+		Route:     info.FullMethod,
+		Status:    int(code),
+		Outcome:   string(errmap.GRPCOutcome(code)),
+		Duration:  time.Since(start),
+		Phases:    collector.Totals(),
+		User:      clientPrincipal(ctx),
+		Tenant:    tenant.FromContext(ctx),
+		BytesIn:   messageSize(req),
+		BytesOut:  messageSize(resp),
+		Record:    record,
+	}
+	if emitErr := s.wideEvents.Emit(event); emitErr != nil {
+		s.log.Error("Failed to emit wide event", emitErr, map[string]interface{}{"method": info.FullMethod})
+	}
+
+	return resp, err
+}
+
+// peerAddr returns the address of the caller, empty when unknown
+func peerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return p.Addr.String()
+}
+
+// clientPrincipal identifies the caller by its address, as the HTTP
+// transport does for requests that are not authenticated
+func clientPrincipal(ctx context.Context) string {
+	return accesslog.ClientPrincipal(peerAddr(ctx))
+}
+
+// messageSize is the encoded size of a protobuf message, 0 for anything else
+func messageSize(msg interface{}) int64 {
+	m, ok := msg.(proto.Message)
+	if !ok {
+		return 0
+	}
+	return int64(proto.Size(m))
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.log.Info("Shutting down gRPC server", nil)
 	done := make(chan struct{})
@@ -1,19 +1,34 @@
 package grpc
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
 	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
 	"github.com/romanitalian/carch-go/internal/service"
+	userv1 "github.com/romanitalian/carch-go/pkg/api/user/v1"
 )
 
 // Mock for UserService
@@ -35,6 +50,14 @@ func (m *MockUserService) GetByID(ctx context.Context, id string) (*domain.User,
 	return args.Get(0).(*domain.User), args.Error(1)
 }
 
+func (m *MockUserService) GetByIDs(ctx context.Context, ids []string) (*domain.UserLookup, error) {
+	args := m.Called(ctx, ids)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.UserLookup), args.Error(1)
+}
+
 func (m *MockUserService) Update(ctx context.Context, user *domain.User) error {
 	args := m.Called(ctx, user)
 	return args.Error(0)
@@ -45,12 +68,45 @@ func (m *MockUserService) Delete(ctx context.Context, id string) error {
 	return args.Error(0)
 }
 
-func (m *MockUserService) List(ctx context.Context) ([]*domain.User, error) {
-	args := m.Called(ctx)
+func (m *MockUserService) List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	args := m.Called(ctx, opts)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
-	return args.Get(0).([]*domain.User), args.Error(1)
+	return args.Get(0).(*domain.UserPage), args.Error(1)
+}
+
+func TestServer_mapErrors_ClientContext(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	server := NewServer("bufnet", &service.Services{Log: log}, log)
+	info := &grpc.UnaryServerInfo{FullMethod: "/carch.user.v1.UserService/BatchGetUsers"}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		code codes.Code
+	}{
+		{"client canceled", canceled, codes.Canceled},
+		{"deadline exceeded", expired, codes.DeadlineExceeded},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			_, err := server.mapErrors(tt.ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, ctx.Err()
+			})
+
+			// Assert
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
 }
 
 // Helper function to create a buffered listener for gRPC testing
@@ -148,3 +204,289 @@ func TestServer_Shutdown_WithTimeout(t *testing.T) {
 	err := server.Shutdown(ctx)
 	assert.NoError(t, err)
 }
+
+func TestServer_mapErrors(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	server := NewServer("bufnet", &service.Services{Log: log}, log)
+	info := &grpc.UnaryServerInfo{FullMethod: "/user.UserService/GetUser"}
+
+	tests := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{"not found", domain.ErrUserNotFound, codes.NotFound},
+		{"invalid input", domain.ErrInvalidInput, codes.InvalidArgument},
+		{"unknown", errors.New("connection reset"), codes.Internal},
+		{"canceled internally", context.Canceled, codes.Internal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			_, err := server.mapErrors(context.Background(), nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, tt.err
+			})
+
+			// Assert
+			assert.Equal(t, tt.code, status.Code(err))
+		})
+	}
+}
+
+func TestServer_observeOutcome(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	server := NewServer("bufnet", &service.Services{Log: log}, log)
+	info := &grpc.UnaryServerInfo{FullMethod: "/carch.user.v1.UserService/GetUser"}
+	retry := metadata.NewIncomingContext(context.Background(), metadata.Pairs(previousAttemptsKey, "1"))
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		err          error
+		wantOutcome  metrics.Outcome
+		wantEligible string
+	}{
+		{"success", context.Background(), nil, metrics.OutcomeSuccess, "true"},
+		{"client error", context.Background(), status.Error(codes.NotFound, "not found"), metrics.OutcomeClientError, "true"},
+		{"server error", context.Background(), status.Error(codes.Internal, "internal"), metrics.OutcomeServerError, "true"},
+		{"cancelled", context.Background(), status.Error(codes.Canceled, "canceled"), metrics.OutcomeCancelled, "true"},
+		{"retry", retry, status.Error(codes.Unavailable, "unavailable"), metrics.OutcomeServerError, "false"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter := metrics.SLORequests.WithLabelValues("grpc", info.FullMethod, string(tt.wantOutcome), tt.wantEligible)
+			before := testutil.ToFloat64(counter)
+
+			// Act
+			_, err := server.observeOutcome(tt.ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, tt.err
+			})
+
+			// Assert
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, before+1, testutil.ToFloat64(counter))
+		})
+	}
+}
+
+// trailerStream records the trailer a call sets
+type trailerStream struct {
+	grpc.ServerTransportStream
+	trailer metadata.MD
+}
+
+func (s *trailerStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+func TestServer_reportStaleness(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	server := NewServer("bufnet", &service.Services{Log: log}, log)
+	info := &grpc.UnaryServerInfo{FullMethod: "/carch.user.v1.UserService/BatchGetUsers"}
+
+	tests := []struct {
+		name        string
+		stale       time.Duration
+		err         error
+		wantTrailer []string
+	}{
+		{name: "fresh data"},
+		{name: "stale data", stale: 95 * time.Second, wantTrailer: []string{"95"}},
+		{name: "failed call", stale: 95 * time.Second, err: status.Error(codes.Unavailable, "unavailable")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream := &trailerStream{}
+			ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+
+			// Act
+			_, err := server.reportStaleness(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				if tt.stale > 0 {
+					consistency.ReportStale(ctx, tt.stale)
+				}
+				return nil, tt.err
+			})
+
+			// Assert
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.wantTrailer, stream.trailer.Get(stalenessTrailer))
+		})
+	}
+}
+
+func TestServer_BatchGetUsers(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	mockUserService := new(MockUserService)
+	services := &service.Services{
+		User: mockUserService,
+		Log:  log,
+	}
+
+	listener := newBufferedListener()
+	server := NewServer("bufnet", services, log)
+	go server.Run(listener)
+	defer server.Shutdown(context.Background())
+
+	ctx := context.Background()
+	conn, err := dialBufferedGrpc(ctx, listener)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	ids := []string{"user-1", "user-2"}
+	mockUserService.On("GetByIDs", mock.Anything, ids).Return(&domain.UserLookup{
+		Found:   map[string]*domain.User{"user-1": {ID: "user-1", Email: "a@example.com"}},
+		Missing: []string{"user-2"},
+	}, nil)
+
+	// Act
+	resp, err := userv1.NewUserServiceClient(conn).BatchGetUsers(ctx, &userv1.BatchGetUsersRequest{Ids: ids})
+
+	// Assert
+	assert.NoError(t, err)
+	assert.Equal(t, "a@example.com", resp.GetUsers()["user-1"].GetEmail())
+	assert.Equal(t, []string{"user-2"}, resp.GetMissingIds())
+	mockUserService.AssertExpectations(t)
+}
+
+func TestServer_BatchGetUsers_TooMany(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	mockUserService := new(MockUserService)
+	services := &service.Services{
+		User: mockUserService,
+		Log:  log,
+	}
+
+	listener := newBufferedListener()
+	server := NewServer("bufnet", services, log)
+	go server.Run(listener)
+	defer server.Shutdown(context.Background())
+
+	ctx := context.Background()
+	conn, err := dialBufferedGrpc(ctx, listener)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	mockUserService.On("GetByIDs", mock.Anything, []string{"user-1"}).Return(nil, domain.ErrTooManyIDs)
+
+	// Act
+	_, err = userv1.NewUserServiceClient(conn).BatchGetUsers(ctx, &userv1.BatchGetUsersRequest{Ids: []string{"user-1"}})
+
+	// Assert
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	mockUserService.AssertExpectations(t)
+}
+
+func TestServer_emitWideEvent(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	var events bytes.Buffer
+	server := NewServer("bufnet", &service.Services{Log: log}, log,
+		WithWideEvents(wideevent.NewEmitter(&events, wideevent.WithFields("transport", "route", "status", "outcome", "user", "bytes_in", wideevent.DBStatements))))
+	info := &grpc.UnaryServerInfo{FullMethod: "/carch.user.v1.UserService/BatchGetUsers"}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 4711}})
+	req := &userv1.BatchGetUsersRequest{Ids: []string{"user-1", "user-2"}}
+
+	// Act
+	_, err := server.emitWideEvent(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		wideevent.Add(ctx, wideevent.DBStatements, 2)
+		return nil, status.Error(codes.NotFound, "no users found")
+	})
+
+	// Assert
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.JSONEq(t, `{
+		"transport": "grpc",
+		"route": "/carch.user.v1.UserService/BatchGetUsers",
+		"status": 5,
+		"outcome": "client_error",
+		"user": "client:203.0.113.7",
+		"bytes_in": `+strconv.Itoa(proto.Size(req))+`,
+		"db_statements": 2
+	}`, events.String())
+}
+
+func TestServer_limitMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata string
+		wantCode codes.Code
+	}{
+		{name: "within the cap", metadata: strings.Repeat("x", 100), wantCode: codes.OK},
+		{name: "above the cap", metadata: strings.Repeat("x", 2048), wantCode: codes.ResourceExhausted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			var logs bytes.Buffer
+			log := logger.New(logger.WithOutput(&logs))
+			server := NewServer("bufnet", &service.Services{Log: log}, log, WithMaxMetadataSize(1024))
+			info := &grpc.UnaryServerInfo{FullMethod: "/carch.user.v1.UserService/BatchGetUsers"}
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-blob", tt.metadata))
+			called := false
+
+			// Act
+			_, err := server.limitMetadata(ctx, &userv1.BatchGetUsersRequest{}, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				return &userv1.BatchGetUsersResponse{}, nil
+			})
+
+			// Assert
+			assert.Equal(t, tt.wantCode, status.Code(err))
+			assert.Equal(t, tt.wantCode == codes.OK, called, "the handler runs only within the cap")
+		})
+	}
+}
+
+func TestServer_limitMetadata_Call(t *testing.T) {
+	// Arrange
+	var logs syncBuffer
+	log := logger.New(logger.WithOutput(&logs))
+	mockUserService := new(MockUserService)
+	server := NewServer("bufnet", &service.Services{User: mockUserService, Log: log}, log, WithMaxMetadataSize(1024))
+	listener := newBufferedListener()
+	go server.Run(listener)
+	defer server.Shutdown(context.Background())
+
+	ctx := context.Background()
+	conn, err := dialBufferedGrpc(ctx, listener)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// Act
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-blob", strings.Repeat("x", 2048))
+	_, err = userv1.NewUserServiceClient(conn).BatchGetUsers(ctx, &userv1.BatchGetUsersRequest{Ids: []string{"user-1"}})
+
+	// Assert
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	mockUserService.AssertNotCalled(t, "GetByIDs", mock.Anything, mock.Anything)
+	assert.Contains(t, logs.String(), `"status":8`, "the rejected call is in the access log")
+	assert.Contains(t, logs.String(), "Rejected oversized metadata")
+}
+
+// syncBuffer collects the logs written by the server goroutines
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
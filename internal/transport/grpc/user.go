@@ -0,0 +1,38 @@
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	userv1 "github.com/romanitalian/carch-go/pkg/api/user/v1"
+)
+
+// BatchGetUsers resolves a batch of user IDs, see service.UserService.GetByIDs
+func (s *Server) BatchGetUsers(ctx context.Context, req *userv1.BatchGetUsersRequest) (*userv1.BatchGetUsersResponse, error) {
+	lookup, err := s.services.User.GetByIDs(ctx, req.GetIds())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &userv1.BatchGetUsersResponse{
+		Users:      make(map[string]*userv1.User, len(lookup.Found)),
+		MissingIds: lookup.Missing,
+	}
+	for id, u := range lookup.Found {
+		resp.Users[id] = toProtoUser(u)
+	}
+
+	return resp, nil
+}
+
+func toProtoUser(u *domain.User) *userv1.User {
+	return &userv1.User{
+		Id:        u.ID,
+		Email:     u.Email,
+		Name:      u.Name,
+		CreatedAt: timestamppb.New(u.CreatedAt),
+		UpdatedAt: timestamppb.New(u.UpdatedAt),
+	}
+}
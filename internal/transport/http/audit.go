@@ -0,0 +1,123 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// auditFields are the fields of an audit entry a listing can select
+var auditFields = map[string]func(e *domain.AuditEntry) interface{}{
+	"id":         func(e *domain.AuditEntry) interface{} { return e.ID },
+	"action":     func(e *domain.AuditEntry) interface{} { return e.Action },
+	"entity":     func(e *domain.AuditEntry) interface{} { return e.Entity },
+	"entity_id":  func(e *domain.AuditEntry) interface{} { return e.EntityID },
+	"actor_id":   func(e *domain.AuditEntry) interface{} { return e.ActorID },
+	"details":    func(e *domain.AuditEntry) interface{} { return e.Details },
+	"created_at": func(e *domain.AuditEntry) interface{} { return e.CreatedAt },
+}
+
+// Middleware attributing the request to its principal, whom the audit
+// entries it records name as their actor
+func (h *Handler) actorScope(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		next(w, r.WithContext(service.WithActor(r.Context(), h.principalOf(r).ID)))
+	}
+}
+
+// listAudit pages through the audit log, newest first. Details are only
+// loaded with include_diff=true to keep default pages small.
+func (h *Handler) listAudit(w http.ResponseWriter, r *http.Request) {
+	filter, fields, err := parseAuditQuery(r)
+	if err != nil {
+		h.log.Warn("Invalid audit query", map[string]interface{}{"query": r.URL.RawQuery, "error": err.Error()})
+		h.respondError(w, r, err)
+		return
+	}
+
+	page, err := h.services.Audit.List(r.Context(), filter)
+	if err != nil {
+		h.logError(r, "Failed to list audit entries", err, nil)
+		h.respondError(w, r, err)
+		return
+	}
+
+	if len(fields) == 0 {
+		h.respondJSON(w, http.StatusOK, page)
+		return
+	}
+
+	entries := make([]map[string]interface{}, 0, len(page.Entries))
+	for _, e := range page.Entries {
+		entry := make(map[string]interface{}, len(fields))
+		for _, f := range fields {
+			entry[f] = auditFields[f](e)
+		}
+		entries = append(entries, entry)
+	}
+	h.respondJSON(w, http.StatusOK, auditPageRS{Entries: entries, NextCursor: page.NextCursor})
+}
+
+// parseAuditQuery reads the filter and the selected fields of an audit listing
+func parseAuditQuery(r *http.Request) (domain.AuditFilter, []string, error) {
+	q := r.URL.Query()
+	filter := domain.AuditFilter{
+		ActorID:  q.Get("actor_id"),
+		EntityID: q.Get("entity_id"),
+		Action:   q.Get("action"),
+	}
+
+	for name, dst := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if raw := q.Get(name); raw != "" {
+			t, err := time.Parse(time.RFC3339, raw)
+			if err != nil {
+				return filter, nil, newDecodeError("parameter %q must be an RFC 3339 time", name)
+			}
+			*dst = t
+		}
+	}
+
+	if raw := q.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 {
+			return filter, nil, newDecodeError("parameter %q must be a positive integer", "limit")
+		}
+		filter.Limit = limit
+	}
+
+	if raw := q.Get("cursor"); raw != "" {
+		cursor, err := domain.ParseAuditCursor(raw)
+		if err != nil {
+			return filter, nil, newDecodeError("parameter %q is not a cursor of a previous page", "cursor")
+		}
+		filter.After = &cursor
+	}
+
+	if raw := q.Get("include_diff"); raw != "" {
+		include, err := strconv.ParseBool(raw)
+		if err != nil {
+			return filter, nil, newDecodeError("parameter %q must be a boolean", "include_diff")
+		}
+		filter.IncludeDetails = include
+	}
+
+	var fields []string
+	if raw := q.Get("fields"); raw != "" {
+		for _, f := range strings.Split(raw, ",") {
+			f = strings.TrimSpace(f)
+			if _, ok := auditFields[f]; !ok {
+				return filter, nil, newDecodeError("unknown field %q", f)
+			}
+			if f == "details" && !filter.IncludeDetails {
+				return filter, nil, newDecodeError("field %q requires include_diff=true", f)
+			}
+			fields = append(fields, f)
+		}
+	}
+
+	return filter, fields, nil
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// stubAuditService returns page and records the filter it was called with
+type stubAuditService struct {
+	service.AuditServiceInterface
+	page   *domain.AuditPage
+	filter domain.AuditFilter
+}
+
+func (s *stubAuditService) List(ctx context.Context, filter domain.AuditFilter) (*domain.AuditPage, error) {
+	s.filter = filter
+	page := *s.page
+	if !filter.IncludeDetails {
+		page.Entries = nil
+		for _, e := range s.page.Entries {
+			entry := *e
+			entry.Details = nil
+			page.Entries = append(page.Entries, &entry)
+		}
+	}
+	return &page, nil
+}
+
+func newAuditHandler() (*Handler, *stubAuditService) {
+	audit := &stubAuditService{page: &domain.AuditPage{
+		Entries: []*domain.AuditEntry{{
+			ID:        7,
+			Action:    domain.AuditWebhookRejected,
+			Entity:    domain.AuditEntityWebhook,
+			EntityID:  "stripe",
+			Details:   json.RawMessage(`{"reason":"stale"}`),
+			CreatedAt: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+		}},
+		NextCursor: "next",
+	}}
+	log := logger.New()
+	return NewHandler(&service.Services{Audit: audit, Log: log}, log), audit
+}
+
+func TestHandler_listAudit_Filters(t *testing.T) {
+	from := time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC)
+	cursor := domain.AuditCursor{CreatedAt: from.Add(time.Hour), ID: 9}
+
+	tests := []struct {
+		name  string
+		query string
+		want  domain.AuditFilter
+	}{
+		{"none", "", domain.AuditFilter{}},
+		{"actor", "?actor_id=admin-1", domain.AuditFilter{ActorID: "admin-1"}},
+		{"entity", "?entity_id=u-1", domain.AuditFilter{EntityID: "u-1"}},
+		{"action", "?action=user.purged", domain.AuditFilter{Action: "user.purged"}},
+		{"time range", "?from=2024-03-01T00:00:00Z&to=2024-03-08T00:00:00Z", domain.AuditFilter{From: from, To: from.AddDate(0, 0, 7)}},
+		{"page", "?limit=20&cursor=" + cursor.String(), domain.AuditFilter{Limit: 20, After: &cursor}},
+		{"include diff", "?include_diff=true", domain.AuditFilter{IncludeDetails: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			handler, audit := newAuditHandler()
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/audit"+tt.query, nil))
+
+			// Assert
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, tt.want, audit.filter)
+		})
+	}
+}
+
+func TestHandler_listAudit_DiffToggle(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{
+			name:  "without diff",
+			query: "",
+			want: `{"entries":[{"id":7,"action":"webhook.rejected","entity":"webhook","entity_id":"stripe",
+				"created_at":"2024-03-01T00:00:00Z"}],"next_cursor":"next"}`,
+		},
+		{
+			name:  "with diff",
+			query: "?include_diff=true",
+			want: `{"entries":[{"id":7,"action":"webhook.rejected","entity":"webhook","entity_id":"stripe",
+				"details":{"reason":"stale"},"created_at":"2024-03-01T00:00:00Z"}],"next_cursor":"next"}`,
+		},
+		{
+			name:  "selected fields",
+			query: "?fields=id,action",
+			want:  `{"entries":[{"id":7,"action":"webhook.rejected"}],"next_cursor":"next"}`,
+		},
+		{
+			name:  "selected fields with diff",
+			query: "?fields=id,details&include_diff=true",
+			want:  `{"entries":[{"id":7,"details":{"reason":"stale"}}],"next_cursor":"next"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			handler, _ := newAuditHandler()
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/audit"+tt.query, nil))
+
+			// Assert
+			require.Equal(t, http.StatusOK, rr.Code)
+			assert.JSONEq(t, tt.want, rr.Body.String())
+		})
+	}
+}
+
+func TestHandler_listAudit_InvalidQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		details string
+	}{
+		{"from", "?from=yesterday", `parameter "from" must be an RFC 3339 time`},
+		{"limit", "?limit=0", `parameter "limit" must be a positive integer`},
+		{"cursor", "?cursor=%21", `parameter "cursor" is not a cursor of a previous page`},
+		{"include diff", "?include_diff=maybe", `parameter "include_diff" must be a boolean`},
+		{"unknown field", "?fields=id,password", `unknown field "password"`},
+		{"details without diff", "?fields=details", `field "details" requires include_diff=true`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			handler, _ := newAuditHandler()
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/audit"+tt.query, nil))
+
+			// Assert
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			var resp errorRS
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+			assert.Equal(t, tt.details, resp.Details)
+		})
+	}
+}
+
+func TestHandler_actorScope(t *testing.T) {
+	// Arrange
+	handler, _ := newAuditHandler()
+	var actor string
+	next := handler.actorScope(func(w http.ResponseWriter, r *http.Request) {
+		actor = service.ActorOf(r.Context())
+	})
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/users/u-1", nil)
+	req.RemoteAddr = "203.0.113.7:51234"
+
+	// Act
+	next(httptest.NewRecorder(), req)
+
+	// Assert
+	assert.Equal(t, "client:203.0.113.7", actor)
+}
@@ -0,0 +1,52 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+// bulkDeleteUsers counts the users matching a filter on a dry run. Otherwise
+// it enqueues the job deleting them and answers with the job, whose progress
+// is served by the job routes.
+func (h *Handler) bulkDeleteUsers(w http.ResponseWriter, r *http.Request) {
+	var req bulkDeleteUsersRQ
+	if err := h.decodeJSONBody(r, &req); err != nil {
+		h.logError(r, "Failed to decode request body", err, map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, err)
+		return
+	}
+
+	if req.DryRun == nil {
+		h.log.Warn("Bulk delete without dry_run", map[string]interface{}{"filter": req.Filter})
+		h.respondError(w, r, fmt.Errorf("%w: dry_run is required", domain.ErrInvalidInput))
+		return
+	}
+
+	filter, err := domain.ParseUserFilter(req.Filter)
+	if err != nil {
+		h.log.Warn("Invalid bulk delete filter", map[string]interface{}{"filter": req.Filter})
+		h.respondError(w, r, err)
+		return
+	}
+
+	result, err := h.services.BulkDelete.Start(r.Context(), domain.BulkDeleteRequest{
+		Filter: filter,
+		DryRun: *req.DryRun,
+		Force:  req.Force,
+	})
+	if err != nil {
+		h.logError(r, "Failed to start bulk delete", err, map[string]interface{}{"filter": req.Filter})
+		h.respondError(w, r, err)
+		return
+	}
+
+	if result.DryRun {
+		h.respondJSON(w, http.StatusOK, result)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/jobs/"+result.Job.ID)
+	h.respondJSON(w, http.StatusAccepted, result)
+}
@@ -0,0 +1,120 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// stubBulkDeleteService matches three users with a ceiling of two and
+// records the request it was started with
+type stubBulkDeleteService struct {
+	service.BulkDeleteServiceInterface
+	req *domain.BulkDeleteRequest
+}
+
+func (s *stubBulkDeleteService) Start(ctx context.Context, req domain.BulkDeleteRequest) (*domain.BulkDeleteResult, error) {
+	s.req = &req
+	if req.DryRun {
+		return &domain.BulkDeleteResult{DryRun: true, Matched: 3}, nil
+	}
+	if !req.Force {
+		return nil, domain.ErrBulkDeleteTooLarge
+	}
+	return &domain.BulkDeleteResult{Matched: 3, Job: &domain.Job{ID: "job-1", Type: domain.JobUsersBulkDelete, Status: domain.JobPending}}, nil
+}
+
+func TestHandler_bulkDeleteUsers(t *testing.T) {
+	before := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		body         string
+		wantStatus   int
+		wantBody     string
+		wantLocation string
+		wantReq      *domain.BulkDeleteRequest
+	}{
+		{
+			name:       "dry run",
+			body:       `{"filter":"email_domain:example.com,created_before:2024-01-01T00:00:00Z","dry_run":true}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"dry_run":true,"matched":3}`,
+			wantReq: &domain.BulkDeleteRequest{
+				Filter: domain.UserFilter{EmailDomain: "example.com", CreatedBefore: before},
+				DryRun: true,
+			},
+		},
+		{
+			name:         "forced run",
+			body:         `{"filter":"email_domain:example.com","dry_run":false,"force":true}`,
+			wantStatus:   http.StatusAccepted,
+			wantBody:     `"job":{"id":"job-1"`,
+			wantLocation: "/api/v1/jobs/job-1",
+			wantReq:      &domain.BulkDeleteRequest{Filter: domain.UserFilter{EmailDomain: "example.com"}, Force: true},
+		},
+		{
+			name:       "above the ceiling",
+			body:       `{"filter":"email_domain:example.com","dry_run":false}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   `"code":"bulk_delete_too_large"`,
+			wantReq:    &domain.BulkDeleteRequest{Filter: domain.UserFilter{EmailDomain: "example.com"}},
+		},
+		{
+			name:       "dry_run left out",
+			body:       `{"filter":"email_domain:example.com"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"code":"invalid_input"`,
+		},
+		{
+			name:       "invalid filter",
+			body:       `{"filter":"email:ada@example.com","dry_run":true}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"code":"invalid_input"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			bulk := &stubBulkDeleteService{}
+			log := logger.New()
+			handler := NewHandler(&service.Services{BulkDelete: bulk, Log: log}, log)
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users/bulk-delete", strings.NewReader(tt.body))
+
+			// Act
+			handler.Internal().ServeHTTP(rr, req)
+
+			// Assert
+			require.Equal(t, tt.wantStatus, rr.Code, rr.Body.String())
+			assert.Contains(t, rr.Body.String(), tt.wantBody)
+			assert.Equal(t, tt.wantLocation, rr.Header().Get("Location"))
+			assert.Equal(t, tt.wantReq, bulk.req)
+		})
+	}
+}
+
+func TestHandler_bulkDeleteUsers_NotPublic(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	handler := NewHandler(&service.Services{BulkDelete: &stubBulkDeleteService{}, Log: log}, log)
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users/bulk-delete", strings.NewReader(`{"filter":"name_prefix:a","dry_run":true}`))
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
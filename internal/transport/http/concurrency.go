@@ -0,0 +1,164 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/transport/accesslog"
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+)
+
+// defaultConcurrencySlotTTL frees slots of requests that never released them
+const defaultConcurrencySlotTTL = time.Minute
+
+// Principal is who a request is made on behalf of
+type Principal struct {
+	ID string
+	// Role selects the concurrency limit override of the principal, if any
+	Role string
+}
+
+// PrincipalFunc resolves the principal of a request
+type PrincipalFunc func(r *http.Request) Principal
+
+// clientPrincipal identifies a principal by the address of the client, for
+// requests that are not authenticated. Behind a trusted proxy it is the
+// forwarded address, see clientIP.
+func (h *Handler) clientPrincipal(r *http.Request) Principal {
+	return Principal{ID: accesslog.ClientPrincipal(h.clientIP(r))}
+}
+
+// concurrencyLimit caps how many expensive requests a principal runs at once
+type concurrencyLimit struct {
+	slots  cache.Semaphore
+	limit  int
+	byRole map[string]int
+	ttl    time.Duration
+}
+
+// limitOf returns the limit of p, 0 when it is unlimited
+func (l *concurrencyLimit) limitOf(p Principal) int {
+	if limit, ok := l.byRole[p.Role]; ok && p.Role != "" {
+		return limit
+	}
+	return l.limit
+}
+
+// concurrencyLimitError reports the limit a principal ran into
+type concurrencyLimitError struct {
+	limit int
+}
+
+func (e *concurrencyLimitError) Error() string {
+	return fmt.Sprintf("%s: %d", errmap.ErrConcurrencyLimit, e.limit)
+}
+
+func (e *concurrencyLimitError) Unwrap() error {
+	return errmap.ErrConcurrencyLimit
+}
+
+// Details is exposed to clients by respondError
+func (e *concurrencyLimitError) Details() string {
+	return fmt.Sprintf("at most %d expensive requests may run at once, retry when one of them completes", e.limit)
+}
+
+// WithPrincipalConcurrency lets each principal run at most limit expensive
+// requests at once, such as full listings, bulk lookups and imports. byRole
+// overrides limit for the roles it lists. A limit of 0 disables it. Slots
+// are held in slots, which replicas share when it is shared, and freed
+// after ttl if a request never releases its slot. The slot of a request
+// running longer is refreshed every half ttl.
+func WithPrincipalConcurrency(slots cache.Semaphore, limit int, byRole map[string]int, ttl time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if slots == nil || (limit <= 0 && len(byRole) == 0) {
+			return
+		}
+		if ttl <= 0 {
+			ttl = defaultConcurrencySlotTTL
+		}
+		h.concurrency = &concurrencyLimit{slots: slots, limit: limit, byRole: byRole, ttl: ttl}
+	}
+}
+
+// WithPrincipalFunc sets how the principal of a request is resolved. By
+// default requests are attributed to the client address.
+func WithPrincipalFunc(fn PrincipalFunc) HandlerOption {
+	return func(h *Handler) {
+		if fn != nil {
+			h.principalOf = fn
+		}
+	}
+}
+
+// Middleware answering 429 when the principal of the request already runs
+// as many expensive requests as its limit allows. The slot is released when
+// the handler returns, including when the client disconnects.
+func (h *Handler) limitConcurrency(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.concurrency == nil {
+			next(w, r)
+			return
+		}
+
+		principal := h.principalOf(r)
+		limit := h.concurrency.limitOf(principal)
+		if limit <= 0 {
+			next(w, r)
+			return
+		}
+
+		key := "concurrency:expensive:" + principal.ID
+		token, ok, err := h.concurrency.slots.Acquire(r.Context(), key, limit, h.concurrency.ttl)
+		if err != nil {
+			// Failing open keeps the API up when the slot store is not
+			h.logError(r, "Failed to acquire concurrency slot", err, map[string]interface{}{"principal": principal.ID})
+			next(w, r)
+			return
+		}
+
+		w.Header().Set("X-Concurrency-Limit", strconv.Itoa(limit))
+		if !ok {
+			h.log.Warn("Concurrency limit exceeded", map[string]interface{}{
+				"principal": principal.ID,
+				"role":      principal.Role,
+				"limit":     limit,
+				"path":      r.URL.Path,
+			})
+			h.respondError(w, r, &concurrencyLimitError{limit: limit})
+			return
+		}
+
+		done := make(chan struct{})
+		go h.refreshSlot(r, key, token, done)
+		defer func() {
+			close(done)
+			// The request context is done when the client disconnected
+			if err := h.concurrency.slots.Release(context.WithoutCancel(r.Context()), key, token); err != nil {
+				h.logError(r, "Failed to release concurrency slot", err, map[string]interface{}{"principal": principal.ID})
+			}
+		}()
+		next(w, r)
+	}
+}
+
+// refreshSlot keeps the slot of a request held until done is closed, so that
+// requests running longer than the ttl do not lose it
+func (h *Handler) refreshSlot(r *http.Request, key, token string, done <-chan struct{}) {
+	ticker := time.NewTicker(h.concurrency.ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := h.concurrency.slots.Refresh(context.WithoutCancel(r.Context()), key, token, h.concurrency.ttl); err != nil {
+				h.logError(r, "Failed to refresh concurrency slot", err, map[string]interface{}{"key": key})
+			}
+		}
+	}
+}
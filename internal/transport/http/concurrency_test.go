@@ -0,0 +1,281 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// blockingUserService lists users once released or once the request is done
+type blockingUserService struct {
+	MockUserService
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (s *blockingUserService) List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	s.entered <- struct{}{}
+	select {
+	case <-s.release:
+		return &domain.UserPage{Users: []*domain.User{}}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func newConcurrencyHandler(svc *blockingUserService, limit int, opts ...HandlerOption) *Handler {
+	log := logger.New()
+	opts = append(opts, WithPrincipalConcurrency(cache.NewSlots(), limit, nil, time.Minute))
+	return NewHandler(&service.Services{User: svc, Log: log}, log, opts...)
+}
+
+// exports runs full listings that block in the service
+type exports struct {
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+func newExports() *exports {
+	return &exports{done: make(chan struct{}, 16)}
+}
+
+// start starts a listing and returns once it reached the service
+func (e *exports) start(ctx context.Context, handler http.Handler, svc *blockingUserService) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil).WithContext(ctx)
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		handler.ServeHTTP(rr, req)
+		e.done <- struct{}{}
+	}()
+	<-svc.entered
+	return rr
+}
+
+func TestHandler_limitConcurrency(t *testing.T) {
+	// Arrange
+	const limit = 3
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	handler := newConcurrencyHandler(svc, limit)
+
+	running := newExports()
+	recorders := make([]*httptest.ResponseRecorder, limit)
+	for i := range recorders {
+		recorders[i] = running.start(context.Background(), handler, svc)
+	}
+
+	// Act
+	rejected := httptest.NewRecorder()
+	handler.ServeHTTP(rejected, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusTooManyRequests, rejected.Code)
+	assert.Equal(t, "3", rejected.Header().Get("X-Concurrency-Limit"))
+	var resp errorRS
+	require.NoError(t, json.Unmarshal(rejected.Body.Bytes(), &resp))
+	assert.Equal(t, "concurrency_limit_exceeded", resp.Code)
+	assert.Equal(t, "at most 3 expensive requests may run at once, retry when one of them completes", resp.Details)
+
+	// Act: one of the running requests completes
+	svc.release <- struct{}{}
+	<-running.done
+	next := running.start(context.Background(), handler, svc)
+
+	// Assert: its slot was freed
+	close(svc.release)
+	running.wg.Wait()
+	for _, rr := range append(recorders, next) {
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+}
+
+func TestHandler_limitConcurrency_ClientDisconnect(t *testing.T) {
+	// Arrange
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	handler := newConcurrencyHandler(svc, 1)
+
+	ctx, disconnect := context.WithCancel(context.Background())
+	running := newExports()
+	running.start(ctx, handler, svc)
+
+	// Act: the client goes away mid-request
+	disconnect()
+	<-running.done
+
+	// Assert: the slot is free for the next request
+	next := running.start(context.Background(), handler, svc)
+	close(svc.release)
+	running.wg.Wait()
+	assert.Equal(t, http.StatusOK, next.Code)
+}
+
+func TestHandler_limitConcurrency_PerPrincipal(t *testing.T) {
+	// Arrange
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	handler := newConcurrencyHandler(svc, 1,
+		WithPrincipalFunc(func(r *http.Request) Principal {
+			return Principal{ID: r.Header.Get("X-User")}
+		}))
+
+	var wg sync.WaitGroup
+	// admitted reports whether a request reached the service or was rejected
+	admitted := func(user string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		req.Header.Set("X-User", user)
+		return admit(t, handler, svc, req, &wg)
+	}
+
+	// Act & Assert
+	assert.True(t, admitted("ann"))
+	assert.False(t, admitted("ann"), "ann runs one export")
+	assert.True(t, admitted("bob"), "principals have slots of their own")
+
+	close(svc.release)
+	wg.Wait()
+}
+
+func TestHandler_limitConcurrency_ByRole(t *testing.T) {
+	// Arrange
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	log := logger.New()
+	handler := NewHandler(&service.Services{User: svc, Log: log}, log,
+		WithPrincipalConcurrency(cache.NewSlots(), 1, map[string]int{"analyst": 2, "admin": 0}, time.Minute),
+		WithPrincipalFunc(func(r *http.Request) Principal {
+			return Principal{ID: r.Header.Get("X-User"), Role: r.Header.Get("X-Role")}
+		}))
+
+	var wg sync.WaitGroup
+	admitted := func(user, role string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		req.Header.Set("X-User", user)
+		req.Header.Set("X-Role", role)
+		return admit(t, handler, svc, req, &wg)
+	}
+
+	// Act & Assert
+	assert.True(t, admitted("ann", "analyst"))
+	assert.True(t, admitted("ann", "analyst"), "analysts run two exports")
+	assert.False(t, admitted("ann", "analyst"))
+	for range 3 {
+		assert.True(t, admitted("root", "admin"), "admins are not limited")
+	}
+	assert.True(t, admitted("bob", ""))
+	assert.False(t, admitted("bob", ""), "principals without a role get the global limit")
+
+	close(svc.release)
+	wg.Wait()
+}
+
+func TestHandler_limitConcurrency_Import(t *testing.T) {
+	// Arrange: the client already runs as many expensive requests as allowed
+	slots := cache.NewSlots()
+	_, ok, err := slots.Acquire(context.Background(), "concurrency:expensive:client:192.0.2.1", 1, time.Minute)
+	require.NoError(t, err)
+	require.True(t, ok)
+	log := logger.New()
+	imports := &stubImportService{}
+	handler := NewHandler(&service.Services{Import: imports, Log: log}, log,
+		WithPrincipalConcurrency(slots, 1, nil, time.Minute))
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users/import", strings.NewReader("email,password\n"))
+	req.Header.Set("Content-Type", "text/csv")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.Internal().ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Empty(t, imports.content)
+}
+
+func TestHandler_limitConcurrency_ForwardedClient(t *testing.T) {
+	// Arrange: clients behind a trusted proxy share its address
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	handler := newConcurrencyHandler(svc, 1, WithTrustedProxy(true))
+
+	var wg sync.WaitGroup
+	admitted := func(client string) bool {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		req.RemoteAddr = "10.0.0.1:4242"
+		req.Header.Set("X-Forwarded-For", client)
+		return admit(t, handler, svc, req, &wg)
+	}
+
+	// Act & Assert
+	assert.True(t, admitted("203.0.113.7"))
+	assert.False(t, admitted("203.0.113.7"))
+	assert.True(t, admitted("203.0.113.8"), "the forwarded address is the principal, not the proxy")
+
+	close(svc.release)
+	wg.Wait()
+}
+
+// admit serves req in the background and reports whether it reached the
+// service or was rejected
+func admit(t *testing.T, handler http.Handler, svc *blockingUserService, req *http.Request, wg *sync.WaitGroup) bool {
+	t.Helper()
+
+	rejected := make(chan bool, 1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		rejected <- rr.Code == http.StatusTooManyRequests
+	}()
+
+	select {
+	case <-svc.entered:
+		return true
+	case r := <-rejected:
+		return !r
+	}
+}
+
+// refreshingSlots counts the refreshes of its slots
+type refreshingSlots struct {
+	*cache.Slots
+	refreshed chan string
+}
+
+func (s *refreshingSlots) Refresh(ctx context.Context, key, token string, ttl time.Duration) error {
+	select {
+	case s.refreshed <- key:
+	default:
+	}
+	return s.Slots.Refresh(ctx, key, token, ttl)
+}
+
+func TestHandler_limitConcurrency_RefreshesLongRequests(t *testing.T) {
+	// Arrange
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	slots := &refreshingSlots{Slots: cache.NewSlots(), refreshed: make(chan string, 1)}
+	log := logger.New()
+	handler := NewHandler(&service.Services{User: svc, Log: log}, log,
+		WithPrincipalConcurrency(slots, 1, nil, 20*time.Millisecond))
+
+	running := newExports()
+	running.start(context.Background(), handler, svc)
+
+	// Act: the request outlives its slot ttl
+	key := <-slots.refreshed
+
+	// Assert
+	assert.Equal(t, "concurrency:expensive:client:192.0.2.1", key)
+	close(svc.release)
+	running.wg.Wait()
+}
@@ -1,7 +1,79 @@
 package http
 
+import (
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/explain"
+	"github.com/romanitalian/carch-go/internal/pkg/health"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
+)
+
 // Config holds HTTP server configuration
 type Config struct {
 	Address string
 	Port    string
+	// TLSCertFile and TLSKeyFile serve the public listener over TLS, it is
+	// plaintext without them
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// InternalAddress and InternalPort bind the listener of probes, metrics
+	// and admin routes. Without a port those routes are not served.
+	InternalAddress string
+	InternalPort    string
+	// Timeouts of the public listener, zero values keep the defaults
+	Timeouts Timeouts
+
+	// StatementBudget is the per-request database statement budget, 0 disables it
+	StatementBudget       int
+	StatementBudgetStrict bool
+
+	// AccessLogExclude lists request paths that are not access-logged
+	AccessLogExclude []string
+	// AccessLogDebug lists request paths access-logged at debug level
+	AccessLogDebug []string
+	// ReadinessCheckers are run by /readyz
+	ReadinessCheckers []health.Checker
+	// ConsistencyWindow is how long consistency tokens route reads to the primary
+	ConsistencyWindow time.Duration
+	// SlowRequestThreshold is how long a request may take before its phase
+	// timings are logged, 0 disables tracing
+	SlowRequestThreshold time.Duration
+	// ShedSoftLimit in-flight requests or a p99 latency above ShedLatencyThreshold
+	// start shedding non-critical requests, ShedHardLimit rejects every request.
+	// 0 disables each trigger.
+	ShedSoftLimit        int
+	ShedHardLimit        int
+	ShedLatencyThreshold time.Duration
+	// ConcurrencySlots holds the slots of expensive requests. Each principal
+	// runs at most ConcurrencyLimit of them at once, or the limit of its role
+	// in ConcurrencyByRole. 0 disables the limit.
+	ConcurrencySlots   cache.Semaphore
+	ConcurrencyLimit   int
+	ConcurrencyByRole  map[string]int
+	ConcurrencySlotTTL time.Duration
+	// GroupConcurrency caps the requests of each route group running at
+	// once, requests past the cap wait up to GroupWait for a slot
+	GroupConcurrency map[string]int
+	GroupWait        time.Duration
+	// CreateUserRate is how many users a client address may create per
+	// second, in bursts of up to CreateUserBurst. 0 disables the limit.
+	CreateUserRate  float64
+	CreateUserBurst int
+	// TrustProxy takes client addresses from X-Forwarded-For
+	TrustProxy bool
+	// ThrottleOverrideToken, sent in X-Admin-Override, lifts the user
+	// mutation throttle. Empty disables the override.
+	ThrottleOverrideToken string
+	// TrailingSlash is how GET and HEAD requests to non-canonical paths are
+	// served, TrailingSlashRedirect or TrailingSlashRewrite
+	TrailingSlash string
+	// CORS is what pages of other origins may do with the API, see WithCORS
+	CORS CORS
+	// WideEvents emits a wide event per sampled request, nil disables them
+	WideEvents *wideevent.Emitter
+	// ExplainSampler is the sampler of query plans administrators may
+	// tune, nil leaves its routes out
+	ExplainSampler *explain.Sampler
 }
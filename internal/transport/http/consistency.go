@@ -0,0 +1,89 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
+)
+
+// defaultConsistencyWindow is used when no replica lag bound is configured
+const defaultConsistencyWindow = 5 * time.Second
+
+// WithConsistencyWindow sets how long after a mutation reads presenting its
+// consistency token are served from the primary. It should cover the worst
+// replica lag.
+func WithConsistencyWindow(window time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if window > 0 {
+			h.consistencyWindow = window
+		}
+	}
+}
+
+// Middleware routing reads to the primary, bypassing caches and replicas, when
+// the request presents a fresh consistency token or asks for Cache-Control: no-cache.
+// Responses built from stale data carry its age in X-Data-Staleness.
+func (h *Handler) readYourWrites(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, staleness := consistency.TrackStaleness(r.Context())
+		token := r.Header.Get(consistency.Header)
+		if (token != "" && consistency.Fresh(token, time.Now(), h.consistencyWindow)) || noCache(r) {
+			ctx = consistency.WithPrimary(ctx)
+		}
+
+		next(&stalenessWriter{ResponseWriter: w, staleness: staleness}, r.WithContext(ctx))
+	}
+}
+
+// stalenessWriter sets X-Data-Staleness before the status is sent, once
+// the reads of the request reported stale data
+type stalenessWriter struct {
+	http.ResponseWriter
+	staleness *consistency.Staleness
+	sent      bool
+}
+
+func (sw *stalenessWriter) WriteHeader(code int) {
+	sw.setHeader()
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *stalenessWriter) Write(b []byte) (int, error) {
+	sw.setHeader()
+	return sw.ResponseWriter.Write(b)
+}
+
+func (sw *stalenessWriter) setHeader() {
+	if sw.sent {
+		return
+	}
+	sw.sent = true
+	if age, ok := sw.staleness.Age(); ok {
+		sw.Header().Set(consistency.StalenessHeader, consistency.FormatAge(age))
+	}
+}
+
+// Unwrap lets http.ResponseController and abortResponse reach the
+// underlying writer
+func (sw *stalenessWriter) Unwrap() http.ResponseWriter {
+	return sw.ResponseWriter
+}
+
+// noCache reports whether the request carries the no-cache directive
+func noCache(r *http.Request) bool {
+	for _, value := range r.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(directive), "no-cache") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setConsistencyToken returns the token of a committed mutation to the client
+func setConsistencyToken(w http.ResponseWriter) {
+	w.Header().Set(consistency.Header, consistency.NewToken(time.Now()))
+}
@@ -0,0 +1,190 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/repository"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// laggingUserRepository writes to the primary and serves plain reads from a
+// replica that never catches up during the test
+type laggingUserRepository struct {
+	domain.UserRepository
+	primary map[string]domain.User
+	replica map[string]domain.User
+}
+
+func (r *laggingUserRepository) Create(ctx context.Context, user *domain.User) error {
+	user.ID = "user-123"
+	r.primary[user.ID] = *user
+	return nil
+}
+
+func (r *laggingUserRepository) GetByID(ctx context.Context, id string) (*domain.User, error) {
+	source := r.replica
+	if consistency.PrimaryRequired(ctx) {
+		source = r.primary
+	}
+
+	user, ok := source[id]
+	if !ok {
+		return nil, domain.ErrUserNotFound
+	}
+	return &user, nil
+}
+
+func (r *laggingUserRepository) Update(ctx context.Context, user *domain.User) error {
+	r.primary[user.ID] = *user
+	return nil
+}
+
+// setupConsistencyHandler serves users through the cache decorator on top of a lagging replica
+func setupConsistencyHandler() (*laggingUserRepository, *Handler) {
+	log := logger.New()
+	repo := &laggingUserRepository{primary: map[string]domain.User{}, replica: map[string]domain.User{}}
+	users := repository.NewCachedUserRepository(repo, cache.NewMemory(), time.Minute, log)
+
+	return repo, NewHandler(&service.Services{User: service.NewUserService(users, log)}, log)
+}
+
+func getUserName(t *testing.T, h *Handler, header http.Header) (int, string) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/user-123", nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	var user domain.User
+	if w.Code == http.StatusOK {
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&user))
+	}
+	return w.Code, user.Name
+}
+
+func TestHandler_ReadYourWrites_AfterCreate(t *testing.T) {
+	// Arrange
+	_, h := setupConsistencyHandler()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users",
+		strings.NewReader(`{"email":"new@example.com","password":"secret","name":"New"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code)
+	token := w.Header().Get(consistency.Header)
+	require.NotEmpty(t, token)
+
+	// Act
+	staleStatus, _ := getUserName(t, h, nil)
+	freshStatus, freshName := getUserName(t, h, http.Header{consistency.Header: {token}})
+
+	// Assert
+	assert.Equal(t, http.StatusNotFound, staleStatus, "the replica has not seen the insert yet")
+	assert.Equal(t, http.StatusOK, freshStatus)
+	assert.Equal(t, "New", freshName)
+}
+
+func TestHandler_ReadYourWrites_AfterUpdate(t *testing.T) {
+	// Arrange
+	repo, h := setupConsistencyHandler()
+	old := domain.User{ID: "user-123", Email: "a@example.com", Name: "Old"}
+	repo.primary[old.ID] = old
+	repo.replica[old.ID] = old
+
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/users/user-123",
+		strings.NewReader(`{"email":"a@example.com","name":"New"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	token := w.Header().Get(consistency.Header)
+	require.NotEmpty(t, token)
+
+	// Act
+	_, stale := getUserName(t, h, nil)
+	_, expired := getUserName(t, h, http.Header{consistency.Header: {consistency.NewToken(time.Now().Add(-time.Minute))}})
+	_, withToken := getUserName(t, h, http.Header{consistency.Header: {token}})
+	_, noCache := getUserName(t, h, http.Header{"Cache-Control": {"max-age=0, no-cache"}})
+
+	// Assert
+	assert.Equal(t, "Old", stale)
+	assert.Equal(t, "Old", expired, "tokens older than the replica lag bound are ignored")
+	assert.Equal(t, "New", withToken)
+	assert.Equal(t, "New", noCache)
+}
+
+func TestHandler_ReadYourWrites_InvalidTokenIgnored(t *testing.T) {
+	// Arrange
+	repo, h := setupConsistencyHandler()
+	repo.replica["user-123"] = domain.User{ID: "user-123", Name: "Old"}
+	repo.primary["user-123"] = domain.User{ID: "user-123", Name: "New"}
+
+	// Act
+	status, name := getUserName(t, h, http.Header{consistency.Header: {"not a token"}})
+
+	// Assert
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "Old", name)
+}
+
+// outageUserRepository fails every read once down, as an unreachable database
+type outageUserRepository struct {
+	*laggingUserRepository
+	down bool
+}
+
+func (r *outageUserRepository) GetByID(ctx context.Context, id string) (*domain.User, error) {
+	if r.down {
+		return nil, syscall.ECONNREFUSED
+	}
+	return r.laggingUserRepository.GetByID(ctx, id)
+}
+
+func TestHandler_StaleIfError(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	repo := &outageUserRepository{laggingUserRepository: &laggingUserRepository{
+		primary: map[string]domain.User{},
+		replica: map[string]domain.User{"user-123": {ID: "user-123", Name: "Cached"}},
+	}}
+	now := clock.NewFixed(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC))
+	users := repository.NewCachedUserRepository(repo, cache.NewMemory(), time.Minute, log,
+		repository.WithStaleIfError(10*time.Minute), repository.WithCacheClock(now))
+	h := NewHandler(&service.Services{User: service.NewUserService(users, log)}, log)
+
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/users/user-123", nil))
+		return w
+	}
+	require.Equal(t, http.StatusOK, get().Code)
+
+	// Act
+	fresh := get()
+	now.Advance(90 * time.Second)
+	repo.down = true
+	stale := get()
+
+	// Assert
+	assert.Empty(t, fresh.Header().Get(consistency.StalenessHeader))
+	require.Equal(t, http.StatusOK, stale.Code)
+	assert.Equal(t, "90", stale.Header().Get(consistency.StalenessHeader))
+	assert.Contains(t, stale.Body.String(), `"Cached"`)
+}
@@ -0,0 +1,98 @@
+package http
+
+import (
+	"context"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// Content is a large payload streamed to the client instead of encoded as
+// JSON, such as a file of a user. It is served with the semantics of
+// http.ServeContent: the ETag and ModTime answer conditional requests, and
+// Range requests get the bytes asked for, so that a client resumes an
+// interrupted download instead of starting over.
+type Content struct {
+	// Name is the file name the Content-Type is guessed from when Type is
+	// empty, and the name the client saves the payload as
+	Name string
+	// Type is the Content-Type, guessed from Name or the first bytes when empty
+	Type string
+	// ModTime is the Last-Modified time, zero omits it
+	ModTime time.Time
+	// ETag is the quoted entity tag, e.g. `"v3"` or `W/"v3"`, empty omits it
+	ETag string
+	// Body is read from the offsets of the ranges asked for
+	Body io.ReadSeeker
+}
+
+// serveContent streams c in answer to r. The copy stops as soon as the
+// request is canceled, and the bytes written are counted by route.
+func (h *Handler) serveContent(w http.ResponseWriter, r *http.Request, c Content) {
+	if c.Type != "" {
+		w.Header().Set("Content-Type", c.Type)
+	}
+	if c.ETag != "" {
+		w.Header().Set("ETag", c.ETag)
+	}
+	if c.Name != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": c.Name}))
+	}
+
+	counted := &countingWriter{ResponseWriter: w}
+	http.ServeContent(counted, r, c.Name, c.ModTime, &contextReader{ctx: r.Context(), ReadSeeker: c.Body})
+
+	metrics.HTTPContentBytes.WithLabelValues(r.Pattern).Add(float64(counted.bytes))
+	if err := r.Context().Err(); err != nil {
+		h.logError(r, "Content download interrupted", err, map[string]interface{}{
+			"path":  r.URL.Path,
+			"bytes": counted.bytes,
+		})
+	}
+}
+
+// contextReader fails reads once ctx is done, which stops the copy of
+// http.ServeContent when the client goes away
+type contextReader struct {
+	ctx context.Context
+	io.ReadSeeker
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.ReadSeeker.Read(p)
+}
+
+// countingWriter counts the body bytes of the content written, leaving out
+// the error messages http.ServeContent writes for failed preconditions and
+// unsatisfiable ranges
+type countingWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *countingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	if w.status == 0 || w.status < http.StatusMultipleChoices {
+		w.bytes += int64(n)
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *countingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,144 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// contentModTime is the Last-Modified time of the served content
+var contentModTime = time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// contentBody is the payload served, 26 bytes
+const contentBody = "abcdefghijklmnopqrstuvwxyz"
+
+// serveTestContent serves contentBody at route and returns the response
+func serveTestContent(t *testing.T, route string, req *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+
+	log := logger.New(logger.WithOutput(io.Discard))
+	handler := NewHandler(&service.Services{Log: log}, log)
+	mux := http.NewServeMux()
+	mux.HandleFunc(route, func(w http.ResponseWriter, r *http.Request) {
+		handler.serveContent(w, r, Content{
+			Name:    "export.txt",
+			ModTime: contentModTime,
+			ETag:    `"v1"`,
+			Body:    strings.NewReader(contentBody),
+		})
+	})
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandler_serveContent(t *testing.T) {
+	tests := []struct {
+		name         string
+		header       map[string]string
+		wantStatus   int
+		wantBody     string
+		wantRange    string
+		wantBodySent int
+	}{
+		{name: "full download", wantStatus: http.StatusOK, wantBody: contentBody},
+		{name: "satisfiable range", header: map[string]string{"Range": "bytes=10-19"},
+			wantStatus: http.StatusPartialContent, wantBody: "klmnopqrst", wantRange: "bytes 10-19/26"},
+		{name: "resumed download", header: map[string]string{"Range": "bytes=20-"},
+			wantStatus: http.StatusPartialContent, wantBody: "uvwxyz", wantRange: "bytes 20-25/26"},
+		{name: "suffix range", header: map[string]string{"Range": "bytes=-3"},
+			wantStatus: http.StatusPartialContent, wantBody: "xyz", wantRange: "bytes 23-25/26"},
+		{name: "unsatisfiable range", header: map[string]string{"Range": "bytes=30-40"},
+			wantStatus: http.StatusRequestedRangeNotSatisfiable, wantRange: "bytes */26"},
+		{name: "range of a current version", header: map[string]string{"Range": "bytes=0-2", "If-Range": `"v1"`},
+			wantStatus: http.StatusPartialContent, wantBody: "abc", wantRange: "bytes 0-2/26"},
+		{name: "range of a stale version", header: map[string]string{"Range": "bytes=0-2", "If-Range": `"v0"`},
+			wantStatus: http.StatusOK, wantBody: contentBody},
+		{name: "matching etag", header: map[string]string{"If-None-Match": `"v1"`}, wantStatus: http.StatusNotModified},
+		{name: "changed etag", header: map[string]string{"If-None-Match": `"v0"`}, wantStatus: http.StatusOK, wantBody: contentBody},
+		{name: "not modified since", header: map[string]string{"If-Modified-Since": contentModTime.Format(http.TimeFormat)},
+			wantStatus: http.StatusNotModified},
+		{name: "modified since", header: map[string]string{"If-Modified-Since": contentModTime.Add(-time.Hour).Format(http.TimeFormat)},
+			wantStatus: http.StatusOK, wantBody: contentBody},
+		{name: "failed precondition", header: map[string]string{"If-Match": `"v0"`}, wantStatus: http.StatusPreconditionFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			route := "GET /content/" + strings.ReplaceAll(tt.name, " ", "-")
+			req := httptest.NewRequest(http.MethodGet, strings.TrimPrefix(route, "GET "), nil)
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+
+			// Act
+			rr := serveTestContent(t, route, req)
+
+			// Assert
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, rr.Body.String())
+			}
+			assert.Equal(t, tt.wantRange, rr.Header().Get("Content-Range"))
+			if tt.wantStatus != http.StatusRequestedRangeNotSatisfiable && tt.wantStatus != http.StatusPreconditionFailed {
+				assert.Equal(t, `"v1"`, rr.Header().Get("ETag"))
+			}
+			assert.Equal(t, float64(len(tt.wantBody)), testutil.ToFloat64(metrics.HTTPContentBytes.WithLabelValues(route)))
+			if tt.wantStatus == http.StatusOK || tt.wantStatus == http.StatusPartialContent {
+				assert.Equal(t, "bytes", rr.Header().Get("Accept-Ranges"))
+				assert.Equal(t, contentModTime.Format(http.TimeFormat), rr.Header().Get("Last-Modified"))
+				assert.Equal(t, "text/plain; charset=utf-8", rr.Header().Get("Content-Type"))
+				assert.Equal(t, `attachment; filename=export.txt`, rr.Header().Get("Content-Disposition"))
+			}
+		})
+	}
+}
+
+// cancelingReader cancels the request once the first chunk was read
+type cancelingReader struct {
+	io.ReadSeeker
+	cancel context.CancelFunc
+	reads  int
+}
+
+func (r *cancelingReader) Read(p []byte) (int, error) {
+	r.reads++
+	n, err := r.ReadSeeker.Read(p[:min(len(p), 4)])
+	r.cancel()
+	return n, err
+}
+
+func TestHandler_serveContent_Canceled(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	log := logger.New(logger.WithOutput(&logs), logger.WithLevel(zerolog.DebugLevel))
+	handler := NewHandler(&service.Services{Log: log}, log)
+	ctx, cancel := context.WithCancel(context.Background())
+	body := &cancelingReader{ReadSeeker: strings.NewReader(contentBody), cancel: cancel}
+	req := httptest.NewRequest(http.MethodGet, "/content/canceled", nil).WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.serveContent(rr, req, Content{Name: "export.txt", Body: body})
+
+	// Assert
+	assert.Equal(t, 1, body.reads, "the copy stops once the request is canceled")
+	assert.Equal(t, "abcd", rr.Body.String())
+	require.Contains(t, logs.String(), "Content download interrupted")
+	assert.Contains(t, logs.String(), `"bytes":4`)
+}
@@ -0,0 +1,134 @@
+package http
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCORSMaxAge is how long browsers may cache the answer to a
+// preflight request unless CORS.MaxAge is set
+const defaultCORSMaxAge = 10 * time.Minute
+
+// corsExposedHeaders are the response headers scripts of other origins may read
+const corsExposedHeaders = "Location, X-Consistency-Token"
+
+// CORS is what pages served by other origins may do with the API
+type CORS struct {
+	// Origins may call the API, "*" allowing any origin. Without origins,
+	// cross-origin calls are not allowed.
+	Origins []string
+	// Methods and Headers are those preflight requests may ask for, any
+	// when empty
+	Methods []string
+	Headers []string
+	// MaxAge is how long browsers may cache the answer to a preflight
+	// request, 10m by default
+	MaxAge time.Duration
+	// AllowCredentials lets requests carry cookies and HTTP authentication.
+	// "*" then matches no origin, as browsers refuse credentials for it.
+	AllowCredentials bool
+}
+
+// WithCORS lets browsers call the API from pages served by the origins of
+// the policy
+func WithCORS(policy CORS) HandlerOption {
+	return func(h *Handler) {
+		h.corsPolicy = policy
+	}
+}
+
+// corsAllowed reports whether the origin may call the API
+func (h *Handler) corsAllowed(origin string) bool {
+	if slices.Contains(h.corsPolicy.Origins, origin) {
+		return true
+	}
+	return !h.corsPolicy.AllowCredentials && slices.Contains(h.corsPolicy.Origins, "*")
+}
+
+// corsPreflightAllowed reports whether the method and comma-separated
+// headers of a preflight request are allowed
+func (h *Handler) corsPreflightAllowed(method, headers string) bool {
+	p := h.corsPolicy
+	if len(p.Methods) > 0 && !slices.Contains(p.Methods, method) {
+		return false
+	}
+	if len(p.Headers) == 0 {
+		return true
+	}
+	for _, name := range strings.Split(headers, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && !slices.ContainsFunc(p.Headers, func(allowed string) bool { return strings.EqualFold(allowed, name) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// Middleware answering CORS preflight requests and allowing the origins of
+// WithCORS to read responses. It runs before routing, since the routes do
+// not accept OPTIONS.
+func (h *Handler) cors(next http.Handler) http.Handler {
+	if len(h.corsPolicy.Origins) == 0 {
+		return next
+	}
+
+	maxAge := h.corsPolicy.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultCORSMaxAge
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Responses differ by origin, shared caches must not serve the
+		// answer for one origin to another
+		header := w.Header()
+		header.Add("Vary", "Origin")
+
+		origin := r.Header.Get("Origin")
+		if origin == "" || !h.corsAllowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		method := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || method == "" {
+			h.setCORSOrigin(header, origin)
+			header.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		requested := r.Header.Get("Access-Control-Request-Headers")
+		if !h.corsPreflightAllowed(method, requested) {
+			// Without the allow headers the browser fails the request
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		h.setCORSOrigin(header, origin)
+		if methods := h.corsPolicy.Methods; len(methods) > 0 {
+			header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		} else {
+			header.Set("Access-Control-Allow-Methods", method)
+		}
+		if headers := h.corsPolicy.Headers; len(headers) > 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+		} else if requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge.Seconds())))
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// setCORSOrigin allows the origin to read the response
+func (h *Handler) setCORSOrigin(header http.Header, origin string) {
+	header.Set("Access-Control-Allow-Origin", origin)
+	if h.corsPolicy.AllowCredentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -0,0 +1,186 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+func newCORSHandler(policy CORS) (*MockUserService, *Handler) {
+	svc := new(MockUserService)
+	log := logger.New()
+	return svc, NewHandler(&service.Services{User: svc, Log: log}, log, WithCORS(policy))
+}
+
+// preflight returns a preflight request from origin for a request with the
+// method and comma-separated headers
+func preflight(origin, method, headers string) *http.Request {
+	req := httptest.NewRequest(http.MethodOptions, "/api/v1/users", nil)
+	req.Header.Set("Origin", origin)
+	req.Header.Set("Access-Control-Request-Method", method)
+	if headers != "" {
+		req.Header.Set("Access-Control-Request-Headers", headers)
+	}
+	return req
+}
+
+func TestHandler_cors_Preflight(t *testing.T) {
+	// Arrange
+	_, handler := newCORSHandler(CORS{Origins: []string{"https://app.example.com"}})
+	req := preflight("https://app.example.com", http.MethodPost, "Content-Type")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+	assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, http.MethodPost, rr.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", rr.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "600", rr.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, rr.Header().Values("Vary"))
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Credentials"))
+}
+
+func TestHandler_cors_Request(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          CORS
+		origin          string
+		wantAllow       string
+		wantCredentials string
+		wantVary        []string
+	}{
+		{
+			name:      "listed origin",
+			policy:    CORS{Origins: []string{"https://app.example.com"}},
+			origin:    "https://app.example.com",
+			wantAllow: "https://app.example.com",
+			wantVary:  []string{"Origin"},
+		},
+		{
+			name:      "any origin",
+			policy:    CORS{Origins: []string{"*"}},
+			origin:    "http://localhost:3000",
+			wantAllow: "http://localhost:3000",
+			wantVary:  []string{"Origin"},
+		},
+		{
+			name:     "other origin",
+			policy:   CORS{Origins: []string{"https://app.example.com"}},
+			origin:   "https://evil.example",
+			wantVary: []string{"Origin"},
+		},
+		{
+			name:     "no origin",
+			policy:   CORS{Origins: []string{"https://app.example.com"}},
+			wantVary: []string{"Origin"},
+		},
+		{
+			name:            "credentials",
+			policy:          CORS{Origins: []string{"https://app.example.com"}, AllowCredentials: true},
+			origin:          "https://app.example.com",
+			wantAllow:       "https://app.example.com",
+			wantCredentials: "true",
+			wantVary:        []string{"Origin"},
+		},
+		{
+			name:     "credentials rule out any origin",
+			policy:   CORS{Origins: []string{"*"}, AllowCredentials: true},
+			origin:   "https://evil.example",
+			wantVary: []string{"Origin"},
+		},
+		{
+			name:   "disabled",
+			origin: "https://app.example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			svc, handler := newCORSHandler(tt.policy)
+			svc.On("GetByID", mock.Anything, "user-1").Return(&domain.User{ID: "user-1"}, nil)
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/users/user-1", nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, http.StatusOK, rr.Code)
+			assert.Equal(t, tt.wantAllow, rr.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tt.wantCredentials, rr.Header().Get("Access-Control-Allow-Credentials"))
+			assert.Equal(t, tt.wantVary, rr.Header().Values("Vary"))
+		})
+	}
+}
+
+func TestHandler_cors_ConfiguredPreflight(t *testing.T) {
+	policy := CORS{
+		Origins:          []string{"https://app.example.com"},
+		Methods:          []string{http.MethodGet, http.MethodPost},
+		Headers:          []string{"Content-Type", "Idempotency-Key"},
+		MaxAge:           time.Hour,
+		AllowCredentials: true,
+	}
+	tests := []struct {
+		name    string
+		method  string
+		headers string
+		allowed bool
+	}{
+		{name: "allowed", method: http.MethodPost, headers: "content-type, idempotency-key", allowed: true},
+		{name: "no headers", method: http.MethodGet, allowed: true},
+		{name: "other method", method: http.MethodDelete},
+		{name: "other header", method: http.MethodPost, headers: "Content-Type, X-Debug"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			_, handler := newCORSHandler(policy)
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler.ServeHTTP(rr, preflight("https://app.example.com", tt.method, tt.headers))
+
+			// Assert
+			assert.Equal(t, http.StatusNoContent, rr.Code)
+			assert.Equal(t, []string{"Origin", "Access-Control-Request-Method", "Access-Control-Request-Headers"}, rr.Header().Values("Vary"))
+			if !tt.allowed {
+				assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+				assert.Empty(t, rr.Header().Get("Access-Control-Allow-Methods"))
+				return
+			}
+			assert.Equal(t, "https://app.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, "GET, POST", rr.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal(t, "Content-Type, Idempotency-Key", rr.Header().Get("Access-Control-Allow-Headers"))
+			assert.Equal(t, "3600", rr.Header().Get("Access-Control-Max-Age"))
+			assert.Equal(t, "true", rr.Header().Get("Access-Control-Allow-Credentials"))
+		})
+	}
+}
+
+func TestHandler_cors_PreflightOfOtherOrigin(t *testing.T) {
+	_, handler := newCORSHandler(CORS{Origins: []string{"https://app.example.com"}})
+	req := preflight("https://evil.example", http.MethodDelete, "")
+	rr := httptest.NewRecorder()
+
+	handler.ServeHTTP(rr, req)
+
+	assert.Empty(t, rr.Header().Get("Access-Control-Allow-Origin"))
+	assert.NotEqual(t, http.StatusNoContent, rr.Code)
+}
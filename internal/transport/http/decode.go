@@ -0,0 +1,110 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+// decodeError explains why the body or query of a request was rejected
+type decodeError struct {
+	reason string
+}
+
+func newDecodeError(format string, args ...interface{}) error {
+	return &decodeError{reason: fmt.Sprintf(format, args...)}
+}
+
+func (e *decodeError) Error() string {
+	return domain.ErrInvalidInput.Error() + ": " + e.reason
+}
+
+func (e *decodeError) Unwrap() error {
+	return domain.ErrInvalidInput
+}
+
+// Details returns the reason exposed to clients
+func (e *decodeError) Details() string {
+	return e.reason
+}
+
+// decodeJSONBody decodes the single JSON value of the request body into dst.
+// Numbers decoded into interface{} are kept as json.Number so they do not
+// lose precision, and type mismatches name the field and the expected type.
+func (h *Handler) decodeJSONBody(r *http.Request, dst interface{}) error {
+	defer r.Body.Close()
+
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(dst); err != nil {
+		return translateDecodeError(err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return newDecodeError("body must contain a single JSON value")
+	}
+
+	return nil
+}
+
+// translateDecodeError turns errors of the JSON decoder into errors wrapping
+// domain.ErrInvalidInput, with a reason for clients where one is known
+func translateDecodeError(err error) error {
+	var (
+		typeErr    *json.UnmarshalTypeError
+		syntaxErr  *json.SyntaxError
+		invalidErr *json.InvalidUnmarshalError
+	)
+	switch {
+	case errors.As(err, &typeErr):
+		expected := describeJSONType(typeErr.Type)
+		// A whole number that was rejected does not fit the integer type
+		if number, ok := strings.CutPrefix(typeErr.Value, "number "); ok && expected == "an integer" &&
+			!strings.ContainsAny(number, ".eE") {
+			expected = fmt.Sprintf("an integer that fits %s", typeErr.Type)
+		}
+		if typeErr.Field == "" {
+			return newDecodeError("body must be %s", expected)
+		}
+		return newDecodeError("field %q must be %s", typeErr.Field, expected)
+	case errors.As(err, &syntaxErr):
+		return newDecodeError("malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.Is(err, io.EOF):
+		return newDecodeError("body is empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return newDecodeError("body is truncated")
+	case errors.As(err, &invalidErr):
+		// dst is not a pointer, a bug rather than a bad request
+		return err
+	}
+	return fmt.Errorf("%w: %v", domain.ErrInvalidInput, err)
+}
+
+// describeJSONType names the JSON type a Go type is decoded from
+func describeJSONType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a " + t.String()
+}
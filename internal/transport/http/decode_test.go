@@ -0,0 +1,138 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// decodeTarget covers every JSON type a request model may declare
+type decodeTarget struct {
+	Name    string                 `json:"name"`
+	Count   int64                  `json:"count"`
+	Small   int8                   `json:"small"`
+	Ratio   float64                `json:"ratio"`
+	Enabled bool                   `json:"enabled"`
+	Tags    []string               `json:"tags"`
+	Nested  struct{ ID string }    `json:"nested"`
+	Extra   map[string]interface{} `json:"extra"`
+}
+
+func decodeBody(body string, dst interface{}) error {
+	h := NewHandler(&service.Services{}, logger.New())
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	return h.decodeJSONBody(req, dst)
+}
+
+func TestHandler_decodeJSONBody_Rejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		details string
+	}{
+		{"number as string", `{"name": 42}`, `field "name" must be a string`},
+		{"string as integer", `{"count": "42"}`, `field "count" must be an integer`},
+		{"fraction as integer", `{"count": 1.5}`, `field "count" must be an integer`},
+		{"integer overflow", `{"small": 300}`, `field "small" must be an integer that fits int8`},
+		{"beyond int64", `{"count": 9223372036854775808}`, `field "count" must be an integer that fits int64`},
+		{"string as boolean", `{"enabled": "true"}`, `field "enabled" must be a boolean`},
+		{"number as boolean", `{"enabled": 1}`, `field "enabled" must be a boolean`},
+		{"string as number", `{"ratio": "0.5"}`, `field "ratio" must be a number`},
+		{"object as array", `{"tags": {}}`, `field "tags" must be an array`},
+		{"number in array", `{"tags": ["a", 1]}`, `field "tags.1" must be a string`},
+		{"nested field", `{"nested": {"ID": 7}}`, `field "nested.ID" must be a string`},
+		{"array as body", `[]`, `body must be an object`},
+		{"malformed", `{"name": "a",}`, `malformed JSON at offset 14`},
+		{"truncated", `{"name": "a"`, `body is truncated`},
+		{"empty", ``, `body is empty`},
+		{"trailing value", `{"name": "a"} {}`, `body must contain a single JSON value`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			var dst decodeTarget
+			err := decodeBody(tt.body, &dst)
+
+			// Assert
+			require.ErrorIs(t, err, domain.ErrInvalidInput)
+			var detailed interface{ Details() string }
+			require.ErrorAs(t, err, &detailed)
+			assert.Equal(t, tt.details, detailed.Details())
+		})
+	}
+}
+
+func TestHandler_decodeJSONBody_KeepsLargeNumbersExact(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want interface{}
+		got  func(dst *decodeTarget) interface{}
+	}{
+		{
+			// 2^53 + 1 is the first integer a float64 cannot represent
+			name: "integer field",
+			body: `{"count": 9007199254740993}`,
+			want: int64(9007199254740993),
+			got:  func(dst *decodeTarget) interface{} { return dst.Count },
+		},
+		{
+			name: "max int64",
+			body: `{"count": 9223372036854775807}`,
+			want: int64(9223372036854775807),
+			got:  func(dst *decodeTarget) interface{} { return dst.Count },
+		},
+		{
+			name: "untyped value",
+			body: `{"extra": {"id": 9007199254740993}}`,
+			want: json.Number("9007199254740993"),
+			got:  func(dst *decodeTarget) interface{} { return dst.Extra["id"] },
+		},
+		{
+			name: "untyped value beyond int64",
+			body: `{"extra": {"id": 123456789012345678901234567890}}`,
+			want: json.Number("123456789012345678901234567890"),
+			got:  func(dst *decodeTarget) interface{} { return dst.Extra["id"] },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			var dst decodeTarget
+			err := decodeBody(tt.body, &dst)
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, tt.got(&dst))
+		})
+	}
+}
+
+func TestHandler_createUser_FieldTypeMismatch(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	handler := NewHandler(&service.Services{User: new(MockUserService), Log: log}, log)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users", strings.NewReader(`{"email": "a@example.com", "password": true}`))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	var resp errorRS
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &resp))
+	assert.Equal(t, "invalid_input", resp.Code)
+	assert.Equal(t, `field "password" must be a string`, resp.Details)
+}
@@ -0,0 +1,67 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/explain"
+)
+
+// explainSamplingRS is the state of the sampling of query plans
+type explainSamplingRS struct {
+	Rate          float64 `json:"rate"`
+	MinCost       float64 `json:"min_cost"`
+	MinDurationMS int64   `json:"min_duration_ms"`
+}
+
+// explainSamplingRQ changes the share of reads explained, 0 disables sampling
+type explainSamplingRQ struct {
+	Rate *float64 `json:"rate"`
+}
+
+// WithExplainSampler lets administrators read and change the sample rate
+// of s at /api/v1/admin/explain-sampling, nil leaves the routes out
+func WithExplainSampler(s *explain.Sampler) HandlerOption {
+	return func(h *Handler) {
+		h.explain = s
+	}
+}
+
+// getExplainSampling reports the sample rate and the thresholds above which
+// sampled plans are logged
+func (h *Handler) getExplainSampling(w http.ResponseWriter, r *http.Request) {
+	h.respondJSON(w, http.StatusOK, h.explainSampling())
+}
+
+// setExplainSampling changes the sample rate until the process restarts,
+// e.g. to sample while a slow query is investigated
+func (h *Handler) setExplainSampling(w http.ResponseWriter, r *http.Request) {
+	var req explainSamplingRQ
+	if err := h.decodeJSONBody(r, &req); err != nil {
+		h.logError(r, "Failed to decode request body", err, map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, err)
+		return
+	}
+	if req.Rate == nil {
+		h.respondError(w, r, fmt.Errorf("%w: rate is required", domain.ErrInvalidInput))
+		return
+	}
+
+	previous := h.explain.Rate()
+	if err := h.explain.SetRate(*req.Rate); err != nil {
+		h.respondError(w, r, fmt.Errorf("%w: %w", domain.ErrInvalidInput, err))
+		return
+	}
+	h.log.Info("Explain sample rate changed", map[string]interface{}{"previous": previous, "rate": *req.Rate})
+
+	h.respondJSON(w, http.StatusOK, h.explainSampling())
+}
+
+func (h *Handler) explainSampling() explainSamplingRS {
+	return explainSamplingRS{
+		Rate:          h.explain.Rate(),
+		MinCost:       h.explain.MinCost(),
+		MinDurationMS: h.explain.MinDuration().Milliseconds(),
+	}
+}
@@ -0,0 +1,76 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/explain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+func TestHandler_explainSampling(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	sampler := explain.NewSampler(0, log, explain.WithMinCost(500), explain.WithMinDuration(250*time.Millisecond))
+	handler := NewHandler(&service.Services{Log: log}, log, WithExplainSampler(sampler))
+	req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/explain-sampling", strings.NewReader(`{"rate": 0.05}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.Internal().ServeHTTP(rr, req)
+
+	// Assert
+	require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+	var body explainSamplingRS
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, explainSamplingRS{Rate: 0.05, MinCost: 500, MinDurationMS: 250}, body)
+	assert.Equal(t, 0.05, sampler.Rate())
+
+	rr = httptest.NewRecorder()
+	handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/explain-sampling", nil))
+	assert.JSONEq(t, `{"rate": 0.05, "min_cost": 500, "min_duration_ms": 250}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/explain-sampling", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "not served on the public port")
+}
+
+func TestHandler_explainSampling_InvalidRate(t *testing.T) {
+	for _, body := range []string{`{"rate": 1.5}`, `{"rate": -1}`, `{}`} {
+		t.Run(body, func(t *testing.T) {
+			// Arrange
+			log := logger.New()
+			sampler := explain.NewSampler(0.01, log)
+			handler := NewHandler(&service.Services{Log: log}, log, WithExplainSampler(sampler))
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/explain-sampling", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler.Internal().ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Equal(t, 0.01, sampler.Rate())
+		})
+	}
+}
+
+func TestHandler_explainSampling_WithoutSampler(t *testing.T) {
+	log := logger.New()
+	handler := NewHandler(&service.Services{Log: log}, log)
+	rr := httptest.NewRecorder()
+
+	handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/explain-sampling", nil))
+
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -0,0 +1,332 @@
+// Package filter parses the expressions list endpoints select records with,
+// e.g. `created_at:gte:2024-01-01 AND (name:prefix:Ada OR NOT email_domain:eq:example.com)`.
+//
+// A term compares a field with a value, field:operator:value. Terms combine
+// with NOT, AND and OR, binding in that order from tightest to loosest, and
+// parentheses group them. Values holding spaces or parentheses are quoted,
+// "like this", with \" and \\ escaping a quote and a backslash.
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits on the expressions Parse accepts, so that a request cannot make
+// the database evaluate an arbitrarily large condition
+const (
+	MaxTerms = 16
+	MaxDepth = 8
+)
+
+// Op is an operator comparing a field with a value
+type Op string
+
+// Operators a term may use. Fields allow a subset, see Schema.
+const (
+	Eq     Op = "eq"
+	Ne     Op = "ne"
+	Lt     Op = "lt"
+	Lte    Op = "lte"
+	Gt     Op = "gt"
+	Gte    Op = "gte"
+	Prefix Op = "prefix"
+)
+
+var ops = map[Op]bool{Eq: true, Ne: true, Lt: true, Lte: true, Gt: true, Gte: true, Prefix: true}
+
+// Expr is a node of a parsed expression: *Term, *Not, *And or *Or
+type Expr interface {
+	// String returns the expression with its grouping made explicit
+	String() string
+	expr()
+}
+
+// Term compares a field with a value
+type Term struct {
+	Field string
+	Op    Op
+	Value string
+	// Pos is the position of the field in the expression, starting at 1
+	Pos int
+}
+
+// Not negates an expression
+type Not struct {
+	X Expr
+}
+
+// And holds when both expressions hold
+type And struct {
+	Left, Right Expr
+}
+
+// Or holds when either expression holds
+type Or struct {
+	Left, Right Expr
+}
+
+func (*Term) expr() {}
+func (*Not) expr()  {}
+func (*And) expr()  {}
+func (*Or) expr()   {}
+
+func (t *Term) String() string {
+	value := t.Value
+	if value == "" || strings.ContainsAny(value, " \t()\"\\") {
+		value = strconv.Quote(value)
+	}
+	return t.Field + ":" + string(t.Op) + ":" + value
+}
+
+func (n *Not) String() string { return "NOT " + n.X.String() }
+func (a *And) String() string { return "(" + a.Left.String() + " AND " + a.Right.String() + ")" }
+func (o *Or) String() string  { return "(" + o.Left.String() + " OR " + o.Right.String() + ")" }
+
+// Error is an expression that does not parse or that a Schema rejects
+type Error struct {
+	// Pos is the position of the offending character, starting at 1
+	Pos    int
+	Reason string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("position %d: %s", e.Pos, e.Reason)
+}
+
+func errorAt(pos int, format string, args ...interface{}) *Error {
+	return &Error{Pos: pos, Reason: fmt.Sprintf(format, args...)}
+}
+
+// Parse parses an expression. The error is an *Error.
+func Parse(raw string) (Expr, error) {
+	p := &parser{src: raw}
+	p.next()
+	if p.tok.kind == tokEOF {
+		return nil, errorAt(1, "empty expression")
+	}
+
+	e, err := p.or(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.unexpected("AND, OR or the end")
+	}
+	return e, nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokLParen
+	tokRParen
+	tokAnd
+	tokOr
+	tokNot
+	tokTerm
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	term *Term
+	pos  int
+	err  *Error
+}
+
+// parser is a recursive descent parser reading one token ahead
+type parser struct {
+	src   string
+	off   int
+	tok   token
+	terms int
+}
+
+func (p *parser) or(depth int) (Expr, error) {
+	left, err := p.and(depth)
+	for err == nil && p.tok.kind == tokOr {
+		p.next()
+		var right Expr
+		if right, err = p.and(depth); err == nil {
+			left = &Or{Left: left, Right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) and(depth int) (Expr, error) {
+	left, err := p.unary(depth)
+	for err == nil && p.tok.kind == tokAnd {
+		p.next()
+		var right Expr
+		if right, err = p.unary(depth); err == nil {
+			left = &And{Left: left, Right: right}
+		}
+	}
+	return left, err
+}
+
+func (p *parser) unary(depth int) (Expr, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokNot:
+		p.next()
+		x, err := p.unary(depth)
+		if err != nil {
+			return nil, err
+		}
+		return &Not{X: x}, nil
+	case tokLParen:
+		if depth >= MaxDepth {
+			return nil, errorAt(tok.pos, "parentheses nest deeper than %d levels", MaxDepth)
+		}
+		p.next()
+		x, err := p.or(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokRParen {
+			return nil, p.unexpected(`")"`)
+		}
+		p.next()
+		return x, nil
+	case tokTerm:
+		if tok.err != nil {
+			return nil, tok.err
+		}
+		if p.terms++; p.terms > MaxTerms {
+			return nil, errorAt(tok.pos, "more than %d terms", MaxTerms)
+		}
+		p.next()
+		return tok.term, nil
+	default:
+		return nil, p.unexpected("a term, NOT or (")
+	}
+}
+
+// unexpected reports the current token where another was expected
+func (p *parser) unexpected(want string) *Error {
+	if p.tok.err != nil {
+		return p.tok.err
+	}
+	if p.tok.kind == tokEOF {
+		return errorAt(p.tok.pos, "unexpected end, want %s", want)
+	}
+	return errorAt(p.tok.pos, "unexpected %q, want %s", p.tok.text, want)
+}
+
+// next reads the token after the current one
+func (p *parser) next() {
+	for p.off < len(p.src) && (p.src[p.off] == ' ' || p.src[p.off] == '\t') {
+		p.off++
+	}
+	start := p.off
+	p.tok = token{pos: start + 1}
+	if start == len(p.src) {
+		p.tok.kind = tokEOF
+		return
+	}
+
+	switch p.src[start] {
+	case '(':
+		p.off++
+		p.tok.kind, p.tok.text = tokLParen, "("
+		return
+	case ')':
+		p.off++
+		p.tok.kind, p.tok.text = tokRParen, ")"
+		return
+	}
+
+	word := p.word()
+	p.tok.text = word
+	switch word {
+	case "AND":
+		p.tok.kind = tokAnd
+	case "OR":
+		p.tok.kind = tokOr
+	case "NOT":
+		p.tok.kind = tokNot
+	default:
+		p.tok.kind = tokTerm
+		p.tok.term, p.tok.err = p.term(word, start)
+	}
+}
+
+// word reads up to the next space or parenthesis outside of quotes
+func (p *parser) word() string {
+	start := p.off
+	quoted := false
+	for ; p.off < len(p.src); p.off++ {
+		c := p.src[p.off]
+		switch {
+		case quoted && c == '\\' && p.off+1 < len(p.src):
+			p.off++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ' ' || c == '\t' || c == '(' || c == ')'):
+			return p.src[start:p.off]
+		}
+	}
+	return p.src[start:p.off]
+}
+
+// term parses a field:operator:value word starting at offset start
+func (p *parser) term(word string, start int) (*Term, *Error) {
+	switch upper := strings.ToUpper(word); upper {
+	case "AND", "OR", "NOT":
+		return nil, errorAt(start+1, "write %q as %s", word, upper)
+	}
+
+	field, rest, ok := strings.Cut(word, ":")
+	op, value, ok2 := strings.Cut(rest, ":")
+	if !ok || !ok2 || field == "" {
+		return nil, errorAt(start+1, "term %q must be of the form field:operator:value", word)
+	}
+	opPos := start + len(field) + 2
+	if !ops[Op(op)] {
+		return nil, errorAt(opPos, "unknown operator %q", op)
+	}
+
+	valuePos := opPos + len(op) + 1
+	if strings.HasPrefix(value, `"`) {
+		unquoted, err := unquote(value)
+		if err != nil {
+			return nil, errorAt(valuePos, "%s", err)
+		}
+		value = unquoted
+	} else if i := strings.IndexByte(value, '"'); i >= 0 {
+		return nil, errorAt(valuePos+i, "quote inside an unquoted value")
+	}
+	if value == "" {
+		return nil, errorAt(valuePos, "missing value")
+	}
+
+	return &Term{Field: field, Op: Op(op), Value: value, Pos: start + 1}, nil
+}
+
+// unquote returns the content of a quoted value
+func unquote(quoted string) (string, error) {
+	var b strings.Builder
+	for i := 1; i < len(quoted); i++ {
+		switch c := quoted[i]; c {
+		case '\\':
+			i++
+			if i == len(quoted) || (quoted[i] != '"' && quoted[i] != '\\') {
+				return "", fmt.Errorf(`only \" and \\ may be escaped`)
+			}
+			b.WriteByte(quoted[i])
+		case '"':
+			if i != len(quoted)-1 {
+				return "", fmt.Errorf("text after the closing quote")
+			}
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", fmt.Errorf("unterminated quote")
+}
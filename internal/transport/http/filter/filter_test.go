@@ -0,0 +1,90 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{name: "term", raw: "name:eq:Ada", want: "name:eq:Ada"},
+		{name: "value with colons", raw: "created_at:gte:2024-01-01T00:00:00Z", want: "created_at:gte:2024-01-01T00:00:00Z"},
+		{name: "quoted value", raw: `name:eq:"Ada \"The Countess\" (1815)"`, want: `name:eq:"Ada \"The Countess\" (1815)"`},
+		{name: "AND binds tighter than OR", raw: "a:eq:1 OR b:eq:2 AND c:eq:3", want: "(a:eq:1 OR (b:eq:2 AND c:eq:3))"},
+		{name: "NOT binds tighter than AND", raw: "NOT a:eq:1 AND b:eq:2", want: "(NOT a:eq:1 AND b:eq:2)"},
+		{name: "left associative", raw: "a:eq:1 AND b:eq:2 AND c:eq:3", want: "((a:eq:1 AND b:eq:2) AND c:eq:3)"},
+		{name: "parentheses group", raw: "(a:eq:1 OR b:eq:2) AND c:eq:3", want: "((a:eq:1 OR b:eq:2) AND c:eq:3)"},
+		{name: "negated group", raw: "NOT (a:eq:1 OR b:eq:2)", want: "NOT (a:eq:1 OR b:eq:2)"},
+		{name: "spacing", raw: "  (a:eq:1)AND(NOT\tb:eq:2) ", want: "(a:eq:1 AND NOT b:eq:2)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			expr, err := Parse(tt.raw)
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, expr.String())
+		})
+	}
+}
+
+func TestParse_Term(t *testing.T) {
+	expr, err := Parse(`x:eq:1 AND name:prefix:"Ada L"`)
+
+	require.NoError(t, err)
+	assert.Equal(t, &Term{Field: "name", Op: Prefix, Value: "Ada L", Pos: 12}, expr.(*And).Right)
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantErr string
+	}{
+		{raw: "", wantErr: "position 1: empty expression"},
+		{raw: "name", wantErr: `position 1: term "name" must be of the form field:operator:value`},
+		{raw: "name:Ada", wantErr: `position 1: term "name:Ada" must be of the form field:operator:value`},
+		{raw: "a:eq:1 AND name:like:Ada", wantErr: `position 17: unknown operator "like"`},
+		{raw: "name:eq:", wantErr: "position 9: missing value"},
+		{raw: `name:eq:"Ada`, wantErr: "position 9: unterminated quote"},
+		{raw: `name:eq:Ad"a`, wantErr: "position 11: quote inside an unquoted value"},
+		{raw: "a:eq:1 b:eq:2", wantErr: `position 8: unexpected "b:eq:2", want AND, OR or the end`},
+		{raw: "a:eq:1 AND", wantErr: "position 11: unexpected end, want a term, NOT or ("},
+		{raw: "a:eq:1 and b:eq:2", wantErr: `position 8: write "and" as AND`},
+		{raw: "(a:eq:1 OR b:eq:2", wantErr: `position 18: unexpected end, want ")"`},
+		{raw: "a:eq:1)", wantErr: `position 7: unexpected ")", want AND, OR or the end`},
+		{raw: "NOT OR a:eq:1", wantErr: `position 5: unexpected "OR", want a term, NOT or (`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			// Act
+			_, err := Parse(tt.raw)
+
+			// Assert
+			var parseErr *Error
+			require.ErrorAs(t, err, &parseErr)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParse_Limits(t *testing.T) {
+	terms := strings.Repeat("a:eq:1 OR ", MaxTerms) + "a:eq:1"
+	_, err := Parse(terms)
+	assert.EqualError(t, err, "position 161: more than 16 terms")
+
+	_, err = Parse(strings.Repeat("(", MaxDepth) + "a:eq:1" + strings.Repeat(")", MaxDepth))
+	assert.NoError(t, err)
+
+	_, err = Parse(strings.Repeat("(", MaxDepth+1) + "a:eq:1" + strings.Repeat(")", MaxDepth+1))
+	assert.EqualError(t, err, "position 9: parentheses nest deeper than 8 levels")
+}
@@ -0,0 +1,87 @@
+package filter
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Field declares a field expressions may compare and how
+type Field struct {
+	// Ops are the operators allowed on the field
+	Ops []Op
+	// Value converts a value to the string or time.Time compared with the
+	// field, nil compares the value as given
+	Value func(raw string) (interface{}, error)
+}
+
+// Schema is the whitelist of the fields, by name, a resource can be filtered on
+type Schema map[string]Field
+
+// Time converts RFC 3339 times and dates, the latter as midnight UTC
+func Time(raw string) (interface{}, error) {
+	if t, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, raw)
+	if err != nil {
+		return nil, fmt.Errorf("must be an RFC 3339 time or a date")
+	}
+	return t, nil
+}
+
+// Check returns an *Error for the first term using a field or an operator
+// the schema does not allow, or holding a value the field does not accept
+func (s Schema) Check(e Expr) error {
+	switch e := e.(type) {
+	case *Not:
+		return s.Check(e.X)
+	case *And:
+		if err := s.Check(e.Left); err != nil {
+			return err
+		}
+		return s.Check(e.Right)
+	case *Or:
+		if err := s.Check(e.Left); err != nil {
+			return err
+		}
+		return s.Check(e.Right)
+	}
+
+	t := e.(*Term)
+	field, ok := s[t.Field]
+	if !ok {
+		return errorAt(t.Pos, "unknown field %q, valid fields are %s", t.Field, strings.Join(slices.Sorted(maps.Keys(s)), ", "))
+	}
+	opPos := t.Pos + len(t.Field) + 1
+	if !slices.Contains(field.Ops, t.Op) {
+		return errorAt(opPos, "operator %q is not allowed on %s, valid operators are %s", t.Op, t.Field, joinOps(field.Ops))
+	}
+	if _, err := field.value(t.Value); err != nil {
+		return errorAt(opPos+len(t.Op)+1, "%s %v", t.Field, err)
+	}
+	return nil
+}
+
+// Value returns the value of a term of a checked expression, converted by
+// its field
+func (s Schema) Value(t *Term) (interface{}, error) {
+	return s[t.Field].value(t.Value)
+}
+
+func (f Field) value(raw string) (interface{}, error) {
+	if f.Value == nil {
+		return raw, nil
+	}
+	return f.Value(raw)
+}
+
+func joinOps(ops []Op) string {
+	names := make([]string, len(ops))
+	for i, op := range ops {
+		names[i] = string(op)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,81 @@
+package filter
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSchema = Schema{
+	"name":       {Ops: []Op{Eq, Ne, Prefix}},
+	"domain":     {Ops: []Op{Eq}, Value: func(raw string) (interface{}, error) { return strings.ToLower(raw), nil }},
+	"created_at": {Ops: []Op{Lt, Lte, Gt, Gte}, Value: Time},
+}
+
+func mustParse(t *testing.T, raw string) Expr {
+	t.Helper()
+	expr, err := Parse(raw)
+	require.NoError(t, err)
+	require.NoError(t, testSchema.Check(expr))
+	return expr
+}
+
+func TestSchema_Check(t *testing.T) {
+	tests := []struct {
+		raw     string
+		wantErr string
+	}{
+		{raw: "name:prefix:Ada AND NOT created_at:gte:2024-01-01"},
+		{raw: "name:eq:Ada OR status:eq:active", wantErr: `position 16: unknown field "status", valid fields are created_at, domain, name`},
+		{raw: "NOT created_at:eq:2024-01-01", wantErr: `position 16: operator "eq" is not allowed on created_at, valid operators are lt, lte, gt, gte`},
+		{raw: "(name:eq:Ada) AND created_at:gt:yesterday", wantErr: "position 33: created_at must be an RFC 3339 time or a date"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			// Arrange
+			expr, err := Parse(tt.raw)
+			require.NoError(t, err)
+
+			// Act
+			err = testSchema.Check(expr)
+
+			// Assert
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var checkErr *Error
+			require.ErrorAs(t, err, &checkErr)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSchema_Value(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want interface{}
+	}{
+		{raw: "name:eq:Ada", want: "Ada"},
+		{raw: "domain:eq:Example.COM", want: "example.com"},
+		{raw: "created_at:lt:2024-01-01", want: time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			// Arrange
+			term := mustParse(t, tt.raw).(*Term)
+
+			// Act
+			value, err := testSchema.Value(term)
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, value)
+		})
+	}
+}
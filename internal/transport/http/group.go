@@ -0,0 +1,139 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+)
+
+// routeGroup names routes sharing a cap on the requests they run at once,
+// whoever makes them
+type routeGroup string
+
+const (
+	// groupBulk are the bulk mutations, such as user imports and bulk deletes
+	groupBulk routeGroup = "bulk"
+	// groupExport are the full listings, which read whole tables
+	groupExport routeGroup = "export"
+)
+
+// defaultGroupWait is how long a request waits for a slot of its group
+const defaultGroupWait = 250 * time.Millisecond
+
+// groupLimit caps the requests of a route group running at once. Requests
+// past the cap wait for a slot, up to wait, before they are rejected.
+type groupLimit struct {
+	group routeGroup
+	limit int
+	slots chan struct{}
+	wait  time.Duration
+}
+
+func newGroupLimit(group routeGroup, limit int, wait time.Duration) *groupLimit {
+	metrics.HTTPGroupLimit.WithLabelValues(string(group)).Set(float64(limit))
+	metrics.HTTPGroupInFlight.WithLabelValues(string(group)).Set(0)
+	metrics.HTTPGroupWaiting.WithLabelValues(string(group)).Set(0)
+	return &groupLimit{group: group, limit: limit, slots: make(chan struct{}, limit), wait: wait}
+}
+
+// acquire takes a slot, waiting up to the configured wait or until the
+// request is done. Acquired slots must be released.
+func (g *groupLimit) acquire(r *http.Request) bool {
+	select {
+	case g.slots <- struct{}{}:
+		metrics.HTTPGroupInFlight.WithLabelValues(string(g.group)).Inc()
+		return true
+	default:
+	}
+	if g.wait <= 0 {
+		return false
+	}
+
+	waiting := metrics.HTTPGroupWaiting.WithLabelValues(string(g.group))
+	waiting.Inc()
+	defer waiting.Dec()
+
+	timer := time.NewTimer(g.wait)
+	defer timer.Stop()
+	select {
+	case g.slots <- struct{}{}:
+		metrics.HTTPGroupInFlight.WithLabelValues(string(g.group)).Inc()
+		return true
+	case <-timer.C:
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
+
+func (g *groupLimit) release() {
+	<-g.slots
+	metrics.HTTPGroupInFlight.WithLabelValues(string(g.group)).Dec()
+}
+
+// groupLimitError reports the cap of the route group a request ran into
+type groupLimitError struct {
+	group routeGroup
+	limit int
+}
+
+func (e *groupLimitError) Error() string {
+	return fmt.Sprintf("%s: %d %s requests", errmap.ErrConcurrencyLimit, e.limit, e.group)
+}
+
+func (e *groupLimitError) Unwrap() error {
+	return errmap.ErrConcurrencyLimit
+}
+
+// Details is exposed to clients by respondError
+func (e *groupLimitError) Details() string {
+	return fmt.Sprintf("at most %d %s requests may run at once, retry later", e.limit, e.group)
+}
+
+// WithGroupConcurrency caps the requests of each route group in limits
+// running at once, across principals. A request past the cap waits up to
+// wait for a slot before it is answered with 429. Groups without a positive
+// limit are not capped.
+func WithGroupConcurrency(limits map[string]int, wait time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if wait < 0 {
+			wait = defaultGroupWait
+		}
+		for group, limit := range limits {
+			if limit > 0 {
+				h.groups[routeGroup(group)] = newGroupLimit(routeGroup(group), limit, wait)
+			}
+		}
+	}
+}
+
+// Middleware capping the requests of group running at once. It goes inside
+// the per-principal limit, so that a principal past its own limit is
+// rejected at once rather than after queueing for the group. Without a cap
+// configured for group, requests pass through.
+func (h *Handler) limitGroup(group routeGroup, next http.HandlerFunc) http.HandlerFunc {
+	limit := h.groups[group]
+	if limit == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !limit.acquire(r) {
+			metrics.HTTPGroupRejected.WithLabelValues(string(group)).Inc()
+			h.log.Warn("Route group concurrency limit exceeded", map[string]interface{}{
+				"group": group,
+				"limit": limit.limit,
+				"path":  r.URL.Path,
+			})
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(limit.wait)))
+			h.respondError(w, r, &groupLimitError{group: group, limit: limit.limit})
+			return
+		}
+		defer limit.release()
+		next(w, r)
+	}
+}
@@ -0,0 +1,170 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+func newGroupHandler(svc *blockingUserService, limit int, wait time.Duration, opts ...HandlerOption) *Handler {
+	log := logger.New()
+	opts = append(opts, WithGroupConcurrency(map[string]int{string(groupExport): limit}, wait))
+	return NewHandler(&service.Services{User: svc, Log: log}, log, opts...)
+}
+
+// listUsersAs serves a listing made by user in the background and returns
+// its response once it completed
+func listUsersAs(handler http.Handler, user string) <-chan *httptest.ResponseRecorder {
+	done := make(chan *httptest.ResponseRecorder, 1)
+	go func() {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		req.Header.Set("X-User", user)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		done <- rr
+	}()
+	return done
+}
+
+func TestHandler_limitGroup(t *testing.T) {
+	// Arrange
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	handler := newGroupHandler(svc, 2, 0)
+	inFlight := metrics.HTTPGroupInFlight.WithLabelValues("export")
+	rejectedBefore := testutil.ToFloat64(metrics.HTTPGroupRejected.WithLabelValues("export"))
+
+	running := newExports()
+	first := running.start(context.Background(), handler, svc)
+	second := running.start(context.Background(), handler, svc)
+
+	// Act
+	rejected := httptest.NewRecorder()
+	handler.ServeHTTP(rejected, httptest.NewRequest(http.MethodGet, "/api/v1/users", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusTooManyRequests, rejected.Code)
+	assert.Equal(t, "1", rejected.Header().Get("Retry-After"))
+	var resp errorRS
+	require.NoError(t, json.Unmarshal(rejected.Body.Bytes(), &resp))
+	assert.Equal(t, "concurrency_limit_exceeded", resp.Code)
+	assert.Equal(t, "at most 2 export requests may run at once, retry later", resp.Details)
+	assert.Equal(t, 2.0, testutil.ToFloat64(inFlight))
+	assert.Equal(t, 2.0, testutil.ToFloat64(metrics.HTTPGroupLimit.WithLabelValues("export")))
+	assert.Equal(t, rejectedBefore+1, testutil.ToFloat64(metrics.HTTPGroupRejected.WithLabelValues("export")))
+
+	// Act: the running requests complete
+	close(svc.release)
+	running.wg.Wait()
+
+	// Assert: their slots were freed
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, 0.0, testutil.ToFloat64(inFlight))
+}
+
+func TestHandler_limitGroup_Wait(t *testing.T) {
+	// Arrange
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	handler := newGroupHandler(svc, 1, time.Minute)
+	waiting := metrics.HTTPGroupWaiting.WithLabelValues("export")
+
+	running := newExports()
+	running.start(context.Background(), handler, svc)
+
+	// Act: a second request queues for the slot
+	queued := listUsersAs(handler, "bob")
+	require.Eventually(t, func() bool { return testutil.ToFloat64(waiting) == 1 }, time.Second, time.Millisecond)
+	svc.release <- struct{}{}
+	<-running.done
+
+	// Assert: it runs once the slot is released
+	<-svc.entered
+	assert.Equal(t, 0.0, testutil.ToFloat64(waiting))
+	svc.release <- struct{}{}
+	assert.Equal(t, http.StatusOK, (<-queued).Code)
+}
+
+func TestHandler_limitGroup_WaitTimeout(t *testing.T) {
+	// Arrange
+	const wait = 20 * time.Millisecond
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	handler := newGroupHandler(svc, 1, wait)
+	running := newExports()
+	running.start(context.Background(), handler, svc)
+
+	// Act
+	start := time.Now()
+	rr := <-listUsersAs(handler, "bob")
+
+	// Assert
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.GreaterOrEqual(t, time.Since(start), wait, "rejected after waiting")
+	assert.Equal(t, 0.0, testutil.ToFloat64(metrics.HTTPGroupWaiting.WithLabelValues("export")))
+	close(svc.release)
+	running.wg.Wait()
+}
+
+func TestHandler_limitGroup_WithPrincipalLimit(t *testing.T) {
+	// Arrange: each principal runs one listing, the group runs two
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	handler := newConcurrencyHandler(svc, 1,
+		WithGroupConcurrency(map[string]int{string(groupExport): 2}, time.Minute),
+		WithPrincipalFunc(func(r *http.Request) Principal {
+			return Principal{ID: r.Header.Get("X-User")}
+		}))
+	ann := listUsersAs(handler, "ann")
+	<-svc.entered
+
+	// Act & Assert: the principal limit rejects at once, without queueing
+	// for the group
+	rr := <-listUsersAs(handler, "ann")
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get("X-Concurrency-Limit"), "rejected by the principal limit")
+
+	bob := listUsersAs(handler, "bob")
+	<-svc.entered
+
+	// Act & Assert: the group queues a principal within its own limit
+	carol := listUsersAs(handler, "carol")
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(metrics.HTTPGroupWaiting.WithLabelValues("export")) == 1
+	}, time.Second, time.Millisecond, "carol waits for the group")
+
+	svc.release <- struct{}{}
+	svc.release <- struct{}{}
+	<-svc.entered
+	svc.release <- struct{}{}
+	for _, done := range []<-chan *httptest.ResponseRecorder{ann, bob, carol} {
+		assert.Equal(t, http.StatusOK, (<-done).Code)
+	}
+}
+
+func TestHandler_limitGroup_NotHealthRoutes(t *testing.T) {
+	// Arrange: the export group is saturated
+	svc := &blockingUserService{entered: make(chan struct{}), release: make(chan struct{})}
+	handler := newGroupHandler(svc, 1, 0)
+	running := newExports()
+	running.start(context.Background(), handler, svc)
+	defer running.wg.Wait()
+	defer close(svc.release)
+
+	for _, path := range []string{"/livez", "/readyz"} {
+		// Act
+		rr := httptest.NewRecorder()
+		handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+		// Assert
+		assert.Equal(t, http.StatusOK, rr.Code, path)
+	}
+}
@@ -1,97 +1,439 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/explain"
+	"github.com/romanitalian/carch-go/internal/pkg/health"
 	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/pkg/querybudget"
+	"github.com/romanitalian/carch-go/internal/pkg/queryreg"
+	"github.com/romanitalian/carch-go/internal/pkg/sanitize"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
 	"github.com/romanitalian/carch-go/internal/service"
+	"github.com/romanitalian/carch-go/internal/transport/accesslog"
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+	"github.com/romanitalian/carch-go/internal/transport/http/openapi"
 )
 
 type Handler struct {
 	services *service.Services
 	log      *logger.Logger
 	mux      *http.ServeMux
+	// internal serves probes, metrics and admin routes, which must never be
+	// reachable on the public port
+	internal *http.ServeMux
+
+	statementBudget       int
+	statementBudgetStrict bool
+	accessLogExclude      map[string]bool
+	accessLogDebug        map[string]bool
+	readinessCheckers     []health.Checker
+	sseHeartbeat          time.Duration
+	consistencyWindow     time.Duration
+	slowRequestThreshold  time.Duration
+	shedder               *loadShedder
+	concurrency           *concurrencyLimit
+	groups                map[routeGroup]*groupLimit
+	principalOf           PrincipalFunc
+	rateLimiter           *rateLimiter
+	trustProxy            bool
+	throttleOverrideToken string
+	trailingSlash         string
+	corsPolicy            CORS
+	queries               *queryreg.Registry
+	explain               *explain.Sampler
+	wideEvents            *wideevent.Emitter
+
+	// public and internalRoutes are the muxes behind the path normalization
+	public         http.Handler
+	internalRoutes http.Handler
+
+	routes  []openapi.Route
+	openAPI []byte
 }
 
-func NewHandler(services *service.Services, log *logger.Logger) *Handler {
+// HandlerOption is a function that configures a Handler
+type HandlerOption func(*Handler)
+
+// WithStatementBudget sets the maximum number of database statements a single
+// request may issue before a warning is logged. In strict mode statements past
+// the budget fail, which makes N+1 regressions visible in tests and dev.
+func WithStatementBudget(limit int, strict bool) HandlerOption {
+	return func(h *Handler) {
+		h.statementBudget = limit
+		h.statementBudgetStrict = strict
+	}
+}
+
+// WithAccessLogExclude disables access logging for the given request paths,
+// e.g. for frequently scraped endpoints like /metrics
+func WithAccessLogExclude(paths ...string) HandlerOption {
+	return func(h *Handler) {
+		for _, p := range paths {
+			if p = strings.TrimSpace(p); p != "" {
+				h.accessLogExclude[p] = true
+			}
+		}
+	}
+}
+
+// WithAccessLogDebug access-logs the given request paths at debug level
+// rather than info, e.g. probes polled every few seconds
+func WithAccessLogDebug(paths ...string) HandlerOption {
+	return func(h *Handler) {
+		for _, p := range paths {
+			if p = strings.TrimSpace(p); p != "" {
+				h.accessLogDebug[p] = true
+			}
+		}
+	}
+}
+
+// WithReadinessCheckers sets the checks /readyz runs before reporting ready
+func WithReadinessCheckers(checkers ...health.Checker) HandlerOption {
+	return func(h *Handler) {
+		h.readinessCheckers = append(h.readinessCheckers, checkers...)
+	}
+}
+
+// WithSSEHeartbeat sets how often idle event streams send a heartbeat comment
+func WithSSEHeartbeat(interval time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if interval > 0 {
+			h.sseHeartbeat = interval
+		}
+	}
+}
+
+func NewHandler(services *service.Services, log *logger.Logger, opts ...HandlerOption) *Handler {
 	h := &Handler{
 		services: services,
 		log:      log,
 		mux:      http.NewServeMux(),
+		internal: http.NewServeMux(),
+
+		accessLogExclude:  make(map[string]bool),
+		accessLogDebug:    make(map[string]bool),
+		groups:            make(map[routeGroup]*groupLimit),
+		sseHeartbeat:      defaultSSEHeartbeat,
+		consistencyWindow: defaultConsistencyWindow,
+		trailingSlash:     TrailingSlashRedirect,
+		queries:           queryreg.Default,
+	}
+	h.principalOf = h.clientPrincipal
+
+	for _, opt := range opts {
+		opt(h)
 	}
 
 	h.setupRoutes()
+	h.public = h.cors(h.normalizePath(h.mux))
+	h.internalRoutes = h.normalizePath(h.internal)
+
+	// The document only depends on the code, so failing to build it is a
+	// programming error like a conflicting route pattern
+	doc, err := h.OpenAPI()
+	if err != nil {
+		panic(err)
+	}
+	if h.openAPI, err = json.MarshalIndent(doc, "", "  "); err != nil {
+		panic(err)
+	}
+
 	return h
 }
 
 func (h *Handler) setupRoutes() {
-	// REST API endpoints
-	h.mux.HandleFunc("POST /api/v1/users", h.logRequest(h.createUser))
-	h.mux.HandleFunc("GET /api/v1/users/{id}", h.logRequest(h.getUserByID))
-	h.mux.HandleFunc("PUT /api/v1/users/{id}", h.logRequest(h.updateUser))
-	h.mux.HandleFunc("DELETE /api/v1/users/{id}", h.logRequest(h.deleteUser))
-	h.mux.HandleFunc("GET /api/v1/users", h.logRequest(h.listUsers))
+	// REST API endpoints. Reads are shed first under load, mutations are
+	// admitted until the hard limit. Expensive routes are limited per principal.
+	// The metadata of each route makes up the OpenAPI document, see openapi.go.
+	h.handle("POST /api/v1/users", routeCritical, h.rateLimit(h.createUser),
+		openapi.Summary("Create a user"),
+		openapi.Accepts(createUserRQ{}),
+		openapi.Returns(http.StatusCreated, domain.User{}),
+		openapi.Errors(domain.ErrEmailTaken, domain.ErrConflict, errmap.ErrRateLimited))
+	h.handle("GET /api/v1/users/{id}", routeSheddable, h.getUserByID,
+		openapi.Summary("Get a user"),
+		openapi.Returns(http.StatusOK, domain.User{}),
+		openapi.Errors(domain.ErrUserNotFound))
+	h.handle("PUT /api/v1/users/{id}", routeCritical, h.updateUser,
+		openapi.Summary("Replace the email and name of a user"),
+		openapi.Accepts(updateUserRQ{}),
+		openapi.Returns(http.StatusOK, domain.User{}),
+		openapi.Errors(domain.ErrUserNotFound, domain.ErrEmailTaken, domain.ErrEmailChangeRequiresConfirm, domain.ErrTooManyChanges),
+		openapi.Secured(adminOverride))
+	h.handle("PATCH /api/v1/users/{id}", routeCritical, h.patchUser,
+		openapi.Summary("Change some fields of a user"),
+		openapi.Description("Accepts a JSON Merge Patch (RFC 7386), also sent as application/json, or a JSON Patch (RFC 6902) limited to replace and remove."),
+		openapi.AcceptsAs(mediaTypeMergePatch, userMergePatchRQ{}),
+		openapi.AcceptsAs("application/json", userMergePatchRQ{}),
+		openapi.AcceptsAs(mediaTypeJSONPatch, []jsonPatchOp{}),
+		openapi.Returns(http.StatusOK, domain.User{}),
+		openapi.Errors(domain.ErrInvalidPatch, errmap.ErrUnsupportedMediaType, domain.ErrUserNotFound, domain.ErrEmailTaken,
+			domain.ErrEmailChangeRequiresConfirm, domain.ErrTooManyChanges),
+		openapi.Secured(adminOverride))
+	h.handle("DELETE /api/v1/users/{id}", routeCritical, h.deleteUser,
+		openapi.Summary("Delete a user"),
+		openapi.Returns(http.StatusNoContent, nil),
+		openapi.Errors(domain.ErrUserNotFound))
+	h.handle("GET /api/v1/users", routeSheddable, h.limitConcurrency(h.limitGroup(groupExport, h.listUsers)),
+		openapi.Summary("List users"),
+		openapi.Query("sort", "", "field[:locale] to order by, created_at (default) or name, e.g. name:de-DE"),
+		openapi.Query("sort_dir", "", "asc or desc, newest first and names A to Z by default"),
+		openapi.Query("limit", "", "Most users returned, up to USER_LIST_MAX_LIMIT (the default). "+
+			"X-Total-Count tells how many users the whole list holds"),
+		openapi.Query("offset", "", "Number of users skipped before the first one returned, 0 by default"),
+		openapi.Query("filter", "", "Comma-separated field:value terms the users must all match: email_domain, "+
+			"name_prefix, created_after and created_before (RFC 3339), e.g. email_domain:example.com,created_before:2024-01-01T00:00:00Z"),
+		openapi.Query("where", "", "Expression the users must also match: field:operator:value terms combined with NOT, AND, OR "+
+			"and parentheses, e.g. created_at:gte:2024-01-01 AND (name:prefix:Ada OR NOT email_domain:eq:example.com). "+
+			"Fields are id, email, email_domain, name, created_at and updated_at"),
+		openapi.Returns(http.StatusOK, []*domain.User{}),
+		openapi.Errors(errmap.ErrConcurrencyLimit))
+	h.handle("GET /api/v1/users/tombstones", routeSheddable, h.limitConcurrency(h.limitGroup(groupExport, h.listTombstones)),
+		openapi.Summary("List deleted users"),
+		openapi.Description("Lets consumers that mirror users reconcile deletions they missed."),
+		openapi.Query("since", time.Time{}, "Only users deleted after this RFC 3339 time"),
+		openapi.Returns(http.StatusOK, []*domain.Tombstone{}),
+		openapi.Errors(errmap.ErrConcurrencyLimit))
+	h.handle("POST /api/v1/users/lookup", routeSheddable, h.limitConcurrency(h.lookupUsers),
+		openapi.Summary("Resolve a batch of user IDs"),
+		openapi.Accepts(lookupUsersRQ{}),
+		openapi.Returns(http.StatusOK, lookupUsersRS{}),
+		openapi.Errors(domain.ErrTooManyIDs, errmap.ErrConcurrencyLimit))
+	h.handle("POST /api/v1/users/{id}/email-change", routeCritical, h.requestEmailChange,
+		openapi.Summary("Request an email change"),
+		openapi.Description("Sends a confirmation token to the new email. The change is applied once the token is confirmed."),
+		openapi.Accepts(emailChangeRQ{}),
+		openapi.Returns(http.StatusAccepted, nil),
+		openapi.Errors(domain.ErrUserNotFound, domain.ErrEmailTaken))
+	h.handle("DELETE /api/v1/users/{id}/email-change", routeCritical, h.cancelEmailChange,
+		openapi.Summary("Cancel the pending email change"),
+		openapi.Returns(http.StatusNoContent, nil),
+		openapi.Errors(domain.ErrEmailChangeNotFound))
+	h.handle("POST /api/v1/auth/email-change/confirm", routeCritical, h.confirmEmailChange,
+		openapi.Summary("Confirm an email change"),
+		openapi.Accepts(confirmEmailChangeRQ{}),
+		openapi.Returns(http.StatusOK, domain.User{}),
+		openapi.Errors(domain.ErrEmailChangeNotFound, domain.ErrEmailChangeExpired, domain.ErrEmailTaken))
+	h.handle("GET /api/v1/jobs/{id}", routeSheddable, h.getJob,
+		openapi.Summary("Get a job"),
+		openapi.Returns(http.StatusOK, domain.Job{}),
+		openapi.Errors(domain.ErrJobNotFound))
+	h.handle("GET /api/v1/jobs/{id}/events", routeStream, h.streamJobEvents,
+		openapi.Summary("Stream the progress of a job"),
+		openapi.Description("Server-Sent Events whose data is the job after each change. The stream ends when the job succeeds or fails."),
+		openapi.ReturnsAs(http.StatusOK, "text/event-stream", domain.Job{}),
+		openapi.Errors(domain.ErrJobNotFound))
+	h.handle("POST /api/v1/hooks/{provider}", routeCritical, h.receiveWebhook,
+		openapi.Summary("Receive a webhook"),
+		openapi.Description("Verifies the signature of the provider and queues the payload, answering before it is processed."),
+		openapi.Accepts(json.RawMessage{}),
+		openapi.Returns(http.StatusAccepted, nil),
+		openapi.Errors(domain.ErrWebhookProviderNotFound, domain.ErrWebhookSignatureInvalid, domain.ErrWebhookStale, domain.ErrWebhookReplayed),
+		openapi.Secured(webhookSignature))
+
+	// Internal endpoints
+	h.handleInternal("GET /api/v1/admin/instances", h.listInstances)
+	h.handleInternal("GET /api/v1/admin/audit", h.listAudit)
+	h.handleInternal("GET /api/v1/admin/users/{id}", h.getUserSensitive)
+	h.handleInternal("POST /api/v1/admin/users/bulk-delete", h.limitGroup(groupBulk, h.bulkDeleteUsers))
+	h.handleInternal("POST /api/v1/admin/users/import", h.limitConcurrency(h.limitGroup(groupBulk, h.importUsers)))
+	h.handleInternal("GET /api/v1/admin/queries", h.listQueries)
+	if h.explain != nil {
+		h.handleInternal("GET /api/v1/admin/explain-sampling", h.getExplainSampling)
+		h.handleInternal("PUT /api/v1/admin/explain-sampling", h.setExplainSampling)
+	}
+	h.handleInternal("GET /openapi.json", h.serveOpenAPI)
+
+	// Probes. Liveness is answered from memory outside the middleware chain
+	// so that frequent probing does not flood logs and metrics.
+	h.internal.HandleFunc("GET /livez", livez)
+	h.handleProbe("GET /readyz", h.readyz)
+
+	// Prometheus metrics
+	h.internal.Handle("GET /metrics", h.logRequest(sloExcluded, metrics.Handler().ServeHTTP))
+}
+
+// handle registers a public API route wrapped with the common middleware
+// chain. class tells the load shedder how to treat it under load, opts
+// describe it in the OpenAPI document.
+func (h *Handler) handle(pattern string, class routeClass, fn http.HandlerFunc, opts ...openapi.Option) {
+	route, err := openapi.NewRoute(pattern, opts...)
+	if err != nil {
+		panic(err)
+	}
+	h.routes = append(h.routes, route)
+	h.mux.HandleFunc(pattern, h.abortTruncated(h.emitWideEvent(h.logRequest(sloEligible, h.shedLoad(class, h.wrapInner(fn))))))
+}
+
+// handleInternal registers a route served only on the internal listener.
+// Its requests are kept out of the availability SLO.
+func (h *Handler) handleInternal(pattern string, fn http.HandlerFunc) {
+	h.internal.HandleFunc(pattern, h.wrap(fn))
+}
+
+// handleProbe registers a probe on the internal listener, left out of the SLO metrics
+func (h *Handler) handleProbe(pattern string, fn http.HandlerFunc) {
+	h.internal.HandleFunc(pattern, h.abortTruncated(h.logRequest(sloExcluded, h.wrapInner(fn))))
 }
 
-// ServeHTTP implements the http.Handler interface
+// wrap applies the common middleware chain
+func (h *Handler) wrap(fn http.HandlerFunc) http.HandlerFunc {
+	return h.abortTruncated(h.emitWideEvent(h.logRequest(sloIneligible, h.wrapInner(fn))))
+}
+
+// wrapInner applies the middleware that runs only for admitted requests.
+// Panics are recovered there, so that they are logged as failed requests.
+func (h *Handler) wrapInner(fn http.HandlerFunc) http.HandlerFunc {
+	return h.recoverPanic(h.traceSlow(h.trackStatements(h.readYourWrites(h.tenantScope(h.actorScope(fn))))))
+}
+
+// ServeHTTP implements the http.Handler interface for the public routes
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.mux.ServeHTTP(w, r)
+	h.public.ServeHTTP(w, r)
+}
+
+// Internal returns the handler of the internal routes
+func (h *Handler) Internal() http.Handler {
+	return h.internalRoutes
 }
 
-// Middleware for logging requests
-func (h *Handler) logRequest(next http.HandlerFunc) http.HandlerFunc {
+// Middleware for logging requests and counting them by status and outcome.
+// scope tells whether their outcome counts towards the availability SLO.
+// The access log entry has the fields of the gRPC one, see accesslog.
+func (h *Handler) logRequest(scope sloScope, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// Create a response wrapper to capture status code
+		requestID := accesslog.RequestID(r.Header.Get(accesslog.RequestIDHeader))
+		w.Header().Set(accesslog.RequestIDHeader, requestID)
+		// Services and repositories log with the fields of the request
+		r = r.WithContext(logger.WithContext(r.Context(), h.log.WithFields(map[string]interface{}{
+			"request_id": requestID,
+			"method":     r.Method,
+			"route":      r.Pattern,
+		})))
+
+		// Create a response wrapper to capture status code, and count the
+		// bytes read from the body
 		rw := newResponseWriter(w)
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
 
 		// Process request
 		next(rw, r)
 
+		metrics.HTTPRequests.WithLabelValues(r.Method, r.Pattern, strconv.Itoa(rw.statusCode)).Inc()
+		if scope != sloExcluded {
+			metrics.ObserveRequest("http", r.Pattern, errmap.HTTPOutcome(rw.statusCode), scope.eligible(r))
+		}
+
+		if h.accessLogExclude[r.URL.Path] {
+			return
+		}
+
 		// Log after request is processed
-		h.log.Info("HTTP Request", map[string]interface{}{
-			"method":      r.Method,
-			"path":        r.URL.Path,
-			"status":      rw.statusCode,
-			"duration_ms": time.Since(start).Milliseconds(),
-			"remote_addr": r.RemoteAddr,
-			"user_agent":  r.UserAgent(),
-		})
+		route := r.Pattern
+		if route == "" {
+			route = r.URL.Path
+		}
+		entry := accesslog.Entry{
+			Transport: "http",
+			Method:    r.Method,
+			Route:     route,
+			Status:    rw.statusCode,
+			Outcome:   string(errmap.HTTPOutcome(rw.statusCode)),
+			Duration:  time.Since(start),
+			Peer:      r.RemoteAddr,
+			RequestID: requestID,
+			User:      h.principalOf(r).ID,
+			UserAgent: r.UserAgent(),
+			BytesIn:   body.n,
+			BytesOut:  rw.bytes,
+		}
+		if h.accessLogDebug[r.URL.Path] {
+			entry.Debug(h.log)
+			return
+		}
+		entry.Log(h.log)
 	}
 }
 
-// responseWriter is a wrapper for http.ResponseWriter that captures the status code
+// Middleware for counting database statements issued by a request
+func (h *Handler) trackStatements(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, budget := querybudget.WithBudget(r.Context(), h.statementBudget, h.statementBudgetStrict)
+
+		next(w, r.WithContext(ctx))
+
+		route := r.Pattern
+		metrics.StatementsPerRequest.WithLabelValues("http", route).Observe(float64(budget.Count()))
+		wideevent.Add(ctx, wideevent.DBStatements, budget.Count())
+		if budget.Exceeded() {
+			h.log.Warn("Database statement budget exceeded", map[string]interface{}{
+				"route":      route,
+				"statements": budget.Count(),
+				"budget":     budget.Limit(),
+			})
+		}
+	}
+}
+
+// responseWriter is a wrapper for http.ResponseWriter that captures the
+// status code and counts the bytes written
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
+	// wroteHeader tells whether the status was sent, after which the
+	// response can no longer be replaced by an error
+	wroteHeader bool
+	// aborted marks a response whose connection must be aborted, see
+	// recoverPanic
+	aborted bool
 }
 
 func newResponseWriter(w http.ResponseWriter) *responseWriter {
-	return &responseWriter{w, http.StatusOK}
+	return &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
+	if code >= http.StatusOK {
+		rw.wroteHeader = true
+	}
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
 
-// Helper functions for handling requests and responses
-func (h *Handler) decodeJSONBody(r *http.Request, dst interface{}) error {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		return err
-	}
-	defer r.Body.Close()
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
 
-	return json.Unmarshal(body, dst)
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush event streams
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
 }
 
+// Helper functions for handling requests and responses
 func (h *Handler) respondJSON(w http.ResponseWriter, status int, data interface{}) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -103,8 +445,40 @@ func (h *Handler) respondJSON(w http.ResponseWriter, status int, data interface{
 	}
 }
 
-func (h *Handler) respondError(w http.ResponseWriter, status int, err error) {
-	h.respondJSON(w, status, errorRS{Error: err.Error()})
+// respondError writes the error using the transport mapping registered in errmap.
+// Requests abandoned by the client are answered with 499 and logged at debug
+// level, errors without a mapping are logged in full and answered with a
+// generic message.
+func (h *Handler) respondError(w http.ResponseWriter, r *http.Request, err error) {
+	m, ok := errmap.Resolve(r.Context(), err)
+	switch {
+	case m == errmap.Canceled:
+		h.log.Debug("Request canceled by client", map[string]interface{}{"path": r.URL.Path, "error": err.Error()})
+	case !ok:
+		h.log.Error("Unmapped error", err, nil)
+		m = errmap.Internal
+	}
+
+	rs := errorRS{Error: m.Message, Code: m.Code}
+	var detailed interface{ Details() string }
+	if errors.As(err, &detailed) {
+		rs.Details = sanitize.String(detailed.Details())
+	}
+	h.respondJSON(w, m.HTTPStatus, rs)
+}
+
+// logError logs a failed request step. Failures caused by the client
+// abandoning the request are not server faults and are logged at debug level.
+func (h *Handler) logError(r *http.Request, msg string, err error, fields map[string]interface{}) {
+	if m, _ := errmap.Resolve(r.Context(), err); m == errmap.Canceled {
+		if fields == nil {
+			fields = map[string]interface{}{}
+		}
+		fields["error"] = err.Error()
+		h.log.Debug(msg, fields)
+		return
+	}
+	h.log.Error(msg, err, fields)
 }
 
 // For testing purposes
@@ -116,22 +490,22 @@ var pathValueFunc = func(r *http.Request, key string) string {
 func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
 	var req createUserRQ
 	if err := h.decodeJSONBody(r, &req); err != nil {
-		h.log.Error("Failed to decode request body", err, map[string]interface{}{"path": r.URL.Path})
-		h.respondError(w, http.StatusBadRequest, err)
+		h.logError(r, "Failed to decode request body", err, map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, err)
 		return
 	}
 
 	// Validate required fields
 	if req.Email == "" || req.Password == "" {
 		h.log.Warn("Missing required fields", map[string]interface{}{"path": r.URL.Path})
-		h.respondError(w, http.StatusBadRequest, domain.ErrInvalidInput)
+		h.respondError(w, r, domain.ErrInvalidInput)
 		return
 	}
 
 	// Validate email format
 	if !isValidEmail(req.Email) {
 		h.log.Warn("Invalid email format", map[string]interface{}{"email": req.Email})
-		h.respondError(w, http.StatusBadRequest, domain.ErrInvalidInput)
+		h.respondError(w, r, domain.ErrInvalidInput)
 		return
 	}
 
@@ -139,14 +513,16 @@ func (h *Handler) createUser(w http.ResponseWriter, r *http.Request) {
 		Email:    req.Email,
 		Password: req.Password,
 		Name:     req.Name,
+		Metadata: req.Metadata,
 	}
 
 	if err := h.services.User.Create(r.Context(), user); err != nil {
-		h.log.Error("Failed to create user", err, map[string]interface{}{"email": req.Email})
-		h.respondError(w, http.StatusInternalServerError, err)
+		h.logError(r, "Failed to create user", err, map[string]interface{}{"email": req.Email})
+		h.respondError(w, r, err)
 		return
 	}
 
+	setConsistencyToken(w)
 	h.respondJSON(w, http.StatusCreated, user)
 }
 
@@ -163,22 +539,38 @@ func isValidEmail(email string) bool {
 }
 
 func (h *Handler) getUserByID(w http.ResponseWriter, r *http.Request) {
+	h.getUser(r.Context(), w, r)
+}
+
+// getUserSensitive answers the user with the values of its sensitive
+// metadata keys. The route is served on the internal listener only, which
+// stands in for the admin permission the API does not have yet.
+func (h *Handler) getUserSensitive(w http.ResponseWriter, r *http.Request) {
+	h.log.Info("Reading sensitive user metadata", map[string]interface{}{
+		"user_id":   pathValueFunc(r, "id"),
+		"client_ip": h.clientIP(r),
+	})
+	h.getUser(service.WithSensitiveMetadata(r.Context()), w, r)
+}
+
+// getUser answers the user of the id path value read with ctx
+func (h *Handler) getUser(ctx context.Context, w http.ResponseWriter, r *http.Request) {
 	id := pathValueFunc(r, "id")
 	if id == "" {
 		h.log.Warn("Missing user ID", map[string]interface{}{"path": r.URL.Path})
-		h.respondError(w, http.StatusBadRequest, domain.ErrInvalidInput)
+		h.respondError(w, r, domain.ErrInvalidInput)
 		return
 	}
 
-	user, err := h.services.User.GetByID(r.Context(), id)
+	user, err := h.services.User.GetByID(ctx, id)
 	if err != nil {
-		if err == domain.ErrUserNotFound {
+		if errors.Is(err, domain.ErrUserNotFound) {
 			h.log.Warn("User not found", map[string]interface{}{"user_id": id})
-			h.respondError(w, http.StatusNotFound, err)
+			h.respondError(w, r, err)
 			return
 		}
-		h.log.Error("Failed to get user", err, map[string]interface{}{"user_id": id})
-		h.respondError(w, http.StatusInternalServerError, err)
+		h.logError(r, "Failed to get user", err, map[string]interface{}{"user_id": id})
+		h.respondError(w, r, err)
 		return
 	}
 
@@ -189,34 +581,84 @@ func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
 	id := pathValueFunc(r, "id")
 	if id == "" {
 		h.log.Warn("Missing user ID", map[string]interface{}{"path": r.URL.Path})
-		h.respondError(w, http.StatusBadRequest, domain.ErrInvalidInput)
+		h.respondError(w, r, domain.ErrInvalidInput)
 		return
 	}
 
 	var req updateUserRQ
 	if err := h.decodeJSONBody(r, &req); err != nil {
-		h.log.Error("Failed to decode request body", err, map[string]interface{}{"path": r.URL.Path})
-		h.respondError(w, http.StatusBadRequest, err)
+		h.logError(r, "Failed to decode request body", err, map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, err)
 		return
 	}
 
 	user := &domain.User{
-		ID:    id,
-		Email: req.Email,
-		Name:  req.Name,
+		ID:       id,
+		Email:    req.Email,
+		Name:     req.Name,
+		Metadata: req.Metadata,
 	}
 
-	if err := h.services.User.Update(r.Context(), user); err != nil {
-		if err == domain.ErrUserNotFound {
+	if err := h.services.User.Update(h.mutationContext(r), user); err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
 			h.log.Warn("User not found for update", map[string]interface{}{"user_id": id})
-			h.respondError(w, http.StatusNotFound, err)
+			h.respondError(w, r, err)
 			return
 		}
-		h.log.Error("Failed to update user", err, map[string]interface{}{"user_id": id})
-		h.respondError(w, http.StatusInternalServerError, err)
+		h.logError(r, "Failed to update user", err, map[string]interface{}{"user_id": id})
+		h.respondError(w, r, err)
 		return
 	}
 
+	setConsistencyToken(w)
+	h.respondJSON(w, http.StatusOK, user)
+}
+
+// patchUser accepts JSON Merge Patch (RFC 7386) and JSON Patch (RFC 6902) documents
+func (h *Handler) patchUser(w http.ResponseWriter, r *http.Request) {
+	id := pathValueFunc(r, "id")
+	if id == "" {
+		h.log.Warn("Missing user ID", map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, domain.ErrInvalidInput)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		h.logError(r, "Failed to read request body", err, map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, fmt.Errorf("%w: %v", domain.ErrInvalidInput, err))
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var changes domain.UserChanges
+	switch mediaType {
+	case mediaTypeMergePatch, "application/json":
+		changes, err = parseMergePatch(body)
+	case mediaTypeJSONPatch:
+		changes, err = parseJSONPatch(body)
+	default:
+		err = fmt.Errorf("%w: %q", errmap.ErrUnsupportedMediaType, mediaType)
+	}
+	if err != nil {
+		h.log.Warn("Rejected patch", map[string]interface{}{"user_id": id, "error": err.Error()})
+		h.respondError(w, r, err)
+		return
+	}
+
+	user, err := h.services.User.Patch(h.mutationContext(r), id, changes)
+	if err != nil {
+		if errors.Is(err, domain.ErrUserNotFound) {
+			h.log.Warn("User not found for patch", map[string]interface{}{"user_id": id})
+		} else {
+			h.logError(r, "Failed to patch user", err, map[string]interface{}{"user_id": id})
+		}
+		h.respondError(w, r, err)
+		return
+	}
+
+	setConsistencyToken(w)
 	h.respondJSON(w, http.StatusOK, user)
 }
 
@@ -224,31 +666,210 @@ func (h *Handler) deleteUser(w http.ResponseWriter, r *http.Request) {
 	id := pathValueFunc(r, "id")
 	if id == "" {
 		h.log.Warn("Missing user ID", map[string]interface{}{"path": r.URL.Path})
-		h.respondError(w, http.StatusBadRequest, domain.ErrInvalidInput)
+		h.respondError(w, r, domain.ErrInvalidInput)
 		return
 	}
 
 	if err := h.services.User.Delete(r.Context(), id); err != nil {
-		if err == domain.ErrUserNotFound {
+		if errors.Is(err, domain.ErrUserNotFound) {
 			h.log.Warn("User not found for deletion", map[string]interface{}{"user_id": id})
-			h.respondError(w, http.StatusNotFound, err)
+			h.respondError(w, r, err)
 			return
 		}
-		h.log.Error("Failed to delete user", err, map[string]interface{}{"user_id": id})
-		h.respondError(w, http.StatusInternalServerError, err)
+		h.logError(r, "Failed to delete user", err, map[string]interface{}{"user_id": id})
+		h.respondError(w, r, err)
 		return
 	}
 
+	setConsistencyToken(w)
 	h.respondJSON(w, http.StatusNoContent, nil)
 }
 
 func (h *Handler) listUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := h.services.User.List(r.Context())
+	opts, err := domain.ParseUserSort(r.URL.Query().Get("sort"))
+	if err != nil {
+		h.log.Warn("Invalid sort parameter", map[string]interface{}{"sort": r.URL.Query().Get("sort")})
+		h.respondError(w, r, err)
+		return
+	}
+
+	opts.Filter, err = domain.ParseUserFilter(r.URL.Query().Get("filter"))
+	if err != nil {
+		h.log.Warn("Invalid filter parameter", map[string]interface{}{"filter": r.URL.Query().Get("filter")})
+		h.respondError(w, r, err)
+		return
+	}
+
+	opts.Filter.Where, err = parseUserWhere(r.URL.Query().Get("where"))
+	if err != nil {
+		h.log.Warn("Invalid where parameter", map[string]interface{}{"error": err.Error()})
+		h.respondError(w, r, err)
+		return
+	}
+
+	if err := parsePage(r, &opts.PageRequest); err != nil {
+		h.log.Warn("Invalid page parameters", map[string]interface{}{"query": r.URL.RawQuery, "error": err.Error()})
+		h.respondError(w, r, err)
+		return
+	}
+
+	page, err := h.services.User.List(r.Context(), opts)
+	if err != nil {
+		h.logError(r, "Failed to list users", err, nil)
+		h.respondError(w, r, err)
+		return
+	}
+
+	// The body stays the list of users, the size of the whole list is a header
+	w.Header().Set(totalCountHeader, strconv.FormatInt(page.Total, 10))
+	h.respondJSON(w, http.StatusOK, page.Users)
+}
+
+// totalCountHeader tells how many items a paged list holds in all
+const totalCountHeader = "X-Total-Count"
+
+// parsePage reads the limit, offset and sort_dir parameters into p, which
+// the service validates
+func parsePage(r *http.Request, p *domain.PageRequest) error {
+	q := r.URL.Query()
+	for name, dst := range map[string]*int{"limit": &p.Limit, "offset": &p.Offset} {
+		if raw := q.Get(name); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil || n < 0 {
+				return newDecodeError("parameter %q must be a non-negative integer", name)
+			}
+			*dst = n
+		}
+	}
+	p.SortDir = q.Get("sort_dir")
+	return nil
+}
+
+// listTombstones lets consumers that mirror users reconcile deletions they missed
+func (h *Handler) listTombstones(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if raw := r.URL.Query().Get("since"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			h.log.Warn("Invalid since parameter", map[string]interface{}{"since": raw})
+			h.respondError(w, r, domain.ErrInvalidInput)
+			return
+		}
+		since = parsed
+	}
+
+	tombstones, err := h.services.User.ListTombstones(r.Context(), since)
+	if err != nil {
+		h.logError(r, "Failed to list user tombstones", err, nil)
+		h.respondError(w, r, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, tombstones)
+}
+
+// lookupUsers resolves a batch of user IDs for services holding user references
+func (h *Handler) lookupUsers(w http.ResponseWriter, r *http.Request) {
+	var req lookupUsersRQ
+	if err := h.decodeJSONBody(r, &req); err != nil {
+		h.logError(r, "Failed to decode request body", err, map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, err)
+		return
+	}
+
+	lookup, err := h.services.User.GetByIDs(r.Context(), req.IDs)
+	if err != nil {
+		h.log.Warn("Failed to look up users", map[string]interface{}{"count": len(req.IDs), "error": err.Error()})
+		h.respondError(w, r, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, lookupUsersRS{
+		Users:   lookup.Found,
+		Missing: lookup.Missing,
+	})
+}
+
+func (h *Handler) requestEmailChange(w http.ResponseWriter, r *http.Request) {
+	id := pathValueFunc(r, "id")
+	if id == "" {
+		h.log.Warn("Missing user ID", map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, domain.ErrInvalidInput)
+		return
+	}
+
+	var req emailChangeRQ
+	if err := h.decodeJSONBody(r, &req); err != nil {
+		h.logError(r, "Failed to decode request body", err, map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, err)
+		return
+	}
+
+	if !isValidEmail(req.Email) {
+		h.log.Warn("Invalid email format", map[string]interface{}{"email": req.Email})
+		h.respondError(w, r, domain.ErrInvalidInput)
+		return
+	}
+
+	if err := h.services.User.RequestEmailChange(r.Context(), id, req.Email); err != nil {
+		h.log.Warn("Failed to request email change", map[string]interface{}{"user_id": id, "error": err.Error()})
+		h.respondError(w, r, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusAccepted, nil)
+}
+
+func (h *Handler) cancelEmailChange(w http.ResponseWriter, r *http.Request) {
+	id := pathValueFunc(r, "id")
+	if id == "" {
+		h.log.Warn("Missing user ID", map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, domain.ErrInvalidInput)
+		return
+	}
+
+	if err := h.services.User.CancelEmailChange(r.Context(), id); err != nil {
+		h.log.Warn("Failed to cancel email change", map[string]interface{}{"user_id": id, "error": err.Error()})
+		h.respondError(w, r, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusNoContent, nil)
+}
+
+func (h *Handler) confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	var req confirmEmailChangeRQ
+	if err := h.decodeJSONBody(r, &req); err != nil {
+		h.logError(r, "Failed to decode request body", err, map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, err)
+		return
+	}
+
+	if req.Token == "" {
+		h.log.Warn("Missing confirmation token", map[string]interface{}{"path": r.URL.Path})
+		h.respondError(w, r, domain.ErrInvalidInput)
+		return
+	}
+
+	user, err := h.services.User.ConfirmEmailChange(r.Context(), req.Token)
+	if err != nil {
+		h.log.Warn("Failed to confirm email change", map[string]interface{}{"error": err.Error()})
+		h.respondError(w, r, err)
+		return
+	}
+
+	setConsistencyToken(w)
+	h.respondJSON(w, http.StatusOK, user)
+}
+
+// listInstances reports live replicas and whether their config or version differ
+func (h *Handler) listInstances(w http.ResponseWriter, r *http.Request) {
+	report, err := h.services.Instance.List(r.Context())
 	if err != nil {
-		h.log.Error("Failed to list users", err, nil)
-		h.respondError(w, http.StatusInternalServerError, err)
+		h.logError(r, "Failed to list service instances", err, nil)
+		h.respondError(w, r, err)
 		return
 	}
 
-	h.respondJSON(w, http.StatusOK, users)
+	h.respondJSON(w, http.StatusOK, report)
 }
@@ -0,0 +1,92 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+// defaultSSEHeartbeat keeps idle event streams open through proxies
+const defaultSSEHeartbeat = 15 * time.Second
+
+func (h *Handler) getJob(w http.ResponseWriter, r *http.Request) {
+	id := pathValueFunc(r, "id")
+
+	job, err := h.services.Job.Get(r.Context(), id)
+	if err != nil {
+		h.log.Warn("Failed to get job", map[string]interface{}{"job_id": id, "error": err.Error()})
+		h.respondError(w, r, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusOK, job)
+}
+
+// streamJobEvents pushes job progress as Server-Sent Events and ends the
+// stream once the job reaches a terminal state
+func (h *Handler) streamJobEvents(w http.ResponseWriter, r *http.Request) {
+	id := pathValueFunc(r, "id")
+
+	updates, err := h.services.Job.Watch(r.Context(), id)
+	if err != nil {
+		h.log.Warn("Failed to watch job", map[string]interface{}{"job_id": id, "error": err.Error()})
+		h.respondError(w, r, err)
+		return
+	}
+
+	// The stream outlives the server write timeout
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.log.Warn("Failed to clear write deadline", map[string]interface{}{"job_id": id, "error": err.Error()})
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		h.log.Error("Streaming is not supported", err, map[string]interface{}{"job_id": id})
+		return
+	}
+
+	heartbeat := time.NewTicker(h.sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case job, ok := <-updates:
+			if !ok {
+				return
+			}
+			if err := writeEvent(w, "progress", job); err != nil {
+				return
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeEvent writes a single Server-Sent Event with a JSON payload
+func writeEvent(w http.ResponseWriter, event string, job *domain.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	return err
+}
@@ -0,0 +1,143 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// Mock for JobService
+type MockJobService struct {
+	mock.Mock
+}
+
+func (m *MockJobService) Get(ctx context.Context, id string) (*domain.Job, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Job), args.Error(1)
+}
+
+func (m *MockJobService) Update(ctx context.Context, job *domain.Job) error {
+	args := m.Called(ctx, job)
+	return args.Error(0)
+}
+
+func (m *MockJobService) Watch(ctx context.Context, id string) (<-chan *domain.Job, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(chan *domain.Job), args.Error(1)
+}
+
+// newJobStreamServer serves the handler with the given write timeout
+func newJobStreamServer(t *testing.T, jobs service.JobServiceInterface, writeTimeout time.Duration, opts ...HandlerOption) *httptest.Server {
+	log := logger.New()
+	handler := NewHandler(&service.Services{Job: jobs, Log: log}, log, opts...)
+
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.WriteTimeout = writeTimeout
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// readFrame reads one SSE frame, up to the blank line terminating it
+func readFrame(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+
+	var frame strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\n" {
+			return frame.String()
+		}
+		frame.WriteString(line)
+	}
+}
+
+func TestHandler_streamJobEvents(t *testing.T) {
+	// Arrange
+	mockJobService := new(MockJobService)
+	updates := make(chan *domain.Job)
+	mockJobService.On("Watch", mock.Anything, "job-1").Return(updates, nil)
+
+	// The stream must outlive a write timeout shorter than the job
+	srv := newJobStreamServer(t, mockJobService, 100*time.Millisecond)
+
+	// Act
+	resp, err := http.Get(srv.URL + "/api/v1/jobs/job-1/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body := bufio.NewReader(resp.Body)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	updates <- &domain.Job{ID: "job-1", Status: domain.JobPending}
+	assert.Equal(t, "event: progress\ndata: "+`{"id":"job-1","type":"","status":"pending","progress":0,"created_at":"0001-01-01T00:00:00Z","updated_at":"0001-01-01T00:00:00Z"}`+"\n", readFrame(t, body))
+
+	time.Sleep(200 * time.Millisecond)
+	updates <- &domain.Job{ID: "job-1", Status: domain.JobRunning, Progress: 40}
+	assert.Contains(t, readFrame(t, body), `"status":"running","progress":40`)
+
+	updates <- &domain.Job{ID: "job-1", Status: domain.JobSucceeded, Progress: 100}
+	assert.Contains(t, readFrame(t, body), `"status":"succeeded","progress":100`)
+
+	// The service closes the updates after the terminal state, ending the stream
+	close(updates)
+	_, err = body.ReadString('\n')
+	assert.Error(t, err)
+	mockJobService.AssertExpectations(t)
+}
+
+func TestHandler_streamJobEvents_Heartbeat(t *testing.T) {
+	// Arrange
+	mockJobService := new(MockJobService)
+	updates := make(chan *domain.Job)
+	mockJobService.On("Watch", mock.Anything, "job-1").Return(updates, nil)
+
+	srv := newJobStreamServer(t, mockJobService, 0, WithSSEHeartbeat(20*time.Millisecond))
+
+	// Act
+	resp, err := http.Get(srv.URL + "/api/v1/jobs/job-1/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// Assert
+	assert.Equal(t, ": heartbeat\n", readFrame(t, bufio.NewReader(resp.Body)))
+}
+
+func TestHandler_streamJobEvents_NotFound(t *testing.T) {
+	// Arrange
+	mockJobService := new(MockJobService)
+	mockJobService.On("Watch", mock.Anything, "missing").Return(nil, domain.ErrJobNotFound)
+
+	log := logger.New()
+	handler := NewHandler(&service.Services{Job: mockJobService, Log: log}, log)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/jobs/missing/events", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"job_not_found"`)
+}
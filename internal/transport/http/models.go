@@ -1,18 +1,64 @@
 package http
 
-// Request models
+import "github.com/romanitalian/carch-go/internal/domain"
+
+// Request models. The validate tags document the rules the handlers enforce
+// in the OpenAPI document, see the openapi package for the supported rules.
 type createUserRQ struct {
-	Email    string `json:"email"`
-	Password string `json:"password"`
-	Name     string `json:"name"`
+	Email    string            `json:"email" validate:"required,email"`
+	Password string            `json:"password" validate:"required"`
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
+// updateUserRQ replaces the metadata when it is given and keeps it otherwise
 type updateUserRQ struct {
-	Email string `json:"email"`
-	Name  string `json:"name"`
+	Email    string            `json:"email"`
+	Name     string            `json:"name"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// userMergePatchRQ is an RFC 7386 merge patch of a user. It only documents
+// the patch, which is decoded member by member, see parseMergePatch.
+type userMergePatchRQ struct {
+	Email string  `json:"email,omitempty" validate:"email"`
+	Name  *string `json:"name,omitempty"`
+}
+
+type emailChangeRQ struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type confirmEmailChangeRQ struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type lookupUsersRQ struct {
+	IDs []string `json:"ids" validate:"max=100"`
+}
+
+// bulkDeleteUsersRQ selects users with the filter of the user list. DryRun
+// has no default, so that a deletion is never started by leaving it out.
+type bulkDeleteUsersRQ struct {
+	Filter string `json:"filter" validate:"required"`
+	DryRun *bool  `json:"dry_run" validate:"required"`
+	Force  bool   `json:"force"`
 }
 
 // Response models
 type errorRS struct {
-	Error string `json:"error"`
+	Error   string `json:"error"`
+	Code    string `json:"code"`
+	Details string `json:"details,omitempty"`
+}
+
+type lookupUsersRS struct {
+	Users   map[string]*domain.User `json:"users"`
+	Missing []string                `json:"missing"`
+}
+
+// auditPageRS is a page of audit entries reduced to the selected fields
+type auditPageRS struct {
+	Entries    []map[string]interface{} `json:"entries"`
+	NextCursor string                   `json:"next_cursor,omitempty"`
 }
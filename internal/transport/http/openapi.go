@@ -0,0 +1,68 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/consistency"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+	"github.com/romanitalian/carch-go/internal/transport/http/openapi"
+)
+
+// openAPIConfig describes what the public routes share in the OpenAPI document
+var openAPIConfig = openapi.Config{
+	Info: openapi.Info{
+		Title:   "carch-go API",
+		Version: "v1",
+	},
+	ErrorBody: errorRS{},
+	// Every request may carry a malformed tenant and be shed under load
+	CommonErrors: []error{domain.ErrInvalidInput, errmap.ErrOverloaded},
+	CommonParams: []openapi.Param{
+		{Name: tenant.Header, In: "header", Description: "Tenant the request acts for, events it produces are routed by it"},
+		{Name: consistency.Header, In: "header", Description: "Token returned by a mutation, reads sending it are served from the primary"},
+	},
+}
+
+// adminOverride lets requests change users past the mutation throttle
+var adminOverride = openapi.Auth{
+	Name:     "adminOverride",
+	Optional: true,
+	Scheme: openapi.SecurityScheme{
+		Type:        "apiKey",
+		In:          "header",
+		Name:        throttleOverrideHeader,
+		Description: "Admin token lifting the user mutation throttle",
+	},
+}
+
+// webhookSignature is the signature inbound webhooks are verified with
+var webhookSignature = openapi.Auth{
+	Name: "webhookSignature",
+	Scheme: openapi.SecurityScheme{
+		Type: "apiKey",
+		In:   "header",
+		Name: domain.DefaultWebhookSignatureHeader,
+		Description: "HMAC of the timestamp and the body with the provider's secret. " +
+			"The signature and timestamp headers and the algorithm can be configured per provider.",
+	},
+}
+
+// OpenAPI returns the OpenAPI document of the public routes
+func (h *Handler) OpenAPI() (*openapi.Document, error) {
+	return openapi.Generate(openAPIConfig, h.routes)
+}
+
+// OpenAPIJSON returns the indented JSON of the document generated at startup
+func (h *Handler) OpenAPIJSON() []byte {
+	return h.openAPI
+}
+
+// serveOpenAPI answers with the document generated at startup
+func (h *Handler) serveOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write(h.openAPI); err != nil {
+		h.log.Debug("Failed to write OpenAPI document", map[string]interface{}{"error": err.Error()})
+	}
+}
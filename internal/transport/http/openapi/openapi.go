@@ -0,0 +1,260 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+)
+
+// Version is the OpenAPI version of the generated documents
+const Version = "3.0.3"
+
+// Document is an OpenAPI document
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// PathItem holds the operations of a path by lower-case method
+type PathItem map[string]*Operation
+
+// Operation is a route of the API
+type Operation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter is a path, query or header parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody is the body of an operation by media type
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body of one media type
+type MediaType struct {
+	Schema  *Schema     `json:"schema"`
+	Example interface{} `json:"example,omitempty"`
+}
+
+// Components holds the named schemas and security schemes
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is how a route authenticates requests
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Config describes the API and what all of its routes share
+type Config struct {
+	Info Info
+	// ErrorBody is a value of the DTO errors are answered with
+	ErrorBody interface{}
+	// CommonErrors are answered by every route in addition to its own.
+	// Unmapped errors are always documented as 500.
+	CommonErrors []error
+	// CommonParams are accepted by every route in addition to its own
+	CommonParams []Param
+}
+
+var wildcard = regexp.MustCompile(`\{([^}.]+)(\.\.\.)?\}`)
+
+// Generate builds the document of the routes
+func Generate(cfg Config, routes []Route) (*Document, error) {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    cfg.Info,
+		Paths:   make(map[string]PathItem),
+	}
+	s := newSchemas()
+	security := make(map[string]*SecurityScheme)
+
+	errorSchema, err := s.of(cfg.ErrorBody, response)
+	if err != nil {
+		return nil, fmt.Errorf("error body: %w", err)
+	}
+
+	for _, r := range routes {
+		op, err := operation(s, r, cfg, errorSchema)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", r.Method, r.Path, err)
+		}
+
+		if r.Auth != nil {
+			if known, ok := security[r.Auth.Name]; ok && *known != r.Auth.Scheme {
+				return nil, fmt.Errorf("%s %s: security scheme %q is declared twice differently", r.Method, r.Path, r.Auth.Name)
+			}
+			scheme := r.Auth.Scheme
+			security[r.Auth.Name] = &scheme
+			op.Security = []map[string][]string{{r.Auth.Name: {}}}
+			if r.Auth.Optional {
+				op.Security = append(op.Security, map[string][]string{})
+			}
+		}
+
+		path := wildcard.ReplaceAllString(r.Path, "{$1}")
+		item, ok := doc.Paths[path]
+		if !ok {
+			item = make(PathItem)
+			doc.Paths[path] = item
+		}
+		method := strings.ToLower(r.Method)
+		if _, ok := item[method]; ok {
+			return nil, fmt.Errorf("%s %s is registered twice", r.Method, r.Path)
+		}
+		item[method] = op
+	}
+
+	doc.Components.Schemas = s.components
+	if len(security) > 0 {
+		doc.Components.SecuritySchemes = security
+	}
+	return doc, nil
+}
+
+func operation(s *schemas, r Route, cfg Config, errorSchema *Schema) (*Operation, error) {
+	op := &Operation{
+		OperationID: r.OperationID,
+		Summary:     r.Summary,
+		Description: r.Description,
+		Tags:        tags(r.Path),
+		Responses:   make(map[string]*Response),
+	}
+
+	for _, m := range wildcard.FindAllStringSubmatch(r.Path, -1) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     m[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "string"},
+		})
+	}
+	for _, p := range append(append([]Param{}, r.Params...), cfg.CommonParams...) {
+		schema, err := s.of(p.Value, request)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s: %w", p.Name, err)
+		}
+		if p.Value == nil {
+			schema = &Schema{Type: "string"}
+		}
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:        p.Name,
+			In:          p.In,
+			Description: p.Description,
+			Required:    p.Required,
+			Schema:      schema,
+		})
+	}
+
+	if len(r.Requests) > 0 {
+		op.RequestBody = &RequestBody{Required: true, Content: make(map[string]MediaType)}
+		for _, b := range r.Requests {
+			schema, err := s.of(b.Value, request)
+			if err != nil {
+				return nil, fmt.Errorf("request body: %w", err)
+			}
+			op.RequestBody.Content[b.ContentType] = MediaType{Schema: schema, Example: b.Example}
+		}
+	}
+
+	success := &Response{Description: http.StatusText(r.Status)}
+	for _, b := range r.Responses {
+		schema, err := s.of(b.Value, response)
+		if err != nil {
+			return nil, fmt.Errorf("response body: %w", err)
+		}
+		if success.Content == nil {
+			success.Content = make(map[string]MediaType)
+		}
+		success.Content[b.ContentType] = MediaType{Schema: schema, Example: b.Example}
+	}
+	op.Responses[strconv.Itoa(r.Status)] = success
+
+	for status, codes := range errorCodes(append(append([]error{}, r.Errors...), cfg.CommonErrors...)) {
+		op.Responses[strconv.Itoa(status)] = &Response{
+			Description: http.StatusText(status) + ". Codes: " + strings.Join(codes, ", "),
+			Content:     map[string]MediaType{"application/json": {Schema: errorSchema}},
+		}
+	}
+
+	return op, nil
+}
+
+// errorCodes groups the codes of the errors by HTTP status. Errors without
+// a mapping are answered as internal errors, which are always documented.
+func errorCodes(errs []error) map[int][]string {
+	byStatus := make(map[int][]string)
+	seen := make(map[string]bool)
+	add := func(m errmap.Mapping) {
+		if seen[m.Code] {
+			return
+		}
+		seen[m.Code] = true
+		byStatus[m.HTTPStatus] = append(byStatus[m.HTTPStatus], m.Code)
+	}
+
+	for _, err := range errs {
+		add(errmap.Map(err))
+	}
+	add(errmap.Internal)
+
+	for _, codes := range byStatus {
+		sort.Strings(codes)
+	}
+	return byStatus
+}
+
+// tags groups operations by the first path segment after the API prefix
+func tags(path string) []string {
+	for _, seg := range strings.Split(path, "/") {
+		if seg == "" || seg == "api" || isVersion(seg) {
+			continue
+		}
+		if strings.HasPrefix(seg, "{") {
+			return nil
+		}
+		return []string{seg}
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+type auditedRQ struct {
+	UpdatedBy string `json:"updated_by" validate:"required,uuid"`
+}
+
+type widgetRQ struct {
+	auditedRQ
+	Name    string            `json:"name" validate:"required,min=1,max=64"`
+	Color   string            `json:"color,omitempty" validate:"oneof=red green"`
+	Tags    []string          `json:"tags,omitempty" validate:"max=5"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Secret  string            `json:"-"`
+	hidden  string
+	Comment *string `json:"comment"`
+}
+
+type widgetRS struct {
+	ID        string          `json:"id"`
+	Note      string          `json:"note,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+	Parent    *widgetRS       `json:"parent,omitempty"`
+}
+
+type errorRS struct {
+	Code string `json:"code"`
+}
+
+func generate(t *testing.T, routes ...Route) *Document {
+	t.Helper()
+	doc, err := Generate(Config{Info: Info{Title: "test", Version: "v1"}, ErrorBody: errorRS{}}, routes)
+	require.NoError(t, err)
+	return doc
+}
+
+func route(t *testing.T, pattern string, opts ...Option) Route {
+	t.Helper()
+	r, err := NewRoute(pattern, opts...)
+	require.NoError(t, err)
+	return r
+}
+
+func TestGenerate_RequestSchemaHonorsTags(t *testing.T) {
+	// Arrange
+	r := route(t, "POST /api/v1/widgets", Accepts(widgetRQ{}), Returns(http.StatusCreated, widgetRS{}))
+
+	// Act
+	doc := generate(t, r)
+
+	// Assert
+	rq := doc.Components.Schemas["WidgetRQ"]
+	require.NotNil(t, rq)
+	assert.Equal(t, []string{"name", "updated_by"}, rq.Required)
+	assert.NotContains(t, rq.Properties, "Secret")
+	assert.NotContains(t, rq.Properties, "hidden")
+	assert.Equal(t, "uuid", rq.Properties["updated_by"].Format)
+	assert.Equal(t, 1, *rq.Properties["name"].MinLength)
+	assert.Equal(t, 64, *rq.Properties["name"].MaxLength)
+	assert.Equal(t, []string{"red", "green"}, rq.Properties["color"].Enum)
+	assert.Equal(t, 5, *rq.Properties["tags"].MaxItems)
+	assert.Equal(t, &Schema{Type: "string"}, rq.Properties["labels"].AdditionalProperties)
+	assert.True(t, rq.Properties["comment"].Nullable)
+}
+
+func TestGenerate_ResponseFieldsWithoutOmitemptyAreRequired(t *testing.T) {
+	// Arrange
+	r := route(t, "GET /api/v1/widgets/{id}", Returns(http.StatusOK, widgetRS{}))
+
+	// Act
+	doc := generate(t, r)
+
+	// Assert
+	rs := doc.Components.Schemas["WidgetRS"]
+	require.NotNil(t, rs)
+	assert.Equal(t, []string{"created_at", "id", "payload"}, rs.Required)
+	assert.Equal(t, &Schema{Type: "string", Format: "date-time"}, rs.Properties["created_at"])
+	assert.Equal(t, &Schema{}, rs.Properties["payload"])
+	assert.Equal(t, "#/components/schemas/WidgetRS", rs.Properties["parent"].Ref)
+}
+
+func TestGenerate_Operation(t *testing.T) {
+	// Arrange
+	auth := Auth{Name: "token", Optional: true, Scheme: SecurityScheme{Type: "apiKey", In: "header", Name: "X-Token"}}
+	r := route(t, "DELETE /api/v1/widgets/{id}/parts/{part...}",
+		Summary("Delete a part"),
+		Query("force", false, "Delete even if in use"),
+		Returns(http.StatusNoContent, nil),
+		Errors(domain.ErrUserNotFound, domain.ErrJobNotFound, domain.ErrConflict, errors.New("unmapped")),
+		Secured(auth))
+
+	// Act
+	doc := generate(t, r)
+
+	// Assert
+	op := doc.Paths["/api/v1/widgets/{id}/parts/{part}"]["delete"]
+	require.NotNil(t, op)
+	assert.Equal(t, "deleteWidgetsByIdPartsByPart", op.OperationID)
+	assert.Equal(t, []string{"widgets"}, op.Tags)
+	require.Len(t, op.Parameters, 3)
+	assert.Equal(t, Parameter{Name: "part", In: "path", Required: true, Schema: &Schema{Type: "string"}}, op.Parameters[1])
+	assert.Equal(t, &Schema{Type: "boolean"}, op.Parameters[2].Schema)
+	assert.Nil(t, op.Responses["204"].Content)
+	assert.Equal(t, "Not Found. Codes: job_not_found, user_not_found", op.Responses["404"].Description)
+	assert.Equal(t, "Conflict. Codes: conflict", op.Responses["409"].Description)
+	assert.Equal(t, "Internal Server Error. Codes: internal", op.Responses["500"].Description)
+	assert.Equal(t, []map[string][]string{{"token": {}}, {}}, op.Security)
+	assert.Equal(t, "X-Token", doc.Components.SecuritySchemes["token"].Name)
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	type other struct{ A int }
+	tests := []struct {
+		name   string
+		routes []Route
+	}{
+		{
+			name:   "duplicate route",
+			routes: []Route{route(t, "GET /a"), route(t, "GET /a")},
+		},
+		{
+			name: "unknown validation rule",
+			routes: []Route{route(t, "POST /a", Accepts(struct {
+				A string `validate:"shiny"`
+			}{}))},
+		},
+		{
+			name:   "non-string map keys",
+			routes: []Route{route(t, "GET /a", Returns(http.StatusOK, map[int]string{}))},
+		},
+		{
+			name: "conflicting security schemes",
+			routes: []Route{
+				route(t, "GET /a", Secured(Auth{Name: "k", Scheme: SecurityScheme{Type: "apiKey", Name: "A"}})),
+				route(t, "GET /b", Secured(Auth{Name: "k", Scheme: SecurityScheme{Type: "apiKey", Name: "B"}})),
+			},
+		},
+		{
+			name: "rule on a struct",
+			routes: []Route{route(t, "POST /a", Accepts(struct {
+				O other `validate:"max=1"`
+			}{}))},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			_, err := Generate(Config{}, tt.routes)
+
+			// Assert
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewRoute_RejectsPatternWithoutMethod(t *testing.T) {
+	// Act
+	_, err := NewRoute("/api/v1/users")
+
+	// Assert
+	assert.Error(t, err)
+}
@@ -0,0 +1,206 @@
+// Package openapi generates the OpenAPI document of the HTTP API from the
+// metadata routes are registered with, so the document cannot drift from
+// the handlers. Request and response bodies are described by reflecting
+// over the DTO structs, honoring their json and validate tags.
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Auth is an authentication policy of a route, documented as a security scheme
+type Auth struct {
+	// Name identifies the scheme in the document
+	Name   string
+	Scheme SecurityScheme
+	// Optional routes also accept requests without the credentials
+	Optional bool
+}
+
+// Body is a request or response body of one media type
+type Body struct {
+	ContentType string
+	// Value is a value of the DTO type, e.g. createUserRQ{}. Nil means no body.
+	Value   interface{}
+	Example interface{}
+}
+
+// Param is a query or header parameter
+type Param struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	// Value is a value of the parameter type, a string when nil
+	Value interface{}
+}
+
+// Route is the metadata of a registered route
+type Route struct {
+	Method string
+	// Path is the pattern path, with wildcards such as {id}
+	Path        string
+	OperationID string
+	Summary     string
+	Description string
+	Auth        *Auth
+	Params      []Param
+	Requests    []Body
+	Status      int
+	Responses   []Body
+	// Errors are the domain errors the route answers with, described
+	// through their errmap mapping
+	Errors []error
+}
+
+// Option attaches metadata to a route when it is registered
+type Option func(*Route)
+
+// NewRoute returns the metadata of the route registered with pattern,
+// "METHOD /path" as accepted by http.ServeMux
+func NewRoute(pattern string, opts ...Option) (Route, error) {
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok || method == "" || !strings.HasPrefix(path, "/") {
+		return Route{}, fmt.Errorf("route pattern %q must be of the form \"METHOD /path\"", pattern)
+	}
+
+	r := Route{Method: method, Path: path, Status: http.StatusOK}
+	for _, opt := range opts {
+		opt(&r)
+	}
+	if r.OperationID == "" {
+		r.OperationID = operationID(r.Method, r.Path)
+	}
+	return r, nil
+}
+
+// operationID derives an ID from the method and the path, leaving out the
+// API prefix: GET /api/v1/users/{id} is getUsersById
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, seg := range strings.Split(path, "/") {
+		switch {
+		case seg == "" || seg == "api" || isVersion(seg):
+			continue
+		case strings.HasPrefix(seg, "{"):
+			b.WriteString("By")
+			seg = strings.Trim(seg, "{}.")
+		}
+		for _, word := range strings.FieldsFunc(seg, func(r rune) bool { return r == '-' || r == '_' }) {
+			b.WriteString(strings.ToUpper(word[:1]) + word[1:])
+		}
+	}
+	return b.String()
+}
+
+func isVersion(seg string) bool {
+	if len(seg) < 2 || seg[0] != 'v' {
+		return false
+	}
+	for _, r := range seg[1:] {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Summary sets the one-line summary of the route
+func Summary(s string) Option {
+	return func(r *Route) {
+		r.Summary = s
+	}
+}
+
+// Description sets the long description of the route
+func Description(s string) Option {
+	return func(r *Route) {
+		r.Description = s
+	}
+}
+
+// OperationID overrides the ID derived from the method and the path
+func OperationID(id string) Option {
+	return func(r *Route) {
+		r.OperationID = id
+	}
+}
+
+// Accepts documents a JSON request body of the type of v
+func Accepts(v interface{}) Option {
+	return AcceptsAs("application/json", v)
+}
+
+// AcceptsAs documents a request body of the media type and the type of v.
+// Routes accepting several media types use it once per type.
+func AcceptsAs(contentType string, v interface{}) Option {
+	return func(r *Route) {
+		r.Requests = append(r.Requests, Body{ContentType: contentType, Value: v})
+	}
+}
+
+// Returns documents the success status and its JSON body of the type of v,
+// nil for a response without a body
+func Returns(status int, v interface{}) Option {
+	return ReturnsAs(status, "application/json", v)
+}
+
+// ReturnsAs documents the success status and its body of the media type
+func ReturnsAs(status int, contentType string, v interface{}) Option {
+	return func(r *Route) {
+		r.Status = status
+		r.Responses = nil
+		if v != nil {
+			r.Responses = []Body{{ContentType: contentType, Value: v}}
+		}
+	}
+}
+
+// RequestExample attaches an example to the request bodies
+func RequestExample(v interface{}) Option {
+	return func(r *Route) {
+		for i := range r.Requests {
+			r.Requests[i].Example = v
+		}
+	}
+}
+
+// ResponseExample attaches an example to the success response body
+func ResponseExample(v interface{}) Option {
+	return func(r *Route) {
+		for i := range r.Responses {
+			r.Responses[i].Example = v
+		}
+	}
+}
+
+// Errors documents the errors the route answers with
+func Errors(errs ...error) Option {
+	return func(r *Route) {
+		r.Errors = append(r.Errors, errs...)
+	}
+}
+
+// Query documents an optional query parameter of the type of v
+func Query(name string, v interface{}, description string) Option {
+	return func(r *Route) {
+		r.Params = append(r.Params, Param{Name: name, In: "query", Description: description, Value: v})
+	}
+}
+
+// Header documents an optional string request header
+func Header(name, description string) Option {
+	return func(r *Route) {
+		r.Params = append(r.Params, Param{Name: name, In: "header", Description: description})
+	}
+}
+
+// Secured documents the authentication policy of the route
+func Secured(auth Auth) Option {
+	return func(r *Route) {
+		r.Auth = &auth
+	}
+}
@@ -0,0 +1,304 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is an OpenAPI 3.0 schema object
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	marshalerType  = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// direction tells whether a schema describes what clients send or what they
+// receive. Fields without omitempty are always present in what they receive.
+type direction int
+
+const (
+	request direction = iota
+	response
+)
+
+type component struct {
+	typ reflect.Type
+	dir direction
+}
+
+// schemas builds the schemas of DTO types. Structs become components
+// referenced by name, everything else is described inline.
+type schemas struct {
+	components map[string]*Schema
+	names      map[component]string
+	owners     map[string]component
+}
+
+func newSchemas() *schemas {
+	return &schemas{
+		components: make(map[string]*Schema),
+		names:      make(map[component]string),
+		owners:     make(map[string]component),
+	}
+}
+
+// of returns the schema of the type of v
+func (s *schemas) of(v interface{}, dir direction) (*Schema, error) {
+	return s.schema(reflect.TypeOf(v), dir)
+}
+
+func (s *schemas) schema(t reflect.Type, dir direction) (*Schema, error) {
+	switch {
+	case t == nil:
+		return &Schema{}, nil
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}, nil
+	case t == rawMessageType:
+		return &Schema{}, nil
+	case t.Kind() != reflect.Ptr && t.Implements(marshalerType),
+		t.Kind() != reflect.Ptr && reflect.PointerTo(t).Implements(marshalerType):
+		// The encoding is up to the type
+		return &Schema{}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		elem, err := s.schema(t.Elem(), dir)
+		if err != nil {
+			return nil, err
+		}
+		if elem.Ref != "" {
+			return elem, nil
+		}
+		nullable := *elem
+		nullable.Nullable = true
+		return &nullable, nil
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Uint, reflect.Uint8, reflect.Uint16:
+		return &Schema{Type: "integer"}, nil
+	case reflect.Int32, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}, nil
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}, nil
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}, nil
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}, nil
+	case reflect.String:
+		return &Schema{Type: "string"}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}, nil
+		}
+		items, err := s.schema(t.Elem(), dir)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "array", Items: items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("map %s: only string keys can be encoded as JSON objects", t)
+		}
+		values, err := s.schema(t.Elem(), dir)
+		if err != nil {
+			return nil, err
+		}
+		return &Schema{Type: "object", AdditionalProperties: values}, nil
+	case reflect.Interface:
+		return &Schema{}, nil
+	case reflect.Struct:
+		return s.component(t, dir)
+	}
+
+	return nil, fmt.Errorf("type %s cannot be described", t)
+}
+
+// component registers the struct as a named schema and returns a reference
+// to it. A struct used in both directions gets one component per direction.
+func (s *schemas) component(t reflect.Type, dir direction) (*Schema, error) {
+	key := component{t, dir}
+	if name, ok := s.names[key]; ok {
+		return &Schema{Ref: "#/components/schemas/" + name}, nil
+	}
+
+	name := componentName(t)
+	if t.Name() == "" {
+		return s.object(t, dir)
+	}
+	if owner, ok := s.owners[name]; ok {
+		if owner.typ != t {
+			return nil, fmt.Errorf("types %s and %s would both be named %s", owner.typ, t, name)
+		}
+		if dir == request {
+			name += "Request"
+		} else {
+			name += "Response"
+		}
+	}
+	s.names[key] = name
+	s.owners[name] = key
+
+	obj, err := s.object(t, dir)
+	if err != nil {
+		return nil, err
+	}
+	s.components[name] = obj
+	return &Schema{Ref: "#/components/schemas/" + name}, nil
+}
+
+// componentName is the exported spelling of the type name, with the request
+// and response suffixes of the DTOs kept: createUserRQ is CreateUserRQ
+func componentName(t reflect.Type) string {
+	name := t.Name()
+	if name == "" {
+		return ""
+	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+// object describes the JSON object a struct encodes to
+func (s *schemas) object(t reflect.Type, dir direction) (*Schema, error) {
+	obj := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	if err := s.fields(obj, t, dir); err != nil {
+		return nil, err
+	}
+	sort.Strings(obj.Required)
+	return obj, nil
+}
+
+func (s *schemas) fields(obj *Schema, t reflect.Type, dir direction) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// Embedded structs without a name are flattened, as encoding/json does
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := s.fields(obj, ft, dir); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		prop, err := s.schema(f.Type, dir)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", t, f.Name, err)
+		}
+
+		required, err := applyRules(prop, f.Tag.Get("validate"))
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", t, f.Name, err)
+		}
+		omitempty := strings.Contains(","+opts+",", ",omitempty,")
+		if required || dir == response && !omitempty {
+			obj.Required = append(obj.Required, name)
+		}
+		obj.Properties[name] = prop
+	}
+	return nil
+}
+
+// applyRules translates the validate tag of a field into schema constraints
+// and reports whether the field is required. Rules are comma separated:
+// required, email, uuid, url, min=N, max=N and oneof=a b c.
+func applyRules(prop *Schema, tag string) (bool, error) {
+	if tag == "" {
+		return false, nil
+	}
+
+	required := false
+	for _, rule := range strings.Split(tag, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "required":
+			required = true
+			continue
+		}
+		if prop.Ref != "" {
+			// Siblings of a reference are ignored by OpenAPI 3.0
+			return false, fmt.Errorf("rule %q cannot constrain a struct", rule)
+		}
+		switch name {
+		case "email", "uuid":
+			prop.Format = name
+		case "url":
+			prop.Format = "uri"
+		case "min", "max":
+			n, err := strconv.Atoi(arg)
+			if err != nil {
+				return false, fmt.Errorf("rule %q needs an integer", rule)
+			}
+			setBound(prop, name, n)
+		case "oneof":
+			prop.Enum = strings.Fields(arg)
+		default:
+			return false, fmt.Errorf("unknown validation rule %q", rule)
+		}
+	}
+	return required, nil
+}
+
+// setBound sets the min or max rule as the bound that fits the schema type
+func setBound(prop *Schema, rule string, n int) {
+	switch prop.Type {
+	case "string":
+		if rule == "min" {
+			prop.MinLength = &n
+		} else {
+			prop.MaxLength = &n
+		}
+	case "array":
+		if rule == "min" {
+			prop.MinItems = &n
+		} else {
+			prop.MaxItems = &n
+		}
+	default:
+		f := float64(n)
+		if rule == "min" {
+			prop.Minimum = &f
+		} else {
+			prop.Maximum = &f
+		}
+	}
+}
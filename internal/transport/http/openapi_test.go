@@ -0,0 +1,70 @@
+package http
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+var updateOpenAPI = flag.Bool("update-openapi", false, "rewrite the committed OpenAPI document")
+
+const openAPISnapshot = "../../../api/openapi/openapi.json"
+
+// TestOpenAPI_MatchesSnapshot fails when a route or a DTO changes without
+// the committed document. Review the diff, then regenerate it with
+// go test ./internal/transport/http -run TestOpenAPI -update-openapi
+func TestOpenAPI_MatchesSnapshot(t *testing.T) {
+	// Arrange
+	h := NewHandler(&service.Services{}, logger.New())
+
+	// Act
+	got := string(h.OpenAPIJSON()) + "\n"
+
+	// Assert
+	if *updateOpenAPI {
+		require.NoError(t, os.WriteFile(openAPISnapshot, []byte(got), 0o644))
+	}
+	want, err := os.ReadFile(openAPISnapshot)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), got, "OpenAPI document drifted from the routes, see TestOpenAPI_MatchesSnapshot")
+}
+
+func TestOpenAPI_DocumentsEveryRoute(t *testing.T) {
+	// Arrange
+	h := NewHandler(&service.Services{}, logger.New())
+
+	// Act
+	doc, err := h.OpenAPI()
+
+	// Assert
+	require.NoError(t, err)
+	for _, r := range h.routes {
+		item, ok := doc.Paths[r.Path]
+		require.True(t, ok, "path %s is not documented", r.Path)
+		assert.Contains(t, item, map[string]string{"GET": "get", "POST": "post", "PUT": "put", "PATCH": "patch", "DELETE": "delete"}[r.Method])
+	}
+	assert.Len(t, h.routes, 14)
+}
+
+func TestOpenAPI_ServedOnInternalListener(t *testing.T) {
+	// Arrange
+	h := NewHandler(&service.Services{}, logger.New())
+	req := httptest.NewRequest(http.MethodGet, "/openapi.json", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	h.Internal().ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	assert.JSONEq(t, string(h.OpenAPIJSON()), rr.Body.String())
+}
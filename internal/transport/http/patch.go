@@ -0,0 +1,145 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+// Patch media types
+const (
+	mediaTypeMergePatch = "application/merge-patch+json"
+	mediaTypeJSONPatch  = "application/json-patch+json"
+)
+
+// patchError explains why a patch document was rejected
+type patchError struct {
+	reason string
+}
+
+func newPatchError(format string, args ...interface{}) error {
+	return &patchError{reason: fmt.Sprintf(format, args...)}
+}
+
+func (e *patchError) Error() string {
+	return domain.ErrInvalidPatch.Error() + ": " + e.reason
+}
+
+func (e *patchError) Unwrap() error {
+	return domain.ErrInvalidPatch
+}
+
+// Details returns the reason exposed to clients
+func (e *patchError) Details() string {
+	return e.reason
+}
+
+// userPatchFields lists the patchable user fields and whether they may be cleared
+var userPatchFields = map[string]struct {
+	clearable bool
+}{
+	"name":  {clearable: true},
+	"email": {clearable: false},
+}
+
+// parseMergePatch translates an RFC 7386 merge patch into a change set.
+// A null member clears the field, an absent member leaves it unchanged.
+func parseMergePatch(body []byte) (domain.UserChanges, error) {
+	var changes domain.UserChanges
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return changes, newPatchError("merge patch must be a JSON object")
+	}
+
+	for field, raw := range doc {
+		var value *string
+		if !bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+			var s string
+			if err := json.Unmarshal(raw, &s); err != nil {
+				return changes, newPatchError("field %q must be a string", field)
+			}
+			value = &s
+		}
+
+		if err := setUserChange(&changes, field, value); err != nil {
+			return changes, err
+		}
+	}
+
+	return changes, nil
+}
+
+// jsonPatchOp is a single RFC 6902 operation
+type jsonPatchOp struct {
+	Op    string          `json:"op" validate:"required,oneof=replace remove"`
+	Path  string          `json:"path" validate:"required,oneof=/name /email"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// parseJSONPatch translates an RFC 6902 patch limited to replace and remove
+// operations on whitelisted paths into a change set
+func parseJSONPatch(body []byte) (domain.UserChanges, error) {
+	var changes domain.UserChanges
+
+	var ops []jsonPatchOp
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return changes, newPatchError("JSON patch must be an array of operations")
+	}
+
+	for i, op := range ops {
+		if len(op.Path) < 2 || op.Path[0] != '/' {
+			return changes, newPatchError("operation %d: invalid path %q", i, op.Path)
+		}
+		field := op.Path[1:]
+
+		switch op.Op {
+		case "replace":
+			var s string
+			if err := json.Unmarshal(op.Value, &s); err != nil {
+				return changes, newPatchError("operation %d: value of %q must be a string", i, op.Path)
+			}
+			if err := setUserChange(&changes, field, &s); err != nil {
+				return changes, err
+			}
+		case "remove":
+			if err := setUserChange(&changes, field, nil); err != nil {
+				return changes, err
+			}
+		default:
+			return changes, newPatchError("operation %d: unsupported op %q", i, op.Op)
+		}
+	}
+
+	return changes, nil
+}
+
+// setUserChange records a change of field; a nil value clears it
+func setUserChange(changes *domain.UserChanges, field string, value *string) error {
+	spec, ok := userPatchFields[field]
+	if !ok {
+		return newPatchError("field %q cannot be patched", field)
+	}
+
+	if value == nil {
+		if !spec.clearable {
+			return newPatchError("field %q cannot be cleared", field)
+		}
+		empty := ""
+		value = &empty
+	}
+
+	switch field {
+	case "name":
+		changes.Name = value
+	case "email":
+		if !isValidEmail(*value) {
+			return newPatchError("field %q must be a valid email", field)
+		}
+		changes.Email = value
+	}
+
+	return nil
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestParseMergePatch_NullVersusAbsent(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected domain.UserChanges
+	}{
+		{"absent leaves unchanged", `{}`, domain.UserChanges{}},
+		{"null clears", `{"name": null}`, domain.UserChanges{Name: strPtr("")}},
+		{"value sets", `{"name": "Jane"}`, domain.UserChanges{Name: strPtr("Jane")}},
+		{"email set, name absent", `{"email": "jane@example.com"}`, domain.UserChanges{Email: strPtr("jane@example.com")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			changes, err := parseMergePatch([]byte(tt.body))
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, changes)
+		})
+	}
+}
+
+func TestParseMergePatch_Rejected(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"not an object", `["name"]`},
+		{"email cannot be cleared", `{"email": null}`},
+		{"invalid email", `{"email": "jane"}`},
+		{"unknown field", `{"password": "secret"}`},
+		{"wrong type", `{"name": 42}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			_, err := parseMergePatch([]byte(tt.body))
+
+			// Assert
+			assert.ErrorIs(t, err, domain.ErrInvalidPatch)
+		})
+	}
+}
+
+func TestParseJSONPatch_AllowedOps(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected domain.UserChanges
+	}{
+		{"replace name", `[{"op": "replace", "path": "/name", "value": "Jane"}]`, domain.UserChanges{Name: strPtr("Jane")}},
+		{"replace email", `[{"op": "replace", "path": "/email", "value": "jane@example.com"}]`, domain.UserChanges{Email: strPtr("jane@example.com")}},
+		{"remove name", `[{"op": "remove", "path": "/name"}]`, domain.UserChanges{Name: strPtr("")}},
+		{"empty patch", `[]`, domain.UserChanges{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			changes, err := parseJSONPatch([]byte(tt.body))
+
+			// Assert
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, changes)
+		})
+	}
+}
+
+func TestParseJSONPatch_ForbiddenOps(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{"add", `[{"op": "add", "path": "/name", "value": "Jane"}]`},
+		{"copy", `[{"op": "copy", "from": "/email", "path": "/name"}]`},
+		{"move", `[{"op": "move", "from": "/email", "path": "/name"}]`},
+		{"test", `[{"op": "test", "path": "/name", "value": "Jane"}]`},
+		{"remove email", `[{"op": "remove", "path": "/email"}]`},
+		{"non-whitelisted path", `[{"op": "replace", "path": "/id", "value": "other"}]`},
+		{"nested path", `[{"op": "replace", "path": "/name/first", "value": "Jane"}]`},
+		{"not an array", `{"op": "replace"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			_, err := parseJSONPatch([]byte(tt.body))
+
+			// Assert
+			assert.ErrorIs(t, err, domain.ErrInvalidPatch)
+		})
+	}
+}
+
+func TestHandler_patchUser(t *testing.T) {
+	// Arrange
+	mockUserService, handler, _ := setupTestHandler()
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/users/user-123", bytes.NewBufferString(`{"name": null}`))
+	req.Header.Set("Content-Type", mediaTypeMergePatch)
+	rr := httptest.NewRecorder()
+
+	mockUserService.On("Patch", mock.Anything, "user-123", domain.UserChanges{Name: strPtr("")}).
+		Return(&domain.User{ID: "user-123", Email: "jane@example.com"}, nil)
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockUserService.AssertExpectations(t)
+}
+
+func TestHandler_patchUser_UnprocessableOp(t *testing.T) {
+	// Arrange
+	mockUserService, handler, _ := setupTestHandler()
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/users/user-123",
+		bytes.NewBufferString(`[{"op": "add", "path": "/name", "value": "Jane"}]`))
+	req.Header.Set("Content-Type", mediaTypeJSONPatch)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), `unsupported op \"add\"`)
+	mockUserService.AssertNotCalled(t, "Patch", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestHandler_patchUser_UnsupportedMediaType(t *testing.T) {
+	// Arrange
+	_, handler, _ := setupTestHandler()
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/users/user-123", bytes.NewBufferString(`name=Jane`))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusUnsupportedMediaType, rr.Code)
+}
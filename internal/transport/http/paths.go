@@ -0,0 +1,71 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Policies of non-canonical paths sent with safe methods, see WithTrailingSlash
+const (
+	// TrailingSlashRedirect answers GET and HEAD with a 308 to the canonical path
+	TrailingSlashRedirect = "redirect"
+	// TrailingSlashRewrite serves every request as if the canonical path was sent
+	TrailingSlashRewrite = "rewrite"
+)
+
+// WithTrailingSlash sets how requests to a path with a trailing slash or
+// duplicate slashes are served. Requests with other methods are always
+// rewritten, since clients do not reliably replay a body on redirect.
+func WithTrailingSlash(policy string) HandlerOption {
+	return func(h *Handler) {
+		if policy != "" {
+			h.trailingSlash = policy
+		}
+	}
+}
+
+// canonicalPath collapses duplicate slashes and strips the trailing slash.
+// No route has a meaningful trailing slash, the root aside.
+func canonicalPath(p string) string {
+	for strings.Contains(p, "//") {
+		p = strings.ReplaceAll(p, "//", "/")
+	}
+	if len(p) > 1 {
+		p = strings.TrimSuffix(p, "/")
+	}
+	return p
+}
+
+// Middleware serving non-canonical paths by their canonical route. It runs
+// before routing, since ServeMux would answer them with a 404 or a 301.
+func (h *Handler) normalizePath(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		canonical := canonicalPath(r.URL.Path)
+		if canonical == r.URL.Path {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u := *r.URL
+		u.Path = canonical
+		if u.RawPath != "" {
+			u.RawPath = canonicalPath(u.RawPath)
+		}
+
+		safe := r.Method == http.MethodGet || r.Method == http.MethodHead
+		if safe && h.trailingSlash == TrailingSlashRedirect {
+			// Only the path and query are sent, a collapsed //host/path
+			// must not become a protocol-relative URL
+			location := u.EscapedPath()
+			if u.RawQuery != "" {
+				location += "?" + u.RawQuery
+			}
+			http.Redirect(w, r, location, http.StatusPermanentRedirect)
+			return
+		}
+
+		rewritten := r.Clone(r.Context())
+		rewritten.URL = &u
+		next.ServeHTTP(w, rewritten)
+	})
+}
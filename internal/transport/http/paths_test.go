@@ -0,0 +1,134 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+func TestCanonicalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/", want: "/"},
+		{path: "//", want: "/"},
+		{path: "/api/v1/users", want: "/api/v1/users"},
+		{path: "/api/v1/users/", want: "/api/v1/users"},
+		{path: "//api/v1/users", want: "/api/v1/users"},
+		{path: "/api///v1//users//", want: "/api/v1/users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			assert.Equal(t, tt.want, canonicalPath(tt.path))
+		})
+	}
+}
+
+func TestHandler_normalizePath_Redirect(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		wantLocation string
+	}{
+		{name: "trailing slash", method: http.MethodGet, target: "/api/v1/users/", wantLocation: "/api/v1/users"},
+		{name: "query is kept", method: http.MethodGet, target: "/api/v1/users/?sort=name", wantLocation: "/api/v1/users?sort=name"},
+		{name: "head", method: http.MethodHead, target: "/api/v1/users/", wantLocation: "/api/v1/users"},
+		// A Location of //evil.example/x would send clients to another host
+		{name: "leading double slash", method: http.MethodGet, target: "//evil.example/x", wantLocation: "/evil.example/x"},
+		{name: "escaped segment", method: http.MethodGet, target: "/api/v1/users/a%2Fb/", wantLocation: "/api/v1/users/a%2Fb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			mockUserService, handler, _ := setupTestHandler()
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler.ServeHTTP(rr, req)
+
+			// Assert
+			assert.Equal(t, http.StatusPermanentRedirect, rr.Code)
+			assert.Equal(t, tt.wantLocation, rr.Header().Get("Location"))
+			mockUserService.AssertNotCalled(t, "List", mock.Anything, mock.Anything)
+		})
+	}
+}
+
+func TestHandler_normalizePath_RewritesPost(t *testing.T) {
+	// Arrange
+	mockUserService, handler, _ := setupTestHandler()
+	body, _ := json.Marshal(createUserRQ{Email: "test@example.com", Password: "password123", Name: "Test User"})
+	req := httptest.NewRequest(http.MethodPost, "//api/v1/users/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+
+	mockUserService.On("Create", mock.Anything, mock.MatchedBy(func(user *domain.User) bool {
+		return user.Email == "test@example.com"
+	})).Return(nil)
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	mockUserService.AssertExpectations(t)
+}
+
+func TestHandler_normalizePath_RewritePolicy(t *testing.T) {
+	// Arrange
+	mockUserService := new(MockUserService)
+	log := logger.New()
+	handler := NewHandler(&service.Services{User: mockUserService, Log: log}, log, WithTrailingSlash(TrailingSlashRewrite))
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/users/?sort=name", nil)
+	rr := httptest.NewRecorder()
+
+	mockUserService.On("List", mock.Anything, mock.Anything).Return(&domain.UserPage{}, nil)
+
+	// Act
+	handler.ServeHTTP(rr, req)
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockUserService.AssertExpectations(t)
+}
+
+func TestHandler_normalizePath_Internal(t *testing.T) {
+	// Arrange
+	_, handler, _ := setupTestHandler()
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/livez/", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusPermanentRedirect, rr.Code)
+	assert.Equal(t, "/livez", rr.Header().Get("Location"))
+}
+
+// No route has a meaningful trailing slash, so every pattern must be the
+// canonical path its variants are served by. A route that needs one must
+// change this policy first.
+func TestHandler_RoutesAreCanonical(t *testing.T) {
+	// Arrange
+	_, handler, _ := setupTestHandler()
+
+	// Assert
+	assert.NotEmpty(t, handler.routes)
+	for _, r := range handler.routes {
+		assert.Equal(t, canonicalPath(r.Path), r.Path, "%s %s is not canonical", r.Method, r.Path)
+	}
+}
@@ -0,0 +1,37 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/health"
+)
+
+// readinessTimeout bounds each readiness check
+const readinessTimeout = 2 * time.Second
+
+var (
+	livezBody        = []byte("ok\n")
+	livezContentType = []string{"text/plain; charset=utf-8"}
+)
+
+// livez reports that the process is alive. It must stay allocation-free:
+// no logging, no metrics, no dependency checks.
+func livez(w http.ResponseWriter, r *http.Request) {
+	w.Header()["Content-Type"] = livezContentType
+	w.WriteHeader(http.StatusOK)
+	w.Write(livezBody)
+}
+
+// readyz reports whether the service can handle traffic. A service that is
+// still starting is not ready either, its report tells it apart from a failure.
+func (h *Handler) readyz(w http.ResponseWriter, r *http.Request) {
+	report := health.Run(r.Context(), readinessTimeout, h.readinessCheckers...)
+
+	status := http.StatusOK
+	if report.Failed() || report.Starting() {
+		status = http.StatusServiceUnavailable
+	}
+
+	h.respondJSON(w, status, report)
+}
@@ -0,0 +1,195 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/health"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+	"github.com/romanitalian/carch-go/internal/transport/accesslog"
+)
+
+type stubChecker struct {
+	result health.Result
+}
+
+func (s stubChecker) Name() string                            { return "stub" }
+func (s stubChecker) Check(ctx context.Context) health.Result { return s.result }
+
+// discardWriter is a ResponseWriter that does not allocate on use
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header         { return d.header }
+func (d *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardWriter) WriteHeader(int)             {}
+
+func setupProbeHandler(opts ...HandlerOption) (*Handler, *bytes.Buffer) {
+	var logs bytes.Buffer
+	log := logger.New(logger.WithOutput(&logs))
+	handler := NewHandler(&service.Services{User: new(MockUserService), Log: log}, log, opts...)
+	return handler, &logs
+}
+
+func TestHandler_livez_NotLogged(t *testing.T) {
+	// Arrange
+	handler, logs := setupProbeHandler()
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/livez", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "ok\n", rr.Body.String())
+	assert.Empty(t, logs.String())
+}
+
+func TestLivez_NoAllocations(t *testing.T) {
+	// Arrange
+	w := &discardWriter{header: make(http.Header)}
+	req := httptest.NewRequest(http.MethodGet, "/livez", nil)
+
+	// Act
+	allocs := testing.AllocsPerRun(100, func() {
+		livez(w, req)
+	})
+
+	// Assert
+	assert.Zero(t, allocs)
+}
+
+func TestHandler_accessLogExclude(t *testing.T) {
+	// Arrange
+	handler, logs := setupProbeHandler(WithAccessLogExclude("/metrics"))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, logs.String(), `"transport":"http"`)
+}
+
+func TestHandler_readyz_Logged(t *testing.T) {
+	// Arrange
+	handler, logs := setupProbeHandler(
+		WithAccessLogExclude("/metrics"),
+		WithReadinessCheckers(stubChecker{health.Pass("connected")}),
+	)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, logs.String(), `"transport":"http"`)
+	assert.Contains(t, logs.String(), `"route":"GET /readyz"`)
+}
+
+func TestHandler_accessLogDebug(t *testing.T) {
+	tests := []struct {
+		name      string
+		level     string
+		wantLevel string
+	}{
+		{name: "logger at info level", level: "info"},
+		{name: "logger at debug level", level: "debug", wantLevel: "debug"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			var logs bytes.Buffer
+			log := logger.New(logger.WithOutput(&logs), logger.WithLevelString(tt.level))
+			handler := NewHandler(&service.Services{User: new(MockUserService), Log: log}, log,
+				WithAccessLogDebug("/readyz"),
+				WithReadinessCheckers(stubChecker{health.Pass("connected")}))
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			// Assert
+			assert.Equal(t, http.StatusOK, rr.Code)
+			entry := accessLogEntry(t, &logs)
+			if tt.wantLevel == "" {
+				assert.Nil(t, entry)
+				return
+			}
+			require.NotNil(t, entry)
+			assert.Equal(t, tt.wantLevel, entry["level"])
+		})
+	}
+}
+
+func TestHandler_accessLog_RequestIDAndBytes(t *testing.T) {
+	// Arrange
+	handler, logs := setupProbeHandler(WithReadinessCheckers(stubChecker{health.Pass("connected")}))
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	req.Header.Set(accesslog.RequestIDHeader, "req-42")
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.Internal().ServeHTTP(rr, req)
+
+	// Assert
+	entry := accessLogEntry(t, logs)
+	require.NotNil(t, entry)
+	assert.Equal(t, "info", entry["level"])
+	assert.Equal(t, "req-42", entry["request_id"])
+	assert.Equal(t, "req-42", rr.Header().Get(accesslog.RequestIDHeader))
+	require.NotZero(t, rr.Body.Len())
+	assert.Equal(t, float64(rr.Body.Len()), entry["bytes_out"], "the bytes of the response body are counted")
+}
+
+// accessLogEntry returns the access log entry of logs, nil if there is none
+func accessLogEntry(t *testing.T, logs *bytes.Buffer) map[string]interface{} {
+	t.Helper()
+
+	for _, line := range bytes.Split(bytes.TrimSpace(logs.Bytes()), []byte("\n")) {
+		var entry map[string]interface{}
+		if json.Unmarshal(line, &entry) == nil && entry["message"] == accesslog.Message {
+			return entry
+		}
+	}
+	return nil
+}
+
+func TestHandler_readyz_Failing(t *testing.T) {
+	// Arrange
+	handler, _ := setupProbeHandler(WithReadinessCheckers(stubChecker{health.Fail("ping failed")}))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), "ping failed")
+}
+
+func TestHandler_readyz_Starting(t *testing.T) {
+	// Arrange
+	handler, _ := setupProbeHandler(WithReadinessCheckers(stubChecker{health.Starting("applying migration 2 of 5")}))
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.Internal().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	// Assert
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"status":"starting"`)
+	assert.NotContains(t, rr.Body.String(), `"status":"fail"`)
+}
@@ -0,0 +1,33 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/queryreg"
+)
+
+// queryReportRS is the usage of the registered database statements since
+// the process started
+type queryReportRS struct {
+	StartedAt time.Time        `json:"started_at"`
+	Queries   []queryreg.Usage `json:"queries"`
+}
+
+// WithQueryRegistry sets the registry GET /api/v1/admin/queries reports,
+// queryreg.Default unless set
+func WithQueryRegistry(registry *queryreg.Registry) HandlerOption {
+	return func(h *Handler) {
+		h.queries = registry
+	}
+}
+
+// listQueries reports how often each registered statement ran in this
+// process. Statements with no calls may be dead code, see `cli queries report`
+// for the usage across instances.
+func (h *Handler) listQueries(w http.ResponseWriter, r *http.Request) {
+	h.respondJSON(w, http.StatusOK, queryReportRS{
+		StartedAt: h.queries.StartedAt(),
+		Queries:   h.queries.Report(),
+	})
+}
@@ -0,0 +1,52 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/queryreg"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+func TestHandler_listQueries(t *testing.T) {
+	// Arrange
+	registry := queryreg.New()
+	get := registry.Register("users.get_by_id", "repository.(*UserRepository).GetByID", `SELECT 1`)
+	registry.Register("users.purge", "repository.(*UserRepository).Purge", `SELECT 2`)
+	calledAt := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	registry.Observe(get, calledAt)
+
+	log := logger.New()
+	handler := NewHandler(&service.Services{Log: log}, log, WithQueryRegistry(registry))
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/admin/queries", nil)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.Internal().ServeHTTP(rr, req)
+
+	// Assert
+	require.Equal(t, http.StatusOK, rr.Code)
+	var body queryReportRS
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &body))
+	assert.Equal(t, registry.StartedAt().Unix(), body.StartedAt.Unix())
+	require.Len(t, body.Queries, 2)
+	assert.Equal(t, queryreg.Usage{
+		Name:         "users.get_by_id",
+		Origin:       "repository.(*UserRepository).GetByID",
+		Calls:        1,
+		LastCalledAt: &calledAt,
+	}, body.Queries[0])
+	assert.Equal(t, uint64(0), body.Queries[1].Calls)
+	assert.NotContains(t, rr.Body.String(), `"last_called_at":null`)
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/admin/queries", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code, "not served on the public port")
+}
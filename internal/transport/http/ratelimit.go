@@ -0,0 +1,176 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/transport/accesslog"
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+)
+
+// rateLimitSweepInterval is how often buckets of clients gone quiet are dropped
+const rateLimitSweepInterval = time.Minute
+
+// bucket holds the tokens of a client as of updated
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// rateLimiter is a token bucket per client address. Each bucket holds up to
+// burst tokens and refills at rate tokens per second, a request takes one.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+
+	now func() time.Time
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// allow takes a token of the client, or returns how long until it has one
+func (l *rateLimiter) allow(client string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, updated: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.updated).Seconds()*l.rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// sweep drops, every rateLimitSweepInterval, the buckets that refilled
+// completely, which a new bucket replaces without changing what is allowed
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < rateLimitSweepInterval {
+		return
+	}
+	l.swept = now
+
+	refill := time.Duration(l.burst / l.rate * float64(time.Second))
+	for client, b := range l.buckets {
+		if now.Sub(b.updated) >= refill {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// size returns how many clients have a bucket
+func (l *rateLimiter) size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// rateLimitError reports how long a client has to wait
+type rateLimitError struct {
+	rate       float64
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("%s: retry after %s", errmap.ErrRateLimited, e.retryAfter)
+}
+
+func (e *rateLimitError) Unwrap() error {
+	return errmap.ErrRateLimited
+}
+
+// Details is exposed to clients by respondError
+func (e *rateLimitError) Details() string {
+	return fmt.Sprintf("at most %g requests per second are allowed, retry after %d seconds", e.rate, retryAfterSeconds(e.retryAfter))
+}
+
+// retryAfterSeconds rounds a wait up to the whole seconds of Retry-After
+func retryAfterSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+// WithRateLimit lets each client address make rate requests per second to
+// the rate limited routes, with bursts of up to burst requests. A rate of 0
+// disables the limit.
+func WithRateLimit(rate float64, burst int) HandlerOption {
+	return func(h *Handler) {
+		if rate <= 0 || burst <= 0 {
+			return
+		}
+		h.rateLimiter = newRateLimiter(rate, burst)
+	}
+}
+
+// WithTrustedProxy takes the client address from X-Forwarded-For, for
+// servers only reachable through a proxy that appends to the header
+func WithTrustedProxy(trusted bool) HandlerOption {
+	return func(h *Handler) {
+		h.trustProxy = trusted
+	}
+}
+
+// clientIP returns the address of the client. Behind a trusted proxy it is
+// the last address of X-Forwarded-For, the one the proxy saw: the addresses
+// before it are sent by the client and can be forged.
+func (h *Handler) clientIP(r *http.Request) string {
+	if h.trustProxy {
+		forwarded := strings.Join(r.Header.Values("X-Forwarded-For"), ",")
+		if i := strings.LastIndexByte(forwarded, ','); i >= 0 {
+			forwarded = forwarded[i+1:]
+		}
+		if ip := net.ParseIP(strings.TrimSpace(forwarded)); ip != nil {
+			return ip.String()
+		}
+	}
+
+	return accesslog.ClientHost(r.RemoteAddr)
+}
+
+// Middleware answering 429 with Retry-After when the client address ran out
+// of tokens. Without a rate limit configured, requests pass through.
+func (h *Handler) rateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.rateLimiter == nil {
+			next(w, r)
+			return
+		}
+
+		client := h.clientIP(r)
+		ok, wait := h.rateLimiter.allow(client)
+		if !ok {
+			metrics.HTTPRateLimited.WithLabelValues(r.Pattern).Inc()
+			h.log.Warn("Rate limit exceeded", map[string]interface{}{"client": client, "path": r.URL.Path})
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+			h.respondError(w, r, &rateLimitError{rate: h.rateLimiter.rate, retryAfter: wait})
+			return
+		}
+		next(w, r)
+	}
+}
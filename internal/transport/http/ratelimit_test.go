@@ -0,0 +1,181 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// newRateLimitedHandler creates users with a rate limit on a frozen clock
+func newRateLimitedHandler(rate float64, burst int, opts ...HandlerOption) (*Handler, *time.Time) {
+	svc := new(MockUserService)
+	svc.On("Create", mock.Anything, mock.Anything).Return(nil)
+	log := logger.New()
+
+	opts = append(opts, WithRateLimit(rate, burst))
+	h := NewHandler(&service.Services{User: svc, Log: log}, log, opts...)
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	h.rateLimiter.now = func() time.Time { return now }
+	return h, &now
+}
+
+func createUserFrom(h http.Handler, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/users",
+		strings.NewReader(`{"email":"ada@example.com","password":"password123","name":"Ada"}`))
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandler_rateLimit(t *testing.T) {
+	// Arrange
+	handler, now := newRateLimitedHandler(0.5, 2)
+
+	// Act: the burst is spent, then a token comes back every 2 seconds
+	var codes []int
+	for range 3 {
+		codes = append(codes, createUserFrom(handler, "203.0.113.7:4711", "").Code)
+	}
+	limited := createUserFrom(handler, "203.0.113.7:4712", "")
+	other := createUserFrom(handler, "203.0.113.8:4711", "")
+	*now = now.Add(2 * time.Second)
+	refilled := createUserFrom(handler, "203.0.113.7:4711", "")
+
+	// Assert
+	assert.Equal(t, []int{http.StatusCreated, http.StatusCreated, http.StatusTooManyRequests}, codes)
+	require.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, "2", limited.Header().Get("Retry-After"))
+	var rs errorRS
+	require.NoError(t, json.NewDecoder(limited.Body).Decode(&rs))
+	assert.Equal(t, errorRS{
+		Error:   "too many requests, retry later",
+		Code:    "rate_limited",
+		Details: "at most 0.5 requests per second are allowed, retry after 2 seconds",
+	}, rs)
+	assert.Equal(t, http.StatusCreated, other.Code, "clients have buckets of their own")
+	assert.Equal(t, http.StatusCreated, refilled.Code)
+}
+
+func TestHandler_rateLimit_OnlyCreate(t *testing.T) {
+	handler, _ := newRateLimitedHandler(1, 1)
+	handler.services.User.(*MockUserService).On("List", mock.Anything, mock.Anything).Return(&domain.UserPage{}, nil)
+
+	createUserFrom(handler, "203.0.113.7:4711", "")
+	for range 3 {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+		req.RemoteAddr = "203.0.113.7:4711"
+		handler.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+}
+
+func TestHandler_clientIP(t *testing.T) {
+	tests := []struct {
+		name         string
+		trustProxy   bool
+		forwardedFor []string
+		want         string
+	}{
+		{name: "remote address", want: "10.0.0.1"},
+		{name: "untrusted header", forwardedFor: []string{"198.51.100.1"}, want: "10.0.0.1"},
+		{name: "trusted proxy", trustProxy: true, forwardedFor: []string{"198.51.100.1"}, want: "198.51.100.1"},
+		{name: "forged entries", trustProxy: true, forwardedFor: []string{"192.0.2.66, 198.51.100.1"}, want: "198.51.100.1"},
+		{name: "repeated headers", trustProxy: true, forwardedFor: []string{"192.0.2.66", " 2001:db8::1 "}, want: "2001:db8::1"},
+		{name: "invalid entry", trustProxy: true, forwardedFor: []string{"198.51.100.1, unknown"}, want: "10.0.0.1"},
+		{name: "no header", trustProxy: true, want: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			h := &Handler{trustProxy: tt.trustProxy}
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/users", nil)
+			req.RemoteAddr = "10.0.0.1:4711"
+			for _, value := range tt.forwardedFor {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			// Act & Assert
+			assert.Equal(t, tt.want, h.clientIP(req))
+		})
+	}
+}
+
+func TestHandler_rateLimit_TrustedProxy(t *testing.T) {
+	handler, _ := newRateLimitedHandler(1, 1, WithTrustedProxy(true))
+
+	assert.Equal(t, http.StatusCreated, createUserFrom(handler, "10.0.0.1:4711", "198.51.100.1").Code)
+	assert.Equal(t, http.StatusCreated, createUserFrom(handler, "10.0.0.1:4711", "198.51.100.2").Code)
+	assert.Equal(t, http.StatusTooManyRequests, createUserFrom(handler, "10.0.0.1:4711", "192.0.2.1, 198.51.100.1").Code,
+		"entries before the one the proxy appended do not change the client")
+}
+
+func TestRateLimiter_Sweep(t *testing.T) {
+	// Arrange
+	limiter := newRateLimiter(1, 5)
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+	for i := range 100 {
+		limiter.allow(fmt.Sprintf("198.51.100.%d", i))
+	}
+	limiter.allow("203.0.113.7")
+	require.Equal(t, 101, limiter.size())
+
+	// Act: the quiet clients refilled, the busy one has not
+	now = now.Add(rateLimitSweepInterval - 3*time.Second)
+	for range 5 {
+		limiter.allow("203.0.113.7")
+	}
+	now = now.Add(3 * time.Second)
+	ok, _ := limiter.allow("203.0.113.7")
+
+	// Assert
+	assert.True(t, ok)
+	assert.Equal(t, 1, limiter.size())
+}
+
+func TestRateLimiter_Concurrent(t *testing.T) {
+	// Arrange
+	const burst = 50
+	limiter := newRateLimiter(1, burst)
+	now := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	limiter.now = func() time.Time { return now }
+
+	// Act
+	var allowed atomic.Int64
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				if ok, _ := limiter.allow("203.0.113.7"); ok {
+					allowed.Add(1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Assert
+	assert.Equal(t, int64(burst), allowed.Load())
+}
@@ -0,0 +1,102 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+)
+
+// Results of a recovered panic, the result label of the panic metric
+const (
+	// panicRecovered is a panic before the response started, answered with 500
+	panicRecovered = "recovered"
+	// panicAborted is a panic after the response started, whose connection
+	// is aborted
+	panicAborted = "aborted"
+)
+
+// recoverPanic answers the requests whose handler panics. Before anything
+// was written the client gets a 500 like for any internal error. Once the
+// status and part of the body are sent it cannot tell the client, which
+// would otherwise get a truncated body that looks complete, so the
+// response is marked aborted and abortTruncated breaks the connection after
+// the request is logged. Handlers aborting on purpose with
+// http.ErrAbortHandler are aborted the same way without being logged.
+func (h *Handler) recoverPanic(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := newResponseWriter(w)
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				abortResponse(rw)
+				return
+			}
+
+			result := panicRecovered
+			if rw.wroteHeader {
+				result = panicAborted
+			}
+			metrics.HTTPPanics.WithLabelValues(r.Pattern, result).Inc()
+			h.log.Error("Panic serving request", panicError(p), map[string]interface{}{
+				"path":   r.URL.Path,
+				"result": result,
+				"stack":  string(debug.Stack()),
+			})
+
+			if rw.wroteHeader {
+				abortResponse(rw)
+				return
+			}
+			m := errmap.Internal
+			h.respondJSON(rw, m.HTTPStatus, errorRS{Error: m.Message, Code: m.Code})
+		}()
+
+		next(rw, r)
+	}
+}
+
+// panicError turns the value of a panic into an error
+func panicError(p interface{}) error {
+	if err, ok := p.(error); ok {
+		return err
+	}
+	return errors.New(fmt.Sprint(p))
+}
+
+// abortResponse marks every responseWriter wrapping w as aborted, so that
+// the request is logged and counted as failed although its status was sent
+func abortResponse(w http.ResponseWriter) {
+	for w != nil {
+		if rw, ok := w.(*responseWriter); ok {
+			rw.aborted = true
+			rw.statusCode = http.StatusInternalServerError
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return
+		}
+		w = u.Unwrap()
+	}
+}
+
+// abortTruncated is the outermost middleware. It aborts the connection of
+// the responses marked aborted once the other middleware is done with them,
+// by panicking with http.ErrAbortHandler: the server then closes HTTP/1
+// connections without the final chunk and resets HTTP/2 streams, so clients
+// see an error rather than a complete body.
+func (h *Handler) abortTruncated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rw := newResponseWriter(w)
+		next(rw, r)
+		if rw.aborted {
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package http
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// streamedRecords is the number of records written before the panic of
+// the streaming routes, enough to be flushed to the client
+const streamedRecords = 2 * streamFlushEvery
+
+// lockedBuffer is the log output of a server, read by the tests
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newPanicServer serves a handler with test routes, some of them panicking
+// before or while streaming their response
+func newPanicServer(t *testing.T, logs io.Writer) *httptest.Server {
+	t.Helper()
+
+	log := logger.New(logger.WithOutput(logs))
+	h := NewHandler(&service.Services{Log: log}, log)
+	h.handle("GET /test/panic", routeSheddable, func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	h.handle("GET /test/ndjson", routeSheddable, func(w http.ResponseWriter, r *http.Request) {
+		s := newNDJSONStream(w)
+		for i := range streamedRecords {
+			require.NoError(t, s.Write(map[string]int{"n": i}))
+		}
+		if r.URL.Query().Get("fail") != "" {
+			panic("boom")
+		}
+		require.NoError(t, s.Close())
+	})
+	h.handle("GET /test/csv", routeSheddable, func(w http.ResponseWriter, r *http.Request) {
+		s, err := newCSVStream(w, []string{"n"})
+		require.NoError(t, err)
+		for i := range streamedRecords {
+			require.NoError(t, s.Write([]string{strconv.Itoa(i)}))
+		}
+		if r.URL.Query().Get("fail") != "" {
+			panic(http.ErrAbortHandler)
+		}
+		require.NoError(t, s.Close())
+	})
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestHandler_recoverPanic_beforeResponse(t *testing.T) {
+	// Arrange
+	var logs lockedBuffer
+	srv := newPanicServer(t, &logs)
+	recovered := metrics.HTTPPanics.WithLabelValues("GET /test/panic", panicRecovered)
+	before := testutil.ToFloat64(recovered)
+
+	// Act
+	resp, err := http.Get(srv.URL + "/test/panic")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.JSONEq(t, `{"error":"internal server error","code":"internal"}`, string(body))
+	assert.Equal(t, before+1, testutil.ToFloat64(recovered))
+	assert.Contains(t, logs.String(), `"message":"Panic serving request"`)
+	assert.Contains(t, logs.String(), `"error":"boom"`)
+}
+
+func TestHandler_recoverPanic_midStream(t *testing.T) {
+	tests := []struct {
+		name      string
+		route     string
+		path      string
+		wantEnd   string
+		wantPanic bool
+	}{
+		{name: "complete ndjson", route: "GET /test/ndjson", path: "/test/ndjson", wantEnd: `{"complete":true,"count":200}` + "\n"},
+		{name: "failed ndjson", route: "GET /test/ndjson", path: "/test/ndjson?fail=1", wantPanic: true},
+		{name: "complete csv", route: "GET /test/csv", path: "/test/csv", wantEnd: "199\n"},
+		{name: "aborted csv", route: "GET /test/csv", path: "/test/csv?fail=1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			var logs lockedBuffer
+			srv := newPanicServer(t, &logs)
+			aborted := metrics.HTTPPanics.WithLabelValues(tt.route, panicAborted)
+			before := testutil.ToFloat64(aborted)
+
+			// Act
+			resp, err := http.Get(srv.URL + tt.path)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+
+			// Assert
+			assert.Equal(t, http.StatusOK, resp.StatusCode, "the status was sent before the failure")
+			if tt.wantEnd != "" {
+				require.NoError(t, err)
+				assert.True(t, bytes.HasSuffix(body, []byte(tt.wantEnd)))
+				assert.Equal(t, strconv.Itoa(streamedRecords), resp.Trailer.Get(streamCompleteTrailer))
+				assert.Equal(t, before, testutil.ToFloat64(aborted))
+				return
+			}
+
+			assert.ErrorIs(t, err, io.ErrUnexpectedEOF, "the connection is aborted rather than closed cleanly")
+			assert.NotEmpty(t, body, "records were flushed before the failure")
+			assert.NotContains(t, string(body), `"complete"`)
+			assert.Empty(t, resp.Trailer.Get(streamCompleteTrailer))
+			assert.Contains(t, logs.String(), `"status":500`, "the request is logged as failed")
+			if tt.wantPanic {
+				assert.Equal(t, before+1, testutil.ToFloat64(aborted))
+				assert.Contains(t, logs.String(), `"result":"aborted"`)
+			} else {
+				assert.Equal(t, before, testutil.ToFloat64(aborted))
+				assert.NotContains(t, logs.String(), "Panic serving request", "deliberate aborts are not panics")
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package http
 
 import (
 	"context"
+	"net"
 	"net/http"
 	"time"
 
@@ -9,34 +10,159 @@ import (
 	"github.com/romanitalian/carch-go/internal/service"
 )
 
+// Timeouts of a listener. Zero values are replaced by the listener's defaults.
+type Timeouts struct {
+	Read       time.Duration
+	Write      time.Duration
+	Idle       time.Duration
+	ReadHeader time.Duration
+}
+
+// Default timeouts of the listeners. Metrics scrapes of a busy process can
+// take longer than an API response, so the internal listener allows more
+// time. Idle and header timeouts are the read timeout, as net/http does.
+var (
+	publicTimeouts   = Timeouts{Read: 10 * time.Second, Write: 10 * time.Second}
+	internalTimeouts = Timeouts{Read: 10 * time.Second, Write: 30 * time.Second}
+)
+
+// withDefaults returns the timeouts with zero values taken from defaults
+func (t Timeouts) withDefaults(defaults Timeouts) Timeouts {
+	if t.Read <= 0 {
+		t.Read = defaults.Read
+	}
+	if t.Write <= 0 {
+		t.Write = defaults.Write
+	}
+	if t.Idle <= 0 {
+		t.Idle = defaults.Idle
+	}
+	if t.Idle <= 0 {
+		t.Idle = t.Read
+	}
+	if t.ReadHeader <= 0 {
+		t.ReadHeader = defaults.ReadHeader
+	}
+	if t.ReadHeader <= 0 {
+		t.ReadHeader = t.Read
+	}
+	return t
+}
+
+// defaultInternalAddress keeps the internal listener off the network unless configured
+const defaultInternalAddress = "127.0.0.1"
+
 type Server struct {
-	srv     *http.Server
-	handler *Handler
-	log     *logger.Logger
+	name     string
+	srv      *http.Server
+	handler  *Handler
+	log      *logger.Logger
+	internal *Server
+	// certFile and keyFile serve the listener over TLS when set
+	certFile string
+	keyFile  string
 }
 
+// NewServer builds the public server. When cfg.InternalPort is set, the
+// internal routes get a server of their own, see Internal.
 func NewServer(cfg *Config, services *service.Services, log *logger.Logger) *Server {
-	handler := NewHandler(services, log)
-	address := cfg.Address + ":" + cfg.Port
-	log.Info("Starting HTTP server", map[string]interface{}{"address": address})
+	handler := NewHandler(services, log,
+		WithStatementBudget(cfg.StatementBudget, cfg.StatementBudgetStrict),
+		WithAccessLogExclude(cfg.AccessLogExclude...),
+		WithAccessLogDebug(cfg.AccessLogDebug...),
+		WithReadinessCheckers(cfg.ReadinessCheckers...),
+		WithConsistencyWindow(cfg.ConsistencyWindow),
+		WithSlowRequestThreshold(cfg.SlowRequestThreshold),
+		WithLoadShedding(cfg.ShedSoftLimit, cfg.ShedHardLimit, cfg.ShedLatencyThreshold),
+		WithPrincipalConcurrency(cfg.ConcurrencySlots, cfg.ConcurrencyLimit, cfg.ConcurrencyByRole, cfg.ConcurrencySlotTTL),
+		WithGroupConcurrency(cfg.GroupConcurrency, cfg.GroupWait),
+		WithRateLimit(cfg.CreateUserRate, cfg.CreateUserBurst),
+		WithTrustedProxy(cfg.TrustProxy),
+		WithThrottleOverrideToken(cfg.ThrottleOverrideToken),
+		WithTrailingSlash(cfg.TrailingSlash),
+		WithCORS(cfg.CORS),
+		WithWideEvents(cfg.WideEvents),
+		WithExplainSampler(cfg.ExplainSampler),
+	)
+
+	s := newServer("public", cfg.Address+":"+cfg.Port, handler, cfg.Timeouts.withDefaults(publicTimeouts), log)
+	s.handler = handler
+	s.certFile, s.keyFile = cfg.TLSCertFile, cfg.TLSKeyFile
+
+	if cfg.InternalPort != "" {
+		address := cfg.InternalAddress
+		if address == "" {
+			address = defaultInternalAddress
+		}
+		s.internal = newServer("internal", address+":"+cfg.InternalPort, handler.Internal(),
+			Timeouts{}.withDefaults(internalTimeouts), log)
+	}
+
+	return s
+}
+
+func newServer(name, address string, handler http.Handler, timeouts Timeouts, log *logger.Logger) *Server {
+	log.Info("Starting HTTP server", map[string]interface{}{
+		"listener":            name,
+		"address":             address,
+		"read_timeout":        timeouts.Read.String(),
+		"write_timeout":       timeouts.Write.String(),
+		"idle_timeout":        timeouts.Idle.String(),
+		"read_header_timeout": timeouts.ReadHeader.String(),
+	})
 	return &Server{
-		handler: handler,
-		log:     log,
+		name: name,
+		log:  log,
 		srv: &http.Server{
-			Addr:           address,
-			Handler:        handler,
-			ReadTimeout:    10 * time.Second,
-			WriteTimeout:   10 * time.Second,
-			MaxHeaderBytes: 1 << 20,
+			Addr:              address,
+			Handler:           handler,
+			ReadTimeout:       timeouts.Read,
+			WriteTimeout:      timeouts.Write,
+			IdleTimeout:       timeouts.Idle,
+			ReadHeaderTimeout: timeouts.ReadHeader,
+			MaxHeaderBytes:    1 << 20,
 		},
 	}
 }
 
+// Internal returns the server of the internal routes, nil when no internal port is configured
+func (s *Server) Internal() *Server {
+	return s.internal
+}
+
+// Probes returns a server on the public address that answers only the
+// probes, for a process refusing to serve its API without an internal port
+func (s *Server) Probes() *Server {
+	routes := s.handler.Internal()
+	mux := http.NewServeMux()
+	mux.Handle("GET /livez", routes)
+	mux.Handle("GET /readyz", routes)
+	probes := newServer("probes", s.srv.Addr, mux, Timeouts{}.withDefaults(internalTimeouts), s.log)
+	probes.certFile, probes.keyFile = s.certFile, s.keyFile
+	return probes
+}
+
+// Handler returns the root HTTP handler of the server
+func (s *Server) Handler() http.Handler {
+	return s.srv.Handler
+}
+
 func (s *Server) Run() error {
+	if s.certFile != "" {
+		return s.srv.ListenAndServeTLS(s.certFile, s.keyFile)
+	}
 	return s.srv.ListenAndServe()
 }
 
+// Serve accepts connections on l instead of the configured address
+func (s *Server) Serve(l net.Listener) error {
+	if s.certFile != "" {
+		return s.srv.ServeTLS(l, s.certFile, s.keyFile)
+	}
+	return s.srv.Serve(l)
+}
+
 func (s *Server) Shutdown(ctx context.Context) error {
-	s.log.Info("Shutting down HTTP server", nil)
+	s.log.Info("Shutting down HTTP server", map[string]interface{}{"listener": s.name})
 	return s.srv.Shutdown(ctx)
 }
@@ -0,0 +1,186 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+func newTestServer(cfg *Config) *Server {
+	log := logger.New(logger.WithOutput(io.Discard))
+	return NewServer(cfg, &service.Services{User: new(MockUserService), Log: log}, log)
+}
+
+// serve runs s on a random local port and returns its base URL
+func serve(t *testing.T, s *Server) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(l) }()
+	t.Cleanup(func() {
+		s.Shutdown(context.Background())
+		assert.ErrorIs(t, <-done, http.ErrServerClosed)
+	})
+
+	return "http://" + l.Addr().String()
+}
+
+func statusOf(t *testing.T, url string) int {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestNewServer_RouteSegregation(t *testing.T) {
+	// Arrange
+	s := newTestServer(&Config{Address: "127.0.0.1", Port: "0", InternalPort: "0"})
+	require.NotNil(t, s.Internal())
+
+	internalRoutes := []string{"/livez", "/readyz", "/metrics"}
+
+	for _, path := range internalRoutes {
+		// Act
+		public := httptest.NewRecorder()
+		s.Handler().ServeHTTP(public, httptest.NewRequest(http.MethodGet, path, nil))
+		internal := httptest.NewRecorder()
+		s.Internal().Handler().ServeHTTP(internal, httptest.NewRequest(http.MethodGet, path, nil))
+
+		// Assert
+		assert.Equal(t, http.StatusNotFound, public.Code, "%s must not be served publicly", path)
+		assert.Equal(t, http.StatusOK, internal.Code, "%s must be served internally", path)
+	}
+
+	// The admin route is only registered internally
+	public := httptest.NewRecorder()
+	s.Handler().ServeHTTP(public, httptest.NewRequest(http.MethodGet, "/api/v1/admin/instances", nil))
+	assert.Equal(t, http.StatusNotFound, public.Code)
+
+	// API routes are only registered publicly
+	internal := httptest.NewRecorder()
+	s.Internal().Handler().ServeHTTP(internal, httptest.NewRequest(http.MethodGet, "/api/v1/users/tombstones", nil))
+	assert.Equal(t, http.StatusNotFound, internal.Code)
+}
+
+func TestNewServer_InternalDisabledWithoutPort(t *testing.T) {
+	// Arrange
+	s := newTestServer(&Config{Address: "127.0.0.1", Port: "0"})
+	rr := httptest.NewRecorder()
+
+	// Act
+	s.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	// Assert
+	assert.Nil(t, s.Internal())
+	assert.Equal(t, http.StatusNotFound, rr.Code, "internal routes must not fall back to the public listener")
+}
+
+func TestServer_Probes(t *testing.T) {
+	// Arrange
+	s := newTestServer(&Config{Address: "127.0.0.1", Port: "8080"})
+	probes := s.Probes()
+
+	for path, want := range map[string]int{
+		"/livez":          http.StatusOK,
+		"/readyz":         http.StatusOK,
+		"/metrics":        http.StatusNotFound,
+		"/api/v1/users/1": http.StatusNotFound,
+	} {
+		// Act
+		rr := httptest.NewRecorder()
+		probes.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+
+		// Assert
+		assert.Equal(t, want, rr.Code, path)
+	}
+	assert.Equal(t, "127.0.0.1:8080", probes.srv.Addr)
+}
+
+func TestNewServer_InternalBindsLoopbackByDefault(t *testing.T) {
+	// Act
+	s := newTestServer(&Config{Address: "0.0.0.0", Port: "8080", InternalPort: "9100"})
+
+	// Assert
+	assert.Equal(t, "0.0.0.0:8080", s.srv.Addr)
+	assert.Equal(t, "127.0.0.1:9100", s.Internal().srv.Addr)
+	assert.Equal(t, internalTimeouts.Write, s.Internal().srv.WriteTimeout)
+	assert.Equal(t, publicTimeouts.Write, s.srv.WriteTimeout)
+}
+
+func TestNewServer_Timeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeouts Timeouts
+		want     Timeouts
+	}{
+		{
+			name: "defaults",
+			want: Timeouts{Read: 10 * time.Second, Write: 10 * time.Second, Idle: 10 * time.Second, ReadHeader: 10 * time.Second},
+		},
+		{
+			name:     "configured",
+			timeouts: Timeouts{Read: time.Minute, Write: 5 * time.Minute, Idle: 2 * time.Minute, ReadHeader: 5 * time.Second},
+			want:     Timeouts{Read: time.Minute, Write: 5 * time.Minute, Idle: 2 * time.Minute, ReadHeader: 5 * time.Second},
+		},
+		{
+			name:     "idle and header follow the read timeout",
+			timeouts: Timeouts{Read: time.Minute},
+			want:     Timeouts{Read: time.Minute, Write: 10 * time.Second, Idle: time.Minute, ReadHeader: time.Minute},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			s := newTestServer(&Config{Address: "127.0.0.1", Port: "0", InternalPort: "0", Timeouts: tt.timeouts})
+
+			// Assert
+			assert.Equal(t, tt.want.Read, s.srv.ReadTimeout)
+			assert.Equal(t, tt.want.Write, s.srv.WriteTimeout)
+			assert.Equal(t, tt.want.Idle, s.srv.IdleTimeout)
+			assert.Equal(t, tt.want.ReadHeader, s.srv.ReadHeaderTimeout)
+			assert.Equal(t, internalTimeouts.Write, s.Internal().srv.WriteTimeout, "the internal listener keeps its defaults")
+		})
+	}
+}
+
+func TestServer_IndependentShutdown(t *testing.T) {
+	// Arrange
+	s := newTestServer(&Config{Address: "127.0.0.1", Port: "0", InternalPort: "0"})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	internalDone := make(chan error, 1)
+	go func() { internalDone <- s.Internal().Serve(l) }()
+	internalURL := "http://" + l.Addr().String()
+
+	publicURL := serve(t, s)
+
+	require.Equal(t, http.StatusOK, statusOf(t, internalURL+"/livez"))
+
+	// Act
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Internal().Shutdown(ctx))
+
+	// Assert
+	assert.ErrorIs(t, <-internalDone, http.ErrServerClosed)
+	_, err = http.Get(internalURL + "/livez")
+	assert.Error(t, err, "the internal listener must be closed")
+	assert.Equal(t, http.StatusNotFound, statusOf(t, publicURL+"/livez"), "the public listener must keep serving")
+}
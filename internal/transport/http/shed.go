@@ -0,0 +1,200 @@
+package http
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+)
+
+// routeClass tells the load shedder how to treat a route
+type routeClass int
+
+const (
+	// routeCritical is admitted until the hard in-flight limit, e.g. mutations
+	routeCritical routeClass = iota
+	// routeSheddable is rejected first under load, e.g. listings and searches
+	routeSheddable
+	// routeStream is long-lived and neither shed nor tracked, e.g. event streams
+	routeStream
+)
+
+// Latency tracking of the load shedder
+const (
+	// shedSamples is how many recent request durations the p99 is taken over
+	shedSamples = 512
+	// shedWindow is how old a sample may be and still count
+	shedWindow = 10 * time.Second
+	// shedRecompute is how often the p99 is recomputed
+	shedRecompute = 100 * time.Millisecond
+	// shedRetryAfter is the Retry-After of rejected requests
+	shedRetryAfter = time.Second
+)
+
+// latencySample is the duration of a request completed at
+type latencySample struct {
+	at       time.Time
+	duration time.Duration
+}
+
+// loadShedder rejects requests early when the service is overloaded, before
+// queued work makes every request time out together. Past the soft limit of
+// in-flight requests or the p99 latency threshold, a growing fraction of
+// sheddable requests is rejected. Past the hard limit every request is.
+type loadShedder struct {
+	softLimit    int64
+	hardLimit    int64
+	p99Threshold time.Duration
+
+	inFlight atomic.Int64
+
+	mu       sync.Mutex
+	samples  []latencySample
+	next     int
+	p99      time.Duration
+	computed time.Time
+
+	now    func() time.Time
+	random func() float64
+}
+
+// WithLoadShedding enables load shedding. softLimit in-flight requests or a
+// p99 latency above p99Threshold start shedding sheddable routes, hardLimit
+// in-flight requests reject every route. Zero values disable each trigger.
+func WithLoadShedding(softLimit, hardLimit int, p99Threshold time.Duration) HandlerOption {
+	return func(h *Handler) {
+		if softLimit <= 0 && hardLimit <= 0 && p99Threshold <= 0 {
+			return
+		}
+		h.shedder = newLoadShedder(softLimit, hardLimit, p99Threshold)
+	}
+}
+
+func newLoadShedder(softLimit, hardLimit int, p99Threshold time.Duration) *loadShedder {
+	return &loadShedder{
+		softLimit:    int64(max(softLimit, 0)),
+		hardLimit:    int64(max(hardLimit, 0)),
+		p99Threshold: p99Threshold,
+		samples:      make([]latencySample, 0, shedSamples),
+		now:          time.Now,
+		random:       rand.Float64,
+	}
+}
+
+// admit reserves an in-flight slot for a request of class. Admitted
+// requests must call done.
+func (s *loadShedder) admit(class routeClass) bool {
+	n := s.inFlight.Add(1)
+	metrics.HTTPInFlight.Set(float64(n))
+
+	if s.hardLimit > 0 && n > s.hardLimit {
+		s.release()
+		return false
+	}
+	if class != routeSheddable {
+		return true
+	}
+
+	fraction := s.shedFraction(n)
+	metrics.HTTPShedRate.Set(fraction)
+	if fraction > 0 && s.random() < fraction {
+		s.release()
+		return false
+	}
+	return true
+}
+
+// done releases the slot of a request admitted at start and records its duration
+func (s *loadShedder) done(start time.Time) {
+	now := s.now()
+	s.release()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sample := latencySample{at: now, duration: now.Sub(start)}
+	if len(s.samples) < shedSamples {
+		s.samples = append(s.samples, sample)
+	} else {
+		s.samples[s.next] = sample
+	}
+	s.next = (s.next + 1) % shedSamples
+}
+
+func (s *loadShedder) release() {
+	metrics.HTTPInFlight.Set(float64(s.inFlight.Add(-1)))
+}
+
+// shedFraction is the share of sheddable requests to reject with n requests
+// in flight. It grows from 0 at a trigger's threshold to 1 at the hard limit,
+// or at twice the soft limit or p99 threshold.
+func (s *loadShedder) shedFraction(n int64) float64 {
+	var fraction float64
+	if s.softLimit > 0 && n > s.softLimit {
+		ceiling := s.hardLimit
+		if ceiling <= s.softLimit {
+			ceiling = 2 * s.softLimit
+		}
+		fraction = float64(n-s.softLimit) / float64(ceiling-s.softLimit)
+	}
+	if s.p99Threshold > 0 {
+		if p99 := s.latencyP99(); p99 > s.p99Threshold {
+			fraction = max(fraction, float64(p99-s.p99Threshold)/float64(s.p99Threshold))
+		}
+	}
+	return min(fraction, 1)
+}
+
+// latencyP99 returns the p99 of the durations recorded within shedWindow,
+// recomputed at most every shedRecompute
+func (s *loadShedder) latencyP99() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.computed) < shedRecompute {
+		return s.p99
+	}
+	s.computed = now
+
+	recent := make([]time.Duration, 0, len(s.samples))
+	for _, sample := range s.samples {
+		if now.Sub(sample.at) <= shedWindow {
+			recent = append(recent, sample.duration)
+		}
+	}
+	if len(recent) == 0 {
+		s.p99 = 0
+		return 0
+	}
+	sort.Slice(recent, func(i, j int) bool { return recent[i] < recent[j] })
+	s.p99 = recent[(len(recent)*99)/100]
+	return s.p99
+}
+
+// Middleware rejecting requests with 503 and Retry-After while the service
+// is overloaded. Without load shedding configured, requests pass through.
+func (h *Handler) shedLoad(class routeClass, next http.HandlerFunc) http.HandlerFunc {
+	if h.shedder == nil || class == routeStream {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.shedder.admit(class) {
+			metrics.HTTPShed.WithLabelValues(r.Pattern).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(shedRetryAfter.Seconds())))
+			h.respondError(w, r, errmap.ErrOverloaded)
+			return
+		}
+
+		start := h.shedder.now()
+		defer h.shedder.done(start)
+		next(w, r)
+	}
+}
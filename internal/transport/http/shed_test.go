@@ -0,0 +1,201 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// fakeClock is a clock advanced by hand
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// slowUserService takes latency on the fake clock to answer, and while gate
+// is set blocks until it is closed
+type slowUserService struct {
+	MockUserService
+	clock   *fakeClock
+	latency time.Duration
+	gate    chan struct{}
+	entered chan struct{}
+}
+
+func (s *slowUserService) wait() {
+	if s.gate != nil {
+		s.entered <- struct{}{}
+		<-s.gate
+	}
+	s.clock.Advance(s.latency)
+}
+
+func (s *slowUserService) List(ctx context.Context, opts domain.UserListOptions) (*domain.UserPage, error) {
+	s.wait()
+	return &domain.UserPage{Users: []*domain.User{}}, nil
+}
+
+func (s *slowUserService) Create(ctx context.Context, user *domain.User) error {
+	s.wait()
+	user.ID = "u-1"
+	return nil
+}
+
+func newShedHandler(svc *slowUserService, soft, hard int, p99 time.Duration) *Handler {
+	log := logger.New()
+	h := NewHandler(&service.Services{User: svc, Log: log}, log, WithLoadShedding(soft, hard, p99))
+	h.shedder.now = svc.clock.Now
+	return h
+}
+
+func newListUsersRQ() *http.Request {
+	return httptest.NewRequest(http.MethodGet, "/api/v1/users", nil)
+}
+
+func newCreateUserRQ() *http.Request {
+	return httptest.NewRequest(http.MethodPost, "/api/v1/users",
+		strings.NewReader(`{"email": "a@example.com", "password": "secret"}`))
+}
+
+func serveShed(h http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestHandler_shedLoad_InFlight(t *testing.T) {
+	// Arrange: two reads hold the soft limit, the hard limit is four
+	svc := &slowUserService{
+		clock:   &fakeClock{now: time.Now()},
+		gate:    make(chan struct{}),
+		entered: make(chan struct{}),
+	}
+	handler := newShedHandler(svc, 2, 4, 0)
+
+	var wg sync.WaitGroup
+	held := make([]*httptest.ResponseRecorder, 2)
+	for i := range held {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			held[i] = serveShed(handler, newListUsersRQ())
+		}()
+		<-svc.entered
+	}
+	assert.Equal(t, float64(2), testutil.ToFloat64(metrics.HTTPInFlight))
+
+	// Act: a third read is over the soft limit by half of the way to the hard one
+	handler.shedder.random = func() float64 { return 0.4 }
+	shed := serveShed(handler, newListUsersRQ())
+
+	// Assert
+	assert.Equal(t, http.StatusServiceUnavailable, shed.Code)
+	assert.Equal(t, "1", shed.Header().Get("Retry-After"))
+	assert.Contains(t, shed.Body.String(), `"code":"overloaded"`)
+	assert.Equal(t, 0.5, testutil.ToFloat64(metrics.HTTPShedRate))
+
+	// Act: mutations are admitted up to the hard limit
+	created := make(chan *httptest.ResponseRecorder, 2)
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			created <- serveShed(handler, newCreateUserRQ())
+		}()
+		<-svc.entered
+	}
+	rejected := serveShed(handler, newCreateUserRQ())
+
+	// Assert
+	assert.Equal(t, http.StatusServiceUnavailable, rejected.Code)
+	assert.Equal(t, http.StatusOK, serveShed(handler.Internal(), httptest.NewRequest(http.MethodGet, "/readyz", nil)).Code,
+		"probes are never shed")
+
+	// Act: the held requests finish
+	close(svc.gate)
+	wg.Wait()
+	close(created)
+	svc.gate = nil
+
+	// Assert: everything was answered and shedding stopped
+	for _, rr := range held {
+		assert.Equal(t, http.StatusOK, rr.Code)
+	}
+	for rr := range created {
+		assert.Equal(t, http.StatusCreated, rr.Code)
+	}
+	assert.Zero(t, testutil.ToFloat64(metrics.HTTPInFlight))
+	assert.Equal(t, http.StatusOK, serveShed(handler, newListUsersRQ()).Code)
+	assert.Zero(t, testutil.ToFloat64(metrics.HTTPShedRate))
+}
+
+func TestHandler_shedLoad_Latency(t *testing.T) {
+	// Arrange
+	clock := &fakeClock{now: time.Now()}
+	svc := &slowUserService{clock: clock, latency: 5 * time.Millisecond}
+	handler := newShedHandler(svc, 0, 0, 20*time.Millisecond)
+	handler.shedder.random = func() float64 { return 0.5 }
+
+	answered := func(n int, req func() *http.Request) map[int]int {
+		codes := make(map[int]int)
+		for range n {
+			codes[serveShed(handler, req()).Code]++
+			clock.Advance(shedRecompute)
+		}
+		return codes
+	}
+
+	// Act: a fast service is not shed
+	fast := answered(20, newListUsersRQ)
+
+	// Act: the service slows down past the p99 threshold
+	svc.latency = 100 * time.Millisecond
+	slow := answered(20, newListUsersRQ)
+	mutations := answered(5, newCreateUserRQ)
+
+	// Act: the slow requests age out of the window
+	svc.latency = 5 * time.Millisecond
+	clock.Advance(shedWindow)
+	recovered := answered(20, newListUsersRQ)
+
+	// Assert
+	assert.Equal(t, map[int]int{http.StatusOK: 20}, fast)
+	assert.Equal(t, map[int]int{http.StatusOK: 1, http.StatusServiceUnavailable: 19}, slow,
+		"reads are shed once the first slow one is recorded")
+	assert.Equal(t, map[int]int{http.StatusCreated: 5}, mutations)
+	assert.Equal(t, map[int]int{http.StatusOK: 20}, recovered)
+}
+
+func TestHandler_shedLoad_Disabled(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	handler := NewHandler(&service.Services{Log: log}, log, WithLoadShedding(0, 0, 0))
+
+	// Assert
+	require.Nil(t, handler.shedder)
+}
@@ -0,0 +1,33 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// retryAttemptHeader numbers the retries of a request, from 1. Retries are
+// kept out of the SLO so that a failing request retried by its client
+// counts once.
+const retryAttemptHeader = "X-Retry-Attempt"
+
+// sloScope tells how the requests of a route count towards the availability SLO
+type sloScope int
+
+const (
+	// sloEligible requests make up the SLO, those of the public API
+	sloEligible sloScope = iota
+	// sloIneligible requests are classified but kept out of the error
+	// budget, those of admin routes
+	sloIneligible
+	// sloExcluded requests are not classified at all, probes and metrics
+	sloExcluded
+)
+
+// eligible reports whether the request counts towards the SLO
+func (s sloScope) eligible(r *http.Request) bool {
+	if s != sloEligible {
+		return false
+	}
+	attempt, err := strconv.Atoi(r.Header.Get(retryAttemptHeader))
+	return err != nil || attempt <= 0
+}
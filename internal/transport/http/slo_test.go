@@ -0,0 +1,93 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// sloCount returns the SLO request count of the labels
+func sloCount(route string, outcome metrics.Outcome, eligible string) float64 {
+	return testutil.ToFloat64(metrics.SLORequests.WithLabelValues("http", route, string(outcome), eligible))
+}
+
+func TestHandler_SLOOutcome(t *testing.T) {
+	tests := []struct {
+		name         string
+		internal     bool
+		target       string
+		retryAttempt string
+		listErr      error
+		wantRoute    string
+		wantOutcome  metrics.Outcome
+		wantEligible string
+	}{
+		{name: "public success", target: "/api/v1/users", wantRoute: "GET /api/v1/users", wantOutcome: metrics.OutcomeSuccess, wantEligible: "true"},
+		{name: "public client error", target: "/api/v1/users?sort=age", wantRoute: "GET /api/v1/users", wantOutcome: metrics.OutcomeClientError, wantEligible: "true"},
+		{name: "public server error", target: "/api/v1/users", listErr: errors.New("connection reset"), wantRoute: "GET /api/v1/users", wantOutcome: metrics.OutcomeServerError, wantEligible: "true"},
+		{name: "retry", target: "/api/v1/users", retryAttempt: "1", listErr: errors.New("connection reset"), wantRoute: "GET /api/v1/users", wantOutcome: metrics.OutcomeServerError, wantEligible: "false"},
+		{name: "first attempt", target: "/api/v1/users", retryAttempt: "0", wantRoute: "GET /api/v1/users", wantOutcome: metrics.OutcomeSuccess, wantEligible: "true"},
+		{name: "admin route", internal: true, target: "/openapi.json", wantRoute: "GET /openapi.json", wantOutcome: metrics.OutcomeSuccess, wantEligible: "false"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			log := logger.New()
+			mockUserService := new(MockUserService)
+			handler := NewHandler(&service.Services{User: mockUserService, Log: log}, log)
+			mockUserService.On("List", mock.Anything, domain.UserListOptions{}).Return(&domain.UserPage{}, tt.listErr)
+
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.retryAttempt != "" {
+				req.Header.Set(retryAttemptHeader, tt.retryAttempt)
+			}
+			before := sloCount(tt.wantRoute, tt.wantOutcome, tt.wantEligible)
+
+			// Act
+			rr := httptest.NewRecorder()
+			if tt.internal {
+				handler.Internal().ServeHTTP(rr, req)
+			} else {
+				handler.ServeHTTP(rr, req)
+			}
+
+			// Assert
+			assert.Equal(t, before+1, sloCount(tt.wantRoute, tt.wantOutcome, tt.wantEligible))
+		})
+	}
+}
+
+func TestHandler_SLOExcludesProbes(t *testing.T) {
+	// Arrange
+	log := logger.New()
+	handler := NewHandler(&service.Services{Log: log}, log)
+
+	for _, path := range []string{"/readyz", "/metrics"} {
+		route := "GET " + path
+		var before float64
+		for _, outcome := range []metrics.Outcome{metrics.OutcomeSuccess, metrics.OutcomeServerError} {
+			before += sloCount(route, outcome, "true") + sloCount(route, outcome, "false")
+		}
+
+		// Act
+		handler.Internal().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+
+		// Assert
+		var after float64
+		for _, outcome := range []metrics.Outcome{metrics.OutcomeSuccess, metrics.OutcomeServerError} {
+			after += sloCount(route, outcome, "true") + sloCount(route, outcome, "false")
+		}
+		assert.Equal(t, before, after, "%s must not be classified", path)
+	}
+}
@@ -0,0 +1,87 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/timing"
+)
+
+// slowPhase is a phase of a slow request as logged
+type slowPhase struct {
+	Name       string  `json:"name"`
+	Detail     string  `json:"detail,omitempty"`
+	OffsetMs   float64 `json:"offset_ms"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// slowTotal is the combined time of same-named phases as logged
+type slowTotal struct {
+	Count      int     `json:"count"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// WithSlowRequestThreshold logs the phase breakdown of requests taking at
+// least threshold, 0 disables tracing
+func WithSlowRequestThreshold(threshold time.Duration) HandlerOption {
+	return func(h *Handler) {
+		h.slowRequestThreshold = threshold
+	}
+}
+
+// Middleware collecting phase timings and logging them for slow requests.
+// Requests are only traced when a threshold is set. The collector of a
+// request emitting a wide event is reused, it times the handler already.
+func (h *Handler) traceSlow(next http.HandlerFunc) http.HandlerFunc {
+	if h.slowRequestThreshold <= 0 {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		collector := timing.FromContext(r.Context())
+		if collector != nil {
+			next(w, r)
+		} else {
+			var ctx context.Context
+			ctx, collector = timing.WithCollector(r.Context())
+
+			span := timing.Start(ctx, "handler", "")
+			next(w, r.WithContext(ctx))
+			span.End()
+		}
+
+		if elapsed := collector.Elapsed(); elapsed >= h.slowRequestThreshold {
+			h.logSlowRequest(r, elapsed, collector)
+		}
+	}
+}
+
+func (h *Handler) logSlowRequest(r *http.Request, elapsed time.Duration, collector *timing.Collector) {
+	recorded, dropped := collector.Phases()
+	phases := make([]slowPhase, len(recorded))
+	for i, p := range recorded {
+		phases[i] = slowPhase{
+			Name:       p.Name,
+			Detail:     p.Detail,
+			OffsetMs:   timing.Milliseconds(p.Offset),
+			DurationMs: timing.Milliseconds(p.Duration),
+		}
+	}
+
+	totals := make(map[string]slowTotal)
+	for name, t := range collector.Totals() {
+		totals[name] = slowTotal{Count: t.Count, DurationMs: timing.Milliseconds(t.Duration)}
+	}
+
+	h.log.Warn("Slow request", map[string]interface{}{
+		"method":         r.Method,
+		"route":          r.Pattern,
+		"path":           r.URL.Path,
+		"duration_ms":    elapsed.Milliseconds(),
+		"threshold_ms":   h.slowRequestThreshold.Milliseconds(),
+		"phases":         phases,
+		"phase_totals":   totals,
+		"phases_dropped": dropped,
+	})
+}
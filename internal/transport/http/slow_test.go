@@ -0,0 +1,139 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/repository"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// sleepyQuerier answers every statement after a delay
+type sleepyQuerier struct {
+	delay time.Duration
+}
+
+func (q sleepyQuerier) GetContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	time.Sleep(q.delay)
+	return nil
+}
+
+func (q sleepyQuerier) SelectContext(ctx context.Context, dest interface{}, query string, args ...interface{}) error {
+	time.Sleep(q.delay)
+	return nil
+}
+
+func (q sleepyQuerier) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	time.Sleep(q.delay)
+	return nil, nil
+}
+
+// slowRequestEntries returns the "Slow request" log entries
+func slowRequestEntries(t *testing.T, logs *bytes.Buffer) []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &entry))
+		if entry["message"] == "Slow request" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+func setupSlowHandler(threshold time.Duration, delay time.Duration) (*Handler, *bytes.Buffer) {
+	var logs bytes.Buffer
+	log := logger.New(logger.WithOutput(&logs))
+
+	q := repository.NewInstrumentedQuerier(sleepyQuerier{delay: delay})
+	mockUserService := new(MockUserService)
+	mockUserService.On("GetByID", mock.Anything, "user-1").Run(func(args mock.Arguments) {
+		ctx := args.Get(0).(context.Context)
+		var user domain.User
+		q.GetContext(ctx, &user, "SELECT id, email\n\t\tFROM users WHERE id = $1", "user-1")
+		q.ExecContext(ctx, "UPDATE users SET seen_at = now() WHERE id = $1", "user-1")
+	}).Return(&domain.User{ID: "user-1"}, nil)
+
+	handler := NewHandler(&service.Services{User: mockUserService, Log: log}, log,
+		WithSlowRequestThreshold(threshold))
+	return handler, &logs
+}
+
+func TestHandler_SlowRequestDump(t *testing.T) {
+	// Arrange
+	handler, logs := setupSlowHandler(20*time.Millisecond, 15*time.Millisecond)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/users/user-1", nil))
+
+	// Assert
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	entries := slowRequestEntries(t, logs)
+	require.Len(t, entries, 1)
+	entry := entries[0]
+	assert.Equal(t, "GET /api/v1/users/{id}", entry["route"])
+	assert.Equal(t, 20.0, entry["threshold_ms"])
+	assert.GreaterOrEqual(t, entry["duration_ms"], 30.0)
+
+	phases := entry["phases"].([]interface{})
+	require.Len(t, phases, 3)
+	names := make([]string, len(phases))
+	for i, p := range phases {
+		names[i] = p.(map[string]interface{})["name"].(string)
+	}
+	// Phases are listed as they end, so the handler comes last
+	assert.Equal(t, []string{"db", "db", "handler"}, names)
+
+	first := phases[0].(map[string]interface{})
+	assert.Equal(t, "SELECT id, email FROM users WHERE id = $1", first["detail"])
+	assert.GreaterOrEqual(t, first["duration_ms"], 15.0)
+
+	second := phases[1].(map[string]interface{})
+	assert.GreaterOrEqual(t, second["offset_ms"], first["duration_ms"], "the second statement starts after the first")
+
+	totals := entry["phase_totals"].(map[string]interface{})
+	db := totals["db"].(map[string]interface{})
+	assert.Equal(t, 2.0, db["count"])
+	assert.GreaterOrEqual(t, db["duration_ms"], 30.0)
+}
+
+func TestHandler_FastRequestNotDumped(t *testing.T) {
+	// Arrange
+	handler, logs := setupSlowHandler(time.Second, 0)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/users/user-1", nil))
+
+	// Assert
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, slowRequestEntries(t, logs))
+}
+
+func TestHandler_SlowRequestTracingDisabled(t *testing.T) {
+	// Arrange
+	handler, logs := setupSlowHandler(0, 5*time.Millisecond)
+	rr := httptest.NewRecorder()
+
+	// Act
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/users/user-1", nil))
+
+	// Assert
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Empty(t, slowRequestEntries(t, logs))
+}
@@ -0,0 +1,113 @@
+package http
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	mediaTypeNDJSON = "application/x-ndjson"
+	mediaTypeCSV    = "text/csv; charset=utf-8"
+
+	// streamCompleteTrailer is the trailer sent after the last record of a
+	// complete stream, with the number of records
+	streamCompleteTrailer = "X-Stream-Complete"
+	// streamFlushEvery is the number of records written between flushes
+	streamFlushEvery = 100
+)
+
+// streamEnd is the last line of a complete NDJSON stream
+type streamEnd struct {
+	Complete bool `json:"complete"`
+	Count    int  `json:"count"`
+}
+
+// recordStream writes a response record by record, flushing them as it
+// goes. Since the status is sent with the first records, a failure cannot
+// be answered with an error anymore: a stream is only known to be complete
+// when it ends with its marker, written by Close. NDJSON streams end with a
+// {"complete":true,"count":N} line and both formats with the
+// X-Stream-Complete trailer, so clients can tell a truncated stream from a
+// complete one. A handler failing midway must not call Close, and should
+// abort the response with panic(http.ErrAbortHandler), see recoverPanic.
+type recordStream struct {
+	w     http.ResponseWriter
+	rc    *http.ResponseController
+	enc   *json.Encoder
+	csv   *csv.Writer
+	count int
+}
+
+// newNDJSONStream starts an NDJSON stream, one JSON value per line
+func newNDJSONStream(w http.ResponseWriter) *recordStream {
+	s := startStream(w, mediaTypeNDJSON)
+	s.enc = json.NewEncoder(w)
+	return s
+}
+
+// newCSVStream starts a CSV stream whose first row is header
+func newCSVStream(w http.ResponseWriter, header []string) (*recordStream, error) {
+	s := startStream(w, mediaTypeCSV)
+	s.csv = csv.NewWriter(w)
+	if err := s.csv.Write(header); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func startStream(w http.ResponseWriter, contentType string) *recordStream {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Trailer", streamCompleteTrailer)
+	w.WriteHeader(http.StatusOK)
+	return &recordStream{w: w, rc: http.NewResponseController(w)}
+}
+
+// Write writes a record, a JSON value for NDJSON streams and a []string
+// row for CSV ones
+func (s *recordStream) Write(record interface{}) error {
+	var err error
+	if s.csv != nil {
+		err = s.csv.Write(record.([]string))
+	} else {
+		err = s.enc.Encode(record)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.count++
+	if s.count%streamFlushEvery == 0 {
+		return s.flush()
+	}
+	return nil
+}
+
+// Close marks the stream complete, once every record was written
+func (s *recordStream) Close() error {
+	if s.csv == nil {
+		if err := s.enc.Encode(streamEnd{Complete: true, Count: s.count}); err != nil {
+			return err
+		}
+	}
+	if err := s.flush(); err != nil {
+		return err
+	}
+	s.w.Header().Set(streamCompleteTrailer, strconv.Itoa(s.count))
+	return nil
+}
+
+func (s *recordStream) flush() error {
+	if s.csv != nil {
+		s.csv.Flush()
+		if err := s.csv.Error(); err != nil {
+			return err
+		}
+	}
+	if err := s.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return err
+	}
+	return nil
+}
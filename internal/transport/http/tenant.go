@@ -0,0 +1,27 @@
+package http
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+)
+
+// Middleware scoping the request to the tenant named by X-Tenant-ID. Events
+// produced by the request carry the tenant and are routed by it.
+func (h *Handler) tenantScope(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(tenant.Header)
+		if name == "" {
+			next(w, r)
+			return
+		}
+		if !tenant.Valid(name) {
+			h.respondError(w, r, fmt.Errorf("%w: invalid %s", domain.ErrInvalidInput, tenant.Header))
+			return
+		}
+
+		next(w, r.WithContext(tenant.WithTenant(r.Context(), name)))
+	}
+}
@@ -0,0 +1,52 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+func TestHandler_tenantScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantTenant string
+	}{
+		{name: "tenant", header: "acme", wantStatus: http.StatusOK, wantTenant: "acme"},
+		{name: "no tenant", wantStatus: http.StatusOK},
+		{name: "too long", header: strings.Repeat("a", 256), wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			log := logger.New()
+			h := NewHandler(&service.Services{Log: log}, log)
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/hooks/github", nil)
+			if tt.header != "" {
+				req.Header.Set(tenant.Header, tt.header)
+			}
+			rec := httptest.NewRecorder()
+
+			var got string
+			next := func(w http.ResponseWriter, r *http.Request) {
+				got = tenant.FromContext(r.Context())
+			}
+
+			// Act
+			h.tenantScope(next)(rec, req)
+
+			// Assert
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantTenant, got)
+		})
+	}
+}
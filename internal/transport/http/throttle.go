@@ -0,0 +1,37 @@
+package http
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// throttleOverrideHeader carries the admin token lifting the user mutation throttle
+const throttleOverrideHeader = "X-Admin-Override"
+
+// WithThrottleOverrideToken lets requests sending token in X-Admin-Override
+// change users past the mutation throttle. An empty token disables the override.
+func WithThrottleOverrideToken(token string) HandlerOption {
+	return func(h *Handler) {
+		h.throttleOverrideToken = token
+	}
+}
+
+// mutationContext returns the context of a user mutation, which bypasses the
+// throttle when the request carries the admin override token
+func (h *Handler) mutationContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	got := r.Header.Get(throttleOverrideHeader)
+	if h.throttleOverrideToken == "" || got == "" {
+		return ctx
+	}
+	if subtle.ConstantTimeCompare([]byte(got), []byte(h.throttleOverrideToken)) != 1 {
+		h.log.Warn("Ignoring invalid admin override", map[string]interface{}{"path": r.URL.Path})
+		return ctx
+	}
+
+	h.log.Info("Admin override lifts the mutation throttle", map[string]interface{}{"path": r.URL.Path})
+	return service.BypassThrottle(ctx)
+}
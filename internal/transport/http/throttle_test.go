@@ -0,0 +1,44 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+func TestHandler_mutationContext(t *testing.T) {
+	tests := []struct {
+		name       string
+		token      string
+		header     string
+		wantBypass bool
+	}{
+		{name: "matching token", token: "s3cret", header: "s3cret", wantBypass: true},
+		{name: "wrong token", token: "s3cret", header: "guess"},
+		{name: "no header", token: "s3cret"},
+		{name: "override disabled", header: "s3cret"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			log := logger.New()
+			h := NewHandler(&service.Services{Log: log}, log, WithThrottleOverrideToken(tt.token))
+			req := httptest.NewRequest(http.MethodPatch, "/api/v1/users/u-1", nil)
+			if tt.header != "" {
+				req.Header.Set(throttleOverrideHeader, tt.header)
+			}
+
+			// Act
+			ctx := h.mutationContext(req)
+
+			// Assert
+			assert.Equal(t, tt.wantBypass, service.ThrottleBypassed(ctx))
+		})
+	}
+}
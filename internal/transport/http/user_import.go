@@ -0,0 +1,39 @@
+package http
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+)
+
+// importFormats maps the media types of import uploads to their format
+var importFormats = map[string]string{
+	"text/csv":             domain.ImportCSV,
+	"application/x-ndjson": domain.ImportNDJSON,
+}
+
+// importUsers streams the uploaded file to the staging directory and answers
+// with the job importing it, before any row is read. Its progress is served
+// by the job routes.
+func (h *Handler) importUsers(w http.ResponseWriter, r *http.Request) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	format, ok := importFormats[mediaType]
+	if !ok {
+		h.log.Warn("Rejected import media type", map[string]interface{}{"media_type": mediaType})
+		h.respondError(w, r, fmt.Errorf("%w: %q", errmap.ErrUnsupportedMediaType, mediaType))
+		return
+	}
+
+	job, err := h.services.Import.Stage(r.Context(), format, r.Body)
+	if err != nil {
+		h.logError(r, "Failed to stage user import", err, map[string]interface{}{"format": format})
+		h.respondError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/jobs/"+job.ID)
+	h.respondJSON(w, http.StatusAccepted, job)
+}
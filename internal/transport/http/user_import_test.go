@@ -0,0 +1,105 @@
+package http
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// stubImportService stages files of up to 16 bytes and records the last one
+type stubImportService struct {
+	service.UserImportServiceInterface
+	format  string
+	content string
+}
+
+func (s *stubImportService) Stage(ctx context.Context, format string, r io.Reader) (*domain.Job, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > 16 {
+		return nil, domain.ErrImportTooLarge
+	}
+	s.format, s.content = format, string(content)
+	return &domain.Job{ID: "job-1", Type: domain.JobUsersImport, Status: domain.JobPending}, nil
+}
+
+func TestHandler_importUsers(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		body         string
+		wantStatus   int
+		wantBody     string
+		wantLocation string
+		wantFormat   string
+	}{
+		{
+			name:         "csv",
+			contentType:  "text/csv; charset=utf-8",
+			body:         "email,password\n",
+			wantStatus:   http.StatusAccepted,
+			wantBody:     `"id":"job-1"`,
+			wantLocation: "/api/v1/jobs/job-1",
+			wantFormat:   domain.ImportCSV,
+		},
+		{
+			name:         "ndjson",
+			contentType:  "application/x-ndjson",
+			body:         `{"email":"a"}` + "\n",
+			wantStatus:   http.StatusAccepted,
+			wantBody:     `"type":"users.import"`,
+			wantLocation: "/api/v1/jobs/job-1",
+			wantFormat:   domain.ImportNDJSON,
+		},
+		{
+			name:        "unsupported media type",
+			contentType: "application/json",
+			body:        `[]`,
+			wantStatus:  http.StatusUnsupportedMediaType,
+			wantBody:    `"code":"unsupported_media_type"`,
+		},
+		{
+			name:        "too large",
+			contentType: "text/csv",
+			body:        "email,password\nada@example.com,secret\n",
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantBody:    `"code":"import_too_large"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			imports := &stubImportService{}
+			log := logger.New()
+			handler := NewHandler(&service.Services{Import: imports, Log: log}, log)
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/admin/users/import", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			// Act
+			handler.Internal().ServeHTTP(rr, req)
+
+			// Assert
+			require.Equal(t, tt.wantStatus, rr.Code, rr.Body.String())
+			assert.Contains(t, rr.Body.String(), tt.wantBody)
+			assert.Equal(t, tt.wantLocation, rr.Header().Get("Location"))
+			assert.Equal(t, tt.wantFormat, imports.format)
+			if tt.wantFormat != "" {
+				assert.Equal(t, tt.body, imports.content)
+			}
+		})
+	}
+}
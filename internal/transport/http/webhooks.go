@@ -0,0 +1,49 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+)
+
+// maxWebhookBody bounds the size of inbound webhook payloads
+const maxWebhookBody = 1 << 20
+
+// receiveWebhook verifies a provider's webhook and queues it for the worker,
+// answering 202 without waiting for it to be processed
+func (h *Handler) receiveWebhook(w http.ResponseWriter, r *http.Request) {
+	provider := pathValueFunc(r, "provider")
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+	if err != nil {
+		h.logError(r, "Failed to read webhook body", err, map[string]interface{}{"provider": provider})
+		h.respondError(w, r, fmt.Errorf("%w: %v", domain.ErrInvalidInput, err))
+		return
+	}
+
+	err = h.services.Webhook.Receive(r.Context(), &domain.WebhookRequest{
+		Provider:   provider,
+		Header:     r.Header.Get,
+		Body:       body,
+		RemoteAddr: r.RemoteAddr,
+		ReceivedAt: time.Now(),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, domain.ErrWebhookProviderNotFound):
+			h.log.Warn("Unknown webhook provider", map[string]interface{}{"provider": provider})
+		case errors.Is(err, domain.ErrWebhookSignatureInvalid), errors.Is(err, domain.ErrWebhookStale), errors.Is(err, domain.ErrWebhookReplayed):
+			// Logged and audited by the service
+		default:
+			h.logError(r, "Failed to accept webhook", err, map[string]interface{}{"provider": provider})
+		}
+		h.respondError(w, r, err)
+		return
+	}
+
+	h.respondJSON(w, http.StatusAccepted, nil)
+}
@@ -0,0 +1,99 @@
+package http
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+type recordingPublisher struct {
+	events []*domain.OutboxEvent
+}
+
+func (p *recordingPublisher) Publish(ctx context.Context, event *domain.OutboxEvent) error {
+	p.events = append(p.events, event)
+	return nil
+}
+
+// recordingAuditRepository keeps created entries; other methods are not used
+type recordingAuditRepository struct {
+	domain.AuditRepository
+	entries []*domain.AuditEntry
+}
+
+func (r *recordingAuditRepository) Create(ctx context.Context, entry *domain.AuditEntry) error {
+	r.entries = append(r.entries, entry)
+	return nil
+}
+
+func newWebhookRequest(provider, secret string, signedAt time.Time, body string) *http.Request {
+	timestamp := strconv.FormatInt(signedAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "." + body))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/hooks/"+provider, strings.NewReader(body))
+	req.Header.Set("Stripe-Timestamp", timestamp)
+	req.Header.Set("Stripe-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return req
+}
+
+func TestHandler_receiveWebhook(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		req        *http.Request
+		wantStatus int
+		published  int
+		audited    int
+	}{
+		{"accepted", newWebhookRequest("stripe", "whsec", now, `{"id":"evt_1"}`), http.StatusAccepted, 1, 0},
+		{"bad signature", newWebhookRequest("stripe", "guess", now, `{"id":"evt_1"}`), http.StatusUnauthorized, 0, 1},
+		{"replayed", newWebhookRequest("stripe", "whsec", now.Add(-time.Hour), `{"id":"evt_1"}`), http.StatusUnauthorized, 0, 1},
+		{"unknown provider", newWebhookRequest("acme", "whsec", now, `{"id":"evt_1"}`), http.StatusNotFound, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			log := logger.New()
+			publisher := &recordingPublisher{}
+			audit := &recordingAuditRepository{}
+			providers := []domain.WebhookProvider{{
+				Name:            "stripe",
+				Secret:          "whsec",
+				SignatureHeader: "Stripe-Signature",
+				TimestampHeader: "Stripe-Timestamp",
+			}}
+			webhooks := service.NewWebhookService(providers, 5*time.Minute, publisher, nil, audit, log)
+			handler := NewHandler(&service.Services{Webhook: webhooks, Log: log}, log)
+			rr := httptest.NewRecorder()
+
+			// Act
+			handler.ServeHTTP(rr, tt.req)
+
+			// Assert
+			assert.Equal(t, tt.wantStatus, rr.Code)
+			require.Len(t, publisher.events, tt.published)
+			if tt.published > 0 {
+				assert.Equal(t, "webhook.stripe", publisher.events[0].Type)
+				assert.JSONEq(t, `{"id":"evt_1"}`, string(publisher.events[0].Payload))
+			}
+			assert.Len(t, audit.entries, tt.audited)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package http
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/transport/http/filter"
+)
+
+// userWhere is the whitelist of the fields and operators the where
+// parameter of GET /api/v1/users may use
+var userWhere = filter.Schema{
+	"id":           {Ops: []filter.Op{filter.Eq, filter.Ne}, Value: canonicalUUID},
+	"email":        {Ops: []filter.Op{filter.Eq, filter.Ne, filter.Prefix}},
+	"email_domain": {Ops: []filter.Op{filter.Eq, filter.Ne}, Value: lowerDomain},
+	"name":         {Ops: []filter.Op{filter.Eq, filter.Ne, filter.Prefix}},
+	"created_at":   {Ops: []filter.Op{filter.Lt, filter.Lte, filter.Gt, filter.Gte}, Value: filter.Time},
+	"updated_at":   {Ops: []filter.Op{filter.Lt, filter.Lte, filter.Gt, filter.Gte}, Value: filter.Time},
+}
+
+func lowerDomain(raw string) (interface{}, error) {
+	return strings.ToLower(strings.TrimPrefix(raw, "@")), nil
+}
+
+// canonicalUUID rejects IDs the uuid column would fail on, and compares the
+// others in the form they are stored in
+func canonicalUUID(raw string) (interface{}, error) {
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("must be a UUID")
+	}
+	return id.String(), nil
+}
+
+// parseUserWhere parses the where parameter of a user listing, nil when it
+// is empty. Errors give the position of the offending character.
+func parseUserWhere(raw string) (domain.UserCondition, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	expr, err := filter.Parse(raw)
+	if err == nil {
+		err = userWhere.Check(expr)
+	}
+	if err != nil {
+		return nil, newDecodeError("where: %v", err)
+	}
+
+	return userCondition(expr), nil
+}
+
+// userCondition converts an expression checked against userWhere
+func userCondition(e filter.Expr) domain.UserCondition {
+	switch e := e.(type) {
+	case *filter.Not:
+		return &domain.UserNot{X: userCondition(e.X)}
+	case *filter.And:
+		return &domain.UserAnd{Left: userCondition(e.Left), Right: userCondition(e.Right)}
+	case *filter.Or:
+		return &domain.UserOr{Left: userCondition(e.Left), Right: userCondition(e.Right)}
+	}
+
+	t := e.(*filter.Term)
+	value, _ := userWhere.Value(t)
+	return &domain.UserTerm{Field: domain.UserField(t.Field), Op: domain.Comparison(t.Op), Value: value}
+}
@@ -0,0 +1,83 @@
+package http
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+	"github.com/romanitalian/carch-go/internal/pkg/timing"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
+	"github.com/romanitalian/carch-go/internal/transport/errmap"
+)
+
+// WithWideEvents emits a wide event for every sampled request, nil disables them
+func WithWideEvents(emitter *wideevent.Emitter) HandlerOption {
+	return func(h *Handler) {
+		h.wideEvents = emitter
+	}
+}
+
+// countingBody counts the bytes read from a request body
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// Middleware emitting the wide event of sampled requests. The phase timings
+// it collects are shared with the slow request log.
+func (h *Handler) emitWideEvent(next http.HandlerFunc) http.HandlerFunc {
+	if h.wideEvents == nil {
+		return next
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.wideEvents.Sampled() {
+			next(w, r)
+			return
+		}
+
+		start := time.Now()
+		ctx, record := wideevent.WithRecord(r.Context())
+		ctx, collector := timing.WithCollector(ctx)
+		r = r.WithContext(ctx)
+		body := &countingBody{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		rw := newResponseWriter(w)
+
+		span := timing.Start(ctx, "handler", "")
+		next(rw, r)
+		span.End()
+
+		name := r.Header.Get(tenant.Header)
+		if !tenant.Valid(name) {
+			name = ""
+		}
+		event := wideevent.Event{
+			Time:      start,
+			Transport: "http",
+			Method:    r.Method,
+			Route:     r.Pattern,
+			Status:    rw.statusCode,
+			Outcome:   string(errmap.HTTPOutcome(rw.statusCode)),
+			Duration:  time.Since(start),
+			Phases:    collector.Totals(),
+			User:      h.principalOf(r).ID,
+			Tenant:    name,
+			BytesIn:   body.n,
+			BytesOut:  rw.bytes,
+			Record:    record,
+		}
+		if err := h.wideEvents.Emit(event); err != nil {
+			h.log.Error("Failed to emit wide event", err, map[string]interface{}{"route": r.Pattern})
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+	"github.com/romanitalian/carch-go/internal/pkg/wideevent"
+	"github.com/romanitalian/carch-go/internal/repository"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// setupWideEventHandler serves users from a mocked database behind the user
+// cache and returns the wide events it emits
+func setupWideEventHandler(t *testing.T, opts ...wideevent.Option) (*Handler, sqlmock.Sqlmock, *bytes.Buffer) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	log := logger.New(logger.WithOutput(&bytes.Buffer{}))
+	repo := repository.NewCachedUserRepository(
+		repository.NewUserRepository(repository.NewInstrumentedQuerier(sqlx.NewDb(db, "sqlmock"))),
+		cache.NewMemory(), time.Minute, log)
+	var events bytes.Buffer
+	handler := NewHandler(&service.Services{User: service.NewUserService(repo, log), Log: log}, log,
+		WithWideEvents(wideevent.NewEmitter(&events, opts...)),
+		WithSlowRequestThreshold(time.Hour))
+
+	return handler, mock, &events
+}
+
+// wideEvents decodes the emitted events
+func wideEvents(t *testing.T, events *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var decoded []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(events.String()), "\n") {
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		decoded = append(decoded, event)
+	}
+	return decoded
+}
+
+func TestHandler_WideEvent(t *testing.T) {
+	// Arrange
+	handler, mock, events := setupWideEventHandler(t)
+	mock.ExpectExec(`users.delete`).WillReturnResult(sqlmock.NewResult(0, 1))
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/users/user-1", nil)
+	req.Header.Set(tenant.Header, "acme")
+	req.RemoteAddr = "203.0.113.7:4711"
+
+	// Act
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// Assert
+	require.NoError(t, mock.ExpectationsWereMet())
+	emitted := wideEvents(t, events)
+	require.Len(t, emitted, 1)
+	event := emitted[0]
+
+	keys := make([]string, 0, len(event))
+	for key := range event {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, wideevent.Fields, keys)
+
+	assert.Equal(t, "http", event["transport"])
+	assert.Equal(t, "DELETE", event["method"])
+	assert.Equal(t, "DELETE /api/v1/users/{id}", event["route"])
+	assert.Equal(t, float64(http.StatusNoContent), event["status"])
+	assert.Equal(t, "success", event["outcome"])
+	assert.Equal(t, "client:203.0.113.7", event["user"])
+	assert.Equal(t, "acme", event["tenant"])
+	assert.Equal(t, float64(1), event["db_statements"])
+	assert.Equal(t, float64(1), event["events_published"])
+	assert.Equal(t, float64(0), event["cache_hits"])
+	assert.Equal(t, float64(1), event["sample_rate"])
+	_, err := time.Parse(time.RFC3339Nano, event["time"].(string))
+	assert.NoError(t, err)
+
+	phases := event["phases"].(map[string]interface{})
+	assert.Equal(t, float64(1), phases["handler"].(map[string]interface{})["count"], "the slow request trace shares the collector")
+	assert.Equal(t, float64(1), phases["db"].(map[string]interface{})["count"])
+}
+
+func TestHandler_WideEvent_CacheAndBytes(t *testing.T) {
+	// Arrange
+	handler, mock, events := setupWideEventHandler(t, wideevent.WithFields("route", "bytes_out", wideevent.CacheHits, wideevent.CacheMisses, wideevent.DBStatements))
+	mock.ExpectQuery(`users.get_by_id`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "email", "name"}).AddRow("user-1", "user1@example.com", "User"))
+
+	// Act: the second lookup is served by the cache
+	var sizes []int
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/api/v1/users/user-1", nil))
+		require.Equal(t, http.StatusOK, rr.Code)
+		sizes = append(sizes, rr.Body.Len())
+	}
+
+	// Assert
+	require.NoError(t, mock.ExpectationsWereMet())
+	emitted := wideEvents(t, events)
+	require.Len(t, emitted, 2)
+	assert.Equal(t, map[string]interface{}{
+		"route": "GET /api/v1/users/{id}", "bytes_out": float64(sizes[0]),
+		"cache_hits": float64(0), "cache_misses": float64(1), "db_statements": float64(1),
+	}, emitted[0])
+	assert.Equal(t, map[string]interface{}{
+		"route": "GET /api/v1/users/{id}", "bytes_out": float64(sizes[1]),
+		"cache_hits": float64(1), "cache_misses": float64(0), "db_statements": float64(0),
+	}, emitted[1])
+}
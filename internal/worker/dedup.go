@@ -0,0 +1,198 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// ForceHeader is the message header that makes a deduplicated handler
+// process a message even though a message with the same business key was
+// processed within the window. It is true as a boolean or as "true".
+const ForceHeader = "x-force-processing"
+
+// dedupKeyPrefix namespaces the keys of processed messages in the store
+const dedupKeyPrefix = "worker:processed:"
+
+// dedup is the content-based deduplication of a lane
+type dedup struct {
+	window time.Duration
+	fields []string
+}
+
+// WithDedup suppresses messages whose business key was already processed
+// within window. The key is a hash over the given fields of the JSON body,
+// dot-separated for nested objects, such as "order.id". Unlike the message
+// ID it also matches an upstream sending the same event twice. Messages
+// missing one of the fields, or whose body is not JSON, are always handled.
+func WithDedup(window time.Duration, fields ...string) HandlerOption {
+	return func(l *lane) {
+		if window > 0 && len(fields) > 0 {
+			l.dedup = &dedup{window: window, fields: fields}
+		}
+	}
+}
+
+// WithDedupStore sets the store of processed messages that WithDedup
+// checks. Defaults to an in-process cache, which only sees the messages of
+// its own worker, see repository.RedisCache for a shared one.
+func WithDedupStore(store cache.Adder) DispatcherOption {
+	return func(d *Dispatcher) {
+		if store != nil {
+			d.processed = store
+		}
+	}
+}
+
+// businessKey returns the store key of the message's business key, and
+// false when the message cannot be deduplicated
+func (dd *dedup) businessKey(msg messaging.Delivery) (string, bool) {
+	dec := json.NewDecoder(bytes.NewReader(msg.Body))
+	dec.UseNumber()
+	var payload interface{}
+	if err := dec.Decode(&payload); err != nil {
+		return "", false
+	}
+
+	h := sha256.New()
+	h.Write([]byte(msg.Type))
+	for _, field := range dd.fields {
+		value, ok := lookupField(payload, field)
+		if !ok {
+			return "", false
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+		h.Write([]byte{0})
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+		h.Write(encoded)
+	}
+
+	return dedupKeyPrefix + msg.Type + ":" + hex.EncodeToString(h.Sum(nil)), true
+}
+
+// lookupField returns the value at the dot-separated path of a decoded JSON
+// document. A null value counts as missing.
+func lookupField(payload interface{}, path string) (interface{}, bool) {
+	value := payload
+	for _, name := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = obj[name]; !ok || value == nil {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// forced reports whether the message asks to bypass deduplication
+func forced(msg messaging.Delivery) bool {
+	switch v := msg.Headers[ForceHeader].(type) {
+	case bool:
+		return v
+	case string:
+		return strings.EqualFold(v, "true")
+	}
+	return false
+}
+
+// Values of the business keys in the store of processed messages
+var (
+	dedupInFlight = []byte("in-flight")
+	dedupDone     = []byte("done")
+)
+
+// claim is the outcome of reserving the business key of a message
+type claim int
+
+const (
+	// claimed reserved the key for the handler
+	claimed claim = iota
+	// claimDuplicate found the key processed within the window
+	claimDuplicate
+	// claimInFlight found the key reserved by a handler still running
+	claimInFlight
+	// claimUnchecked could not reach the store
+	claimUnchecked
+)
+
+// reserve claims the business key of a message until the lane's timeout.
+// The store adds the key atomically, so of the workers sharing it only one
+// handles the key. The reservation is released when the handler fails and
+// extended to the window when it succeeds, see release and markProcessed,
+// and it expires with the timeout when the worker dies meanwhile. The
+// store failing lets the message through, handlers must stay idempotent on
+// the message ID anyway.
+func (d *Dispatcher) reserve(ctx context.Context, l *lane, key string, fields map[string]interface{}) claim {
+	for {
+		ok, err := d.processed.Add(ctx, key, dedupInFlight, l.timeout)
+		if err != nil {
+			return d.unchecked(err, fields)
+		}
+		if ok {
+			return claimed
+		}
+
+		value, found, err := d.processed.Get(ctx, key)
+		if err != nil {
+			return d.unchecked(err, fields)
+		}
+		if !found {
+			// The key expired in between
+			continue
+		}
+		if bytes.Equal(value, dedupInFlight) {
+			d.log.Info("Message with the same business key is in flight, requeueing", fields)
+			return claimInFlight
+		}
+		metrics.WorkerDuplicates.WithLabelValues(l.label()).Inc()
+		d.log.Info("Duplicate message suppressed", fields)
+		return claimDuplicate
+	}
+}
+
+func (d *Dispatcher) unchecked(err error, fields map[string]interface{}) claim {
+	d.log.Warn("Failed to check for a duplicate message, handling it", map[string]interface{}{
+		"type":       fields["type"],
+		"message_id": fields["message_id"],
+		"error":      err.Error(),
+	})
+	return claimUnchecked
+}
+
+// markProcessed records the business key of a handled message for the
+// lane's window
+func (d *Dispatcher) markProcessed(ctx context.Context, l *lane, key string, fields map[string]interface{}) {
+	if err := d.processed.Set(ctx, key, dedupDone, l.dedup.window); err != nil {
+		d.log.Warn("Failed to record processed message", map[string]interface{}{
+			"type":       fields["type"],
+			"message_id": fields["message_id"],
+			"error":      err.Error(),
+		})
+	}
+}
+
+// release drops the reservation of a message that was not handled, so that
+// its retries are
+func (d *Dispatcher) release(ctx context.Context, key string, fields map[string]interface{}) {
+	if err := d.processed.Delete(context.WithoutCancel(ctx), key); err != nil {
+		d.log.Warn("Failed to release the business key of a failed message", map[string]interface{}{
+			"type":       fields["type"],
+			"message_id": fields["message_id"],
+			"error":      err.Error(),
+		})
+	}
+}
@@ -0,0 +1,269 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// clockStore is a processed-messages store whose time the test advances
+type clockStore struct {
+	mu      sync.Mutex
+	now     time.Time
+	expires map[string]time.Time
+	values  map[string][]byte
+}
+
+func newClockStore() *clockStore {
+	return &clockStore{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC), expires: map[string]time.Time{}, values: map[string][]byte{}}
+}
+
+func (s *clockStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt, ok := s.expires[key]
+	if !ok || !s.now.Before(expiresAt) {
+		return nil, false, nil
+	}
+	return s.values[key], true, nil
+}
+
+func (s *clockStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expires[key] = s.now.Add(ttl)
+	s.values[key] = value
+	return nil
+}
+
+func (s *clockStore) Add(ctx context.Context, key string, value []byte, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if expiresAt, ok := s.expires[key]; ok && s.now.Before(expiresAt) {
+		return false, nil
+	}
+	s.expires[key] = s.now.Add(ttl)
+	s.values[key] = value
+	return true, nil
+}
+
+func (s *clockStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.expires, key)
+	delete(s.values, key)
+	return nil
+}
+
+func (s *clockStore) advance(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = s.now.Add(d)
+}
+
+func paymentDelivery(ack messaging.Acknowledger, tag uint64, body string) messaging.Delivery {
+	msg := delivery(ack, tag, "payment.captured")
+	msg.Body = []byte(body)
+	return msg
+}
+
+// startDedupDispatcher dispatches payment.captured messages deduplicated on
+// the payment and amount, counting the handled ones
+func startDedupDispatcher(t *testing.T, store *clockStore) (chan<- messaging.Delivery, *atomic.Int32) {
+	t.Helper()
+
+	var handled atomic.Int32
+	d := NewDispatcher(logger.New(), WithDedupStore(store))
+	d.Handle("payment.captured", func(ctx context.Context, msg messaging.Delivery) error {
+		handled.Add(1)
+		return nil
+	}, WithConcurrency(1), WithDedup(time.Hour, "payment.id", "amount"))
+
+	return startDispatcher(t, d), &handled
+}
+
+func TestDispatcher_DedupSuppressesDuplicates(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	deliveries, handled := startDedupDispatcher(t, newClockStore())
+	suppressed := testutil.ToFloat64(metrics.WorkerDuplicates.WithLabelValues("payment.captured"))
+
+	// Act
+	// The second message is the first one resent with another message ID and
+	// reordered fields, the third differs in a key field
+	deliveries <- paymentDelivery(ack, 1, `{"payment": {"id": "p-1"}, "amount": 100, "sent_at": "12:00"}`)
+	first := ack.next(t)
+	deliveries <- paymentDelivery(ack, 2, `{"amount": 100, "sent_at": "12:01", "payment": {"id": "p-1"}}`)
+	second := ack.next(t)
+	deliveries <- paymentDelivery(ack, 3, `{"payment": {"id": "p-1"}, "amount": 250}`)
+	third := ack.next(t)
+
+	// Assert
+	assert.Equal(t, settlement{tag: 1, acked: true}, first)
+	assert.Equal(t, settlement{tag: 2, acked: true}, second)
+	assert.Equal(t, settlement{tag: 3, acked: true}, third)
+	assert.Equal(t, int32(2), handled.Load())
+	assert.Equal(t, suppressed+1, testutil.ToFloat64(metrics.WorkerDuplicates.WithLabelValues("payment.captured")))
+}
+
+func TestDispatcher_DedupWindowExpires(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	store := newClockStore()
+	deliveries, handled := startDedupDispatcher(t, store)
+	body := `{"payment": {"id": "p-1"}, "amount": 100}`
+
+	// Act
+	deliveries <- paymentDelivery(ack, 1, body)
+	ack.next(t)
+	store.advance(59 * time.Minute)
+	deliveries <- paymentDelivery(ack, 2, body)
+	ack.next(t)
+	store.advance(time.Minute)
+	deliveries <- paymentDelivery(ack, 3, body)
+	ack.next(t)
+
+	// Assert
+	assert.Equal(t, int32(2), handled.Load(), "the message after the window must be handled again")
+}
+
+func TestDispatcher_DedupForceHeader(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	deliveries, handled := startDedupDispatcher(t, newClockStore())
+	body := `{"payment": {"id": "p-1"}, "amount": 100}`
+
+	// Act
+	deliveries <- paymentDelivery(ack, 1, body)
+	ack.next(t)
+	forcedMsg := paymentDelivery(ack, 2, body)
+	forcedMsg.Headers = messaging.Headers{ForceHeader: true}
+	deliveries <- forcedMsg
+	ack.next(t)
+	forcedMsg = paymentDelivery(ack, 3, body)
+	forcedMsg.Headers = messaging.Headers{ForceHeader: "true"}
+	deliveries <- forcedMsg
+	ack.next(t)
+
+	// Assert
+	assert.Equal(t, int32(3), handled.Load())
+}
+
+func TestDispatcher_DedupSkipsMessagesWithoutKey(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	deliveries, handled := startDedupDispatcher(t, newClockStore())
+
+	// Act
+	for tag, body := range []string{`{"amount": 100}`, `{"amount": 100}`, `not json`, `not json`, `{"payment": null, "amount": 1}`, `{"payment": null, "amount": 1}`} {
+		deliveries <- paymentDelivery(ack, uint64(tag), body)
+		ack.next(t)
+	}
+
+	// Assert
+	assert.Equal(t, int32(6), handled.Load())
+}
+
+func TestDispatcher_DedupIgnoresFailedMessages(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	var calls atomic.Int32
+	d := NewDispatcher(logger.New(), WithDedupStore(newClockStore()), WithRetries(&recordingRetrier{}, 2))
+	d.Handle("payment.captured", func(ctx context.Context, msg messaging.Delivery) error {
+		if calls.Add(1) == 1 {
+			return assert.AnError
+		}
+		return nil
+	}, WithDedup(time.Hour, "payment.id"))
+	deliveries := startDispatcher(t, d)
+	body := `{"payment": {"id": "p-1"}}`
+
+	// Act
+	deliveries <- paymentDelivery(ack, 1, body)
+	first := ack.next(t)
+	deliveries <- paymentDelivery(ack, 2, body)
+	second := ack.next(t)
+
+	// Assert
+	assert.Equal(t, settlement{tag: 1, acked: true}, first, "acked once its retry is published")
+	assert.Equal(t, settlement{tag: 2, acked: true}, second)
+	assert.Equal(t, int32(2), calls.Load(), "a failed message must not suppress its retry")
+}
+
+func TestDispatcher_DedupSharedAcrossWorkers(t *testing.T) {
+	// Arrange: two workers share the store, the first is still handling
+	// the message when the second receives it again
+	ack := newRecordingAcknowledger()
+	store := newClockStore()
+	started, release := make(chan struct{}), make(chan struct{})
+	var handled atomic.Int32
+	handler := func(ctx context.Context, msg messaging.Delivery) error {
+		handled.Add(1)
+		close(started)
+		<-release
+		return nil
+	}
+	first := NewDispatcher(logger.New(), WithDedupStore(store))
+	first.Handle("payment.captured", handler, WithDedup(time.Hour, "payment.id"))
+	second := NewDispatcher(logger.New(), WithDedupStore(store))
+	second.Handle("payment.captured", handler, WithDedup(time.Hour, "payment.id"))
+	toFirst, toSecond := startDispatcher(t, first), startDispatcher(t, second)
+	body := `{"payment": {"id": "p-1"}}`
+
+	// Act
+	toFirst <- paymentDelivery(ack, 1, body)
+	<-started
+	toSecond <- paymentDelivery(ack, 2, body)
+	inFlight := ack.next(t)
+	close(release)
+	handledFirst := ack.next(t)
+	toSecond <- paymentDelivery(ack, 3, body)
+	duplicate := ack.next(t)
+
+	// Assert
+	assert.Equal(t, settlement{tag: 2, requeue: true}, inFlight, "requeued while the other worker handles it")
+	assert.Equal(t, settlement{tag: 1, acked: true}, handledFirst)
+	assert.Equal(t, settlement{tag: 3, acked: true}, duplicate)
+	assert.Equal(t, int32(1), handled.Load())
+}
+
+func TestDispatcher_DedupReleasesTimedOutMessages(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	var calls atomic.Int32
+	d := NewDispatcher(logger.New(), WithDedupStore(newClockStore()), WithRetries(&recordingRetrier{}, 2))
+	d.Handle("payment.captured", func(ctx context.Context, msg messaging.Delivery) error {
+		if calls.Add(1) == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}, WithTimeout(10*time.Millisecond), WithDedup(time.Hour, "payment.id"))
+	deliveries := startDispatcher(t, d)
+	body := `{"payment": {"id": "p-1"}}`
+
+	// Act
+	deliveries <- paymentDelivery(ack, 1, body)
+	first := ack.next(t)
+	deliveries <- paymentDelivery(ack, 2, body)
+	second := ack.next(t)
+
+	// Assert
+	assert.Equal(t, settlement{tag: 1, acked: true}, first, "acked once its retry is published")
+	assert.Equal(t, settlement{tag: 2, acked: true}, second)
+	assert.Equal(t, int32(2), calls.Load(), "a timed out message must not suppress its retry")
+}
@@ -0,0 +1,529 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/cache"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+const (
+	defaultWorkers        = 10
+	defaultHandlerTimeout = 30 * time.Second
+	// defaultPrefetch is the default prefetch window of the worker, see
+	// WithPrefetch
+	defaultPrefetch = 20
+	// defaultMaxAttempts handles a failed message once more before dropping it
+	defaultMaxAttempts = 2
+	// defaultLane handles messages whose type has no registered handler
+	defaultLane = ""
+	// saturatedRequeueDelay is how long a message of a type with no room
+	// left is held before it is requeued, so that the broker does not
+	// redeliver it at once in a loop
+	saturatedRequeueDelay = time.Second
+)
+
+// ErrDeliveriesClosed is returned by Dispatch when the broker closes the delivery channel
+var ErrDeliveriesClosed = errors.New("delivery channel closed")
+
+// Handler processes a single message. The context is cancelled when the
+// handler's timeout expires or the dispatcher shuts down.
+type Handler func(ctx context.Context, msg messaging.Delivery) error
+
+// HandlerOption is a function that configures a message type's handler
+type HandlerOption func(*lane)
+
+// WithTimeout sets how long the handler may run before its context is
+// cancelled and the message is failed, see WithRetries
+func WithTimeout(timeout time.Duration) HandlerOption {
+	return func(l *lane) {
+		l.timeout = timeout
+	}
+}
+
+// WithConcurrency caps how many messages of the type are handled at once,
+// independently of the dispatcher's worker pool
+func WithConcurrency(n int) HandlerOption {
+	return func(l *lane) {
+		l.concurrency = n
+	}
+}
+
+// WithBacklog sets how many messages of the type may wait for a free slot.
+// Defaults to the concurrency. Messages beyond it are held by the type
+// until there is room, up to the prefetch window (see WithPrefetch), while
+// deliveries of the other types keep flowing. A message beyond that is
+// requeued after saturatedRequeueDelay.
+func WithBacklog(n int) HandlerOption {
+	return func(l *lane) {
+		l.backlog = n
+	}
+}
+
+// DispatcherOption is a function that configures a Dispatcher
+type DispatcherOption func(*Dispatcher)
+
+// WithWorkers sets how many messages are handled at once across all types
+func WithWorkers(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.workers = n
+		}
+	}
+}
+
+// WithPrefetch sizes the holding buffer of every type to the broker's
+// prefetch window, see WithBacklog. With the window of the consumer, a type
+// can never hold more than it, so no message is requeued on saturation. 0,
+// also an unlimited window, keeps the default of 20.
+func WithPrefetch(n int) DispatcherOption {
+	return func(d *Dispatcher) {
+		if n > 0 {
+			d.prefetch = n
+		}
+	}
+}
+
+// Retrier publishes a failed message again with its attempts counted, see
+// messaging.Delivery.Retry
+type Retrier interface {
+	Retry(ctx context.Context, msg messaging.Delivery) error
+}
+
+// WithRetries publishes failed messages again through retrier until
+// handlers failed them maxAttempts times, after which they are dropped, or
+// dead-lettered when their queue has a dead letter exchange. Handler
+// timeouts count as failed attempts. Attempts are counted in
+// messaging.AttemptsHeader, so messages requeued unhandled, on saturation
+// or drain, are not counted. A maxAttempts of 0 keeps
+// the default of 2. Without a retrier, failed messages are dropped at once.
+func WithRetries(retrier Retrier, maxAttempts int) DispatcherOption {
+	return func(d *Dispatcher) {
+		d.retrier = retrier
+		if maxAttempts > 0 {
+			d.maxAttempts = maxAttempts
+		}
+	}
+}
+
+// WithDefaultTimeout sets the timeout of handlers registered without WithTimeout
+func WithDefaultTimeout(timeout time.Duration) DispatcherOption {
+	return func(d *Dispatcher) {
+		if timeout > 0 {
+			d.timeout = timeout
+		}
+	}
+}
+
+// lane is the isolated processing path of one message type
+type lane struct {
+	msgType     string
+	handler     Handler
+	timeout     time.Duration
+	concurrency int
+	backlog     int
+	dedup       *dedup
+	queue       chan messaging.Delivery
+	// held keeps the messages beyond the backlog until the lane's feeder
+	// moves them to queue
+	held chan messaging.Delivery
+}
+
+// Dispatcher routes deliveries to handlers by message type. Every type gets
+// its own lane with a concurrency cap, a bounded backlog and a timeout, so a
+// slow handler only delays messages of its own type, see WithBacklog.
+type Dispatcher struct {
+	log       *logger.Logger
+	workers   int
+	prefetch  int
+	timeout   time.Duration
+	processed cache.Adder
+	pool      chan struct{}
+	lanes     map[string]*lane
+	// retrier publishes failed messages again, up to maxAttempts handled
+	retrier     Retrier
+	maxAttempts int
+	// parked counts the saturated messages waiting to be requeued
+	parked sync.WaitGroup
+
+	// draining is closed by Drain, after which in-flight handlers run until
+	// drainDeadline
+	draining      chan struct{}
+	drainOnce     sync.Once
+	drainDeadline time.Time
+}
+
+func NewDispatcher(log *logger.Logger, opts ...DispatcherOption) *Dispatcher {
+	d := &Dispatcher{
+		log:         log,
+		workers:     defaultWorkers,
+		timeout:     defaultHandlerTimeout,
+		maxAttempts: defaultMaxAttempts,
+		prefetch:    defaultPrefetch,
+		processed:   cache.NewMemory(),
+		lanes:       map[string]*lane{},
+		draining:    make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	d.pool = make(chan struct{}, d.workers)
+	d.Handle(defaultLane, d.handleUnknown)
+
+	return d
+}
+
+// Handle registers the handler for messages of msgType. It must be called
+// before Dispatch.
+func (d *Dispatcher) Handle(msgType string, handler Handler, opts ...HandlerOption) {
+	l := &lane{
+		msgType:     msgType,
+		handler:     handler,
+		timeout:     d.timeout,
+		concurrency: d.workers,
+	}
+
+	for _, opt := range opts {
+		opt(l)
+	}
+
+	if l.concurrency <= 0 || l.concurrency > d.workers {
+		l.concurrency = d.workers
+	}
+	if l.backlog <= 0 {
+		l.backlog = l.concurrency
+	}
+	l.queue = make(chan messaging.Delivery, l.backlog)
+	l.held = make(chan messaging.Delivery, d.prefetch)
+
+	d.lanes[msgType] = l
+}
+
+// Dispatch handles deliveries until ctx is done, the channel is closed or
+// Drain is called, then waits for in-flight handlers. Messages still
+// waiting in a backlog are requeued. Cancelling ctx cancels the handlers
+// at once, while after Drain they may run until its deadline.
+func (d *Dispatcher) Dispatch(ctx context.Context, deliveries <-chan messaging.Delivery) error {
+	work, stopWork := context.WithCancel(ctx)
+	defer stopWork()
+
+	var wg, feeders sync.WaitGroup
+	for _, l := range d.lanes {
+		feeders.Add(1)
+		go func(l *lane) {
+			defer feeders.Done()
+			d.feedLane(l)
+		}(l)
+		for i := 0; i < l.concurrency; i++ {
+			wg.Add(1)
+			go func(l *lane) {
+				defer wg.Done()
+				d.runLane(work, l)
+			}(l)
+		}
+	}
+
+	err := d.route(ctx, deliveries)
+
+	if d.isDraining() {
+		// Messages the broker sent before it stopped consuming are requeued
+		// for another worker, and handlers still running at the deadline
+		// are cancelled, which requeues their messages too
+		go d.requeue(ctx, deliveries)
+		deadline := time.AfterFunc(time.Until(d.drainDeadline), func() {
+			d.log.Warn("Drain deadline reached, requeueing in-flight messages", map[string]interface{}{
+				"deadline": d.drainDeadline.Format(time.RFC3339Nano),
+			})
+			stopWork()
+		})
+		defer deadline.Stop()
+	}
+
+	for _, l := range d.lanes {
+		close(l.held)
+	}
+	feeders.Wait()
+	for _, l := range d.lanes {
+		close(l.queue)
+		if d.isDraining() {
+			// The lane goroutines may all be busy with messages in flight
+			for msg := range l.queue {
+				d.nack(msg, true)
+			}
+		}
+	}
+	wg.Wait()
+	d.parked.Wait()
+
+	return err
+}
+
+// Drain stops taking deliveries for a graceful shutdown: messages not being
+// handled yet are requeued, while the handlers in flight may finish until
+// deadline. Their messages are requeued if they are still running then, so
+// deadline should leave them time to settle before the process is killed.
+// Dispatch returns once they are done.
+func (d *Dispatcher) Drain(deadline time.Time) {
+	d.drainOnce.Do(func() {
+		d.drainDeadline = deadline
+		close(d.draining)
+	})
+}
+
+// isDraining reports whether Drain was called
+func (d *Dispatcher) isDraining() bool {
+	select {
+	case <-d.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// requeue nacks the deliveries received while draining, until the channel
+// is closed or ctx is done
+func (d *Dispatcher) requeue(ctx context.Context, deliveries <-chan messaging.Delivery) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-deliveries:
+			if !ok {
+				return
+			}
+			d.nack(msg, true)
+		}
+	}
+}
+
+func (d *Dispatcher) route(ctx context.Context, deliveries <-chan messaging.Delivery) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-d.draining:
+			return nil
+		case msg, ok := <-deliveries:
+			if !ok {
+				if d.isDraining() {
+					// The consumer was cancelled to drain
+					return nil
+				}
+				return ErrDeliveriesClosed
+			}
+
+			l, ok := d.lanes[msg.Type]
+			if !ok {
+				l = d.lanes[defaultLane]
+			}
+
+			// Never wait on a lane, the other types would wait behind it
+			select {
+			case l.held <- msg:
+			default:
+				d.park(ctx, l, msg)
+			}
+		}
+	}
+}
+
+// feedLane moves the held messages of the lane to its backlog as room
+// frees up, in the order they were delivered. Once draining, they are
+// requeued instead.
+func (d *Dispatcher) feedLane(l *lane) {
+	for msg := range l.held {
+		if d.isDraining() {
+			d.nack(msg, true)
+			continue
+		}
+		l.queue <- msg
+	}
+}
+
+// park requeues a message of a saturated lane after saturatedRequeueDelay,
+// or at once when ctx is done or the dispatcher drains
+func (d *Dispatcher) park(ctx context.Context, l *lane, msg messaging.Delivery) {
+	metrics.WorkerSaturated.WithLabelValues(l.label()).Inc()
+	d.log.Debug("Message type saturated, requeueing", map[string]interface{}{
+		"type":       msg.Type,
+		"message_id": msg.MessageID,
+	})
+
+	d.parked.Add(1)
+	go func() {
+		defer d.parked.Done()
+		delay := time.NewTimer(saturatedRequeueDelay)
+		defer delay.Stop()
+		select {
+		case <-delay.C:
+		case <-ctx.Done():
+		case <-d.draining:
+		}
+		d.nack(msg, true)
+	}()
+}
+
+func (d *Dispatcher) runLane(ctx context.Context, l *lane) {
+	for msg := range l.queue {
+		if ctx.Err() != nil || d.isDraining() {
+			d.nack(msg, true)
+			continue
+		}
+
+		select {
+		case d.pool <- struct{}{}:
+		case <-ctx.Done():
+			d.nack(msg, true)
+			continue
+		case <-d.draining:
+			d.nack(msg, true)
+			continue
+		}
+
+		d.process(ctx, l, msg)
+		<-d.pool
+	}
+}
+
+// process runs the handler and settles the message. On timeout the message
+// is failed right away like a handler error, while the lane slot stays
+// taken until the handler actually returns so a handler ignoring its
+// context cannot pile up. Handlers cancelled by a drain are requeued.
+// Duplicates of a message already processed are acknowledged unhandled, and
+// requeued while another worker handles it.
+func (d *Dispatcher) process(ctx context.Context, l *lane, msg messaging.Delivery) {
+	fields := map[string]interface{}{
+		"type":       msg.Type,
+		"message_id": msg.MessageID,
+	}
+
+	// key is recorded once the handler succeeded, the reservation of a
+	// reserved key is released when it did not
+	var key string
+	var reserved bool
+	if l.dedup != nil {
+		if businessKey, ok := l.dedup.businessKey(msg); ok && forced(msg) {
+			key = businessKey
+		} else if ok {
+			switch d.reserve(ctx, l, businessKey, fields) {
+			case claimed:
+				key, reserved = businessKey, true
+			case claimDuplicate:
+				d.settle(ctx, msg, nil, fields)
+				return
+			case claimInFlight:
+				d.nack(msg, true)
+				return
+			}
+		}
+	}
+
+	inFlight := metrics.WorkerInFlight.WithLabelValues(l.label())
+	inFlight.Inc()
+	defer inFlight.Dec()
+
+	handlerCtx, cancel := context.WithTimeout(ctx, l.timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- fmt.Errorf("handler panic: %v", r)
+			}
+		}()
+		done <- l.handler(handlerCtx, msg)
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil && key != "" {
+			d.markProcessed(ctx, l, key, fields)
+		} else if err != nil && reserved {
+			d.release(ctx, key, fields)
+		}
+		d.settle(ctx, msg, err, fields)
+	case <-handlerCtx.Done():
+		if reserved {
+			d.release(ctx, key, fields)
+		}
+		if errors.Is(handlerCtx.Err(), context.DeadlineExceeded) {
+			metrics.WorkerTimeouts.WithLabelValues(l.label()).Inc()
+			fields["timeout"] = l.timeout.String()
+			d.settle(ctx, msg, fmt.Errorf("handler timed out: %w", context.DeadlineExceeded), fields)
+		} else {
+			d.nack(msg, true)
+		}
+
+		if err := <-done; err != nil && !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			d.log.Error("Message handler failed after its timeout", err, fields)
+		}
+	}
+}
+
+// settle acks a handled message. A failed message is published again with
+// the attempt counted, see WithRetries, and dropped once handlers failed it
+// maxAttempts times.
+func (d *Dispatcher) settle(ctx context.Context, msg messaging.Delivery, err error, fields map[string]interface{}) {
+	if err == nil {
+		d.ack(msg, fields)
+		return
+	}
+
+	attempts := msg.Attempts() + 1
+	fields["attempts"] = attempts
+	d.log.Error("Message handler failed", err, fields)
+
+	if d.retrier == nil || attempts >= d.maxAttempts {
+		d.log.Warn("Dropping failed message", fields)
+		d.nack(msg, false)
+		return
+	}
+	if retryErr := d.retrier.Retry(context.WithoutCancel(ctx), msg); retryErr != nil {
+		// The attempt goes uncounted, but the message is not lost
+		d.log.Error("Failed to retry message, requeueing", retryErr, fields)
+		d.nack(msg, true)
+		return
+	}
+	d.ack(msg, fields)
+}
+
+func (d *Dispatcher) ack(msg messaging.Delivery, fields map[string]interface{}) {
+	if err := msg.Ack(false); err != nil {
+		d.log.Error("Failed to ack message", err, fields)
+	}
+}
+
+func (d *Dispatcher) nack(msg messaging.Delivery, requeue bool) {
+	if err := msg.Nack(false, requeue); err != nil {
+		d.log.Error("Failed to nack message", err, map[string]interface{}{
+			"type":       msg.Type,
+			"message_id": msg.MessageID,
+		})
+	}
+}
+
+// handleUnknown acknowledges messages without a registered handler
+func (d *Dispatcher) handleUnknown(ctx context.Context, msg messaging.Delivery) error {
+	d.log.Info("Processing message", map[string]interface{}{
+		"type": msg.Type,
+		"body": string(msg.Body),
+	})
+	return nil
+}
+
+// label is the metrics label of the lane's message type
+func (l *lane) label() string {
+	if l.msgType == defaultLane {
+		return "unknown"
+	}
+	return l.msgType
+}
@@ -0,0 +1,533 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/pkg/metrics"
+)
+
+// settlement is how a delivery was acknowledged
+type settlement struct {
+	tag     uint64
+	acked   bool
+	requeue bool
+}
+
+// recordingAcknowledger reports every ack and nack on a channel
+type recordingAcknowledger struct {
+	settled chan settlement
+}
+
+func newRecordingAcknowledger() *recordingAcknowledger {
+	return &recordingAcknowledger{settled: make(chan settlement, 100)}
+}
+
+func (a *recordingAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.settled <- settlement{tag: tag, acked: true}
+	return nil
+}
+
+func (a *recordingAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.settled <- settlement{tag: tag, requeue: requeue}
+	return nil
+}
+
+func (a *recordingAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+// next waits for the next settlement
+func (a *recordingAcknowledger) next(t *testing.T) settlement {
+	t.Helper()
+
+	select {
+	case s := <-a.settled:
+		return s
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for a message to be settled")
+		return settlement{}
+	}
+}
+
+func delivery(ack messaging.Acknowledger, tag uint64, msgType string) messaging.Delivery {
+	return messaging.Delivery{Acknowledger: ack, DeliveryTag: tag, Type: msgType}
+}
+
+// startDispatcher runs the dispatcher until the test ends
+func startDispatcher(t *testing.T, d *Dispatcher) chan<- messaging.Delivery {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	deliveries := make(chan messaging.Delivery)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = d.Dispatch(ctx, deliveries)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return deliveries
+}
+
+func TestDispatcher_SlowTypeDoesNotStarveOthers(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	d := NewDispatcher(logger.New(), WithWorkers(4))
+	d.Handle("email.send", func(ctx context.Context, msg messaging.Delivery) error {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-ctx.Done():
+		}
+		return nil
+	}, WithConcurrency(1), WithBacklog(1), WithTimeout(time.Minute))
+	d.Handle("user.sync", func(ctx context.Context, msg messaging.Delivery) error {
+		return nil
+	})
+
+	deliveries := startDispatcher(t, d)
+
+	// Act
+	// The first email occupies the only slot, the second waits in the backlog
+	deliveries <- delivery(ack, 1, "email.send")
+	<-started
+	deliveries <- delivery(ack, 2, "email.send")
+	for tag := uint64(10); tag < 15; tag++ {
+		deliveries <- delivery(ack, tag, "user.sync")
+	}
+
+	// Assert
+	settled := map[uint64]settlement{}
+	for range 5 {
+		s := ack.next(t)
+		settled[s.tag] = s
+	}
+	for tag := uint64(10); tag < 15; tag++ {
+		assert.True(t, settled[tag].acked, "user.sync message %d should be handled while email.send is stuck", tag)
+	}
+	assert.Equal(t, float64(1), testutil.ToFloat64(metrics.WorkerInFlight.WithLabelValues("email.send")))
+}
+
+func TestDispatcher_SaturatedTypeHoldsItsMessages(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	started := make(chan struct{}, 4)
+	release := make(chan struct{})
+
+	d := NewDispatcher(logger.New(), WithWorkers(4), WithPrefetch(10))
+	d.Handle("email.send", func(ctx context.Context, msg messaging.Delivery) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}, WithConcurrency(1), WithBacklog(1))
+	d.Handle("user.sync", func(ctx context.Context, msg messaging.Delivery) error {
+		return nil
+	})
+
+	saturated := testutil.ToFloat64(metrics.WorkerSaturated.WithLabelValues("email.send"))
+	deliveries := startDispatcher(t, d)
+
+	// The first email occupies the only slot, the second fills the backlog
+	deliveries <- delivery(ack, 1, "email.send")
+	<-started
+	deliveries <- delivery(ack, 2, "email.send")
+
+	// Act: more emails arrive past the backlog, then another type
+	deliveries <- delivery(ack, 3, "email.send")
+	deliveries <- delivery(ack, 4, "email.send")
+	deliveries <- delivery(ack, 10, "user.sync")
+
+	// Assert: the other type is handled while email.send is saturated
+	assert.Equal(t, settlement{tag: 10, acked: true}, ack.next(t))
+
+	// Once the handler returns, every email is handled in order, none requeued
+	close(release)
+	for tag := uint64(1); tag <= 4; tag++ {
+		assert.Equal(t, settlement{tag: tag, acked: true}, ack.next(t))
+	}
+	assert.Equal(t, saturated, testutil.ToFloat64(metrics.WorkerSaturated.WithLabelValues("email.send")))
+}
+
+func TestDispatcher_SaturatedTypeRequeuesPastItsHold(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+
+	d := NewDispatcher(logger.New(), WithWorkers(4), WithPrefetch(1))
+	d.Handle("email.send", func(ctx context.Context, msg messaging.Delivery) error {
+		started <- struct{}{}
+		<-release
+		return nil
+	}, WithConcurrency(1), WithBacklog(1))
+	d.Handle("user.sync", func(ctx context.Context, msg messaging.Delivery) error {
+		return nil
+	})
+
+	saturated := testutil.ToFloat64(metrics.WorkerSaturated.WithLabelValues("email.send"))
+	deliveries := startDispatcher(t, d)
+
+	// One email runs, one waits in the backlog, the next ones are held
+	deliveries <- delivery(ack, 1, "email.send")
+	<-started
+	for tag := uint64(2); tag <= 5; tag++ {
+		deliveries <- delivery(ack, tag, "email.send")
+	}
+
+	// Act: the other type still flows
+	deliveries <- delivery(ack, 10, "user.sync")
+
+	// Assert: the last emails, past the hold, are requeued after a delay
+	assert.Equal(t, settlement{tag: 10, acked: true}, ack.next(t))
+	requeued := ack.next(t)
+	assert.True(t, requeued.requeue)
+	assert.GreaterOrEqual(t, requeued.tag, uint64(4))
+	assert.Greater(t, testutil.ToFloat64(metrics.WorkerSaturated.WithLabelValues("email.send")), saturated)
+}
+
+func TestDispatcher_TimeoutCountsAnAttempt(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	cancelled := make(chan struct{})
+	retrier := &recordingRetrier{}
+
+	d := NewDispatcher(logger.New(), WithRetries(retrier, 2))
+	d.Handle("email.send", func(ctx context.Context, msg messaging.Delivery) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}, WithTimeout(20*time.Millisecond))
+
+	timeouts := testutil.ToFloat64(metrics.WorkerTimeouts.WithLabelValues("email.send"))
+	deliveries := startDispatcher(t, d)
+
+	// Act
+	deliveries <- delivery(ack, 1, "email.send")
+
+	// Assert: the message is retried with the attempt counted
+	assert.Equal(t, settlement{tag: 1, acked: true}, ack.next(t))
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("handler context was not cancelled")
+	}
+	assert.Equal(t, timeouts+1, testutil.ToFloat64(metrics.WorkerTimeouts.WithLabelValues("email.send")))
+	retrier.mu.Lock()
+	defer retrier.mu.Unlock()
+	require.Len(t, retrier.retried, 1)
+	assert.Equal(t, int64(1), retrier.retried[0].Headers[messaging.AttemptsHeader])
+}
+
+func TestDispatcher_TimeoutDroppedAfterMaxAttempts(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	retrier := &recordingRetrier{}
+
+	d := NewDispatcher(logger.New(), WithRetries(retrier, 2))
+	d.Handle("email.send", func(ctx context.Context, msg messaging.Delivery) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, WithTimeout(10*time.Millisecond))
+
+	deliveries := startDispatcher(t, d)
+
+	// Act: the message already timed out once
+	deliveries <- messaging.Delivery{
+		Acknowledger: ack,
+		DeliveryTag:  1,
+		Type:         "email.send",
+		Headers:      map[string]interface{}{messaging.AttemptsHeader: int64(1)},
+	}
+
+	// Assert: it is dropped, or dead-lettered, instead of requeued again
+	assert.Equal(t, settlement{tag: 1}, ack.next(t))
+	assert.Empty(t, retrier.retried)
+}
+
+func TestDispatcher_TimeoutReleasesSlotOnlyWhenHandlerReturns(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var calls int
+
+	d := NewDispatcher(logger.New())
+	d.Handle("report.build", func(ctx context.Context, msg messaging.Delivery) error {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		// Ignores its context
+		<-release
+		return nil
+	}, WithConcurrency(1), WithTimeout(10*time.Millisecond))
+
+	deliveries := startDispatcher(t, d)
+
+	// Act
+	deliveries <- delivery(ack, 1, "report.build")
+	first := ack.next(t)
+	deliveries <- delivery(ack, 2, "report.build")
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	blocked := calls
+	mu.Unlock()
+	close(release)
+
+	// Assert
+	assert.Equal(t, settlement{tag: 1}, first, "dropped, there is no retrier")
+	assert.Equal(t, 1, blocked, "the next message must wait for the timed out handler to return")
+	assert.Equal(t, settlement{tag: 2, acked: true}, ack.next(t))
+}
+
+// recordingRetrier records the messages published again
+type recordingRetrier struct {
+	mu      sync.Mutex
+	retried []messaging.Publishing
+	err     error
+}
+
+func (r *recordingRetrier) Retry(ctx context.Context, msg messaging.Delivery) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return r.err
+	}
+	r.retried = append(r.retried, msg.Retry())
+	return nil
+}
+
+func TestDispatcher_FailedMessageRetriedByAttempts(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	retrier := &recordingRetrier{}
+
+	d := NewDispatcher(logger.New(), WithRetries(retrier, 2))
+	d.Handle("user.sync", func(ctx context.Context, msg messaging.Delivery) error {
+		return errors.New("upstream unavailable")
+	})
+
+	deliveries := startDispatcher(t, d)
+
+	// Act: a message requeued unhandled before, e.g. on saturation, is
+	// redelivered without a failed attempt
+	requeued := delivery(ack, 1, "user.sync")
+	requeued.Redelivered = true
+	deliveries <- requeued
+	first := ack.next(t)
+	retried := retrier.retried[0]
+	deliveries <- messaging.Delivery{Acknowledger: ack, DeliveryTag: 2, Type: retried.Type, Headers: retried.Headers}
+	second := ack.next(t)
+
+	// Assert
+	assert.Equal(t, settlement{tag: 1, acked: true}, first, "the retry replaces the failed message")
+	assert.Equal(t, int64(1), retried.Headers[messaging.AttemptsHeader])
+	assert.Equal(t, settlement{tag: 2, requeue: false}, second, "dropped after its second failed attempt")
+	assert.Len(t, retrier.retried, 1)
+}
+
+func TestDispatcher_FailedRetryRequeues(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	d := NewDispatcher(logger.New(), WithRetries(&recordingRetrier{err: errors.New("broker unavailable")}, 2))
+	d.Handle("user.sync", func(ctx context.Context, msg messaging.Delivery) error {
+		return errors.New("upstream unavailable")
+	})
+	deliveries := startDispatcher(t, d)
+
+	// Act
+	deliveries <- delivery(ack, 1, "user.sync")
+
+	// Assert
+	assert.Equal(t, settlement{tag: 1, requeue: true}, ack.next(t))
+}
+
+func TestDispatcher_RecoversHandlerPanic(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+
+	retrier := &recordingRetrier{}
+
+	d := NewDispatcher(logger.New(), WithRetries(retrier, 2))
+	d.Handle("user.sync", func(ctx context.Context, msg messaging.Delivery) error {
+		panic("nil map")
+	})
+
+	deliveries := startDispatcher(t, d)
+
+	// Act
+	deliveries <- delivery(ack, 1, "user.sync")
+
+	// Assert: the panic counts as a failed attempt
+	assert.Equal(t, settlement{tag: 1, acked: true}, ack.next(t))
+	assert.Len(t, retrier.retried, 1)
+}
+
+func TestDispatcher_FailedMessageDroppedWithoutRetrier(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	d := NewDispatcher(logger.New())
+	d.Handle("user.sync", func(ctx context.Context, msg messaging.Delivery) error {
+		return errors.New("upstream unavailable")
+	})
+	deliveries := startDispatcher(t, d)
+
+	// Act
+	deliveries <- delivery(ack, 1, "user.sync")
+
+	// Assert
+	assert.Equal(t, settlement{tag: 1, requeue: false}, ack.next(t))
+}
+
+func TestDispatcher_UnknownTypeAcked(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	deliveries := startDispatcher(t, NewDispatcher(logger.New()))
+
+	// Act
+	deliveries <- delivery(ack, 1, "legacy.task")
+
+	// Assert
+	assert.Equal(t, settlement{tag: 1, acked: true}, ack.next(t))
+}
+
+func TestDispatcher_ClosedDeliveries(t *testing.T) {
+	// Arrange
+	deliveries := make(chan messaging.Delivery)
+	close(deliveries)
+
+	// Act
+	err := NewDispatcher(logger.New()).Dispatch(context.Background(), deliveries)
+
+	// Assert
+	require.ErrorIs(t, err, ErrDeliveriesClosed)
+}
+
+// runDispatcher runs the dispatcher and returns the result of Dispatch
+func runDispatcher(t *testing.T, d *Dispatcher) (chan<- messaging.Delivery, <-chan error) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	deliveries := make(chan messaging.Delivery)
+	done := make(chan error, 1)
+	go func() { done <- d.Dispatch(ctx, deliveries) }()
+	return deliveries, done
+}
+
+func TestDispatcher_DrainFinishesInFlight(t *testing.T) {
+	// Arrange
+	ack := newRecordingAcknowledger()
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+
+	d := NewDispatcher(logger.New())
+	d.Handle("report.build", func(ctx context.Context, msg messaging.Delivery) error {
+		started <- struct{}{}
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, WithConcurrency(1), WithBacklog(1))
+	deliveries, done := runDispatcher(t, d)
+
+	deliveries <- delivery(ack, 1, "report.build")
+	<-started
+	deliveries <- delivery(ack, 2, "report.build")
+
+	// Act
+	d.Drain(time.Now().Add(time.Minute))
+	deliveries <- delivery(ack, 3, "report.build")
+
+	// Assert: the waiting and the late messages are requeued at once
+	settled := map[uint64]settlement{}
+	for range 2 {
+		s := ack.next(t)
+		settled[s.tag] = s
+	}
+	assert.Equal(t, map[uint64]settlement{
+		2: {tag: 2, requeue: true},
+		3: {tag: 3, requeue: true},
+	}, settled)
+
+	// Assert: the message in flight is handled to the end
+	close(release)
+	assert.Equal(t, settlement{tag: 1, acked: true}, ack.next(t))
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Dispatch did not return after draining")
+	}
+}
+
+func TestDispatcher_DrainRequeuesBeforeGraceExpires(t *testing.T) {
+	// Arrange: a 300ms grace whose last 100ms are kept to requeue
+	const grace, margin = 300 * time.Millisecond, 100 * time.Millisecond
+	ack := newRecordingAcknowledger()
+	started := make(chan struct{}, 2)
+	cancelled := make(chan error, 2)
+
+	d := NewDispatcher(logger.New())
+	d.Handle("report.build", func(ctx context.Context, msg messaging.Delivery) error {
+		started <- struct{}{}
+		// Far slower than the grace
+		select {
+		case <-time.After(time.Minute):
+			return nil
+		case <-ctx.Done():
+			cancelled <- ctx.Err()
+			return ctx.Err()
+		}
+	}, WithTimeout(time.Hour))
+	deliveries, done := runDispatcher(t, d)
+	deliveries <- delivery(ack, 1, "report.build")
+	deliveries <- delivery(ack, 2, "report.build")
+	<-started
+	<-started
+	timeouts := testutil.ToFloat64(metrics.WorkerTimeouts.WithLabelValues("report.build"))
+
+	// Act
+	sigterm := time.Now()
+	d.Drain(sigterm.Add(grace - margin))
+
+	// Assert: both are requeued once the margin starts, well before the grace expires
+	for range 2 {
+		s := ack.next(t)
+		elapsed := time.Since(sigterm)
+		assert.True(t, s.requeue, "message %d", s.tag)
+		assert.GreaterOrEqual(t, elapsed, grace-margin, "in-flight work may run until the margin")
+		assert.Less(t, elapsed, grace-margin/2, "the margin is left to requeue")
+	}
+	for range 2 {
+		assert.ErrorIs(t, <-cancelled, context.Canceled)
+	}
+	assert.Equal(t, timeouts, testutil.ToFloat64(metrics.WorkerTimeouts.WithLabelValues("report.build")), "not a handler timeout")
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(grace):
+		t.Fatal("Dispatch did not return within the grace")
+	}
+}
@@ -0,0 +1,42 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+)
+
+// JobHandler adapts the function running an enqueued job to a Handler. The
+// message carries a domain.JobEnqueued payload naming the job.
+func JobHandler(run func(ctx context.Context, jobID string) error) Handler {
+	return func(ctx context.Context, msg messaging.Delivery) error {
+		var enqueued domain.JobEnqueued
+		if err := json.Unmarshal(msg.Body, &enqueued); err != nil {
+			return fmt.Errorf("decode job message: %w", err)
+		}
+		if enqueued.JobID == "" {
+			return fmt.Errorf("job message %s names no job", msg.MessageID)
+		}
+		return run(ctx, enqueued.JobID)
+	}
+}
+
+// UserCleanupHandler adapts the function running a user deletion hook to a
+// Handler. The message carries a domain.UserCleanup payload naming the user
+// and the hook, which runs for the tenant of the user.
+func UserCleanupHandler(run func(ctx context.Context, userID, hook string) error) Handler {
+	return func(ctx context.Context, msg messaging.Delivery) error {
+		var cleanup domain.UserCleanup
+		if err := json.Unmarshal(msg.Body, &cleanup); err != nil {
+			return fmt.Errorf("decode user cleanup message: %w", err)
+		}
+		if cleanup.UserID == "" || cleanup.Hook == "" {
+			return fmt.Errorf("user cleanup message %s names no user or hook", msg.MessageID)
+		}
+		return run(tenant.WithTenant(ctx, cleanup.Tenant), cleanup.UserID, cleanup.Hook)
+	}
+}
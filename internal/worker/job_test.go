@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/tenant"
+)
+
+func TestJobHandler(t *testing.T) {
+	var ran []string
+	handler := JobHandler(func(ctx context.Context, jobID string) error {
+		ran = append(ran, jobID)
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "runs the named job", body: `{"job_id":"job-1"}`},
+		{name: "malformed body", body: `{`, wantErr: "decode job message"},
+		{name: "no job", body: `{}`, wantErr: "names no job"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			err := handler(context.Background(), messaging.Delivery{MessageID: "msg-1", Body: []byte(tt.body)})
+
+			// Assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+	assert.Equal(t, []string{"job-1"}, ran)
+}
+
+func TestUserCleanupHandler(t *testing.T) {
+	var ran []string
+	handler := UserCleanupHandler(func(ctx context.Context, userID, hook string) error {
+		ran = append(ran, tenant.FromContext(ctx)+"/"+userID+"/"+hook)
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string
+	}{
+		{name: "runs the named hook", body: `{"user_id":"user-1","hook":"avatars","tenant":"acme"}`},
+		{name: "malformed body", body: `{`, wantErr: "decode user cleanup message"},
+		{name: "no hook", body: `{"user_id":"user-1"}`, wantErr: "names no user or hook"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Act
+			err := handler(context.Background(), messaging.Delivery{MessageID: "msg-1", Body: []byte(tt.body)})
+
+			// Assert
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+	assert.Equal(t, []string{"acme/user-1/avatars"}, ran)
+}
@@ -2,49 +2,173 @@ package worker
 
 import (
 	"context"
-	"log"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"github.com/streadway/amqp"
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/health"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
 )
 
+// defaultQueue is consumed when no queues are configured
+const defaultQueue = "tasks"
+
 type MessageQueue interface {
-	Consume(queueName string) (<-chan amqp.Delivery, error)
+	Consume(queueName string) (<-chan messaging.Delivery, error)
 	Close() error
 }
 
+// consumerStopper is a MessageQueue that can stop the broker sending
+// messages without closing the connection, see repository.RabbitMQ
+type consumerStopper interface {
+	StopConsuming() error
+}
+
+// Phase is the stage of the lifecycle of a worker
+type Phase string
+
+const (
+	// PhaseStarting is a worker subscribing to its queues
+	PhaseStarting Phase = "starting"
+	// PhaseRunning is a worker handling messages
+	PhaseRunning Phase = "running"
+	// PhaseDraining is a worker shutting down, finishing the messages in
+	// flight without taking new ones, see Drain
+	PhaseDraining Phase = "draining"
+	// PhaseStopped is a worker whose Run returned
+	PhaseStopped Phase = "stopped"
+)
+
 type Worker struct {
-	queue MessageQueue
+	queue      MessageQueue
+	dispatcher *Dispatcher
+	queues     []string
+	phase      atomic.Value
+	log        *logger.Logger
 }
 
-func NewWorker(queue MessageQueue) *Worker {
-	return &Worker{
-		queue: queue,
+// WorkerOption is a function that configures a Worker
+type WorkerOption func(*Worker)
+
+// WithQueues sets the queues the worker consumes, e.g. the task queues of
+// its tenant partitions. Deliveries of all of them share the dispatcher.
+func WithQueues(names ...string) WorkerOption {
+	return func(w *Worker) {
+		if len(names) > 0 {
+			w.queues = names
+		}
 	}
 }
 
+// WithLogger sets the logger the worker reports its lifecycle to,
+// logger.Default() unless set
+func WithLogger(log *logger.Logger) WorkerOption {
+	return func(w *Worker) {
+		if log != nil {
+			w.log = log
+		}
+	}
+}
+
+func NewWorker(queue MessageQueue, dispatcher *Dispatcher, opts ...WorkerOption) *Worker {
+	w := &Worker{
+		queue:      queue,
+		dispatcher: dispatcher,
+		queues:     []string{defaultQueue},
+		log:        logger.Default(),
+	}
+	w.phase.Store(PhaseStarting)
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
 func (w *Worker) Run(ctx context.Context) error {
-	// Subscribing to the task queue
-	messages, err := w.queue.Consume("tasks")
-	if err != nil {
-		return err
-	}
-
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case msg := <-messages:
-			if err := w.processMessage(msg); err != nil {
-				log.Printf("Error processing message: %v", err)
-			}
+	defer w.phase.Store(PhaseStopped)
+
+	// Subscribing to the task queues
+	subscriptions := make([]<-chan messaging.Delivery, 0, len(w.queues))
+	for _, name := range w.queues {
+		messages, err := w.queue.Consume(name)
+		if err != nil {
+			w.log.Error("Failed to subscribe to queue", err, map[string]interface{}{"queue": name})
+			return err
 		}
+		subscriptions = append(subscriptions, messages)
+	}
+	w.phase.CompareAndSwap(PhaseStarting, PhaseRunning)
+	w.log.Info("Worker running", map[string]interface{}{"queues": w.queues})
+
+	if len(subscriptions) == 1 {
+		return w.dispatcher.Dispatch(ctx, subscriptions[0])
+	}
+	return w.dispatcher.Dispatch(ctx, merge(ctx, subscriptions))
+}
+
+// Drain starts the graceful shutdown of a running worker. New messages are
+// no longer taken: the broker stops sending them when the queue supports it,
+// and those already received are requeued. The messages in flight may be
+// handled until deadline, after which they are cancelled and requeued, see
+// Dispatcher.Drain. Run returns once they are settled.
+func (w *Worker) Drain(deadline time.Time) error {
+	w.phase.Store(PhaseDraining)
+	w.log.Info("Worker draining", map[string]interface{}{"queues": w.queues, "deadline": deadline.Format(time.RFC3339)})
+	w.dispatcher.Drain(deadline)
+	if q, ok := w.queue.(consumerStopper); ok {
+		return q.StopConsuming()
 	}
+	return nil
 }
 
-func (w *Worker) processMessage(msg amqp.Delivery) error {
-	// Processing message
-	log.Printf("Processing message: %s", string(msg.Body))
+// Phase returns the stage of the lifecycle of the worker
+func (w *Worker) Phase() Phase {
+	return w.phase.Load().(Phase)
+}
+
+// Name implements health.Checker
+func (w *Worker) Name() string {
+	return "worker"
+}
+
+// Check implements health.Checker, failing once the worker drains so that
+// it is not counted as available while it shuts down
+func (w *Worker) Check(ctx context.Context) health.Result {
+	switch phase := w.Phase(); phase {
+	case PhaseStarting:
+		return health.Starting(string(phase))
+	case PhaseRunning:
+		return health.Pass(string(phase))
+	default:
+		return health.Fail(string(phase))
+	}
+}
 
-	// Acknowledging processing
-	return msg.Ack(false)
+// merge forwards the deliveries of every subscription to one channel, which
+// is closed once all of them are
+func merge(ctx context.Context, subscriptions []<-chan messaging.Delivery) <-chan messaging.Delivery {
+	out := make(chan messaging.Delivery)
+	var wg sync.WaitGroup
+	for _, messages := range subscriptions {
+		wg.Add(1)
+		go func(messages <-chan messaging.Delivery) {
+			defer wg.Done()
+			for msg := range messages {
+				select {
+				case out <- msg:
+				case <-ctx.Done():
+					// Unacknowledged, the broker redelivers it
+					return
+				}
+			}
+		}(messages)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
 }
@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/internal/messaging"
+	"github.com/romanitalian/carch-go/internal/pkg/health"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+)
+
+// fakeQueues serves a delivery channel per queue
+type fakeQueues struct {
+	queues   map[string]chan messaging.Delivery
+	consumed []string
+}
+
+func newFakeQueues(names ...string) *fakeQueues {
+	q := &fakeQueues{queues: map[string]chan messaging.Delivery{}}
+	for _, name := range names {
+		q.queues[name] = make(chan messaging.Delivery, 1)
+	}
+	return q
+}
+
+func (q *fakeQueues) Consume(queueName string) (<-chan messaging.Delivery, error) {
+	q.consumed = append(q.consumed, queueName)
+	return q.queues[queueName], nil
+}
+
+func (q *fakeQueues) Close() error {
+	return nil
+}
+
+func TestWorker_Run_ConsumesItsPartitions(t *testing.T) {
+	// Arrange: the worker owns the default and acme partitions of three
+	queues := newFakeQueues("tasks.default", "tasks.acme", "tasks.globex")
+	ack := newRecordingAcknowledger()
+
+	handled := make(chan string, 3)
+	d := NewDispatcher(logger.New())
+	d.Handle("webhook.github", func(ctx context.Context, msg messaging.Delivery) error {
+		handled <- msg.MessageID
+		return nil
+	})
+	w := NewWorker(queues, d, WithQueues("tasks.default", "tasks.acme"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+
+	// Act
+	queues.queues["tasks.globex"] <- messaging.Delivery{Acknowledger: ack, DeliveryTag: 1, Type: "webhook.github", MessageID: "globex"}
+	queues.queues["tasks.acme"] <- messaging.Delivery{Acknowledger: ack, DeliveryTag: 2, Type: "webhook.github", MessageID: "acme"}
+	queues.queues["tasks.default"] <- messaging.Delivery{Acknowledger: ack, DeliveryTag: 3, Type: "webhook.github", MessageID: "default"}
+
+	// Assert: deliveries of both partitions are dispatched, the other partition is left alone
+	var got []string
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-handled:
+			got = append(got, id)
+		case <-time.After(2 * time.Second):
+			t.Fatal("delivery was not handled")
+		}
+	}
+	assert.ElementsMatch(t, []string{"acme", "default"}, got)
+	assert.Equal(t, []string{"tasks.default", "tasks.acme"}, queues.consumed)
+	assert.Len(t, queues.queues["tasks.globex"], 1, "not consumed")
+
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestWorker_Run_DefaultQueue(t *testing.T) {
+	// Arrange
+	queues := newFakeQueues("tasks")
+	w := NewWorker(queues, NewDispatcher(logger.New()))
+	close(queues.queues["tasks"])
+
+	// Act
+	err := w.Run(context.Background())
+
+	// Assert
+	assert.ErrorIs(t, err, ErrDeliveriesClosed)
+	assert.Equal(t, []string{"tasks"}, queues.consumed)
+}
+
+// stoppableQueues closes the delivery channels when consuming stops, like
+// the broker does once it delivered the messages already sent
+type stoppableQueues struct {
+	*fakeQueues
+	stopped bool
+}
+
+func (q *stoppableQueues) StopConsuming() error {
+	q.stopped = true
+	for _, deliveries := range q.queues {
+		close(deliveries)
+	}
+	return nil
+}
+
+func TestWorker_Drain(t *testing.T) {
+	// Arrange
+	queues := &stoppableQueues{fakeQueues: newFakeQueues("tasks")}
+	ack := newRecordingAcknowledger()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	d := NewDispatcher(logger.New())
+	d.Handle("report.build", func(ctx context.Context, msg messaging.Delivery) error {
+		close(started)
+		<-release
+		return nil
+	})
+	w := NewWorker(queues, d)
+	assert.Equal(t, health.StatusStarting, w.Check(context.Background()).Status)
+
+	done := make(chan error, 1)
+	go func() { done <- w.Run(context.Background()) }()
+	queues.queues["tasks"] <- messaging.Delivery{Acknowledger: ack, DeliveryTag: 1, Type: "report.build"}
+	<-started
+	assert.Equal(t, PhaseRunning, w.Phase())
+	assert.Equal(t, health.StatusPass, w.Check(context.Background()).Status)
+
+	// Act
+	require.NoError(t, w.Drain(time.Now().Add(time.Minute)))
+
+	// Assert
+	assert.True(t, queues.stopped, "the broker stops sending messages")
+	assert.Equal(t, PhaseDraining, w.Phase())
+	assert.Equal(t, health.Result{Status: health.StatusFail, Message: "draining"}, w.Check(context.Background()))
+
+	close(release)
+	assert.Equal(t, settlement{tag: 1, acked: true}, ack.next(t))
+	require.NoError(t, <-done)
+	assert.Equal(t, PhaseStopped, w.Phase())
+}
+
+// missingQueues fails to consume any queue
+type missingQueues struct {
+	fakeQueues
+}
+
+func (q *missingQueues) Consume(queueName string) (<-chan messaging.Delivery, error) {
+	return nil, errors.New("NOT_FOUND - no queue")
+}
+
+func TestWorker_Run_LogsSubscriptionFailure(t *testing.T) {
+	// Arrange
+	var logs bytes.Buffer
+	log := logger.New(logger.WithOutput(&logs))
+	w := NewWorker(&missingQueues{}, NewDispatcher(log), WithQueues("tasks.acme"), WithLogger(log))
+
+	// Act
+	err := w.Run(context.Background())
+
+	// Assert
+	require.Error(t, err)
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "Failed to subscribe to queue", entry["message"])
+	assert.Equal(t, "tasks.acme", entry["queue"])
+	assert.Equal(t, "error", entry["level"])
+}
@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS user_tombstones (
+    id UUID PRIMARY KEY,
+    deleted_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS user_tombstones_deleted_at_idx ON user_tombstones (deleted_at);
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS email_change_requests (
+    user_id UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    new_email VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS service_instances (
+    id UUID PRIMARY KEY,
+    hostname VARCHAR(255) NOT NULL,
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    heartbeat_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    config_hash VARCHAR(64) NOT NULL,
+    version VARCHAR(255) NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS service_instances_heartbeat_at_idx ON service_instances (heartbeat_at);
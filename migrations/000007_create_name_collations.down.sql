@@ -0,0 +1,10 @@
+DROP COLLATION IF EXISTS "de-DE";
+DROP COLLATION IF EXISTS "en-US";
+DROP COLLATION IF EXISTS "es-ES";
+DROP COLLATION IF EXISTS "fr-FR";
+DROP COLLATION IF EXISTS "it-IT";
+DROP COLLATION IF EXISTS "pl-PL";
+DROP COLLATION IF EXISTS "ru-RU";
+DROP COLLATION IF EXISTS "sv-SE";
+DROP COLLATION IF EXISTS "tr-TR";
+DROP COLLATION IF EXISTS "uk-UA";
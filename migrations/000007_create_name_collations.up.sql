@@ -0,0 +1,16 @@
+-- ICU collations used to sort users by name, named after their BCP 47 tag.
+-- Servers built without ICU skip them and the service falls back to sorting in memory.
+DO $$
+DECLARE
+    locale TEXT;
+BEGIN
+    FOREACH locale IN ARRAY ARRAY['de-DE', 'en-US', 'es-ES', 'fr-FR', 'it-IT', 'pl-PL', 'ru-RU', 'sv-SE', 'tr-TR', 'uk-UA']
+    LOOP
+        BEGIN
+            EXECUTE format('CREATE COLLATION IF NOT EXISTS %I (provider = icu, locale = %L)', locale, locale);
+        EXCEPTION WHEN OTHERS THEN
+            RAISE NOTICE 'collation % not created: %', locale, SQLERRM;
+        END;
+    END LOOP;
+END
+$$;
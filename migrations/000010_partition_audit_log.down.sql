@@ -0,0 +1,21 @@
+ALTER TABLE audit_log RENAME TO audit_log_partitioned;
+ALTER INDEX audit_log_entity_idx RENAME TO audit_log_partitioned_entity_idx;
+ALTER SEQUENCE audit_log_id_seq OWNED BY NONE;
+
+CREATE TABLE audit_log (
+    id BIGINT PRIMARY KEY DEFAULT nextval('audit_log_id_seq'),
+    action VARCHAR(64) NOT NULL,
+    entity VARCHAR(64) NOT NULL,
+    entity_id VARCHAR(255) NOT NULL,
+    details JSONB,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX audit_log_entity_idx ON audit_log (entity, entity_id);
+
+INSERT INTO audit_log (id, action, entity, entity_id, details, created_at)
+SELECT id, action, entity, entity_id, details, created_at
+FROM audit_log_partitioned;
+
+DROP TABLE audit_log_partitioned;
+ALTER SEQUENCE audit_log_id_seq OWNED BY audit_log.id;
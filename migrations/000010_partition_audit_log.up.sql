@@ -0,0 +1,50 @@
+-- Convert audit_log into a table partitioned by created_at month. Partitions
+-- are named audit_log_yYYYYmMM and cover calendar months in UTC; rows outside
+-- every monthly partition land in audit_log_default. The scheduler keeps the
+-- upcoming months created and drops the ones past retention.
+ALTER TABLE audit_log RENAME TO audit_log_unpartitioned;
+ALTER INDEX audit_log_entity_idx RENAME TO audit_log_unpartitioned_entity_idx;
+ALTER SEQUENCE audit_log_id_seq OWNED BY NONE;
+
+CREATE TABLE audit_log (
+    id BIGINT NOT NULL DEFAULT nextval('audit_log_id_seq'),
+    action VARCHAR(64) NOT NULL,
+    entity VARCHAR(64) NOT NULL,
+    entity_id VARCHAR(255) NOT NULL,
+    details JSONB,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (id, created_at)
+) PARTITION BY RANGE (created_at);
+
+CREATE INDEX audit_log_entity_idx ON audit_log (entity, entity_id);
+
+CREATE TABLE audit_log_default PARTITION OF audit_log DEFAULT;
+
+-- One partition per month from the oldest existing entry through next month
+DO $$
+DECLARE
+    month TIMESTAMP;
+    last_month TIMESTAMP := date_trunc('month', now() AT TIME ZONE 'UTC') + INTERVAL '1 month';
+BEGIN
+    SELECT COALESCE(date_trunc('month', min(created_at) AT TIME ZONE 'UTC'), date_trunc('month', now() AT TIME ZONE 'UTC'))
+    INTO month
+    FROM audit_log_unpartitioned;
+
+    WHILE month <= last_month LOOP
+        EXECUTE format(
+            'CREATE TABLE %I PARTITION OF audit_log FOR VALUES FROM (%L) TO (%L)',
+            'audit_log_' || to_char(month, '"y"YYYY"m"MM'),
+            month AT TIME ZONE 'UTC',
+            (month + INTERVAL '1 month') AT TIME ZONE 'UTC'
+        );
+        month := month + INTERVAL '1 month';
+    END LOOP;
+END
+$$;
+
+INSERT INTO audit_log (id, action, entity, entity_id, details, created_at)
+SELECT id, action, entity, entity_id, details, created_at
+FROM audit_log_unpartitioned;
+
+DROP TABLE audit_log_unpartitioned;
+ALTER SEQUENCE audit_log_id_seq OWNED BY audit_log.id;
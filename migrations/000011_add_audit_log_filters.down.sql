@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS audit_log_action_created_at_idx;
+DROP INDEX IF EXISTS audit_log_entity_id_created_at_idx;
+DROP INDEX IF EXISTS audit_log_actor_id_created_at_idx;
+DROP INDEX IF EXISTS audit_log_created_at_id_idx;
+
+ALTER TABLE audit_log DROP COLUMN IF EXISTS actor_id;
@@ -0,0 +1,10 @@
+-- Who performed an audited action, NULL for actions of the system itself
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS actor_id VARCHAR(255);
+
+-- The audit listing pages on (created_at, id), newest first. Every filter
+-- gets an index ending with the page key so a page is a bounded index scan.
+-- Indexes on the partitioned table are created on every partition.
+CREATE INDEX IF NOT EXISTS audit_log_created_at_id_idx ON audit_log (created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_created_at_idx ON audit_log (actor_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_log_entity_id_created_at_idx ON audit_log (entity_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS audit_log_action_created_at_idx ON audit_log (action, created_at DESC, id DESC);
@@ -0,0 +1,13 @@
+-- Every event relayed from the outbox, numbered in relay order so consumers
+-- can be rebuilt by replaying a range of sequence numbers
+CREATE TABLE IF NOT EXISTS event_archive (
+    seq BIGSERIAL PRIMARY KEY,
+    event_id UUID NOT NULL UNIQUE,
+    event_type VARCHAR(255) NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    archived_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS event_archive_event_type_seq_idx ON event_archive (event_type, seq);
+CREATE INDEX IF NOT EXISTS event_archive_archived_at_idx ON event_archive (archived_at);
@@ -0,0 +1,2 @@
+ALTER TABLE event_archive DROP COLUMN IF EXISTS tenant;
+ALTER TABLE outbox DROP COLUMN IF EXISTS tenant;
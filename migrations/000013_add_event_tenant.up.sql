@@ -0,0 +1,4 @@
+-- The tenant an event belongs to, empty for events without one. Published
+-- events are routed by it when tenant routing is enabled.
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS tenant VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE event_archive ADD COLUMN IF NOT EXISTS tenant VARCHAR(255) NOT NULL DEFAULT '';
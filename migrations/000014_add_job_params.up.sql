@@ -0,0 +1,3 @@
+-- What a job type needs to run, e.g. the filter of a bulk delete. Jobs are
+-- handed to the worker by ID only, so a redelivered job resumes from here.
+ALTER TABLE jobs ADD COLUMN IF NOT EXISTS params JSONB NOT NULL DEFAULT '{}';
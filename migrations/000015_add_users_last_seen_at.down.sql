@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS users_last_seen_at_idx;
+
+ALTER TABLE users DROP COLUMN IF EXISTS last_seen_at;
@@ -0,0 +1,8 @@
+-- min-compatible-binary: 14
+-- last_seen_at moves on creation, changes and reads by ID. Users are
+-- seeded with their last change until they are seen again.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP WITH TIME ZONE;
+
+UPDATE users SET last_seen_at = updated_at WHERE last_seen_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS users_last_seen_at_idx ON users (last_seen_at DESC NULLS LAST) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+-- min-compatible-binary: 15
+ALTER TABLE users ADD COLUMN IF NOT EXISTS metadata JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS metadata_encrypted JSONB NOT NULL DEFAULT '{}';
@@ -0,0 +1,5 @@
+ALTER TABLE event_archive DROP COLUMN IF EXISTS aggregate_seq;
+ALTER TABLE event_archive DROP COLUMN IF EXISTS aggregate_id;
+ALTER TABLE outbox DROP COLUMN IF EXISTS aggregate_seq;
+ALTER TABLE outbox DROP COLUMN IF EXISTS aggregate_id;
+DROP TABLE IF EXISTS outbox_sequences;
@@ -0,0 +1,16 @@
+-- min-compatible-binary: 16
+-- Events about the same aggregate, such as a user, are numbered from 1 in
+-- the order they were recorded. outbox_sequences holds the last number of
+-- each aggregate and the creation time of its last event, which later events
+-- of the aggregate do not precede, so relaying in creation order keeps them
+-- in sequence. Events without an aggregate have an empty one and number 0.
+CREATE TABLE IF NOT EXISTS outbox_sequences (
+    aggregate_id VARCHAR(255) PRIMARY KEY,
+    seq BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS aggregate_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS aggregate_seq BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE event_archive ADD COLUMN IF NOT EXISTS aggregate_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE event_archive ADD COLUMN IF NOT EXISTS aggregate_seq BIGINT NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- min-compatible-binary: 17
+-- Payload fields too sensitive to keep, such as email change tokens, are held
+-- apart from the payload until the event is published and then cleared. The
+-- event archive has no such column, so they are never archived.
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS secret JSONB;
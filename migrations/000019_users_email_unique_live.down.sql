@@ -0,0 +1,4 @@
+-- Fails while a deleted user and a live one share an email
+DROP INDEX IF EXISTS users_email_live_key;
+
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
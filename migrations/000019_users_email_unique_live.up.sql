@@ -0,0 +1,7 @@
+-- min-compatible-binary: 18
+-- Soft-deleted users keep their row until the purge, their email can be
+-- registered again right away. Binaries up to 18 map the violation of the
+-- new index to a generic conflict instead of email_taken.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_email_key;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_live_key ON users (email) WHERE deleted_at IS NULL;
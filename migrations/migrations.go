@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// FS holds the SQL migrations shipped with the binary
+//
+//go:embed *.sql
+var FS embed.FS
+
+// LatestVersion returns the highest migration version embedded in the binary
+func LatestVersion() (uint, error) {
+	entries, err := fs.ReadDir(FS, ".")
+	if err != nil {
+		return 0, err
+	}
+
+	var latest uint
+	for _, e := range entries {
+		prefix, _, ok := strings.Cut(e.Name(), "_")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid migration file name %s: %w", e.Name(), err)
+		}
+		if uint(v) > latest {
+			latest = uint(v)
+		}
+	}
+
+	return latest, nil
+}
@@ -88,8 +88,10 @@ func (m *SeedManager) EnsureDatabaseExists(dbName string) error {
 	return nil
 }
 
-// InitializeDatabase ensures the database and user exist and grants necessary permissions
-func (m *SeedManager) InitializeDatabase(dbName, username string) error {
+// InitializeDatabase ensures the database and user exist and grants necessary
+// permissions. dsn connects the administrator to dbName, with the TLS
+// settings of the server connection.
+func (m *SeedManager) InitializeDatabase(dbName, username, dsn string) error {
 	// First ensure the user exists
 	if err := m.EnsureUserExists(username, ""); err != nil {
 		return err
@@ -108,8 +110,7 @@ func (m *SeedManager) InitializeDatabase(dbName, username string) error {
 	}
 
 	// Connect to the specific database to grant schema privileges
-	dbConn, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		"localhost", 5432, "postgres", "postgres", dbName))
+	dbConn, err := sql.Open("postgres", dsn)
 	if err != nil {
 		return fmt.Errorf("failed to connect to database for schema privileges: %w", err)
 	}
@@ -133,9 +134,10 @@ func (m *SeedManager) InitializeRabbitMQUser(adminURL, username, password, vhost
 	})
 
 	m.logger.Info("Note: RabbitMQ user creation requires the rabbitmqadmin tool or management plugin", nil)
+	// The password is left out of the logs, the operator knows where it is configured
 	m.logger.Info("If this fails, please create the user manually with:", map[string]interface{}{
-		"command": fmt.Sprintf("rabbitmqctl add_user %s %s && rabbitmqctl set_permissions -p %s %s \".*\" \".*\" \".*\"",
-			username, password, vhost, username),
+		"command": fmt.Sprintf("rabbitmqctl add_user %s '<password>' && rabbitmqctl set_permissions -p %s %s \".*\" \".*\" \".*\"",
+			username, vhost, username),
 	})
 
 	m.logger.Info("RabbitMQ initialization completed", nil)
@@ -0,0 +1,89 @@
+package users_test
+
+import (
+	"flag"
+	"fmt"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite testdata/api.golden with the current exported surface")
+
+const pkgPath = "github.com/romanitalian/carch-go/pkg/users"
+
+// TestAPI_Stable fails when the exported surface of the package changes,
+// including the internal types it aliases. Review the diff and, if the change
+// is intended and compatible, run go test ./pkg/users -run TestAPI -update.
+func TestAPI_Stable(t *testing.T) {
+	// Arrange
+	golden := filepath.Join("testdata", "api.golden")
+
+	// Act
+	pkg, err := importer.ForCompiler(token.NewFileSet(), "source", nil).Import(pkgPath)
+	require.NoError(t, err)
+	surface := describe(pkg)
+
+	// Assert
+	if *update {
+		require.NoError(t, os.WriteFile(golden, []byte(surface), 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), surface, "exported API changed, see TestAPI_Stable")
+}
+
+// describe lists every exported object of pkg, one per line. Types are
+// followed by their underlying type and their exported methods, so changes
+// to aliased types show up too.
+func describe(pkg *types.Package) string {
+	qualifier := func(p *types.Package) string {
+		if p == pkg {
+			return ""
+		}
+		return p.Path()
+	}
+
+	var lines []string
+	scope := pkg.Scope()
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if !obj.Exported() {
+			continue
+		}
+		lines = append(lines, types.ObjectString(obj, qualifier))
+
+		tn, ok := obj.(*types.TypeName)
+		if !ok {
+			continue
+		}
+
+		// Interfaces are fully described by their method set
+		mset := types.NewMethodSet(tn.Type())
+		if !types.IsInterface(tn.Type()) {
+			lines = append(lines, "\tunderlying "+types.TypeString(tn.Type().Underlying(), qualifier))
+			mset = types.NewMethodSet(types.NewPointer(tn.Type()))
+		}
+
+		var methods []string
+		for i := 0; i < mset.Len(); i++ {
+			m := mset.At(i).Obj()
+			if m.Exported() {
+				methods = append(methods, fmt.Sprintf("\tmethod %s%s", m.Name(),
+					strings.TrimPrefix(types.TypeString(m.Type(), qualifier), "func")))
+			}
+		}
+		sort.Strings(methods)
+		lines = append(lines, methods...)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
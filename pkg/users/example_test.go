@@ -0,0 +1,58 @@
+package users_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/romanitalian/carch-go/pkg/users"
+)
+
+func Example() {
+	ctx := context.Background()
+	svc := users.NewService(users.NewMemoryRepository(),
+		users.WithHooks(func(ctx context.Context, e users.Event) {
+			fmt.Println("hook:", e.Type)
+		}),
+	)
+
+	// Create
+	user := &users.User{Email: "ada@example.com", Name: "Ada"}
+	if err := svc.Create(ctx, user); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Read
+	found, err := svc.GetByID(ctx, user.ID)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("found:", found.Name, found.Email)
+
+	// Update
+	name := "Ada Lovelace"
+	updated, err := svc.Patch(ctx, user.ID, users.UserChanges{Name: &name})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println("updated:", updated.Name)
+
+	// Delete
+	if err := svc.Delete(ctx, user.ID); err != nil {
+		fmt.Println(err)
+		return
+	}
+	_, err = svc.GetByID(ctx, user.ID)
+	fmt.Println("deleted:", errors.Is(err, users.ErrUserNotFound))
+
+	// Output:
+	// hook: user.created
+	// found: Ada ada@example.com
+	// hook: user.updated
+	// updated: Ada Lovelace
+	// hook: user.deleted
+	// deleted: true
+}
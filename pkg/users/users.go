@@ -0,0 +1,219 @@
+// Package users embeds the user service in other programs.
+//
+// It is the stable surface of the service: the domain types, the service
+// interface and the constructors of the repositories and of the service.
+// Everything else in the module is internal and may change at any time.
+// The exported surface is pinned by testdata/api.golden, see api_test.go.
+package users
+
+import (
+	"database/sql"
+	"io"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+
+	"github.com/romanitalian/carch-go/internal/domain"
+	"github.com/romanitalian/carch-go/internal/pkg/clock"
+	"github.com/romanitalian/carch-go/internal/pkg/generate"
+	"github.com/romanitalian/carch-go/internal/pkg/idgen"
+	"github.com/romanitalian/carch-go/internal/pkg/logger"
+	"github.com/romanitalian/carch-go/internal/repository"
+	"github.com/romanitalian/carch-go/internal/service"
+)
+
+// Domain types
+type (
+	User            = domain.User
+	UserChanges     = domain.UserChanges
+	UserListOptions = domain.UserListOptions
+	UserFilter      = domain.UserFilter
+	UserLookup      = domain.UserLookup
+	Tombstone       = domain.Tombstone
+	EmailChange     = domain.EmailChange
+	OutboxEvent     = domain.OutboxEvent
+	PageRequest     = domain.PageRequest
+	UserPage        = domain.UserPage
+)
+
+// Fields a user list can be sorted by
+const (
+	SortCreatedAt = domain.UserSortCreatedAt
+	SortName      = domain.UserSortName
+)
+
+// Directions a user list can be sorted in
+const (
+	SortAsc  = domain.SortAsc
+	SortDesc = domain.SortDesc
+)
+
+// MaxLookupIDs is the largest number of distinct IDs accepted by a single GetByIDs call
+const MaxLookupIDs = service.MaxLookupIDs
+
+// Errors returned by the service, compare them with errors.Is
+var (
+	ErrUserNotFound               = domain.ErrUserNotFound
+	ErrInvalidInput               = domain.ErrInvalidInput
+	ErrEmailTaken                 = domain.ErrEmailTaken
+	ErrTooManyIDs                 = domain.ErrTooManyIDs
+	ErrConflict                   = domain.ErrConflict
+	ErrEmailChangeNotFound        = domain.ErrEmailChangeNotFound
+	ErrEmailChangeExpired         = domain.ErrEmailChangeExpired
+	ErrEmailChangeRequiresConfirm = domain.ErrEmailChangeRequiresConfirm
+)
+
+// ParseSort parses a sort parameter of the form field[:locale], e.g. "name:de-DE"
+func ParseSort(raw string) (UserListOptions, error) {
+	return domain.ParseUserSort(raw)
+}
+
+// ParseFilter parses a filter of comma-separated field:value terms,
+// e.g. "email_domain:example.com,name_prefix:Ad"
+func ParseFilter(raw string) (UserFilter, error) {
+	return domain.ParseUserFilter(raw)
+}
+
+// UserServiceInterface is the user service
+type UserServiceInterface = service.UserServiceInterface
+
+// Repository stores users
+type Repository = domain.UserRepository
+
+// Clock tells the time records are stamped with
+type Clock = clock.Clock
+
+// IDGenerator generates the IDs of new records
+type IDGenerator = idgen.Generator
+
+// PasswordHasher turns a plain password into the hash that is stored
+type PasswordHasher = service.PasswordHasher
+
+// Event describes a change the service made to a user
+type Event = service.UserEvent
+
+// Hook is called after a change to a user has been stored
+type Hook = service.UserHook
+
+// Types of the events passed to hooks
+const (
+	EventCreated = service.UserCreated
+	EventUpdated = service.UserUpdated
+	EventDeleted = service.UserDeleted
+)
+
+// RepositoryOption configures a repository
+type RepositoryOption func(*repositoryOptions)
+
+type repositoryOptions struct {
+	gen []generate.Option
+}
+
+// WithRepositoryClock stamps stored users with the time told by c
+func WithRepositoryClock(c Clock) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.gen = append(o.gen, generate.WithClock(c))
+	}
+}
+
+// WithIDGenerator identifies new users with IDs from ids
+func WithIDGenerator(ids IDGenerator) RepositoryOption {
+	return func(o *repositoryOptions) {
+		o.gen = append(o.gen, generate.WithIDGenerator(ids))
+	}
+}
+
+func newRepositoryOptions(opts []RepositoryOption) repositoryOptions {
+	var o repositoryOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// NewPostgresRepository stores users in a PostgreSQL database migrated with
+// the migrations of this module
+func NewPostgresRepository(db *sql.DB, opts ...RepositoryOption) Repository {
+	o := newRepositoryOptions(opts)
+	return repository.NewUserRepository(sqlx.NewDb(db, "postgres"), repository.WithUserGenerators(o.gen...))
+}
+
+// NewMemoryRepository stores users in memory. It suits tests and embedding
+// programs without a database; locale-aware name ordering is done by the service.
+func NewMemoryRepository(opts ...RepositoryOption) Repository {
+	o := newRepositoryOptions(opts)
+	return repository.NewMemoryUserRepository(o.gen...)
+}
+
+// Option configures the service
+type Option func(*options)
+
+type options struct {
+	user   []service.UserOption
+	gen    []generate.Option
+	logOut io.Writer
+}
+
+// WithHasher hashes passwords of new users before they are stored
+func WithHasher(h PasswordHasher) Option {
+	return func(o *options) {
+		o.user = append(o.user, service.WithPasswordHasher(h))
+	}
+}
+
+// WithClock tells the service the time, e.g. to expire email change requests
+func WithClock(c Clock) Option {
+	return func(o *options) {
+		o.gen = append(o.gen, generate.WithClock(c))
+	}
+}
+
+// WithHooks calls hooks, in order, after every stored change to a user
+func WithHooks(hooks ...Hook) Option {
+	return func(o *options) {
+		o.user = append(o.user, service.WithUserHooks(hooks...))
+	}
+}
+
+// WithEmailChangeConfirmation makes email changes go through the
+// confirmation flow only
+func WithEmailChangeConfirmation(ttl time.Duration) Option {
+	return func(o *options) {
+		o.user = append(o.user, service.WithEmailChangeConfirmation(ttl))
+	}
+}
+
+// WithDefaultCollation sets the locale names are ordered by when a list
+// sorted by name does not name one
+func WithDefaultCollation(locale string) Option {
+	return func(o *options) {
+		o.user = append(o.user, service.WithDefaultCollation(locale))
+	}
+}
+
+// WithMaxPageLimit caps the number of users a single List call returns,
+// 100 by default
+func WithMaxPageLimit(limit int) Option {
+	return func(o *options) {
+		o.user = append(o.user, service.WithMaxPageLimit(limit))
+	}
+}
+
+// WithLogOutput writes the service log, JSON lines, to w. The log is
+// discarded by default.
+func WithLogOutput(w io.Writer) Option {
+	return func(o *options) {
+		o.logOut = w
+	}
+}
+
+// NewService creates the user service on top of repo
+func NewService(repo Repository, opts ...Option) UserServiceInterface {
+	o := options{logOut: io.Discard}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	userOpts := append([]service.UserOption{service.WithUserGenerators(o.gen...)}, o.user...)
+	return service.NewUserService(repo, logger.New(logger.WithOutput(o.logOut)), userOpts...)
+}
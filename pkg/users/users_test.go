@@ -0,0 +1,117 @@
+package users_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/romanitalian/carch-go/pkg/users"
+)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+type reverseHasher struct{}
+
+func (reverseHasher) Hash(password string) (string, error) {
+	r := []rune(password)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r), nil
+}
+
+func TestService_CreateHashesPassword(t *testing.T) {
+	// Arrange
+	svc := users.NewService(users.NewMemoryRepository(), users.WithHasher(reverseHasher{}))
+	user := &users.User{Email: "ada@example.com", Password: "secret"}
+
+	// Act
+	err := svc.Create(context.Background(), user)
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, "terces", user.Password)
+}
+
+func TestMemoryRepository_StampsWithOptions(t *testing.T) {
+	// Arrange
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	repo := users.NewMemoryRepository(users.WithRepositoryClock(fixedClock(now)))
+	svc := users.NewService(repo)
+	ctx := context.Background()
+	user := &users.User{Email: "ada@example.com"}
+	require.NoError(t, svc.Create(ctx, user))
+
+	// Act
+	require.NoError(t, svc.Delete(ctx, user.ID))
+	tombstones, err := svc.ListTombstones(ctx, now.Add(-time.Hour))
+
+	// Assert
+	require.NoError(t, err)
+	assert.Equal(t, now, user.CreatedAt)
+	require.Len(t, tombstones, 1)
+	assert.Equal(t, user.ID, tombstones[0].ID)
+	assert.Equal(t, now, tombstones[0].DeletedAt)
+}
+
+func TestMemoryRepository_RejectsTakenEmail(t *testing.T) {
+	// Arrange
+	svc := users.NewService(users.NewMemoryRepository())
+	ctx := context.Background()
+	require.NoError(t, svc.Create(ctx, &users.User{Email: "ada@example.com"}))
+
+	// Act
+	err := svc.Create(ctx, &users.User{Email: "ada@example.com"})
+
+	// Assert
+	assert.ErrorIs(t, err, users.ErrEmailTaken)
+}
+
+func TestService_ListSortsByLocaleInMemory(t *testing.T) {
+	// Arrange
+	svc := users.NewService(users.NewMemoryRepository())
+	ctx := context.Background()
+	for _, name := range []string{"Zoe", "Ärger", "Anna"} {
+		require.NoError(t, svc.Create(ctx, &users.User{Email: name + "@example.com", Name: name}))
+	}
+	opts, err := users.ParseSort("name:de-DE")
+	require.NoError(t, err)
+
+	// Act
+	list, err := svc.List(ctx, opts)
+
+	// Assert
+	require.NoError(t, err)
+	var names []string
+	for _, u := range list.Users {
+		names = append(names, u.Name)
+	}
+	assert.Equal(t, []string{"Anna", "Ärger", "Zoe"}, names)
+}
+
+func TestService_ListFiltersInMemory(t *testing.T) {
+	// Arrange
+	svc := users.NewService(users.NewMemoryRepository())
+	ctx := context.Background()
+	for _, email := range []string{"ada@example.com", "alan@example.org", "grace@EXAMPLE.com"} {
+		require.NoError(t, svc.Create(ctx, &users.User{Email: email}))
+	}
+	filter, err := users.ParseFilter("email_domain:example.com")
+	require.NoError(t, err)
+
+	// Act
+	list, err := svc.List(ctx, users.UserListOptions{Filter: filter})
+
+	// Assert
+	require.NoError(t, err)
+	var emails []string
+	for _, u := range list.Users {
+		emails = append(emails, u.Email)
+	}
+	assert.ElementsMatch(t, []string{"ada@example.com", "grace@EXAMPLE.com"}, emails)
+}